@@ -0,0 +1,496 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// package analytics records the queries a farmer server answers in a small
+// local SQLite database, and reports on who and what is using the server the
+// most, and which kinds of query are slowest.
+package analytics
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+const (
+	// DefaultRetention is how long records are kept if Open() isn't given a
+	// retention.
+	DefaultRetention = 90 * 24 * time.Hour
+
+	// recordBuffer is how many records can be waiting to be written before
+	// further records are dropped.
+	recordBuffer = 10000
+
+	// batchSize is the most records we write in one transaction.
+	batchSize = 1000
+
+	pruneInterval = time.Hour
+	busyTimeoutMS = 60000
+	dirPerms      = 0755
+	hoursPerDay   = 24
+	shapeFieldSep = ","
+
+	schema = `
+CREATE TABLE IF NOT EXISTS queries (
+	time INTEGER NOT NULL,
+	query_key TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	identity TEXT NOT NULL,
+	bom TEXT NOT NULL,
+	user_name TEXT NOT NULL,
+	shape TEXT NOT NULL,
+	span_days REAL NOT NULL,
+	duration_ms REAL NOT NULL,
+	bytes INTEGER NOT NULL,
+	cache TEXT NOT NULL,
+	status INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS queries_time ON queries (time);
+`
+
+	insertRecord = `INSERT INTO queries (time, query_key, kind, identity, bom, user_name, shape,
+	span_days, duration_ms, bytes, cache, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	topOf = `SELECT %[1]s, COUNT(*), SUM(duration_ms), SUM(bytes) FROM queries
+WHERE time >= ? AND time < ? AND %[1]s != ''
+GROUP BY %[1]s ORDER BY COUNT(*) DESC, %[1]s LIMIT ?`
+
+	slowestShapes = `SELECT shape, COUNT(*), AVG(duration_ms), MAX(duration_ms), AVG(bytes) FROM queries
+WHERE time >= ? AND time < ?
+GROUP BY shape ORDER BY AVG(duration_ms) DESC, shape LIMIT ?`
+
+	totals = `SELECT COUNT(*), COALESCE(SUM(duration_ms), 0), COALESCE(SUM(bytes), 0) FROM queries
+WHERE time >= ? AND time < ?`
+)
+
+// Record describes one query answered by a server. For requests with several
+// queries (eg. multi_scroll), each has the Duration and Bytes of the whole
+// request.
+type Record struct {
+	Time time.Time
+
+	// Kind is the sort of request, eg. "scroll", "search", "count" or
+	// "get_usernames".
+	Kind string
+
+	// Identity is the client that made the request, if known.
+	Identity string
+
+	Query    *es.Query
+	Duration time.Duration
+
+	// Bytes is the size of the response.
+	Bytes  int64
+	Status int
+}
+
+// Store records queries in an SQLite database file.
+type Store struct {
+	db        *sql.DB
+	retention time.Duration
+	records   chan entry
+	done      chan struct{}
+	dropped   atomic.Int64
+	mu        sync.RWMutex
+	closed    bool
+	lastPrune time.Time
+}
+
+// Open returns a Store that records to (creating if necessary) the SQLite
+// database at the given path, keeping records for the given retention period
+// (DefaultRetention if 0). Close() it when done.
+func Open(path string, retention time.Duration) (*Store, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), dirPerms); err != nil {
+		return nil, err
+	}
+
+	sdb, err := sql.Open("sqlite",
+		fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", path, busyTimeoutMS))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = sdb.Exec(schema); err != nil {
+		sdb.Close()
+
+		return nil, err
+	}
+
+	s := &Store{
+		db:        sdb,
+		retention: retention,
+		records:   make(chan entry, recordBuffer),
+		done:      make(chan struct{}),
+	}
+
+	go s.writeRecords()
+
+	return s, nil
+}
+
+// RecordQuery queues the given Record to be written in the background. If the
+// queue is full, the Record is dropped instead of making the caller wait; see
+// Dropped(). Records are also dropped after Close().
+func (s *Store) RecordQuery(rec Record) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		s.dropped.Add(1)
+
+		return
+	}
+
+	select {
+	case s.records <- newEntry(rec):
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of records RecordQuery() couldn't queue.
+func (s *Store) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// writeRecords writes queued records in batches until Close() is called,
+// pruning old records every pruneInterval.
+func (s *Store) writeRecords() {
+	defer close(s.done)
+
+	batch := make([]entry, 0, batchSize)
+
+	for e := range s.records {
+		batch = append(batch[:0], e)
+
+	fill:
+		for len(batch) < batchSize {
+			select {
+			case e, ok := <-s.records:
+				if !ok {
+					break fill
+				}
+
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+
+		if err := s.write(batch); err != nil {
+			slog.Error("writing query analytics failed", "err", err)
+		}
+
+		s.pruneIfDue(time.Now())
+	}
+}
+
+// write inserts the given entries in a single transaction.
+func (s *Store) write(batch []entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(insertRecord)
+	if err != nil {
+		tx.Rollback() //nolint:errcheck
+
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, e := range batch {
+		if _, err = stmt.Exec(e.time.UnixMilli(), e.key, e.kind, e.identity, e.bom, e.user,
+			e.shape, e.spanDays, float64(e.duration)/float64(time.Millisecond), e.bytes,
+			e.cache, e.status); err != nil {
+			tx.Rollback() //nolint:errcheck
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pruneIfDue deletes records older than our retention, if we haven't done so
+// in the last pruneInterval before now.
+func (s *Store) pruneIfDue(now time.Time) {
+	if now.Sub(s.lastPrune) < pruneInterval {
+		return
+	}
+
+	s.lastPrune = now
+
+	if _, err := s.db.Exec("DELETE FROM queries WHERE time < ?", now.Add(-s.retention).UnixMilli()); err != nil {
+		slog.Error("pruning query analytics failed", "err", err)
+	}
+}
+
+// entry holds the details of a Record that we store. They are worked out when
+// the Record is queued, so that we don't touch its Query in the background.
+type entry struct {
+	time     time.Time
+	key      string
+	kind     string
+	identity string
+	bom      string
+	user     string
+	shape    string
+	spanDays float64
+	duration time.Duration
+	bytes    int64
+	cache    string
+	status   int
+}
+
+func newEntry(rec Record) entry {
+	e := entry{
+		time:     rec.Time,
+		kind:     rec.Kind,
+		identity: rec.Identity,
+		shape:    rec.Kind,
+		duration: rec.Duration,
+		bytes:    rec.Bytes,
+		status:   rec.Status,
+	}
+
+	if rec.Query == nil {
+		return e
+	}
+
+	filters := rec.Query.Filters()
+
+	e.key = rec.Query.Key()
+	e.bom = filters["BOM"]
+	e.user = filters["USER_NAME"]
+	e.spanDays = querySpanDays(rec.Query)
+	e.shape = queryShape(rec.Kind, filters, e.spanDays)
+	e.cache = rec.Query.CacheStatus()
+
+	return e
+}
+
+// querySpanDays returns the number of days the query's timestamp range covers,
+// or 0 if it doesn't have one.
+func querySpanDays(query *es.Query) float64 {
+	tr, err := query.TimeRange()
+	if err != nil {
+		return 0
+	}
+
+	start, end := tr.GTE, tr.LT
+
+	if start.IsZero() {
+		start = tr.GT
+	}
+
+	if end.IsZero() {
+		end = tr.LTE
+	}
+
+	return end.Sub(start).Hours() / hoursPerDay
+}
+
+// queryShape describes a query without its filter values, eg.
+// "scroll BOM,USER_NAME 7d", so that similar queries can be grouped together.
+// Spans are rounded up to whole days.
+func queryShape(kind string, filters map[string]string, spanDays float64) string {
+	fields := make([]string, 0, len(filters))
+
+	for field := range filters {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	shape := kind
+
+	if len(fields) > 0 {
+		shape += " " + strings.Join(fields, shapeFieldSep)
+	}
+
+	if spanDays > 0 {
+		days := int(spanDays)
+		if float64(days) < spanDays {
+			days++
+		}
+
+		shape += fmt.Sprintf(" %dd", days)
+	}
+
+	return shape
+}
+
+// Close writes any queued records and closes the database.
+func (s *Store) Close() error {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	s.closed = true
+	close(s.records)
+	s.mu.Unlock()
+
+	<-s.done
+
+	return s.db.Close()
+}
+
+// Usage is the number of queries made by or about something, and their total
+// duration and response size.
+type Usage struct {
+	Name       string  `json:"name"`
+	Queries    int64   `json:"queries"`
+	TotalMS    float64 `json:"total_ms"`
+	TotalBytes int64   `json:"total_bytes"`
+}
+
+// Shape is the number of queries of a queryShape(), and how long they took.
+type Shape struct {
+	Shape     string  `json:"shape"`
+	Queries   int64   `json:"queries"`
+	MeanMS    float64 `json:"mean_ms"`
+	MaxMS     float64 `json:"max_ms"`
+	MeanBytes float64 `json:"mean_bytes"`
+}
+
+// Report summarises the queries recorded over a period.
+type Report struct {
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Queries    int64     `json:"queries"`
+	TotalMS    float64   `json:"total_ms"`
+	TotalBytes int64     `json:"total_bytes"`
+
+	// TopUsers are the client identities that made the most queries.
+	TopUsers []Usage `json:"top_users"`
+
+	// TopBOMs are the BOMs that were queried the most.
+	TopBOMs []Usage `json:"top_boms"`
+
+	// SlowestShapes are the kinds of query that took longest on average.
+	SlowestShapes []Shape `json:"slowest_shapes"`
+}
+
+// Report returns a Report of the queries recorded from (inclusive) to
+// (exclusive) the given times, with at most top entries in each list.
+func (s *Store) Report(from, to time.Time, top int) (*Report, error) {
+	r := &Report{From: from, To: to}
+	fromMS, toMS := from.UnixMilli(), to.UnixMilli()
+
+	err := s.db.QueryRow(totals, fromMS, toMS).Scan(&r.Queries, &r.TotalMS, &r.TotalBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.TopUsers, err = s.top("identity", fromMS, toMS, top); err != nil {
+		return nil, err
+	}
+
+	if r.TopBOMs, err = s.top("bom", fromMS, toMS, top); err != nil {
+		return nil, err
+	}
+
+	r.SlowestShapes, err = s.slowestShapes(fromMS, toMS, top)
+
+	return r, err
+}
+
+// top returns the Usage of the given column's most common non-blank values.
+func (s *Store) top(column string, fromMS, toMS int64, top int) ([]Usage, error) {
+	rows, err := s.db.Query(fmt.Sprintf(topOf, column), fromMS, toMS, top)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var usages []Usage
+
+	for rows.Next() {
+		var u Usage
+
+		if err = rows.Scan(&u.Name, &u.Queries, &u.TotalMS, &u.TotalBytes); err != nil {
+			return nil, err
+		}
+
+		usages = append(usages, u)
+	}
+
+	return usages, rows.Err()
+}
+
+func (s *Store) slowestShapes(fromMS, toMS int64, top int) ([]Shape, error) {
+	rows, err := s.db.Query(slowestShapes, fromMS, toMS, top)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var shapes []Shape
+
+	for rows.Next() {
+		var sh Shape
+
+		if err = rows.Scan(&sh.Shape, &sh.Queries, &sh.MeanMS, &sh.MaxMS, &sh.MeanBytes); err != nil {
+			return nil, err
+		}
+
+		shapes = append(shapes, sh)
+	}
+
+	return shapes, rows.Err()
+}
+
+// WriteJSON writes the report to w as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
@@ -0,0 +1,134 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestAnalytics(t *testing.T) {
+	newQuery := func(filters string) *es.Query {
+		query := &es.Query{}
+		So(json.Unmarshal([]byte(`{"query":{"bool":{"filter":[`+filters+`,`+
+			`{"range":{"timestamp":{"gte":"2024-06-01T00:00:00Z","lt":"2024-06-08T00:00:00Z"}}}]}}}`), query), ShouldBeNil)
+
+		return query
+	}
+
+	Convey("queryShape() describes queries without their filter values", t, func() {
+		So(queryShape("scroll", map[string]string{"USER_NAME": "u", "BOM": "b"}, 7), ShouldEqual, "scroll BOM,USER_NAME 7d")
+		So(queryShape("search", map[string]string{"BOM": "b"}, 0.5), ShouldEqual, "search BOM 1d")
+		So(queryShape("count", nil, 0), ShouldEqual, "count")
+	})
+
+	Convey("Given a Store", t, func() {
+		path := filepath.Join(t.TempDir(), "sub", "analytics.sqlite")
+
+		store, err := Open(path, 0)
+		So(err, ShouldBeNil)
+		So(store.retention, ShouldEqual, DefaultRetention)
+
+		now := time.Now()
+
+		hg := newQuery(`{"match_phrase":{"BOM":"Human Genetics"}}`)
+		hgUser := newQuery(`{"match_phrase":{"BOM":"Human Genetics"}},{"match_phrase":{"USER_NAME":"u1"}}`)
+		cg := newQuery(`{"match_phrase":{"BOM":"Cancer Genetics"}}`)
+
+		for _, rec := range []Record{
+			{Time: now, Kind: "scroll", Identity: "alice", Query: hg, Duration: 10 * time.Millisecond, Bytes: 100},
+			{Time: now, Kind: "scroll", Identity: "alice", Query: cg, Duration: 20 * time.Millisecond, Bytes: 200},
+			{Time: now, Kind: "scroll", Identity: "bob", Query: hgUser, Duration: 90 * time.Millisecond, Bytes: 50},
+			{Time: now, Kind: "count", Query: hg, Duration: time.Millisecond, Bytes: 10},
+			{Time: now.Add(-48 * time.Hour), Kind: "scroll", Identity: "bob", Query: hg, Duration: time.Second},
+		} {
+			store.RecordQuery(rec)
+		}
+
+		So(store.Close(), ShouldBeNil)
+		So(store.Close(), ShouldBeNil)
+
+		store.RecordQuery(Record{Time: now, Kind: "scroll", Query: hg})
+		So(store.Dropped(), ShouldEqual, 1)
+
+		store, err = Open(path, 0)
+		So(err, ShouldBeNil)
+
+		defer store.Close()
+
+		Convey("you can get a Report of a period", func() {
+			report, errr := store.Report(now.Add(-time.Hour), now.Add(time.Hour), 10)
+			So(errr, ShouldBeNil)
+			So(report.Queries, ShouldEqual, 4)
+			So(report.TotalMS, ShouldAlmostEqual, 121, 0.001)
+			So(report.TotalBytes, ShouldEqual, 360)
+
+			So(report.TopUsers, ShouldResemble, []Usage{
+				{Name: "alice", Queries: 2, TotalMS: 30, TotalBytes: 300},
+				{Name: "bob", Queries: 1, TotalMS: 90, TotalBytes: 50},
+			})
+
+			So(len(report.TopBOMs), ShouldEqual, 2)
+			So(report.TopBOMs[0].Name, ShouldEqual, "Human Genetics")
+			So(report.TopBOMs[0].Queries, ShouldEqual, 3)
+
+			So(len(report.SlowestShapes), ShouldEqual, 3)
+			So(report.SlowestShapes[0], ShouldResemble, Shape{
+				Shape: "scroll BOM,USER_NAME 7d", Queries: 1, MeanMS: 90, MaxMS: 90, MeanBytes: 50,
+			})
+			So(report.SlowestShapes[1].Shape, ShouldEqual, "scroll BOM 7d")
+			So(report.SlowestShapes[1].MeanMS, ShouldEqual, 15)
+			So(report.SlowestShapes[2].Shape, ShouldEqual, "count BOM 7d")
+
+			buf := &bytes.Buffer{}
+			So(report.WriteJSON(buf), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, `"slowest_shapes"`)
+
+			report, errr = store.Report(now.Add(-72*time.Hour), now.Add(time.Hour), 1)
+			So(errr, ShouldBeNil)
+			So(report.Queries, ShouldEqual, 5)
+			So(len(report.TopUsers), ShouldEqual, 1)
+			So(report.SlowestShapes[0].MaxMS, ShouldEqual, 1000)
+		})
+
+		Convey("old records are pruned", func() {
+			store.retention = 24 * time.Hour
+			store.pruneIfDue(now)
+
+			report, errr := store.Report(now.Add(-72*time.Hour), now.Add(time.Hour), 10)
+			So(errr, ShouldBeNil)
+			So(report.Queries, ShouldEqual, 4)
+			So(strings.Join([]string{report.TopUsers[0].Name, report.TopUsers[1].Name}, ","), ShouldEqual, "alice,bob")
+		})
+	})
+}
@@ -26,7 +26,9 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"log/slog"
 	"time"
@@ -39,7 +41,11 @@ import (
 const (
 	cacheKeyPrefixResults = "r."
 	cacheKeyPrefixStrings = "s."
+	cacheKeyPrefixCount   = "c."
+	cacheKeyPrefixCounts  = "n."
 	hoursInDay            = 24
+
+	WarnLocalOnly = "elasticsearch is unavailable; this answer is from local data only and may be incomplete"
 )
 
 // Searcher types have a Search function for querying something like elastic
@@ -51,53 +57,158 @@ type Searcher interface {
 // Scroller types have a Scroll function for querying something like elastic
 // search, automatically getting all hits in a single scroll call. They have a
 // corresponding Done() function which takes the Scroll result to release
-// any resources associated with doing that Scroll. They also have a Usernames
-// function that returns just the usernames from the hits.
+// any resources associated with doing that Scroll. MultiScroll does several
+// Scrolls at once, returning Results that share a single PoolKey. They also
+// have a DistinctValues function that returns just the unique values of a field
+// (eg. USER_NAME) from the hits, a DistinctCounts function that also returns
+// how many hits have each value, and a Count function that returns just the
+// number of hits.
 type Scroller interface {
 	Scroll(query *es.Query) (*es.Result, error)
+	MultiScroll(queries []*es.Query) ([]*es.Result, error)
 	Done(key int) bool
-	Usernames(query *es.Query) ([]string, error)
+	DistinctValues(query *es.Query, field string) ([]string, error)
+	DistinctCounts(query *es.Query, field string) (map[string]int, error)
+	Count(query *es.Query) (int, error)
+}
+
+// Aggregator types have an Aggregate function that can answer some aggregation
+// queries without elastic search, returning false for ones they can't. If our
+// Scroller is also an Aggregator, we try it before our Searcher.
+type Aggregator interface {
+	Aggregate(query *es.Query) (*es.Result, bool, error)
+}
+
+// LocalAggregator types have an AggregateLocalOnly function that gives a
+// possibly incomplete answer to aggregation queries using only local data. If
+// our Scroller is a LocalAggregator, we use it when our Searcher is
+// unavailable, and warn that the answer is local-only.
+type LocalAggregator interface {
+	AggregateLocalOnly(query *es.Query) (*es.Result, bool, error)
+}
+
+// EachScroller types have a ScrollEach function that calls back with each hit
+// of a scroll query as it is read, instead of returning them all in a Result.
+// If our Scroller is an EachScroller, our ScrollEach() uses it.
+type EachScroller interface {
+	ScrollEach(query *es.Query, cb func(*es.Hit) error) error
 }
 
 type querier func(query *es.Query) ([]byte, int, error)
 
+// Sizes are the maximum number of results held in each of a CachedQuerier's
+// separate LRU caches, so that eg. one giant scroll can't evict dozens of small
+// aggregation results.
+type Sizes struct {
+	// Search is for Search() (eg. aggregation) and Count() results.
+	Search int
+
+	// Scroll is for Scroll(), ScrollOrStream() and MultiScroll() results.
+	Scroll int
+
+	// Distinct is for DistinctValues() results, eg. usernames.
+	Distinct int
+}
+
+// largest returns the biggest of the sizes.
+func (s Sizes) largest() int {
+	return max(s.Search, s.Scroll, s.Distinct)
+}
+
 // CachedQuerier is an LRU cache wrapper around a Searcher and a Scroller that
-// stores and returns their Results as JSON.
+// stores and returns their Results as JSON, with a separate cache for each kind
+// of query (see Sizes). JSON of at least 1KB is stored and returned gzip
+// compressed, so that many more large results fit in memory and can be sent as
+// is to clients that accept gzip; see IsGzipped() and Plain().
 type CachedQuerier struct {
-	Searcher Searcher
-	Scroller Scroller
-	lru      *lru.Cache[string, []byte]
+	Searcher  Searcher
+	Scroller  Scroller
+	searches  *partition
+	scrolls   *partition
+	distincts *partition
+	gzipped   *lru.Cache[[sha256.Size]byte, []byte]
+	metrics   *Metrics
+
+	streamThreshold int
+	slow            slowQueries
+	revalidate      *revalidator
 }
 
 // New returns a CachedQuerier that takes a Searcher and a Scroller. It caches
-// cacheSize Search() and Scroll() queries, evicting the least recently used
-// query results once the cache is full. It stores and returns JSON encoding of
-// the Results.
+// cacheSize Search(), cacheSize Scroll() and cacheSize DistinctValues() query
+// results; see NewWithSizes().
 func New(searcher Searcher, scroller Scroller, cacheSize int) (*CachedQuerier, error) {
-	l, err := lru.New[string, []byte](cacheSize)
+	return NewWithSizes(searcher, scroller, Sizes{Search: cacheSize, Scroll: cacheSize, Distinct: cacheSize})
+}
+
+// NewWithSizes returns a CachedQuerier that takes a Searcher and a Scroller. It
+// caches up to the given number of results of each kind of query, evicting the
+// least recently used results of a kind once its cache is full. It stores and
+// returns JSON encoding of the Results, compressed if large. The gzip
+// compressed forms of up to sizes.largest() other results are also cached; see
+// Gzip().
+func NewWithSizes(searcher Searcher, scroller Scroller, sizes Sizes) (*CachedQuerier, error) {
+	searches, err := newPartition(PartitionSearch, sizes.Search)
+	if err != nil {
+		return nil, err
+	}
+
+	scrolls, err := newPartition(PartitionScroll, sizes.Scroll)
+	if err != nil {
+		return nil, err
+	}
+
+	distincts, err := newPartition(PartitionDistinct, sizes.Distinct)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := lru.New[[sha256.Size]byte, []byte](sizes.largest())
+	if err != nil {
+		return nil, err
+	}
+
+	rv, err := newRevalidator(sizes.Search)
 	if err != nil {
 		return nil, err
 	}
 
 	return &CachedQuerier{
-		Searcher: searcher,
-		Scroller: scroller,
-		lru:      l,
+		Searcher:   searcher,
+		Scroller:   scroller,
+		searches:   searches,
+		scrolls:    scrolls,
+		distincts:  distincts,
+		gzipped:    gz,
+		metrics:    NewMetrics(),
+		revalidate: rv,
 	}, nil
 }
 
+// partitions returns our caches.
+func (c *CachedQuerier) partitions() []*partition {
+	return []*partition{c.searches, c.scrolls, c.distincts}
+}
+
 // Search returns any cached data for the given query, otherwise returns the
-// JSON result of calling our Searcher.Search().
+// JSON result of calling our Searcher.Search(). Cached aggregation results may
+// be refreshed in the background; see SetRevalidateAge().
 func (c *CachedQuerier) Search(query *es.Query) ([]byte, error) {
-	jb, _, err := c.wrapWithCache(cacheKeyPrefixResults, query, c.searchQuerier)
+	jb, _, err := c.wrapWithCache(c.searches, cacheKeyPrefixResults, query, c.searchQuerier)
+	if err != nil {
+		return nil, err
+	}
 
-	return jb, err
+	c.revalidateIfStale(query)
+
+	return jb, nil
 }
 
-func (c *CachedQuerier) wrapWithCache(keyPrefix string, query *es.Query, querier querier) ([]byte, int, error) {
+func (c *CachedQuerier) wrapWithCache(p *partition, keyPrefix string, query *es.Query,
+	querier querier) ([]byte, int, error) {
 	cacheKey := keyPrefix + query.Key()
 
-	jsonBytes, ok := c.lru.Get(cacheKey)
+	jsonBytes, ok := c.get(p, cacheKey, query)
 	if ok {
 		return jsonBytes, -1, nil
 	}
@@ -107,7 +218,11 @@ func (c *CachedQuerier) wrapWithCache(keyPrefix string, query *es.Query, querier
 		return nil, key, err
 	}
 
-	c.lru.Add(cacheKey, jsonBytes)
+	if len(query.Warnings()) > 0 {
+		return jsonBytes, key, nil
+	}
+
+	c.add(p, cacheKey, query, jsonBytes)
 
 	return jsonBytes, key, nil
 }
@@ -115,7 +230,7 @@ func (c *CachedQuerier) wrapWithCache(keyPrefix string, query *es.Query, querier
 func (c *CachedQuerier) searchQuerier(query *es.Query) ([]byte, int, error) {
 	t := time.Now()
 
-	result, err := c.Searcher.Search(query)
+	result, err := c.search(query, t)
 	if err != nil {
 		return nil, -1, err
 	}
@@ -125,14 +240,64 @@ func (c *CachedQuerier) searchQuerier(query *es.Query) ([]byte, int, error) {
 		items = len(result.Aggregations.Stats.Buckets)
 	}
 
-	logQuery(t, items, query, "search")
+	c.logQuery(t, items, query, "search")
 
 	jb, err := resultToJSON(result, query)
 
 	return jb, -1, err
 }
 
-func logQuery(start time.Time, items int, query *es.Query, kind string) {
+// search answers the query using our Scroller if it is an Aggregator that can
+// answer it, otherwise our Searcher. If our Searcher is unavailable, falls back
+// to a local-only answer; see LocalAggregator. Results we answer locally have
+// their Took set to the time since start.
+func (c *CachedQuerier) search(query *es.Query, start time.Time) (*es.Result, error) {
+	if agg, ok := c.Scroller.(Aggregator); ok && query.Aggs != nil {
+		result, answered, err := agg.Aggregate(query)
+		if err != nil {
+			return nil, err
+		}
+
+		if answered {
+			result.SetLocalStats(query, start)
+
+			return result, nil
+		}
+	}
+
+	result, err := c.Searcher.Search(query)
+	if err == nil || !es.IsUnavailable(err) {
+		return result, err
+	}
+
+	return c.searchLocalOnly(query, start, err)
+}
+
+// searchLocalOnly answers the query using our Scroller if it is a
+// LocalAggregator that can answer it, adding a WarnLocalOnly warning to the
+// query. Otherwise returns the given error from our Searcher.
+func (c *CachedQuerier) searchLocalOnly(query *es.Query, start time.Time, searchErr error) (*es.Result, error) {
+	agg, ok := c.Scroller.(LocalAggregator)
+	if !ok || query.Aggs == nil {
+		return nil, searchErr
+	}
+
+	result, answered, err := agg.AggregateLocalOnly(query)
+	if err != nil || !answered {
+		return nil, searchErr
+	}
+
+	query.AddWarning(WarnLocalOnly)
+	result.SetLocalStats(query, start)
+
+	return result, nil
+}
+
+// logQuery debug logs details of the given query, and also records it as a slow
+// query if it took at least our slow query threshold.
+func (c *CachedQuerier) logQuery(start time.Time, items int, query *es.Query, kind string) {
+	c.slow.check(time.Since(start), items, query, kind)
+
 	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
 		return
 	}
@@ -187,23 +352,32 @@ func queryToFilterAttrs(query *es.Query) []slog.Attr {
 	return attrs
 }
 
+// resultToJSON returns the JSON of the result, gzip compressed if large; see
+// entryWriter. The time this takes is the query's es.PhaseMarshal.
 func resultToJSON(result *es.Result, query *es.Query) ([]byte, error) {
 	t := time.Now()
-	jsonBytes, err := result.MarshalFields(query.DesiredFields())
+	e := &entryWriter{}
+
+	if err := result.MarshalFieldsTo(e, query.DesiredFields()); err != nil {
+		return nil, err
+	}
+
+	jsonBytes, err := e.Bytes()
 	if err != nil {
 		return nil, err
 	}
 
+	query.TimePhase(es.PhaseMarshal, t)
 	slog.Debug("json.Marshal of Result", "took", time.Since(t))
 
-	return jsonBytes, err
+	return jsonBytes, nil
 }
 
 // Scroll returns any cached data for the given query, otherwise returns the
 // JSON result of calling our Scroller.Scroll(), along with the key it returns
 // for clearing up resources with Done(key).
 func (c *CachedQuerier) Scroll(query *es.Query) ([]byte, int, error) {
-	return c.wrapWithCache(cacheKeyPrefixResults, query, c.scrollQuerier)
+	return c.wrapWithCache(c.scrolls, cacheKeyPrefixResults, query, c.scrollQuerier)
 }
 
 func (c *CachedQuerier) scrollQuerier(query *es.Query) ([]byte, int, error) {
@@ -214,47 +388,316 @@ func (c *CachedQuerier) scrollQuerier(query *es.Query) ([]byte, int, error) {
 		return nil, -1, err
 	}
 
-	logQuery(t, len(result.HitSet.Hits), query, "scroll")
+	result.SetLocalStats(query, t)
+	c.logQuery(t, len(result.HitSet.Hits), query, "scroll")
 
 	jb, err := resultToJSON(result, query)
 
 	return jb, result.PoolKey, err
 }
 
+// SetStreamThreshold makes ScrollOrStream() return uncached Results that have
+// at least the given number of hits, instead of their JSON. The default of 0
+// means ScrollOrStream() is the same as Scroll().
+func (c *CachedQuerier) SetStreamThreshold(hits int) {
+	c.streamThreshold = hits
+}
+
+// ScrollOrStream is like Scroll(), but if the result isn't cached and has at
+// least our stream threshold of hits (see SetStreamThreshold()), it returns the
+// Result instead of JSON, so that you can write it out with
+// Result.MarshalFieldsTo() without ever holding all of its JSON in memory. Such
+// large results are not cached.
+//
+// Either way, call Done() with the returned key once you're finished.
+func (c *CachedQuerier) ScrollOrStream(query *es.Query) ([]byte, *es.Result, int, error) {
+	if c.streamThreshold <= 0 {
+		jsonBytes, key, err := c.Scroll(query)
+
+		return jsonBytes, nil, key, err
+	}
+
+	cacheKey := cacheKeyPrefixResults + query.Key()
+
+	jsonBytes, ok := c.get(c.scrolls, cacheKey, query)
+
+	if ok {
+		return jsonBytes, nil, -1, nil
+	}
+
+	result, err := c.ScrollResult(query)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
+	if len(result.HitSet.Hits) >= c.streamThreshold {
+		if query.Refresh() {
+			c.scrolls.lru.Remove(cacheKey)
+		}
+
+		return nil, result, result.PoolKey, nil
+	}
+
+	jsonBytes, err = resultToJSON(result, query)
+	if err != nil {
+		return nil, nil, result.PoolKey, err
+	}
+
+	c.add(c.scrolls, cacheKey, query, jsonBytes)
+
+	return jsonBytes, nil, result.PoolKey, nil
+}
+
+// MultiScroll returns a JSON array of the Scroll() results of each of the given
+// queries, in the same order. Any results not already cached are retrieved
+// with a single call to our Scroller.MultiScroll(). The array is never gzip
+// compressed.
+func (c *CachedQuerier) MultiScroll(queries []*es.Query) ([]byte, error) {
+	jsons := make([][]byte, len(queries))
+
+	var uncachedIndexes []int
+
+	for i, query := range queries {
+		jsonBytes, ok := c.get(c.scrolls, cacheKeyPrefixResults+query.Key(), query)
+
+		if ok {
+			jsons[i] = jsonBytes
+
+			continue
+		}
+
+		uncachedIndexes = append(uncachedIndexes, i)
+	}
+
+	if len(uncachedIndexes) > 0 {
+		if err := c.multiScrollUncached(queries, uncachedIndexes, jsons); err != nil {
+			return nil, err
+		}
+	}
+
+	return jsonArray(jsons)
+}
+
+// multiScrollUncached does a MultiScroll of the queries with the given indexes,
+// storing their JSON results in the cache and in the corresponding indexes of
+// the given jsons.
+func (c *CachedQuerier) multiScrollUncached(queries []*es.Query, indexes []int, jsons [][]byte) error {
+	t := time.Now()
+	uncached := make([]*es.Query, len(indexes))
+
+	for i, queryIndex := range indexes {
+		uncached[i] = queries[queryIndex]
+	}
+
+	results, err := c.Scroller.MultiScroll(uncached)
+	if err != nil {
+		return err
+	}
+
+	defer c.Scroller.Done(results[0].PoolKey)
+
+	for i, result := range results {
+		result.SetLocalStats(uncached[i], t)
+		c.logQuery(t, len(result.HitSet.Hits), uncached[i], "multiscroll")
+
+		jsonBytes, err := resultToJSON(result, uncached[i])
+		if err != nil {
+			return err
+		}
+
+		c.add(c.scrolls, cacheKeyPrefixResults+uncached[i].Key(), uncached[i], jsonBytes)
+		jsons[indexes[i]] = jsonBytes
+	}
+
+	return nil
+}
+
+// jsonArray returns a JSON array of the given JSONs, decompressing any that
+// are gzipped.
+func jsonArray(jsons [][]byte) ([]byte, error) {
+	plains := make([][]byte, len(jsons))
+
+	for i, data := range jsons {
+		plain, err := Plain(data)
+		if err != nil {
+			return nil, err
+		}
+
+		plains[i] = plain
+	}
+
+	return append(append([]byte{'['}, bytes.Join(plains, []byte{','})...), ']'), nil
+}
+
+// ScrollResult returns the uncached Result of calling our Scroller.Scroll(),
+// for when you want to work with the hits themselves instead of JSON. You must
+// call Done(result.PoolKey) once you are finished with the Result.
+func (c *CachedQuerier) ScrollResult(query *es.Query) (*es.Result, error) {
+	t := time.Now()
+
+	result, err := c.Scroller.Scroll(query)
+	if err != nil {
+		return nil, err
+	}
+
+	result.SetLocalStats(query, t)
+	c.logQuery(t, len(result.HitSet.Hits), query, "scroll")
+
+	return result, nil
+}
+
+// ScrollEach calls the given callback with each hit of the given scroll query,
+// without caching. If our Scroller is an EachScroller, hits are passed on as it
+// reads them, so they're never all held in memory; otherwise they come from
+// ScrollResult(). The hit is only valid until the callback returns.
+func (c *CachedQuerier) ScrollEach(query *es.Query, cb func(*es.Hit) error) error {
+	each, ok := c.Scroller.(EachScroller)
+	if !ok {
+		return c.scrollResultEach(query, cb)
+	}
+
+	t := time.Now()
+	items := 0
+
+	err := each.ScrollEach(query, func(hit *es.Hit) error {
+		items++
+
+		return cb(hit)
+	})
+	if err == nil {
+		c.logQuery(t, items, query, "scroll")
+	}
+
+	return err
+}
+
+// scrollResultEach calls the given callback with each hit of our
+// ScrollResult() of the given query.
+func (c *CachedQuerier) scrollResultEach(query *es.Query, cb func(*es.Hit) error) error {
+	result, err := c.ScrollResult(query)
+	if err != nil {
+		return err
+	}
+
+	defer c.Done(result.PoolKey)
+
+	for i := range result.HitSet.Hits {
+		if err = cb(&result.HitSet.Hits[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Done calls our Scroller.Done().
 func (c *CachedQuerier) Done(key int) bool {
 	return c.Scroller.Done(key)
 }
 
+// Flush empties our caches, returning how many results were in them.
+func (c *CachedQuerier) Flush() int {
+	n := 0
+
+	for _, p := range c.partitions() {
+		n += p.lru.Len()
+		p.lru.Purge()
+	}
+	c.gzipped.Purge()
+	c.revalidate.cachedAt.Purge()
+
+	return n
+}
+
+// DistinctValues returns any cached slice for the given query and field,
+// otherwise returns the slice from calling our Scroller.DistinctValues().
+func (c *CachedQuerier) DistinctValues(query *es.Query, field string) ([]byte, error) {
+	jb, _, err := c.wrapWithCache(c.distincts, cacheKeyPrefixStrings+field+".", query,
+		func(query *es.Query) ([]byte, int, error) {
+			return c.distinctValuesQuerier(query, field)
+		})
+
+	return jb, err
+}
+
 // Usernames returns any cached slice for the given query, otherwise returns
-// the slice from calling our Scroller.Usernames().
+// the slice from calling our Scroller.DistinctValues() with USER_NAME.
 func (c *CachedQuerier) Usernames(query *es.Query) ([]byte, error) {
-	jb, _, err := c.wrapWithCache(cacheKeyPrefixStrings, query, c.usernameQuerier)
+	return c.DistinctValues(query, "USER_NAME")
+}
+
+func (c *CachedQuerier) distinctValuesQuerier(query *es.Query, field string) ([]byte, int, error) {
+	t := time.Now()
+
+	values, err := c.Scroller.DistinctValues(query, field)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	c.logQuery(t, len(values), query, "distinct "+field)
+
+	return stringsToJSON(values)
+}
+
+// DistinctCounts returns any cached map for the given query and field,
+// otherwise returns the map from calling our Scroller.DistinctCounts(), JSON
+// encoded as an object of value to number of hits.
+func (c *CachedQuerier) DistinctCounts(query *es.Query, field string) ([]byte, error) {
+	jb, _, err := c.wrapWithCache(c.distincts, cacheKeyPrefixCounts+field+".", query,
+		func(query *es.Query) ([]byte, int, error) {
+			return c.distinctCountsQuerier(query, field)
+		})
 
 	return jb, err
 }
 
-func (c *CachedQuerier) usernameQuerier(query *es.Query) ([]byte, int, error) {
+func (c *CachedQuerier) distinctCountsQuerier(query *es.Query, field string) ([]byte, int, error) {
 	t := time.Now()
 
-	usernames, err := c.Scroller.Usernames(query)
+	counts, err := c.Scroller.DistinctCounts(query, field)
 	if err != nil {
 		return nil, -1, err
 	}
 
-	logQuery(t, len(usernames), query, "usernames")
+	c.logQuery(t, len(counts), query, "distinct counts "+field)
 
-	return stringsToJSON(usernames)
+	return stringsToJSON(counts)
 }
 
-func stringsToJSON(strs []string) ([]byte, int, error) {
+func stringsToJSON[T []string | map[string]int](strs T) ([]byte, int, error) {
 	t := time.Now()
 	jsonBytes, err := json.Marshal(strs)
 	if err != nil {
 		return nil, -1, err
 	}
 
-	slog.Debug("json.Marshal of usernames", "took", time.Since(t))
+	slog.Debug("json.Marshal of strings", "took", time.Since(t))
+
+	jsonBytes, err = compressEntry(jsonBytes)
+
+	return jsonBytes, -1, err
+}
+
+// Count returns any cached count for the given query, otherwise returns the
+// JSON of an elasticsearch-like count response using the number from calling
+// our Scroller.Count().
+func (c *CachedQuerier) Count(query *es.Query) ([]byte, error) {
+	jb, _, err := c.wrapWithCache(c.searches, cacheKeyPrefixCount, query, c.countQuerier)
+
+	return jb, err
+}
+
+func (c *CachedQuerier) countQuerier(query *es.Query) ([]byte, int, error) {
+	t := time.Now()
+
+	count, err := c.Scroller.Count(query)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	c.logQuery(t, count, query, "count")
+
+	jsonBytes, err := json.Marshal(es.NewCountResult(count))
 
 	return jsonBytes, -1, err
 }
@@ -265,7 +708,12 @@ func Decode(data []byte) (*es.Result, error) {
 	t := time.Now()
 	result := &es.Result{}
 
-	err := easyjson.Unmarshal(data, result)
+	data, err := Plain(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = easyjson.Unmarshal(data, result)
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +45,9 @@ type mockSearchScroller struct {
 	searchCalls   int
 	scrollCalls   int
 	usernameCalls int
+	distinctCalls int
+	countCalls    int
+	multiCalls    int
 }
 
 func (m *mockSearchScroller) Search(query *es.Query) (*es.Result, error) {
@@ -83,30 +86,119 @@ func (m *mockSearchScroller) Scroll(query *es.Query) (*es.Result, error) {
 	return m.querier(query)
 }
 
+func (m *mockSearchScroller) MultiScroll(queries []*es.Query) ([]*es.Result, error) {
+	m.multiCalls++
+
+	results := make([]*es.Result, len(queries))
+
+	for i, query := range queries {
+		r, err := m.querier(query)
+		if err != nil {
+			return nil, err
+		}
+
+		results[i] = r
+	}
+
+	return results, nil
+}
+
 func (m *mockSearchScroller) Done(int) bool {
 	return true
 }
 
-func (m *mockSearchScroller) Usernames(query *es.Query) ([]string, error) {
-	m.usernameCalls++
+func (m *mockSearchScroller) DistinctValues(query *es.Query, field string) ([]string, error) {
+	counts, err := m.DistinctCounts(query, field)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(counts))
+	for val := range counts {
+		values = append(values, val)
+	}
+
+	return values, nil
+}
+
+func (m *mockSearchScroller) DistinctCounts(query *es.Query, field string) (map[string]int, error) {
+	m.distinctCalls++
+
+	if field == "USER_NAME" {
+		m.usernameCalls++
+	}
 
 	r, err := m.querier(query)
 	if err != nil {
 		return nil, err
 	}
 
-	usernamesMap := make(map[string]bool)
+	counts := make(map[string]int)
 
 	for _, hit := range r.HitSet.Hits {
-		usernamesMap[hit.Details.UserName] = true
+		val, err := hit.Details.DistinctValue(field)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[val]++
 	}
 
-	usernames := make([]string, 0, len(usernamesMap))
-	for username := range usernamesMap {
-		usernames = append(usernames, username)
+	return counts, nil
+}
+
+func (m *mockSearchScroller) Count(query *es.Query) (int, error) {
+	m.countCalls++
+
+	r, err := m.querier(query)
+	if err != nil {
+		return 0, err
 	}
 
-	return usernames, nil
+	return len(r.HitSet.Hits), nil
+}
+
+type mockAggregator struct {
+	*mockSearchScroller
+	aggregateCalls int
+}
+
+func (m *mockAggregator) Aggregate(query *es.Query) (*es.Result, bool, error) {
+	m.aggregateCalls++
+
+	if query.Filters()["rollable"] != "yes" {
+		return nil, false, nil
+	}
+
+	return &es.Result{
+		HitSet: &es.HitSet{Total: es.HitSetTotal{Value: 1}},
+		Aggregations: &es.Aggregations{Stats: &es.Buckets{
+			Buckets: []interface{}{map[string]interface{}{"key": "a", "doc_count": 1}},
+		}},
+	}, true, nil
+}
+
+type mockUnavailableSearcher struct{}
+
+func (mockUnavailableSearcher) Search(*es.Query) (*es.Result, error) {
+	return nil, es.Error{Msg: es.ErrCircuitOpen}
+}
+
+type mockLocalAggregator struct {
+	*mockAggregator
+	localCalls int
+}
+
+func (m *mockLocalAggregator) AggregateLocalOnly(query *es.Query) (*es.Result, bool, error) {
+	m.localCalls++
+
+	if query.Filters()["local"] != "yes" {
+		return nil, false, nil
+	}
+
+	result, err := m.querier(query)
+
+	return result, true, err
 }
 
 func TestCache(t *testing.T) {
@@ -126,9 +218,11 @@ func TestCache(t *testing.T) {
 
 		Convey("You can get uncached, then cached Search results", func() {
 			So(ss.searchCalls, ShouldEqual, 0)
+			So(query.CacheStatus(), ShouldBeBlank)
 
 			data, err := cq.Search(query)
 			So(err, ShouldBeNil)
+			So(query.CacheStatus(), ShouldEqual, es.CacheMiss)
 
 			results, err := Decode(data)
 			So(err, ShouldBeNil)
@@ -143,6 +237,7 @@ func TestCache(t *testing.T) {
 			So(results.HitSet.Total.Value, ShouldEqual, expectedTotal)
 			So(ss.searchCalls, ShouldEqual, 1)
 			So(ss.scrollCalls, ShouldEqual, 0)
+			So(query.CacheStatus(), ShouldEqual, es.CacheHit)
 
 			Convey("Different queries get fresh results that are also cached", func() {
 				expectedTotal2 := expectedTotal + 1
@@ -217,6 +312,15 @@ func TestCache(t *testing.T) {
 					So(results.HitSet.Total.Value, ShouldEqual, expectedTotal2)
 					So(ss.searchCalls, ShouldEqual, 4)
 				})
+
+				Convey("You can Flush() the cache to get fresh results", func() {
+					So(cq.Flush(), ShouldEqual, 2)
+					So(cq.Flush(), ShouldEqual, 0)
+
+					_, err = cq.Search(query)
+					So(err, ShouldBeNil)
+					So(ss.searchCalls, ShouldEqual, 3)
+				})
 			})
 		})
 
@@ -239,6 +343,112 @@ func TestCache(t *testing.T) {
 			So(results.HitSet.Total.Value, ShouldEqual, expectedTotal)
 			So(ss.scrollCalls, ShouldEqual, 1)
 			So(ss.searchCalls, ShouldEqual, 0)
+
+			Convey("Which have their totals limited by track_total_hits", func() {
+				query.TrackTotalHits = json.RawMessage("2")
+
+				data, _, err = cq.Scroll(query)
+				So(err, ShouldBeNil)
+
+				results, err = Decode(data)
+				So(err, ShouldBeNil)
+				So(results.HitSet.Total, ShouldResemble, es.HitSetTotal{Value: 2, Relation: es.RelationGte})
+				So(ss.scrollCalls, ShouldEqual, 2)
+			})
+
+			Convey("Which are recomputed and re-stored for queries that want a Refresh", func() {
+				query.SetRefresh(true)
+
+				_, _, err = cq.Scroll(query)
+				So(err, ShouldBeNil)
+				So(query.CacheStatus(), ShouldEqual, es.CacheMiss)
+				So(ss.scrollCalls, ShouldEqual, 2)
+
+				query.SetRefresh(false)
+
+				_, _, err = cq.Scroll(query)
+				So(err, ShouldBeNil)
+				So(query.CacheStatus(), ShouldEqual, es.CacheHit)
+				So(ss.scrollCalls, ShouldEqual, 2)
+			})
+		})
+
+		Convey("You can ScrollOrStream, getting large uncached results as a Result", func() {
+			data, result, _, err := cq.ScrollOrStream(query)
+			So(err, ShouldBeNil)
+			So(result, ShouldBeNil)
+			So(data, ShouldNotBeEmpty)
+			So(ss.scrollCalls, ShouldEqual, 1)
+
+			cq.SetStreamThreshold(5)
+
+			data, result, _, err = cq.ScrollOrStream(query)
+			So(err, ShouldBeNil)
+			So(result, ShouldBeNil)
+			So(data, ShouldNotBeEmpty)
+			So(ss.scrollCalls, ShouldEqual, 1)
+
+			cq.Flush()
+
+			for range 2 {
+				data, result, _, err = cq.ScrollOrStream(query)
+				So(err, ShouldBeNil)
+				So(data, ShouldBeNil)
+				So(len(result.HitSet.Hits), ShouldEqual, 5)
+			}
+
+			So(ss.scrollCalls, ShouldEqual, 3)
+
+			cq.SetStreamThreshold(6)
+
+			for range 2 {
+				data, result, _, err = cq.ScrollOrStream(query)
+				So(err, ShouldBeNil)
+				So(result, ShouldBeNil)
+
+				results, errd := Decode(data)
+				So(errd, ShouldBeNil)
+				So(results.HitSet.Total.Value, ShouldEqual, expectedTotal)
+			}
+
+			So(ss.scrollCalls, ShouldEqual, 4)
+		})
+
+		Convey("You can MultiScroll, getting uncached results in a single call", func() {
+			query2 := &es.Query{
+				Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+					{"match_phrase": map[string]interface{}{"total": strconv.Itoa(expectedTotal + 1)}},
+				}}},
+			}
+
+			_, _, err := cq.Scroll(query)
+			So(err, ShouldBeNil)
+			So(ss.scrollCalls, ShouldEqual, 1)
+
+			data, err := cq.MultiScroll([]*es.Query{query2, query})
+			So(err, ShouldBeNil)
+			So(ss.multiCalls, ShouldEqual, 1)
+			So(query2.CacheStatus(), ShouldEqual, es.CacheMiss)
+			So(query.CacheStatus(), ShouldEqual, es.CacheHit)
+
+			var raws []json.RawMessage
+
+			err = json.Unmarshal(data, &raws)
+			So(err, ShouldBeNil)
+			So(len(raws), ShouldEqual, 2)
+
+			results, err := Decode(raws[0])
+			So(err, ShouldBeNil)
+			So(results.HitSet.Total.Value, ShouldEqual, expectedTotal+1)
+
+			results, err = Decode(raws[1])
+			So(err, ShouldBeNil)
+			So(results.HitSet.Total.Value, ShouldEqual, expectedTotal)
+
+			_, err = cq.MultiScroll([]*es.Query{query, query2})
+			So(err, ShouldBeNil)
+			So(ss.multiCalls, ShouldEqual, 1)
+			So(ss.scrollCalls, ShouldEqual, 1)
 		})
 
 		Convey("You can get uncached, then cached Usernames results", func() {
@@ -295,9 +505,243 @@ func TestCache(t *testing.T) {
 			So(ss.searchCalls, ShouldEqual, 0)
 		})
 
+		Convey("You can get uncached, then cached DistinctValues results", func() {
+			So(ss.distinctCalls, ShouldEqual, 0)
+
+			data, err := cq.DistinctValues(query, "USER_NAME")
+			So(err, ShouldBeNil)
+
+			var usernames []string
+
+			err = json.Unmarshal(data, &usernames)
+			So(err, ShouldBeNil)
+
+			sort.Strings(usernames)
+
+			expected := []string{"a", "b"}
+			So(usernames, ShouldResemble, expected)
+			So(ss.distinctCalls, ShouldEqual, 1)
+
+			data, err = cq.DistinctValues(query, "USER_NAME")
+			So(err, ShouldBeNil)
+
+			usernames = nil
+
+			err = json.Unmarshal(data, &usernames)
+			So(err, ShouldBeNil)
+			sort.Strings(usernames)
+			So(usernames, ShouldResemble, expected)
+			So(ss.distinctCalls, ShouldEqual, 1)
+			So(ss.scrollCalls, ShouldEqual, 0)
+			So(ss.searchCalls, ShouldEqual, 0)
+
+			rdata, _, err := cq.Scroll(query)
+			So(err, ShouldBeNil)
+
+			results, err := Decode(rdata)
+			So(err, ShouldBeNil)
+			So(results.HitSet.Total.Value, ShouldEqual, expectedTotal)
+			So(ss.scrollCalls, ShouldEqual, 1)
+
+			data, err = cq.DistinctValues(query, "USER_NAME")
+			So(err, ShouldBeNil)
+
+			usernames = nil
+
+			err = json.Unmarshal(data, &usernames)
+			So(err, ShouldBeNil)
+
+			sort.Strings(usernames)
+
+			So(usernames, ShouldResemble, expected)
+			So(ss.distinctCalls, ShouldEqual, 1)
+			So(ss.scrollCalls, ShouldEqual, 1)
+			So(ss.searchCalls, ShouldEqual, 0)
+
+			data, err = cq.DistinctValues(query, es.DistinctGPU)
+			So(err, ShouldBeNil)
+
+			var flags []string
+
+			err = json.Unmarshal(data, &flags)
+			So(err, ShouldBeNil)
+			So(flags, ShouldResemble, []string{"false"})
+			So(ss.distinctCalls, ShouldEqual, 2)
+
+			_, err = cq.DistinctValues(query, "JOB_NAME")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("You can get uncached, then cached DistinctCounts results", func() {
+			data, err := cq.DistinctCounts(query, "USER_NAME")
+			So(err, ShouldBeNil)
+
+			var counts map[string]int
+
+			err = json.Unmarshal(data, &counts)
+			So(err, ShouldBeNil)
+			So(len(counts), ShouldEqual, 2)
+			So(counts["a"]+counts["b"], ShouldEqual, expectedTotal)
+			So(ss.distinctCalls, ShouldEqual, 1)
+
+			data, err = cq.DistinctCounts(query, "USER_NAME")
+			So(err, ShouldBeNil)
+			So(query.CacheStatus(), ShouldEqual, es.CacheHit)
+			So(ss.distinctCalls, ShouldEqual, 1)
+
+			var cached map[string]int
+
+			err = json.Unmarshal(data, &cached)
+			So(err, ShouldBeNil)
+			So(cached, ShouldResemble, counts)
+
+			_, err = cq.DistinctValues(query, "USER_NAME")
+			So(err, ShouldBeNil)
+			So(ss.distinctCalls, ShouldEqual, 2)
+
+			stats := cq.Stats()
+			So(len(stats.Entries), ShouldEqual, 2)
+			So(stats.Entries[1].Kind, ShouldEqual, "distinct counts USER_NAME")
+
+			_, err = cq.DistinctCounts(query, "JOB_NAME")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Each kind of query has its own cache, with its own size", func() {
+			cq, err = NewWithSizes(ss, ss, Sizes{Search: 1, Scroll: 1, Distinct: 1})
+			So(err, ShouldBeNil)
+
+			otherQuery := func(total int) *es.Query {
+				return &es.Query{
+					Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+						{"match_phrase": map[string]interface{}{"total": strconv.Itoa(total)}},
+					}}},
+				}
+			}
+
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+			_, err = cq.DistinctValues(query, "USER_NAME")
+			So(err, ShouldBeNil)
+
+			for total := 1; total <= 3; total++ {
+				_, _, err = cq.Scroll(otherQuery(total))
+				So(err, ShouldBeNil)
+			}
+
+			So(ss.scrollCalls, ShouldEqual, 3)
+
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+			So(query.CacheStatus(), ShouldEqual, es.CacheHit)
+			So(ss.searchCalls, ShouldEqual, 1)
+
+			_, err = cq.DistinctValues(query, "USER_NAME")
+			So(err, ShouldBeNil)
+			So(ss.distinctCalls, ShouldEqual, 1)
+
+			_, _, err = cq.Scroll(otherQuery(1))
+			So(err, ShouldBeNil)
+			So(ss.scrollCalls, ShouldEqual, 4)
+
+			So(cq.Flush(), ShouldEqual, 3)
+		})
+
+		Convey("You can get uncached, then cached Count results", func() {
+			So(ss.countCalls, ShouldEqual, 0)
+
+			data, err := cq.Count(query)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual,
+				`{"count":5,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0}}`)
+			So(ss.countCalls, ShouldEqual, 1)
+
+			data2, err := cq.Count(query)
+			So(err, ShouldBeNil)
+			So(data2, ShouldResemble, data)
+			So(ss.countCalls, ShouldEqual, 1)
+			So(ss.scrollCalls, ShouldEqual, 0)
+		})
+
+		Convey("Aggregation Searches are answered by a Scroller that is an Aggregator, if it can", func() {
+			agg := &mockAggregator{mockSearchScroller: ss}
+			cq, err = New(ss, agg, cacheSize)
+			So(err, ShouldBeNil)
+
+			query.Aggs = &es.Aggs{Stats: es.AggsStats{Terms: &es.Field{Field: "ACCOUNTING_NAME"}}}
+
+			data, err := cq.Search(query)
+			So(err, ShouldBeNil)
+			So(agg.aggregateCalls, ShouldEqual, 1)
+			So(ss.searchCalls, ShouldEqual, 1)
+
+			results, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(results.HitSet.Total.Value, ShouldEqual, expectedTotal)
+
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": {"rollable": "yes"}})
+
+			data, err = cq.Search(query)
+			So(err, ShouldBeNil)
+			So(agg.aggregateCalls, ShouldEqual, 2)
+			So(ss.searchCalls, ShouldEqual, 1)
+
+			results, err = Decode(data)
+			So(err, ShouldBeNil)
+			So(results.HitSet.Total.Value, ShouldEqual, 1)
+			So(results.Aggregations.Stats.Buckets, ShouldHaveLength, 1)
+
+			query.Aggs = nil
+
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+			So(agg.aggregateCalls, ShouldEqual, 2)
+			So(ss.searchCalls, ShouldEqual, 2)
+		})
+
+		Convey("Aggregation Searches get uncached local-only answers while the Searcher is unavailable", func() {
+			local := &mockLocalAggregator{mockAggregator: &mockAggregator{mockSearchScroller: ss}}
+			cq, err = New(mockUnavailableSearcher{}, local, cacheSize)
+			So(err, ShouldBeNil)
+
+			query.Aggs = &es.Aggs{Stats: es.AggsStats{Terms: &es.Field{Field: "ACCOUNTING_NAME"}}}
+
+			_, err = cq.Search(query)
+			So(es.IsUnavailable(err), ShouldBeTrue)
+			So(local.localCalls, ShouldEqual, 1)
+			So(query.Warnings(), ShouldBeEmpty)
+
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": {"local": "yes"}})
+
+			for i := range 2 {
+				q := &es.Query{Aggs: query.Aggs, Query: query.Query}
+
+				data, err := cq.Search(q)
+				So(err, ShouldBeNil)
+				So(local.localCalls, ShouldEqual, 2+i)
+				So(q.Warnings(), ShouldResemble, []string{WarnLocalOnly})
+
+				results, err := Decode(data)
+				So(err, ShouldBeNil)
+				So(results.HitSet.Total.Value, ShouldEqual, expectedTotal)
+			}
+
+			query.Aggs = nil
+
+			_, err = cq.Search(query)
+			So(es.IsUnavailable(err), ShouldBeTrue)
+			So(local.localCalls, ShouldEqual, 3)
+		})
+
 		Convey("You can get all fields, or just the ones you want", func() {
 			data, _, err := cq.Scroll(query)
 			So(err, ShouldBeNil)
+			So(IsGzipped(data), ShouldBeTrue)
+
+			data, err = Plain(data)
+			So(err, ShouldBeNil)
 
 			jsonStr := string(data)
 			So(strings.Count(jsonStr, `{"_id":"`), ShouldEqual, 5)
@@ -325,6 +769,9 @@ func TestCache(t *testing.T) {
 			data, _, err = cq.Scroll(query)
 			So(err, ShouldBeNil)
 
+			data, err = Plain(data)
+			So(err, ShouldBeNil)
+
 			jsonStr = string(data)
 			So(strings.Count(jsonStr, `{"_id":"`), ShouldEqual, 5)
 			So(strings.Count(jsonStr, `{"_source":{"_id":"`), ShouldEqual, 0)
@@ -349,3 +796,66 @@ func TestCache(t *testing.T) {
 		})
 	})
 }
+
+type mockEachScroller struct {
+	*mockSearchScroller
+	eachCalls int
+}
+
+func (m *mockEachScroller) ScrollEach(query *es.Query, cb func(*es.Hit) error) error {
+	m.eachCalls++
+
+	result, err := m.querier(query)
+	if err != nil {
+		return err
+	}
+
+	for i := range result.HitSet.Hits {
+		if err = cb(&result.HitSet.Hits[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestScrollEach(t *testing.T) {
+	Convey("Given a CachedQuerier and a query", t, func() {
+		query := &es.Query{
+			Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				{"match_phrase": map[string]interface{}{"total": "5"}},
+			}}},
+		}
+
+		var ids []string
+
+		collect := func(hit *es.Hit) error {
+			ids = append(ids, hit.ID)
+
+			return nil
+		}
+
+		Convey("ScrollEach uses ScrollResult() if the Scroller isn't an EachScroller", func() {
+			ss := &mockSearchScroller{}
+
+			cq, err := New(ss, ss, cacheSize)
+			So(err, ShouldBeNil)
+
+			So(cq.ScrollEach(query, collect), ShouldBeNil)
+			So(ids, ShouldResemble, []string{"1", "2", "3", "4", "5"})
+			So(ss.scrollCalls, ShouldEqual, 1)
+		})
+
+		Convey("ScrollEach uses the Scroller's ScrollEach() if it has one", func() {
+			each := &mockEachScroller{mockSearchScroller: &mockSearchScroller{}}
+
+			cq, err := New(each, each, cacheSize)
+			So(err, ShouldBeNil)
+
+			So(cq.ScrollEach(query, collect), ShouldBeNil)
+			So(ids, ShouldResemble, []string{"1", "2", "3", "4", "5"})
+			So(each.eachCalls, ShouldEqual, 1)
+			So(each.scrollCalls, ShouldEqual, 0)
+		})
+	})
+}
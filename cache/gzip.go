@@ -0,0 +1,168 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+)
+
+// minCompressBytes is the smallest JSON we store gzip compressed in our cache.
+const minCompressBytes = 1024
+
+// gzipMagic are the first bytes of gzip compressed data, which JSON can never
+// start with.
+var gzipMagic = []byte{0x1f, 0x8b} //nolint:gochecknoglobals
+
+// IsGzipped returns true if the given data (eg. the JSON returned by Search())
+// is gzip compressed.
+func IsGzipped(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// Plain returns the given data (eg. the JSON returned by Search()) decompressed
+// if IsGzipped(), otherwise as is.
+func Plain(data []byte) ([]byte, error) {
+	if !IsGzipped(data) {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(zr)
+}
+
+// entryWriter is an io.Writer that buffers what is written to it, switching to
+// gzip compressing it once at least minCompressBytes have been written, so that
+// large JSON never has to be held in memory uncompressed.
+type entryWriter struct {
+	buf bytes.Buffer
+	zw  *gzip.Writer
+}
+
+func (e *entryWriter) Write(p []byte) (int, error) {
+	if e.zw == nil && e.buf.Len()+len(p) < minCompressBytes {
+		return e.buf.Write(p)
+	}
+
+	if e.zw == nil {
+		if err := e.startCompressing(); err != nil {
+			return 0, err
+		}
+	}
+
+	return e.zw.Write(p)
+}
+
+// startCompressing makes us gzip compress what has been written so far, and
+// everything written from now on.
+func (e *entryWriter) startCompressing() error {
+	plain := bytes.Clone(e.buf.Bytes())
+	e.buf.Reset()
+
+	zw, err := gzip.NewWriterLevel(&e.buf, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+
+	e.zw = zw
+
+	_, err = zw.Write(plain)
+
+	return err
+}
+
+// Bytes returns everything written to us, gzip compressed if it was large
+// enough.
+func (e *entryWriter) Bytes() ([]byte, error) {
+	if e.zw != nil {
+		if err := e.zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e.buf.Bytes(), nil
+}
+
+// compressEntry returns the given JSON gzip compressed if it is large enough
+// to be worth storing that way in our cache.
+func compressEntry(data []byte) ([]byte, error) {
+	if len(data) < minCompressBytes {
+		return data, nil
+	}
+
+	e := &entryWriter{}
+
+	if _, err := e.Write(data); err != nil {
+		return nil, err
+	}
+
+	return e.Bytes()
+}
+
+// Gzip returns the given data (eg. the JSON returned by MultiScroll()) gzip
+// compressed, or as is if it already IsGzipped(). The compressed forms of
+// recently compressed data are cached, keyed on a hash of the data, so repeat
+// queries that return the same data don't have to compress it again.
+//
+// Compression favours speed over size, since results can be hundreds of MB,
+// but JSON compresses well regardless.
+func (c *CachedQuerier) Gzip(data []byte) ([]byte, error) {
+	if IsGzipped(data) {
+		return data, nil
+	}
+
+	key := sha256.Sum256(data)
+
+	if gz, ok := c.gzipped.Get(key); ok {
+		return gz, nil
+	}
+
+	var buf bytes.Buffer
+
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = zw.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	gz := buf.Bytes()
+	c.gzipped.Add(key, gz)
+
+	return gz, nil
+}
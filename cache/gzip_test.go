@@ -0,0 +1,123 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGzip(t *testing.T) {
+	Convey("Given a CachedQuerier, you can Gzip() data", t, func() {
+		cq, err := New(nil, nil, 1)
+		So(err, ShouldBeNil)
+
+		data := bytes.Repeat([]byte(`{"hit":1},`), 1000)
+
+		gz, err := cq.Gzip(data)
+		So(err, ShouldBeNil)
+		So(len(gz), ShouldBeLessThan, len(data))
+
+		zr, err := gzip.NewReader(bytes.NewReader(gz))
+		So(err, ShouldBeNil)
+
+		plain, err := io.ReadAll(zr)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(plain, data), ShouldBeTrue)
+
+		Convey("The compressed form is cached until evicted or flushed", func() {
+			again, errg := cq.Gzip(bytes.Clone(data))
+			So(errg, ShouldBeNil)
+			So(&again[0], ShouldPointTo, &gz[0])
+
+			cq.Flush()
+
+			again, errg = cq.Gzip(data)
+			So(errg, ShouldBeNil)
+			So(&again[0], ShouldNotPointTo, &gz[0])
+			So(again, ShouldResemble, gz)
+
+			gz = again
+
+			_, errg = cq.Gzip([]byte("other"))
+			So(errg, ShouldBeNil)
+
+			again, errg = cq.Gzip(data)
+			So(errg, ShouldBeNil)
+			So(&again[0], ShouldNotPointTo, &gz[0])
+		})
+
+		Convey("Already compressed data is returned as is", func() {
+			again, errg := cq.Gzip(gz)
+			So(errg, ShouldBeNil)
+			So(&again[0], ShouldPointTo, &gz[0])
+		})
+	})
+
+	Convey("Large cache entries are compressed, and can be decompressed", t, func() {
+		small := []byte(`{"hit":1}`)
+
+		entry, err := compressEntry(small)
+		So(err, ShouldBeNil)
+		So(IsGzipped(entry), ShouldBeFalse)
+
+		plain, err := Plain(entry)
+		So(err, ShouldBeNil)
+		So(plain, ShouldResemble, small)
+
+		large := bytes.Repeat(small, minCompressBytes)
+
+		entry, err = compressEntry(large)
+		So(err, ShouldBeNil)
+		So(IsGzipped(entry), ShouldBeTrue)
+		So(len(entry), ShouldBeLessThan, len(large)/10)
+
+		plain, err = Plain(entry)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(plain, large), ShouldBeTrue)
+
+		Convey("including when written in pieces", func() {
+			e := &entryWriter{}
+
+			for range minCompressBytes {
+				_, err = e.Write(small)
+				So(err, ShouldBeNil)
+			}
+
+			entry, err = e.Bytes()
+			So(err, ShouldBeNil)
+			So(IsGzipped(entry), ShouldBeTrue)
+
+			plain, err = Plain(entry)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(plain, large), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,165 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package cache
+
+import (
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	PartitionSearch   = "search"
+	PartitionScroll   = "scroll"
+	PartitionDistinct = "distinct"
+)
+
+// entry is a cached result, along with a description of the query it is the
+// result of.
+type entry struct {
+	data []byte
+	info Entry
+}
+
+// partition is one of a CachedQuerier's LRU caches, holding the results of one
+// kind of query.
+type partition struct {
+	name string
+	size int
+	lru  *lru.Cache[string, entry]
+}
+
+func newPartition(name string, size int) (*partition, error) {
+	l, err := lru.New[string, entry](size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &partition{name: name, size: size, lru: l}, nil
+}
+
+// kindOf returns the kind of query (eg. "count", "distinct USER_NAME" or
+// "distinct counts USER_NAME") whose result is cached in us under the given
+// key.
+func (p *partition) kindOf(cacheKey string) string {
+	switch {
+	case strings.HasPrefix(cacheKey, cacheKeyPrefixCount):
+		return "count"
+	case strings.HasPrefix(cacheKey, cacheKeyPrefixStrings):
+		return PartitionDistinct + " " + fieldOfKey(cacheKey, cacheKeyPrefixStrings)
+	case strings.HasPrefix(cacheKey, cacheKeyPrefixCounts):
+		return PartitionDistinct + " counts " + fieldOfKey(cacheKey, cacheKeyPrefixCounts)
+	default:
+		return p.name
+	}
+}
+
+// fieldOfKey returns the field of a distinct values cache key with the given
+// prefix.
+func fieldOfKey(cacheKey, prefix string) string {
+	field := strings.TrimPrefix(cacheKey, prefix)
+	if i := strings.LastIndex(field, "."); i >= 0 {
+		field = field[:i]
+	}
+
+	return field
+}
+
+// get returns the JSON cached in the given partition for the given key,
+// recording on the query whether it was found, and counting it as a hit or
+// miss in our Metrics. Queries that want a Refresh() are treated as not cached,
+// so that they get recomputed and their new result stored in place of the old.
+func (c *CachedQuerier) get(p *partition, cacheKey string, query *es.Query) ([]byte, bool) {
+	var (
+		e  entry
+		ok bool
+	)
+
+	if !query.Refresh() {
+		e, ok = p.lru.Get(cacheKey)
+	}
+
+	query.SetCached(ok)
+	c.metrics.observe(p.name, ok)
+
+	return e.data, ok
+}
+
+// add caches the given JSON result of the given query in the given partition
+// under the given key, counting any eviction this causes in our Metrics.
+func (c *CachedQuerier) add(p *partition, cacheKey string, query *es.Query, data []byte) {
+	info := newEntry(p.kindOf(cacheKey), query)
+	info.Partition = p.name
+	info.Key = cacheKey
+
+	if p.lru.Add(cacheKey, entry{data: data, info: info}) {
+		c.metrics.evicted(p.name)
+	}
+}
+
+// entries returns descriptions of our cached results, most recently used
+// first.
+func (p *partition) entries(now time.Time) []Entry {
+	keys := p.lru.Keys()
+	entries := make([]Entry, 0, len(keys))
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		e, ok := p.lru.Peek(keys[i])
+		if !ok {
+			continue
+		}
+
+		info := e.info
+		info.Bytes = len(e.data)
+		info.Gzipped = IsGzipped(e.data)
+		info.AgeSeconds = now.Sub(info.CachedAt).Seconds()
+
+		entries = append(entries, info)
+	}
+
+	return entries
+}
+
+// stats returns our PartitionStats, with counters from the given Metrics.
+func (p *partition) stats(m *Metrics) PartitionStats {
+	ps := PartitionStats{
+		Name:      p.name,
+		Size:      p.size,
+		Hits:      m.Hits(p.name),
+		Misses:    m.Misses(p.name),
+		Evictions: m.Evictions(p.name),
+	}
+
+	for _, key := range p.lru.Keys() {
+		if e, ok := p.lru.Peek(key); ok {
+			ps.Entries++
+			ps.Bytes += len(e.data)
+		}
+	}
+
+	return ps
+}
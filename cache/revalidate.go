@@ -0,0 +1,130 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// revalidator remembers when aggregation Search() results were cached, so that
+// ones older than age can be refreshed in the background. With an age of 0 it
+// does nothing.
+type revalidator struct {
+	mu         sync.Mutex
+	age        time.Duration
+	cachedAt   *lru.Cache[string, time.Time]
+	refreshing map[string]bool
+	wg         sync.WaitGroup
+}
+
+func newRevalidator(cacheSize int) (*revalidator, error) {
+	cachedAt, err := lru.New[string, time.Time](cacheSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revalidator{
+		cachedAt:   cachedAt,
+		refreshing: make(map[string]bool),
+	}, nil
+}
+
+// SetRevalidateAge enables stale-while-revalidate for aggregation Search()es:
+// a cached result that is older than the given age is still returned
+// immediately, but a fresh result is then got in the background to replace it
+// in the cache. The default of 0 means cached results are used until evicted
+// or Flush()ed.
+func (c *CachedQuerier) SetRevalidateAge(age time.Duration) {
+	c.revalidate.mu.Lock()
+	defer c.revalidate.mu.Unlock()
+
+	c.revalidate.age = age
+}
+
+// revalidateIfStale notes when the result of the given aggregation query was
+// cached if it was just got, or if it was a cache hit older than our revalidate
+// age, starts refreshing it in the background.
+func (c *CachedQuerier) revalidateIfStale(query *es.Query) {
+	r := c.revalidate
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.age <= 0 || query.Aggs == nil || len(query.Warnings()) > 0 {
+		return
+	}
+
+	key := cacheKeyPrefixResults + query.Key()
+
+	cachedAt, known := r.cachedAt.Get(key)
+	if query.CacheStatus() != es.CacheHit || !known {
+		r.cachedAt.Add(key, time.Now())
+
+		return
+	}
+
+	if time.Since(cachedAt) < r.age || r.refreshing[key] {
+		return
+	}
+
+	r.refreshing[key] = true
+	r.wg.Add(1)
+
+	go c.refresh(key, query.WithContext(context.Background()))
+}
+
+// refresh gets a fresh result for the given query from our Searcher, and
+// caches it under the given key.
+func (c *CachedQuerier) refresh(key string, query *es.Query) {
+	r := c.revalidate
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.refreshing, key)
+		r.mu.Unlock()
+		r.wg.Done()
+	}()
+
+	jsonBytes, _, err := c.searchQuerier(query)
+	if err != nil {
+		slog.Warn("background refresh of cached result failed", "err", err)
+
+		return
+	}
+
+	if len(query.Warnings()) > 0 {
+		return
+	}
+
+	c.add(c.searches, key, query, jsonBytes)
+	r.cachedAt.Add(key, time.Now())
+}
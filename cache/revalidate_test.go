@@ -0,0 +1,110 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestRevalidate(t *testing.T) {
+	Convey("Given a CachedQuerier with a revalidate age, and an aggregation query", t, func() {
+		ss := &mockSearchScroller{}
+
+		cq, err := New(ss, ss, cacheSize)
+		So(err, ShouldBeNil)
+
+		cq.SetRevalidateAge(time.Hour)
+
+		query := &es.Query{
+			Aggs: &es.Aggs{},
+			Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				{"match_phrase": map[string]interface{}{"total": strconv.Itoa(3)}},
+			}}},
+		}
+		key := cacheKeyPrefixResults + query.Key()
+
+		_, err = cq.Search(query)
+		So(err, ShouldBeNil)
+		So(ss.searchCalls, ShouldEqual, 1)
+
+		cachedAt, ok := cq.revalidate.cachedAt.Get(key)
+		So(ok, ShouldBeTrue)
+
+		Convey("Fresh cached results are returned without refreshing", func() {
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+			So(query.CacheStatus(), ShouldEqual, es.CacheHit)
+
+			cq.revalidate.wg.Wait()
+			So(ss.searchCalls, ShouldEqual, 1)
+		})
+
+		Convey("Stale cached results are returned, then refreshed in the background", func() {
+			cq.revalidate.cachedAt.Add(key, cachedAt.Add(-2*time.Hour))
+
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+			So(query.CacheStatus(), ShouldEqual, es.CacheHit)
+
+			cq.revalidate.wg.Wait()
+			So(ss.searchCalls, ShouldEqual, 2)
+
+			refreshedAt, ok := cq.revalidate.cachedAt.Get(key)
+			So(ok, ShouldBeTrue)
+			So(refreshedAt, ShouldHappenAfter, cachedAt)
+
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+			So(query.CacheStatus(), ShouldEqual, es.CacheHit)
+
+			cq.revalidate.wg.Wait()
+			So(ss.searchCalls, ShouldEqual, 2)
+		})
+
+		Convey("Non-aggregation queries and an age of 0 never refresh", func() {
+			cq.revalidate.cachedAt.Add(key, cachedAt.Add(-2*time.Hour))
+			cq.SetRevalidateAge(0)
+
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+
+			query.Aggs = nil
+			cq.SetRevalidateAge(time.Hour)
+
+			_, err = cq.Search(query)
+			So(err, ShouldBeNil)
+
+			cq.revalidate.wg.Wait()
+			So(ss.searchCalls, ShouldEqual, 2)
+			So(cq.revalidate.cachedAt.Contains(cacheKeyPrefixResults+query.Key()), ShouldBeFalse)
+		})
+	})
+}
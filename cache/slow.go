@@ -0,0 +1,95 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cache
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const maxSlowQueries = 100
+
+// slowQueries records the most recent queries that took at least a threshold
+// amount of time. The zero value records nothing.
+type slowQueries struct {
+	mu        sync.RWMutex
+	threshold time.Duration
+	recent    []es.SlowQuery
+}
+
+// check records the given query, and warn logs it, if took is at least our
+// threshold.
+func (s *slowQueries) check(took time.Duration, items int, query *es.Query, kind string) {
+	s.mu.RLock()
+	threshold := s.threshold
+	s.mu.RUnlock()
+
+	if threshold <= 0 || took < threshold {
+		return
+	}
+
+	sq := es.NewSlowQuery(kind, query, took, items)
+
+	slog.Warn("slow query", "kind", sq.Kind, "took", took, "items", sq.Items,
+		"gte", sq.GTE, "lt", sq.LT, "lte", sq.LTE, "filters", sq.Filters, "scanned", sq.Scanned)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recent = append(s.recent, sq)
+
+	if len(s.recent) > maxSlowQueries {
+		s.recent = s.recent[len(s.recent)-maxSlowQueries:]
+	}
+}
+
+// SetSlowQueryThreshold makes us warn log, and remember for SlowQueries(), any
+// query that takes at least the given duration to answer from our Searcher or
+// Scroller (cache hits are never slow). The default of 0 disables this.
+func (c *CachedQuerier) SetSlowQueryThreshold(threshold time.Duration) {
+	c.slow.mu.Lock()
+	defer c.slow.mu.Unlock()
+
+	c.slow.threshold = threshold
+}
+
+// SlowQueries returns details of up to the 100 most recent queries that took
+// at least our slow query threshold, newest first.
+func (c *CachedQuerier) SlowQueries() []es.SlowQuery {
+	c.slow.mu.RLock()
+	defer c.slow.mu.RUnlock()
+
+	sqs := make([]es.SlowQuery, len(c.slow.recent))
+
+	for i, sq := range c.slow.recent {
+		sqs[len(sqs)-1-i] = sq
+	}
+
+	return sqs
+}
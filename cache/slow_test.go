@@ -0,0 +1,126 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+type scanningScroller struct {
+	*mockSearchScroller
+}
+
+func (s *scanningScroller) Scroll(query *es.Query) (*es.Result, error) {
+	query.SetScanned(7)
+
+	return s.mockSearchScroller.Scroll(query)
+}
+
+func TestSlowQueries(t *testing.T) {
+	Convey("Given a CachedQuerier", t, func() {
+		ss := &scanningScroller{mockSearchScroller: &mockSearchScroller{}}
+
+		cq, err := New(ss, ss, cacheSize)
+		So(err, ShouldBeNil)
+
+		newQuery := func(total int) *es.Query {
+			return &es.Query{
+				Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+					{"match_phrase": map[string]interface{}{"total": strconv.Itoa(total)}},
+					{"range": map[string]interface{}{
+						"timestamp": map[string]interface{}{
+							"lte":    "2024-06-04T00:00:00Z",
+							"gte":    "2024-05-04T00:00:00Z",
+							"format": "strict_date_optional_time",
+						},
+					}},
+				}}},
+			}
+		}
+
+		Convey("By default no queries are slow", func() {
+			_, _, err = cq.Scroll(newQuery(1))
+			So(err, ShouldBeNil)
+
+			sqs := cq.SlowQueries()
+			So(sqs, ShouldNotBeNil)
+			So(sqs, ShouldBeEmpty)
+		})
+
+		Convey("With a high threshold, fast queries are not slow", func() {
+			cq.SetSlowQueryThreshold(time.Hour)
+
+			_, _, err = cq.Scroll(newQuery(1))
+			So(err, ShouldBeNil)
+			So(cq.SlowQueries(), ShouldBeEmpty)
+		})
+
+		Convey("With a tiny threshold, uncached queries are recorded newest first", func() {
+			cq.SetSlowQueryThreshold(time.Nanosecond)
+
+			query := newQuery(1)
+			_, _, err = cq.Scroll(query)
+			So(err, ShouldBeNil)
+
+			_, err = cq.Count(newQuery(2))
+			So(err, ShouldBeNil)
+
+			_, _, err = cq.Scroll(query)
+			So(err, ShouldBeNil)
+
+			sqs := cq.SlowQueries()
+			So(len(sqs), ShouldEqual, 2)
+			So(sqs[0].Kind, ShouldEqual, "count")
+			So(sqs[0].Items, ShouldEqual, 5)
+			So(sqs[0].Filters, ShouldResemble, map[string]string{"total": "2"})
+			So(sqs[1].Kind, ShouldEqual, "scroll")
+			So(sqs[1].Scanned, ShouldEqual, 7)
+			So(sqs[1].GTE, ShouldEqual, "2024-05-04T00:00:00Z")
+			So(sqs[1].LTE, ShouldEqual, "2024-06-04T00:00:00Z")
+			So(sqs[1].LT, ShouldBeBlank)
+			So(sqs[1].Key, ShouldEqual, query.Key())
+			So(sqs[1].TookMS, ShouldBeGreaterThan, 0)
+			So(sqs[1].Time, ShouldHappenWithin, time.Minute, time.Now())
+
+			Convey("Only the most recent are kept", func() {
+				for i := range maxSlowQueries {
+					_, err = cq.Count(newQuery(i + 3))
+					So(err, ShouldBeNil)
+				}
+
+				sqs = cq.SlowQueries()
+				So(len(sqs), ShouldEqual, maxSlowQueries)
+				So(sqs[0].Filters["total"], ShouldEqual, strconv.Itoa(maxSlowQueries+2))
+				So(sqs[maxSlowQueries-1].Filters["total"], ShouldEqual, "3")
+			})
+		})
+	})
+}
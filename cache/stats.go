@@ -0,0 +1,178 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package cache
+
+import (
+	"io"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/metrics"
+)
+
+// Metrics records how many cache hits, misses and evictions there have been in
+// each partition (PartitionSearch, PartitionScroll or PartitionDistinct) of
+// one or more CachedQueriers.
+type Metrics struct {
+	hits      *metrics.CounterVec
+	misses    *metrics.CounterVec
+	evictions *metrics.CounterVec
+}
+
+// NewMetrics returns a new Metrics, for sharing between CachedQueriers with
+// SetMetrics().
+func NewMetrics() *Metrics {
+	return &Metrics{
+		hits: metrics.NewCounterVec("farmer_cache_hits_total",
+			"Number of queries answered from the cache.", "partition"),
+		misses: metrics.NewCounterVec("farmer_cache_misses_total",
+			"Number of queries not answered from the cache.", "partition"),
+		evictions: metrics.NewCounterVec("farmer_cache_evictions_total",
+			"Number of cached results evicted to make room for new ones.", "partition"),
+	}
+}
+
+// observe records a hit or miss in the given partition.
+func (m *Metrics) observe(partition string, hit bool) {
+	if hit {
+		m.hits.Inc(partition)
+	} else {
+		m.misses.Inc(partition)
+	}
+}
+
+// evicted records an eviction from the given partition.
+func (m *Metrics) evicted(partition string) {
+	m.evictions.Inc(partition)
+}
+
+// Hits returns the number of cache hits in the given partition.
+func (m *Metrics) Hits(partition string) uint64 {
+	return m.hits.Value(partition)
+}
+
+// Misses returns the number of cache misses in the given partition.
+func (m *Metrics) Misses(partition string) uint64 {
+	return m.misses.Value(partition)
+}
+
+// Evictions returns the number of results evicted from the given partition.
+func (m *Metrics) Evictions(partition string) uint64 {
+	return m.evictions.Value(partition)
+}
+
+// WriteMetrics writes our metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteMetrics(w io.Writer) error {
+	return metrics.WriteAll(w, m.hits, m.misses, m.evictions)
+}
+
+// SetMetrics makes us record our cache hits, misses and evictions in the given
+// Metrics instead of our own, eg. so that counts continue across replacement
+// of one CachedQuerier with another.
+func (c *CachedQuerier) SetMetrics(m *Metrics) {
+	c.metrics = m
+}
+
+// Metrics returns the Metrics we record our cache hits, misses and evictions
+// in.
+func (c *CachedQuerier) Metrics() *Metrics {
+	return c.metrics
+}
+
+// Entry describes a cached result and the query it is the result of.
+type Entry struct {
+	Partition  string            `json:"partition"`
+	Kind       string            `json:"kind"`
+	Key        string            `json:"key"`
+	Bytes      int               `json:"bytes"`
+	Gzipped    bool              `json:"gzipped"`
+	CachedAt   time.Time         `json:"cached_at"`
+	AgeSeconds float64           `json:"age_seconds"`
+	GTE        string            `json:"gte,omitempty"`
+	LT         string            `json:"lt,omitempty"`
+	LTE        string            `json:"lte,omitempty"`
+	Filters    map[string]string `json:"filters"`
+}
+
+// newEntry returns an Entry describing the given query of the given kind,
+// cached now.
+func newEntry(kind string, query *es.Query) Entry {
+	e := Entry{
+		Kind:     kind,
+		CachedAt: time.Now(),
+		Filters:  query.Filters(),
+	}
+
+	lt, lte, gte, err := query.DateRange()
+	if err == nil {
+		e.GTE = formatRangeTime(gte)
+		e.LT = formatRangeTime(lt)
+		e.LTE = formatRangeTime(lte)
+	}
+
+	return e
+}
+
+// formatRangeTime formats non-zero times as RFC3339, and zero times as blank.
+func formatRangeTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// PartitionStats describes the occupancy of one of a CachedQuerier's caches.
+// Its Hits, Misses and Evictions are those of the CachedQuerier's Metrics().
+type PartitionStats struct {
+	Name      string `json:"name"`
+	Size      int    `json:"size"`
+	Entries   int    `json:"entries"`
+	Bytes     int    `json:"bytes"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// Stats describes a CachedQuerier's caches and everything in them.
+type Stats struct {
+	Partitions []PartitionStats `json:"partitions"`
+	Entries    []Entry          `json:"entries"`
+}
+
+// Stats returns details of each of our caches, and of each result in them,
+// most recently used first within each cache. It doesn't affect which results
+// are least recently used.
+func (c *CachedQuerier) Stats() Stats {
+	now := time.Now()
+	stats := Stats{Entries: []Entry{}}
+
+	for _, p := range c.partitions() {
+		stats.Partitions = append(stats.Partitions, p.stats(c.metrics))
+		stats.Entries = append(stats.Entries, p.entries(now)...)
+	}
+
+	return stats
+}
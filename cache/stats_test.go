@@ -0,0 +1,132 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package cache
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestStats(t *testing.T) {
+	Convey("Given a CachedQuerier", t, func() {
+		ss := &mockSearchScroller{}
+
+		cq, err := NewWithSizes(ss, ss, Sizes{Search: 2, Scroll: 1, Distinct: 1})
+		So(err, ShouldBeNil)
+
+		totalQuery := func(total int) *es.Query {
+			return &es.Query{
+				Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+					{"match_phrase": map[string]interface{}{"total": strconv.Itoa(total)}},
+				}}},
+			}
+		}
+
+		Convey("hits, misses and evictions are counted per partition", func() {
+			_, err = cq.Search(totalQuery(1))
+			So(err, ShouldBeNil)
+			_, err = cq.Search(totalQuery(1))
+			So(err, ShouldBeNil)
+
+			for total := 1; total <= 3; total++ {
+				_, _, err = cq.Scroll(totalQuery(total))
+				So(err, ShouldBeNil)
+			}
+
+			m := cq.Metrics()
+			So(m.Hits(PartitionSearch), ShouldEqual, 1)
+			So(m.Misses(PartitionSearch), ShouldEqual, 1)
+			So(m.Evictions(PartitionSearch), ShouldEqual, 0)
+			So(m.Hits(PartitionScroll), ShouldEqual, 0)
+			So(m.Misses(PartitionScroll), ShouldEqual, 3)
+			So(m.Evictions(PartitionScroll), ShouldEqual, 2)
+
+			var buf bytes.Buffer
+
+			So(m.WriteMetrics(&buf), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, `farmer_cache_hits_total{partition="search"} 1`)
+			So(buf.String(), ShouldContainSubstring, `farmer_cache_misses_total{partition="scroll"} 3`)
+			So(buf.String(), ShouldContainSubstring, `farmer_cache_evictions_total{partition="scroll"} 2`)
+
+			Convey("which can be shared between CachedQueriers", func() {
+				cq2, errn := New(ss, ss, 1)
+				So(errn, ShouldBeNil)
+				cq2.SetMetrics(m)
+
+				_, errn = cq2.Search(totalQuery(1))
+				So(errn, ShouldBeNil)
+				So(m.Misses(PartitionSearch), ShouldEqual, 2)
+			})
+		})
+
+		Convey("Stats() describes the partitions and their entries", func() {
+			stats := cq.Stats()
+			So(len(stats.Partitions), ShouldEqual, 3)
+			So(stats.Entries, ShouldBeEmpty)
+
+			_, err = cq.Search(totalQuery(1))
+			So(err, ShouldBeNil)
+			_, err = cq.Count(totalQuery(2))
+			So(err, ShouldBeNil)
+			_, err = cq.DistinctValues(totalQuery(3), "USER_NAME")
+			So(err, ShouldBeNil)
+
+			stats = cq.Stats()
+			So(stats.Partitions[0].Name, ShouldEqual, PartitionSearch)
+			So(stats.Partitions[0].Size, ShouldEqual, 2)
+			So(stats.Partitions[0].Entries, ShouldEqual, 2)
+			So(stats.Partitions[0].Misses, ShouldEqual, 2)
+			So(stats.Partitions[1].Entries, ShouldEqual, 0)
+			So(stats.Partitions[2].Entries, ShouldEqual, 1)
+
+			So(len(stats.Entries), ShouldEqual, 3)
+			So(stats.Entries[0].Kind, ShouldEqual, "count")
+			So(stats.Entries[0].Filters, ShouldResemble, map[string]string{"total": "2"})
+			So(stats.Entries[1].Kind, ShouldEqual, PartitionSearch)
+			So(stats.Entries[1].Partition, ShouldEqual, PartitionSearch)
+			So(stats.Entries[1].Key, ShouldEqual, cacheKeyPrefixResults+totalQuery(1).Key())
+			So(stats.Entries[1].Bytes, ShouldBeGreaterThan, 0)
+			So(stats.Entries[1].AgeSeconds, ShouldBeGreaterThanOrEqualTo, 0)
+			So(stats.Entries[2].Kind, ShouldEqual, "distinct USER_NAME")
+			So(stats.Entries[2].Partition, ShouldEqual, PartitionDistinct)
+
+			So(stats.Partitions[0].Bytes, ShouldEqual, stats.Entries[0].Bytes+stats.Entries[1].Bytes)
+
+			Convey("without changing which is least recently used", func() {
+				_, err = cq.Search(totalQuery(4))
+				So(err, ShouldBeNil)
+
+				stats = cq.Stats()
+				So(stats.Entries[0].Key, ShouldEqual, cacheKeyPrefixResults+totalQuery(4).Key())
+				So(stats.Entries[1].Kind, ShouldEqual, "count")
+				So(cq.Metrics().Evictions(PartitionSearch), ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,859 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// package client provides a typed Go client for a farmer server, so that tools
+// can query it without hand-writing elasticsearch query DSL or HTTP plumbing.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrBadStatus    = "farmer server returned a non-OK status"
+	ErrSearchFailed = "a search in a multi search failed"
+
+	getUsernamesEndpoint       = "get_usernames"
+	getAccountingNamesEndpoint = "get_accounting_names"
+	getBOMsEndpoint            = "get_boms"
+	multiScrollEndpoint        = "multi_scroll"
+	exportEndpoint             = "export"
+	metricsEndpoint            = "metrics"
+	statusEndpoint             = "status"
+	readyEndpoint              = "readyz"
+	teamSummaryEndpoint        = "report/team_summary"
+	gpuReportEndpoint          = "report/gpu"
+	adminReloadEndpoint        = "admin/reload"
+	adminFlushCacheEndpoint    = "admin/flush-cache"
+	adminSlowQueriesEndpoint   = "admin/slow-queries"
+	adminCacheEndpoint         = "admin/cache"
+	adminReloadConfigEndpoint  = "admin/reload-config"
+	adminBackfillEndpoint      = "admin/backfill"
+	backfillFromFormat         = time.DateOnly
+	scrollParam                = "scroll=1m"
+	explainPlanParam           = "explain_plan=true"
+	contentTypeJSON            = "application/json"
+	defaultTimeout             = 10 * time.Minute
+)
+
+// Error is an error type that has a Msg with one of our const Err* messages.
+type Error struct {
+	Msg   string
+	cause string
+}
+
+// Error returns a string representation of the error.
+func (e Error) Error() string {
+	if e.cause != "" {
+		return fmt.Sprintf("%s: %s", e.Msg, e.cause)
+	}
+
+	return e.Msg
+}
+
+// Filter describes the hits you're interested in. From and To are required,
+// and BOM is required for anything other than Search().
+type Filter struct {
+	From           time.Time // inclusive
+	To             time.Time // exclusive
+	BOM            string
+	AccountingName string
+	UserName       string
+	GPUOnly        bool
+	Fields         []string // the _source fields you want; empty means all
+}
+
+// Query converts this Filter to an elasticsearch Query.
+func (f Filter) Query() *es.Query {
+	filter := es.Filter{
+		{"match_phrase": map[string]interface{}{"META_CLUSTER_NAME": "farm"}},
+		{"range": map[string]interface{}{
+			"timestamp": map[string]interface{}{
+				"lt":     f.To.UTC().Format(time.RFC3339),
+				"gte":    f.From.UTC().Format(time.RFC3339),
+				"format": "strict_date_optional_time",
+			},
+		}},
+	}
+
+	for _, kv := range [][2]string{
+		{"BOM", f.BOM},
+		{"ACCOUNTING_NAME", f.AccountingName},
+		{"USER_NAME", f.UserName},
+	} {
+		if kv[1] != "" {
+			filter = append(filter, map[string]es.MapStringStringOrMap{
+				"match_phrase": map[string]interface{}{kv[0]: kv[1]},
+			})
+		}
+	}
+
+	if f.GPUOnly {
+		filter = append(filter, map[string]es.MapStringStringOrMap{
+			"prefix": map[string]interface{}{"QUEUE_NAME": "gpu"},
+		})
+	}
+
+	return &es.Query{
+		Size:   es.MaxSize,
+		Sort:   []string{"_doc"},
+		Query:  &es.QueryFilter{Bool: es.QFBool{Filter: filter}},
+		Source: f.Fields,
+	}
+}
+
+// Client lets you query a farmer server.
+type Client struct {
+	base       *url.URL
+	index      string
+	httpClient *http.Client
+	username   string
+	password   string
+	token      string
+	refresh    bool
+}
+
+// New returns a Client that will talk to the farmer server at the given URL
+// (eg. "http://farmer.domain:1235"), doing searches against the given index,
+// which must match the index the server was configured with.
+func New(farmerURL, index string) (*Client, error) {
+	base, err := url.Parse(farmerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		base:       base,
+		index:      index,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}, nil
+}
+
+// SetBasicAuth makes subsequent requests use the given basic auth credentials,
+// for servers configured with auth_users.
+func (c *Client) SetBasicAuth(username, password string) {
+	c.username, c.password = username, password
+}
+
+// SetToken makes subsequent requests send the given bearer token, for servers
+// configured with auth_tokens. It takes precedence over SetBasicAuth().
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// SetRefresh makes subsequent requests (if refresh is true) ask the server to
+// ignore any results it has cached for them, recomputing and re-caching them
+// instead.
+func (c *Client) SetRefresh(refresh bool) {
+	c.refresh = refresh
+}
+
+// do makes a request to our server with our credentials, returning the
+// response if it had a 2xx status.
+func (c *Client) do(method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	resp, err := c.send(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(resp.Body) //nolint:errcheck
+		resp.Body.Close()
+
+		return nil, Error{Msg: ErrBadStatus, cause: fmt.Sprintf("%s: %s", resp.Status, msg)}
+	}
+
+	return resp, nil
+}
+
+// send makes a request to our server with our credentials, returning the
+// response whatever its status.
+func (c *Client) send(method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body) //nolint:noctx
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", contentTypeJSON)
+	}
+
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	if c.refresh {
+		req.Header.Set(es.RefreshHeader, "true")
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// Search does a normal (eg. aggregation) search, which the server will
+// typically proxy to the real elasticsearch.
+func (c *Client) Search(query *es.Query) (*es.Result, error) {
+	return c.searchResult(query, "")
+}
+
+// Scroll gets all the hits matching the given Filter from the server's local
+// database.
+func (c *Client) Scroll(filter Filter) (*es.Result, error) {
+	return c.searchResult(filter.Query(), scrollParam)
+}
+
+// MultiScroll is like calling Scroll() with each of the given Filters, but
+// does it in a single request that lets the server share work between them.
+// The returned Results are in the same order as the Filters.
+func (c *Client) MultiScroll(filters []Filter) ([]*es.Result, error) {
+	queries := make([]*es.Query, len(filters))
+
+	for i, filter := range filters {
+		queries[i] = filter.Query()
+	}
+
+	var raws []json.RawMessage
+
+	if err := c.postAndDecode("/"+multiScrollEndpoint, "", queries, &raws); err != nil {
+		return nil, err
+	}
+
+	results := make([]*es.Result, len(raws))
+
+	for i, raw := range raws {
+		results[i] = es.NewResult()
+
+		if err := results[i].UnmarshalJSON(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// MultiSearch is like calling Search() with each of the given queries, but
+// does it in a single _msearch request. The returned Results are in the same
+// order as the queries. Returns an error if any of the searches failed.
+func (c *Client) MultiSearch(queries []*es.Query) ([]*es.Result, error) {
+	var body bytes.Buffer
+
+	for _, query := range queries {
+		queryBytes, err := json.Marshal(query)
+		if err != nil {
+			return nil, err
+		}
+
+		body.WriteString("{}\n")
+		body.Write(queryBytes)
+		body.WriteByte('\n')
+	}
+
+	resp, err := c.do(http.MethodPost, c.base.JoinPath(c.indexPath(es.MultiSearchPage)), &body)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var msearch struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&msearch); err != nil {
+		return nil, err
+	}
+
+	return multiSearchResults(msearch.Responses)
+}
+
+// multiSearchResults converts the responses of a _msearch request to Results,
+// returning an error for the first of them that has one.
+func multiSearchResults(raws []json.RawMessage) ([]*es.Result, error) {
+	results := make([]*es.Result, len(raws))
+
+	for i, raw := range raws {
+		var failure struct {
+			Error *struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		}
+
+		if err := json.Unmarshal(raw, &failure); err != nil {
+			return nil, err
+		}
+
+		if failure.Error != nil {
+			return nil, Error{Msg: ErrSearchFailed, cause: failure.Error.Reason}
+		}
+
+		results[i] = es.NewResult()
+
+		if err := results[i].UnmarshalJSON(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func (c *Client) searchResult(query *es.Query, params string) (*es.Result, error) {
+	body, err := c.post(c.searchPath(), params, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := es.NewResult()
+
+	err = result.UnmarshalJSON(data)
+
+	return result, err
+}
+
+func (c *Client) searchPath() string {
+	return c.indexPath(es.SearchPage)
+}
+
+func (c *Client) indexPath(page string) string {
+	return "/" + url.QueryEscape(c.index) + "/" + page
+}
+
+// Count returns the number of hits matching the given Filter.
+func (c *Client) Count(filter Filter) (int, error) {
+	countResult := &es.CountResult{}
+
+	err := c.postAndDecode(c.indexPath(es.CountPage), "", filter.Query(), countResult)
+
+	return countResult.Count, err
+}
+
+// QueryPlan describes the work a farmer server's local database would do to
+// answer a Filter's scroll search.
+type QueryPlan struct {
+	Cluster        string    `json:"cluster,omitempty"`
+	BOM            string    `json:"bom"`
+	Dirs           []DirPlan `json:"dirs"`
+	IndexFiles     int       `json:"index_files"`
+	EntriesScanned int64     `json:"entries_scanned"`
+	Hits           int       `json:"hits"`
+	EstimatedBytes int64     `json:"estimated_bytes"`
+	IndexOnly      bool      `json:"index_only"`
+	Rollups        bool      `json:"rollups"`
+	ExceedsLimits  bool      `json:"exceeds_limits"`
+}
+
+// DirPlan describes the part of a QueryPlan in one day/BOM directory.
+type DirPlan struct {
+	Dir        string   `json:"dir"`
+	Loaded     bool     `json:"loaded"`
+	IndexFiles []string `json:"index_files"`
+	Entries    int      `json:"entries"`
+	Hits       int      `json:"hits"`
+	Bytes      int64    `json:"bytes"`
+}
+
+// Explain returns the QueryPlan of the given Filter: which directories and
+// index files would be looked at, how many hits would be found and how many
+// bytes read, without doing the search.
+func (c *Client) Explain(filter Filter) (*QueryPlan, error) {
+	plan := &QueryPlan{}
+
+	err := c.postAndDecode(c.searchPath(), scrollParam+"&"+explainPlanParam, filter.Query(), plan)
+
+	return plan, err
+}
+
+// post sends the given query (or queries) as JSON to the given path on our
+// server, returning the response body, which you must Close().
+func (c *Client) post(path, params string, query interface{}) (io.ReadCloser, error) {
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	u := c.base.JoinPath(path)
+	u.RawQuery = params
+
+	resp, err := c.do(http.MethodPost, u, bytes.NewReader(queryBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Usernames returns the unique usernames of the hits matching the given Filter.
+func (c *Client) Usernames(filter Filter) ([]string, error) {
+	return c.strings(getUsernamesEndpoint, filter)
+}
+
+// AccountingNames returns the unique accounting names of the hits matching the
+// given Filter.
+func (c *Client) AccountingNames(filter Filter) ([]string, error) {
+	return c.strings(getAccountingNamesEndpoint, filter)
+}
+
+// BOMs returns the unique BOMs of the hits matching the given Filter. The
+// Filter's BOM is optional here.
+func (c *Client) BOMs(filter Filter) ([]string, error) {
+	return c.strings(getBOMsEndpoint, filter)
+}
+
+// UsernameCounts returns the number of hits (jobs) matching the given Filter
+// for each username.
+func (c *Client) UsernameCounts(filter Filter) (map[string]int, error) {
+	return c.counts(getUsernamesEndpoint, filter)
+}
+
+// AccountingNameCounts returns the number of hits (jobs) matching the given
+// Filter for each accounting name.
+func (c *Client) AccountingNameCounts(filter Filter) (map[string]int, error) {
+	return c.counts(getAccountingNamesEndpoint, filter)
+}
+
+func (c *Client) counts(endpoint string, filter Filter) (map[string]int, error) {
+	var counts map[string]int
+
+	err := c.postAndDecode("/"+endpoint, "counts=true", filter.Query(), &counts)
+
+	return counts, err
+}
+
+func (c *Client) strings(endpoint string, filter Filter) ([]string, error) {
+	var strs []string
+
+	err := c.postAndDecode("/"+endpoint, "", filter.Query(), &strs)
+
+	return strs, err
+}
+
+func (c *Client) postAndDecode(path, params string, query, v interface{}) error {
+	body, err := c.post(path, params, query)
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	return json.NewDecoder(body).Decode(v)
+}
+
+// Export writes all the hits matching the given Filter to the given writer as
+// CSV, with a column for each of the Filter's Fields (or all fields if none
+// were specified). Supply tsv true to get tab separated values instead.
+func (c *Client) Export(filter Filter, w io.Writer, tsv bool) error {
+	format := ""
+
+	if tsv {
+		format = "tsv"
+	}
+
+	return c.export(filter, w, format)
+}
+
+// ExportArrow is like Export(), but writes an Arrow IPC stream of typed
+// columns, readable by eg. pyarrow.ipc.open_stream().
+func (c *Client) ExportArrow(filter Filter, w io.Writer) error {
+	return c.export(filter, w, "arrow")
+}
+
+// ExportParquet is like Export(), but writes a Parquet file of typed columns.
+func (c *Client) ExportParquet(filter Filter, w io.Writer) error {
+	return c.export(filter, w, "parquet")
+}
+
+func (c *Client) export(filter Filter, w io.Writer, format string) error {
+	params := url.Values{}
+
+	if len(filter.Fields) > 0 {
+		params.Set("columns", strings.Join(filter.Fields, ","))
+	}
+
+	if format != "" {
+		params.Set("format", format)
+	}
+
+	body, err := c.post("/"+exportEndpoint, params.Encode(), filter.Query())
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	_, err = io.Copy(w, body)
+
+	return err
+}
+
+// Metrics writes the server's metrics, in the Prometheus text exposition
+// format, to the given writer.
+func (c *Client) Metrics(w io.Writer) error {
+	resp, err := c.do(http.MethodGet, c.base.JoinPath(metricsEndpoint), nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+
+	return err
+}
+
+// Status says how up to date a server's local database is.
+type Status struct {
+	// DataThrough is the latest day (YYYY-MM-DD, UTC) with complete data, or
+	// empty if there is none.
+	DataThrough string `json:"data_through"`
+
+	// DaysBehind is how many days before yesterday DataThrough is, or -1 if
+	// there's no data.
+	DaysBehind int `json:"days_behind"`
+}
+
+// Status returns the server's Status.
+func (c *Client) Status() (*Status, error) {
+	resp, err := c.do(http.MethodGet, c.base.JoinPath(statusEndpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	status := &Status{}
+
+	err = json.NewDecoder(resp.Body).Decode(status)
+
+	return status, err
+}
+
+// TeamDay is the total usage and waste of one team's (ACCOUNTING_NAME's) jobs
+// on one day.
+type TeamDay struct {
+	Day                string  `json:"day"`
+	AccountingName     string  `json:"accounting_name"`
+	Jobs               int64   `json:"jobs"`
+	CPUAvailSeconds    float64 `json:"cpu_avail_sec"`
+	CPUWastedSeconds   float64 `json:"cpu_wasted_sec"`
+	MemAvailMBSeconds  float64 `json:"mem_avail_mb_sec"`
+	MemWastedMBSeconds float64 `json:"mem_wasted_mb_sec"`
+	WastedCost         float64 `json:"wasted_cost"`
+}
+
+// TeamSummary has a TeamDay for every team with jobs in a BOM on each day from
+// From to To (inclusive, YYYY-MM-DD), sorted by day and then team.
+type TeamSummary struct {
+	BOM  string     `json:"bom"`
+	From string     `json:"from"`
+	To   string     `json:"to"`
+	Days []*TeamDay `json:"days"`
+}
+
+// TeamSummary returns the TeamSummary of the given BOM for the days from first
+// to last (inclusive).
+func (c *Client) TeamSummary(bom string, first, last time.Time) (*TeamSummary, error) {
+	summary := &TeamSummary{}
+
+	err := c.getReport(teamSummaryEndpoint, bom, first, last, summary)
+
+	return summary, err
+}
+
+// GPUUsage is the total usage and waste of a set of jobs in gpu queues, and
+// how many different users ran them.
+type GPUUsage struct {
+	Jobs               int64   `json:"jobs"`
+	Users              int     `json:"users"`
+	CPUAvailSeconds    float64 `json:"cpu_avail_sec"`
+	CPUWastedSeconds   float64 `json:"cpu_wasted_sec"`
+	MemAvailMBSeconds  float64 `json:"mem_avail_mb_sec"`
+	MemWastedMBSeconds float64 `json:"mem_wasted_mb_sec"`
+	RunTimeSeconds     float64 `json:"run_time_sec"`
+	WastedCost         float64 `json:"wasted_cost"`
+}
+
+// GPUDay is the GPUUsage of one day.
+type GPUDay struct {
+	Day string `json:"day"`
+	GPUUsage
+}
+
+// GPUReport has the GPUUsage of a BOM (or all BOMs) on each day from From to
+// To (inclusive, YYYY-MM-DD), their Total, and how many gpu jobs each user ran
+// over all those days.
+type GPUReport struct {
+	BOM      string           `json:"bom,omitempty"`
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Days     []*GPUDay        `json:"days"`
+	Total    GPUUsage         `json:"total"`
+	UserJobs map[string]int64 `json:"user_jobs"`
+}
+
+// GPUReport returns the GPUReport of the given BOM (or all BOMs, if blank) for
+// the days from first to last (inclusive).
+func (c *Client) GPUReport(bom string, first, last time.Time) (*GPUReport, error) {
+	report := &GPUReport{}
+
+	err := c.getReport(gpuReportEndpoint, bom, first, last, report)
+
+	return report, err
+}
+
+// getReport GETs the given report endpoint for the given BOM (if not blank)
+// and days, decoding the response in to v.
+func (c *Client) getReport(endpoint, bom string, first, last time.Time, v interface{}) error {
+	params := url.Values{
+		"from": {first.UTC().Format(time.DateOnly)},
+		"to":   {last.UTC().Format(time.DateOnly)},
+	}
+
+	if bom != "" {
+		params.Set("bom", bom)
+	}
+
+	u := c.base.JoinPath(endpoint)
+	u.RawQuery = params.Encode()
+
+	resp, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// IndexReadiness says how far a server has got loading one index's local
+// database.
+type IndexReadiness struct {
+	Ready      bool   `json:"ready"`
+	LoadedDays int    `json:"loaded_days"`
+	TotalDays  int    `json:"total_days"`
+	LoadedFrom string `json:"loaded_from,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Readiness says whether a server has finished loading its local databases.
+type Readiness struct {
+	// Ready is true once all days can be queried.
+	Ready bool `json:"ready"`
+
+	// Indexes holds the IndexReadiness of each index whose local database is
+	// loaded in the background, keyed on index name ("" for the default).
+	Indexes map[string]IndexReadiness `json:"indexes,omitempty"`
+}
+
+// Readiness returns the server's Readiness. A server that is still loading
+// is not an error.
+func (c *Client) Readiness() (*Readiness, error) {
+	resp, err := c.send(http.MethodGet, c.base.JoinPath(readyEndpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		msg, _ := io.ReadAll(resp.Body) //nolint:errcheck
+
+		return nil, Error{Msg: ErrBadStatus, cause: fmt.Sprintf("%s: %s", resp.Status, msg)}
+	}
+
+	readiness := &Readiness{}
+
+	err = json.NewDecoder(resp.Body).Decode(readiness)
+
+	return readiness, err
+}
+
+// Reload makes the server look for newly backfilled days right away, and empty
+// its cache, returning its new Status. Our credentials must be those of an
+// admin.
+func (c *Client) Reload() (*Status, error) {
+	return c.adminStatus(adminReloadEndpoint)
+}
+
+// ReloadConfig makes the server re-read its config file, picking up new
+// elasticsearch credentials, cache size and update frequency without a
+// restart, returning its Status. Our credentials must be those of an admin.
+func (c *Client) ReloadConfig() (*Status, error) {
+	return c.adminStatus(adminReloadConfigEndpoint)
+}
+
+// adminStatus POSTs to the given admin endpoint, returning the Status in the
+// response.
+func (c *Client) adminStatus(endpoint string) (*Status, error) {
+	resp, err := c.do(http.MethodPost, c.base.JoinPath(endpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	status := &Status{}
+
+	err = json.NewDecoder(resp.Body).Decode(status)
+
+	return status, err
+}
+
+// FlushCache makes the server empty its cache, returning how many cached
+// results were discarded. Our credentials must be those of an admin.
+func (c *Client) FlushCache() (int, error) {
+	resp, err := c.do(http.MethodPost, c.base.JoinPath(adminFlushCacheEndpoint), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	var result struct {
+		Flushed int `json:"flushed"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+
+	return result.Flushed, err
+}
+
+// SlowQueries returns details of the most recent queries that took the server
+// at least its slow query threshold to answer, newest first. Our credentials
+// must be those of an admin.
+func (c *Client) SlowQueries() ([]es.SlowQuery, error) {
+	resp, err := c.do(http.MethodGet, c.base.JoinPath(adminSlowQueriesEndpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var sqs []es.SlowQuery
+
+	err = json.NewDecoder(resp.Body).Decode(&sqs)
+
+	return sqs, err
+}
+
+// CacheStats returns details of the server's caches and the results in them,
+// keyed by index. Our credentials must be those of an admin.
+func (c *Client) CacheStats() (map[string]cache.Stats, error) {
+	resp, err := c.do(http.MethodGet, c.base.JoinPath(adminCacheEndpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var stats map[string]cache.Stats
+
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+
+	return stats, err
+}
+
+// BackfillJob describes a backfill started by Backfill().
+type BackfillJob struct {
+	ID string `json:"id"`
+
+	// State is "running", "succeeded" or "failed".
+	State     string          `json:"state"`
+	From      string          `json:"from"`
+	Period    string          `json:"period"`
+	DaysDone  int             `json:"days_done"`
+	DaysTotal int             `json:"days_total"`
+	Started   time.Time       `json:"started"`
+	Finished  *time.Time      `json:"finished,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Report    *BackfillReport `json:"report,omitempty"`
+}
+
+// BackfillReport says what a finished BackfillJob did with each day.
+type BackfillReport struct {
+	Days []struct {
+		Day      string  `json:"day"`
+		Status   string  `json:"status"`
+		Hits     int     `json:"hits"`
+		Attempts int     `json:"attempts"`
+		Seconds  float64 `json:"seconds"`
+		Error    string  `json:"error,omitempty"`
+	} `json:"days"`
+	Skipped   int `json:"skipped"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// Backfill makes the server start backfilling the given period (eg. "2d")
+// before the day of from, in the background, returning the new BackfillJob;
+// pass its ID to BackfillJob() to follow its progress. Our credentials must be
+// those of an admin.
+func (c *Client) Backfill(from time.Time, period string) (*BackfillJob, error) {
+	u := c.base.JoinPath(adminBackfillEndpoint)
+	u.RawQuery = url.Values{"from": {from.UTC().Format(backfillFromFormat)}, "period": {period}}.Encode()
+
+	return c.backfillJob(http.MethodPost, u)
+}
+
+// BackfillJob returns the current state of the BackfillJob with the given ID.
+// Our credentials must be those of an admin.
+func (c *Client) BackfillJob(id string) (*BackfillJob, error) {
+	return c.backfillJob(http.MethodGet, c.base.JoinPath(adminBackfillEndpoint, id))
+}
+
+// backfillJob makes a request to the given URL, returning the BackfillJob in
+// the response.
+func (c *Client) backfillJob(method string, u *url.URL) (*BackfillJob, error) {
+	resp, err := c.do(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	job := &BackfillJob{}
+
+	err = json.NewDecoder(resp.Body).Decode(job)
+
+	return job, err
+}
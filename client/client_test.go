@@ -0,0 +1,491 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/server"
+)
+
+type mockScroller struct {
+	*es.Mock
+	scrolls int
+}
+
+func (m *mockScroller) Scroll(query *es.Query) (*es.Result, error) {
+	m.scrolls++
+
+	return m.Mock.Scroll(query, nil)
+}
+
+type fixedDataSource time.Time
+
+func (f fixedDataSource) DataThrough() time.Time {
+	return time.Time(f)
+}
+
+func (f fixedDataSource) DataVersion() string {
+	return time.Time(f).Format(time.RFC3339)
+}
+
+func (f fixedDataSource) Reload() error {
+	return nil
+}
+
+func (f fixedDataSource) GPUReport(_ context.Context, _, bom string, first, last time.Time) (*db.GPUReport, error) {
+	return &db.GPUReport{
+		BOM:      bom,
+		From:     first.Format(time.DateOnly),
+		To:       last.Format(time.DateOnly),
+		Days:     []*db.GPUDay{{Day: time.Time(f).Format(time.DateOnly), GPUUsage: db.GPUUsage{Jobs: 1, Users: 1}}},
+		Total:    db.GPUUsage{Jobs: 1, Users: 1},
+		UserJobs: map[string]int64{"u": 1},
+	}, nil
+}
+
+func (f fixedDataSource) TeamSummary(_ context.Context, _, bom string, first, last time.Time) (*db.TeamSummary, error) {
+	return &db.TeamSummary{
+		BOM:  bom,
+		From: first.Format(time.DateOnly),
+		To:   last.Format(time.DateOnly),
+		Days: []*db.TeamDay{{Day: time.Time(f).Format(time.DateOnly), AccountingName: "groupA", Jobs: 1}},
+	}, nil
+}
+
+func (f fixedDataSource) Explain(query *es.Query) (*db.QueryPlan, error) {
+	bom := query.Filters()["BOM"]
+
+	return &db.QueryPlan{
+		BOM:        bom,
+		Dirs:       []db.DirPlan{{Dir: time.Time(f).Format("2006/01/02") + "/" + bom, IndexFiles: []string{"0.index"}}},
+		IndexFiles: 1,
+		IndexOnly:  true,
+	}, nil
+}
+
+func TestClient(t *testing.T) {
+	Convey("Given a farmer server", t, func() {
+		index := "some-indexes-*"
+		mock := &mockScroller{Mock: es.NewMock(index)}
+
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		s := server.New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		ts := httptest.NewServer(s)
+
+		defer ts.Close()
+
+		c, err := New(ts.URL, index)
+		So(err, ShouldBeNil)
+
+		from, err := time.Parse(time.RFC3339, "2024-05-03T15:00:00Z")
+		So(err, ShouldBeNil)
+
+		filter := Filter{From: from, To: from.Add(1 * time.Hour), BOM: "Human Genetics"}
+
+		Convey("You can turn a Filter in to a Query", func() {
+			query := filter.Query()
+
+			lt, _, gte, errd := query.DateRange()
+			So(errd, ShouldBeNil)
+			So(gte, ShouldEqual, from)
+			So(lt, ShouldEqual, filter.To)
+			So(query.Filters(), ShouldResemble, map[string]string{
+				"META_CLUSTER_NAME": "farm",
+				"BOM":               "Human Genetics",
+			})
+			So(query.Key(), ShouldEqual, filter.Query().Key())
+
+			filter.UserName = "u"
+			filter.GPUOnly = true
+
+			So(filter.Query().Filters(), ShouldResemble, map[string]string{
+				"META_CLUSTER_NAME": "farm",
+				"BOM":               "Human Genetics",
+				"USER_NAME":         "u",
+				"QUEUE_NAME":        "gpu",
+			})
+		})
+
+		Convey("You can Scroll() to get all hits", func() {
+			result, errs := c.Scroll(filter)
+			So(errs, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, 23581)
+		})
+
+		Convey("You can SetRefresh() to bypass cached results", func() {
+			_, errs := c.Scroll(filter)
+			So(errs, ShouldBeNil)
+			_, errs = c.Scroll(filter)
+			So(errs, ShouldBeNil)
+			So(mock.scrolls, ShouldEqual, 1)
+
+			c.SetRefresh(true)
+
+			result, errs := c.Scroll(filter)
+			So(errs, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, 23581)
+			So(mock.scrolls, ShouldEqual, 2)
+
+			c.SetRefresh(false)
+
+			_, errs = c.Scroll(filter)
+			So(errs, ShouldBeNil)
+			So(mock.scrolls, ShouldEqual, 2)
+		})
+
+		Convey("You can MultiScroll() several Filters at once", func() {
+			filter2 := filter
+			filter2.Fields = []string{"USER_NAME"}
+
+			results, errm := c.MultiScroll([]Filter{filter, filter2})
+			So(errm, ShouldBeNil)
+			So(len(results), ShouldEqual, 2)
+			So(len(results[0].HitSet.Hits), ShouldEqual, 23581)
+			So(len(results[1].HitSet.Hits), ShouldEqual, 2)
+		})
+
+		Convey("You can Count() hits", func() {
+			count, errc := c.Count(filter)
+			So(errc, ShouldBeNil)
+			So(count, ShouldEqual, 23581)
+		})
+
+		Convey("You can Search()", func() {
+			result, errs := c.Search(filter.Query())
+			So(errs, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, 10000)
+		})
+
+		Convey("You can MultiSearch() several queries at once", func() {
+			filter2 := filter
+			filter2.From = time.Date(2024, 5, 30, 0, 0, 0, 0, time.UTC)
+
+			results, errm := c.MultiSearch([]*es.Query{filter.Query(), filter2.Query()})
+			So(errm, ShouldBeNil)
+			So(len(results), ShouldEqual, 2)
+			So(len(results[0].HitSet.Hits), ShouldEqual, 10000)
+			So(len(results[1].HitSet.Hits), ShouldEqual, 1)
+
+			_, errm = multiSearchResults([]json.RawMessage{
+				json.RawMessage(`{"took":1,"status":200}`),
+				json.RawMessage(`{"error":{"type":"x","reason":"bad"},"status":500}`),
+			})
+			So(errm, ShouldNotBeNil)
+			So(errm.Error(), ShouldEqual, ErrSearchFailed+": bad")
+		})
+
+		Convey("You can get Usernames()", func() {
+			usernames, erru := c.Usernames(filter)
+			So(erru, ShouldBeNil)
+
+			sort.Strings(usernames)
+			So(usernames, ShouldResemble, []string{"u", "u1", "u2"})
+		})
+
+		Convey("You can get UsernameCounts() and AccountingNameCounts()", func() {
+			counts, errc := c.UsernameCounts(filter)
+			So(errc, ShouldBeNil)
+			So(len(counts), ShouldEqual, 3)
+			So(counts["u"]+counts["u1"]+counts["u2"], ShouldEqual, 23581)
+
+			counts, errc = c.AccountingNameCounts(filter)
+			So(errc, ShouldBeNil)
+			So(counts, ShouldResemble, map[string]int{"": 23581})
+		})
+
+		Convey("You can get AccountingNames() and BOMs()", func() {
+			names, errn := c.AccountingNames(filter)
+			So(errn, ShouldBeNil)
+			So(names, ShouldResemble, []string{""})
+
+			filter.BOM = ""
+
+			boms, errb := c.BOMs(filter)
+			So(errb, ShouldBeNil)
+			So(boms, ShouldResemble, []string{""})
+		})
+
+		Convey("You can Export() as CSV", func() {
+			filter.Fields = []string{"USER_NAME", "QUEUE_NAME"}
+
+			var b bytes.Buffer
+
+			erre := c.Export(filter, &b, false)
+			So(erre, ShouldBeNil)
+			So(b.String(), ShouldEqual, "USER_NAME,QUEUE_NAME\npathpipe,transfer\nu2,q2\n")
+
+			filter.Fields = []string{"foo"}
+			erre = c.Export(filter, &b, true)
+			So(erre, ShouldNotBeNil)
+			So(erre.Error(), ShouldStartWith, ErrBadStatus)
+			So(strings.Contains(erre.Error(), "400"), ShouldBeTrue)
+		})
+
+		Convey("You can ExportArrow() and ExportParquet()", func() {
+			filter.Fields = []string{"USER_NAME", "RUN_TIME_SEC"}
+
+			var b bytes.Buffer
+
+			erre := c.ExportArrow(filter, &b)
+			So(erre, ShouldBeNil)
+			So(b.Bytes()[:4], ShouldResemble, []byte{0xff, 0xff, 0xff, 0xff})
+			So(b.String(), ShouldContainSubstring, "RUN_TIME_SEC")
+
+			b.Reset()
+
+			erre = c.ExportParquet(filter, &b)
+			So(erre, ShouldBeNil)
+			So(b.String(), ShouldStartWith, "PAR1")
+			So(b.String(), ShouldEndWith, "PAR1")
+		})
+
+		Convey("You can supply credentials for a server with auth", func() {
+			s.SetAuth(server.Auth{Users: map[string]string{"u": "p"}, Tokens: []string{"t"}})
+
+			_, err = c.Usernames(filter)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "401")
+
+			c.SetBasicAuth("u", "p")
+
+			_, err = c.Usernames(filter)
+			So(err, ShouldBeNil)
+
+			c.SetToken("t")
+
+			err = c.Metrics(io.Discard)
+			So(err, ShouldBeNil)
+
+			c.SetToken("wrong")
+
+			_, err = c.Count(filter)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("You can get the server's Status()", func() {
+			status, errs := c.Status()
+			So(errs, ShouldBeNil)
+			So(status, ShouldResemble, &Status{DaysBehind: -1})
+
+			s.SetDataSource(fixedDataSource(from))
+
+			status, errs = c.Status()
+			So(errs, ShouldBeNil)
+			So(status.DataThrough, ShouldEqual, "2024-05-03")
+		})
+
+		Convey("You can Explain() a Filter's search", func() {
+			s.SetDataSource(fixedDataSource(from))
+
+			plan, erre := c.Explain(filter)
+			So(erre, ShouldBeNil)
+			So(plan.BOM, ShouldEqual, "Human Genetics")
+			So(plan.IndexFiles, ShouldEqual, 1)
+			So(plan.IndexOnly, ShouldBeTrue)
+			So(plan.Dirs, ShouldResemble, []DirPlan{{Dir: "2024/05/03/Human Genetics", IndexFiles: []string{"0.index"}}})
+		})
+
+		Convey("You can get a TeamSummary()", func() {
+			s.SetTeamSummarizer(fixedDataSource(from))
+
+			summary, errt := c.TeamSummary("bomA", from, from.Add(24*time.Hour))
+			So(errt, ShouldBeNil)
+			So(summary.BOM, ShouldEqual, "bomA")
+			So(summary.From, ShouldEqual, from.Format(time.DateOnly))
+			So(summary.To, ShouldEqual, from.Add(24*time.Hour).Format(time.DateOnly))
+			So(summary.Days, ShouldResemble, []*TeamDay{{Day: "2024-05-03", AccountingName: "groupA", Jobs: 1}})
+		})
+
+		Convey("You can get a GPUReport()", func() {
+			s.SetGPUReporter(fixedDataSource(from))
+
+			report, errg := c.GPUReport("", from, from)
+			So(errg, ShouldBeNil)
+			So(report.BOM, ShouldBeEmpty)
+			So(report.To, ShouldEqual, "2024-05-03")
+			So(report.Days, ShouldResemble, []*GPUDay{{Day: "2024-05-03", GPUUsage: GPUUsage{Jobs: 1, Users: 1}}})
+			So(report.Total.Jobs, ShouldEqual, 1)
+			So(report.UserJobs, ShouldResemble, map[string]int64{"u": 1})
+		})
+
+		Convey("You can get the server's Readiness()", func() {
+			readiness, errr := c.Readiness()
+			So(errr, ShouldBeNil)
+			So(readiness, ShouldResemble, &Readiness{Ready: true})
+		})
+
+		Convey("Admins can Reload(), ReloadConfig() and FlushCache()", func() {
+			s.SetAuth(server.Auth{Users: map[string]string{"u": "p", "a": "b"}, Admins: []string{"a"}})
+			s.SetDataSource(fixedDataSource(from))
+			s.SetReloader(fixedDataSource(from))
+
+			_, err = c.Scroll(filter)
+			So(err, ShouldNotBeNil)
+
+			c.SetBasicAuth("u", "p")
+
+			_, err = c.Scroll(filter)
+			So(err, ShouldBeNil)
+
+			_, err = c.FlushCache()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "403")
+
+			c.SetBasicAuth("a", "b")
+
+			flushed, errf := c.FlushCache()
+			So(errf, ShouldBeNil)
+			So(flushed, ShouldEqual, 1)
+
+			status, errr := c.Reload()
+			So(errr, ShouldBeNil)
+			So(status.DataThrough, ShouldEqual, "2024-05-03")
+
+			_, errr = c.ReloadConfig()
+			So(errr, ShouldNotBeNil)
+			So(errr.Error(), ShouldContainSubstring, "501")
+
+			reloaded := false
+			s.SetConfigReloader(func() error {
+				reloaded = true
+
+				return nil
+			})
+
+			status, errr = c.ReloadConfig()
+			So(errr, ShouldBeNil)
+			So(status.DataThrough, ShouldEqual, "2024-05-03")
+			So(reloaded, ShouldBeTrue)
+		})
+
+		Convey("Admins can get SlowQueries()", func() {
+			s.SetAuth(server.Auth{Users: map[string]string{"u": "p", "a": "b"}, Admins: []string{"a"}})
+			cq.SetSlowQueryThreshold(time.Nanosecond)
+
+			c.SetBasicAuth("u", "p")
+
+			_, err = c.Scroll(filter)
+			So(err, ShouldBeNil)
+
+			_, err = c.SlowQueries()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "403")
+
+			c.SetBasicAuth("a", "b")
+
+			sqs, errs := c.SlowQueries()
+			So(errs, ShouldBeNil)
+			So(len(sqs), ShouldEqual, 1)
+			So(sqs[0].Kind, ShouldEqual, "scroll")
+			So(sqs[0].Filters["BOM"], ShouldEqual, filter.BOM)
+		})
+
+		Convey("Admins can get CacheStats()", func() {
+			s.SetAuth(server.Auth{Users: map[string]string{"u": "p", "a": "b"}, Admins: []string{"a"}})
+			c.SetBasicAuth("u", "p")
+
+			_, err = c.Scroll(filter)
+			So(err, ShouldBeNil)
+
+			_, err = c.CacheStats()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "403")
+
+			c.SetBasicAuth("a", "b")
+
+			stats, errs := c.CacheStats()
+			So(errs, ShouldBeNil)
+			So(stats, ShouldContainKey, index)
+			So(len(stats[index].Partitions), ShouldEqual, 3)
+			So(stats[index].Partitions[1].Name, ShouldEqual, cache.PartitionScroll)
+			So(stats[index].Partitions[1].Misses, ShouldEqual, 1)
+			So(len(stats[index].Entries), ShouldEqual, 1)
+			So(stats[index].Entries[0].Kind, ShouldEqual, cache.PartitionScroll)
+			So(stats[index].Entries[0].Filters["BOM"], ShouldEqual, filter.BOM)
+			So(stats[index].Entries[0].GTE, ShouldEqual, filter.From.Format(time.RFC3339))
+		})
+
+		Convey("Admins can Backfill() and follow the BackfillJob()", func() {
+			s.SetAuth(server.Auth{AdminTokens: []string{"t"}})
+			s.SetBackfiller(func(_ time.Time, _ time.Duration, progress func(int, int)) (*db.BackfillReport, error) {
+				progress(1, 1)
+
+				return &db.BackfillReport{Succeeded: 1}, nil
+			})
+
+			c.SetToken("t")
+
+			job, errb := c.Backfill(from, "1d")
+			So(errb, ShouldBeNil)
+			So(job.ID, ShouldEqual, "1")
+			So(job.From, ShouldEqual, "2024-05-03")
+			So(job.Period, ShouldEqual, "1d")
+
+			for job.State == "running" {
+				time.Sleep(time.Millisecond)
+
+				job, errb = c.BackfillJob(job.ID)
+				So(errb, ShouldBeNil)
+			}
+
+			So(job.State, ShouldEqual, "succeeded")
+			So(job.DaysDone, ShouldEqual, 1)
+			So(job.Report.Succeeded, ShouldEqual, 1)
+
+			_, errb = c.BackfillJob("2")
+			So(errb, ShouldNotBeNil)
+		})
+
+		Convey("You can get the server's Metrics()", func() {
+			s.AddMetrics(mock.Metrics())
+
+			_, err = c.Search(filter.Query())
+			So(err, ShouldBeNil)
+
+			var b bytes.Buffer
+
+			err = c.Metrics(&b)
+			So(err, ShouldBeNil)
+			So(b.String(), ShouldContainSubstring, `farmer_elasticsearch_requests_total{op="search"} 1`)
+		})
+	})
+}
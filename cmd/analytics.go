@@ -0,0 +1,154 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/analytics"
+)
+
+const (
+	defaultAnalyticsTop  = 10
+	defaultAnalyticsDays = 7
+)
+
+var analyticsFrom string
+var analyticsTo string
+var analyticsTop int
+var analyticsJSON bool
+
+var analyticsCmd = &cobra.Command{
+	Use:   "analytics",
+	Short: "report on the queries a server has answered",
+	Long: `report on the queries a server has answered.
+
+Supply a -c config.yml (see root command help for details) with an
+analytics_file that a server has been recording queries in.
+
+Queries made from the --from day (default 7 days ago) to the --to day (default
+today) inclusive are summarised, printing:
+
+queries<tab>number<tab>total_ms<tab>total_bytes
+
+Then sections of the --top users (client identities, when the server has auth)
+and the top BOMs queried:
+
+users
+name<tab>queries<tab>total_ms<tab>total_bytes
+
+boms
+name<tab>queries<tab>total_ms<tab>total_bytes
+
+And the slowest query shapes:
+
+shapes
+shape<tab>queries<tab>mean_ms<tab>max_ms<tab>mean_bytes
+
+Query shapes are the kind of query (eg. scroll or search), the fields it
+filters on, and the number of days it covers, eg. "scroll BOM,USER_NAME 7d", so
+that you can see which kinds of query would most benefit from optimisation.
+
+With --json, the report is printed as JSON instead.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		config := ParseConfig()
+		if config.Farmer.AnalyticsFile == "" {
+			die("analytics_file isn't set in the config")
+		}
+
+		today := time.Now().UTC().Truncate(oneDay)
+		first := today.AddDate(0, 0, -defaultAnalyticsDays)
+		last := today
+
+		if analyticsFrom != "" {
+			first = parseBackfillDay(analyticsFrom)
+		}
+
+		if analyticsTo != "" {
+			last = parseBackfillDay(analyticsTo)
+		}
+
+		store, err := analytics.Open(config.Farmer.AnalyticsFile, config.Farmer.AnalyticsRetention)
+		if err != nil {
+			die("could not open analytics: %s", err)
+		}
+
+		defer store.Close()
+
+		report, err := store.Report(first, last.Add(oneDay), analyticsTop)
+		if err != nil {
+			die("analytics report failed: %s", err)
+		}
+
+		if analyticsJSON {
+			if err = report.WriteJSON(os.Stdout); err != nil {
+				die("could not write report: %s", err)
+			}
+
+			return
+		}
+
+		printAnalyticsReport(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(analyticsCmd)
+
+	// flags specific to this sub-command
+	analyticsCmd.Flags().StringVar(&analyticsFrom, "from", "",
+		"first day (YYYY-MM-DD) to report on (default 7 days ago)")
+	analyticsCmd.Flags().StringVar(&analyticsTo, "to", "",
+		"last day (YYYY-MM-DD) to report on (default today)")
+	analyticsCmd.Flags().IntVar(&analyticsTop, "top", defaultAnalyticsTop,
+		"how many users, BOMs and query shapes to report")
+	analyticsCmd.Flags().BoolVar(&analyticsJSON, "json", false,
+		"print the report as JSON")
+}
+
+func printAnalyticsReport(report *analytics.Report) {
+	cliPrint("queries\t%d\t%.0f\t%d\n", report.Queries, report.TotalMS, report.TotalBytes)
+
+	for _, section := range []struct {
+		name   string
+		usages []analytics.Usage
+	}{{"users", report.TopUsers}, {"boms", report.TopBOMs}} {
+		cliPrint("\n%s\n", section.name)
+
+		for _, u := range section.usages {
+			cliPrint("%s\t%d\t%.0f\t%d\n", u.Name, u.Queries, u.TotalMS, u.TotalBytes)
+		}
+	}
+
+	cliPrint("\nshapes\n")
+
+	for _, s := range report.SlowestShapes {
+		cliPrint("%s\t%d\t%.1f\t%.1f\t%.0f\n", s.Shape, s.Queries, s.MeanMS, s.MaxMS, s.MeanBytes)
+	}
+}
@@ -28,10 +28,8 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"regexp"
 	"runtime"
 	"runtime/pprof"
-	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -40,16 +38,22 @@ import (
 )
 
 const (
-	hoursInDay   = 24
-	hoursInWeek  = hoursInDay * 7
-	hoursInMonth = 730
-	hoursInYear  = 8760
+	backfillDayFormat = "2006-01-02"
 
 	profileFrequency = 10 * time.Second
 )
 
 var backfillPeriod string
 var backfillPprof string
+var backfillStrict bool
+var backfillSkipBadHits bool
+var backfillHourly bool
+var backfillFrom string
+var backfillTo string
+var backfillForce bool
+var backfillVerifyCounts bool
+var backfillReport string
+var backfillIndex string
 
 var backfillCmd = &cobra.Command{
 	Use:   "backfill",
@@ -69,10 +73,51 @@ farmer backfill -p 2d
 
 every day, to cover yourself if it failed on one day for example, or you forgot
 to run it.
+
+With --strict, the hits will also be inspected for _source fields that aren't
+part of our schema (and so would not be stored in the database). Each unknown
+field is warned about with a sample value the first time it is seen, and a
+summary of how often each was seen is given at the end.
+
+By default, the backfill of a day fails if any of its hits can't be stored, eg.
+because its ACCOUNTING_NAME is longer than the configured
+accounting_name_width. With --skip-bad-hits, such hits are instead skipped and
+warned about, and a summary of them is given at the end.
+
+To backfill specific days instead of a --period, give --from and --to dates
+(inclusive, YYYY-MM-DD, UTC), eg. to fill in the days of an elastic search
+outage:
+
+farmer backfill --from 2024-03-10 --to 2024-03-14
+
+--to defaults to yesterday.
+
+Days already backfilled are normally skipped. If the elastic search data for
+some days was corrected after you backfilled them, add --force to --from and
+--to to fetch and store those days again, replacing what was stored.
+
+With --verify-counts, after storing each day elastic search is asked to count
+the day's hits, and a warning is given if that doesn't match the number we
+stored. The counts are recorded so that "farmer verify" can report mismatches
+later.
+
+With --hourly, --period is ignored and instead each hour of today (UTC) that has
+finished is stored, skipping hours already stored. Run this every hour to make
+today's hits queryable by the server soon after each hour completes, and keep
+running the normal daily backfill, which replaces the hours with the complete
+day.
+
+With --report, a JSON report of what happened to each day (skipped, succeeded
+or failed, with its number of hits, how long it took and any error) is written
+to the given file, even if the backfill fails, eg. for cron monitors. A summary
+of the day counts is always logged at the end. (Not used with --hourly.)
+
+If your config has farms, backfill one of them by giving its index with
+--index; without it, the elastic index is backfilled in to the farmer
+database_dir as usual.
 `,
 	Run: func(_ *cobra.Command, _ []string) {
-		config := ParseConfig()
-		period := parsePeriod(backfillPeriod)
+		config := ParseFarmConfig(backfillIndex)
 
 		client, err := es.NewClient(config.ToESConfig())
 		if err != nil {
@@ -83,14 +128,45 @@ to run it.
 			go profileBackfillMem(backfillPprof)
 		}
 
+		var unknownFields *es.UnknownFields
+
+		if backfillStrict {
+			unknownFields = client.WatchForUnknownFields()
+		}
+
 		t := time.Now()
 
-		err = db.Backfill(client, config.ToDBConfig(), t, period)
+		dbConfig := config.ToDBConfig()
+		dbConfig.SkipBadHits = backfillSkipBadHits
+		dbConfig.VerifyCounts = dbConfig.VerifyCounts || backfillVerifyCounts
+
+		var report *db.BackfillReport
+
+		switch {
+		case backfillHourly:
+			err = db.BackfillHours(client, dbConfig, t)
+		case backfillForce:
+			report, err = db.Refill(client, dbConfig, backfillDays(t)...)
+		case backfillFrom != "":
+			report, err = db.BackfillRange(client, dbConfig, parseBackfillDay(backfillFrom),
+				parseBackfillDayOrYesterday(backfillTo, t))
+		default:
+			report, err = db.Backfill(client, dbConfig, t, parsePeriod(backfillPeriod))
+		}
+
+		if report != nil {
+			summariseBackfillReport(report, backfillReport)
+		}
+
 		if err != nil {
 			die("backfill failed: %s", err)
 		}
 
 		info("overall: %s", time.Since(t))
+
+		if unknownFields != nil {
+			reportUnknownFields(unknownFields)
+		}
 	},
 }
 
@@ -102,37 +178,120 @@ func init() {
 		"period of time to pull hits for, eg. 1h for 1 hour, 2d for 2 day, 3w for 3 weeks, 4m for 4 months and 5y for 5 years") //nolint:lll
 	backfillCmd.Flags().StringVar(&backfillPprof, "pprof", "",
 		"output profiling data to files with the given prefix path")
+	backfillCmd.Flags().BoolVar(&backfillStrict, "strict", false,
+		"report hit fields that are not part of the schema")
+	backfillCmd.Flags().BoolVar(&backfillSkipBadHits, "skip-bad-hits", false,
+		"skip and report hits that can't be stored, instead of failing")
+	backfillCmd.Flags().BoolVar(&backfillHourly, "hourly", false,
+		"store today's finished hours instead of whole days")
+	backfillCmd.Flags().StringVar(&backfillFrom, "from", "",
+		"first day (YYYY-MM-DD) to backfill, instead of using --period")
+	backfillCmd.Flags().StringVar(&backfillTo, "to", "",
+		"last day (YYYY-MM-DD) to backfill with --from (default yesterday)")
+	backfillCmd.Flags().BoolVar(&backfillForce, "force", false,
+		"with --from, fetch and store days again even if already backfilled")
+	backfillCmd.Flags().BoolVar(&backfillVerifyCounts, "verify-counts", false,
+		"compare elastic search's count of each day's hits with the number stored")
+	backfillCmd.Flags().StringVar(&backfillIndex, "index", "",
+		"backfill the farm with this index instead of the elastic index")
+	backfillCmd.Flags().StringVar(&backfillReport, "report", "",
+		"write a JSON report of each day's outcome to this file")
 }
 
 func parsePeriod(periodStr string) time.Duration {
-	durationRegex := regexp.MustCompile("[0-9]+[hdwmy]")
+	d, err := db.ParsePeriod(periodStr)
+	if err != nil {
+		die("%s", err)
+	}
 
-	periodStr = durationRegex.ReplaceAllStringFunc(periodStr, func(d string) string {
-		num, err := strconv.ParseInt(d[:len(d)-1], 10, 64)
-		if err != nil {
-			return d
-		}
+	return d
+}
+
+func parseBackfillDay(day string) time.Time {
+	t, err := time.Parse(backfillDayFormat, day)
+	if err != nil {
+		die("invalid day: %s", err)
+	}
 
-		switch d[len(d)-1] {
-		case 'd':
-			num *= hoursInDay
-		case 'w':
-			num *= hoursInWeek
-		case 'm':
-			num *= hoursInMonth
-		case 'y':
-			num *= hoursInYear
+	return t
+}
+
+// parseBackfillDayOrYesterday is like parseBackfillDay(), but returns the day
+// before now if day is blank.
+func parseBackfillDayOrYesterday(day string, now time.Time) time.Time {
+	if day == "" {
+		return now.UTC().AddDate(0, 0, -1)
+	}
+
+	return parseBackfillDay(day)
+}
+
+// backfillDays returns each day from --from to --to, dying if --from wasn't
+// supplied.
+func backfillDays(now time.Time) []time.Time {
+	if backfillFrom == "" {
+		die("--force requires --from")
+	}
+
+	first := parseBackfillDay(backfillFrom)
+	last := parseBackfillDayOrYesterday(backfillTo, now)
+
+	var days []time.Time
+
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	return days
+}
+
+// summariseBackfillReport logs the totals of the given report and warns about
+// each failed day, and writes the report as JSON to the given path, if not
+// blank.
+func summariseBackfillReport(report *db.BackfillReport, path string) {
+	for _, day := range report.Days {
+		if day.Status == db.DayFailed {
+			warn("day %s failed: %s", day.Day, day.Error)
 		}
+	}
 
-		return strconv.FormatInt(num, 10) + "h"
-	})
+	info("days: %d succeeded, %d skipped, %d failed", report.Succeeded, report.Skipped, report.Failed)
 
-	d, err := time.ParseDuration(periodStr)
+	if path == "" {
+		return
+	}
+
+	if err := writeBackfillReport(report, path); err != nil {
+		warn("failed to write report: %s", err)
+	}
+}
+
+func writeBackfillReport(report *db.BackfillReport, path string) error {
+	f, err := os.Create(path)
 	if err != nil {
-		die("invalid period: %s", err)
+		return err
 	}
 
-	return d
+	if err = report.WriteJSON(f); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+func reportUnknownFields(unknownFields *es.UnknownFields) {
+	fields := unknownFields.Fields()
+	if len(fields) == 0 {
+		info("no unknown fields seen")
+
+		return
+	}
+
+	for _, field := range fields {
+		warn("unknown field %s seen in %d hits, eg. %s", field.Name, field.Count, field.Sample)
+	}
 }
 
 func profileBackfillMem(prefix string) {
@@ -26,37 +26,151 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/wtsi-hgi/go-farmer/cache"
 	"github.com/wtsi-hgi/go-farmer/db"
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/farmer"
+	"github.com/wtsi-hgi/go-farmer/server"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	defaultCacheEntries = 128
+	defaultBackfillPeriod   = "2d"
+	backfillAtFormat        = "15:04"
+	defaultAccessLogMaxMB   = 100
+	defaultAccessLogBackups = 5
+	accessLogStderr         = "-"
+	bytesPerMB              = 1024 * 1024
+	envFileSuffix           = "_FILE"
+	envListSeparators       = ",\n"
+	envUserPasswordSep      = ":"
+	defaultFarmerURLHost    = "localhost"
 )
 
 type YAMLConfig struct {
 	Elastic struct {
-		Host     string
-		Username string
-		Password string
-		Scheme   string
-		Port     int
-		Index    string
+		Host              string
+		Username          string
+		Password          string
+		Scheme            string
+		Port              int
+		Addresses         []string
+		Index             string
+		RequestsPerSecond float64 `yaml:"requests_per_second"`
+		ScrollSlices      int     `yaml:"scroll_slices"`
+		UsePIT            bool    `yaml:"use_pit"`
+		Flavor            string
+		APIKey            string `yaml:"api_key"`
+		ServiceToken      string `yaml:"service_token"`
+		CACert            string `yaml:"ca_cert"`
+		InsecureSkip      bool   `yaml:"insecure_skip_verify"`
+		ClientCert        string `yaml:"client_cert"`
+		ClientKey         string `yaml:"client_key"`
+
+		RequestTimeout   time.Duration `yaml:"request_timeout"`
+		MaxRetries       int           `yaml:"max_retries"`
+		RetryBackoff     time.Duration `yaml:"retry_backoff"`
+		BreakerThreshold int           `yaml:"breaker_threshold"`
+		BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
 	}
 	Farmer struct {
 		Host         string
 		Port         int
-		DatabaseDir  string `yaml:"database_dir"`
-		FileSize     int    `yaml:"file_size"`
-		BufferSize   int    `yaml:"buffer_size"`
-		CacheEntries int    `yaml:"cache_entries"`
-		PoolSize     int    `yaml:"pool_size"`
+		Listen       string
+		TLSCert      string `yaml:"tls_cert"`
+		TLSKey       string `yaml:"tls_key"`
+		TLSReload    bool   `yaml:"tls_reload"`
+		DisableHTTP2 bool   `yaml:"disable_http2"`
+		AdminListen  string `yaml:"admin_listen"`
+		GRPCListen   string `yaml:"grpc_listen"`
+
+		AuthUsers       map[string]string `yaml:"auth_users"`
+		AuthTokens      []string          `yaml:"auth_tokens"`
+		AuthExemptPaths []string          `yaml:"auth_exempt_paths"`
+		AuthAdmins      []string          `yaml:"auth_admins"`
+		AuthAdminTokens []string          `yaml:"auth_admin_tokens"`
+
+		RateLimitPerSecond  float64 `yaml:"rate_limit_per_second"`
+		RateLimitBurst      int     `yaml:"rate_limit_burst"`
+		RateLimitConcurrent int     `yaml:"rate_limit_concurrent"`
+
+		AccessLog        string `yaml:"access_log"`
+		AccessLogMaxMB   int    `yaml:"access_log_max_mb"`
+		AccessLogBackups int    `yaml:"access_log_backups"`
+
+		AnalyticsFile      string        `yaml:"analytics_file"`
+		AnalyticsRetention time.Duration `yaml:"analytics_retention"`
+
+		Backend       string
+		DatabaseDir   string        `yaml:"database_dir"`
+		DatabaseDirs  []string      `yaml:"database_dirs"`
+		FileSize      int           `yaml:"file_size"`
+		BufferSize    int           `yaml:"buffer_size"`
+		CacheEntries  int           `yaml:"cache_entries"`
+		CacheSearch   int           `yaml:"cache_search_entries"`
+		CacheScroll   int           `yaml:"cache_scroll_entries"`
+		CacheDistinct int           `yaml:"cache_distinct_entries"`
+		CacheRefresh  time.Duration `yaml:"cache_revalidate_age"`
+		StreamMinHits int           `yaml:"stream_min_hits"`
+		ScrollPaging  bool          `yaml:"scroll_paging"`
+		Dashboard     bool          `yaml:"dashboard"`
+		DashboardOpen bool          `yaml:"dashboard_open"`
+		PoolSize      int           `yaml:"pool_size"`
+		MaxPoolBytes  int           `yaml:"max_pool_bytes"`
+		PoolIdle      time.Duration `yaml:"pool_idle_timeout"`
+		LazyLoadDirs  int           `yaml:"lazy_load_dirs"`
+		IndexLoads    int           `yaml:"max_simultaneous_index_loads"`
+		QueryTimeout  time.Duration `yaml:"query_timeout"`
+		UpdateFreq    time.Duration `yaml:"update_frequency"`
+		ReadOnly      bool          `yaml:"read_only"`
+		SlowQuery     time.Duration `yaml:"slow_query_threshold"`
+		MaxScrolls    int           `yaml:"max_simultaneous_scrolls"`
+		MaxHits       int           `yaml:"max_hits"`
+		MaxBytes      int           `yaml:"max_bytes"`
+		MaxOpenFiles  int           `yaml:"max_open_files"`
+		VerifyReads   bool          `yaml:"verify_reads"`
+		SkipBOMs      []string      `yaml:"skip_boms"`
+		OnlyBOMs      []string      `yaml:"only_boms"`
+		Clusters      []string      `yaml:"clusters"`
+		Columnar      []string      `yaml:"columnar_fields"`
+		JobPrefixes   bool          `yaml:"job_prefix_index"`
+		MMap          bool          `yaml:"mmap"`
+		AsyncLoad     bool          `yaml:"background_load"`
+
+		BackfillAt         string        `yaml:"backfill_at"`
+		BackfillPeriod     string        `yaml:"backfill_period"`
+		MaxBackfills       int           `yaml:"max_simultaneous_backfills"`
+		VerifyCounts       bool          `yaml:"verify_counts"`
+		BackfillRetries    int           `yaml:"backfill_retries"`
+		BackfillRetryDelay time.Duration `yaml:"backfill_retry_delay"`
+
+		AccountingNameWidth int `yaml:"accounting_name_width"`
+		UserNameWidth       int `yaml:"user_name_width"`
+		QueueNameWidth      int `yaml:"queue_name_width"`
+	}
+	Farms []struct {
+		Index        string
+		DatabaseDir  string   `yaml:"database_dir"`
+		DatabaseDirs []string `yaml:"database_dirs"`
+	}
+	S3 struct {
+		Endpoint        string
+		Bucket          string
+		Prefix          string
+		AccessKeyID     string `yaml:"access_key_id"`
+		SecretAccessKey string `yaml:"secret_access_key"`
+		Region          string
+		UseSSL          bool `yaml:"use_ssl"`
 	}
 }
 
@@ -65,19 +179,210 @@ func ParseConfig() *YAMLConfig {
 		die("you must supply a config file with -c")
 	}
 
-	data, err := os.ReadFile(configPath)
+	c, err := LoadConfig(configPath)
 	if err != nil {
-		die("missing config file: %s", err)
+		die("%s", err)
+	}
+
+	return c
+}
+
+// ParseFarmConfig is like ParseConfig(), but returns the ForIndex() config of
+// the given index.
+func ParseFarmConfig(index string) *YAMLConfig {
+	c, err := ParseConfig().ForIndex(index)
+	if err != nil {
+		die("%s", err)
+	}
+
+	return c
+}
+
+// LoadConfig reads and parses the config file at the given path.
+func LoadConfig(path string) (*YAMLConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("missing config file: %w", err)
 	}
 
 	c := &YAMLConfig{}
 
 	err = yaml.Unmarshal(data, &c)
 	if err != nil {
-		die("invalid config file: %s", err)
+		return nil, fmt.Errorf("invalid config file: %w", err)
 	}
 
-	return c
+	if err = c.applyEnvOverrides(); err != nil {
+		return nil, fmt.Errorf("invalid config override: %w", err)
+	}
+
+	return c, nil
+}
+
+// envOverrides returns the environment variable names that can override
+// settings in the config file, mapped to those settings.
+func (c *YAMLConfig) envOverrides() map[string]*string {
+	return map[string]*string{
+		"ELASTIC_HOST":          &c.Elastic.Host,
+		"ELASTIC_USERNAME":      &c.Elastic.Username,
+		"ELASTIC_PASSWORD":      &c.Elastic.Password,
+		"ELASTIC_API_KEY":       &c.Elastic.APIKey,
+		"ELASTIC_SERVICE_TOKEN": &c.Elastic.ServiceToken,
+		"S3_ACCESS_KEY_ID":      &c.S3.AccessKeyID,
+		"S3_SECRET_ACCESS_KEY":  &c.S3.SecretAccessKey,
+	}
+}
+
+// envListOverrides returns the environment variable names that can override
+// list settings in the config file, mapped to those settings.
+func (c *YAMLConfig) envListOverrides() map[string]*[]string {
+	return map[string]*[]string{
+		"FARMER_AUTH_TOKENS":       &c.Farmer.AuthTokens,
+		"FARMER_AUTH_ADMIN_TOKENS": &c.Farmer.AuthAdminTokens,
+	}
+}
+
+// applyEnvOverrides replaces config file settings with the values of any set
+// envOverrides() environment variables, or with the contents of the files named
+// by the same variables suffixed with _FILE (such as docker or kubernetes
+// secrets), minus any trailing newline.
+//
+// envListOverrides() variables likewise replace list settings with their comma
+// or newline separated values, and FARMER_AUTH_USERS adds to (or replaces the
+// passwords of) auth_users with its comma or newline separated
+// "user:password" values.
+func (c *YAMLConfig) applyEnvOverrides() error {
+	for name, setting := range c.envOverrides() {
+		value, err := envOrFile(name)
+		if err != nil {
+			return err
+		}
+
+		if value != "" {
+			*setting = value
+		}
+	}
+
+	for name, setting := range c.envListOverrides() {
+		value, err := envOrFile(name)
+		if err != nil {
+			return err
+		}
+
+		if value != "" {
+			*setting = splitEnvList(value)
+		}
+	}
+
+	return c.applyEnvAuthUsers()
+}
+
+// applyEnvAuthUsers adds the "user:password" values of FARMER_AUTH_USERS (or
+// FARMER_AUTH_USERS_FILE) to our auth_users.
+func (c *YAMLConfig) applyEnvAuthUsers() error {
+	const name = "FARMER_AUTH_USERS"
+
+	value, err := envOrFile(name)
+	if err != nil || value == "" {
+		return err
+	}
+
+	users := splitEnvList(value)
+
+	if c.Farmer.AuthUsers == nil {
+		c.Farmer.AuthUsers = make(map[string]string, len(users))
+	}
+
+	for i, userPassword := range users {
+		user, password, found := strings.Cut(userPassword, envUserPasswordSep)
+		if !found || user == "" {
+			return fmt.Errorf("%s: value %d is not user:password", name, i+1)
+		}
+
+		c.Farmer.AuthUsers[user] = password
+	}
+
+	return nil
+}
+
+// splitEnvList splits the given environment variable value on commas and
+// newlines, ignoring surrounding whitespace and empty values.
+func splitEnvList(value string) []string {
+	var values []string
+
+	for _, v := range strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(envListSeparators, r)
+	}) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// envOrFile returns the value of the named environment variable, or the
+// contents of the file named by name_FILE. It's an error to set both.
+func envOrFile(name string) (string, error) {
+	value := os.Getenv(name)
+	path := os.Getenv(name + envFileSuffix)
+
+	if path == "" {
+		return value, nil
+	}
+
+	if value != "" {
+		return "", fmt.Errorf("both %s and %s%s are set", name, name, envFileSuffix)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s%s: %w", name, envFileSuffix, err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ForIndex returns this config if index is blank or our elastic index.
+// Otherwise, if index is that of one of our farms, returns a copy of this
+// config with that index and the farm's database_dir and database_dirs, and no
+// farms.
+func (c *YAMLConfig) ForIndex(index string) (*YAMLConfig, error) {
+	if index == "" || index == c.Elastic.Index {
+		return c, nil
+	}
+
+	for _, farm := range c.Farms {
+		if farm.Index != index {
+			continue
+		}
+
+		fc := *c
+		fc.Elastic.Index = farm.Index
+		fc.Farmer.DatabaseDir = farm.DatabaseDir
+		fc.Farmer.DatabaseDirs = farm.DatabaseDirs
+		fc.Farms = nil
+
+		return &fc, nil
+	}
+
+	return nil, fmt.Errorf("index %q is not configured", index)
+}
+
+// FarmConfigs returns the ForIndex() config of each of our farms.
+func (c *YAMLConfig) FarmConfigs() []*YAMLConfig {
+	configs := make([]*YAMLConfig, 0, len(c.Farms))
+
+	for _, farm := range c.Farms {
+		fc, err := c.ForIndex(farm.Index)
+		if err != nil {
+			continue
+		}
+
+		configs = append(configs, fc)
+	}
+
+	return configs
 }
 
 func (c *YAMLConfig) ToESConfig() es.Config {
@@ -88,16 +393,139 @@ func (c *YAMLConfig) ToESConfig() es.Config {
 		Username: c.Elastic.Username,
 		Password: c.Elastic.Password,
 		Index:    c.Elastic.Index,
+
+		Addresses: c.Elastic.Addresses,
+
+		RequestsPerSecond: c.Elastic.RequestsPerSecond,
+		ScrollSlices:      c.Elastic.ScrollSlices,
+		UsePIT:            c.Elastic.UsePIT,
+		Flavor:            c.Elastic.Flavor,
+
+		APIKey:             c.Elastic.APIKey,
+		ServiceToken:       c.Elastic.ServiceToken,
+		CACert:             c.Elastic.CACert,
+		InsecureSkipVerify: c.Elastic.InsecureSkip,
+		ClientCert:         c.Elastic.ClientCert,
+		ClientKey:          c.Elastic.ClientKey,
+
+		RequestTimeout:   c.Elastic.RequestTimeout,
+		MaxRetries:       c.Elastic.MaxRetries,
+		RetryBackoff:     c.Elastic.RetryBackoff,
+		BreakerThreshold: c.Elastic.BreakerThreshold,
+		BreakerCooldown:  c.Elastic.BreakerCooldown,
 	}
 }
 
+// ToDBConfig returns our database config, exiting if it isn't valid.
 func (c *YAMLConfig) ToDBConfig() db.Config {
+	config, err := c.dbConfig()
+	if err != nil {
+		die("%s", err)
+	}
+
+	return config
+}
+
+// dbConfig returns our database config, or an error if our s3 config is
+// invalid.
+func (c *YAMLConfig) dbConfig() (db.Config, error) {
+	store, err := c.objectStore()
+	if err != nil {
+		return db.Config{}, err
+	}
+
 	return db.Config{
-		Directory:  c.Farmer.DatabaseDir,
-		FileSize:   c.Farmer.FileSize,
-		BufferSize: c.Farmer.BufferSize,
-		PoolSize:   c.Farmer.PoolSize,
+		Directory:       c.Farmer.DatabaseDir,
+		Backend:         c.Farmer.Backend,
+		FileSize:        c.Farmer.FileSize,
+		BufferSize:      c.Farmer.BufferSize,
+		PoolSize:        c.Farmer.PoolSize,
+		MaxPoolBytes:    c.Farmer.MaxPoolBytes,
+		PoolIdleTimeout: c.Farmer.PoolIdle,
+		LazyLoadDirs:    c.Farmer.LazyLoadDirs,
+
+		UpdateFrequency: c.Farmer.UpdateFreq,
+		ReadOnly:        c.Farmer.ReadOnly,
+
+		MaxSimultaneousScrolls: c.Farmer.MaxScrolls,
+		MaxHits:                c.Farmer.MaxHits,
+		MaxBytes:               c.Farmer.MaxBytes,
+		MaxOpenFiles:           c.Farmer.MaxOpenFiles,
+		VerifyReads:            c.Farmer.VerifyReads,
+		SkipBOMs:               c.Farmer.SkipBOMs,
+		OnlyBOMs:               c.Farmer.OnlyBOMs,
+		Clusters:               c.Farmer.Clusters,
+		ColumnarFields:         c.Farmer.Columnar,
+		JobPrefixIndex:         c.Farmer.JobPrefixes,
+		MMap:                   c.Farmer.MMap,
+		BackgroundLoad:         c.Farmer.AsyncLoad,
+
+		MaxSimultaneousBackfills:  c.Farmer.MaxBackfills,
+		MaxSimultaneousIndexLoads: c.Farmer.IndexLoads,
+		VerifyCounts:              c.Farmer.VerifyCounts,
+		BackfillRetries:           c.Farmer.BackfillRetries,
+		BackfillRetryDelay:        c.Farmer.BackfillRetryDelay,
+
+		IndexWidths: db.IndexWidths{
+			AccountingName: c.Farmer.AccountingNameWidth,
+			UserName:       c.Farmer.UserNameWidth,
+			QueueName:      c.Farmer.QueueNameWidth,
+		},
+
+		ExtraDirectories: c.Farmer.DatabaseDirs,
+		ObjectStore:      store,
+	}, nil
+}
+
+// objectStore returns an S3Store if an s3 bucket was configured, or nil.
+func (c *YAMLConfig) objectStore() (db.ObjectStore, error) {
+	if c.S3.Bucket == "" {
+		return nil, nil //nolint:nilnil
 	}
+
+	store, err := db.NewS3Store(db.S3Config{
+		Endpoint:        c.S3.Endpoint,
+		Bucket:          c.S3.Bucket,
+		Prefix:          c.S3.Prefix,
+		AccessKeyID:     c.S3.AccessKeyID,
+		SecretAccessKey: c.S3.SecretAccessKey,
+		Region:          c.S3.Region,
+		UseSSL:          c.S3.UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 config: %w", err)
+	}
+
+	return store, nil
+}
+
+// BackfillSchedule returns the configured backfill_at time of day as a duration
+// after midnight, and the configured backfill_period (default 2d). Returns nil
+// if no backfill_at was configured.
+func (c *YAMLConfig) BackfillSchedule() (*farmer.BackfillSchedule, error) {
+	if c.Farmer.BackfillAt == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	at, err := time.Parse(backfillAtFormat, c.Farmer.BackfillAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backfill_at: %w", err)
+	}
+
+	period := c.Farmer.BackfillPeriod
+	if period == "" {
+		period = defaultBackfillPeriod
+	}
+
+	d, err := db.ParsePeriod(period)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backfill_period: %w", err)
+	}
+
+	return &farmer.BackfillSchedule{
+		At:     time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute,
+		Period: d,
+	}, nil
 }
 
 func (c *YAMLConfig) CacheEntries() int {
@@ -105,16 +533,156 @@ func (c *YAMLConfig) CacheEntries() int {
 		return c.Farmer.CacheEntries
 	}
 
-	return defaultCacheEntries
+	return farmer.DefaultCacheEntries
 }
 
-func (c *YAMLConfig) ElasticURL() *url.URL {
-	return &url.URL{
-		Host:   net.JoinHostPort(c.Elastic.Host, strconv.Itoa(c.Elastic.Port)),
-		Scheme: c.Elastic.Scheme,
+// CacheSizes returns the configured number of entries in each of the separate
+// caches for searches, scrolls and distinct values, each defaulting to
+// CacheEntries().
+func (c *YAMLConfig) CacheSizes() cache.Sizes {
+	entries := c.CacheEntries()
+
+	orDefault := func(n int) int {
+		if n > 0 {
+			return n
+		}
+
+		return entries
+	}
+
+	return cache.Sizes{
+		Search:   orDefault(c.Farmer.CacheSearch),
+		Scroll:   orDefault(c.Farmer.CacheScroll),
+		Distinct: orDefault(c.Farmer.CacheDistinct),
 	}
 }
 
+// ElasticURLs returns the URLs of the configured elastic addresses, or if none,
+// the URL of the configured elastic scheme, host and port.
+func (c *YAMLConfig) ElasticURLs() ([]*url.URL, error) {
+	return farmer.ElasticURLs(c.ToESConfig())
+}
+
+// ServerAuth returns the credentials clients of our server must supply.
+func (c *YAMLConfig) ServerAuth() server.Auth {
+	return server.Auth{
+		Users:       c.Farmer.AuthUsers,
+		Tokens:      c.Farmer.AuthTokens,
+		ExemptPaths: c.Farmer.AuthExemptPaths,
+		Admins:      c.Farmer.AuthAdmins,
+		AdminTokens: c.Farmer.AuthAdminTokens,
+	}
+}
+
+// ServerRateLimit returns the per-client limits on requests to our server.
+func (c *YAMLConfig) ServerRateLimit() server.RateLimit {
+	return server.RateLimit{
+		PerSecond:     c.Farmer.RateLimitPerSecond,
+		Burst:         c.Farmer.RateLimitBurst,
+		MaxConcurrent: c.Farmer.RateLimitConcurrent,
+	}
+}
+
+// AccessLogger returns a Logger for our server's access log, writing JSON to
+// STDERR if access_log is "-", or to a RotatingFile at the access_log path,
+// which you should Close() when done. Returns a nil Logger and Closer if
+// access_log isn't set.
+func (c *YAMLConfig) AccessLogger() (*slog.Logger, io.Closer, error) {
+	switch c.Farmer.AccessLog {
+	case "":
+		return nil, nil, nil
+	case accessLogStderr:
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil, nil
+	}
+
+	maxMB := c.Farmer.AccessLogMaxMB
+	if maxMB <= 0 {
+		maxMB = defaultAccessLogMaxMB
+	}
+
+	backups := c.Farmer.AccessLogBackups
+	if backups <= 0 {
+		backups = defaultAccessLogBackups
+	}
+
+	file, err := server.NewRotatingFile(c.Farmer.AccessLog, int64(maxMB)*bytesPerMB, backups)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return slog.New(slog.NewJSONHandler(file, nil)), file, nil
+}
+
+// FarmerURL returns the URL that our server can be reached at, based on our
+// listen address, using localhost if it doesn't specify a host.
+func (c *YAMLConfig) FarmerURL() string {
+	host, port, err := net.SplitHostPort(c.FarmerHostPort())
+	if err != nil || host == "" || host == "0.0.0.0" || host == "::" {
+		host = defaultFarmerURLHost
+	}
+
+	if err != nil {
+		port = strconv.Itoa(c.Farmer.Port)
+	}
+
+	scheme := "http"
+	if c.Farmer.TLSCert != "" {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, port))
+}
+
 func (c *YAMLConfig) FarmerHostPort() string {
+	if c.Farmer.Listen != "" {
+		return c.Farmer.Listen
+	}
+
 	return net.JoinHostPort(c.Farmer.Host, strconv.Itoa(c.Farmer.Port))
 }
+
+// ToFarmerConfig returns the config of a farmer.New() Farmer that serves as
+// we're configured to, or an error if our s3 or backfill config is invalid.
+func (c *YAMLConfig) ToFarmerConfig() (farmer.Config, error) {
+	dbConfig, err := c.dbConfig()
+	if err != nil {
+		return farmer.Config{}, err
+	}
+
+	backfill, err := c.BackfillSchedule()
+	if err != nil {
+		return farmer.Config{}, err
+	}
+
+	config := farmer.Config{
+		Elastic: c.ToESConfig(),
+		DB:      dbConfig,
+		Cache: farmer.CacheConfig{
+			Sizes:              c.CacheSizes(),
+			StreamMinHits:      c.Farmer.StreamMinHits,
+			SlowQueryThreshold: c.Farmer.SlowQuery,
+			RevalidateAge:      c.Farmer.CacheRefresh,
+		},
+		QueryTimeout:  c.Farmer.QueryTimeout,
+		Auth:          c.ServerAuth(),
+		RateLimit:     c.ServerRateLimit(),
+		ScrollPaging:  c.Farmer.ScrollPaging,
+		Dashboard:     c.Farmer.Dashboard,
+		DashboardOpen: c.Farmer.DashboardOpen,
+		Backfill:      backfill,
+
+		AnalyticsFile:      c.Farmer.AnalyticsFile,
+		AnalyticsRetention: c.Farmer.AnalyticsRetention,
+	}
+
+	for _, fc := range c.FarmConfigs() {
+		farmDBConfig, errf := fc.dbConfig()
+		if errf != nil {
+			return farmer.Config{}, errf
+		}
+
+		config.Farms = append(config.Farms, farmer.FarmConfig{Elastic: fc.ToESConfig(), DB: farmDBConfig})
+	}
+
+	return config, nil
+}
@@ -0,0 +1,112 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// setenv sets the given environment variable until the end of the current
+// Convey.
+func setenv(name, value string) {
+	So(os.Setenv(name, value), ShouldBeNil)
+
+	Reset(func() {
+		os.Unsetenv(name)
+	})
+}
+
+func TestEnvOverrides(t *testing.T) {
+	Convey("Given a config file with server credentials", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.yml")
+
+		So(os.WriteFile(path, []byte(`farmer:
+  auth_users:
+    alice: yamlpass
+  auth_tokens: [yamltoken]
+  auth_admin_tokens: [yamladmin]
+`), 0600), ShouldBeNil)
+
+		Convey("Without overrides, the file's credentials are used", func() {
+			c, err := LoadConfig(path)
+			So(err, ShouldBeNil)
+			So(c.Farmer.AuthUsers, ShouldResemble, map[string]string{"alice": "yamlpass"})
+			So(c.Farmer.AuthTokens, ShouldResemble, []string{"yamltoken"})
+			So(c.Farmer.AuthAdminTokens, ShouldResemble, []string{"yamladmin"})
+		})
+
+		Convey("Environment variables override them", func() {
+			setenv("FARMER_AUTH_USERS", "alice:envpass, bob:b:c")
+			setenv("FARMER_AUTH_TOKENS", "t1,t2")
+			setenv("FARMER_AUTH_ADMIN_TOKENS", "a1")
+
+			c, err := LoadConfig(path)
+			So(err, ShouldBeNil)
+			So(c.Farmer.AuthUsers, ShouldResemble, map[string]string{"alice": "envpass", "bob": "b:c"})
+			So(c.Farmer.AuthTokens, ShouldResemble, []string{"t1", "t2"})
+			So(c.Farmer.AuthAdminTokens, ShouldResemble, []string{"a1"})
+		})
+
+		Convey("_FILE environment variables override them with newline separated values", func() {
+			usersPath := filepath.Join(dir, "users")
+			tokensPath := filepath.Join(dir, "tokens")
+
+			So(os.WriteFile(usersPath, []byte("carol:cpass\ndave:dpass\n"), 0600), ShouldBeNil)
+			So(os.WriteFile(tokensPath, []byte("ft1\nft2\n"), 0600), ShouldBeNil)
+
+			setenv("FARMER_AUTH_USERS_FILE", usersPath)
+			setenv("FARMER_AUTH_ADMIN_TOKENS_FILE", tokensPath)
+
+			c, err := LoadConfig(path)
+			So(err, ShouldBeNil)
+			So(c.Farmer.AuthUsers, ShouldResemble, map[string]string{
+				"alice": "yamlpass", "carol": "cpass", "dave": "dpass",
+			})
+			So(c.Farmer.AuthTokens, ShouldResemble, []string{"yamltoken"})
+			So(c.Farmer.AuthAdminTokens, ShouldResemble, []string{"ft1", "ft2"})
+		})
+
+		Convey("Invalid overrides are errors that don't reveal the values", func() {
+			setenv("FARMER_AUTH_USERS", "alice:ok,secretvalue")
+
+			_, err := LoadConfig(path)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldNotContainSubstring, "secretvalue")
+
+			setenv("FARMER_AUTH_USERS", "")
+			setenv("FARMER_AUTH_TOKENS", "t1")
+			setenv("FARMER_AUTH_TOKENS_FILE", path)
+
+			_, err = LoadConfig(path)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
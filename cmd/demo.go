@@ -127,7 +127,7 @@ func demo(config *YAMLConfig, period int) { //nolint:funlen,gocognit,gocyclo
 		return
 	}
 
-	ldb, err := db.New(config.ToDBConfig(), true)
+	ldb, err := db.Open(config.ToDBConfig(), true)
 	if err != nil {
 		die("failed to open local database: %s", err)
 	}
@@ -139,7 +139,7 @@ func demo(config *YAMLConfig, period int) { //nolint:funlen,gocognit,gocyclo
 		}
 	}()
 
-	cq, err := cache.New(client, ldb, config.CacheEntries())
+	cq, err := cache.NewWithSizes(client, ldb, config.CacheSizes())
 	if err != nil {
 		die("failed to create an LRU cache: %s", err)
 	}
@@ -333,7 +333,9 @@ func initDB(client *es.Client, config db.Config, p int) error {
 		return err
 	}
 
-	return db.Backfill(client, config, from, period)
+	_, err = db.Backfill(client, config, from, period)
+
+	return err
 }
 
 func timeSearch(msg string, cb func() ([]byte, int, error)) int {
@@ -393,7 +395,7 @@ func printHitInfo(result *es.Result) {
 	}
 }
 
-func doDemoPprof(ldb *db.DB, query *es.Query, poolKey int) {
+func doDemoPprof(ldb db.Backend, query *es.Query, poolKey int) {
 	ldb.Done(poolKey)
 
 	fCPU, err := os.Create(demoPprof + ".cpu")
@@ -423,7 +425,7 @@ func doDemoPprof(ldb *db.DB, query *es.Query, poolKey int) {
 	printTimingFooter(result)
 }
 
-func timeUsers(ldb *db.DB, query *es.Query) {
+func timeUsers(ldb db.Backend, query *es.Query) {
 	printTimingHeader("users (pure index) query")
 
 	t := time.Now()
@@ -0,0 +1,135 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+var fsckQuarantine bool
+var fsckRebackfill bool
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "check the integrity of the local database",
+	Long: `check the integrity of the local database.
+
+Supply a -c config.yml (see root command help for details).
+
+All the flat files in the configured database directory will be checked:
+index and data files must come in pairs, index files must match their
+checksums, index entries must refer to data that is within their data file and
+that matches its checksum, and their timestamps must be in order and within
+their day. Each data file's dictionary (if any) must match its checksum. Each
+problem found is printed as:
+
+day<tab>path<tab>problem
+
+With --quarantine, days with problems are moved to a .quarantine directory
+within the database directory, so that the server will no longer use them, and
+so that the next backfill will backfill them again.
+
+With --rebackfill, days with problems are quarantined and then immediately
+backfilled again from the configured elastic search.
+
+You should not have a server running against the database directory while
+quarantining or backfilling.
+
+Exits non-zero if problems were found and not fixed.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		config := ParseConfig()
+		dbConfig := config.ToDBConfig()
+
+		problems, err := db.Fsck(dbConfig)
+		if err != nil {
+			die("fsck failed: %s", err)
+		}
+
+		days := daysWithProblems(problems)
+
+		switch {
+		case len(days) == 0:
+			info("no problems found")
+
+			return
+		case fsckRebackfill:
+			rebackfillDays(config, dbConfig, days)
+		case fsckQuarantine:
+			if err = db.QuarantineDays(dbConfig, days); err != nil {
+				die("quarantine failed: %s", err)
+			}
+
+			info("quarantined %d days", len(days))
+		default:
+			die("found problems in %d days", len(days))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(fsckCmd)
+
+	// flags specific to this sub-command
+	fsckCmd.Flags().BoolVar(&fsckQuarantine, "quarantine", false,
+		"move days with problems out of the way")
+	fsckCmd.Flags().BoolVar(&fsckRebackfill, "rebackfill", false,
+		"quarantine days with problems, then backfill them again")
+}
+
+// daysWithProblems prints the given problems and returns the unique days they
+// were found in.
+func daysWithProblems(problems []db.FsckProblem) []string {
+	var days []string
+
+	seen := make(map[string]bool)
+
+	for _, p := range problems {
+		cliPrint("%s\t%s\t%s\n", p.Day, p.Path, p.Problem)
+
+		if !seen[p.Day] {
+			seen[p.Day] = true
+			days = append(days, p.Day)
+		}
+	}
+
+	return days
+}
+
+func rebackfillDays(config *YAMLConfig, dbConfig db.Config, days []string) {
+	client, err := es.NewClient(config.ToESConfig())
+	if err != nil {
+		die("failed to create real elasticsearch client: %s", err)
+	}
+
+	if err = db.RebackfillDays(client, dbConfig, days); err != nil {
+		die("rebackfill failed: %s", err)
+	}
+
+	info("backfilled %d days again", len(days))
+}
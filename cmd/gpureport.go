@@ -0,0 +1,128 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+var gpuReportFrom string
+var gpuReportTo string
+var gpuReportBOM string
+var gpuReportCluster string
+var gpuReportJSON bool
+
+var gpuReportCmd = &cobra.Command{
+	Use:   "gpu-report",
+	Short: "report the daily usage of gpu queues from the local database",
+	Long: `report the daily usage of gpu queues from the local database.
+
+Supply a -c config.yml (see root command help for details), and the --from
+(and optionally --to) day to report on.
+
+Each day's jobs in gpu queues (those with a QUEUE_NAME starting "gpu") of all
+BOMs, or just the --bom you supply, are totalled up from the gpu rollup files
+written during backfill (or from the day's hits, for days backfilled before
+those existed), and printed as:
+
+day<tab>jobs<tab>users<tab>cpu_avail_sec<tab>cpu_wasted_sec<tab>mem_avail_mb_sec<tab>mem_wasted_mb_sec<tab>run_time_sec<tab>wasted_cost
+
+Followed by a "total" line for the whole period, where users is the number of
+different users over all the days.
+
+With --json, the full report, including the number of gpu jobs each user ran,
+is printed as JSON instead.
+
+The same report is available from a running server at /report/gpu.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		if gpuReportFrom == "" {
+			die("--from is required")
+		}
+
+		first := parseBackfillDay(gpuReportFrom)
+		last := first
+
+		if gpuReportTo != "" {
+			last = parseBackfillDay(gpuReportTo)
+		}
+
+		config := ParseConfig()
+		dbConfig := config.ToDBConfig()
+		dbConfig.BackgroundLoad = false
+
+		ldb, err := db.Open(dbConfig, true)
+		if err != nil {
+			die("could not open database: %s", err)
+		}
+
+		defer ldb.Close()
+
+		report, err := ldb.GPUReport(context.Background(), gpuReportCluster, gpuReportBOM, first, last)
+		if err != nil {
+			die("gpu report failed: %s", err)
+		}
+
+		if gpuReportJSON {
+			if err = report.WriteJSON(os.Stdout); err != nil {
+				die("could not write report: %s", err)
+			}
+
+			return
+		}
+
+		for _, day := range report.Days {
+			printGPUUsage(day.Day, day.GPUUsage)
+		}
+
+		printGPUUsage("total", report.Total)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gpuReportCmd)
+
+	// flags specific to this sub-command
+	gpuReportCmd.Flags().StringVar(&gpuReportFrom, "from", "",
+		"first day (YYYY-MM-DD) to report on")
+	gpuReportCmd.Flags().StringVar(&gpuReportTo, "to", "",
+		"last day (YYYY-MM-DD) to report on (default --from)")
+	gpuReportCmd.Flags().StringVar(&gpuReportBOM, "bom", "",
+		"only report on this BOM (default all BOMs)")
+	gpuReportCmd.Flags().StringVar(&gpuReportCluster, "cluster", "",
+		"report on this META_CLUSTER_NAME (default the farm cluster)")
+	gpuReportCmd.Flags().BoolVar(&gpuReportJSON, "json", false,
+		"print the full report as JSON")
+}
+
+func printGPUUsage(label string, u db.GPUUsage) {
+	cliPrint("%s\t%d\t%d\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t%.2f\n", label, u.Jobs, u.Users,
+		u.CPUAvailSeconds, u.CPUWastedSeconds, u.MemAvailMBSeconds, u.MemWastedMBSeconds,
+		u.RunTimeSeconds, u.WastedCost)
+}
@@ -0,0 +1,83 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+var mergeVerify bool
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge src_dir dst_dir",
+	Short: "merge one local database directory in to another",
+	Long: `merge one local database directory in to another.
+
+Supply the paths of 2 database directories, eg. one you backfilled on a fast
+scratch machine, and the database_dir of your production server.
+
+Every successfully backfilled day in src_dir that isn't in dst_dir is copied to
+dst_dir. Each day only appears in dst_dir once completely copied, so you can
+merge in to the directory of a running server.
+
+Days present in both are conflicts: they are left alone and reported. With
+--verify, the number of hits in each copied day is checked against src_dir,
+and the number of hits in each conflicting day is reported, so you can decide
+which copy to keep.
+`,
+	Args: cobra.ExactArgs(2), //nolint:mnd
+	Run: func(_ *cobra.Command, args []string) {
+		result, err := db.Merge(args[0], args[1], mergeVerify)
+		if result != nil {
+			for _, day := range result.Copied {
+				info("copied %s", day)
+			}
+
+			for _, c := range result.Conflicts {
+				if mergeVerify {
+					warn("conflict %s: %d hits in src, %d in dst", c.Day, c.SrcHits, c.DstHits)
+				} else {
+					warn("conflict %s", c.Day)
+				}
+			}
+		}
+
+		if err != nil {
+			die("merge failed: %s", err)
+		}
+
+		info("copied %d days, %d conflicts", len(result.Copied), len(result.Conflicts))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mergeCmd)
+
+	// flags specific to this sub-command
+	mergeCmd.Flags().BoolVar(&mergeVerify, "verify", false,
+		"check and report hit counts")
+}
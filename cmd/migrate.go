@@ -0,0 +1,66 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "migrate local database to the current format",
+	Long: `migrate local database to the current format.
+
+Supply a -c config.yml (see root command help for details).
+
+Days in the configured database directory that were stored by an older version
+of farmer, using an older format (including those stored without per-file
+string dictionaries), will be rewritten in the current format. Days already in
+the current format are skipped, so it is safe to run this more than once.
+
+You should not have a server running against the database directory while
+migrating.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		config := ParseConfig()
+
+		t := time.Now()
+
+		migrated, err := db.Migrate(config.ToDBConfig())
+		if err != nil {
+			die("migrate failed after migrating %d directories: %s", migrated, err)
+		}
+
+		info("migrated %d directories in %s", migrated, time.Since(t))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+}
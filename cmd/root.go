@@ -63,6 +63,10 @@ configure the farmers report R to query this server instead of the real one:
 
 farmer serve -c config.yml
 
+To check a config file before using it:
+
+farmer config validate -c config.yml
+
 
 All sub-commands take a config.yml file, which should be in this format:
 
@@ -72,29 +76,341 @@ elastic:
   password: "public"
   scheme: "http"
   port: 19200
+  addresses: []
   index: "elasticsearchindex-*"
+  requests_per_second: 0
+  scroll_slices: 0
+  use_pit: false
+  flavor: "elasticsearch7"
+  api_key: ""
+  service_token: ""
+  ca_cert: ""
+  insecure_skip_verify: false
+  client_cert: ""
+  client_key: ""
+  request_timeout: 0s
+  max_retries: 0
+  retry_backoff: 0s
+  breaker_threshold: 0
+  breaker_cooldown: 30s
 farmer:
   host: "localhost"
   port: 19201
+  listen: ""
+  tls_cert: ""
+  tls_key: ""
+  tls_reload: false
+  disable_http2: false
+  admin_listen: ""
+  grpc_listen: ""
+  auth_users: {}
+  auth_tokens: []
+  auth_exempt_paths: ["/_cluster/health"]
+  auth_admins: []
+  auth_admin_tokens: []
+  rate_limit_per_second: 0
+  rate_limit_burst: 0
+  rate_limit_concurrent: 0
+  access_log: ""
+  access_log_max_mb: 100
+  access_log_backups: 5
+  analytics_file: ""
+  analytics_retention: 2160h
   database_dir: "/path/to/local/database_dir"
+  database_dirs: []
+  backend: "flat"
   file_size: 33554432
   buffer_size: 4194304
   cache_entries: 128
+  cache_search_entries: 0
+  cache_scroll_entries: 0
+  cache_distinct_entries: 0
+  cache_revalidate_age: 0s
+  stream_min_hits: 0
+  scroll_paging: false
+  dashboard: false
+  dashboard_open: false
   pool_size: 0
+  max_pool_bytes: 0
+  pool_idle_timeout: 0s
+  lazy_load_dirs: 0
+  background_load: false
+  max_simultaneous_index_loads: 8
+  query_timeout: 0s
+  update_frequency: 1h
+  read_only: false
+  slow_query_threshold: 0s
+  max_simultaneous_scrolls: 0
+  max_hits: 0
+  max_bytes: 0
+  max_open_files: 256
+  mmap: false
+  skip_boms: []
+  only_boms: []
+  clusters: []
+  columnar_fields: []
+  job_prefix_index: false
+  backfill_at: ""
+  backfill_period: "2d"
+  max_simultaneous_backfills: 16
+  verify_counts: false
+  backfill_retries: 0
+  backfill_retry_delay: 10s
+s3:
+  endpoint: ""
+  bucket: ""
+  prefix: ""
+  access_key_id: ""
+  secret_access_key: ""
+  region: ""
+  use_ssl: true
+
+The server listens on the farmer host and port, or the listen address (eg.
+":443") if given. If tls_cert and tls_key PEM files are given, it serves https
+(and HTTP/2, unless disable_http2 is true). With tls_reload, the files are
+checked every minute and a renewed certificate is used without a restart.
+
+admin_listen, if set, is a second address (eg. "localhost:19202") on which the
+server serves pprof profiles under /debug/pprof/ and runtime stats as JSON at
+/debug/vars, so you can profile a live server, eg. with
+go tool pprof http://localhost:19202/debug/pprof/profile?seconds=30
+If auth is configured, admin credentials are needed. Don't expose it publicly.
+
+grpc_listen, if set, is an address (eg. ":19203") on which the server also
+serves the farmer.v1.Farmer gRPC service (see farmerpb/farmer.proto), with
+Search, Scroll and Usernames methods, using the same TLS settings and auth
+(credentials go in "authorization" metadata, eg. "Bearer <token>"), rate limits,
+access log and query analytics.
+
+If auth_users (usernames mapped to passwords) or auth_tokens are given, every
+request except those for auth_exempt_paths (eg. a health check) must supply
+basic auth as one of those users, or an "Authorization: Bearer <token>" header
+with one of those tokens, or get a 401 status. Basic auth credentials are
+passed on to elastic search when proxying, but tokens are not. Only the
+auth_users listed in auth_admins, or requests with one of the auth_admin_tokens
+(which also work as auth_tokens), may use the server's /admin endpoints.
+
+rate_limit_per_second, if not 0, limits how many requests per second each
+client (auth user or token, otherwise IP address) may make, allowing bursts of
+rate_limit_burst (default rate_limit_per_second). rate_limit_concurrent, if not
+0, limits how many requests each client may have in progress at once. Requests
+over the limits get a 429 status with a Retry-After header.
+
+access_log, if set, is a file the server appends a JSON record to for every
+request, with its method, path, status, bytes sent, duration, client identity,
+and for queries, their keys, BOM and USER_NAME filters and whether they were
+cached. Once the file would exceed access_log_max_mb it is renamed with a .1
+suffix (keeping access_log_backups old files). Set it to "-" to log to STDERR.
+
+analytics_file, if set, is an SQLite file the server records every query in,
+with its key, filters, date span, duration and response size, for
+analytics_retention (default 90 days). See the "analytics" sub-command.
+
+backend is "flat" (the default) to store hits in fast flat files, or "sqlite" to
+store them in a single database_dir/farmer.sqlite file, which is slower but lets
+you use SQL tools on the data if you have a smaller amount of it. Only the
+max_hits and max_bytes options below apply to the sqlite backend.
+
+database_dirs optionally lists more database directories (eg. an archive of
+older months on slower NFS storage) whose days the server answers queries from
+as if they were in database_dir. If a day is in more than one, the copy in
+database_dir is used, then that in the earliest of these, so list faster ones
+first. backfill only stores days in database_dir, skipping days already
+backfilled in any of these, and fsck, snapshot and migrate only work on
+database_dir. Only database_dir is watched for new days; days added to these
+are found every update_frequency if they're newer than all others. Use the tier
+command to move old days between them. Only the flat backend supports this.
 
 Where file and buffer size are in bytes. file_size determines the desired size
 of local database files within database_dir, and buffer_size is the write and
 read buffer size when creating/parsing those files. The default values for these
 are given in the example above (32MB and 4MB respectively).
 
-cache_entries is the number of query results that will be stored in an in-memory
-LRU cache. Defaults to 128.
+cache_entries is the number of query results that will be stored in each of
+the in-memory LRU caches. Defaults to 128. There are separate caches for
+searches (eg. aggregations) and counts, scrolls, and distinct values (eg.
+usernames), so that one giant scroll can't evict many small aggregation results.
+To size them differently, set cache_search_entries, cache_scroll_entries and
+cache_distinct_entries; those left at 0 use cache_entries. GET /admin/cache (as
+an admin) to see how full they are, their hits, misses and evictions, and what's
+in them.
+
+cache_revalidate_age, if not 0s, makes cached aggregation results older than
+this (eg. 10m) still be returned immediately, but then get refreshed in the
+background, so reports stay fast while staying current.
+
+stream_min_hits, if not 0, makes uncached scroll results with at least this many
+hits get streamed to the client in chunks as they're converted to JSON, instead
+of all at once, reducing peak memory use and the time until the client starts
+getting data. Such results aren't cached.
+
+scroll_paging, if true, makes scroll searches return only their first page of
+hits and a scroll id, with each subsequent page got from /_search/scroll, like
+elasticsearch does, for clients that can't take all hits in one response. The
+full result is held in memory until the client has every page, DELETEs the
+scroll, or lets it expire, and isn't cached.
+
+dashboard, if true, makes the server serve a web page at /dashboard/ showing
+how many hits the local database has for each recent day and BOM, backfill
+status, cache statistics and recent slow queries, so you can see the server is
+healthy without reading its logs. Like the /admin endpoints, it needs admin
+credentials, so can't be viewed at all if auth isn't configured, unless
+dashboard_open is true, in which case anyone (who passes any auth) can view it.
 
 pool_size is the initial size of a buffer pool used for processing hit data
 stored on disk. If you set this higher than the expected number of hits in your
 largest query, you'll use a lot of memory, but the first time you run that query
 it will be fast.
 
+max_pool_bytes (default 0, unlimited) caps the total size of that buffer pool,
+so that one huge query doesn't permanently inflate the server's memory use;
+queries needing more get temporary buffers instead. pool_idle_timeout, if set
+(eg. "10m"), frees pool buffers that go unused for that long. The pool's
+occupancy is reported by the /metrics endpoint.
+
+lazy_load_dirs, if greater than 0, makes the server only load local database
+index files in to memory when a query first needs them, keeping at most this
+many day/BOM directories' worth loaded. Use this if you have years of data and
+limited memory. Defaults to 0, which loads all index files at startup.
+
+background_load, if true, makes the server start answering requests right
+away, loading local database index files in the background, most recent days
+first. Until loading finishes, /readyz returns a 503 status, queries of days
+that haven't been loaded yet get a 503 status with a Retry-After header, and
+aggregations of them are sent to elastic search.
+
+max_simultaneous_index_loads (default 8) is how many day/BOM directories' index
+files are loaded at once at startup; the files of each directory are loaded one
+after the other. Lower it if the database is on spinning disks. Progress is
+logged as "loaded N/M days" every 10 seconds while loading.
+
+query_timeout, if not 0s, is how long (eg. 5m) the server will spend on any
+request before giving up and returning a 504 status. Requests are always given
+up on if the client disconnects.
+
+update_frequency is how often the server looks for newly backfilled days.
+Defaults to 1h.
+
+read_only, if true, stops anything creating or modifying files in the
+database_dir, eg. when it's an NFS-mounted replica backfilled by another
+machine. The database_dir must already exist, no index.cache is written,
+backfill_at and s3 can't be used, POST /admin/backfill returns a 403 status,
+and the backfill, migrate, restore, tier and fsck --quarantine/--rebackfill
+commands refuse to run. New days are still found every update_frequency.
+
+slow_query_threshold, if not 0s, makes the server warn log any query that takes
+at least this long (eg. 10s) to answer from the local database or elastic
+search, with its date range, filters and how many index entries it scanned. The
+100 most recent are also listed by GET /admin/slow-queries.
+
+max_simultaneous_scrolls limits how many scroll queries will read the local
+database at once; others will wait their turn. max_hits and max_bytes limit how
+many hits, and bytes of hit data, a single scroll query may return; larger
+queries get an error asking the user to narrow their query, instead of risking
+running out of memory. These all default to 0, meaning unlimited.
+
+max_open_files is the number of local database data files that will be kept
+open between queries, with the least recently queried being closed first. Data
+files compressed by tier --compress are held in memory instead while open.
+If mmap is true, these files are memory-mapped, so that repeated queries of the
+same days are served from the OS page cache with less memory held by farmer
+itself.
+
+skip_boms lists BOMs whose hits won't be stored by backfill; alternatively
+only_boms lists the only BOMs whose hits will be stored. Queries of BOMs that
+aren't stored fail with an error saying so.
+
+clusters lists the META_CLUSTER_NAME values whose hits backfill will store
+(default just "farm", with queries' META_CLUSTER_NAME ignored). If set, each
+cluster's hits are stored separately, so queries only read the files of the
+cluster they filter on (or "farm"); queries of unlisted clusters fail. Only
+the flat backend supports this.
+
+columnar_fields lists numeric fields (eg. WASTED_CPU_SECONDS) that backfill will
+also store in their own column files, so that stats and percentiles aggregations
+of them only read those values instead of whole hits. Only the flat backend
+supports this.
+
+job_prefix_index, if true, makes backfill also store the first 8 bytes of each
+hit's JOB_NAME in a job prefix index for each day and BOM, so that queries
+filtering on a JOB_NAME prefix can skip other hits without reading them. Only
+the flat backend supports this.
+
+backfill_at, if set to a time of day like "01:00" (UTC), makes the server run a
+backfill of the last backfill_period (default "2d") itself every day at that
+time, instead of you having to run the backfill command from cron.
+
+max_simultaneous_backfills (default 16) is how many days a backfill will query
+elastic search for at once, and elastic's requests_per_second (default 0,
+meaning unlimited) limits how many requests we make to elastic search each
+second. Lower these to run long backfills politely on a shared cluster.
+
+elastic's scroll_slices, if greater than 1, makes backfill split each day's
+query in to this many slices that are fetched at once, which can be several
+times faster for busy days. A good value is the number of shards of your index.
+
+elastic's use_pit, if true, makes backfill page through hits using a
+point-in-time and search_after instead of the scroll API, which elastic search
+7.10+ recommends for long-running deep pagination.
+
+elastic's flavor is the kind of server you have: "elasticsearch7" (the default),
+"elasticsearch8" or "opensearch" (which doesn't support use_pit).
+
+For a secured elastic search, give an api_key (the base64 encoding of
+"id:api_key") or service_token instead of a username and password, and use an
+https scheme. The server's certificate is verified using the system's
+certificate authorities plus those in the PEM ca_cert file, unless
+insecure_skip_verify is true. If the server wants client certificates, give the
+paths to PEM client_cert and client_key files.
+
+Instead of a host, scheme and port, you can list several elastic search URLs
+(eg. ["https://es1:9200", "https://es2:9200"]) as addresses. Requests go to one
+that is up, failing over to another if it doesn't respond.
+
+The environment variables ELASTIC_HOST, ELASTIC_USERNAME, ELASTIC_PASSWORD,
+ELASTIC_API_KEY, ELASTIC_SERVICE_TOKEN, S3_ACCESS_KEY_ID and
+S3_SECRET_ACCESS_KEY, if set, override the config file's corresponding settings,
+so that credentials needn't be stored in the file. So do FARMER_AUTH_TOKENS and
+FARMER_AUTH_ADMIN_TOKENS, with comma separated tokens, and FARMER_AUTH_USERS
+adds comma separated "user:password" entries to auth_users. Alternatively set
+eg. ELASTIC_PASSWORD_FILE to the path of a file (such as a docker or kubernetes
+secret) containing the value, with list values on separate lines if you like.
+
+elastic's request_timeout, if not 0s, is how long any single request to elastic
+search may take. Requests that time out or fail with a 5xx or 429 status are
+retried max_retries times (default 0, meaning 3; -1 disables retries), waiting
+retry_backoff before the first retry and twice as long before each subsequent
+one. breaker_threshold, if not 0, is how many consecutive failed requests make
+us stop sending requests to elastic search for breaker_cooldown (default 30s);
+meanwhile the server answers what it can of the farmer's report's aggregation
+queries from local data, with a Warning header saying the answer may be
+incomplete, and returns a 503 status for others.
+
+verify_counts, if true, makes backfills (including scheduled ones) compare
+elastic search's count of each day's hits with the number stored, as with the
+backfill --verify-counts option.
+
+backfill_retries (default 0) is how many times a backfill will try a day again
+after it fails (eg. because of an elastic search timeout), waiting
+backfill_retry_delay (default 10s) before the first retry and twice as long
+before each subsequent one. Other days are backfilled regardless.
+
+The s3 section is optional. If a bucket is given, backfill puts each day it
+completes in that S3-compatible bucket (under the prefix, if any), and skips days
+already there. The server then treats database_dir as a local cache of the
+bucket, downloading index files at startup and every hour, and data files the
+first time they are queried. This lets multiple servers share one backfill.
+
+The farms section is optional. It lists other farms, each with its own index and
+database_dir (and optionally database_dirs), that the server will also answer
+queries for, eg.:
+
+farms:
+  - index: "otherfarmindex-*"
+    database_dir: "/path/to/other/local/database"
+
+Use backfill's --index option to backfill one of them.
+
 index will be the index supplied to the real elasticsearch when doing search and
 scroll queries.
 `,
@@ -127,6 +443,11 @@ func info(msg string, a ...interface{}) {
 	appLogger.Info(fmt.Sprintf(msg, a...))
 }
 
+// warn is a convenience to log a message at the Warn level.
+func warn(msg string, a ...interface{}) {
+	appLogger.Warn(fmt.Sprintf(msg, a...))
+}
+
 // die is a convenience to log a message at the Error level and exit non zero.
 func die(msg string, a ...interface{}) {
 	appLogger.Error(fmt.Sprintf(msg, a...))
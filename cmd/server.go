@@ -26,27 +26,33 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
-	"runtime"
-	"runtime/pprof"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/wtsi-hgi/go-farmer/cache"
-	"github.com/wtsi-hgi/go-farmer/db"
-	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/farmer"
 	"github.com/wtsi-hgi/go-farmer/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"gopkg.in/tylerb/graceful.v1"
 )
 
-const gracefulTimeout = 10 * time.Second
-
-var (
-	serverDebug bool
-	serverPprof string
+const (
+	gracefulTimeout = 10 * time.Second
+	drainTimeout    = 30 * time.Second
+	tcpKeepAlive    = 3 * time.Minute
 )
 
+var serverDebug bool
+
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "server",
@@ -59,18 +65,91 @@ should configure the R farmers report to look at.
 
 This command will block forever in the foreground; you can background it with
 ctrl-z; bg. Or better yet, use the daemonize program to daemonize this. To stop
-the server gracefully, just send it a kill signal (ctrl-c).
+the server gracefully, just send it a kill signal (ctrl-c) or SIGTERM. New
+queries are then refused, and the server waits (for up to 30s) for running ones
+to finish before releasing their memory and closing database files.
 
 Aggregation query results will come from an in-memory cached version of what the
 configured real elastic server returns.
 
 Scroll search query results will come from an in-memory cached version of what
-the configured local database returns. That local database will check every hour
-for any new files added by you running the backfill command.
+the configured local database returns. That local database will check every
+update_frequency (default every hour) for any new files added by you running the
+backfill command.
+
+If the config file has a farmer backfill_at time (eg. "01:00", UTC), the server
+instead runs the backfill itself every day at that time, for the configured
+backfill_period (default 2d), and uses the new days as soon as they're done.
 
 All other requests will be served by the real elastic server, with this server
 acting as a transparent proxy. (Except for /_search/scroll queries, which return
-a fixed fake answer since we handle scrolls during search.)
+a fixed fake answer since we handle scrolls during search, unless the config
+file's scroll_paging is true.)
+
+Besides /get_usernames, /get_accounting_names and /get_boms return the unique
+values of those fields amongst the hits matching a POSTed search query body.
+
+Several scroll searches (eg. one per BOM) can be done in one go by POSTing a JSON
+array of search query bodies to /multi_scroll; they share index traversal and
+file reads, and you get back a JSON array of their results.
+
+/<index>/_msearch requests are split up, each search in them being answered as
+if sent to /<index>/_search, and their results combined like elastic search
+would. Those naming other indexes are proxied.
+
+If the config has farms, searches of their indexes are answered in the same way
+from their own database_dir and cache.
+
+/<index>/_field_caps, /<index>/_mapping and /_mapping requests are answered
+from our own hit schema, so tools like Grafana work without elastic search.
+
+Raw hits can be downloaded as CSV by POSTing a search query body to /export
+(add ?format=tsv for TSV, and ?columns=USER_NAME,JOB_NAME to choose columns).
+
+Add "profile": true to a search body to get a "profile" section in the result,
+with the milliseconds spent scanning indexes, reading data, deserializing hits
+and marshalling JSON, and whether the result came from the cache.
+
+Add ?explain_plan=true to a search to instead get JSON saying which day/BOM
+directories and index files the local database would look at, how many index
+entries and hits there are, the estimated bytes of hit data to read, and whether
+the query can be answered from the index alone. Use it to see why a query is
+slow or too large.
+
+GET /status returns JSON like {"data_through": "2024-06-09", "days_behind": 0},
+saying the latest day the local database has complete data for, and how many
+days before yesterday that is (alert if it's more than 0 and backfill should
+have run). Responses to queries answered locally also have an
+X-Farmer-Data-Through header with that day.
+
+Responses are gzip compressed for clients that send "Accept-Encoding: gzip"
+(the compressed form of cached results is cached too), which greatly reduces
+the size of large scroll results.
+
+Responses to queries answered locally have an ETag that changes when new data
+is loaded; repeat the request with it in an If-None-Match header to get a 304
+with no body if the result hasn't changed.
+
+Prometheus can scrape GET /metrics for counts, errors and durations of the
+requests we make to elastic search, which helps tell whether slowness is due to
+elastic search or the local database.
+
+After a manual backfill, or to get rid of a bad cached result, an admin (see
+auth_admins in the root command help) can POST to /admin/reload to make the
+local database look for new days right away (this also empties the cache), or
+to /admin/flush-cache to just empty the cache, instead of restarting the server.
+
+To pick up changes to the config file's elastic credentials and settings,
+cache_entries, stream_min_hits, slow_query_threshold and update_frequency
+without a restart, send the server a SIGHUP, or have an admin POST to
+/admin/reload-config. This replaces the cache with a new empty one.
+
+An admin can also POST to /admin/backfill?from=YYYY-MM-DD&period=2d to run a
+backfill of the given period before from (default today) in the background,
+like the backfill command. The response is JSON describing the backfill job,
+including its "id"; GET /admin/backfill/<id> to see how many days it has done,
+and its report once finished. The new days are used as soon as it finishes.
+Only one such backfill can run at a time.
 `,
 	Run: func(_ *cobra.Command, _ []string) {
 		if serverDebug {
@@ -79,61 +158,215 @@ a fixed fake answer since we handle scrolls during search.)
 
 		config := ParseConfig()
 
-		client, err := es.NewClient(config.ToESConfig())
+		farmerConfig, err := config.ToFarmerConfig()
 		if err != nil {
-			die("failed to create real elasticsearch client: %s", err)
+			die("%s", err)
 		}
 
 		info("loading local database indexes")
 		t := time.Now()
 
-		ldb, err := db.New(config.ToDBConfig(), true)
+		f, err := farmer.New(farmerConfig)
 		if err != nil {
-			die("failed to open local database: %s", err)
+			die("%s", err)
 		}
 
-		info("load took %s, server now ready", time.Since(t))
+		if config.Farmer.AsyncLoad {
+			info("server now ready; older days will be available once loaded in the background")
+		} else {
+			info("load took %s, server now ready", time.Since(t))
+		}
 
 		defer func() {
-			err = ldb.Close()
+			err = f.Close()
 			if err != nil {
-				die("failed to close local database: %s", err)
+				die("%s", err)
 			}
 		}()
 
-		cq, err := cache.New(client, ldb, config.CacheEntries())
+		reloadConfig := configReloader(f)
+		f.Server.SetConfigReloader(reloadConfig)
+		reloadConfigOnSIGHUP(reloadConfig)
+
+		accessLog, accessLogFile, err := config.AccessLogger()
 		if err != nil {
-			die("failed to create an LRU cache: %s", err)
+			die("failed to open access log: %s", err)
 		}
 
-		server := server.New(cq, config.Elastic.Index, config.ElasticURL())
+		if accessLogFile != nil {
+			defer accessLogFile.Close()
+		}
 
-		if serverPprof != "" {
-			fCPU, err := os.Create(serverPprof + ".cpu")
-			if err != nil {
-				die("failed to create pprof output file: %s", err)
-			}
+		f.Server.SetAccessLog(accessLog)
 
-			fMem, err := os.Create(serverPprof + ".mem")
-			if err != nil {
-				die("failed to create pprof output file: %s", err)
+		if config.Farmer.AdminListen != "" {
+			go serve(config, config.Farmer.AdminListen, f.Server.DebugHandler(), nil)
+		}
+
+		if config.Farmer.GRPCListen != "" {
+			gs := serveGRPC(config, f.Server)
+
+			defer stopGRPC(gs)
+		}
+
+		serveAndDrain(config, f)
+	},
+}
+
+// configReloader returns a function that re-reads our config file and applies
+// it to the given Farmer with Reconfigure().
+func configReloader(f *farmer.Farmer) func() error {
+	return func() error {
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		farmerConfig, err := config.ToFarmerConfig()
+		if err != nil {
+			return err
+		}
+
+		return f.Reconfigure(farmerConfig)
+	}
+}
+
+// reloadConfigOnSIGHUP calls the given config reloader every time we receive
+// a SIGHUP.
+func reloadConfigOnSIGHUP(reload func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := reload(); err != nil {
+				warn("config reload failed: %s", err)
+
+				continue
 			}
 
-			defer fCPU.Close()
-			defer fMem.Close()
+			info("config reloaded")
+		}
+	}()
+}
+
+// serveAndDrain serves the server on our configured listen address like
+// serve(). As soon as shutdown starts (eg. on SIGTERM), new queries are
+// refused, and once in-flight queries have finished, paged scrolls are closed
+// and the local databases release their buffers and unused files. We don't
+// return until that has completed, or drainTimeout has passed.
+func serveAndDrain(config *YAMLConfig, f *farmer.Farmer) {
+	var once sync.Once
 
-			runtime.GC()
-			pprof.StartCPUProfile(fCPU) //nolint:errcheck
+	drained := make(chan error, 1)
 
-			defer func() {
-				pprof.StopCPUProfile()
-				runtime.GC()
-				pprof.WriteHeapProfile(fMem) //nolint:errcheck
+	drain := func() {
+		once.Do(func() {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+				defer cancel()
+
+				drained <- f.Drain(ctx)
 			}()
+		})
+	}
+
+	serve(config, config.FarmerHostPort(), f.Server, drain)
+
+	info("shutting down")
+	drain()
+
+	if err := <-drained; err != nil {
+		warn("queries were still running at shutdown: %s", err)
+	}
+}
+
+// serve serves the handler on the given address with graceful shutdown, over
+// https if we were configured with a tls_cert. shutdownInitiated, if not nil,
+// is called when shutdown starts.
+func serve(config *YAMLConfig, addr string, handler http.Handler, shutdownInitiated func()) {
+	srv := &graceful.Server{
+		Timeout:           gracefulTimeout,
+		TCPKeepAlive:      tcpKeepAlive,
+		ShutdownInitiated: shutdownInitiated,
+		Server:            &http.Server{Addr: addr, Handler: handler}, //nolint:gosec
+	}
+
+	var err error
+
+	if config.Farmer.TLSCert == "" {
+		info("listening on http://%s", srv.Addr)
+
+		err = srv.ListenAndServe()
+	} else {
+		tlsConfig, errt := server.TLSConfig(config.Farmer.TLSCert, config.Farmer.TLSKey,
+			config.Farmer.TLSReload, config.Farmer.DisableHTTP2)
+		if errt != nil {
+			die("failed to load TLS certificate: %s", errt)
 		}
 
-		graceful.Run(config.FarmerHostPort(), gracefulTimeout, server)
-	},
+		info("listening on https://%s", srv.Addr)
+
+		err = srv.ListenAndServeTLSConfig(tlsConfig)
+	}
+
+	var opErr *net.OpError
+	if err != nil && !(errors.As(err, &opErr) && opErr.Op == "accept") {
+		die("server failed: %s", err)
+	}
+}
+
+// serveGRPC starts serving the server's GRPCServer() on our configured
+// grpc_listen address in the background, using our TLS settings.
+func serveGRPC(config *YAMLConfig, s *server.Server) *grpc.Server {
+	var opts []grpc.ServerOption
+
+	scheme := "grpc"
+
+	if config.Farmer.TLSCert != "" {
+		tlsConfig, err := server.TLSConfig(config.Farmer.TLSCert, config.Farmer.TLSKey,
+			config.Farmer.TLSReload, false)
+		if err != nil {
+			die("failed to load TLS certificate: %s", err)
+		}
+
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		scheme = "grpcs"
+	}
+
+	lis, err := net.Listen("tcp", config.Farmer.GRPCListen)
+	if err != nil {
+		die("failed to listen for gRPC: %s", err)
+	}
+
+	gs := s.GRPCServer(opts...)
+
+	info("listening on %s://%s", scheme, lis.Addr())
+
+	go func() {
+		if errs := gs.Serve(lis); errs != nil {
+			die("gRPC server failed: %s", errs)
+		}
+	}()
+
+	return gs
+}
+
+// stopGRPC stops the given gRPC server, waiting up to gracefulTimeout for
+// calls still being handled to finish.
+func stopGRPC(gs *grpc.Server) {
+	stopped := make(chan struct{})
+
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(gracefulTimeout):
+		gs.Stop()
+	}
 }
 
 func init() {
@@ -141,6 +374,4 @@ func init() {
 
 	serverCmd.Flags().BoolVarP(&serverDebug, "debug", "d", false,
 		"output additional debug info")
-	serverCmd.Flags().StringVarP(&serverPprof, "pprof", "p", "",
-		"output profiling data to files with the given prefix path")
 }
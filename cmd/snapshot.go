@@ -0,0 +1,123 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+var snapshotOut string
+var restoreIn string
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "archive the local database",
+	Long: `archive the local database.
+
+Supply a -c config.yml (see root command help for details), and an --out path
+to write a zstd compressed tar archive of the configured database directory to.
+
+Only days that have been successfully backfilled are archived, so it is safe to
+take a snapshot while a backfill or the server is running.
+
+Use the restore command to extract the snapshot in to a database directory, eg.
+to seed a new server.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		if snapshotOut == "" {
+			die("you must supply an --out path")
+		}
+
+		config := ParseConfig()
+
+		f, err := os.Create(snapshotOut)
+		if err != nil {
+			die("failed to create snapshot file: %s", err)
+		}
+
+		days, err := db.Snapshot(config.ToDBConfig(), f)
+		if err != nil {
+			f.Close()
+			die("snapshot failed: %s", err)
+		}
+
+		if err = f.Close(); err != nil {
+			die("failed to close snapshot file: %s", err)
+		}
+
+		info("archived %d days to %s", days, snapshotOut)
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "restore the local database from a snapshot",
+	Long: `restore the local database from a snapshot.
+
+Supply a -c config.yml (see root command help for details), and an --in path
+to a snapshot made by the snapshot command.
+
+The days in the snapshot are extracted in to the configured database directory.
+Days already present there are skipped. Each day only appears in the database
+directory once completely extracted, so a running server will pick up the
+restored days without seeing partial ones.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		if restoreIn == "" {
+			die("you must supply an --in path")
+		}
+
+		config := ParseConfig()
+
+		f, err := os.Open(restoreIn)
+		if err != nil {
+			die("failed to open snapshot file: %s", err)
+		}
+
+		defer f.Close()
+
+		days, err := db.Restore(config.ToDBConfig(), f)
+		if err != nil {
+			die("restore failed: %s", err)
+		}
+
+		info("restored %d days", days)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(snapshotCmd)
+	RootCmd.AddCommand(restoreCmd)
+
+	// flags specific to these sub-commands
+	snapshotCmd.Flags().StringVarP(&snapshotOut, "out", "o", "",
+		"path to write the snapshot to, eg. farmer.tar.zst")
+	restoreCmd.Flags().StringVarP(&restoreIn, "in", "i", "",
+		"path to a snapshot to restore")
+}
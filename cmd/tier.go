@@ -0,0 +1,168 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/client"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+var tierOlderThan string
+var tierTo string
+var tierCompress bool
+var tierIndex string
+var tierServer string
+
+var tierCmd = &cobra.Command{
+	Use:   "tier",
+	Short: "move old days between database directories",
+	Long: `move old days between database directories.
+
+Moves every successfully backfilled day older than --older-than (eg. 90d for
+90 days, 6m for 6 months) from database_dir and database_dirs to the --to
+directory, which must be database_dir or one of database_dirs. Eg. with an
+archive on slow NFS storage listed in database_dirs:
+
+farmer tier -c config.yml --older-than 90d --to /archive/dir
+
+Each day only appears in the --to directory once completely copied. Days the
+--to directory already has are left alone and reported as skipped.
+
+With --compress, the data files of the moved days are zstd compressed. The
+server then reads each compressed file in to memory in full when it's queried
+(counting toward max_open_files), so only compress days that are rarely
+queried.
+
+Afterwards, the running server is asked to reload, so it queries the days from
+their new location. This uses the first of auth_admin_tokens, or the password
+of the first of auth_admins, and the server's listen address (or --server, eg.
+https://farmer.domain:1235). Only once that succeeds are the moved days deleted
+from where they were. If it fails, the old copies are kept, and the server
+keeps querying them until it finds the moved days within update_frequency; run
+tier again after that to delete them.
+
+If your config has farms, tier one of them by giving its index with --index.
+Only the flat backend supports this, and not with s3 or read_only.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		if tierOlderThan == "" || tierTo == "" {
+			die("--older-than and --to are required")
+		}
+
+		config := ParseFarmConfig(tierIndex)
+		before := time.Now().UTC().Truncate(oneDay).Add(-parsePeriod(tierOlderThan))
+
+		dbConfig := config.ToDBConfig()
+
+		result, err := db.Tier(dbConfig, before, tierTo, tierCompress)
+		if result != nil {
+			for _, day := range result.Moved {
+				info("moved %s", day)
+			}
+
+			for _, day := range result.Skipped {
+				warn("skipped %s", day)
+			}
+		}
+
+		if err != nil {
+			die("tier failed: %s", err)
+		}
+
+		info("moved %d days, skipped %d", len(result.Moved), len(result.Skipped))
+
+		if len(result.Tiered) == 0 {
+			return
+		}
+
+		if err = reloadServer(config, tierServer); err != nil {
+			warn("server not reloaded, so the old copies of moved days were kept; "+
+				"run tier again after the server's update_frequency to delete them: %s", err)
+
+			return
+		}
+
+		info("server reloaded")
+
+		removeTiered(dbConfig)
+	},
+}
+
+// removeTiered deletes the old copies of the days that tier moved, now that the
+// server no longer queries them.
+func removeTiered(config db.Config) {
+	removed, err := db.RemoveTiered(config)
+	for _, day := range removed {
+		info("deleted old copy of %s", day)
+	}
+
+	if err != nil {
+		die("deleting old copies of moved days failed: %s", err)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(tierCmd)
+
+	// flags specific to this sub-command
+	tierCmd.Flags().StringVar(&tierOlderThan, "older-than", "",
+		"move days older than this, eg. 90d for 90 days, 6m for 6 months")
+	tierCmd.Flags().StringVar(&tierTo, "to", "",
+		"database directory to move the days to")
+	tierCmd.Flags().BoolVar(&tierCompress, "compress", false,
+		"zstd compress the data files of the moved days")
+	tierCmd.Flags().StringVar(&tierIndex, "index", "",
+		"tier the farm with this index instead of the elastic index")
+	tierCmd.Flags().StringVar(&tierServer, "server", "",
+		"URL of the running server to reload (default from the config)")
+}
+
+// reloadServer asks the server at the given URL (or at our configured listen
+// address, if blank) to reload, using our configured admin credentials.
+func reloadServer(config *YAMLConfig, serverURL string) error {
+	if serverURL == "" {
+		serverURL = config.FarmerURL()
+	}
+
+	c, err := client.New(serverURL, config.Elastic.Index)
+	if err != nil {
+		return err
+	}
+
+	if len(config.Farmer.AuthAdminTokens) > 0 {
+		c.SetToken(config.Farmer.AuthAdminTokens[0])
+	} else if len(config.Farmer.AuthAdmins) > 0 {
+		admin := config.Farmer.AuthAdmins[0]
+		c.SetBasicAuth(admin, config.Farmer.AuthUsers[admin])
+	}
+
+	_, err = c.Reload()
+
+	return err
+}
@@ -0,0 +1,357 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"gopkg.in/yaml.v3"
+)
+
+const maskedSecret = "********"
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "work with the config file",
+	Long: `work with the config file.
+
+See the validate sub-command.
+`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check the config file",
+	Long: `check the config file.
+
+Supply a -c config.yml (see root command help for details).
+
+The config file (with any environment variable overrides applied) is parsed and
+checked: the required elastic host, port, scheme and index and the farmer
+database_dir and listen address must be set, values like the backend, flavor
+and backfill_at must be valid, the database_dir must be writable (or, if it
+doesn't exist yet, its nearest existing parent must be; it isn't created) or, if
+read_only, must exist, any database_dirs must exist, and the configured elastic
+search must respond.
+
+The effective configuration is then printed as YAML, with passwords, keys and
+tokens masked, followed by each problem found.
+
+Exits non-zero if any problems were found, so you can use it to test your
+deployment's config before deploying it.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			die("%s", err)
+		}
+
+		problems := config.Validate()
+
+		out, err := yaml.Marshal(config.Masked())
+		if err != nil {
+			die("failed to print config: %s", err)
+		}
+
+		cliPrint("%s", out)
+
+		for _, problem := range problems {
+			cliPrint("problem: %s\n", problem)
+		}
+
+		if len(problems) > 0 {
+			die("found %d problems in %s", len(problems), configPath)
+		}
+
+		info("%s is valid", configPath)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	RootCmd.AddCommand(configCmd)
+}
+
+// Validate checks that required settings are set and valid, that the
+// database_dirs are usable and that elastic search can be contacted,
+// returning a description of each problem found.
+func (c *YAMLConfig) Validate() []string {
+	problems := c.missingSettings()
+	problems = append(problems, c.invalidSettings()...)
+	problems = append(problems, c.invalidFarms()...)
+
+	if c.Farmer.DatabaseDir != "" {
+		if err := c.checkDatabaseDir(c.Farmer.DatabaseDir); err != nil {
+			problems = append(problems, fmt.Sprintf("database_dir not usable: %s", err))
+		}
+	}
+
+	for _, dir := range c.Farmer.DatabaseDirs {
+		if _, err := os.Stat(dir); err != nil {
+			problems = append(problems, fmt.Sprintf("database_dirs not usable: %s", err))
+		}
+	}
+
+	if err := c.pingElastic(); err != nil {
+		problems = append(problems, fmt.Sprintf("elastic search not usable: %s", err))
+	}
+
+	return problems
+}
+
+func (c *YAMLConfig) missingSettings() []string {
+	var problems []string
+
+	for _, setting := range []struct {
+		name    string
+		missing bool
+	}{
+		{"elastic host (or addresses)", c.Elastic.Host == "" && len(c.Elastic.Addresses) == 0},
+		{"elastic port", c.Elastic.Port == 0 && len(c.Elastic.Addresses) == 0},
+		{"elastic scheme", c.Elastic.Scheme == "" && len(c.Elastic.Addresses) == 0},
+		{"elastic index", c.Elastic.Index == ""},
+		{"farmer database_dir", c.Farmer.DatabaseDir == ""},
+		{"farmer listen (or host and port)", c.Farmer.Listen == "" && (c.Farmer.Host == "" || c.Farmer.Port == 0)},
+	} {
+		if setting.missing {
+			problems = append(problems, "missing "+setting.name)
+		}
+	}
+
+	return problems
+}
+
+func (c *YAMLConfig) invalidSettings() []string {
+	var problems []string
+
+	if _, err := c.ElasticURLs(); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid elastic addresses: %s", err))
+	}
+
+	switch c.Farmer.Backend {
+	case "", db.BackendFlat, db.BackendSQLite:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown farmer backend: %s", c.Farmer.Backend))
+	}
+
+	if len(c.Farmer.Clusters) > 0 && c.Farmer.Backend == db.BackendSQLite {
+		problems = append(problems, "farmer clusters are only supported by the flat backend")
+	}
+
+	for _, field := range c.Farmer.Columnar {
+		if err := es.ValidateNumericField(field); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid farmer columnar_fields: %s", err))
+		}
+	}
+
+	if len(c.Farmer.DatabaseDirs) > 0 && c.Farmer.Backend == db.BackendSQLite {
+		problems = append(problems, "farmer database_dirs are only supported by the flat backend")
+	}
+
+	if len(c.Farmer.Columnar) > 0 && c.Farmer.Backend == db.BackendSQLite {
+		problems = append(problems, "farmer columnar_fields are only supported by the flat backend")
+	}
+
+	if c.Farmer.JobPrefixes && c.Farmer.Backend == db.BackendSQLite {
+		problems = append(problems, "farmer job_prefix_index is only supported by the flat backend")
+	}
+
+	if (c.Farmer.TLSCert == "") != (c.Farmer.TLSKey == "") {
+		problems = append(problems, "farmer tls_cert and tls_key must be given together")
+	}
+
+	if c.Farmer.BackfillAt != "" {
+		if _, err := time.Parse(backfillAtFormat, c.Farmer.BackfillAt); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid farmer backfill_at: %s", err))
+		}
+	}
+
+	if c.Farmer.ReadOnly && (c.Farmer.BackfillAt != "" || c.S3.Bucket != "") {
+		problems = append(problems, "farmer read_only can't be used with backfill_at or an s3 bucket")
+	}
+
+	if c.Farmer.BackfillPeriod != "" {
+		if _, err := db.ParsePeriod(c.Farmer.BackfillPeriod); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid farmer backfill_period: %s", err))
+		}
+	}
+
+	return problems
+}
+
+// invalidFarms checks that each farm has a unique index and database_dir, and
+// that the database_dir is usable.
+func (c *YAMLConfig) invalidFarms() []string {
+	var problems []string
+
+	indexes := map[string]bool{c.Elastic.Index: true}
+	dirs := map[string]bool{c.Farmer.DatabaseDir: true}
+
+	for i, farm := range c.Farms {
+		switch {
+		case farm.Index == "" || farm.DatabaseDir == "":
+			problems = append(problems, fmt.Sprintf("farm %d needs an index and database_dir", i+1))
+
+			continue
+		case indexes[farm.Index]:
+			problems = append(problems, fmt.Sprintf("farm index %s is used more than once", farm.Index))
+		case dirs[farm.DatabaseDir]:
+			problems = append(problems, fmt.Sprintf("farm database_dir %s is used more than once", farm.DatabaseDir))
+		}
+
+		indexes[farm.Index] = true
+		dirs[farm.DatabaseDir] = true
+
+		if err := c.checkDatabaseDir(farm.DatabaseDir); err != nil {
+			problems = append(problems, fmt.Sprintf("farm %s database_dir not usable: %s", farm.Index, err))
+		}
+	}
+
+	return problems
+}
+
+// checkDatabaseDir checks that the given database directory exists if we're
+// read_only, since we mustn't create anything in it, and is checkWritable()
+// otherwise.
+func (c *YAMLConfig) checkDatabaseDir(dir string) error {
+	if c.Farmer.ReadOnly {
+		_, err := os.Stat(dir)
+
+		return err
+	}
+
+	return checkWritable(dir)
+}
+
+// checkWritable checks that we can create files in the given directory, or if
+// it doesn't exist yet, in its nearest existing parent, so that it could be
+// created. It doesn't create the directory itself.
+func checkWritable(dir string) error {
+	existing, err := nearestExistingDir(dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(existing, ".farmer-validate")
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(f.Close(), os.Remove(f.Name()))
+}
+
+// nearestExistingDir returns the given path if it exists, otherwise its
+// nearest parent that does. Returns an error if that isn't a directory.
+func nearestExistingDir(path string) (string, error) {
+	for {
+		info, err := os.Stat(path)
+
+		switch {
+		case err == nil && !info.IsDir():
+			return "", fmt.Errorf("%s is not a directory", path)
+		case err == nil:
+			return path, nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return "", err
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+
+		path = parent
+	}
+}
+
+// pingElastic checks that we can create a client for the configured elastic
+// search, and that it responds to an info request.
+func (c *YAMLConfig) pingElastic() error {
+	client, err := es.NewClient(c.ToESConfig())
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Info()
+
+	return err
+}
+
+// Masked returns a copy of this config with its passwords, keys and tokens
+// replaced with asterisks, suitable for printing.
+func (c *YAMLConfig) Masked() *YAMLConfig {
+	m := *c
+
+	for _, secret := range []*string{
+		&m.Elastic.Password, &m.Elastic.APIKey, &m.Elastic.ServiceToken,
+		&m.S3.SecretAccessKey,
+	} {
+		*secret = maskSecret(*secret)
+	}
+
+	if c.Farmer.AuthUsers != nil {
+		m.Farmer.AuthUsers = make(map[string]string, len(c.Farmer.AuthUsers))
+
+		for user, password := range c.Farmer.AuthUsers {
+			m.Farmer.AuthUsers[user] = maskSecret(password)
+		}
+	}
+
+	m.Farmer.AuthTokens = maskSecrets(c.Farmer.AuthTokens)
+	m.Farmer.AuthAdminTokens = maskSecrets(c.Farmer.AuthAdminTokens)
+
+	return &m
+}
+
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return maskedSecret
+}
+
+func maskSecrets(secrets []string) []string {
+	if secrets == nil {
+		return nil
+	}
+
+	masked := make([]string, len(secrets))
+
+	for i, secret := range secrets {
+		masked[i] = maskSecret(secret)
+	}
+
+	return masked
+}
@@ -0,0 +1,205 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v3"
+)
+
+// newFakeElastic returns a server that answers elastic search info requests.
+func newFakeElastic() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version":{"number":"8.15.0"}}`)) //nolint:errcheck
+	}))
+}
+
+// newValidConfig returns a config with all required settings, using the given
+// elastic address and a database_dir in the given directory.
+func newValidConfig(address, dir string) *YAMLConfig {
+	c := &YAMLConfig{}
+	c.Elastic.Addresses = []string{address}
+	c.Elastic.Index = "farm-*"
+	c.Elastic.Password = "espass"
+	c.Farmer.DatabaseDir = filepath.Join(dir, "db")
+	c.Farmer.Listen = ":0"
+
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	Convey("Given a fake elastic search and a valid config", t, func() {
+		es := newFakeElastic()
+		defer es.Close()
+
+		dir := t.TempDir()
+		c := newValidConfig(es.URL, dir)
+
+		Convey("it has no problems, and the database_dir isn't created", func() {
+			So(c.Validate(), ShouldBeEmpty)
+
+			_, err := os.Stat(c.Farmer.DatabaseDir)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			entries, err := os.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+		})
+
+		Convey("missing required settings are reported", func() {
+			c.Elastic.Addresses = nil
+			c.Elastic.Index = ""
+			c.Farmer.DatabaseDir = ""
+			c.Farmer.Listen = ""
+			c.Farmer.Host = "localhost"
+
+			So(c.missingSettings(), ShouldResemble, []string{
+				"missing elastic host (or addresses)",
+				"missing elastic port",
+				"missing elastic scheme",
+				"missing elastic index",
+				"missing farmer database_dir",
+				"missing farmer listen (or host and port)",
+			})
+
+			problems := c.Validate()
+			So(problems, ShouldContain, "missing elastic index")
+			So(strings.Join(problems, "\n"), ShouldContainSubstring, "elastic search not usable")
+		})
+
+		Convey("invalid settings are reported", func() {
+			c.Farmer.Backend = "foo"
+			c.Farmer.TLSCert = "cert.pem"
+			c.Farmer.BackfillAt = "25:00"
+
+			problems := c.invalidSettings()
+			So(problems, ShouldHaveLength, 3)
+			So(problems[0], ShouldEqual, "unknown farmer backend: foo")
+			So(problems[1], ShouldEqual, "farmer tls_cert and tls_key must be given together")
+			So(problems[2], ShouldStartWith, "invalid farmer backfill_at:")
+		})
+
+		Convey("farms with duplicate indexes or database_dirs are reported", func() {
+			So(yaml.Unmarshal([]byte(`
+- index: farm-*
+  database_dir: `+filepath.Join(dir, "other")+`
+- index: other-*
+  database_dir: `+c.Farmer.DatabaseDir+`
+- index: third-*
+`), &c.Farms), ShouldBeNil)
+
+			So(c.invalidFarms(), ShouldResemble, []string{
+				"farm index farm-* is used more than once",
+				"farm database_dir " + c.Farmer.DatabaseDir + " is used more than once",
+				"farm 3 needs an index and database_dir",
+			})
+		})
+
+		Convey("a read_only farmer needs its database_dirs to exist", func() {
+			c.Farmer.ReadOnly = true
+
+			problems := c.Validate()
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0], ShouldStartWith, "database_dir not usable:")
+
+			So(os.Mkdir(c.Farmer.DatabaseDir, 0700), ShouldBeNil)
+			So(c.Validate(), ShouldBeEmpty)
+		})
+
+		Convey("an unwritable database_dir is reported", func() {
+			file := filepath.Join(dir, "file")
+			So(os.WriteFile(file, nil, 0600), ShouldBeNil)
+
+			c.Farmer.DatabaseDir = file
+
+			problems := c.Validate()
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0], ShouldEqual, "database_dir not usable: "+file+" is not a directory")
+
+			c.Farmer.DatabaseDir = filepath.Join(file, "db")
+
+			problems = c.Validate()
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0], ShouldEndWith, "not a directory")
+
+			if os.Geteuid() == 0 {
+				return
+			}
+
+			readOnlyDir := filepath.Join(dir, "ro")
+			So(os.Mkdir(readOnlyDir, 0500), ShouldBeNil)
+
+			c.Farmer.DatabaseDir = filepath.Join(readOnlyDir, "db")
+
+			problems = c.Validate()
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0], ShouldStartWith, "database_dir not usable:")
+		})
+	})
+
+	Convey("Masked() hides passwords, keys and tokens", t, func() {
+		c := newValidConfig("http://localhost:9200", t.TempDir())
+		c.Elastic.Username = "esuser"
+		c.Elastic.APIKey = "eskey"
+		c.Elastic.ServiceToken = "estoken"
+		c.S3.AccessKeyID = "s3id"
+		c.S3.SecretAccessKey = "s3secret"
+		c.Farmer.AuthUsers = map[string]string{"alice": "alicepass"}
+		c.Farmer.AuthTokens = []string{"token1", ""}
+		c.Farmer.AuthAdminTokens = []string{"admintoken"}
+
+		m := c.Masked()
+		So(m.Elastic.Username, ShouldEqual, "esuser")
+		So(m.Elastic.Password, ShouldEqual, maskedSecret)
+		So(m.Elastic.APIKey, ShouldEqual, maskedSecret)
+		So(m.Elastic.ServiceToken, ShouldEqual, maskedSecret)
+		So(m.S3.AccessKeyID, ShouldEqual, "s3id")
+		So(m.S3.SecretAccessKey, ShouldEqual, maskedSecret)
+		So(m.Farmer.AuthUsers, ShouldResemble, map[string]string{"alice": maskedSecret})
+		So(m.Farmer.AuthTokens, ShouldResemble, []string{maskedSecret, ""})
+		So(m.Farmer.AuthAdminTokens, ShouldResemble, []string{maskedSecret})
+
+		out, err := yaml.Marshal(m)
+		So(err, ShouldBeNil)
+
+		for _, secret := range []string{"espass", "eskey", "estoken", "s3secret", "alicepass", "token1", "admintoken"} {
+			So(string(out), ShouldNotContainSubstring, secret)
+		}
+
+		So(c.Elastic.Password, ShouldEqual, "espass")
+		So(c.Farmer.AuthUsers["alice"], ShouldEqual, "alicepass")
+		So(c.Farmer.AuthTokens[0], ShouldEqual, "token1")
+	})
+}
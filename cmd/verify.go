@@ -0,0 +1,74 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "report backfilled days whose hit counts didn't match elastic search",
+	Long: `report backfilled days whose hit counts didn't match elastic search.
+
+Supply a -c config.yml (see root command help for details).
+
+Days backfilled with --verify-counts (or the verify_counts config option) have
+elastic search's count of their hits recorded alongside the number of hits we
+stored. This reports each day where those didn't match as:
+
+day<tab>elastic count<tab>stored count
+
+Followed by a summary of how many days were verified and unverified. You may
+want to backfill mismatched days again with --force.
+
+Exits non-zero if any mismatches were found.
+`,
+	Run: func(_ *cobra.Command, _ []string) {
+		config := ParseConfig()
+
+		report, err := db.ReportCounts(config.ToDBConfig())
+		if err != nil {
+			die("verify failed: %s", err)
+		}
+
+		for _, count := range report.Mismatches {
+			cliPrint("%s\t%d\t%d\n", count.Day, count.Elastic, count.Stored)
+		}
+
+		info("%d days verified (%d mismatched), %d days unverified",
+			report.Verified, len(report.Mismatches), report.Unverified)
+
+		if len(report.Mismatches) > 0 {
+			die("found %d mismatched days", len(report.Mismatches))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+}
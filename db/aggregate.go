@@ -0,0 +1,587 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	defaultAggSize     = 10
+	aggKeySeparator    = "|"
+	aggFieldAccounting = "ACCOUNTING_NAME"
+	aggFieldProcs      = "NUM_EXEC_PROCS"
+	aggFieldJob        = "Job"
+)
+
+// rollupQuery is an aggregation query that can be answered from rollups.
+type rollupQuery struct {
+	fields         []string
+	multi          bool
+	size           int
+	subAggs        map[string]es.AggsField
+	bom            string
+	cluster        string
+	accountingName string
+	gte            time.Time
+	end            time.Time
+	endInclusive   bool
+	skipMissing    bool
+}
+
+// Aggregate answers the farmer's report's aggregation queries from the rollup
+// files written during backfill, without reading any hit data. The query must
+// be a terms or multi_terms aggregation on some of ACCOUNTING_NAME,
+// NUM_EXEC_PROCS and Job, with sum and/or wasted_cost sub-aggregations, over
+// whole days of a single BOM.
+//
+// It also answers "stats" and "percentiles" aggregations of a numeric field
+// (or es.MemEfficiencyField) of a single BOM's hits, by scrolling them.
+//
+// Returns false if the query can't be answered from our rollups, or is of days
+// we're still loading in the background, in which case you should send it to
+// elasticsearch instead.
+func (d *DB) Aggregate(query *es.Query) (*es.Result, bool, error) {
+	if d.bomSelection.checkQuery(query) != nil || d.checkQueryLoaded(query) != nil {
+		return nil, false, nil
+	}
+
+	cluster, err := d.clusters.resolve(queryCluster(query))
+	if err != nil {
+		return nil, false, nil //nolint:nilerr
+	}
+
+	rq, ok := newRollupQuery(query)
+	if !ok {
+		return d.metricAggregate(query)
+	}
+
+	rq.cluster = cluster
+	readStart := time.Now()
+
+	r, ok, err := d.rollupOfDays(rq)
+
+	query.TimePhase(es.PhaseDataRead, readStart)
+
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	if rq.endInclusive {
+		if err = d.addBoundaryHitsToRollup(query, rq.end, r); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err = query.Context().Err(); err != nil {
+		return nil, false, err
+	}
+
+	return rq.result(r), true, nil
+}
+
+// AggregateLocalOnly is like Aggregate(), but for when elasticsearch is
+// unavailable: days in the query's date range that we don't have rollups for
+// are skipped, and a date range that doesn't start and end at midnight is
+// widened to whole days. The answer may therefore be incomplete or include
+// extra hits.
+func (d *DB) AggregateLocalOnly(query *es.Query) (*es.Result, bool, error) {
+	if err := d.bomSelection.checkQuery(query); err != nil {
+		return nil, false, err
+	}
+
+	if err := d.checkQueryLoaded(query); err != nil {
+		return nil, false, err
+	}
+
+	cluster, err := d.clusters.resolve(queryCluster(query))
+	if err != nil {
+		return nil, false, err
+	}
+
+	rq, ok := newRollupQueryShape(query)
+	if !ok {
+		return d.metricAggregate(query)
+	}
+
+	rq.cluster = cluster
+
+	if !rq.setWholeDayRange(query) {
+		return nil, false, nil
+	}
+
+	rq.skipMissing = true
+
+	r, _, err := d.rollupOfDays(rq)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err = query.Context().Err(); err != nil {
+		return nil, false, err
+	}
+
+	return rq.result(r), true, nil
+}
+
+// newRollupQuery returns a rollupQuery for the given query, or false if it
+// isn't one we can answer from rollups.
+func newRollupQuery(query *es.Query) (*rollupQuery, bool) {
+	rq, ok := newRollupQueryShape(query)
+	if !ok {
+		return nil, false
+	}
+
+	return rq, rq.setDateRange(query)
+}
+
+// newRollupQueryShape returns a rollupQuery for the given query, without its
+// date range, or false if it isn't an aggregation we could answer from
+// rollups.
+func newRollupQueryShape(query *es.Query) (*rollupQuery, bool) {
+	if query.Size != 0 || query.Aggs == nil || query.Query == nil {
+		return nil, false
+	}
+
+	stats, ok := aggsStats(query.Aggs)
+	if !ok {
+		return nil, false
+	}
+
+	rq := &rollupQuery{subAggs: stats.Aggs}
+
+	if !rq.setFields(stats) || !rq.subAggsAreRollable() || !rq.setFilters(query) {
+		return nil, false
+	}
+
+	return rq, true
+}
+
+// aggsStats converts the given Aggs' Stats, which will be a map if the Aggs
+// were decoded from JSON, in to an AggsStats.
+func aggsStats(aggs *es.Aggs) (*es.AggsStats, bool) {
+	b, err := json.Marshal(aggs.Stats)
+	if err != nil {
+		return nil, false
+	}
+
+	stats := &es.AggsStats{}
+
+	if err = json.Unmarshal(b, stats); err != nil {
+		return nil, false
+	}
+
+	return stats, true
+}
+
+// setFields sets the fields we aggregate on from the given terms or
+// multi_terms aggregation, returning false if any aren't in our rollupKey.
+func (rq *rollupQuery) setFields(stats *es.AggsStats) bool {
+	switch {
+	case stats.MultiTerms != nil && stats.Terms == nil:
+		rq.multi = true
+		rq.size = stats.MultiTerms.Size
+
+		for _, term := range stats.MultiTerms.Terms {
+			rq.fields = append(rq.fields, term.Field)
+		}
+	case stats.Terms != nil && stats.MultiTerms == nil:
+		rq.size = stats.Terms.Size
+		rq.fields = []string{stats.Terms.Field}
+	default:
+		return false
+	}
+
+	if rq.size == 0 {
+		rq.size = defaultAggSize
+	}
+
+	for _, field := range rq.fields {
+		switch field {
+		case aggFieldAccounting, aggFieldProcs, aggFieldJob:
+		default:
+			return false
+		}
+	}
+
+	return len(rq.fields) > 0
+}
+
+// subAggsAreRollable returns true if all our sub-aggregations are sums of
+// fields in our rollups, or the report's wasted_cost scripted_metric.
+func (rq *rollupQuery) subAggsAreRollable() bool {
+	summable := rollupSummableFields(&es.Details{})
+
+	for _, agg := range rq.subAggs {
+		switch {
+		case agg.Sum != nil && agg.ScriptedMetric == nil:
+			if _, ok := summable[agg.Sum.Field]; !ok {
+				return false
+			}
+		case agg.ScriptedMetric != nil && agg.Sum == nil:
+			if !isWastedCostScript(agg.ScriptedMetric) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// isWastedCostScript returns true if the given ScriptedMetric is the one whose
+// values we precompute in our rollups.
+func isWastedCostScript(sm *es.ScriptedMetric) bool {
+	if sm.MapScript != wastedCostMapScript {
+		return false
+	}
+
+	params, ok := sm.Params.(map[string]interface{})
+	if !ok || len(params) != 2 { //nolint:mnd
+		return false
+	}
+
+	cpu, ok := params["cpu_second"].(float64)
+	if !ok {
+		return false
+	}
+
+	mb, ok := params["mb_second"].(float64)
+
+	return ok && cpu == wastedCostCPUSecond && mb == wastedCostMBSecond
+}
+
+// setFilters sets our BOM and ACCOUNTING_NAME from the query, returning false
+// if the query doesn't specify a BOM or has filters we can't apply to rollups.
+// META_CLUSTER_NAME is allowed, but is resolved by our DB, as it is for
+// Scroll().
+func (rq *rollupQuery) setFilters(query *es.Query) bool {
+	for _, filter := range query.Query.Bool.Filter {
+		for kind := range filter {
+			if kind != "match_phrase" && kind != "range" {
+				return false
+			}
+		}
+	}
+
+	for field := range query.MatchFilters() {
+		switch field {
+		case "BOM", aggFieldAccounting, "META_CLUSTER_NAME":
+		default:
+			return false
+		}
+	}
+
+	bom, accountingName, _ := queryToFilters(query)
+	rq.bom = bom
+	rq.accountingName = accountingName
+
+	return bom != ""
+}
+
+// setDateRange sets our date range from the query, returning false if it
+// doesn't start and end at midnight.
+func (rq *rollupQuery) setDateRange(query *es.Query) bool {
+	lt, lte, gte, err := query.DateRange()
+	if err != nil {
+		return false
+	}
+
+	rq.gte, rq.end = gte, lt
+
+	if lt.IsZero() {
+		rq.end, rq.endInclusive = lte, true
+	}
+
+	return isMidnight(rq.gte) && isMidnight(rq.end) && rq.end.After(rq.gte)
+}
+
+// setWholeDayRange sets our date range from the query, widened to start and
+// end at midnight, returning false if the query has no valid date range.
+func (rq *rollupQuery) setWholeDayRange(query *es.Query) bool {
+	lt, lte, gte, err := query.DateRange()
+	if err != nil {
+		return false
+	}
+
+	end := lt
+	if end.IsZero() {
+		end = lte.Add(time.Nanosecond)
+	}
+
+	rq.gte = gte.UTC().Truncate(oneDay)
+	rq.end = end.UTC().Truncate(oneDay)
+
+	if !rq.end.Equal(end) {
+		rq.end = rq.end.Add(oneDay)
+	}
+
+	return rq.end.After(rq.gte)
+}
+
+func isMidnight(t time.Time) bool {
+	return t.UTC().Truncate(oneDay).Equal(t)
+}
+
+// rollupOfDays merges the rollups of our cluster's BOM for every day in our
+// date range, not including the end day. Returns false if any of those days
+// haven't been backfilled with rollups, unless we skipMissing, in which case
+// those days are left out.
+func (d *DB) rollupOfDays(rq *rollupQuery) (rollup, bool, error) {
+	r := make(rollup)
+
+	for day := rq.gte; day.Before(rq.end); day = day.Add(oneDay) {
+		dayR, ok, err := d.rollupOfDay(day, clusterBOMDir(rq.cluster, rq.bom))
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !ok {
+			if rq.skipMissing {
+				continue
+			}
+
+			return nil, false, nil
+		}
+
+		r.merge(dayR)
+	}
+
+	return r, true, nil
+}
+
+// rollupOfDay reads the rollup of the given BOM directory on the given day. A
+// day with no hits for the BOM has an empty rollup. Returns false if the day
+// hasn't been (successfully) backfilled, was backfilled before we wrote
+// rollups, or only has hour segments so far.
+func (d *DB) rollupOfDay(day time.Time, bomDirName string) (rollup, bool, error) {
+	bomDir, ok := d.completeDayBOMDir(day, bomDirName)
+	if !ok {
+		return nil, false, nil
+	}
+
+	r, err := readRollup(bomDir)
+	if err == nil {
+		return r, true, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, err
+	}
+
+	if bomDirMissing(bomDir) {
+		return make(rollup), true, nil
+	}
+
+	return nil, false, nil
+}
+
+// completeDayBOMDir returns the path of the given BOM directory on the given
+// day, or false if the day hasn't been (successfully) backfilled or only has
+// hour segments so far.
+func (d *DB) completeDayBOMDir(day time.Time, bomDirName string) (string, bool) {
+	dayDir := d.dateFolder(day)
+
+	if d.isPartialDay(dayDir) {
+		return "", false
+	}
+
+	dayDir = d.realDayDir(dayDir)
+
+	if d.checkBackfillSuccess {
+		if _, err := os.Stat(filepath.Join(dayDir, successBasename)); err != nil {
+			return "", false
+		}
+	}
+
+	return filepath.Join(dayDir, bomDirName), true
+}
+
+// bomDirMissing returns true if the given BOM directory of a complete day
+// doesn't exist, meaning the day had no hits for the BOM.
+func bomDirMissing(bomDir string) bool {
+	_, err := os.Stat(bomDir)
+
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// addBoundaryHitsToRollup adds the hits of the given query that have exactly
+// the given timestamp to the rollup, since rollups of whole days don't let us
+// include a date range's lte.
+func (d *DB) addBoundaryHitsToRollup(query *es.Query, lte time.Time, r rollup) error {
+	result, err := d.Scroll(boundaryQuery(query, lte))
+	if err != nil {
+		return err
+	}
+
+	defer d.Done(result.PoolKey)
+
+	for _, hit := range result.HitSet.Hits {
+		r.add(hit.Details)
+	}
+
+	return nil
+}
+
+// boundaryQuery returns a copy of the given query with its date range replaced
+// by one that only includes the given time.
+func boundaryQuery(query *es.Query, t time.Time) *es.Query {
+	ts := timestamp(t)
+	filter := es.Filter{{"range": {"timestamp": map[string]interface{}{"gte": ts, "lte": ts}}}}
+
+	for _, f := range query.Query.Bool.Filter {
+		if _, ok := f["range"]; !ok {
+			filter = append(filter, f)
+		}
+	}
+
+	return (&es.Query{
+		Query: &es.QueryFilter{Bool: es.QFBool{Filter: filter}},
+	}).WithContext(query.Context())
+}
+
+// aggBucket is a rollup bucket keyed on our aggregated fields.
+type aggBucket struct {
+	key         []interface{}
+	keyAsString string
+	vals        *rollupValues
+}
+
+// result converts the given rollup in to the Result elasticsearch would have
+// given for our query.
+func (rq *rollupQuery) result(r rollup) *es.Result {
+	buckets, total := rq.buckets(r)
+
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].vals.DocCount != buckets[j].vals.DocCount {
+			return buckets[i].vals.DocCount > buckets[j].vals.DocCount
+		}
+
+		return buckets[i].keyAsString < buckets[j].keyAsString
+	})
+
+	if len(buckets) > rq.size {
+		buckets = buckets[:rq.size]
+	}
+
+	result := es.NewResult()
+	result.HitSet.Total.Value = int(total)
+	result.Aggregations = &es.Aggregations{Stats: &es.Buckets{Buckets: make([]interface{}, len(buckets))}}
+
+	for i, b := range buckets {
+		result.Aggregations.Stats.Buckets[i] = rq.bucketJSON(b)
+	}
+
+	return result
+}
+
+// buckets groups the rollup's values by our aggregated fields, skipping any
+// that don't match our ACCOUNTING_NAME filter. Also returns the total number
+// of hits in the buckets.
+func (rq *rollupQuery) buckets(r rollup) ([]*aggBucket, int64) {
+	byKey := make(map[string]*aggBucket)
+
+	var total int64
+
+	for rk, vals := range r {
+		if rq.accountingName != "" && rk.AccountingName != rq.accountingName {
+			continue
+		}
+
+		key, keyStr := rq.bucketKey(rk)
+
+		b, ok := byKey[keyStr]
+		if !ok {
+			b = &aggBucket{key: key, keyAsString: keyStr, vals: newRollupValues()}
+			byKey[keyStr] = b
+		}
+
+		b.vals.merge(vals)
+
+		total += vals.DocCount
+	}
+
+	buckets := make([]*aggBucket, 0, len(byKey))
+	for _, b := range byKey {
+		buckets = append(buckets, b)
+	}
+
+	return buckets, total
+}
+
+// bucketKey returns the values of our aggregated fields in the given rollupKey,
+// and those values joined as a string.
+func (rq *rollupQuery) bucketKey(rk rollupKey) ([]interface{}, string) {
+	key := make([]interface{}, len(rq.fields))
+	strs := make([]string, len(rq.fields))
+
+	for i, field := range rq.fields {
+		switch field {
+		case aggFieldAccounting:
+			key[i], strs[i] = rk.AccountingName, rk.AccountingName
+		case aggFieldProcs:
+			key[i], strs[i] = rk.NumExecProcs, strconv.FormatInt(rk.NumExecProcs, 10)
+		case aggFieldJob:
+			key[i], strs[i] = rk.Job, rk.Job
+		}
+	}
+
+	return key, strings.Join(strs, aggKeySeparator)
+}
+
+// bucketJSON returns the given bucket in the form elasticsearch would.
+func (rq *rollupQuery) bucketJSON(b *aggBucket) map[string]interface{} {
+	bucket := map[string]interface{}{"doc_count": b.vals.DocCount}
+
+	if rq.multi {
+		bucket["key"] = b.key
+		bucket["key_as_string"] = b.keyAsString
+	} else {
+		bucket["key"] = b.key[0]
+	}
+
+	for name, agg := range rq.subAggs {
+		value := b.vals.WastedCost
+		if agg.Sum != nil {
+			value = b.vals.Sums[agg.Sum.Field]
+		}
+
+		bucket[name] = map[string]float64{"value": value}
+	}
+
+	return bucket
+}
@@ -0,0 +1,275 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestAggregate(t *testing.T) {
+	Convey("Given a database with stored hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		lte := gte.Add(oneDay)
+		result := makeResult(gte, lte.Add(10*time.Second))
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for _, hit := range result.HitSet.Hits {
+			hitCh <- &hit
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		_, err = os.Stat(filepath.Join(config.Directory, "2024", "02", "04", "bomB", rollupBasename))
+		So(err, ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := reportAggQuery(gte, lte, "bomB")
+
+		Convey("You can answer the report's aggregation query from rollups", func() {
+			aggResult, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			expected := expectedAggBuckets(result, lte, "bomB")
+			So(aggResult.HitSet.Total.Value, ShouldEqual, 43201)
+			So(aggResult.Aggregations.Stats.Buckets, ShouldHaveLength, len(expected))
+
+			for i, b := range aggResult.Aggregations.Stats.Buckets {
+				bucket, ok := b.(map[string]interface{})
+				So(ok, ShouldBeTrue)
+
+				exp := expected[i]
+				So(bucket["key"], ShouldResemble, []interface{}{exp.AccountingName, int64(3), "job"})
+				So(bucket["key_as_string"], ShouldEqual, exp.AccountingName+"|3|job")
+				So(bucket["doc_count"], ShouldEqual, exp.DocCount)
+				So(bucket["cpu_wasted_sec"], ShouldResemble,
+					map[string]float64{"value": exp.Sums["WASTED_CPU_SECONDS"]})
+				So(bucket["wasted_cost"].(map[string]float64)["value"], ShouldAlmostEqual, exp.WastedCost, 1e-9) //nolint:forcetypeassert
+			}
+		})
+
+		Convey("An lt date range doesn't include hits at the end time", func() {
+			query.Query.Bool.Filter[1] = rangeFilter("lt", gte, lte)
+
+			aggResult, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(aggResult.HitSet.Total.Value, ShouldEqual, 43200)
+		})
+
+		Convey("You can aggregate on a single term and filter on ACCOUNTING_NAME", func() {
+			query.Aggs = &es.Aggs{Stats: es.AggsStats{Terms: &es.Field{Field: "ACCOUNTING_NAME"}}}
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": {"ACCOUNTING_NAME": "groupA"}})
+
+			aggResult, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(aggResult.Aggregations.Stats.Buckets, ShouldHaveLength, 1)
+
+			bucket, ok := aggResult.Aggregations.Stats.Buckets[0].(map[string]interface{})
+			So(ok, ShouldBeTrue)
+			So(bucket["key"], ShouldEqual, "groupA")
+			So(bucket["doc_count"], ShouldEqual, aggResult.HitSet.Total.Value)
+		})
+
+		Convey("Queries that rollups can't answer are not answered", func() {
+			for _, mutate := range []func(){
+				func() { query.Query.Bool.Filter[1] = rangeFilter("lte", gte.Add(time.Hour), lte) },
+				func() { query.Query.Bool.Filter[1] = rangeFilter("lte", gte, lte.Add(time.Hour)) },
+				func() { query.Query.Bool.Filter = query.Query.Bool.Filter[:2] },
+				func() { query.Size = 1 },
+				func() {
+					query.Aggs = &es.Aggs{Stats: es.AggsStats{Terms: &es.Field{Field: "USER_NAME"}}}
+				},
+				func() {
+					query.Aggs = &es.Aggs{Stats: es.AggsStats{
+						Terms: &es.Field{Field: "Job"},
+						Aggs:  map[string]es.AggsField{"x": {Sum: &es.Field{Field: "USER_NAME"}}},
+					}}
+				},
+				func() {
+					query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+						map[string]es.MapStringStringOrMap{"prefix": {"Job": "j"}})
+				},
+			} {
+				query = reportAggQuery(gte, lte, "bomB")
+				mutate()
+
+				_, ok, err := db.Aggregate(query)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+			}
+		})
+
+		Convey("Days without rollups are not answered, but days without the BOM are", func() {
+			err = os.Remove(filepath.Join(config.Directory, "2024", "02", "04", "bomB", rollupBasename))
+			So(err, ShouldBeNil)
+
+			_, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			aggResult, ok, err := db.Aggregate(reportAggQuery(gte.Add(-oneDay), gte, "bomB"))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(aggResult.HitSet.Total.Value, ShouldEqual, 1)
+		})
+
+		Convey("Local-only answers widen the date range to whole days", func() {
+			wholeDays, ok, err := db.Aggregate(reportAggQuery(gte, lte.Add(oneDay), "bomB"))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(wholeDays.HitSet.Total.Value, ShouldBeGreaterThan, 43201)
+
+			query.Query.Bool.Filter[1] = rangeFilter("lte", gte.Add(time.Hour), lte.Add(time.Hour))
+
+			_, ok, err = db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			aggResult, ok, err := db.AggregateLocalOnly(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(aggResult.HitSet.Total.Value, ShouldEqual, wholeDays.HitSet.Total.Value)
+
+			Convey("and skip days without rollups", func() {
+				err = os.Remove(filepath.Join(config.Directory, "2024", "02", "04", "bomB", rollupBasename))
+				So(err, ShouldBeNil)
+
+				lastDay, ok, err := db.Aggregate(reportAggQuery(lte, lte.Add(oneDay), "bomB"))
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+
+				aggResult, ok, err = db.AggregateLocalOnly(query)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+				So(aggResult.HitSet.Total.Value, ShouldEqual, lastDay.HitSet.Total.Value)
+			})
+		})
+	})
+}
+
+// reportAggQuery returns the aggregation query that the farmer's report makes.
+func reportAggQuery(gte, lte time.Time, bom string) *es.Query {
+	return &es.Query{
+		Aggs: &es.Aggs{
+			Stats: map[string]interface{}{
+				"multi_terms": map[string]interface{}{
+					"terms": []interface{}{
+						map[string]interface{}{"field": "ACCOUNTING_NAME"},
+						map[string]interface{}{"field": "NUM_EXEC_PROCS"},
+						map[string]interface{}{"field": "Job"},
+					},
+					"size": es.MaxSize,
+				},
+				"aggs": map[string]interface{}{
+					"cpu_wasted_sec": map[string]interface{}{
+						"sum": map[string]interface{}{"field": "WASTED_CPU_SECONDS"},
+					},
+					"wasted_cost": map[string]interface{}{
+						"scripted_metric": map[string]interface{}{
+							"init_script": "state.costs = []",
+							"map_script":  wastedCostMapScript,
+							"params": map[string]interface{}{
+								"cpu_second": wastedCostCPUSecond,
+								"mb_second":  wastedCostMBSecond,
+							},
+						},
+					},
+				},
+			},
+		},
+		Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+			{"match_phrase": {"META_CLUSTER_NAME": "farm"}},
+			rangeFilter("lte", gte, lte),
+			{"match_phrase": {"BOM": bom}},
+		}}},
+	}
+}
+
+func rangeFilter(endKind string, gte, end time.Time) map[string]es.MapStringStringOrMap {
+	return map[string]es.MapStringStringOrMap{"range": {"timestamp": map[string]interface{}{
+		"gte":    timestamp(gte),
+		endKind:  timestamp(end),
+		"format": "strict_date_optional_time",
+	}}}
+}
+
+// expectedAggBuckets rolls up the hits of the given BOM up to and including
+// lte by brute force, returning entries sorted as Aggregate() sorts buckets.
+func expectedAggBuckets(result *es.Result, lte time.Time, bom string) []rollupEntry {
+	r := make(rollup)
+
+	for _, hit := range result.HitSet.Hits {
+		if hit.Details.BOM == bom && hit.Details.Timestamp <= lte.Unix() {
+			r.add(hit.Details)
+		}
+	}
+
+	entries := make([]rollupEntry, 0, len(r))
+	for key, vals := range r {
+		entries = append(entries, rollupEntry{rollupKey: key, rollupValues: *vals})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DocCount > entries[j].DocCount
+	})
+
+	return entries
+}
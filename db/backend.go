@@ -0,0 +1,124 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrUnknownBackend = "unknown database backend"
+
+	// BackendFlat is the Config.Backend for a DB.
+	BackendFlat = "flat"
+
+	// BackendSQLite is the Config.Backend for an SQLiteDB.
+	BackendSQLite = "sqlite"
+)
+
+// Backend is a local database of elasticsearch hit details that can answer
+// scroll queries. It is implemented by DB and SQLiteDB.
+type Backend interface {
+	Store(hitCh chan *es.Hit) error
+	Scroll(query *es.Query) (*es.Result, error)
+	MultiScroll(queries []*es.Query) ([]*es.Result, error)
+	Done(poolKey int) bool
+	Usernames(query *es.Query) ([]string, error)
+	DistinctValues(query *es.Query, field string) ([]string, error)
+	DistinctCounts(query *es.Query, field string) (map[string]int, error)
+	Count(query *es.Query) (int, error)
+	Aggregate(query *es.Query) (*es.Result, bool, error)
+	TeamSummary(ctx context.Context, cluster, bom string, first, last time.Time) (*TeamSummary, error)
+	GPUReport(ctx context.Context, cluster, bom string, first, last time.Time) (*GPUReport, error)
+	Coverage(ctx context.Context) ([]DayCoverage, error)
+	DataThrough() time.Time
+	DataVersion() string
+	Reload() error
+	SetUpdateFrequency(frequency time.Duration)
+	Drain(ctx context.Context) error
+	Close() error
+}
+
+// Open returns a New() DB or a NewSQLite() SQLiteDB, depending on the
+// configured Backend. checkBackfillSuccess is only used by DB.
+func Open(config Config, checkBackfillSuccess bool) (Backend, error) {
+	switch config.Backend {
+	case "", BackendFlat:
+		return New(config, checkBackfillSuccess)
+	case BackendSQLite:
+		return NewSQLite(config)
+	}
+
+	return nil, Error{Msg: ErrUnknownBackend, cause: config.Backend}
+}
+
+// dayBackfiller is a Backend that Backfill() can store whole days of hits in.
+type dayBackfiller interface {
+	// startDay returns false if the given day was already backfilled.
+	// Otherwise it removes anything partially stored for that day.
+	startDay(day time.Time) (bool, error)
+
+	// storeDay stores the given day's hits from the channel.
+	storeDay(day time.Time, hitCh chan *es.Hit) error
+
+	// finishDay records that the given day was completely stored.
+	finishDay(day time.Time) error
+}
+
+// dayRefiller is a dayBackfiller that Refill() can store days in again.
+type dayRefiller interface {
+	dayBackfiller
+
+	// restartDay prepares to store the given day again, even if it was
+	// already backfilled, by forgetting that it was.
+	restartDay(day time.Time) error
+}
+
+// newDayBackfiller returns a dayRefiller (which is also a dayBackfiller) for
+// the configured Backend, along with a function to call when you're done with
+// it.
+func newDayBackfiller(config Config) (dayRefiller, func() error, error) {
+	if err := config.checkWritable(); err != nil {
+		return nil, nil, err
+	}
+
+	switch config.Backend {
+	case "", BackendFlat:
+		return newDBStruct(config, true), func() error { return nil }, nil
+	case BackendSQLite:
+		s, err := NewSQLite(config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return s, s.Close, nil
+	}
+
+	return nil, nil, Error{Msg: ErrUnknownBackend, cause: config.Backend}
+}
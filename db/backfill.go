@@ -27,9 +27,14 @@ package db
 
 import (
 	"context"
+	"errors"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
@@ -38,9 +43,13 @@ import (
 
 const (
 	ErrAlreadyExists = "database directory already exists"
+	ErrInvalidRange  = "first day is after last day"
 
-	maxSimultaneousBackfills = 16
-	successBasename          = ".backfill_successful"
+	defaultMaxSimultaneousBackfills = 16
+	defaultBackfillRetryDelay       = 10 * time.Second
+	successBasename                 = ".backfill_successful"
+	backfillingBasename             = ".backfilling"
+	replacedSuffix                  = ".replaced"
 )
 
 // Scroller types have a Scroll function for querying something like elastic
@@ -54,66 +63,310 @@ type Scroller interface {
 //
 // If the configured database directory already has any results for a particular
 // day, that day will be skipped.
-func Backfill(client Scroller, config Config, from time.Time, period time.Duration) (err error) {
-	ldb := newDBStruct(config, true)
+//
+// With the default BackendFlat, each day is stored in a temporary directory and
+// only renamed in to place once complete, so a crashed Backfill() never leaves
+// partial days where a DB would load them.
+//
+// Hits are stored in the configured Backend, which defaults to BackendFlat. If
+// the configured SkipBadHits is true, a summary of any hits that were skipped
+// is logged at the end.
+//
+// Days that fail are retried up to the configured BackfillRetries times, with
+// exponential backoff. The returned BackfillReport says what happened to each
+// day. If a day ultimately fails, the other days are still backfilled, and the
+// first error is returned along with the report.
+func Backfill(client Scroller, config Config, from time.Time,
+	period time.Duration) (report *BackfillReport, err error) {
+	ldb, closer, err := newDayBackfiller(config)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if errc := closer(); err == nil {
+			err = errc
+		}
+	}()
+
+	defer logSkippedHits(ldb)
+
+	ldb = verifyCountsIfConfigured(client, config, ldb)
+
+	return backfillByDay(client, ldb, from, period, config)
+}
+
+// logSkippedHits logs a summary of the hits the given dayBackfiller skipped, if
+// it can skip hits and it skipped any.
+func logSkippedHits(ldb dayBackfiller) {
+	s, ok := ldb.(interface{ SkippedHits() SkippedHits })
+	if !ok {
+		return
+	}
+
+	skipped := s.SkippedHits()
+	if skipped.Total == 0 {
+		return
+	}
+
+	slog.Warn("skipped bad hits", "total", skipped.Total, "reasons", skipped.Reasons, "ids", skipped.IDs)
+}
+
+// BackfillRange is like Backfill(), but backfills every day from the day of
+// the given first time to the day of the given last time, inclusive. Days
+// already backfilled are skipped, as with Backfill().
+func BackfillRange(client Scroller, config Config, first, last time.Time) (report *BackfillReport, err error) {
+	first, last = first.UTC().Truncate(oneDay), last.UTC().Truncate(oneDay)
+
+	if last.Before(first) {
+		return nil, Error{Msg: ErrInvalidRange, cause: timestamp(first) + " > " + timestamp(last)}
+	}
+
+	ldb, closer, err := newDayBackfiller(config)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if errc := closer(); err == nil {
+			err = errc
+		}
+	}()
+
+	defer logSkippedHits(ldb)
+
+	ldb = verifyCountsIfConfigured(client, config, ldb)
+
+	return backfillDays(client, ldb, first, last, config)
+}
+
+// Refill is like BackfillRange(), but backfills the given days even if they
+// were already backfilled, replacing what was stored for them. Use this when
+// the elastic search data of some days was corrected after we stored it.
+//
+// With the default BackendFlat, a day's existing files remain queryable until
+// its new files replace them, but the day's success sentinel is removed first,
+// so that if its Refill() fails, the next Backfill() will do it again. Note
+// that other servers sharing a configured ObjectStore won't download the new
+// files of a day they already have.
+func Refill(client Scroller, config Config, days ...time.Time) (report *BackfillReport, err error) {
+	ldb, closer, err := newDayBackfiller(config)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if errc := closer(); err == nil {
+			err = errc
+		}
+	}()
+
+	defer logSkippedHits(ldb)
 
-	return backfillByDay(client, ldb, from, period)
+	ldb = verifyCountsIfConfigured(client, config, ldb)
+
+	return backfillEachDay(client, ldb, uniqueDays(days), func(day time.Time) (bool, error) {
+		return true, ldb.restartDay(day)
+	}, config)
+}
+
+// uniqueDays returns the midnights UTC of the given times, without duplicates.
+func uniqueDays(times []time.Time) []time.Time {
+	seen := make(map[time.Time]bool)
+	days := make([]time.Time, 0, len(times))
+
+	for _, t := range times {
+		day := t.UTC().Truncate(oneDay)
+		if seen[day] {
+			continue
+		}
+
+		seen[day] = true
+		days = append(days, day)
+	}
+
+	return days
 }
 
-func backfillByDay(client Scroller, ldb *DB, from time.Time, period time.Duration) error {
+func backfillByDay(client Scroller, ldb dayBackfiller, from time.Time, period time.Duration,
+	config Config) (*BackfillReport, error) {
 	gte, lt := timeRange(from, period)
+
+	return backfillDays(client, ldb, gte.Add(-oneDay), lt.Add(-oneDay), config)
+}
+
+// backfillDays backfills each day from first to last, inclusive, where first
+// and last are midnights.
+func backfillDays(client Scroller, ldb dayBackfiller, first, last time.Time, config Config) (*BackfillReport, error) {
+	var days []time.Time
+
+	for day := first; !day.After(last); day = day.Add(oneDay) {
+		days = append(days, day)
+	}
+
+	return backfillEachDay(client, ldb, days, ldb.startDay, config)
+}
+
+// backfillEachDay backfills the given days, at most the configured
+// MaxSimultaneousBackfills at once, after calling start for each one, which
+// returns false if the day should be skipped. Failed days are retried as per
+// backfillDay(). If a day ultimately fails, the other days are still done
+// before returning the first error. The returned report is never nil.
+func backfillEachDay(client Scroller, ldb dayBackfiller, days []time.Time,
+	start func(time.Time) (bool, error), config Config) (*BackfillReport, error) {
+	report := &BackfillReport{}
 	g, _ := errgroup.WithContext(context.Background())
-	g.SetLimit(maxSimultaneousBackfills)
+	g.SetLimit(config.MaxSimultaneousBackfillsOrDefault())
+
+	progress := &dayProgress{report: config.BackfillProgress, total: len(days)}
 
-	for !gte.After(lt) {
-		from, lt := timeRange(gte, oneDay)
-		gte = gte.Add(oneDay)
+	var startErr error
 
-		successPath, err := checkIfNeeded(ldb, from)
+	for _, day := range days {
+		needed, err := start(day)
 		if err != nil {
-			return err
+			report.addFailedDay(day, err)
+
+			if startErr == nil {
+				startErr = err
+			}
+
+			progress.dayDone()
+
+			continue
 		}
 
-		if successPath == "" {
+		if !needed {
+			report.addDay(day, DaySkipped)
+			progress.dayDone()
+
 			continue
 		}
 
+		dr := report.addDay(day, DayFailed)
+
 		g.Go(func() error {
-			return queryElasticAndStoreLocally(client, ldb, from, lt, successPath)
+			defer progress.dayDone()
+
+			return backfillDay(client, ldb, day, start, config, dr)
 		})
 	}
 
-	return g.Wait()
+	err := g.Wait()
+
+	report.tally()
+
+	if startErr != nil {
+		return report, startErr
+	}
+
+	return report, err
 }
 
-func queryElasticAndStoreLocally(client Scroller, ldb *DB, gte, lt time.Time, successPath string) error {
-	query := rangeQuery(gte, lt)
+// dayProgress calls a Config.BackfillProgress, if any, as days are finished.
+type dayProgress struct {
+	report func(done, total int)
+	total  int
+	mu     sync.Mutex
+	done   int
+}
+
+// dayDone records that another day is finished and reports our progress.
+func (p *dayProgress) dayDone() {
+	if p.report == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	p.report(p.done, p.total)
+}
+
+// backfillDay stores the hits of the given already started day, recording the
+// outcome in the given DayReport. If that fails, it waits the configured
+// BackfillRetryDelayOrDefault(), starts the day again, and retries, up to the
+// configured BackfillRetries times, doubling the wait each time.
+func backfillDay(client Scroller, ldb dayBackfiller, day time.Time,
+	start func(time.Time) (bool, error), config Config, dr *DayReport) error {
+	t := time.Now()
+	delay := config.BackfillRetryDelayOrDefault()
+
+	for attempt := 1; ; attempt++ {
+		dr.Attempts = attempt
+
+		hits, err := attemptDay(client, ldb, day, start, config.ClustersOrDefault(), attempt > 1)
+		if err == nil || attempt > config.BackfillRetries {
+			dr.finish(t, hits, err)
+
+			return err
+		}
+
+		slog.Warn("backfill of day failed; will retry", "day", timestamp(day),
+			"attempt", attempt, "delay", delay, "err", err)
+
+		time.Sleep(delay)
+
+		delay *= 2
+	}
+}
+
+// attemptDay stores the hits of the given clusters on the given day, first
+// starting it again if restart is true. If the day is no longer needed (eg.
+// because it was completed by a previous attempt), it returns 0 hits and no
+// error.
+func attemptDay(client Scroller, ldb dayBackfiller, day time.Time,
+	start func(time.Time) (bool, error), clusters []string, restart bool) (int, error) {
+	if restart {
+		needed, err := start(day)
+		if err != nil || !needed {
+			return 0, err
+		}
+	}
+
+	return queryElasticAndStoreLocally(client, ldb, day, day.Add(oneDay), clusters)
+}
+
+// queryElasticAndStoreLocally stores the hits of the given clusters from gte to
+// lt in the given dayBackfiller, returning the number of hits elastic search
+// gave us. Each cluster is queried in turn, and its hits are tagged with it.
+func queryElasticAndStoreLocally(client Scroller, ldb dayBackfiller, gte, lt time.Time,
+	clusters []string) (int, error) {
 	t := time.Now()
 	hitCh := make(chan *es.Hit)
 	errCh := make(chan error)
+	var hits atomic.Int64
+
 	cb := func(hit *es.Hit) {
+		hits.Add(1)
 		hitCh <- hit
 	}
 
 	go func() {
-		_, err := client.Scroll(query, cb)
+		err := scrollClusters(client, gte, lt, clusters, cb)
 		close(hitCh)
 		errCh <- err
 	}()
 
-	err := ldb.Store(hitCh)
+	err := ldb.storeDay(gte, hitCh)
 	if err != nil {
-		return err
+		for range hitCh { //nolint:revive
+		}
+
+		<-errCh
+
+		return int(hits.Load()), err
 	}
 
 	err = <-errCh
 	if err != nil {
-		return err
+		return int(hits.Load()), err
 	}
 
 	slog.Info("search&store successful", "took", time.Since(t), "gte", timestamp(gte), "lte", timestamp(lt))
 
-	return recordSuccess(successPath)
+	return int(hits.Load()), ldb.finishDay(gte)
 }
 
 func timeRange(from time.Time, period time.Duration) (time.Time, time.Time) {
@@ -131,34 +384,160 @@ func timeRange(from time.Time, period time.Duration) (time.Time, time.Time) {
 
 // checkIfNeeded returns the path of the success file you should create after
 // successfully storing the data for this day, if this day hasn't already been
-// done. So blank means skip.
+// done, locally or in our ObjectStore. So blank means skip.
 func checkIfNeeded(ldb *DB, day time.Time) (string, error) {
 	dir := ldb.dateFolder(day)
 	successPath := filepath.Join(dir, successBasename)
 
-	_, err := os.Stat(successPath)
-	if err == nil {
+	done, err := dayAlreadyBackfilled(ldb, successPath)
+	if err != nil {
+		return "", err
+	}
+
+	if done {
 		slog.Info("skip completed day", "gte", timestamp(day))
 
 		return "", nil
 	}
 
-	var returnErr error
+	tmpDir := ldb.backfillingDateFolder(day)
+
+	return successPath, removeAll(tmpDir, tmpDir+replacedSuffix)
+}
+
+// isHiddenTopDir returns true if the given path is a directory directly within
+// the given database directory whose name starts with a dot, like our
+// backfillingDir(). Such directories don't contain days that should be loaded.
+func isHiddenTopDir(dbDir, path string) bool {
+	return filepath.Dir(path) == filepath.Clean(dbDir) && strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// backfillingDir is the directory within our own that days are stored in while
+// they are being backfilled, so that a day only appears in our own directory
+// once it is complete.
+func (d *DB) backfillingDir() string {
+	return filepath.Join(d.dir, backfillingBasename)
+}
 
-	_, err = os.Stat(dir)
-	if err == nil {
-		returnErr = os.RemoveAll(dir)
+// backfillingDateFolder is like dateFolder(), but in our backfillingDir().
+func (d *DB) backfillingDateFolder(day time.Time) string {
+	return filepath.Join(d.backfillingDir(), day.UTC().Format(dateFormat))
+}
+
+// startDay returns false if the given day was already backfilled. Otherwise it
+// removes any partially stored files for that day left in our backfillingDir()
+// by a previous crashed backfill. Anything in the day's directory itself (eg.
+// hour segments from BackfillHours()) is left to be queried until finishDay()
+// replaces it.
+func (d *DB) startDay(day time.Time) (bool, error) {
+	successPath, err := checkIfNeeded(d, day)
+
+	return successPath != "", err
+}
+
+// restartDay removes anything left in our backfillingDir() for the given day by
+// a previous crashed backfill, and the day's success sentinel. The day's other
+// files are left to be queried until finishDay() replaces them.
+func (d *DB) restartDay(day time.Time) error {
+	tmpDir := d.backfillingDateFolder(day)
+
+	return removeAll(tmpDir, tmpDir+replacedSuffix, filepath.Join(d.dateFolder(day), successBasename))
+}
+
+// storeDay is Store(), for dayBackfiller, but stores the hits in our
+// backfillingDir().
+func (d *DB) storeDay(_ time.Time, hitCh chan *es.Hit) error {
+	return d.storeUnder(d.backfillingDir(), hitCh)
+}
+
+// finishDay creates the success sentinel file for the given day, renames the
+// day's directory from our backfillingDir() in to place (replacing any existing
+// incomplete directory for the day), and puts the day's files in our
+// ObjectStore if we have one.
+func (d *DB) finishDay(day time.Time) error {
+	dir := d.dateFolder(day)
+	tmpDir := d.backfillingDateFolder(day)
+
+	if err := recordSuccess(filepath.Join(tmpDir, successBasename)); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), dbDirPerms); err != nil {
+		return err
+	}
+
+	if err := replaceDir(tmpDir, dir); err != nil {
+		return err
+	}
+
+	if d.objectStore == nil {
+		return nil
+	}
+
+	return d.uploadDay(dir)
+}
+
+// replaceDir renames from to to. If to already exists, it is first moved aside
+// to from+replacedSuffix, and removed afterwards.
+func replaceDir(from, to string) error {
+	replaced := from + replacedSuffix
+
+	err := os.Rename(to, replaced)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err = os.Rename(from, to); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(replaced)
+}
+
+func dayAlreadyBackfilled(ldb *DB, successPath string) (bool, error) {
+	if _, err := os.Stat(successPath); err == nil {
+		return true, nil
 	}
 
-	return successPath, returnErr
+	if ldb.backfilledInExtraDir(filepath.Dir(successPath)) {
+		return true, nil
+	}
+
+	if ldb.objectStore == nil {
+		return false, nil
+	}
+
+	return ldb.objectStoreHasDay(filepath.Dir(successPath))
+}
+
+// scrollClusters scrolls the hits of each of the given clusters from gte to lt
+// in turn, setting each hit's Cluster before passing it to the callback.
+func scrollClusters(client Scroller, gte, lt time.Time, clusters []string, cb func(*es.Hit)) error {
+	for _, cluster := range clusters {
+		_, err := client.Scroll(clusterRangeQuery(gte, lt, cluster), func(hit *es.Hit) {
+			hit.Cluster = cluster
+			cb(hit)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func rangeQuery(from time.Time, to time.Time) *es.Query {
+	return clusterRangeQuery(from, to, DefaultCluster)
+}
+
+// clusterRangeQuery is like rangeQuery(), but for the hits of the given
+// cluster.
+func clusterRangeQuery(from time.Time, to time.Time, cluster string) *es.Query {
 	return &es.Query{
 		Size: es.MaxSize,
 		Sort: []string{"timestamp", "_doc"},
 		Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
-			{"match_phrase": map[string]interface{}{"META_CLUSTER_NAME": "farm"}},
+			{"match_phrase": map[string]interface{}{clusterField: cluster}},
 			{"range": map[string]interface{}{
 				"timestamp": map[string]string{
 					"lt":     timestamp(to),
@@ -175,15 +554,16 @@ func timestamp(t time.Time) string {
 }
 
 // recordSuccess creates an empty sential file so that we know we stored a whole
-// day's hits. In case there were no hits for that day, we first make the
-// directory (otherwise DB.Store() would have made it).
+// day's hits, unless it already exists (eg. because recordCount() wrote to it).
+// In case there were no hits for that day, we first make the directory
+// (otherwise DB.Store() would have made it).
 func recordSuccess(path string) error {
 	err := os.MkdirAll(filepath.Dir(path), dbDirPerms)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Create(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, dbFilePerms)
 	if err != nil {
 		return err
 	}
@@ -26,11 +26,13 @@
 package db
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,6 +40,35 @@ import (
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
 )
 
+// flakyScroller is an es.Mock that fails its first failures Scroll()s of the
+// given day, after giving all that day's hits.
+type flakyScroller struct {
+	*es.Mock
+	day      string
+	failures int
+	mu       sync.Mutex
+}
+
+func (f *flakyScroller) Scroll(query *es.Query, cb es.HitsCallBack) (*es.Result, error) {
+	result, err := f.Mock.Scroll(query, cb)
+
+	timeRange := query.Query.Bool.Filter[1]["range"]["timestamp"].(map[string]string) //nolint:errcheck,forcetypeassert
+	if timeRange["gte"] != f.day {
+		return result, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failures == 0 {
+		return result, err
+	}
+
+	f.failures--
+
+	return nil, Error{Msg: "flaky"}
+}
+
 func TestBackfill(t *testing.T) {
 	from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
 
@@ -71,7 +102,8 @@ func TestBackfill(t *testing.T) {
 		errCh := make(chan error)
 
 		go func() {
-			errCh <- Backfill(mock, config, from, period)
+			_, errb := Backfill(mock, config, from, period)
+			errCh <- errb
 		}()
 
 		var err error
@@ -93,7 +125,7 @@ func TestBackfill(t *testing.T) {
 		mock := es.NewMock("some-indexes-*")
 		config := Config{Directory: dir}
 
-		err := Backfill(mock, config, from, period)
+		_, err := Backfill(mock, config, from, period)
 		So(err, ShouldBeNil)
 
 		bom := "Human Genetics"
@@ -121,7 +153,7 @@ func TestBackfill(t *testing.T) {
 			err = os.RemoveAll(filepath.Dir(filepath.Dir(localPath31)))
 			So(err, ShouldBeNil)
 
-			err = Backfill(mock, config, from, period)
+			_, err = Backfill(mock, config, from, period)
 			So(err, ShouldBeNil)
 
 			infoRepeat, err := os.Stat(localPath30)
@@ -132,6 +164,21 @@ func TestBackfill(t *testing.T) {
 			So(err, ShouldBeNil)
 		})
 
+		Convey("You can follow the progress of a Backfill()", func() {
+			var progress []string
+
+			config.BackfillProgress = func(done, total int) {
+				progress = append(progress, fmt.Sprintf("%d/%d", done, total))
+			}
+
+			err = os.RemoveAll(filepath.Dir(filepath.Dir(localPath31)))
+			So(err, ShouldBeNil)
+
+			_, err = Backfill(mock, config, from, period)
+			So(err, ShouldBeNil)
+			So(progress, ShouldResemble, []string{"1/3", "2/3", "3/3"})
+		})
+
 		Convey("Repeating Backfill() handles potentially corrupt prior days by starting them from scratch", func() {
 			err = os.Remove(filepath.Join(filepath.Dir(filepath.Dir(localPath31)), successBasename))
 			So(err, ShouldBeNil)
@@ -143,7 +190,7 @@ func TestBackfill(t *testing.T) {
 			err = f.Close()
 			So(err, ShouldBeNil)
 
-			err = Backfill(mock, config, from, period)
+			_, err = Backfill(mock, config, from, period)
 			So(err, ShouldBeNil)
 
 			infoRepeat, err := os.Stat(localPath31)
@@ -153,6 +200,248 @@ func TestBackfill(t *testing.T) {
 			_, err = os.Stat(extraPath)
 			So(err, ShouldNotBeNil)
 		})
+
+		Convey("Refill() stores days again, and a DB loads the new files", func() {
+			dayBOMDir := filepath.Dir(localPath31)
+			fis := db.flatIndexesInDir(dayBOMDir)
+			So(fis, ShouldHaveLength, 1)
+
+			unwatched, errn := New(config, false)
+			So(errn, ShouldBeNil)
+
+			defer unwatched.Close()
+
+			unwatchedFis := unwatched.flatIndexesInDir(dayBOMDir)
+			So(unwatchedFis, ShouldHaveLength, 1)
+
+			lazyConfig := config
+			lazyConfig.LazyLoadDirs = 10
+
+			lazy, errn := New(lazyConfig, true)
+			So(errn, ShouldBeNil)
+
+			defer lazy.Close()
+
+			lazyFis := lazy.flatIndexesInDir(dayBOMDir)
+			So(lazyFis, ShouldHaveLength, 1)
+
+			_, err = Refill(mock, config, from.Add(-oneDay))
+			So(err, ShouldBeNil)
+
+			infoRefill, errs := os.Stat(localPath31)
+			So(errs, ShouldBeNil)
+			So(infoRefill.ModTime(), ShouldHappenAfter, infoOrig31.ModTime())
+
+			infoRefill, errs = os.Stat(localPath30)
+			So(errs, ShouldBeNil)
+			So(infoRefill.ModTime(), ShouldEqual, infoOrig30.ModTime())
+
+			_, errs = os.Stat(filepath.Join(filepath.Dir(dayBOMDir), successBasename))
+			So(errs, ShouldBeNil)
+
+			deadline := time.Now().Add(5 * time.Second)
+			reloaded := db.flatIndexesInDir(dayBOMDir)
+
+			for len(reloaded) == 1 && reloaded[0] == fis[0] && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+
+				reloaded = db.flatIndexesInDir(dayBOMDir)
+			}
+
+			So(reloaded, ShouldHaveLength, 1)
+			So(reloaded[0], ShouldNotPointTo, fis[0])
+
+			result, errs = db.Scroll(query)
+			So(errs, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 2)
+
+			So(unwatched.flatIndexesInDir(dayBOMDir)[0], ShouldPointTo, unwatchedFis[0])
+			So(unwatched.Reload(), ShouldBeNil)
+
+			reloaded = unwatched.flatIndexesInDir(dayBOMDir)
+			So(reloaded, ShouldHaveLength, 1)
+			So(reloaded[0], ShouldNotPointTo, unwatchedFis[0])
+
+			result, errs = unwatched.Scroll(query)
+			So(errs, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 2)
+			unwatched.Done(result.PoolKey)
+
+			deadline = time.Now().Add(5 * time.Second)
+			reloaded = lazy.flatIndexesInDir(dayBOMDir)
+
+			for len(reloaded) == 1 && reloaded[0] == lazyFis[0] && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+
+				reloaded = lazy.flatIndexesInDir(dayBOMDir)
+			}
+
+			So(reloaded, ShouldHaveLength, 1)
+			So(reloaded[0], ShouldNotPointTo, lazyFis[0])
+		})
+
+		Convey("Days are only moved in to place once complete, so a crashed Backfill() leaves nothing to load", func() {
+			err = os.RemoveAll(filepath.Dir(filepath.Dir(localPath31)))
+			So(err, ShouldBeNil)
+
+			crashedDir := filepath.Join(dir, backfillingBasename, "2024", "05", "31", bom)
+			crashedPath := filepath.Join(crashedDir, "0.index")
+
+			err = os.MkdirAll(crashedDir, dbDirPerms)
+			So(err, ShouldBeNil)
+
+			err = copyFile(localPath30, crashedPath)
+			So(err, ShouldBeNil)
+
+			unchecked, err := New(config, false)
+			So(err, ShouldBeNil)
+
+			result, errs = unchecked.Scroll(query)
+			So(errs, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 1)
+			unchecked.Done(result.PoolKey)
+			So(unchecked.Close(), ShouldBeNil)
+
+			_, err = Backfill(mock, config, from, period)
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(localPath31)
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(crashedPath)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a mock elasticsearch client, you can BackfillRange() over specific days", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		dir := t.TempDir()
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: dir, MaxSimultaneousBackfills: 1}
+		So(config.MaxSimultaneousBackfillsOrDefault(), ShouldEqual, 1)
+		So(Config{}.MaxSimultaneousBackfillsOrDefault(), ShouldEqual, defaultMaxSimultaneousBackfills)
+
+		first := time.Date(2024, 05, 30, 0, 0, 0, 0, time.UTC)
+		last := time.Date(2024, 05, 31, 12, 0, 0, 0, time.UTC)
+
+		_, err := BackfillRange(mock, config, first, last)
+		So(err, ShouldBeNil)
+
+		for _, day := range []string{"29", "30", "31"} {
+			_, err = os.Stat(filepath.Join(dir, "2024", "05", day, successBasename))
+
+			if day == "29" {
+				So(err, ShouldNotBeNil)
+			} else {
+				So(err, ShouldBeNil)
+			}
+		}
+
+		_, err = os.Stat(filepath.Join(dir, "2024", "06"))
+		So(err, ShouldNotBeNil)
+
+		Convey("but not backwards", func() {
+			_, err = BackfillRange(mock, config, last, first)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrInvalidRange)
+		})
+	})
+
+	Convey("Given a flaky elasticsearch client, Backfill() retries failed days", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		dir := t.TempDir()
+		scroller := &flakyScroller{Mock: es.NewMock("some-indexes-*"), day: "2024-05-30T00:00:00Z", failures: 2}
+		config := Config{Directory: dir, BackfillRetries: 2, BackfillRetryDelay: time.Millisecond}
+		So(config.BackfillRetryDelayOrDefault(), ShouldEqual, time.Millisecond)
+		So(Config{}.BackfillRetryDelayOrDefault(), ShouldEqual, defaultBackfillRetryDelay)
+
+		report, err := Backfill(scroller, config, from, period)
+		So(err, ShouldBeNil)
+		So(report.Succeeded, ShouldEqual, 3)
+		So(report.Days[0].Attempts, ShouldEqual, 1)
+		So(report.Days[1].Attempts, ShouldEqual, 3)
+		So(report.Days[1].Hits, ShouldEqual, 1)
+
+		db, err := New(config, true)
+		So(err, ShouldBeNil)
+
+		defer db.Close()
+
+		query := rangeQuery(timeRange(from, period))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+		result, err := db.Scroll(query)
+		So(err, ShouldBeNil)
+		So(result.HitSet.Total.Value, ShouldEqual, 2)
+
+		Convey("but gives up after the configured number of retries, still doing other days", func() {
+			dir = t.TempDir()
+			scroller.failures = 2
+			config = Config{Directory: dir, BackfillRetries: 1, BackfillRetryDelay: time.Millisecond}
+
+			report, err = Backfill(scroller, config, from, period)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "flaky")
+			So(report.Succeeded, ShouldEqual, 2)
+			So(report.Failed, ShouldEqual, 1)
+			So(report.Days[1].Status, ShouldEqual, DayFailed)
+			So(report.Days[1].Attempts, ShouldEqual, 2)
+			So(report.Days[1].Error, ShouldEqual, "flaky")
+
+			_, err = os.Stat(filepath.Join(dir, "2024", "05", "30"))
+			So(err, ShouldNotBeNil)
+
+			_, err = os.Stat(filepath.Join(dir, "2024", "05", "31", successBasename))
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("A DB made before a Backfill() finishes sees the new days soon after, even older ones", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		dir := t.TempDir()
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: dir, UpdateFrequency: time.Hour}
+
+		_, err := Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+
+		err = os.RemoveAll(filepath.Join(dir, "2024", "05", "30"))
+		So(err, ShouldBeNil)
+
+		query := rangeQuery(timeRange(from, period))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+		db, err := New(config, true)
+		So(err, ShouldBeNil)
+
+		defer db.Close()
+
+		count, err := db.Count(query)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+		_, err = Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for count == 1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+
+			count, err = db.Count(query)
+			So(err, ShouldBeNil)
+		}
+
+		So(count, ShouldEqual, 2)
+
+		db.muDateBOMDirs.RLock()
+		So(len(db.dateBOMDirs), ShouldEqual, 2)
+		db.muDateBOMDirs.RUnlock()
 	})
 
 	doSlow := os.Getenv("GOFARMER_SLOWTESTS")
@@ -205,7 +494,7 @@ func realBackfillTest(client Scroller, config Config, from time.Time, period tim
 	logger := slog.New(slog.NewTextHandler(&b, nil))
 	slog.SetDefault(logger)
 
-	err := Backfill(client, config, from, period)
+	_, err := Backfill(client, config, from, period)
 	So(err, ShouldBeNil)
 
 	logged := b.String()
@@ -289,7 +578,7 @@ func realBackfillTest(client Scroller, config Config, from time.Time, period tim
 
 	b.Reset()
 
-	err = Backfill(client, config, from, period)
+	_, err = Backfill(client, config, from, period)
 	So(err, ShouldBeNil)
 
 	logged = b.String()
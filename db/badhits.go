@@ -0,0 +1,109 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const maxSkippedHitIDs = 10
+
+// SkippedHits summarises the hits that Store() skipped because they couldn't be
+// stored, when Config.SkipBadHits is true.
+type SkippedHits struct {
+	Total int
+	// Reasons counts the skipped hits by why they couldn't be stored, eg.
+	// ErrFieldTooLong.
+	Reasons map[string]int
+	// IDs are the IDs of the first few hits that were skipped.
+	IDs []string
+}
+
+// badHits decides what happens to hits that can't be stored, keeping track of
+// the ones that were skipped.
+type badHits struct {
+	skip    bool
+	mu      sync.Mutex
+	skipped SkippedHits
+}
+
+func newBadHits(skip bool) *badHits {
+	return &badHits{
+		skip:    skip,
+		skipped: SkippedHits{Reasons: make(map[string]int)},
+	}
+}
+
+// handle returns the given error about the given hit if we're not skipping bad
+// hits. Otherwise, it logs and records the hit as skipped, and returns nil.
+func (b *badHits) handle(hit *es.Hit, err error) error {
+	if !b.skip {
+		return err
+	}
+
+	slog.Warn("skipped bad hit", "id", hit.ID, "err", err)
+
+	reason := err.Error()
+
+	var dbErr Error
+	if errors.As(err, &dbErr) {
+		reason = dbErr.Msg
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.skipped.Total++
+	b.skipped.Reasons[reason]++
+
+	if len(b.skipped.IDs) < maxSkippedHitIDs {
+		b.skipped.IDs = append(b.skipped.IDs, hit.ID)
+	}
+
+	return nil
+}
+
+// SkippedHits returns a summary of the hits that Store() has skipped so far.
+func (b *badHits) SkippedHits() SkippedHits {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	reasons := make(map[string]int, len(b.skipped.Reasons))
+
+	for reason, count := range b.skipped.Reasons {
+		reasons[reason] = count
+	}
+
+	return SkippedHits{
+		Total:   b.skipped.Total,
+		Reasons: reasons,
+		IDs:     append([]string(nil), b.skipped.IDs...),
+	}
+}
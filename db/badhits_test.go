@@ -0,0 +1,100 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestBadHits(t *testing.T) {
+	hits := func() chan *es.Hit {
+		hitCh := make(chan *es.Hit, 3)
+
+		for i, group := range []string{"good", strings.Repeat("long", 10), "good"} {
+			hitCh <- &es.Hit{ID: string(rune('a' + i)), Details: &es.Details{
+				BOM: "bom", AccountingName: group, UserName: "user", Timestamp: 1717113600,
+			}}
+		}
+
+		close(hitCh)
+
+		return hitCh
+	}
+
+	Convey("Given a DB", t, func() {
+		config := Config{Directory: t.TempDir()}
+
+		Convey("Storing a bad hit fails by default", func() {
+			b, err := New(config, false)
+			So(err, ShouldBeNil)
+
+			defer b.Close()
+
+			err = b.Store(hits())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrFieldTooLong)
+		})
+
+		Convey("Bad hits can be skipped instead", func() {
+			config.SkipBadHits = true
+
+			b, err := New(config, false)
+			So(err, ShouldBeNil)
+
+			defer b.Close()
+
+			err = b.Store(hits())
+			So(err, ShouldBeNil)
+
+			So(b.SkippedHits(), ShouldResemble, SkippedHits{
+				Total:   1,
+				Reasons: map[string]int{ErrFieldTooLong: 1},
+				IDs:     []string{"b"},
+			})
+		})
+	})
+
+	Convey("Backfill() can skip bad hits", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		config := Config{Directory: t.TempDir(), SkipBadHits: true}
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+
+		_, err := Backfill(es.NewMock("long_group"), config, from, oneDay)
+		So(err, ShouldBeNil)
+
+		_, err = os.Stat(filepath.Join(config.Directory, "2024", "05", "31", successBasename))
+		So(err, ShouldBeNil)
+	})
+}
@@ -0,0 +1,101 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const ErrBOMNotStored = "BOM is not stored in the local database"
+
+// bomSelection records which BOMs we store, from Config.SkipBOMs and
+// Config.OnlyBOMs. A nil *bomSelection stores all BOMs.
+type bomSelection struct {
+	skip map[string]bool
+	only map[string]bool
+}
+
+// newBOMSelection returns a bomSelection that stores only the given only BOMs
+// (or all BOMs if none are given) apart from the given skip BOMs. Returns nil
+// if no BOMs are given.
+func newBOMSelection(skip, only []string) *bomSelection {
+	if len(skip) == 0 && len(only) == 0 {
+		return nil
+	}
+
+	return &bomSelection{skip: bomSet(skip), only: bomSet(only)}
+}
+
+func bomSet(boms []string) map[string]bool {
+	if len(boms) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(boms))
+
+	for _, bom := range boms {
+		set[sanitiseBOMForFileSystem(bom)] = true
+	}
+
+	return set
+}
+
+// stored returns true if hits with the given BOM should be stored.
+func (b *bomSelection) stored(bom string) bool {
+	if b == nil {
+		return true
+	}
+
+	bom = sanitiseBOMForFileSystem(bom)
+
+	if b.only != nil && !b.only[bom] {
+		return false
+	}
+
+	return !b.skip[bom]
+}
+
+// storedHit returns true if the given hit's BOM should be stored.
+func (b *bomSelection) storedHit(hit *es.Hit) bool {
+	return b.stored(hit.Details.BOM)
+}
+
+// check returns an ErrBOMNotStored Error if the given (filter) BOM isn't
+// stored, so that queries of it fail clearly instead of finding no hits.
+func (b *bomSelection) check(bom string) error {
+	if bom == "" || b.stored(bom) {
+		return nil
+	}
+
+	return Error{Msg: ErrBOMNotStored, cause: bom}
+}
+
+// checkQuery is like check(), for the BOM a query filters on.
+func (b *bomSelection) checkQuery(query *es.Query) error {
+	bom, _, _ := queryToFilters(query)
+
+	return b.check(bom)
+}
@@ -0,0 +1,134 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestBOMSelection(t *testing.T) {
+	day := time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+
+	hits := func() chan *es.Hit {
+		hitCh := make(chan *es.Hit, 3)
+
+		for i, bom := range []string{"keep", "skip", "other"} {
+			hitCh <- &es.Hit{ID: string(rune('a' + i)), Details: &es.Details{
+				BOM: bom, AccountingName: "group", UserName: "user", Timestamp: day.Unix() + int64(i),
+			}}
+		}
+
+		close(hitCh)
+
+		return hitCh
+	}
+
+	bomQuery := func(bom string) *es.Query {
+		query := rangeQuery(day, day.Add(oneDay))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": {"BOM": bom}})
+
+		return query
+	}
+
+	for _, backend := range []string{BackendFlat, BackendSQLite} {
+		Convey("Given a "+backend+" Backend configured to skip a BOM", t, func() {
+			config := Config{Directory: t.TempDir(), Backend: backend, SkipBOMs: []string{"skip"}}
+
+			stored, err := Open(config, false)
+			So(err, ShouldBeNil)
+
+			So(stored.Store(hits()), ShouldBeNil)
+			So(stored.Close(), ShouldBeNil)
+
+			b, err := Open(config, false)
+			So(err, ShouldBeNil)
+
+			defer b.Close()
+
+			Convey("hits of other BOMs are stored and can be queried", func() {
+				for _, bom := range []string{"keep", "other"} {
+					result, errs := b.Scroll(bomQuery(bom))
+					So(errs, ShouldBeNil)
+					So(result.HitSet.Total.Value, ShouldEqual, 1)
+				}
+			})
+
+			Convey("queries of the skipped BOM fail clearly", func() {
+				_, err = b.Scroll(bomQuery("skip"))
+				So(err, ShouldResemble, Error{Msg: ErrBOMNotStored, cause: "skip"})
+
+				_, err = b.Count(bomQuery("skip"))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, ErrBOMNotStored)
+
+				_, err = b.DistinctValues(bomQuery("skip"), "USER_NAME")
+				So(err, ShouldNotBeNil)
+
+				_, err = b.MultiScroll([]*es.Query{bomQuery("keep"), bomQuery("skip")})
+				So(err, ShouldNotBeNil)
+
+				_, answered, errs := b.Aggregate(bomQuery("skip"))
+				So(errs, ShouldBeNil)
+				So(answered, ShouldBeFalse)
+			})
+		})
+	}
+
+	Convey("OnlyBOMs stores only the given BOMs", t, func() {
+		config := Config{Directory: t.TempDir(), OnlyBOMs: []string{"keep"}}
+
+		d, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		So(d.Store(hits()), ShouldBeNil)
+		So(d.Close(), ShouldBeNil)
+
+		d, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer d.Close()
+
+		result, err := d.Scroll(bomQuery("keep"))
+		So(err, ShouldBeNil)
+		So(result.HitSet.Total.Value, ShouldEqual, 1)
+
+		_, err = d.Scroll(bomQuery("other"))
+		So(err, ShouldResemble, Error{Msg: ErrBOMNotStored, cause: "other"})
+	})
+
+	Convey("A nil bomSelection stores everything", t, func() {
+		var b *bomSelection
+
+		So(newBOMSelection(nil, nil), ShouldBeNil)
+		So(b.stored("any"), ShouldBeTrue)
+		So(b.check("any"), ShouldBeNil)
+	})
+}
@@ -26,10 +26,14 @@
 package db
 
 import (
+	"bytes"
+	"io"
 	"sort"
 	"sync"
+	"time"
 
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/metrics"
 )
 
 const (
@@ -37,27 +41,46 @@ const (
 )
 
 type poolEntry struct {
-	buf   *[]byte
-	len   int
-	index int
-	inUse bool
+	buf      *[]byte
+	len      int
+	index    int
+	inUse    bool
+	lastUsed time.Time
 }
 
-// bufPool holds a permanent pool of buffers of mixed size and is able to return
-// an existing unused one that is closest in size to a desired buffer size.
+// bufPool holds a pool of buffers of mixed size and is able to return an
+// existing unused one that is closest in size to a desired buffer size.
+//
+// If it has a maxBytes, unused buffers are evicted to keep the pool within
+// that size, and buffers that still wouldn't fit are given out without being
+// kept in the pool.
 type bufPool struct {
 	mu         sync.Mutex
 	entries    []*poolEntry
 	key        int
 	keyToIndex map[int]int
+	maxBytes   int
+	bytes      int
+	unpooled   map[int]int
+	evictions  uint64
 }
 
 func newBufPool() *bufPool {
 	return &bufPool{
 		keyToIndex: map[int]int{},
+		unpooled:   map[int]int{},
 	}
 }
 
+// newBoundedBufPool returns a bufPool that won't hold more than maxBytes of
+// buffers. A maxBytes of 0 means unlimited.
+func newBoundedBufPool(maxBytes int) *bufPool {
+	b := newBufPool()
+	b.maxBytes = maxBytes
+
+	return b
+}
+
 // Warmup populates the pool with buffers large enough to handle the given
 // number of hits, plus a sequence of smaller ones all the way down to one big
 // enough for 2 hits.
@@ -96,6 +119,12 @@ func (b *bufPool) Get(lengthNeeded int) ([]byte, int) {
 
 	assignedBuf := b.getExisting(lengthNeeded, key)
 
+	if assignedBuf == nil && !b.makeRoomFor(lengthNeeded) {
+		b.unpooled[key] = lengthNeeded
+
+		return make([]byte, lengthNeeded), key
+	}
+
 	if assignedBuf == nil {
 		assignedBuf = b.makeNewBuf(lengthNeeded, key)
 	}
@@ -103,6 +132,92 @@ func (b *bufPool) Get(lengthNeeded int) ([]byte, int) {
 	return assignedBuf, key
 }
 
+// makeRoomFor evicts unused buffers, largest first, until a new buffer of the
+// given length would fit within our maxBytes. Returns false if it still
+// wouldn't fit.
+func (b *bufPool) makeRoomFor(length int) bool {
+	if b.maxBytes <= 0 {
+		return true
+	}
+
+	if length > b.maxBytes {
+		return false
+	}
+
+	for i := len(b.entries) - 1; i >= 0 && b.bytes+length > b.maxBytes; i-- {
+		if !b.entries[i].inUse {
+			b.evict(i)
+		}
+	}
+
+	return b.bytes+length <= b.maxBytes
+}
+
+// evict removes the unused entry at the given index from the pool.
+func (b *bufPool) evict(i int) {
+	b.bytes -= b.entries[i].len
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	b.evictions++
+
+	for j := i; j < len(b.entries); j++ {
+		b.entries[j].index--
+	}
+
+	for key, index := range b.keyToIndex {
+		if index > i {
+			b.keyToIndex[key]--
+		}
+	}
+}
+
+// shrink evicts the buffers that haven't been used since the given time,
+// returning how many bytes were freed.
+func (b *bufPool) shrink(unusedSince time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	before := b.bytes
+
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		if pe := b.entries[i]; !pe.inUse && pe.lastUsed.Before(unusedSince) {
+			b.evict(i)
+		}
+	}
+
+	return before - b.bytes
+}
+
+// shrinkWhenIdle calls shrink() every idle period, evicting buffers that
+// weren't used during the previous period. Call the returned function to stop.
+func (b *bufPool) shrinkWhenIdle(idle time.Duration) func() {
+	ticker := time.NewTicker(idle)
+	stop := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				b.shrink(now.Add(-idle))
+			case <-stop:
+				ticker.Stop()
+
+				return
+			}
+		}
+	}()
+
+	return func() { close(stop) }
+}
+
+// Key returns a new key without a buffer, for Results whose Details are backed
+// by something else. Done() will return false for it.
+func (b *bufPool) Key() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.newKey()
+}
+
 func (b *bufPool) newKey() int {
 	b.key++
 
@@ -118,6 +233,7 @@ func (b *bufPool) getExisting(lengthNeeded int, key int) []byte {
 		}
 
 		pe.inUse = true
+		pe.lastUsed = time.Now()
 		b.keyToIndex[key] = pe.index
 		assignedBuf = *pe.buf
 
@@ -131,11 +247,14 @@ func (b *bufPool) makeNewBuf(lengthNeeded int, key int) []byte {
 	buf := make([]byte, lengthNeeded)
 
 	b.insertSorted(&poolEntry{
-		buf:   &buf,
-		len:   lengthNeeded,
-		inUse: true,
+		buf:      &buf,
+		len:      lengthNeeded,
+		inUse:    true,
+		lastUsed: time.Now(),
 	}, key)
 
+	b.bytes += lengthNeeded
+
 	return buf
 }
 
@@ -165,13 +284,73 @@ func (b *bufPool) Done(key int) bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if _, found := b.unpooled[key]; found {
+		delete(b.unpooled, key)
+
+		return true
+	}
+
 	index, found := b.keyToIndex[key]
 	if !found {
 		return false
 	}
 
 	b.entries[index].inUse = false
+	b.entries[index].lastUsed = time.Now()
 	delete(b.keyToIndex, key)
 
 	return true
 }
+
+// releaseAll is like calling Done() on every key in use, returning how many
+// there were.
+func (b *bufPool) releaseAll() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.keyToIndex) + len(b.unpooled)
+
+	for _, index := range b.keyToIndex {
+		b.entries[index].inUse = false
+	}
+
+	b.keyToIndex = map[int]int{}
+	b.unpooled = map[int]int{}
+
+	return n
+}
+
+// WriteMetrics writes metrics on the occupancy of the pool to the given Writer,
+// in the Prometheus text exposition format.
+func (b *bufPool) WriteMetrics(w io.Writer) error {
+	b.mu.Lock()
+
+	inUse, unpooled := 0, 0
+
+	for _, index := range b.keyToIndex {
+		inUse += b.entries[index].len
+	}
+
+	for _, length := range b.unpooled {
+		unpooled += length
+	}
+
+	var buf bytes.Buffer
+
+	metrics.WriteGauge(&buf, "farmer_bufpool_bytes", //nolint:errcheck
+		"Total size of the buffers held in the pool.", float64(b.bytes))
+	metrics.WriteGauge(&buf, "farmer_bufpool_in_use_bytes", //nolint:errcheck
+		"Size of the pooled buffers currently in use.", float64(inUse))
+	metrics.WriteGauge(&buf, "farmer_bufpool_buffers", //nolint:errcheck
+		"Number of buffers held in the pool.", float64(len(b.entries)))
+	metrics.WriteGauge(&buf, "farmer_bufpool_unpooled_bytes", //nolint:errcheck
+		"Size of in use buffers that didn't fit in the pool.", float64(unpooled))
+	metrics.WriteCounter(&buf, "farmer_bufpool_evictions_total", //nolint:errcheck
+		"Number of buffers evicted from the pool.", b.evictions)
+
+	b.mu.Unlock()
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
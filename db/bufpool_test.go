@@ -26,7 +26,9 @@
 package db
 
 import (
+	"bytes"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
@@ -105,4 +107,87 @@ func TestBufPool(t *testing.T) {
 			So(entry.inUse, ShouldBeFalse)
 		}
 	})
+	Convey("A bounded pool evicts unused buffers to stay within its size", t, func() {
+		pool := newBoundedBufPool(100)
+
+		_, key40 := pool.Get(40)
+		_, key30 := pool.Get(30)
+		_, key20 := pool.Get(20)
+		So(pool.bytes, ShouldEqual, 90)
+
+		So(pool.Done(key40), ShouldBeTrue)
+		So(pool.Done(key20), ShouldBeTrue)
+
+		b, key50 := pool.Get(50)
+		So(len(b), ShouldEqual, 50)
+		So(pool.bytes, ShouldEqual, 100)
+		So(pool.evictions, ShouldEqual, 1)
+		So(len(pool.entries), ShouldEqual, 3)
+		So(pool.entries[0].len, ShouldEqual, 20)
+		So(pool.entries[1].len, ShouldEqual, 30)
+		So(pool.entries[2].len, ShouldEqual, 50)
+
+		Convey("Buffers that don't fit are given out unpooled", func() {
+			b, key := pool.Get(60)
+			So(len(b), ShouldEqual, 60)
+			So(pool.bytes, ShouldEqual, 80)
+			So(pool.unpooled[key], ShouldEqual, 60)
+
+			b, keyBig := pool.Get(200)
+			So(len(b), ShouldEqual, 200)
+
+			var buf bytes.Buffer
+
+			So(pool.WriteMetrics(&buf), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "farmer_bufpool_bytes 80\n")
+			So(buf.String(), ShouldContainSubstring, "farmer_bufpool_in_use_bytes 80\n")
+			So(buf.String(), ShouldContainSubstring, "farmer_bufpool_buffers 2\n")
+			So(buf.String(), ShouldContainSubstring, "farmer_bufpool_unpooled_bytes 260\n")
+			So(buf.String(), ShouldContainSubstring, "farmer_bufpool_evictions_total 2\n")
+
+			So(pool.Done(key), ShouldBeTrue)
+			So(pool.Done(keyBig), ShouldBeTrue)
+			So(pool.Done(key), ShouldBeFalse)
+			So(pool.unpooled, ShouldBeEmpty)
+			So(pool.Done(key30), ShouldBeTrue)
+			So(pool.Done(key50), ShouldBeTrue)
+		})
+
+		Convey("Unused buffers can be shrunk away", func() {
+			So(pool.Done(key30), ShouldBeTrue)
+			So(pool.shrink(time.Now().Add(-time.Hour)), ShouldEqual, 0)
+			So(pool.shrink(time.Now().Add(time.Second)), ShouldEqual, 50)
+			So(len(pool.entries), ShouldEqual, 1)
+			So(pool.entries[0].len, ShouldEqual, 50)
+			So(pool.entries[0].index, ShouldEqual, 0)
+			So(pool.keyToIndex[key50], ShouldEqual, 0)
+
+			So(pool.Done(key50), ShouldBeTrue)
+			So(pool.entries[0].inUse, ShouldBeFalse)
+		})
+
+		Convey("Idle buffers are shrunk away periodically", func() {
+			So(pool.Done(key30), ShouldBeTrue)
+			So(pool.Done(key50), ShouldBeTrue)
+
+			stop := pool.shrinkWhenIdle(10 * time.Millisecond)
+			defer stop()
+
+			So(func() bool {
+				for range 100 {
+					pool.mu.Lock()
+					n := len(pool.entries)
+					pool.mu.Unlock()
+
+					if n == 0 {
+						return true
+					}
+
+					time.Sleep(5 * time.Millisecond)
+				}
+
+				return false
+			}(), ShouldBeTrue)
+		})
+	})
 }
@@ -0,0 +1,110 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	ErrChecksumMismatch = "checksum mismatch"
+
+	checksumKind         = "sums"
+	checksumWidth        = 4
+	checksumTrailerWidth = 2 * checksumWidth
+)
+
+// castagnoli is the CRC-32 table used for all our checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli) //nolint:gochecknoglobals
+
+// checksumPath returns the path to the checksum file that corresponds to the
+// given index file path.
+//
+// A checksum file holds a 4 byte big endian CRC-32C of the data of each of the
+// index's entries, in order, followed by CRC-32Cs of the whole index file and
+// of the index's section of its job prefix index (0 if there isn't one).
+func checksumPath(indexPath string) string {
+	return strings.TrimSuffix(indexPath, indexKind) + checksumKind
+}
+
+// verifyChecksums checks that the given CRC-32C of the index file we were
+// loaded from matches that in our checksum file, and then records the checksum
+// of our job prefixes (checked by setJobPrefixes()) and of each of our entries'
+// data.
+//
+// Databases created before checksum files existed don't have them, in which
+// case we do nothing.
+func (f *flatIndex) verifyChecksums(indexPath string, indexSum uint32) error {
+	sums, err := os.ReadFile(checksumPath(indexPath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if len(sums) != len(f.bomEntries)*checksumWidth+checksumTrailerWidth {
+		return checksumError(indexPath, "wrong number of checksums")
+	}
+
+	trailer := sums[len(sums)-checksumTrailerWidth:]
+
+	if binary.BigEndian.Uint32(trailer) != indexSum {
+		return checksumError(indexPath, "index file")
+	}
+
+	f.jobsSum = binary.BigEndian.Uint32(trailer[checksumWidth:])
+	f.jobsSummed = true
+
+	for i, entry := range f.bomEntries {
+		entry.checksum = binary.BigEndian.Uint32(sums[i*checksumWidth:])
+		entry.checksummed = true
+	}
+
+	return nil
+}
+
+func checksumError(path, what string) error {
+	return Error{Msg: ErrChecksumMismatch, cause: fmt.Sprintf("%s (%s)", path, what)}
+}
+
+// verifyEntryChecksum returns an ErrChecksumMismatch Error if the given data
+// read for the given entry from the given data file doesn't have the entry's
+// checksum. Entries without checksums always pass.
+func verifyEntryChecksum(dataPath string, entry *flatIndexEntry, data []byte) error {
+	if !entry.checksummed || crc32.Checksum(data, castagnoli) == entry.checksum {
+		return nil
+	}
+
+	return checksumError(dataPath, fmt.Sprintf("entry at %d", entry.index))
+}
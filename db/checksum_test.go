@@ -0,0 +1,125 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestChecksums(t *testing.T) {
+	Convey("Given a flatDB with some stored hits", t, func() {
+		dir := t.TempDir()
+
+		fdb, err := newFlatDB(dir, fileSize, bufferSize, IndexWidths{})
+		So(err, ShouldBeNil)
+		So(fdb.addJobPrefixIndex(), ShouldBeNil)
+
+		for i, user := range []string{"a", "b", "c"} {
+			err = fdb.Store(&es.Hit{ID: user, Details: &es.Details{
+				AccountingName: "group", UserName: user, JobName: "job", Timestamp: int64(1707004800 + i),
+			}})
+			So(err, ShouldBeNil)
+		}
+
+		So(fdb.Finish(), ShouldBeNil)
+
+		indexPath := filepath.Join(dir, "0.index")
+		dataPath := filepath.Join(dir, "0.data")
+
+		_, err = os.Stat(checksumPath(indexPath))
+		So(err, ShouldBeNil)
+
+		Convey("The index loads with the checksums of its entries", func() {
+			fi, err := newFlatIndex(indexPath, bufferSize)
+			So(err, ShouldBeNil)
+			So(fi.bomEntries, ShouldHaveLength, 3)
+			So(fi.bomEntries[0].checksummed, ShouldBeTrue)
+
+			_, err = readEntryData(dataPath, fi.bomEntries)
+			So(err, ShouldBeNil)
+
+			Convey("and corrupt data is detected when verifying reads", func() {
+				corruptByte(dataPath, fi.bomEntries[1].index+1)
+
+				_, err = readEntryData(dataPath, fi.bomEntries)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, ErrChecksumMismatch)
+
+				fh, err := os.Open(dataPath)
+				So(err, ShouldBeNil)
+
+				defer fh.Close()
+
+				of := &openFile{File: fh, path: dataPath}
+				buf := make([]byte, fi.bomEntries[1].length)
+				So(of.readEntry(buf, fi.bomEntries[1]), ShouldBeNil)
+			})
+		})
+
+		Convey("A corrupt index fails to load", func() {
+			corruptByte(indexPath, 1)
+
+			_, err = newFlatIndex(indexPath, bufferSize)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrChecksumMismatch)
+		})
+
+		Convey("A corrupt job prefix index fails to load", func() {
+			corruptByte(jobPrefixPath(indexPath), jobPrefixSectionHeader+1)
+
+			_, err = newFlatIndex(indexPath, bufferSize)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrChecksumMismatch)
+		})
+
+		Convey("An index without a checksum file loads without verification", func() {
+			So(os.Remove(checksumPath(indexPath)), ShouldBeNil)
+			corruptByte(dataPath, 1)
+
+			fi, err := newFlatIndex(indexPath, bufferSize)
+			So(err, ShouldBeNil)
+			So(fi.bomEntries[0].checksummed, ShouldBeFalse)
+
+			_, err = readEntryData(dataPath, fi.bomEntries)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+// corruptByte flips the bits of the byte at the given offset in the given file.
+func corruptByte(path string, offset int64) {
+	b, err := os.ReadFile(path)
+	So(err, ShouldBeNil)
+
+	b[offset] ^= 0xff
+
+	So(os.WriteFile(path, b, rollupFilePerms), ShouldBeNil)
+}
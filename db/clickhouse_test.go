@@ -0,0 +1,181 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// fakeClickHouse is an httptest server that understands the queries
+// ClickHouse sends.
+type fakeClickHouse struct {
+	*httptest.Server
+	mu      sync.Mutex
+	queries []string
+	details []*es.Details
+	days    map[string]bool
+	auth    string
+	db      string
+}
+
+func newFakeClickHouse() *fakeClickHouse {
+	f := &fakeClickHouse{days: make(map[string]bool)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+
+	return f
+}
+
+func (f *fakeClickHouse) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user, password, _ := r.BasicAuth()
+	f.auth = user + ":" + password
+	f.db = r.URL.Query().Get("database")
+	query := r.URL.Query().Get("query")
+	day := r.URL.Query().Get("param_day")
+	f.queries = append(f.queries, query)
+
+	switch {
+	case strings.HasPrefix(query, "SELECT count()"):
+		if f.days[day] {
+			w.Write([]byte("1\n")) //nolint:errcheck
+		} else {
+			w.Write([]byte("0\n")) //nolint:errcheck
+		}
+	case strings.HasSuffix(query, "FORMAT JSONEachRow"):
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			d := &es.Details{}
+			if err := json.Unmarshal(scanner.Bytes(), d); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+
+				return
+			}
+
+			f.details = append(f.details, d)
+		}
+	case strings.Contains(query, "_backfilled_days (day) SELECT"):
+		f.days[day] = true
+	case strings.Contains(query, "fail"):
+		http.Error(w, "Code: 60. DB::Exception: fail", http.StatusNotFound)
+	}
+}
+
+func TestClickHouse(t *testing.T) {
+	Convey("You can't make a ClickHouse with a bad table name", t, func() {
+		_, err := NewClickHouse("http://localhost:1/db?table=foo-bar")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, ErrClickHouseTable+": foo-bar")
+	})
+
+	Convey("Given a ClickHouse server", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		fake := newFakeClickHouse()
+		defer fake.Close()
+
+		dsn := strings.Replace(fake.URL, "http://", "http://user:pass@", 1) + "/farm"
+
+		ch, err := NewClickHouse(dsn)
+		So(err, ShouldBeNil)
+		So(fake.auth, ShouldEqual, "user:pass")
+		So(fake.db, ShouldEqual, "farm")
+		So(len(fake.queries), ShouldEqual, 2)
+		So(fake.queries[0], ShouldStartWith, "CREATE TABLE IF NOT EXISTS farmer_hits (`_id` String, ")
+		So(fake.queries[0], ShouldContainSubstring, "`timestamp` Int64")
+		So(fake.queries[0], ShouldContainSubstring, "`WASTED_CPU_SECONDS` Float64")
+		So(fake.queries[1], ShouldStartWith, "CREATE TABLE IF NOT EXISTS farmer_hits_backfilled_days")
+
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+		period := (2 * 24) * time.Hour
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: t.TempDir()}
+
+		Convey("You can Mirror() hits to it without storing them locally", func() {
+			_, err = Mirror(mock, config, from, period, ch, false)
+			So(err, ShouldBeNil)
+
+			So(len(fake.details), ShouldEqual, 2)
+			So(fake.details[0].BOM, ShouldEqual, "Human Genetics")
+			So(fake.days, ShouldResemble, map[string]bool{"2024/05/29": true, "2024/05/30": true, "2024/05/31": true})
+			So(fake.queries, ShouldContain, "ALTER TABLE farmer_hits DROP PARTITION 20240531")
+
+			_, err = os.Stat(filepath.Join(config.Directory, "2024"))
+			So(err, ShouldNotBeNil)
+
+			Convey("Then locally as well, without storing them in ClickHouse again", func() {
+				_, err = Mirror(mock, config, from, period, ch, true)
+				So(err, ShouldBeNil)
+
+				So(len(fake.details), ShouldEqual, 2)
+
+				_, err = os.Stat(filepath.Join(config.Directory, "2024", "05", "31", successBasename))
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("You can Mirror() hits to it and locally at the same time", func() {
+			_, err = Mirror(mock, config, from, period, ch, true)
+			So(err, ShouldBeNil)
+
+			So(len(fake.details), ShouldEqual, 2)
+
+			ldb, err := New(config, true)
+			So(err, ShouldBeNil)
+
+			query := rangeQuery(timeRange(from, period))
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+			count, err := ldb.Count(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+			So(ldb.Close(), ShouldBeNil)
+		})
+
+		Convey("Failed requests return the server's error", func() {
+			ch.table = "fail"
+
+			_, err = ch.startDay(from)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, ErrClickHouse+": Code: 60. DB::Exception: fail")
+		})
+	})
+}
@@ -0,0 +1,132 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"strings"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	// DefaultCluster is the META_CLUSTER_NAME of the hits we store if
+	// Config.Clusters isn't set, and that queries without a META_CLUSTER_NAME
+	// filter are of.
+	DefaultCluster = "farm"
+
+	ErrClusterNotStored = "META_CLUSTER_NAME is not stored in the local database"
+	ErrClustersBackend  = "partitioning by cluster is only supported for the flat backend"
+
+	clusterField     = "META_CLUSTER_NAME"
+	clusterDirPrefix = "@"
+)
+
+// clusterSet records which clusters we store, from Config.Clusters. A nil
+// clusterSet means we only store the DefaultCluster, and ignore the clusters
+// that queries are of.
+type clusterSet map[string]bool
+
+// newClusterSet returns a clusterSet of the given clusters, or nil if none are
+// given.
+func newClusterSet(clusters []string) clusterSet {
+	if len(clusters) == 0 {
+		return nil
+	}
+
+	set := make(clusterSet, len(clusters))
+
+	for _, cluster := range clusters {
+		set[cluster] = true
+	}
+
+	return set
+}
+
+// resolve returns the cluster that a query with the given META_CLUSTER_NAME
+// filter (which may be blank) is of: blank if we don't partition by cluster,
+// otherwise the DefaultCluster if the filter is blank. Returns an
+// ErrClusterNotStored Error if we don't store that cluster.
+func (c clusterSet) resolve(cluster string) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+
+	if cluster == "" {
+		cluster = DefaultCluster
+	}
+
+	if !c[cluster] {
+		return "", Error{Msg: ErrClusterNotStored, cause: cluster}
+	}
+
+	return cluster, nil
+}
+
+// queryCluster returns the value of the query's META_CLUSTER_NAME filter, if
+// any.
+func queryCluster(query *es.Query) string {
+	return query.Filters()[clusterField]
+}
+
+// clusterBOMDir returns the name of the day subdirectory that hits of the given
+// cluster and BOM are stored in. Hits of the DefaultCluster (or a blank
+// cluster) are stored in a directory named after their BOM, as they always
+// have been, while other clusters' directories are named like
+// "@cluster@BOM", so that different clusters' hits don't interleave.
+func clusterBOMDir(cluster, bom string) string {
+	if cluster == "" || cluster == DefaultCluster {
+		return bom
+	}
+
+	return clusterDirPrefix + sanitiseBOMForFileSystem(cluster) + clusterDirPrefix + bom
+}
+
+// inCluster returns true if the given day subdirectory name holds hits of the
+// given cluster.
+func inCluster(bomDir, cluster string) bool {
+	if cluster == "" || cluster == DefaultCluster {
+		return !strings.HasPrefix(bomDir, clusterDirPrefix)
+	}
+
+	return strings.HasPrefix(bomDir, clusterDirPrefix+sanitiseBOMForFileSystem(cluster)+clusterDirPrefix)
+}
+
+// checkFilter returns an error if the filter is of a BOM or cluster we don't
+// store, or of days we haven't loaded yet, and otherwise resolves its cluster,
+// so that it only finds the files of the cluster its query is of.
+func (d *DB) checkFilter(filter *flatFilter) error {
+	if err := d.bomSelection.check(filter.BOM); err != nil {
+		return err
+	}
+
+	if err := d.checkLoaded(filter.start()); err != nil {
+		return err
+	}
+
+	cluster, err := d.clusters.resolve(filter.cluster)
+	filter.cluster = cluster
+
+	return err
+}
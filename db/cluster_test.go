@@ -0,0 +1,197 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// clusterScroller is a Scroller that returns a single hit at the start of the
+// queried time range for the queried META_CLUSTER_NAME, with an ACCOUNTING_NAME
+// of that cluster.
+type clusterScroller struct {
+	mu       sync.Mutex
+	clusters []string
+}
+
+func (c *clusterScroller) Scroll(query *es.Query, cb es.HitsCallBack) (*es.Result, error) {
+	cluster := query.Filters()[clusterField]
+
+	c.mu.Lock()
+	c.clusters = append(c.clusters, cluster)
+	c.mu.Unlock()
+
+	_, _, gte, err := query.DateRange()
+	if err != nil {
+		return nil, err
+	}
+
+	cb(&es.Hit{ID: cluster, Details: &es.Details{
+		BOM:            "Human Genetics",
+		AccountingName: cluster,
+		UserName:       "u",
+		Timestamp:      gte.Unix(),
+	}})
+
+	return &es.Result{}, nil
+}
+
+// queried returns the unique clusters that were queried.
+func (c *clusterScroller) queried() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unique := make(map[string]bool)
+
+	for _, cluster := range c.clusters {
+		unique[cluster] = true
+	}
+
+	clusters := mapKeys(unique)
+	sort.Strings(clusters)
+
+	return clusters
+}
+
+func TestClusters(t *testing.T) {
+	day := time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+
+	clusterQuery := func(cluster, bom string) *es.Query {
+		query := clusterRangeQuery(day, day.Add(oneDay), cluster)
+
+		if cluster == "" {
+			query.Query.Bool.Filter = query.Query.Bool.Filter[1:]
+		}
+
+		if bom != "" {
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": {"BOM": bom}})
+		}
+
+		return query
+	}
+
+	groupsOf := func(d *DB, query *es.Query) []string {
+		groups, err := d.DistinctValues(query, "ACCOUNTING_NAME")
+		So(err, ShouldBeNil)
+
+		sort.Strings(groups)
+
+		return groups
+	}
+
+	Convey("Without configured Clusters, only the default cluster is backfilled", t, func() {
+		client := &clusterScroller{}
+		config := Config{Directory: t.TempDir()}
+
+		_, err := Backfill(client, config, day.Add(oneDay), oneDay)
+		So(err, ShouldBeNil)
+		So(client.queried(), ShouldResemble, []string{DefaultCluster})
+
+		d, err := New(config, true)
+		So(err, ShouldBeNil)
+
+		defer d.Close()
+
+		Convey("and queries of any cluster find its hits", func() {
+			So(groupsOf(d, clusterQuery("other", "Human Genetics")), ShouldResemble, []string{DefaultCluster})
+		})
+	})
+
+	Convey("Given a DB backfilled with multiple Clusters", t, func() {
+		client := &clusterScroller{}
+		config := Config{Directory: t.TempDir(), Clusters: []string{DefaultCluster, "other"}}
+
+		_, err := Backfill(client, config, day.Add(oneDay), oneDay)
+		So(err, ShouldBeNil)
+		So(client.queried(), ShouldResemble, []string{DefaultCluster, "other"})
+
+		dayDir := filepath.Join(config.Directory, "2024", "05", "31")
+		_, err = os.Stat(filepath.Join(dayDir, "Human Genetics"))
+		So(err, ShouldBeNil)
+		_, err = os.Stat(filepath.Join(dayDir, "@other@Human Genetics"))
+		So(err, ShouldBeNil)
+
+		d, err := New(config, true)
+		So(err, ShouldBeNil)
+
+		defer d.Close()
+
+		Convey("queries only find the hits of the cluster they filter on", func() {
+			So(groupsOf(d, clusterQuery(DefaultCluster, "Human Genetics")), ShouldResemble, []string{DefaultCluster})
+			So(groupsOf(d, clusterQuery("other", "Human Genetics")), ShouldResemble, []string{"other"})
+
+			result, errs := d.Scroll(clusterQuery("other", "Human Genetics"))
+			So(errs, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 1)
+			So(result.HitSet.Hits[0].Details.AccountingName, ShouldEqual, "other")
+			d.Done(result.PoolKey)
+
+			n, errs := d.Count(clusterQuery("other", "Human Genetics"))
+			So(errs, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+
+			boms, errs := d.DistinctValues(clusterQuery("other", ""), "BOM")
+			So(errs, ShouldBeNil)
+			So(boms, ShouldResemble, []string{"Human Genetics"})
+		})
+
+		Convey("queries without a cluster filter are of the default cluster", func() {
+			So(groupsOf(d, clusterQuery("", "Human Genetics")), ShouldResemble, []string{DefaultCluster})
+		})
+
+		Convey("rollups are per cluster", func() {
+			r, ok, errr := d.rollupOfDay(day, clusterBOMDir("other", "Human Genetics"))
+			So(errr, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(len(r), ShouldEqual, 1)
+		})
+
+		Convey("queries of other clusters fail clearly", func() {
+			_, err = d.Scroll(clusterQuery("unknown", "Human Genetics"))
+			So(err, ShouldResemble, Error{Msg: ErrClusterNotStored, cause: "unknown"})
+
+			_, err = d.DistinctValues(clusterQuery("unknown", ""), "BOM")
+			So(err, ShouldNotBeNil)
+
+			_, answered, errs := d.Aggregate(clusterQuery("unknown", "Human Genetics"))
+			So(errs, ShouldBeNil)
+			So(answered, ShouldBeFalse)
+		})
+	})
+
+	Convey("The SQLite backend doesn't support Clusters", t, func() {
+		_, err := NewSQLite(Config{Directory: t.TempDir(), Clusters: []string{"other"}})
+		So(err, ShouldResemble, Error{Msg: ErrClustersBackend, cause: BackendSQLite})
+	})
+}
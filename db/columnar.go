@@ -0,0 +1,275 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// columnKind is the kind of file that holds the values of one of the configured
+// ColumnarFields for each entry of the corresponding index file, in the same
+// order. Each value is an 8 byte big endian float64, and the values are
+// followed by a 4 byte big endian CRC-32C of them. The files are named like
+// "0.WASTED_CPU_SECONDS.col".
+const (
+	columnKind       = "col"
+	columnValueWidth = 8
+)
+
+// columnPath returns the path to the column file of the given field that
+// corresponds to the given data file path.
+func columnPath(dataPath, field string) string {
+	return strings.TrimSuffix(dataPath, dataKind) + field + "." + columnKind
+}
+
+// validateColumnarFields returns an error if any of the given fields aren't
+// numeric.
+func validateColumnarFields(fields []string) error {
+	for _, field := range fields {
+		if err := es.ValidateNumericField(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// columnWriter writes the values of a field to a column file.
+type columnWriter struct {
+	field string
+	f     *os.File
+	w     *bufio.Writer
+	sum   uint32
+	buf   []byte
+}
+
+// write writes the value of our field in the given Details.
+func (c *columnWriter) write(details *es.Details) error {
+	val, err := details.NumericValue(c.field)
+	if err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint64(c.buf, math.Float64bits(val))
+	c.sum = crc32.Update(c.sum, castagnoli, c.buf)
+
+	_, err = c.w.Write(c.buf)
+
+	return err
+}
+
+// close writes our checksum, then flushes and closes our file.
+func (c *columnWriter) close() error {
+	c.w.Write(u32tob(c.sum)) //nolint:errcheck
+
+	if err := c.w.Flush(); err != nil {
+		c.f.Close()
+
+		return err
+	}
+
+	return c.f.Close()
+}
+
+// addColumns makes us write a column file for each of the given numeric fields
+// alongside each of our data files.
+func (f *flatDB) addColumns(fields []string) error {
+	if err := validateColumnarFields(fields); err != nil {
+		return err
+	}
+
+	f.columnFields = fields
+
+	return f.createColumnWriters()
+}
+
+// createColumnWriters creates a columnWriter for each of our columnFields for
+// our current data file.
+func (f *flatDB) createColumnWriters() error {
+	f.columns = make([]*columnWriter, 0, len(f.columnFields))
+
+	for _, field := range f.columnFields {
+		fh, w, err := f.createFileAndWriter(field + "." + columnKind)
+		if err != nil {
+			return err
+		}
+
+		f.columns = append(f.columns, &columnWriter{field: field, f: fh, w: w, buf: make([]byte, columnValueWidth)})
+	}
+
+	return nil
+}
+
+// writeColumns writes the values of our columnFields in the given Details to
+// our column files.
+func (f *flatDB) writeColumns(details *es.Details) error {
+	for _, c := range f.columns {
+		if err := c.write(details); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeColumns closes all our column files.
+func (f *flatDB) closeColumns() error {
+	for _, c := range f.columns {
+		if err := c.close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readColumn returns the contents of the given flatIndex's column file for the
+// given field, without its checksum, after verifying it. Returns nil if there
+// is no such column file, eg. because it was stored before the field was
+// configured as one of our ColumnarFields.
+func (d *DB) readColumn(fi *flatIndex, field string) ([]byte, error) {
+	path := columnPath(fi.dataPath, field)
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && d.objectStore != nil {
+		if d.fetchFromObjectStore(path) != nil {
+			return nil, nil
+		}
+
+		b, err = os.ReadFile(path)
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) != len(fi.bomEntries)*columnValueWidth+checksumWidth {
+		return nil, checksumError(path, "wrong number of column values")
+	}
+
+	values, sum := b[:len(b)-checksumWidth], b[len(b)-checksumWidth:]
+
+	if crc32.Checksum(values, castagnoli) != binary.BigEndian.Uint32(sum) {
+		return nil, checksumError(path, "column")
+	}
+
+	return values, nil
+}
+
+// columnValue returns the value of the entry with the given ordinal from the
+// given column.
+func columnValue(column []byte, ordinal int) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(column[ordinal*columnValueWidth:]))
+}
+
+// columnarMetricAggregate is like metricAggregate(), but reads the values of
+// the aggregated field from our column files instead of reading and
+// deserializing whole hits. Returns false if the field isn't one of our
+// ColumnarFields, if the query filters on properties we don't index, or if any
+// of the files we'd need to read don't have a column for the field, in which
+// case you should use metricAggregate() instead.
+func (d *DB) columnarMetricAggregate(query *es.Query) (*es.Result, bool, error) {
+	m, ok := newMetricAgg(query)
+	if !ok || !slices.Contains(d.columnFields, m.field) || hasNonIndexFilters(query) {
+		return nil, false, nil
+	}
+
+	filter, err := newFlatFilter(query)
+	if err != nil || d.checkFilter(filter) != nil {
+		return nil, false, nil //nolint:nilerr
+	}
+
+	var (
+		mu      sync.Mutex
+		values  []float64
+		missing bool
+		readErr error
+	)
+
+	d.operateOnRequestedDays(filter, func(fi *flatIndex) {
+		entries := fi.IndexSearch(filter)
+		if len(entries) == 0 {
+			return
+		}
+
+		column, errr := d.readColumn(fi, m.field)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case errr != nil:
+			readErr = errr
+		case column == nil:
+			missing = true
+		default:
+			for _, entry := range entries {
+				values = append(values, columnValue(column, entry.ordinal))
+			}
+		}
+	})
+
+	query.SetScanned(filter.scanned.Load())
+
+	if readErr != nil {
+		return nil, false, readErr
+	}
+
+	if err = filter.contextErr(); err != nil {
+		return nil, false, err
+	}
+
+	if missing {
+		return nil, false, nil
+	}
+
+	return m.result(values), true, nil
+}
+
+// metricAggregate answers metric aggregation queries with
+// columnarMetricAggregate() if possible, otherwise with metricAggregate().
+func (d *DB) metricAggregate(query *es.Query) (*es.Result, bool, error) {
+	result, ok, err := d.columnarMetricAggregate(query)
+	if ok || err != nil {
+		return result, ok, err
+	}
+
+	return metricAggregate(d, query)
+}
@@ -0,0 +1,194 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestColumnar(t *testing.T) {
+	Convey("Given a database storing columnar fields in several files", t, func() {
+		config := Config{
+			Directory:      t.TempDir(),
+			FileSize:       1000,
+			BufferSize:     bufferSize,
+			ColumnarFields: []string{"WASTED_CPU_SECONDS", "RUN_TIME_SEC"},
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		numHits := 50
+
+		for i := range numHits {
+			hitCh <- &es.Hit{Details: &es.Details{
+				Timestamp:               gte.Add(time.Duration(i) * time.Minute).Unix(),
+				BOM:                     "bomA",
+				UserName:                fmt.Sprintf("user%d", i%3),
+				RunTimeSec:              int64(10 * (i + 1)),
+				WastedCPUSeconds:        float64(i) / 2,
+				AvgMemEfficiencyPercent: float64(i),
+			}}
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		bomDir := filepath.Join(config.Directory, "2024", "02", "04", "bomA")
+
+		columns, err := filepath.Glob(filepath.Join(bomDir, "*.WASTED_CPU_SECONDS."+columnKind))
+		So(err, ShouldBeNil)
+
+		datas, err := filepath.Glob(filepath.Join(bomDir, "*."+dataKind))
+		So(err, ShouldBeNil)
+		So(len(datas), ShouldBeGreaterThan, 1)
+		So(len(columns), ShouldEqual, len(datas))
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := &es.Query{
+			Aggs: &es.Aggs{Stats: map[string]interface{}{
+				"stats": map[string]interface{}{"field": "WASTED_CPU_SECONDS"},
+			}},
+			Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				rangeFilter("lt", gte, gte.Add(oneDay)),
+				{"match_phrase": {"BOM": "bomA"}},
+				{"match_phrase": {"USER_NAME": "user1"}},
+			}}},
+		}
+
+		Convey("Stats aggregations are answered from the columns, matching the scrolled values", func() {
+			result, ok, err := db.columnarMetricAggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			expected, ok, err := metricAggregate(db, query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			So(result.HitSet.Total.Value, ShouldEqual, 17)
+			So(result.HitSet.Total.Value, ShouldEqual, expected.HitSet.Total.Value)
+			So(*result.Aggregations.Stats.Sum, ShouldEqual, *expected.Aggregations.Stats.Sum)
+			So(*result.Aggregations.Stats.Min, ShouldEqual, 0.5)
+			So(*result.Aggregations.Stats.Max, ShouldEqual, 24.5)
+
+			result, ok, err = db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(*result.Aggregations.Stats.Sum, ShouldEqual, *expected.Aggregations.Stats.Sum)
+		})
+
+		Convey("Percentiles aggregations are answered from the columns", func() {
+			query.Aggs.Stats = map[string]interface{}{
+				"percentiles": map[string]interface{}{
+					"field":    "RUN_TIME_SEC",
+					"percents": []float64{0, 50, 100},
+				},
+			}
+
+			result, ok, err := db.columnarMetricAggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			values := result.Aggregations.Stats.Values
+			So(*values["0.0"], ShouldEqual, 20)
+			So(*values["100.0"], ShouldEqual, 500)
+		})
+
+		Convey("Fields that aren't columnar, and JOB_NAME filters, aren't answered from columns", func() {
+			query.Aggs.Stats = map[string]interface{}{
+				"stats": map[string]interface{}{"field": es.MemEfficiencyField},
+			}
+
+			_, ok, err := db.columnarMetricAggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			result, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(*result.Aggregations.Stats.Count, ShouldEqual, 17)
+
+			query.Aggs.Stats = map[string]interface{}{
+				"stats": map[string]interface{}{"field": "WASTED_CPU_SECONDS"},
+			}
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"prefix": {"JOB_NAME": "x"}})
+
+			_, ok, err = db.columnarMetricAggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Missing columns make aggregation fall back to scrolling", func() {
+			So(os.Remove(columns[0]), ShouldBeNil)
+
+			_, ok, err := db.columnarMetricAggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			result, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(result.HitSet.Total.Value, ShouldEqual, 17)
+		})
+
+		Convey("Corrupt columns are detected", func() {
+			So(os.WriteFile(columns[0], []byte("corrupt"), 0600), ShouldBeNil)
+
+			_, _, err := db.columnarMetricAggregate(query)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrChecksumMismatch)
+		})
+	})
+
+	Convey("Non-numeric columnar fields are rejected", t, func() {
+		_, err := New(Config{Directory: t.TempDir(), ColumnarFields: []string{"USER_NAME"}}, false)
+		So(err, ShouldNotBeNil)
+	})
+}
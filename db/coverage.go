@@ -0,0 +1,177 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BOMCoverage describes the hits a database has stored for a day of a BOM.
+type BOMCoverage struct {
+	// Cluster is the cluster the hits are from, if the database is partitioned
+	// by cluster.
+	Cluster string `json:"cluster,omitempty"`
+
+	// BOM is the BOM, as named on the file system.
+	BOM string `json:"bom"`
+
+	// Hits is how many hits are stored, or -1 if that isn't known because the
+	// day hasn't been loaded yet (see Config.LazyLoadDirs).
+	Hits int `json:"hits"`
+}
+
+// DayCoverage describes the hits a database has stored for a day.
+type DayCoverage struct {
+	// Day is the day (YYYY-MM-DD, UTC).
+	Day string `json:"day"`
+
+	// Partial is true if the day hasn't been completely backfilled yet, eg.
+	// because only some hours of it have been stored so far.
+	Partial bool `json:"partial,omitempty"`
+
+	// BOMs has the coverage of each BOM with hits that day, sorted by cluster
+	// and BOM.
+	BOMs []BOMCoverage `json:"boms"`
+}
+
+// coverage collects DayCoverages.
+type coverage map[string]*DayCoverage
+
+// add adds the given hits of the given day, cluster and BOM.
+func (c coverage) add(day time.Time, partial bool, bc BOMCoverage) {
+	key := day.Format(time.DateOnly)
+
+	dc, ok := c[key]
+	if !ok {
+		dc = &DayCoverage{Day: key, Partial: partial}
+		c[key] = dc
+	}
+
+	dc.BOMs = append(dc.BOMs, bc)
+}
+
+// days returns our DayCoverages sorted by day.
+func (c coverage) days() []DayCoverage {
+	days := make([]DayCoverage, 0, len(c))
+
+	for _, key := range sortedKeys(c) {
+		dc := c[key]
+
+		sort.Slice(dc.BOMs, func(i, j int) bool {
+			if dc.BOMs[i].Cluster != dc.BOMs[j].Cluster {
+				return dc.BOMs[i].Cluster < dc.BOMs[j].Cluster
+			}
+
+			return dc.BOMs[i].BOM < dc.BOMs[j].BOM
+		})
+
+		days = append(days, *dc)
+	}
+
+	return days
+}
+
+// Coverage returns the number of hits we have for each day and BOM, from the
+// index files we've loaded (or, if we LazyLoadDirs, found).
+func (d *DB) Coverage(ctx context.Context) ([]DayCoverage, error) {
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	hits := make(map[string]int, len(d.dateBOMDirs)+len(d.lazyPaths))
+
+	for dir, fis := range d.dateBOMDirs {
+		hits[dir] = hitsInFlatIndexes(fis)
+	}
+
+	for dir := range d.lazyPaths {
+		hits[dir] = -1
+
+		if d.lazyLoaded == nil {
+			continue
+		}
+
+		if fis, ok := d.lazyLoaded.Peek(dir); ok {
+			hits[dir] = hitsInFlatIndexes(fis)
+		}
+	}
+
+	c := make(coverage)
+
+	for dir, n := range hits {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		day, cluster, bom, ok := d.parseDateBOMDir(dir)
+		if !ok {
+			continue
+		}
+
+		c.add(day, d.partialDays[d.dateFolder(day)], BOMCoverage{Cluster: cluster, BOM: bom, Hits: n})
+	}
+
+	return c.days(), nil
+}
+
+// hitsInFlatIndexes returns the total number of hits in the given flatIndexes.
+func hitsInFlatIndexes(fis []*flatIndex) int {
+	n := 0
+
+	for _, fi := range fis {
+		n += len(fi.bomEntries)
+	}
+
+	return n
+}
+
+// parseDateBOMDir returns the day, cluster (if not the default) and BOM of the
+// given date/BOM directory path, the BOM directory being named like those of
+// clusterBOMDir().
+func (d *DB) parseDateBOMDir(dir string) (time.Time, string, string, bool) {
+	rel, err := filepath.Rel(d.dir, dir)
+	if err != nil {
+		return time.Time{}, "", "", false
+	}
+
+	dateStr, bomDir := filepath.Split(filepath.ToSlash(rel))
+
+	day, err := time.Parse(dateFormat, strings.TrimSuffix(dateStr, "/"))
+	if err != nil || bomDir == "" {
+		return time.Time{}, "", "", false
+	}
+
+	if rest, ok := strings.CutPrefix(bomDir, clusterDirPrefix); ok {
+		if cluster, bom, ok := strings.Cut(rest, clusterDirPrefix); ok {
+			return day, cluster, bom, true
+		}
+	}
+
+	return day, "", bomDir, true
+}
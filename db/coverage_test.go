@@ -0,0 +1,155 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestCoverage(t *testing.T) {
+	Convey("Given a database with stored hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		first, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		result := makeResult(first, first.Add(oneDay).Add(10*time.Second))
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for _, hit := range result.HitSet.Hits {
+			hitCh <- &hit
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		expected := make(map[string]int)
+
+		for _, hit := range result.HitSet.Hits {
+			day := time.Unix(hit.Details.Timestamp, 0).UTC().Format(time.DateOnly)
+			expected[day+" "+sanitiseBOMForFileSystem(hit.Details.BOM)]++
+		}
+
+		checkCoverage := func(days []DayCoverage, hitsKnown bool) {
+			So(days, ShouldHaveLength, 2)
+			So(days[0].Day, ShouldEqual, "2024-02-04")
+			So(days[1].Day, ShouldEqual, "2024-02-05")
+
+			found := make(map[string]int)
+
+			for _, dc := range days {
+				So(dc.Partial, ShouldBeFalse)
+
+				for i, bc := range dc.BOMs {
+					So(bc.Cluster, ShouldBeBlank)
+
+					if i > 0 {
+						So(bc.BOM, ShouldBeGreaterThan, dc.BOMs[i-1].BOM)
+					}
+
+					if !hitsKnown {
+						So(bc.Hits, ShouldEqual, -1)
+						bc.Hits = expected[dc.Day+" "+bc.BOM]
+					}
+
+					found[dc.Day+" "+bc.BOM] = bc.Hits
+				}
+			}
+
+			So(found, ShouldResemble, expected)
+		}
+
+		Convey("You can get the number of hits of each day and BOM", func() {
+			db, err = New(config, false)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			days, errc := db.Coverage(context.Background())
+			So(errc, ShouldBeNil)
+			checkCoverage(days, true)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, errc = db.Coverage(ctx)
+			So(errc, ShouldNotBeNil)
+		})
+
+		Convey("Days not yet lazily loaded have unknown hits", func() {
+			config.LazyLoadDirs = 1
+
+			db, err = New(config, false)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			days, errc := db.Coverage(context.Background())
+			So(errc, ShouldBeNil)
+			checkCoverage(days, false)
+		})
+	})
+
+	Convey("parseDateBOMDir() understands cluster directories", t, func() {
+		db := &DB{dir: "/db"}
+
+		day, cluster, bom, ok := db.parseDateBOMDir("/db/2024/02/04/bomA")
+		So(ok, ShouldBeTrue)
+		So(day.Format(time.DateOnly), ShouldEqual, "2024-02-04")
+		So(cluster, ShouldBeBlank)
+		So(bom, ShouldEqual, "bomA")
+
+		_, cluster, bom, ok = db.parseDateBOMDir("/db/2024/02/04/" + clusterBOMDir("other", "bomA"))
+		So(ok, ShouldBeTrue)
+		So(cluster, ShouldEqual, "other")
+		So(bom, ShouldEqual, "bomA")
+
+		_, _, _, ok = db.parseDateBOMDir("/db/2024/02/04")
+		So(ok, ShouldBeFalse)
+
+		_, _, _, ok = db.parseDateBOMDir("/elsewhere/bomA")
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -33,23 +33,32 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	lru "github.com/hashicorp/golang-lru/v2"
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/metrics"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
-	ErrFieldTooLong = "field value exceeds expected width"
+	ErrFieldTooLong  = "field value exceeds expected width"
+	ErrQueryTooLarge = "query matches too many hits; narrow your range or filters"
 
-	dbDirPerms = 0770
+	dbDirPerms  = 0770
+	dbFilePerms = 0660
 
 	timeStampWidth         = 8
 	bomWidth               = 34
 	accountingNameWidth    = 24
 	userNameWidth          = 15
+	queueNameWidth         = 20
 	gpuPrefix              = "gpu"
 	notInGPUQueue          = byte(1)
 	inGPUQueue             = byte(2)
@@ -58,8 +67,14 @@ const (
 	defaultBufferSize      = 4 * 1024 * 1024
 	defaultUpdateFrequency = 1 * time.Hour
 
+	defaultMaxSimultaneousIndexLoads = 8
+
 	oneDay = 24 * time.Hour
 
+	// contextCheckInterval is how many index entries or hits we process between
+	// checks for a cancelled query context.
+	contextCheckInterval = 4096
+
 	dateFormat      = "2006/01/02"
 	pretendScrollID = "farmer_scroll_id"
 )
@@ -93,6 +108,151 @@ type Config struct {
 	// hits your queries will return.
 	PoolSize        int
 	UpdateFrequency time.Duration // UpdateFrequency defaults to 1hr
+	// LazyLoadDirs defaults to zero, meaning all index files are loaded in to
+	// memory by New(). If greater than zero, index files are instead only
+	// loaded when a query first needs their date/BOM directory, and at most
+	// this many directories' worth will be kept loaded, with the least
+	// recently queried being unloaded first.
+	LazyLoadDirs int
+	// MaxSimultaneousScrolls defaults to zero, meaning unlimited. Otherwise,
+	// Scroll()s and MultiScroll()s beyond this many wait for earlier ones to
+	// finish.
+	MaxSimultaneousScrolls int
+	// MaxHits and MaxBytes default to zero, meaning unlimited. Otherwise,
+	// queries that the index files say would return more than this many hits,
+	// or more than this many bytes of hit data, fail with ErrQueryTooLarge
+	// before any hit data is read.
+	MaxHits  int
+	MaxBytes int
+	// MaxOpenFiles defaults to 256. It is the number of data files that will be
+	// kept open between queries, with the least recently queried being closed
+	// first. Files being read by running queries are never closed, and unused
+	// files are all closed if we run out of file descriptors.
+	MaxOpenFiles int
+	// ObjectStore defaults to nil, meaning only the files in Directory are
+	// used. Otherwise, Backfill() puts the files of each day it completes in
+	// the ObjectStore, and New() and regular updates download the index files
+	// of days that are in the ObjectStore to Directory. Data files are only
+	// downloaded to Directory when first queried.
+	ObjectStore ObjectStore
+	// Backend is only used by Open() and Backfill(), and defaults to
+	// BackendFlat, meaning hits are stored in flat files by a DB. Use
+	// BackendSQLite to store them in an SQLite database file by an SQLiteDB
+	// instead.
+	Backend string
+	// IndexWidths defaults to the widths described in its docs. Increase these
+	// if Backfill() fails because some hits have longer group, user or queue
+	// names.
+	IndexWidths IndexWidths
+	// SkipBadHits defaults to false, meaning Store() fails if any hit can't be
+	// stored, eg. because its group name is longer than our IndexWidths allow.
+	// If true, such hits are skipped and logged instead, and a summary of them
+	// is available from SkippedHits().
+	SkipBadHits bool
+	// SkipBOMs and OnlyBOMs default to nil, meaning hits of all BOMs are
+	// stored. Otherwise, Store() (and so Backfill() and similar) skip hits
+	// with a BOM in SkipBOMs, or if OnlyBOMs isn't empty, a BOM not in
+	// OnlyBOMs. Queries of such a BOM fail with ErrBOMNotStored, and
+	// Aggregate() doesn't answer them.
+	SkipBOMs []string
+	OnlyBOMs []string
+	// Clusters defaults to nil, meaning only hits with a META_CLUSTER_NAME of
+	// DefaultCluster are backfilled, and the META_CLUSTER_NAME filters of
+	// queries are ignored. Otherwise, the hits of each of the given clusters
+	// are backfilled and stored separately, so that queries only read the
+	// files of the cluster they filter on (or DefaultCluster if they don't),
+	// and queries of other clusters fail with ErrClusterNotStored. Hits of
+	// DefaultCluster are stored where they always have been, so you can add
+	// more clusters to an existing database, but must backfill it again to
+	// have their earlier hits. Only the flat Backend supports this.
+	Clusters []string
+	// VerifyReads defaults to false, meaning the checksums of database files
+	// are only verified when their indexes are loaded. If true, the checksum of
+	// every hit read from a data file is also verified, and queries fail with
+	// ErrChecksumMismatch if any hit's data is corrupt.
+	VerifyReads bool
+	// MaxSimultaneousBackfills defaults to 16. It is the number of days that
+	// Backfill() and similar will query elastic search for at once. Lower it
+	// to reduce the load on a shared elastic search cluster.
+	MaxSimultaneousBackfills int
+	// MaxSimultaneousIndexLoads defaults to 8. It is the number of BOM
+	// directories whose index files New() and regular updates will load at
+	// once; the files within a directory are loaded one after the other.
+	// Lower it if your database is on spinning disks.
+	MaxSimultaneousIndexLoads int
+	// VerifyCounts defaults to false. If true, and the client given to
+	// Backfill() and similar is a Counter, each day stored is followed by a
+	// count of its hits in elastic search. Mismatches are logged, and with the
+	// flat Backend the DayCount is recorded; see ReportCounts().
+	VerifyCounts bool
+	// BackfillRetries defaults to 0. It is the number of times Backfill() and
+	// similar will try a day again after it fails (eg. because of an elastic
+	// search timeout), before giving up on that day. Other days are backfilled
+	// regardless.
+	BackfillRetries int
+	// BackfillRetryDelay defaults to 10s. It is how long to wait before the
+	// first retry of a day; the wait doubles before each subsequent retry.
+	BackfillRetryDelay time.Duration
+	// BackfillProgress defaults to nil. If set, Backfill() and similar call it
+	// each time a day is finished with (skipped, succeeded or failed), with
+	// how many days are finished so far and the total number of days.
+	BackfillProgress func(done, total int)
+	// ColumnarFields defaults to nil. Otherwise, each of these numeric fields
+	// (eg. WASTED_CPU_SECONDS) is also stored in its own column file alongside
+	// each data file, so that stats and percentiles aggregations of them are
+	// computed from just those values, instead of by reading and
+	// deserializing whole hits. Days stored before a field was added here are
+	// aggregated the slow way until they're backfilled again. Only the flat
+	// Backend supports this.
+	ColumnarFields []string
+	// JobPrefixIndex defaults to false. If true, the first 8 bytes of the
+	// JOB_NAME of each hit are also stored in a job prefix index alongside
+	// each date/BOM directory's index files, so that queries with a JOB_NAME
+	// prefix filter can skip non-matching hits without reading their data.
+	// Days stored before this was enabled are filtered the slow way until
+	// they're backfilled again. Only the flat Backend supports this.
+	JobPrefixIndex bool
+	// MMap defaults to false, meaning hit data is read from data files in to a
+	// pool of buffers. If true, data files are memory-mapped instead, so that
+	// the OS page cache serves repeated queries of the same days, and Scroll()
+	// Results are backed by the mapped files instead of by buffers that
+	// have to be allocated and kept on the heap. Mapped files are counted
+	// towards MaxOpenFiles.
+	MMap bool
+	// MaxPoolBytes defaults to 0, meaning the pool of buffers that Scroll()s
+	// read hit data in to can grow without limit, and never shrinks. Otherwise,
+	// unused buffers are freed to keep the pool within this many bytes, and
+	// scrolls that need more are given buffers that are freed once they're
+	// Done().
+	MaxPoolBytes int
+	// PoolIdleTimeout defaults to 0, meaning unused pool buffers are kept. If
+	// set, buffers that go unused for this long are freed.
+	PoolIdleTimeout time.Duration
+	// BackgroundLoad defaults to false, meaning New() doesn't return until all
+	// index files have been loaded. If true, New() returns right away and the
+	// index files are loaded in the background, most recent days first. Until
+	// they're all loaded, queries of days older than those loaded so far fail
+	// with ErrNotLoaded (Aggregate() doesn't answer them), and Readiness() says
+	// how far loading has got.
+	BackgroundLoad bool
+	// ReadOnly defaults to false. If true, nothing in Directory is ever
+	// created or modified: New() doesn't create a missing Directory or write
+	// an index cache file, and Backfill() and everything else that would store
+	// hits, or move or rewrite database files, fails with ErrReadOnly. Days
+	// that something else adds to Directory (eg. when it's a network-mounted
+	// replica backfilled elsewhere) are still found by regular updates. An
+	// ObjectStore can't be used with this.
+	ReadOnly bool
+	// ExtraDirectories defaults to nil. Otherwise, the days in each of these
+	// database directories (eg. an archive of older months on slower disks)
+	// are loaded and queried as if they were in Directory. If a day is in more
+	// than one, the copy in Directory is used, then that in the earliest of
+	// these, so list faster directories first. Backfill() and similar only
+	// store days in Directory, skipping days already backfilled in any of
+	// these. Only Directory is watched for new days; days added to these are
+	// found by regular updates if they're newer than all others. Only the flat
+	// Backend supports this.
+	ExtraDirectories []string
 }
 
 // FileSizeOrDefault returns our FileSize value, unless that is 0, in which
@@ -105,6 +265,47 @@ func (c Config) FileSizeOrDefault() int {
 	return c.FileSize
 }
 
+// MaxSimultaneousBackfillsOrDefault returns our MaxSimultaneousBackfills value,
+// unless that is 0, in which case it returns a sensible default value (16).
+func (c Config) MaxSimultaneousBackfillsOrDefault() int {
+	if c.MaxSimultaneousBackfills == 0 {
+		return defaultMaxSimultaneousBackfills
+	}
+
+	return c.MaxSimultaneousBackfills
+}
+
+// MaxSimultaneousIndexLoadsOrDefault returns our MaxSimultaneousIndexLoads
+// value, unless that is 0, in which case it returns a sensible default value
+// (8).
+func (c Config) MaxSimultaneousIndexLoadsOrDefault() int {
+	if c.MaxSimultaneousIndexLoads == 0 {
+		return defaultMaxSimultaneousIndexLoads
+	}
+
+	return c.MaxSimultaneousIndexLoads
+}
+
+// BackfillRetryDelayOrDefault returns our BackfillRetryDelay value, unless that
+// is 0, in which case it returns a sensible default value (10s).
+func (c Config) BackfillRetryDelayOrDefault() time.Duration {
+	if c.BackfillRetryDelay == 0 {
+		return defaultBackfillRetryDelay
+	}
+
+	return c.BackfillRetryDelay
+}
+
+// ClustersOrDefault returns our Clusters, unless there are none, in which case
+// it returns just DefaultCluster.
+func (c Config) ClustersOrDefault() []string {
+	if len(c.Clusters) == 0 {
+		return []string{DefaultCluster}
+	}
+
+	return c.Clusters
+}
+
 // BufferSizeOrDefault returns our BufferSize value, unless that is 0, in which
 // case it returns a sensible default value (4MB).
 func (c Config) BufferSizeOrDefault() int {
@@ -128,17 +329,58 @@ func (c Config) UpdateFrequencyOrDefault() time.Duration {
 // DB represents a local database that uses a number of flat files to store
 // elasticsearch hit details and return them quickly.
 type DB struct {
+	config               Config
 	dir                  string
 	fileSize             int
 	bufferSize           int
+	indexWidths          IndexWidths
 	bufPool              *bufPool
+	openFiles            *openFiles
+	heldFiles            heldFiles
+	objectStore          ObjectStore
 	updateFrequency      time.Duration
 	checkBackfillSuccess bool
 	latestDate           time.Time
 	stopMonitoring       chan bool
+	stopShrinkingPool    func()
+	updateTicker         *time.Ticker
 
 	muDateBOMDirs sync.RWMutex
 	dateBOMDirs   map[string][]*flatIndex
+	partialDays   map[string]bool
+	replacedDays  map[string]bool
+
+	refillStamps   map[string]time.Time
+	refillsChecked time.Time
+
+	lazyPaths  map[string][]string
+	lazyLoaded *lru.Cache[string, []*flatIndex]
+	muLazyLoad sync.Mutex
+
+	indexCachePath string
+	readOnly       bool
+
+	extraDirs    []string
+	dayDirs      map[string]string
+	muIndexCache sync.Mutex
+
+	watcher   *fsnotify.Watcher
+	muLoadDay sync.Mutex
+	muReload  sync.Mutex
+
+	created     time.Time
+	dataVersion atomic.Uint64
+
+	scrollSem *semaphore.Weighted
+	scans     scanTracker
+	queryLimits
+	*badHits
+	bomSelection *bomSelection
+	clusters     clusterSet
+	columnFields []string
+	jobPrefixes  bool
+	indexLoads   int
+	backgroundLoad
 }
 
 // New returns a DB that will create or use the database files in the configured
@@ -153,74 +395,251 @@ type DB struct {
 // data over time. This defaults to checking for new files ever hour.
 //
 // If you're using Backfill, then provide a true bool to only load successful
-// whole day database files.
+// whole day database files. In that case, the Directory is also watched so that
+// days are loaded as soon as Backfill completes them.
+//
+// If the configured LazyLoadDirs is greater than zero, index files are not
+// loaded until they are first queried; see Config for details.
+//
+// If the configured ObjectStore is not nil, Directory acts as a local cache of
+// its files; see Config for details.
+//
+// If the configured ReadOnly is true, the Directory must already exist, and
+// nothing is written to it; an ErrReadOnlyObjectStore Error is returned if an
+// ObjectStore is also configured.
 func New(config Config, checkBackfillSuccess bool) (*DB, error) {
+	if err := validateColumnarFields(config.ColumnarFields); err != nil {
+		return nil, err
+	}
+
+	if err := config.checkReadOnlyDirectory(); err != nil {
+		return nil, err
+	}
+
 	db := newDBStruct(config, checkBackfillSuccess)
 
-	_, err := os.Stat(config.Directory)
-	if err == nil {
-		err = db.loadAllFlatIndexes(db.dir)
-		if err == nil {
-			db.monitorFlatIndexes()
-			db.bufPool.Warmup(config.PoolSize)
+	if config.LazyLoadDirs > 0 {
+		l, err := lru.New[string, []*flatIndex](config.LazyLoadDirs)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		err = os.MkdirAll(config.Directory, dbDirPerms)
+
+		db.lazyPaths = make(map[string][]string)
+		db.lazyLoaded = l
+	}
+
+	err := db.syncFromObjectStoreIfConfigured()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(config.Directory)
+	if err != nil {
+		return db, os.MkdirAll(config.Directory, dbDirPerms)
+	}
+
+	if config.BackgroundLoad {
+		db.loadInBackground(config)
+
+		return db, nil
+	}
+
+	err = db.loadInitialFlatIndexes()
+	if err == nil {
+		db.startMaintenance(config)
 	}
 
 	return db, err
 }
 
+// startMaintenance starts the things we do once our initial indexes have been
+// loaded: looking for new days, warming up and shrinking our buffer pool.
+func (d *DB) startMaintenance(config Config) {
+	d.monitorFlatIndexes()
+	d.watchForNewDaysIfBackfilling()
+	d.bufPool.Warmup(config.PoolSize)
+	d.shrinkPoolWhenIdle(config.PoolIdleTimeout)
+}
+
 func newDBStruct(config Config, checkBackfillSuccess bool) *DB {
-	return &DB{
+	var scrollSem *semaphore.Weighted
+
+	if config.MaxSimultaneousScrolls > 0 {
+		scrollSem = semaphore.NewWeighted(int64(config.MaxSimultaneousScrolls))
+	}
+
+	d := &DB{
+		config:               config,
 		dir:                  config.Directory,
 		fileSize:             config.FileSizeOrDefault(),
 		bufferSize:           config.BufferSizeOrDefault(),
-		bufPool:              newBufPool(),
+		indexWidths:          config.IndexWidths.OrDefaults(),
+		bufPool:              newBoundedBufPool(config.MaxPoolBytes),
+		objectStore:          config.ObjectStore,
 		updateFrequency:      config.UpdateFrequencyOrDefault(),
 		checkBackfillSuccess: checkBackfillSuccess,
 		dateBOMDirs:          make(map[string][]*flatIndex),
+		partialDays:          make(map[string]bool),
+		replacedDays:         make(map[string]bool),
+		refillStamps:         make(map[string]time.Time),
+		scrollSem:            scrollSem,
+		queryLimits:          queryLimits{maxHits: config.MaxHits, maxBytes: config.MaxBytes},
+		badHits:              newBadHits(config.SkipBadHits),
+		bomSelection:         newBOMSelection(config.SkipBOMs, config.OnlyBOMs),
+		clusters:             newClusterSet(config.Clusters),
+		columnFields:         config.ColumnarFields,
+		jobPrefixes:          config.JobPrefixIndex,
+		indexLoads:           config.MaxSimultaneousIndexLoadsOrDefault(),
+		created:              time.Now(),
+		refillsChecked:       time.Now(),
+		readOnly:             config.ReadOnly,
+		extraDirs:            config.ExtraDirectories,
+		dayDirs:              make(map[string]string),
 	}
+
+	var fetch func(string) error
+
+	if d.objectStore != nil {
+		fetch = d.fetchFromObjectStore
+	}
+
+	d.openFiles = newOpenFiles(config.MaxOpenFiles, fetch)
+	d.openFiles.verify = config.VerifyReads
+	d.openFiles.mmap = config.MMap
+
+	return d
 }
 
+// loadAllFlatIndexes loads all the loadable index files within the given
+// directory. The files of each BOM directory are loaded one after the other,
+// with up to our indexLoads directories being loaded at once. If dir is our
+// whole database directory, the most recent days are loaded first, and
+// progress is logged periodically.
 func (d *DB) loadAllFlatIndexes(dir string) error {
-	eg := errgroup.Group{}
+	dirs, err := d.loadableIndexDirs(dir)
+
+	if d.lazyLoaded != nil {
+		for _, id := range dirs {
+			for _, path := range id.paths {
+				if errr := d.recordLazyPathAndUpdateLatestDate(path, id.dir); errr != nil && err == nil {
+					err = errr
+				}
+			}
+		}
+
+		return err
+	}
+
+	initial := dir == d.dir
+	if initial {
+		slices.Reverse(dirs)
+	}
+
+	errl := d.loadIndexDirs(dirs, initial)
+
+	if err == nil {
+		err = errl
+	}
+
+	return err
+}
+
+// indexDir is a BOM directory and the paths of the loadable index files in it.
+type indexDir struct {
+	dir   string
+	paths []string
+}
+
+// loadableIndexDirs finds the loadable index files within the given directory,
+// grouped by the directory they're in. Days that Tier() moved elsewhere are
+// skipped, as are days we've already loaded if the directory is in one of our
+// extraDirs. If there's an error walking dir, the files found so far are
+// returned along with it.
+func (d *DB) loadableIndexDirs(dir string) ([]indexDir, error) {
+	var dirs []indexDir
+
+	root := d.rootOf(dir)
 
 	err := filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if !de.Type().IsRegular() || !strings.HasSuffix(de.Name(), indexKind) {
+		if de.IsDir() && (isHiddenTopDir(root, path) || d.isLoadedExtraDay(root, path) || isTieredDay(path)) {
+			return filepath.SkipDir
+		}
+
+		if !de.Type().IsRegular() || !strings.HasSuffix(de.Name(), indexKind) || !d.indexIsLoadable(path) {
 			return nil
 		}
 
-		d.loadFlatIndexIfOK(path, &eg)
+		subDir := filepath.Dir(path)
+
+		if len(dirs) == 0 || dirs[len(dirs)-1].dir != subDir {
+			dirs = append(dirs, indexDir{dir: subDir})
+		}
+
+		dirs[len(dirs)-1].paths = append(dirs[len(dirs)-1].paths, path)
 
 		return nil
 	})
 
-	errg := eg.Wait()
+	return dirs, err
+}
+
+// loadIndexDirs loads the index files of the given directories, with up to our
+// indexLoads directories being loaded at once. If this is our initial load,
+// progress is logged, and recorded for Readiness().
+func (d *DB) loadIndexDirs(dirs []indexDir, initial bool) error {
+	progress := newIndexLoadProgress(dirs)
 
-	if err == nil {
-		err = errg
+	if initial {
+		d.loadProgress.Store(progress)
+
+		if len(dirs) > 0 {
+			defer progress.logUntilDone(indexLoadProgressInterval)()
+		}
 	}
 
-	return err
-}
+	eg := errgroup.Group{}
+	eg.SetLimit(d.indexLoads)
 
-func (d *DB) loadFlatIndexIfOK(path string, eg *errgroup.Group) {
-	subDir := filepath.Dir(path)
+	for _, id := range dirs {
+		eg.Go(func() error {
+			if d.closing.Load() {
+				return Error{Msg: ErrClosed}
+			}
 
-	if d.checkBackfillSuccess {
-		if _, err := os.Stat(filepath.Join(filepath.Dir(subDir), successBasename)); err != nil {
-			return
-		}
+			defer progress.dirDone(id.dir)
+
+			for _, path := range id.paths {
+				if err := d.loadFlatIndexAndUpdateLatestDate(path, id.dir); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
 	}
 
-	eg.Go(func() error {
-		return d.loadFlatIndexAndUpdateLatestDate(path, subDir)
-	})
+	return eg.Wait()
+}
+
+// recordLazyPathAndUpdateLatestDate notes the index file path so that
+// flatIndexesInDir() can load it later, on demand. If subDir was already
+// loaded, it is unloaded so that the new file will be seen.
+func (d *DB) recordLazyPathAndUpdateLatestDate(path, subDir string) error {
+	key := d.canonicalPath(subDir)
+
+	d.muDateBOMDirs.Lock()
+	defer d.muDateBOMDirs.Unlock()
+
+	d.lazyPaths[key] = append(d.lazyPaths[key], path)
+	d.lazyLoaded.Remove(key)
+	d.openFiles.forget(dataPathOfIndex(path))
+	noteDayDir(d.dayDirs, subDir, key)
+
+	return d.updateLatestDate(filepath.Dir(key))
 }
 
 func (d *DB) loadFlatIndexAndUpdateLatestDate(path, subDir string) error {
@@ -229,12 +648,17 @@ func (d *DB) loadFlatIndexAndUpdateLatestDate(path, subDir string) error {
 		return err
 	}
 
+	d.openFiles.forget(fi.dataPath)
+
+	key := d.canonicalPath(subDir)
+
 	d.muDateBOMDirs.Lock()
 	defer d.muDateBOMDirs.Unlock()
 
-	d.dateBOMDirs[subDir] = append(d.dateBOMDirs[subDir], fi)
+	d.dateBOMDirs[key] = append(d.dateBOMDirs[key], fi)
+	noteDayDir(d.dayDirs, subDir, key)
 
-	return d.updateLatestDate(filepath.Dir(subDir))
+	return d.updateLatestDate(filepath.Dir(key))
 }
 
 func (d *DB) updateLatestDate(dateDir string) error {
@@ -255,15 +679,43 @@ func (d *DB) updateLatestDate(dateDir string) error {
 	return nil
 }
 
+// DataThrough returns the latest day we have complete data for, ie. that was
+// completely backfilled and has been loaded, or the zero time if we have none.
+// Days we only have hour segments of don't count.
+func (d *DB) DataThrough() time.Time {
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	if d.latestDate.IsZero() {
+		return d.latestDate
+	}
+
+	if d.partialDays[d.dateFolder(d.latestDate)] {
+		return d.latestDate.Add(-oneDay)
+	}
+
+	return d.latestDate
+}
+
+// DataVersion returns a string that changes whenever we load new or replaced
+// days, eg. for use in HTTP ETags. It is also different for each DB made, since
+// the data could have changed while no DB was using it.
+func (d *DB) DataVersion() string {
+	return fmt.Sprintf("%x.%x", d.created.UnixNano(), d.dataVersion.Load())
+}
+
 func (d *DB) monitorFlatIndexes() {
 	ticker := time.NewTicker(d.updateFrequency)
+	d.updateTicker = ticker
 	d.stopMonitoring = make(chan bool)
 
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				d.loadLatestFlatIndexes()
+				if err := d.Reload(); err != nil {
+					slog.Error("syncFromObjectStore failed", "err", err)
+				}
 			case <-d.stopMonitoring:
 				ticker.Stop()
 
@@ -273,19 +725,80 @@ func (d *DB) monitorFlatIndexes() {
 	}()
 }
 
+// shrinkPoolWhenIdle starts freeing pool buffers that go unused for the given
+// period, if it's greater than 0.
+func (d *DB) shrinkPoolWhenIdle(idle time.Duration) {
+	if idle > 0 {
+		d.stopShrinkingPool = d.bufPool.shrinkWhenIdle(idle)
+	}
+}
+
+// PoolMetrics returns a metrics.Writer of metrics on the occupancy of the pool
+// of buffers that Scroll()s read hit data in to.
+func (d *DB) PoolMetrics() metrics.Writer {
+	return d.bufPool
+}
+
+// SetUpdateFrequency changes how often we look for newly backfilled days, as if
+// it had been our configured UpdateFrequency (0 meaning the default of 1hr).
+func (d *DB) SetUpdateFrequency(frequency time.Duration) {
+	if frequency <= 0 {
+		frequency = defaultUpdateFrequency
+	}
+
+	if d.updateTicker != nil {
+		d.updateTicker.Reset(frequency)
+	}
+}
+
+// Reload looks for newly backfilled days right away, instead of waiting for
+// our UpdateFrequency ticker, first syncing them from our ObjectStore if we
+// have one. Days found locally are still loaded if the sync fails, in which
+// case its error is returned. Days that were moved between our directories
+// (see Tier()) are reloaded from their new location.
+func (d *DB) Reload() error {
+	d.muReload.Lock()
+	defer d.muReload.Unlock()
+
+	err := d.syncFromObjectStoreIfConfigured()
+
+	d.reloadMovedDays()
+	d.loadLatestFlatIndexes()
+
+	return err
+}
+
+// watchForNewDaysIfBackfilling calls watchForNewDays() if we only load
+// successfully backfilled days; otherwise we can't tell when a day is complete
+// and just rely on our UpdateFrequency ticker.
+func (d *DB) watchForNewDaysIfBackfilling() {
+	if !d.checkBackfillSuccess {
+		return
+	}
+
+	if err := d.watchForNewDays(); err != nil {
+		slog.Warn("not watching for new days", "err", err)
+	}
+}
+
 func (d *DB) loadLatestFlatIndexes() {
+	for _, dayDir := range d.partialDayDirs() {
+		d.loadDay(dayDir)
+	}
+
+	refilled := false
+
+	for _, dayDir := range d.markRefilledDays() {
+		refilled = d.loadDay(dayDir) || refilled
+	}
+
+	previousLatestDate := d.latestDate
 	currentDay := d.latestDate.Add(oneDay)
 	maxDay := time.Now()
 
 	for {
-		dateFolder := d.dateFolder(currentDay)
-
-		_, err := os.Stat(dateFolder)
-		if err == nil {
-			err = d.loadAllFlatIndexes(dateFolder)
-			if err != nil {
-				slog.Error("loadAllFlatIndexes failed", "err", err)
-			}
+		if dateFolder, ok := d.existingDateFolder(currentDay); ok {
+			d.loadDay(dateFolder)
 		}
 
 		currentDay = currentDay.Add(oneDay)
@@ -294,6 +807,14 @@ func (d *DB) loadLatestFlatIndexes() {
 			break
 		}
 	}
+
+	if d.latestDate.Equal(previousLatestDate) && !refilled {
+		return
+	}
+
+	if err := d.writeIndexCache(); err != nil {
+		slog.Error("writeIndexCache failed", "err", err)
+	}
 }
 
 // Store stores the Details in the Hits from the channel in flat database
@@ -304,36 +825,76 @@ func (d *DB) loadLatestFlatIndexes() {
 //
 // NB: You can only call Store() concurrently if the result supplied to each
 // invocation is for a query of unique days.
+//
+// If a hit can't be stored, eg. because it has an over-long group name, an
+// error is returned, unless the configured SkipBadHits is true, in which case
+// the hit is skipped; see SkippedHits().
 func (d *DB) Store(hitCh chan *es.Hit) error {
+	if d.readOnly {
+		return Error{Msg: ErrReadOnly, cause: d.dir}
+	}
+
+	return d.storeUnder(d.dir, hitCh)
+}
+
+// storeUnder is like Store(), but the day/BOM directories are created in the
+// given root directory instead of our own.
+func (d *DB) storeUnder(root string, hitCh chan *es.Hit) error {
+	return d.storeIn(&flatDBSet{root: root}, hitCh)
+}
+
+// flatDBSet holds the flatDBs being stored to, keyed on their day/BOM
+// directory within root. Their files are named with the given prefix.
+type flatDBSet struct {
+	root   string
+	prefix string
+	dbs    map[string]*flatDB
+}
+
+// storeIn stores the hits from the channel in the flatDBs of the given set.
+func (d *DB) storeIn(set *flatDBSet, hitCh chan *es.Hit) error {
 	var err error
 
 	prevDay := ""
-	flatDBs := make(map[string]*flatDB)
+	set.dbs = make(map[string]*flatDB)
 
 	for hit := range hitCh {
-		prevDay, err = d.storeHit(hit, flatDBs, prevDay)
+		prevDay, err = d.storeHit(hit, set, prevDay)
 		if err != nil {
 			return err
 		}
 	}
 
-	return closeFlatDBs(flatDBs)
+	return closeFlatDBs(set.dbs)
 }
 
-func (d *DB) storeHit(hit *es.Hit, flatDBs map[string]*flatDB, prevDay string) (string, error) {
+func (d *DB) storeHit(hit *es.Hit, set *flatDBSet, prevDay string) (string, error) {
+	if !d.bomSelection.storedHit(hit) {
+		return prevDay, nil
+	}
+
+	fields, err := getFixedWidthFields(hit, d.indexWidths)
+	if err != nil {
+		return prevDay, d.badHits.handle(hit, err)
+	}
+
 	day := timestampToDay(hit.Details.Timestamp)
 	if day != prevDay && prevDay != "" {
-		if err := closeFlatDBs(flatDBs); err != nil {
+		if err = closeFlatDBs(set.dbs); err != nil {
 			return "", err
 		}
 	}
 
-	fdb, err := d.getOrCreateFlatDB(flatDBs, filepath.Join(day, hit.Details.BOM))
+	fdb, err := d.getOrCreateFlatDB(set, filepath.Join(day, clusterBOMDir(hit.Cluster, hit.Details.BOM)))
 	if err != nil {
 		return "", err
 	}
 
-	if err = fdb.Store(hit); err != nil {
+	if fields.data, err = fdb.encode(hit.Details); err != nil {
+		return day, d.badHits.handle(hit, err)
+	}
+
+	if err = fdb.storeFields(hit, fields); err != nil {
 		return "", err
 	}
 
@@ -346,7 +907,7 @@ func timestampToDay(timeStamp int64) string {
 
 func closeFlatDBs(flatDBs map[string]*flatDB) error {
 	for key, fdb := range flatDBs {
-		if err := fdb.Close(); err != nil {
+		if err := fdb.Finish(); err != nil {
 			return err
 		}
 
@@ -356,19 +917,34 @@ func closeFlatDBs(flatDBs map[string]*flatDB) error {
 	return nil
 }
 
-func (d *DB) getOrCreateFlatDB(flatDBs map[string]*flatDB, dayBom string) (*flatDB, error) {
+func (d *DB) getOrCreateFlatDB(set *flatDBSet, dayBom string) (*flatDB, error) {
 	var err error
 
 	dayBom = sanitiseBOMForFileSystem(dayBom)
 
-	fdb, ok := flatDBs[dayBom]
+	fdb, ok := set.dbs[dayBom]
 	if !ok {
-		fdb, err = newFlatDB(filepath.Join(d.dir, dayBom), d.fileSize, d.bufferSize)
+		fdb, err = newPrefixedFlatDB(filepath.Join(set.root, dayBom), set.prefix,
+			d.fileSize, d.bufferSize, d.indexWidths)
 		if err != nil {
 			return nil, err
 		}
 
-		flatDBs[dayBom] = fdb
+		if err = fdb.addColumns(d.columnFields); err != nil {
+			fdb.Close()
+
+			return nil, err
+		}
+
+		if d.jobPrefixes {
+			if err = fdb.addJobPrefixIndex(); err != nil {
+				fdb.Close()
+
+				return nil, err
+			}
+		}
+
+		set.dbs[dayBom] = fdb
 	}
 
 	return fdb, nil
@@ -391,6 +967,7 @@ type localDataEntry struct {
 	fi    *flatIndex
 	entry *flatIndexEntry
 	start int
+	hit   int
 }
 
 // Scroll returns all the hits that pass certain match and prefix filter terms
@@ -398,16 +975,42 @@ type localDataEntry struct {
 // expressed with specific lte and gte RFC3339 values).
 //
 // To avoid memory allocations and increase performance, the returned Result
-// Details are unsafely backed by a pool of byte slices. It is only safe to
-// release these to the pool once you are done with the Result. To avoid a
-// memory leak, you must signify when you are done by calling
-// Done(result.PoolKey).
+// Details are unsafely backed by a pool of byte slices (or, if configured to
+// MMap, by the memory-mapped data files themselves). It is only safe to
+// release these once you are done with the Result. To avoid a memory leak, you
+// must signify when you are done by calling Done(result.PoolKey).
+//
+// If the query's Context() is cancelled, we stop reading the database files as
+// soon as possible and return its error.
+//
+// If the configured MaxHits or MaxBytes would be exceeded, returns an
+// ErrQueryTooLarge Error without reading any hit data.
+//
+// Hits are in no particular order, unless the query's Sort is on timestamp
+// (see es.Query.TimestampSort()), in which case the already time-ordered hits
+// of each day's files are merged in to that order.
 func (d *DB) Scroll(query *es.Query) (*es.Result, error) {
+	order, err := query.TimestampSort()
+	if err != nil {
+		return nil, err
+	}
+
 	filter, err := newFlatFilter(query)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = d.checkFilter(filter); err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquireScrollSlot(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
 	var (
 		mu      sync.Mutex
 		numHits int
@@ -415,6 +1018,7 @@ func (d *DB) Scroll(query *es.Query) (*es.Result, error) {
 	)
 
 	allLDEs := make(map[string][]localDataEntry)
+	scanStart := time.Now()
 
 	d.operateOnRequestedDays(filter, func(fi *flatIndex) {
 		entries := fi.IndexSearch(filter)
@@ -441,6 +1045,17 @@ func (d *DB) Scroll(query *es.Query) (*es.Result, error) {
 		allLDEs[fi.dataPath] = append(allLDEs[fi.dataPath], ldes...)
 	})
 
+	query.TimePhase(es.PhaseIndexScan, scanStart)
+	query.SetScanned(filter.scanned.Load())
+
+	if err = filter.contextErr(); err != nil {
+		return nil, err
+	}
+
+	if err = d.checkQuerySize(numHits, lenHits); err != nil {
+		return nil, err
+	}
+
 	hits := make([]es.Hit, numHits)
 	result := &es.Result{
 		ScrollID: pretendScrollID,
@@ -454,64 +1069,142 @@ func (d *DB) Scroll(query *es.Query) (*es.Result, error) {
 		return result, nil
 	}
 
-	buf, poolKey := d.bufPool.Get(lenHits)
+	var (
+		buf     []byte
+		poolKey int
+	)
+
+	if d.openFiles.mmap {
+		poolKey = d.bufPool.Key()
+	} else {
+		buf, poolKey = d.bufPool.Get(lenHits)
+	}
+
 	result.PoolKey = poolKey
-	hitI := 0
-	eg := errgroup.Group{}
+	runs := make([][]localDataEntry, 0, len(allLDEs))
 
 	for _, ldes := range allLDEs {
-		startingHitIndex := hitI
+		runs = append(runs, ldes)
+	}
+
+	setHitOrder(runs, order)
+
+	eg := errgroup.Group{}
+
+	for _, ldes := range runs {
 		theseLDEs := ldes
 
 		eg.Go(func() error {
-			return d.getIndexEntriesHits(buf, theseLDEs, filter.desiredFields, hits, startingHitIndex)
+			return d.getIndexEntriesHits(buf, poolKey, theseLDEs, filter, hits)
 		})
+	}
+
+	if err = eg.Wait(); err != nil {
+		d.Done(poolKey)
+
+		return nil, err
+	}
+
+	return filterUnindexed(result, query, filter.patterns), nil
+}
+
+// acquireScrollSlot waits until fewer than the configured
+// MaxSimultaneousScrolls are running, or the filter's context is done. You must
+// call the returned function when your scroll completes. Returns an ErrDraining
+// Error once Drain() has been called.
+func (d *DB) acquireScrollSlot(filter *flatFilter) (func(), error) {
+	if !d.scans.begin() {
+		return nil, Error{Msg: ErrDraining}
+	}
 
-		hitI += len(ldes)
+	if d.scrollSem == nil {
+		return d.scans.end, nil
 	}
 
-	err = eg.Wait()
+	if err := d.scrollSem.Acquire(filter.ctx, 1); err != nil {
+		d.scans.end()
+
+		return nil, err
+	}
+
+	return func() {
+		d.scrollSem.Release(1)
+		d.scans.end()
+	}, nil
+}
+
+// queryLimits holds the configured MaxHits and MaxBytes.
+type queryLimits struct {
+	maxHits  int
+	maxBytes int
+}
+
+// checkQuerySize returns an ErrQueryTooLarge Error if the given number of hits
+// or bytes of hit data exceed our configured maximums.
+func (q queryLimits) checkQuerySize(numHits, numBytes int) error {
+	if (q.maxHits > 0 && numHits > q.maxHits) || (q.maxBytes > 0 && numBytes > q.maxBytes) {
+		return Error{Msg: ErrQueryTooLarge, cause: fmt.Sprintf("%d hits, %d bytes", numHits, numBytes)}
+	}
 
-	return filterUnindexed(result, query), err
+	return nil
 }
 
-func (d *DB) getIndexEntriesHits(buf []byte, ldes []localDataEntry, fields es.Fields,
-	hits []es.Hit, hitIndex int) error {
-	for _, lde := range ldes {
-		data := buf[lde.start : lde.start+lde.entry.length]
+// getIndexEntriesHits reads and deserializes the hits of the given entries, all
+// of which must be in the same data file, in to hits at each entry's hit index.
+// The hit data is read in to buf, unless that is nil, in which case hits are
+// deserialized directly from the mapped data file, which is then held open
+// until Done(poolKey).
+func (d *DB) getIndexEntriesHits(buf []byte, poolKey int, ldes []localDataEntry, filter *flatFilter,
+	hits []es.Hit) error {
+	of, err := d.openFiles.acquire(ldes[0].fi.dataPath)
+	if err != nil {
+		return err
+	}
+
+	if buf == nil && of.mapped != nil {
+		d.heldFiles.hold(poolKey, of)
+	} else {
+		defer d.openFiles.release(of)
+	}
+
+	timer := filter.query.PhaseTimer()
+	defer timer.Stop()
+
+	for i, lde := range ldes {
+		if i%contextCheckInterval == 0 {
+			if err := filter.contextErr(); err != nil {
+				return err
+			}
+		}
 
-		err := lde.fi.getDataEntry(data, lde.entry)
+		data, err := entryData(of, buf, lde)
 		if err != nil {
 			return err
 		}
 
-		details, err := es.DeserializeDetails(data, fields)
+		timer.Mark(es.PhaseDataRead)
+
+		details, err := lde.fi.deserialize(data, filter.desiredFields)
 		if err != nil {
 			return err
 		}
 
-		hits[hitIndex] = es.Hit{
+		timer.Mark(es.PhaseDeserialize)
+
+		hits[lde.hit] = es.Hit{
 			ID:      details.ID,
 			Details: details,
 		}
-
-		hitIndex++
 	}
 
-	ldes[0].fi.close()
-
 	return nil
 }
 
 func (d *DB) operateOnRequestedDays(filter *flatFilter, cb func(*flatIndex)) {
-	currentDay := filter.GTE
-
 	var wg sync.WaitGroup
 
-	for {
-		d.muDateBOMDirs.RLock()
-		indexes := d.dateBOMDirs[filepath.Join(d.dateFolder(currentDay), filter.BOM)]
-		d.muDateBOMDirs.RUnlock()
+	d.forEachRequestedDayBOMDir(filter, func(dayBOMDir string) {
+		indexes := d.flatIndexesInDir(dayBOMDir)
 
 		wg.Add(len(indexes))
 
@@ -519,9 +1212,30 @@ func (d *DB) operateOnRequestedDays(filter *flatFilter, cb func(*flatIndex)) {
 			go func(dbIndex *flatIndex) {
 				defer wg.Done()
 
+				if filter.contextErr() != nil {
+					return
+				}
+
 				cb(dbIndex)
 			}(index)
 		}
+	})
+
+	wg.Wait()
+}
+
+// forEachRequestedDayBOMDir calls the given callback with the date/BOM
+// directory path (in the filter's cluster) of each day in the filter's date
+// range.
+func (d *DB) forEachRequestedDayBOMDir(filter *flatFilter, cb func(string)) {
+	currentDay := filter.start()
+
+	for {
+		if filter.contextErr() != nil {
+			return
+		}
+
+		cb(filepath.Join(d.dateFolder(currentDay), filter.bomDir()))
 
 		currentDay = currentDay.Add(oneDay)
 
@@ -529,8 +1243,94 @@ func (d *DB) operateOnRequestedDays(filter *flatFilter, cb func(*flatIndex)) {
 			break
 		}
 	}
+}
+
+func (d *DB) flatIndexesInDir(dayBOMDir string) []*flatIndex {
+	if d.lazyLoaded != nil {
+		return d.lazyFlatIndexesInDir(dayBOMDir)
+	}
 
-	wg.Wait()
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	return d.dateBOMDirs[dayBOMDir]
+}
+
+// lazyFlatIndexesInDir returns the flatIndexes of the given date/BOM directory
+// from our LRU cache, first loading them if necessary.
+func (d *DB) lazyFlatIndexesInDir(dayBOMDir string) []*flatIndex {
+	if fis, ok := d.lazyLoaded.Get(dayBOMDir); ok {
+		return fis
+	}
+
+	d.muLazyLoad.Lock()
+	defer d.muLazyLoad.Unlock()
+
+	if fis, ok := d.lazyLoaded.Get(dayBOMDir); ok {
+		return fis
+	}
+
+	d.muDateBOMDirs.RLock()
+	paths := d.lazyPaths[dayBOMDir]
+	d.muDateBOMDirs.RUnlock()
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	fis, err := d.loadFlatIndexes(paths)
+	if err != nil {
+		slog.Error("lazy loading of flat indexes failed", "dir", dayBOMDir, "err", err)
+
+		return nil
+	}
+
+	d.lazyLoaded.Add(dayBOMDir, fis)
+
+	return fis
+}
+
+func (d *DB) loadFlatIndexes(paths []string) ([]*flatIndex, error) {
+	fis := make([]*flatIndex, len(paths))
+	eg := errgroup.Group{}
+
+	for i, path := range paths {
+		eg.Go(func() error {
+			fi, err := newFlatIndex(path, d.bufferSize)
+			fis[i] = fi
+
+			return err
+		})
+	}
+
+	return fis, eg.Wait()
+}
+
+// dateBOMDirsWithPrefix returns the paths of all known date/BOM directories
+// that start with the given prefix.
+func (d *DB) dateBOMDirsWithPrefix(prefix string) []string {
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	var dirs []string
+
+	if d.lazyLoaded != nil {
+		for dir := range d.lazyPaths {
+			if strings.HasPrefix(dir, prefix) {
+				dirs = append(dirs, dir)
+			}
+		}
+
+		return dirs
+	}
+
+	for dir := range d.dateBOMDirs {
+		if strings.HasPrefix(dir, prefix) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
 }
 
 func (d *DB) dateFolder(day time.Time) string {
@@ -539,28 +1339,21 @@ func (d *DB) dateFolder(day time.Time) string {
 
 // filterUnindexed is used to apply filtering to hits in the result for cases
 // where the query contains match_phrase/prefix filters for properties we don't
-// index on, and were thus ignored up until now. If query only contains indexed
-// or unknown properties returns result unaltered.
-func filterUnindexed(result *es.Result, query *es.Query) *es.Result {
-	matchFilters := nonIndexFilters(query.MatchFilters())
-	prefixFilters := nonIndexFilters(query.PrefixFilters())
-
-	if len(matchFilters) == 0 && len(prefixFilters) == 0 {
+// index on, and were thus ignored up until now, or wildcard/regexp filters
+// (which must be supplied as the query's patternMatchers). If query only
+// contains indexed or unknown properties returns result unaltered.
+func filterUnindexed(result *es.Result, query *es.Query, patterns []patternMatcher) *es.Result {
+	matches := unindexedMatcher(query, patterns)
+	if matches == nil {
 		return result
 	}
 
 	var hits []es.Hit //nolint:prealloc
 
 	for _, hit := range result.HitSet.Hits {
-		if !nonIndexMatch(matchFilters, hit, strings.Contains) {
-			continue
-		}
-
-		if !nonIndexMatch(prefixFilters, hit, strings.HasPrefix) {
-			continue
+		if matches(hit) {
+			hits = append(hits, hit)
 		}
-
-		hits = append(hits, hit)
 	}
 
 	result.HitSet.Total.Value = len(hits)
@@ -569,17 +1362,31 @@ func filterUnindexed(result *es.Result, query *es.Query) *es.Result {
 	return result
 }
 
+// unindexedMatcher returns a func that returns true if a hit passes the
+// filters in the given query that we don't index on, and the given
+// patternMatchers. Returns nil if there are no such filters.
+func unindexedMatcher(query *es.Query, patterns []patternMatcher) func(es.Hit) bool {
+	matchFilters := nonIndexFilters(query.MatchFilters())
+	prefixFilters := nonIndexFilters(query.PrefixFilters())
+
+	if len(matchFilters) == 0 && len(prefixFilters) == 0 && len(patterns) == 0 {
+		return nil
+	}
+
+	return func(hit es.Hit) bool {
+		return nonIndexMatch(matchFilters, hit, strings.Contains) &&
+			nonIndexMatch(prefixFilters, hit, strings.HasPrefix) &&
+			patternsMatch(patterns, hit)
+	}
+}
+
 func nonIndexFilters(allFilters map[string]string) map[string]string {
 	niFilters := make(map[string]string)
 
 	for k, v := range allFilters {
 		switch k {
-		case "BOM", "ACCOUNTING_NAME", "USER_NAME":
+		case "BOM", "ACCOUNTING_NAME", "USER_NAME", "QUEUE_NAME":
 			continue
-		case "QUEUE_NAME":
-			if v != gpuPrefix {
-				continue
-			}
 		}
 
 		niFilters[k] = v
@@ -621,45 +1428,109 @@ func nonIndexMatch(filters map[string]string, hit es.Hit, cmpFunc func(string, s
 // memory. Returns true if there were slices associated with the given PoolKey,
 // false if it did nothing because there were not.
 func (d *DB) Done(poolKey int) bool {
-	return d.bufPool.Done(poolKey)
+	released := d.bufPool.Done(poolKey)
+
+	if d.heldFiles.release(d.openFiles, poolKey) {
+		released = true
+	}
+
+	return released
 }
 
 // Usernames is like Scroll(), but picks out and returns only the unique
-// usernames from amongst the Hits.
+// usernames from amongst the Hits. It is the same as calling DistinctValues()
+// with USER_NAME.
 func (d *DB) Usernames(query *es.Query) ([]string, error) {
+	return d.DistinctValues(query, "USER_NAME")
+}
+
+// Count is like Scroll(), but only returns the number of matching hits. Unless
+// the query has match_phrase/prefix filters on properties we don't index, this
+// is answered purely from the index files, without reading any hit data.
+func (d *DB) Count(query *es.Query) (int, error) {
+	if hasNonIndexFilters(query) {
+		return d.countByScrolling(query)
+	}
+
 	filter, err := newFlatFilter(query)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+
+	if err = d.checkFilter(filter); err != nil {
+		return 0, err
 	}
 
-	var mu sync.Mutex
+	var count atomic.Int64
 
-	usernamesMap := make(map[string]bool)
+	scanStart := time.Now()
 
 	d.operateOnRequestedDays(filter, func(fi *flatIndex) {
-		theseUsernames := fi.Usernames(filter)
+		count.Add(int64(fi.Count(filter)))
+	})
 
-		mu.Lock()
-		defer mu.Unlock()
+	query.TimePhase(es.PhaseIndexScan, scanStart)
+	query.SetScanned(filter.scanned.Load())
+
+	return int(count.Load()), filter.contextErr()
+}
 
-		for name := range theseUsernames {
-			usernamesMap[strings.TrimSpace(name)] = true
+// hasNonIndexFilters returns true if the query filters on properties that we
+// don't index but nonIndexMatch() can filter on after reading hit data, or has
+// wildcard/regexp filters.
+func hasNonIndexFilters(query *es.Query) bool {
+	if len(query.PatternFilters()) > 0 {
+		return true
+	}
+
+	for k := range nonIndexFilters(query.Filters()) {
+		switch k {
+		case "Command", "JOB_NAME", "Job":
+			return true
 		}
-	})
+	}
 
-	usernames := make([]string, 0, len(usernamesMap))
-	for username := range usernamesMap {
-		usernames = append(usernames, username)
+	return false
+}
+
+func (d *DB) countByScrolling(query *es.Query) (int, error) {
+	result, err := d.Scroll(query)
+	if err != nil {
+		return 0, err
 	}
 
-	return usernames, nil
+	d.Done(result.PoolKey)
+
+	return result.HitSet.Total.Value, nil
 }
 
-// Close stops any ongoing monitoring cleanly.
+// Close stops any ongoing monitoring and watching cleanly, and writes an index
+// cache file to the database directory so that the next New() can start up
+// faster.
 func (d *DB) Close() error {
+	loaded := d.stopBackgroundLoad()
+
 	if d.stopMonitoring != nil {
 		d.stopMonitoring <- true
+		d.stopMonitoring = nil
 	}
 
-	return nil
+	if d.stopShrinkingPool != nil {
+		d.stopShrinkingPool()
+		d.stopShrinkingPool = nil
+	}
+
+	if d.watcher != nil {
+		if err := d.watcher.Close(); err != nil {
+			return err
+		}
+	}
+
+	d.openFiles.closeAll()
+
+	if !loaded {
+		return nil
+	}
+
+	return d.writeIndexCache()
 }
@@ -26,6 +26,7 @@
 package db
 
 import (
+	"context"
 	"encoding/binary"
 	"os"
 	"path/filepath"
@@ -49,9 +50,10 @@ func TestDB(t *testing.T) {
 		tmpDir := t.TempDir()
 		dbDir := filepath.Join(tmpDir, "db")
 		config := Config{
-			Directory:  dbDir,
-			FileSize:   fileSize,
-			BufferSize: bufferSize,
+			Directory:      dbDir,
+			FileSize:       fileSize,
+			BufferSize:     bufferSize,
+			JobPrefixIndex: true,
 		}
 
 		db, err := New(config, false)
@@ -138,15 +140,28 @@ func TestDB(t *testing.T) {
 			dir = filepath.Join(dir, bomA)
 			entries, err = os.ReadDir(dir)
 			So(err, ShouldBeNil)
-			So(len(entries), ShouldEqual, 24)
+			So(len(entries), ShouldEqual, 108)
 			So(entries[0].Type().IsRegular(), ShouldBeTrue)
 			So(entries[0].Name(), ShouldEqual, "0.data")
 			So(entries[1].Type().IsRegular(), ShouldBeTrue)
-			So(entries[1].Name(), ShouldEqual, "0.index")
-			So(entries[23].Type().IsRegular(), ShouldBeTrue)
-			So(entries[23].Name(), ShouldEqual, "9.index")
-			So(entries[7].Type().IsRegular(), ShouldBeTrue)
-			So(entries[7].Name(), ShouldEqual, "11.index")
+			So(entries[1].Name(), ShouldEqual, "0.dict")
+			So(entries[2].Type().IsRegular(), ShouldBeTrue)
+			So(entries[2].Name(), ShouldEqual, "0.index")
+			So(entries[3].Type().IsRegular(), ShouldBeTrue)
+			So(entries[3].Name(), ShouldEqual, "0.sums")
+			So(entries[102].Type().IsRegular(), ShouldBeTrue)
+			So(entries[102].Name(), ShouldEqual, "9.index")
+			So(entries[14].Type().IsRegular(), ShouldBeTrue)
+			So(entries[14].Name(), ShouldEqual, "11.index")
+			So(entries[104].Name(), ShouldEqual, gpuRollupBasename)
+			So(entries[105].Name(), ShouldEqual, jobPrefixBasename)
+			So(entries[106].Name(), ShouldEqual, rollupBasename)
+			So(entries[107].Name(), ShouldEqual, usernamesBasename)
+
+			bJobs, err := os.ReadFile(filepath.Join(dir, jobPrefixBasename))
+			So(err, ShouldBeNil)
+			So(bJobs[:lengthEncodeWidth], ShouldResemble, u32tob(0))
+			So(string(bJobs[jobPrefixSectionHeader:jobPrefixSectionHeader+jobPrefixWidth]), ShouldEqual, "jobA    ")
 
 			indexFilePath := filepath.Join(dir, "0.index")
 			bIndex, err := os.ReadFile(indexFilePath)
@@ -174,17 +189,26 @@ func TestDB(t *testing.T) {
 			So(bIndex[nextFieldStart:nextFieldStart+1], ShouldEqual, []byte{notInGPUQueue})
 
 			nextFieldStart++
+			So(string(bIndex[nextFieldStart:nextFieldStart+queueNameWidth]), ShouldEqual, "normal              ")
+
+			nextFieldStart += queueNameWidth
 			dataPos := int(binary.BigEndian.Uint32(bIndex[nextFieldStart : nextFieldStart+lengthEncodeWidth]))
 			expectedDataPos := 0
 			So(dataPos, ShouldEqual, expectedDataPos)
 
 			nextFieldStart += lengthEncodeWidth
 			detailsLen := int(binary.BigEndian.Uint32(bIndex[nextFieldStart : nextFieldStart+lengthEncodeWidth]))
-			expectedDetailsLen := 143
+			expectedDetailsLen := 307
 			So(detailsLen, ShouldEqual, expectedDetailsLen)
 
 			detailsBytes := bData[dataPos:detailsLen]
-			details, err := es.DeserializeDetails(detailsBytes, 0)
+			_, err = es.DeserializeDetails(detailsBytes, 0)
+			So(err, ShouldResemble, es.Error{Msg: es.ErrNoDictionary})
+
+			dict, err := readDictionary(dictionaryPath(dataFilePath))
+			So(err, ShouldBeNil)
+
+			details, err := es.DeserializeDetailsWithDictionary(detailsBytes, 0, dict)
 			So(err, ShouldBeNil)
 
 			timeStamp, err := time.Parse(time.RFC3339, "2024-02-04T00:00:01Z")
@@ -201,13 +225,13 @@ func TestDB(t *testing.T) {
 			dir = filepath.Join(dbDir, "2024", "02", "05", bomA)
 			entries, err = os.ReadDir(dir)
 			So(err, ShouldBeNil)
-			So(len(entries), ShouldEqual, 24)
+			So(len(entries), ShouldEqual, 108)
 
-			indexFilePath = filepath.Join(dir, "11.index")
+			indexFilePath = filepath.Join(dir, "25.index")
 			bIndex, err = os.ReadFile(indexFilePath)
 			So(err, ShouldBeNil)
 
-			dataFilePath = filepath.Join(dir, "11.data")
+			dataFilePath = filepath.Join(dir, "25.data")
 			bData, err = os.ReadFile(dataFilePath)
 			So(err, ShouldBeNil)
 
@@ -221,7 +245,11 @@ func TestDB(t *testing.T) {
 
 			detailsBytes = bData[dataPos:]
 			So(len(detailsBytes), ShouldEqual, detailsLen)
-			details, err = es.DeserializeDetails(detailsBytes, 0)
+
+			dict, err = readDictionary(dictionaryPath(dataFilePath))
+			So(err, ShouldBeNil)
+
+			details, err = es.DeserializeDetailsWithDictionary(detailsBytes, 0, dict)
 			So(err, ShouldBeNil)
 			So(details, ShouldResemble, result.HitSet.Hits[expectedNumHits-1].Details)
 
@@ -293,6 +321,225 @@ func TestDB(t *testing.T) {
 					sort.Strings(usernames)
 					So(usernames, ShouldResemble, []string{"userA", "userB", "userNameLongest"})
 
+					values, errd := db.DistinctValues(query, "ACCOUNTING_NAME")
+					So(errd, ShouldBeNil)
+
+					sort.Strings(values)
+					So(values, ShouldResemble, []string{"groupA", "groupB"})
+
+					values, errd = db.DistinctValues(query, es.DistinctGPU)
+					So(errd, ShouldBeNil)
+
+					sort.Strings(values)
+					So(values, ShouldResemble, []string{"false", "true"})
+
+					values, errd = db.DistinctValues(query, "BOM")
+					So(errd, ShouldBeNil)
+					So(values, ShouldResemble, []string{bomA})
+
+					values, errd = db.DistinctValues(query, "JOB_NAME")
+					So(errd, ShouldNotBeNil)
+					So(values, ShouldBeNil)
+
+					anyBOMQuery := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: query.Query.Bool.Filter[:2]}}}
+					values, errd = db.DistinctValues(anyBOMQuery, "BOM")
+					So(errd, ShouldBeNil)
+
+					sort.Strings(values)
+					So(values, ShouldResemble, []string{bomA, "bomB", "bomC–IDS"})
+
+					_, errd = db.DistinctValues(anyBOMQuery, "USER_NAME")
+					So(errd, ShouldNotBeNil)
+
+					counts, errd := db.DistinctCounts(query, "USER_NAME")
+					So(errd, ShouldBeNil)
+					So(mapKeys(counts), ShouldHaveLength, 3)
+					So(counts["userA"]+counts["userB"]+counts["userNameLongest"], ShouldEqual, expectedBomHits)
+
+					_, errd = db.DistinctCounts(query, "BOM")
+					So(errd, ShouldNotBeNil)
+					So(errd.Error(), ShouldEqual, ErrDistinctCountsBOM)
+
+					count, errc := db.Count(query)
+					So(errc, ShouldBeNil)
+					So(count, ShouldEqual, expectedBomHits)
+					So(query.Scanned(), ShouldBeGreaterThanOrEqualTo, expectedBomHits)
+
+					Convey("but not with a cancelled context", func() {
+						ctx, cancel := context.WithCancel(context.Background())
+						cancel()
+
+						cancelled := query.WithContext(ctx)
+
+						_, err = db.Scroll(cancelled)
+						So(err, ShouldEqual, context.Canceled)
+
+						_, err = db.Count(cancelled)
+						So(err, ShouldEqual, context.Canceled)
+
+						_, err = db.DistinctValues(cancelled, "USER_NAME")
+						So(err, ShouldEqual, context.Canceled)
+
+						_, err = db.DistinctValues(anyBOMQuery.WithContext(ctx), "BOM")
+						So(err, ShouldEqual, context.Canceled)
+
+						_, err = db.MultiScroll([]*es.Query{query, cancelled})
+						So(err, ShouldEqual, context.Canceled)
+					})
+
+					Convey("unless it is larger than the configured limits", func() {
+						limitedConfig := config
+						limitedConfig.MaxHits = expectedBomHits - 1
+
+						limitedDB, errl := New(limitedConfig, false)
+						So(errl, ShouldBeNil)
+
+						defer limitedDB.Close()
+
+						_, err = limitedDB.Scroll(query)
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldStartWith, ErrQueryTooLarge)
+
+						_, err = limitedDB.MultiScroll([]*es.Query{query})
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldStartWith, ErrQueryTooLarge)
+
+						count, errc = limitedDB.Count(query)
+						So(errc, ShouldBeNil)
+						So(count, ShouldEqual, expectedBomHits)
+
+						limitedDB.maxHits = expectedBomHits
+						retrieved, err = limitedDB.Scroll(query)
+						So(err, ShouldBeNil)
+						So(len(retrieved.HitSet.Hits), ShouldEqual, expectedBomHits)
+						limitedDB.Done(retrieved.PoolKey)
+
+						limitedDB.maxBytes = 1
+						_, err = limitedDB.Scroll(query)
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldStartWith, ErrQueryTooLarge)
+					})
+
+					Convey("waiting if too many others are already being done", func() {
+						limitedConfig := config
+						limitedConfig.MaxSimultaneousScrolls = 1
+
+						limitedDB, errl := New(limitedConfig, false)
+						So(errl, ShouldBeNil)
+
+						defer limitedDB.Close()
+
+						release, errl := limitedDB.acquireScrollSlot(&flatFilter{ctx: context.Background()})
+						So(errl, ShouldBeNil)
+
+						ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+						defer cancel()
+
+						_, err = limitedDB.Scroll(query.WithContext(ctx))
+						So(err, ShouldEqual, context.DeadlineExceeded)
+
+						release()
+
+						retrieved, err = limitedDB.Scroll(query)
+						So(err, ShouldBeNil)
+						So(len(retrieved.HitSet.Hits), ShouldEqual, expectedBomHits)
+						limitedDB.Done(retrieved.PoolKey)
+					})
+
+					Convey("or with a db that lazily loads its indexes", func() {
+						lazyConfig := config
+						lazyConfig.LazyLoadDirs = 1
+
+						lazyDB, errl := New(lazyConfig, false)
+						So(errl, ShouldBeNil)
+
+						defer lazyDB.Close()
+
+						So(lazyDB.lazyLoaded.Len(), ShouldEqual, 0)
+						So(lazyDB.dateBOMDirs, ShouldBeEmpty)
+
+						retrieved, errs = lazyDB.Scroll(query)
+						So(errs, ShouldBeNil)
+						So(len(retrieved.HitSet.Hits), ShouldEqual, expectedBomHits)
+						So(lazyDB.Done(retrieved.PoolKey), ShouldBeTrue)
+						So(lazyDB.lazyLoaded.Len(), ShouldEqual, 1)
+
+						count, errc = lazyDB.Count(query)
+						So(errc, ShouldBeNil)
+						So(count, ShouldEqual, expectedBomHits)
+
+						values, errd = lazyDB.DistinctValues(anyBOMQuery, "BOM")
+						So(errd, ShouldBeNil)
+
+						sort.Strings(values)
+						So(values, ShouldResemble, []string{bomA, "bomB", "bomC–IDS"})
+						So(lazyDB.lazyLoaded.Len(), ShouldEqual, 1)
+					})
+
+					Convey("and Close() writes an index cache that the next New() uses", func() {
+						cachePath := filepath.Join(dbDir, indexCacheBasename)
+						_, err = os.Stat(cachePath)
+						So(err, ShouldNotBeNil)
+
+						err = db.Close()
+						So(err, ShouldBeNil)
+
+						_, err = os.Stat(cachePath)
+						So(err, ShouldBeNil)
+
+						cachedDB := newDBStruct(config, false)
+						cachedDB.indexCachePath = cachePath
+						err = cachedDB.loadIndexCache()
+						So(err, ShouldBeNil)
+						So(numEntriesPerDir(cachedDB), ShouldResemble, numEntriesPerDir(db))
+						So(cachedDB.latestDate, ShouldEqual, db.latestDate)
+
+						cachedDB, err = New(config, false)
+						So(err, ShouldBeNil)
+
+						count, errc = cachedDB.Count(query)
+						So(errc, ShouldBeNil)
+						So(count, ShouldEqual, expectedBomHits)
+
+						err = cachedDB.Close()
+						So(err, ShouldBeNil)
+
+						indexFiles, errg := filepath.Glob(filepath.Join(dbDir, "*", "*", "*", "*", "*."+indexKind))
+						So(errg, ShouldBeNil)
+						So(indexFiles, ShouldNotBeEmpty)
+
+						later := time.Now().Add(time.Minute)
+						err = os.Chtimes(indexFiles[0], later, later)
+						So(err, ShouldBeNil)
+
+						staleDB := newDBStruct(config, false)
+						staleDB.indexCachePath = cachePath
+						err = staleDB.loadIndexCache()
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldStartWith, errIndexCacheStale)
+						So(staleDB.dateBOMDirs, ShouldBeEmpty)
+
+						staleDB, err = New(config, false)
+						So(err, ShouldBeNil)
+
+						count, errc = staleDB.Count(query)
+						So(errc, ShouldBeNil)
+						So(count, ShouldEqual, expectedBomHits)
+
+						err = os.WriteFile(cachePath, []byte("garbage"), 0600)
+						So(err, ShouldBeNil)
+
+						err = staleDB.loadIndexCache()
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldStartWith, errIndexCacheInvalid)
+
+						err = staleDB.Close()
+						So(err, ShouldBeNil)
+
+						err = staleDB.loadIndexCache()
+						So(err, ShouldBeNil)
+					})
+
 					Convey("you can filter on things not in the index", func() {
 						jMatch := map[string]es.MapStringStringOrMap{"prefix": map[string]interface{}{"JOB_NAME": "nf"}}
 						query.Query.Bool.Filter = append(query.Query.Bool.Filter, jMatch)
@@ -300,6 +547,74 @@ func TestDB(t *testing.T) {
 						So(err, ShouldBeNil)
 						So(retrieved.HitSet, ShouldNotBeNil)
 						So(len(retrieved.HitSet.Hits), ShouldEqual, 4114)
+
+						count, err = db.Count(query)
+						So(err, ShouldBeNil)
+						So(count, ShouldEqual, 4114)
+
+						jMatch["prefix"]["JOB_NAME"] = "nf-foo-longer"
+						count, err = db.Count(query)
+						So(err, ShouldBeNil)
+						So(count, ShouldEqual, 0)
+
+						Convey("even if the job name prefixes weren't indexed", func() {
+							jobsFiles, errg := filepath.Glob(filepath.Join(dbDir, "*", "*", "*", "*", jobPrefixBasename))
+							So(errg, ShouldBeNil)
+							So(len(jobsFiles), ShouldBeGreaterThan, 0)
+
+							for _, path := range jobsFiles {
+								So(os.Remove(path), ShouldBeNil)
+							}
+
+							db, err = New(config, false)
+							So(err, ShouldBeNil)
+
+							jMatch["prefix"]["JOB_NAME"] = "nf"
+							count, err = db.Count(query)
+							So(err, ShouldBeNil)
+							So(count, ShouldEqual, 4114)
+						})
+					})
+
+					Convey("you can MultiScroll() several queries at once", func() {
+						aMatch := map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"ACCOUNTING_NAME": "groupA"}}
+						jMatch := map[string]es.MapStringStringOrMap{"prefix": map[string]interface{}{"JOB_NAME": "nf"}}
+
+						groupQuery := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{
+							Filter: append(append(es.Filter{}, query.Query.Bool.Filter...), aMatch),
+						}}}
+						jobQuery := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{
+							Filter: append(append(es.Filter{}, query.Query.Bool.Filter...), jMatch),
+						}}, Source: []string{"JOB_NAME"}}
+
+						results, errm := db.MultiScroll([]*es.Query{query, groupQuery, jobQuery})
+						So(errm, ShouldBeNil)
+						So(len(results), ShouldEqual, 3)
+						So(len(results[0].HitSet.Hits), ShouldEqual, expectedBomHits)
+						So(len(results[1].HitSet.Hits), ShouldEqual, 69119)
+						So(results[1].HitSet.Hits[0].Details.AccountingName, ShouldEqual, "groupA")
+						So(len(results[2].HitSet.Hits), ShouldEqual, 4114)
+						So(results[2].HitSet.Hits[0].Details.JobName, ShouldStartWith, "nf")
+						So(results[2].HitSet.Hits[0].Details.AccountingName, ShouldBeBlank)
+						So(results[0].PoolKey, ShouldEqual, results[2].PoolKey)
+
+						released = db.Done(results[0].PoolKey)
+						So(released, ShouldBeTrue)
+
+						bomQuery := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{
+							Filter: append(append(es.Filter{}, query.Query.Bool.Filter[:2]...),
+								map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "bomB"}}),
+						}}}
+
+						results, errm = db.MultiScroll([]*es.Query{query, bomQuery})
+						So(errm, ShouldBeNil)
+						So(len(results[0].HitSet.Hits)+len(results[1].HitSet.Hits), ShouldEqual, expectedNumHits-1)
+
+						db.Done(results[0].PoolKey)
+
+						noBOMQuery := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: query.Query.Bool.Filter[:2]}}}
+						_, errm = db.MultiScroll([]*es.Query{query, noBOMQuery})
+						So(errm, ShouldNotBeNil)
 					})
 
 					Convey("you can filter on things in the index", func() {
@@ -319,11 +634,37 @@ func TestDB(t *testing.T) {
 
 						qMatch := map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"QUEUE_NAME": "gpu-any"}}
 						query.Query.Bool.Filter = append(query.Query.Bool.Filter, qMatch)
+
+						count, err = db.Count(query)
+						So(err, ShouldBeNil)
+						So(count, ShouldEqual, 0)
+
+						qMatch["match_phrase"]["QUEUE_NAME"] = "gpu-normal"
 						retrieved, err = db.Scroll(query)
 						So(err, ShouldBeNil)
 						So(retrieved.HitSet, ShouldNotBeNil)
 						So(len(retrieved.HitSet.Hits), ShouldEqual, 8776)
 
+						count, err = db.Count(query)
+						So(err, ShouldBeNil)
+						So(count, ShouldEqual, 8776)
+
+						qMatch["match_phrase"]["QUEUE_NAME"] = "normal"
+						count, err = db.Count(query)
+						So(err, ShouldBeNil)
+						So(count, ShouldEqual, 61439-8776)
+
+						delete(qMatch, "match_phrase")
+						qMatch["prefix"] = map[string]interface{}{"QUEUE_NAME": "gpu"}
+						count, err = db.Count(query)
+						So(err, ShouldBeNil)
+						So(count, ShouldEqual, 8776)
+
+						qMatch["prefix"]["QUEUE_NAME"] = "norm"
+						count, err = db.Count(query)
+						So(err, ShouldBeNil)
+						So(count, ShouldEqual, 61439-8776)
+
 						released = db.Done(retrieved.PoolKey)
 						So(released, ShouldBeTrue)
 
@@ -512,10 +853,130 @@ func TestDB(t *testing.T) {
 				_, ok = db.dateBOMDirs[filepath.Dir(newestFile)]
 				So(ok, ShouldBeTrue)
 			})
+
+			Convey("A DB's update frequency can be changed while it runs", func() {
+				db, err = New(config, false)
+				So(err, ShouldBeNil)
+
+				db.SetUpdateFrequency(updateFrequency)
+
+				today := time.Now().Format(dateFormat)
+				newestFile := filepath.Join(dbDir, today, bomA, "0.index")
+
+				err = makeFiles(newestFile)
+				So(err, ShouldBeNil)
+
+				<-time.After(updateFrequency * 2)
+				db.muDateBOMDirs.RLock()
+				defer db.muDateBOMDirs.RUnlock()
+
+				_, ok := db.dateBOMDirs[filepath.Dir(newestFile)]
+				So(ok, ShouldBeTrue)
+			})
 		})
 	})
 }
 
+func TestFlatFilter(t *testing.T) {
+	Convey("Given timestamp-sorted index entries", t, func() {
+		var entries []*flatIndexEntry
+
+		for _, ts := range []int64{10, 20, 20, 30, 40} {
+			entries = append(entries, &flatIndexEntry{timeStamp: i64tob(ts)})
+		}
+
+		filter := &flatFilter{GTEKey: i64tob(20), LTKey: i64tob(40)}
+
+		stamps := func(entries []*flatIndexEntry) []int {
+			s := make([]int, len(entries))
+			for i, entry := range entries {
+				s[i] = int(binary.BigEndian.Uint64(entry.timeStamp))
+			}
+
+			return s
+		}
+
+		Convey("You can get just those in the filter's time range", func() {
+			So(stamps(filter.entriesInTimeRange(entries)), ShouldResemble, []int{20, 20, 30})
+
+			filter.LTEKey = i64tob(40)
+			filter.checkLTE = true
+			So(stamps(filter.entriesInTimeRange(entries)), ShouldResemble, []int{20, 20, 30, 40})
+
+			filter.GTEKey = i64tob(41)
+			So(filter.entriesInTimeRange(entries), ShouldBeEmpty)
+
+			filter.GTEKey = i64tob(0)
+			filter.LTEKey = i64tob(5)
+			So(filter.entriesInTimeRange(entries), ShouldBeEmpty)
+			So(filter.entriesInTimeRange(nil), ShouldBeEmpty)
+		})
+
+		Convey("A GT filter excludes entries at its start", func() {
+			filter.GTKey = i64tob(20)
+			filter.checkGT = true
+			So(stamps(filter.entriesInTimeRange(entries)), ShouldResemble, []int{30})
+
+			check := filter.PassChecker()
+			check.LT(i64tob(20))
+			check.GTE(i64tob(20))
+			So(check.Passes(), ShouldBeFalse)
+
+			check = filter.PassChecker()
+			check.LT(i64tob(21))
+			check.GTE(i64tob(21))
+			So(check.Passes(), ShouldBeTrue)
+		})
+	})
+
+	Convey("You can make flat filters from queries with any combination of bounds", t, func() {
+		from := time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)
+		to := from.Add(oneDay)
+
+		newFilter := func(bounds map[string]interface{}) (*flatFilter, error) {
+			return newFlatFilter(&es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				{"range": {"timestamp": bounds}},
+				{"match_phrase": {"BOM": "bomA"}},
+			}}}})
+		}
+
+		for _, lower := range []string{"gt", "gte"} {
+			for _, upper := range []string{"lt", "lte"} {
+				filter, err := newFilter(map[string]interface{}{lower: timestamp(from), upper: timestamp(to)})
+				So(err, ShouldBeNil)
+				So(filter.checkGT, ShouldEqual, lower == "gt")
+				So(filter.checkLTE, ShouldEqual, upper == "lte")
+				So(filter.start(), ShouldEqual, from)
+				So(filter.afterStart(i64tob(from.Unix())), ShouldEqual, lower == "gte")
+				So(filter.beforeEnd(i64tob(to.Unix())), ShouldEqual, upper == "lte")
+			}
+		}
+
+		for _, bounds := range []map[string]interface{}{
+			{"gt": timestamp(from), "gte": timestamp(from), "lt": timestamp(to)},
+			{"gte": timestamp(from), "lt": timestamp(to), "lte": timestamp(to)},
+			{"gt": timestamp(from)},
+			{"lt": timestamp(to)},
+		} {
+			_, err := newFilter(bounds)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, es.ErrUnsupportedRange)
+		}
+	})
+}
+
+func numEntriesPerDir(db *DB) map[string]int {
+	counts := make(map[string]int)
+
+	for dir, fis := range db.dateBOMDirs {
+		for _, fi := range fis {
+			counts[dir] += len(fi.bomEntries)
+		}
+	}
+
+	return counts
+}
+
 func makeResult(gte, lte time.Time) *es.Result {
 	result := &es.Result{
 		HitSet: &es.HitSet{},
@@ -0,0 +1,122 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"strings"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// dictKind is the kind of file that holds the es.Dictionary of the
+// corresponding data file. It holds the serialized Dictionary, followed by a 4
+// byte big endian CRC-32C of it.
+//
+// Data files written before dictionaries existed don't have one, and their
+// hits were serialized without one.
+const dictKind = "dict"
+
+// dictionaryPath returns the path to the dictionary file that corresponds to
+// the given data file path.
+func dictionaryPath(dataPath string) string {
+	return strings.TrimSuffix(dataPath, dataKind) + dictKind
+}
+
+// writeDictionary writes the given dictionary and its checksum to the given
+// path.
+func writeDictionary(path string, dict *es.Dictionary) error {
+	encoded, err := dict.Serialize() //nolint:misspell
+	if err != nil {
+		return err
+	}
+
+	encoded = append(encoded, u32tob(crc32.Checksum(encoded, castagnoli))...)
+
+	return os.WriteFile(path, encoded, dbFilePerms)
+}
+
+// readDictionary reads the dictionary written to the given path by
+// writeDictionary(), verifying its checksum. Returns nil if there is no such
+// file.
+func readDictionary(path string) (*es.Dictionary, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < checksumWidth {
+		return nil, checksumError(path, "dictionary")
+	}
+
+	encoded, sum := b[:len(b)-checksumWidth], b[len(b)-checksumWidth:]
+
+	if crc32.Checksum(encoded, castagnoli) != binary.BigEndian.Uint32(sum) {
+		return nil, checksumError(path, "dictionary")
+	}
+
+	return es.DeserializeDictionary(encoded)
+}
+
+// dictionary returns the dictionary of our data file, reading it the first
+// time we're called. Returns nil if our data file doesn't have one.
+func (f *flatIndex) dictionary() (*es.Dictionary, error) {
+	f.dictOnce.Do(func() {
+		f.dict, f.dictErr = readDictionary(dictionaryPath(f.dataPath))
+	})
+
+	return f.dict, f.dictErr
+}
+
+// deserialize deserializes the given data of one of our entries, using our
+// dictionary.
+func (f *flatIndex) deserialize(data []byte, desired es.Fields) (*es.Details, error) {
+	dict, err := f.dictionary()
+	if err != nil {
+		return nil, err
+	}
+
+	return es.DeserializeDetailsWithDictionary(data, desired, dict)
+}
+
+// encode serializes the given Details using the dictionary of our current data
+// file, first switching to new files if that dictionary is full.
+func (f *flatDB) encode(details *es.Details) ([]byte, error) {
+	if f.dict.Full() {
+		if err := f.switchToNewFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	return details.SerializeWithDictionary(f.dict)
+}
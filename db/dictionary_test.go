@@ -0,0 +1,167 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestDictionaries(t *testing.T) {
+	day := time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)
+
+	newHit := func(i int, user string) *es.Hit {
+		return &es.Hit{ID: strconv.Itoa(i), Details: &es.Details{
+			BOM: "bom", AccountingName: "group", UserName: user, QueueName: "normal",
+			JobName: "job", Timestamp: day.Unix() + int64(i),
+		}}
+	}
+
+	bomQuery := func() *es.Query {
+		query := rangeQuery(day, day.Add(oneDay))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": {"BOM": "bom"}})
+
+		return query
+	}
+
+	Convey("Given a flatDB", t, func() {
+		dbDir := t.TempDir()
+		bomDir := filepath.Join(dbDir, "2024", "02", "04", "bom")
+		config := Config{Directory: dbDir}
+
+		fdb, err := newFlatDB(bomDir, config.FileSizeOrDefault(), config.BufferSizeOrDefault(), config.IndexWidths)
+		So(err, ShouldBeNil)
+
+		Convey("hits are stored with a dictionary per data file", func() {
+			for i, user := range []string{"a", "b", "a"} {
+				So(fdb.Store(newHit(i, user)), ShouldBeNil)
+			}
+
+			So(fdb.Finish(), ShouldBeNil)
+
+			dictPath := filepath.Join(bomDir, "0."+dictKind)
+
+			dict, err := readDictionary(dictPath)
+			So(err, ShouldBeNil)
+			So(dict.Len(), ShouldEqual, 5)
+
+			d, err := New(config, false)
+			So(err, ShouldBeNil)
+
+			result, err := d.Scroll(bomQuery())
+			So(err, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 3)
+			So(result.HitSet.Hits[2].Details.UserName, ShouldEqual, "a")
+			d.Done(result.PoolKey)
+			So(d.Close(), ShouldBeNil)
+
+			Convey("which are checksummed", func() {
+				corruptByte(dictPath, 1)
+
+				_, err = readDictionary(dictPath)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrChecksumMismatch)
+
+				d, err = New(config, false)
+				So(err, ShouldBeNil)
+
+				defer d.Close()
+
+				_, err = d.Scroll(bomQuery())
+				So(err, ShouldNotBeNil)
+
+				problems, err := Fsck(config)
+				So(err, ShouldBeNil)
+				So(problems, ShouldHaveLength, 1)
+				So(problems[0].Path, ShouldEqual, dictPath)
+			})
+		})
+
+		Convey("new files are started when the dictionary is full", func() {
+			for i := 0; fdb.dataFileIndex == 0; i++ {
+				So(fdb.Store(newHit(i, strconv.Itoa(i))), ShouldBeNil)
+			}
+
+			So(fdb.Finish(), ShouldBeNil)
+
+			dict, err := readDictionary(filepath.Join(bomDir, "0."+dictKind))
+			So(err, ShouldBeNil)
+			So(dict.Full(), ShouldBeTrue)
+			So(dict.Len(), ShouldBeLessThanOrEqualTo, es.MaxDictionaryEntries)
+
+			dict, err = readDictionary(filepath.Join(bomDir, "1."+dictKind))
+			So(err, ShouldBeNil)
+			So(dict.Len(), ShouldEqual, 4)
+		})
+
+		Convey("hits stored without a dictionary can still be queried", func() {
+			for i, user := range []string{"a", "b"} {
+				hit := newHit(i, user)
+
+				fields, errf := getFixedWidthFields(hit, fdb.widths)
+				So(errf, ShouldBeNil)
+
+				fields.data, errf = hit.Details.Serialize() //nolint:misspell
+				So(errf, ShouldBeNil)
+
+				So(fdb.storeFields(hit, fields), ShouldBeNil)
+			}
+
+			So(fdb.Finish(), ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(bomDir, "0."+dictKind))
+			So(err, ShouldNotBeNil)
+
+			d, err := New(config, false)
+			So(err, ShouldBeNil)
+
+			result, err := d.Scroll(bomQuery())
+			So(err, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 2)
+			d.Done(result.PoolKey)
+			So(d.Close(), ShouldBeNil)
+
+			Convey("and Migrate() rewrites them with a dictionary", func() {
+				migrated, err := Migrate(config)
+				So(err, ShouldBeNil)
+				So(migrated, ShouldEqual, 1)
+
+				_, err = os.Stat(filepath.Join(bomDir, "0."+dictKind))
+				So(err, ShouldBeNil)
+
+				details := readAllDetails(bomDir, config.BufferSizeOrDefault())
+				So(details, ShouldHaveLength, 2)
+				So(details[1].UserName, ShouldEqual, "b")
+			})
+		})
+	})
+}
@@ -0,0 +1,289 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrDistinctCountsBOM = "distinct value counts can't be found for BOM"
+
+	distinctBOM = "BOM"
+)
+
+// DistinctValues is like Scroll(), but picks out and returns only the unique
+// values of the given field from amongst the Hits. field can be
+// ACCOUNTING_NAME, USER_NAME or es.DistinctGPU, which are answered purely from
+// the index files, or BOM, in which case the query need not specify a BOM
+// and we only read one hit's data per matching BOM. USER_NAMEs of whole days of
+// a BOM are answered from the usernames files written during backfill, where
+// possible.
+func (d *DB) DistinctValues(query *es.Query, field string) ([]string, error) {
+	if err := es.ValidateDistinctField(field); err != nil {
+		return nil, err
+	}
+
+	if field == distinctBOM {
+		return d.boms(query)
+	}
+
+	if len(query.PatternFilters()) > 0 {
+		return d.distinctValuesByScrolling(query, field)
+	}
+
+	filter, err := newFlatFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.checkFilter(filter); err != nil {
+		return nil, err
+	}
+
+	if field == distinctUserName {
+		if names, ok, errf := d.usernamesFromFiles(query, filter); errf != nil || ok {
+			return names, errf
+		}
+	}
+
+	counts, err := d.distinctCountsFromIndexes(query, filter, field)
+
+	return mapKeys(counts), err
+}
+
+// DistinctCounts is like DistinctValues(), but returns how many of the Hits
+// have each value. BOM is not supported.
+func (d *DB) DistinctCounts(query *es.Query, field string) (map[string]int, error) {
+	if err := validateDistinctCountsField(field); err != nil {
+		return nil, err
+	}
+
+	if len(query.PatternFilters()) > 0 {
+		return d.distinctCountsByScrolling(query, field)
+	}
+
+	filter, err := newFlatFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.checkFilter(filter); err != nil {
+		return nil, err
+	}
+
+	return d.distinctCountsFromIndexes(query, filter, field)
+}
+
+// validateDistinctCountsField returns an error if DistinctCounts() can't be
+// found for the given field.
+func validateDistinctCountsField(field string) error {
+	if err := es.ValidateDistinctField(field); err != nil {
+		return err
+	}
+
+	if field == distinctBOM {
+		return Error{Msg: ErrDistinctCountsBOM}
+	}
+
+	return nil
+}
+
+// distinctCountsFromIndexes counts the values of the given field amongst the
+// index entries that pass the filter.
+func (d *DB) distinctCountsFromIndexes(query *es.Query, filter *flatFilter, field string) (map[string]int, error) {
+	var mu sync.Mutex
+
+	counts := make(map[string]int)
+
+	d.operateOnRequestedDays(filter, func(fi *flatIndex) {
+		theseCounts := fi.DistinctCounts(filter, field)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for val, n := range theseCounts {
+			counts[val] += n
+		}
+	})
+
+	query.SetScanned(filter.scanned.Load())
+
+	if err := filter.contextErr(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// distinctValuesByScrolling is like DistinctValues(), but finds the values
+// from the hit data of all matching hits, for queries with filters that can't
+// be checked using the index files alone.
+func (d *DB) distinctValuesByScrolling(query *es.Query, field string) ([]string, error) {
+	counts, err := d.distinctCountsByScrolling(query, field)
+
+	return mapKeys(counts), err
+}
+
+// distinctCountsByScrolling is like distinctValuesByScrolling(), but for
+// DistinctCounts().
+func (d *DB) distinctCountsByScrolling(query *es.Query, field string) (map[string]int, error) {
+	allFields := query.WithContext(query.Context())
+	allFields.Source = nil
+
+	result, err := d.Scroll(allFields)
+	if err != nil {
+		return nil, err
+	}
+
+	defer d.Done(result.PoolKey)
+
+	query.SetScanned(allFields.Scanned())
+
+	return countDistinctValues(result.HitSet.Hits, field)
+}
+
+// countDistinctValues returns how many of the given hits have each value of
+// the given field.
+func countDistinctValues(hits []es.Hit, field string) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	for _, hit := range hits {
+		val, err := hit.Details.DistinctValue(field)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[val]++
+	}
+
+	return counts, nil
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// boms returns the unique BOMs of hits that pass the query's filters in any
+// BOM. Queries with wildcard or regexp filters aren't supported.
+func (d *DB) boms(query *es.Query) ([]string, error) {
+	filter, err := newFlatFilterForAnyBOM(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(filter.patterns) > 0 {
+		return nil, Error{Msg: ErrPatternDistinctBOMs}
+	}
+
+	if err = d.checkFilter(filter); err != nil {
+		return nil, err
+	}
+
+	bomsMap := make(map[string]bool)
+	buf := make([]byte, es.MaxEncodedDetailsLength)
+
+	d.forEachRequestedDayBOMDir(filter, func(dayBOMDir string) {
+		if err != nil {
+			return
+		}
+
+		for _, indexes := range d.flatIndexesOfBOMDirs(filter, dayBOMDir) {
+			var bom string
+
+			bom, err = d.bomOfFirstPassingEntry(indexes, filter, buf)
+			if bom != "" {
+				bomsMap[bom] = true
+			}
+		}
+	})
+
+	if err == nil {
+		err = filter.contextErr()
+	}
+
+	return mapKeys(bomsMap), err
+}
+
+// flatIndexesOfBOMDirs returns the flatIndexes of the given date/BOM folder if
+// the filter specifies a BOM. Otherwise the given folder is just a date folder
+// and we return the flatIndexes of each of its BOM subdirectories in the
+// filter's cluster.
+func (d *DB) flatIndexesOfBOMDirs(filter *flatFilter, dayBOMDir string) [][]*flatIndex {
+	if filter.BOM != "" {
+		return [][]*flatIndex{d.flatIndexesInDir(dayBOMDir)}
+	}
+
+	var indexes [][]*flatIndex
+
+	for _, dir := range d.dateBOMDirsWithPrefix(dayBOMDir + string(filepath.Separator)) {
+		if !inCluster(filepath.Base(dir), filter.cluster) {
+			continue
+		}
+
+		if fis := d.flatIndexesInDir(dir); len(fis) > 0 {
+			indexes = append(indexes, fis)
+		}
+	}
+
+	return indexes
+}
+
+// bomOfFirstPassingEntry reads the data of the first entry amongst the given
+// flatIndexes that passes the filter, and returns its BOM. Returns blank if
+// there were no passing entries.
+func (d *DB) bomOfFirstPassingEntry(indexes []*flatIndex, filter *flatFilter, buf []byte) (string, error) {
+	for _, fi := range indexes {
+		entry := fi.firstPassingEntry(filter)
+		if entry == nil {
+			continue
+		}
+
+		data := buf[:entry.length]
+
+		if err := d.readDataEntry(fi, data, entry); err != nil {
+			return "", err
+		}
+
+		details, err := fi.deserialize(data, es.FieldBOM)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.Clone(details.BOM), nil
+	}
+
+	return "", nil
+}
@@ -0,0 +1,118 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+const ErrDraining = "database is shutting down"
+
+// scanTracker counts running scans, so that Drain() can wait for them to
+// finish. The zero value is ready to use.
+type scanTracker struct {
+	mu       sync.Mutex
+	running  int
+	draining bool
+	idle     chan struct{}
+}
+
+// begin records the start of a scan, returning false if we're draining, in
+// which case the scan must not start. Otherwise you must call end() once the
+// scan has finished.
+func (t *scanTracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+
+	t.running++
+
+	return true
+}
+
+// end records the end of a scan you begin()'d.
+func (t *scanTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.running--
+
+	if t.draining && t.running == 0 {
+		close(t.idle)
+	}
+}
+
+// drain stops begin() succeeding, and returns a channel that is closed once no
+// scans are running.
+func (t *scanTracker) drain() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return t.idle
+	}
+
+	t.draining = true
+	t.idle = make(chan struct{})
+
+	if t.running == 0 {
+		close(t.idle)
+	}
+
+	return t.idle
+}
+
+// Drain stops new Scroll()s and MultiScroll()s starting (they return an
+// ErrDraining Error), and waits until running ones have finished, or the
+// context is done. It then releases the buffers of any Results that haven't
+// been Done(), and closes data files that aren't being read.
+//
+// Returns the context's error if scans were still running, in which case
+// buffers are not released.
+//
+// Call this before Close() when shutting down.
+func (d *DB) Drain(ctx context.Context) error {
+	select {
+	case <-d.scans.drain():
+	case <-ctx.Done():
+		d.openFiles.closeAll()
+
+		return ctx.Err()
+	}
+
+	if released := d.bufPool.releaseAll() + d.heldFiles.releaseAll(d.openFiles); released > 0 {
+		slog.Info("released buffers of unfinished results", "count", released)
+	}
+
+	d.openFiles.closeAll()
+
+	return nil
+}
@@ -0,0 +1,113 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestDrain(t *testing.T) {
+	Convey("Given a DB with some hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		hitCh := make(chan *es.Hit, 2)
+		for _, id := range []string{"a", "b"} {
+			hitCh <- &es.Hit{ID: id, Details: &es.Details{
+				BOM: "bom", AccountingName: "group", UserName: "user", Timestamp: 1717113600,
+			}}
+		}
+
+		close(hitCh)
+
+		b, err := New(config, false)
+		So(err, ShouldBeNil)
+		So(b.Store(hitCh), ShouldBeNil)
+		So(b.Close(), ShouldBeNil)
+
+		d, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		defer d.Close()
+
+		day := time.Unix(1717113600, 0).UTC()
+		query := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+			rangeFilter("lte", day, day.Add(time.Hour)),
+			{"match_phrase": {"BOM": "bom"}},
+		}}}}
+
+		result, err := d.Scroll(query)
+		So(err, ShouldBeNil)
+		So(result.HitSet.Hits, ShouldHaveLength, 2)
+		So(d.bufPool.keyToIndex, ShouldHaveLength, 1)
+
+		Convey("Drain waits for running scans, stops new ones, then releases buffers", func() {
+			So(d.scans.begin(), ShouldBeTrue)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			err = d.Drain(ctx)
+			So(err, ShouldEqual, context.DeadlineExceeded)
+			So(d.bufPool.keyToIndex, ShouldHaveLength, 1)
+
+			_, err = d.Scroll(query)
+			So(err, ShouldNotBeNil)
+
+			var dbErr Error
+			So(errors.As(err, &dbErr), ShouldBeTrue)
+			So(dbErr.Msg, ShouldEqual, ErrDraining)
+
+			_, err = d.MultiScroll([]*es.Query{query})
+			So(err, ShouldNotBeNil)
+
+			go func() {
+				<-time.After(10 * time.Millisecond)
+				d.scans.end()
+			}()
+
+			err = d.Drain(context.Background())
+			So(err, ShouldBeNil)
+			So(d.bufPool.keyToIndex, ShouldBeEmpty)
+			So(d.Done(result.PoolKey), ShouldBeFalse)
+			So(d.openFiles.files, ShouldBeEmpty)
+		})
+
+		Convey("Drain returns right away with nothing running", func() {
+			So(d.Done(result.PoolKey), ShouldBeTrue)
+			So(d.Drain(context.Background()), ShouldBeNil)
+		})
+	})
+}
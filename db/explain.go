@@ -0,0 +1,173 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"path/filepath"
+	"strings"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// QueryPlan describes the work Scroll() would do to answer a query.
+type QueryPlan struct {
+	Cluster string `json:"cluster,omitempty"`
+	BOM     string `json:"bom"`
+
+	// Dirs are the day/BOM directories in the query's date range that have
+	// index files; days with no data for the BOM are not included.
+	Dirs []DirPlan `json:"dirs"`
+
+	IndexFiles     int   `json:"index_files"`
+	EntriesScanned int64 `json:"entries_scanned"`
+	Hits           int   `json:"hits"`
+
+	// EstimatedBytes is how much hit data would be read.
+	EstimatedBytes int64 `json:"estimated_bytes"`
+
+	// IndexOnly is true if the query only filters on things we index, so that
+	// its hits can be counted (eg. by Count()) without reading any hit data.
+	// Otherwise Hits and EstimatedBytes are upper bounds, since further
+	// filtering happens after reading the data.
+	IndexOnly bool `json:"index_only"`
+
+	// Rollups is true if the query is an aggregation that Aggregate() could
+	// answer from our per-day rollups, for days that have them, instead of
+	// reading hits.
+	Rollups bool `json:"rollups"`
+
+	// ExceedsLimits is true if Scroll() would return an ErrQueryTooLarge Error
+	// due to the configured MaxHits or MaxBytes.
+	ExceedsLimits bool `json:"exceeds_limits"`
+}
+
+// DirPlan describes the part of a QueryPlan in one day/BOM directory.
+type DirPlan struct {
+	// Dir is the directory's path relative to the database directory.
+	Dir string `json:"dir"`
+
+	// Loaded is false if the directory's index files had to be loaded for the
+	// plan, because we're configured to LazyLoadDirs and it wasn't in memory.
+	Loaded bool `json:"loaded"`
+
+	IndexFiles []string `json:"index_files"`
+	Entries    int      `json:"entries"`
+	Hits       int      `json:"hits"`
+	Bytes      int64    `json:"bytes"`
+}
+
+// Explain returns the QueryPlan of the given query, saying which directories
+// and index files Scroll() would look at, how many index entries would match,
+// and how much hit data would be read, without reading any. Returns the same
+// errors as Scroll() would for invalid queries.
+func (d *DB) Explain(query *es.Query) (*QueryPlan, error) {
+	filter, err := newFlatFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.checkFilter(filter); err != nil {
+		return nil, err
+	}
+
+	_, rollups := newRollupQuery(query)
+
+	plan := &QueryPlan{
+		Cluster:   filter.cluster,
+		BOM:       filter.BOM,
+		Dirs:      []DirPlan{},
+		IndexOnly: !hasNonIndexFilters(query),
+		Rollups:   rollups,
+	}
+
+	d.forEachRequestedDayBOMDir(filter, func(dayBOMDir string) {
+		if dp, ok := d.explainDir(dayBOMDir, filter); ok {
+			plan.add(dp)
+		}
+	})
+
+	if err = filter.contextErr(); err != nil {
+		return nil, err
+	}
+
+	plan.EntriesScanned = filter.scanned.Load()
+	plan.ExceedsLimits = d.checkQuerySize(plan.Hits, int(plan.EstimatedBytes)) != nil
+
+	return plan, nil
+}
+
+// explainDir returns the DirPlan of the given day/BOM directory, or false if it
+// has no index files.
+func (d *DB) explainDir(dayBOMDir string, filter *flatFilter) (DirPlan, bool) {
+	loaded := d.lazyLoaded == nil || d.lazyLoaded.Contains(dayBOMDir)
+
+	fis := d.flatIndexesInDir(dayBOMDir)
+	if len(fis) == 0 {
+		return DirPlan{}, false
+	}
+
+	dp := DirPlan{
+		Dir:        d.relativePath(dayBOMDir),
+		Loaded:     loaded,
+		IndexFiles: make([]string, len(fis)),
+	}
+
+	for i, fi := range fis {
+		dp.IndexFiles[i] = d.relativePath(indexPathOfData(fi.dataPath))
+		dp.Entries += len(fi.bomEntries)
+
+		for _, entry := range fi.IndexSearch(filter) {
+			dp.Hits++
+			dp.Bytes += int64(entry.length)
+		}
+	}
+
+	return dp, true
+}
+
+// relativePath returns the given path relative to our database directory, or
+// as is if it isn't in it (eg. because it's in one of our extraDirs).
+func (d *DB) relativePath(path string) string {
+	rel, err := filepath.Rel(d.dir, path)
+	if err != nil || !isWithin(d.dir, path) {
+		return path
+	}
+
+	return rel
+}
+
+// indexPathOfData is the inverse of dataPathOfIndex().
+func indexPathOfData(path string) string {
+	return strings.TrimSuffix(path, dataKind) + indexKind
+}
+
+// add adds the given DirPlan to our Dirs and totals.
+func (p *QueryPlan) add(dp DirPlan) {
+	p.Dirs = append(p.Dirs, dp)
+	p.IndexFiles += len(dp.IndexFiles)
+	p.Hits += dp.Hits
+	p.EstimatedBytes += dp.Bytes
+}
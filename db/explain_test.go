@@ -0,0 +1,163 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestExplain(t *testing.T) {
+	Convey("Given a database with stored hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		first, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		last := first.Add(oneDay).Add(10 * time.Second)
+		result := makeResult(first, last)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for _, hit := range result.HitSet.Hits {
+			hitCh <- &hit
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		query := &es.Query{
+			Size: es.MaxSize,
+			Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				{"match_phrase": {"META_CLUSTER_NAME": "farm"}},
+				rangeFilter("lte", first, last),
+				{"match_phrase": {"BOM": "bomB"}},
+			}}},
+		}
+
+		Convey("You can Explain a query without reading hit data", func() {
+			db, err = New(config, false)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			plan, errp := db.Explain(query)
+			So(errp, ShouldBeNil)
+
+			So(plan.BOM, ShouldEqual, "bomB")
+			So(plan.Dirs, ShouldHaveLength, 2)
+			So(plan.Dirs[0].Dir, ShouldEqual, filepath.Join("2024", "02", "04", "bomB"))
+			So(plan.Dirs[1].Dir, ShouldEqual, filepath.Join("2024", "02", "05", "bomB"))
+			So(plan.Dirs[0].Loaded, ShouldBeTrue)
+			So(plan.Dirs[0].IndexFiles, ShouldNotBeEmpty)
+			So(plan.Dirs[0].IndexFiles[0], ShouldStartWith, plan.Dirs[0].Dir+string(filepath.Separator))
+			So(plan.Dirs[0].IndexFiles[0], ShouldEndWith, indexKind)
+			So(plan.IndexFiles, ShouldEqual, len(plan.Dirs[0].IndexFiles)+len(plan.Dirs[1].IndexFiles))
+			So(plan.Dirs[0].Hits, ShouldEqual, plan.Dirs[0].Entries)
+			So(plan.IndexOnly, ShouldBeTrue)
+			So(plan.Rollups, ShouldBeFalse)
+			So(plan.ExceedsLimits, ShouldBeFalse)
+			So(plan.EstimatedBytes, ShouldBeGreaterThan, 0)
+			So(plan.EntriesScanned, ShouldBeGreaterThanOrEqualTo, plan.Hits)
+
+			count, errc := db.Count(query)
+			So(errc, ShouldBeNil)
+			So(plan.Hits, ShouldEqual, count)
+
+			scrolled, errs := db.Scroll(query)
+			So(errs, ShouldBeNil)
+			So(plan.Hits, ShouldEqual, scrolled.HitSet.Total.Value)
+			db.Done(scrolled.PoolKey)
+
+			Convey("which says if it would be too large", func() {
+				db.queryLimits.maxHits = plan.Hits - 1
+
+				plan, errp = db.Explain(query)
+				So(errp, ShouldBeNil)
+				So(plan.ExceedsLimits, ShouldBeTrue)
+			})
+
+			Convey("which says if it can't be answered from the index alone", func() {
+				query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+					map[string]es.MapStringStringOrMap{"match_phrase": {"JOB_NAME": "jobA"}})
+
+				plan, errp = db.Explain(query)
+				So(errp, ShouldBeNil)
+				So(plan.IndexOnly, ShouldBeFalse)
+			})
+
+			Convey("which says if whole day aggregations could use rollups", func() {
+				plan, errp = db.Explain(reportAggQuery(first, first.Add(oneDay), "bomB"))
+				So(errp, ShouldBeNil)
+				So(plan.Rollups, ShouldBeTrue)
+			})
+
+			Convey("which fails like Scroll() for queries without a BOM", func() {
+				query.Query.Bool.Filter = query.Query.Bool.Filter[:2]
+
+				_, errp = db.Explain(query)
+				So(errp, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Explain says which dirs had to be lazy loaded", func() {
+			config.LazyLoadDirs = 2
+
+			db, err = New(config, false)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			plan, errp := db.Explain(query)
+			So(errp, ShouldBeNil)
+			So(plan.Dirs, ShouldHaveLength, 2)
+			So(plan.Dirs[0].Loaded, ShouldBeFalse)
+			So(plan.Dirs[1].Loaded, ShouldBeFalse)
+
+			plan, errp = db.Explain(query)
+			So(errp, ShouldBeNil)
+			So(plan.Dirs[0].Loaded, ShouldBeTrue)
+			So(plan.Dirs[1].Loaded, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,411 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const defaultMaxOpenFiles = 256
+
+// openFile is a data file held open by an openFiles cache.
+type openFile struct {
+	*os.File
+	path    string
+	refs    int
+	element *list.Element
+	verify  bool
+
+	// mapped is the memory-mapped contents of the file, if our openFiles
+	// were configured to mmap and the mapping succeeded, or its decompressed
+	// contents if it only exists compressed, in which case File is nil.
+	mapped       []byte
+	decompressed bool
+}
+
+// readEntry reads the given entry's data in to buf, which must be the entry's
+// length. If we're verifying, returns an ErrChecksumMismatch Error if the data
+// doesn't match the entry's checksum.
+func (o *openFile) readEntry(buf []byte, entry *flatIndexEntry) error {
+	if o.mapped != nil {
+		data, err := o.mappedEntry(entry)
+		if err == nil {
+			copy(buf, data)
+		}
+
+		return err
+	}
+
+	n, err := o.ReadAt(buf, entry.index)
+	if err != nil && n == entry.length {
+		err = nil
+	}
+
+	if err == nil && o.verify {
+		err = verifyEntryChecksum(o.path, entry, buf)
+	}
+
+	return err
+}
+
+// mappedEntry returns the given entry's data directly from our mapped memory,
+// without copying it. The returned slice is only valid until we're closed.
+// Only call this if we're mapped.
+func (o *openFile) mappedEntry(entry *flatIndexEntry) ([]byte, error) {
+	end := entry.index + int64(entry.length)
+	if end > int64(len(o.mapped)) {
+		return nil, fmt.Errorf("%s: entry at %d: %w", o.path, entry.index, io.ErrUnexpectedEOF)
+	}
+
+	data := o.mapped[entry.index:end:end]
+
+	if o.verify {
+		if err := verifyEntryChecksum(o.path, entry, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// close unmaps our memory, if any, and closes our file.
+func (o *openFile) close() error {
+	if o.decompressed {
+		o.mapped = nil
+
+		return nil
+	}
+
+	if o.mapped != nil {
+		munmap(o.mapped) //nolint:errcheck
+		o.mapped = nil
+	}
+
+	return o.Close()
+}
+
+// openFiles is a size-limited cache of open data files, shared by all queries,
+// so that frequently queried files stay open while the least recently used
+// ones get closed.
+type openFiles struct {
+	mu    sync.Mutex
+	max   int
+	files map[string]*openFile
+	lru   *list.List
+	fetch func(path string) error
+
+	// verify is whether the files we open verify the checksums of the entries
+	// read from them.
+	verify bool
+
+	// mmap is whether the files we open are memory-mapped, so that their
+	// entries are read from the OS page cache instead of with read calls.
+	mmap bool
+}
+
+// newOpenFiles returns an openFiles that will try to keep no more than maxOpen
+// (default 256) files open. Files that are in use are never closed, so more
+// than that can be open while queries are running.
+//
+// If fetch is not nil, it will be called to create files that don't exist
+// when they are first acquired. It may be called concurrently for the same
+// path.
+func newOpenFiles(maxOpen int, fetch func(path string) error) *openFiles {
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenFiles
+	}
+
+	return &openFiles{
+		max:   maxOpen,
+		files: make(map[string]*openFile),
+		lru:   list.New(),
+		fetch: fetch,
+	}
+}
+
+// acquire returns the open file at the given path, opening it if necessary.
+// If the file doesn't exist but a compressed copy of it does (see
+// compressedPath()), its decompressed contents are read in to memory instead.
+// Otherwise, if we were given a fetch function, that is used to create it
+// first. You must release() it when you're done reading from it.
+func (o *openFiles) acquire(path string) (*openFile, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if of := o.reuse(path); of != nil {
+		return of, nil
+	}
+
+	fh, err := o.open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		data, errd := readCompressedFile(compressedPath(path))
+		if errd == nil {
+			return o.add(&openFile{path: path, refs: 1, verify: o.verify, mapped: data, decompressed: true}), nil
+		}
+
+		if !errors.Is(errd, fs.ErrNotExist) {
+			return nil, errd
+		}
+	}
+
+	if errors.Is(err, fs.ErrNotExist) && o.fetch != nil {
+		o.mu.Unlock()
+		err = o.fetch(path)
+		o.mu.Lock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if of := o.reuse(path); of != nil {
+			return of, nil
+		}
+
+		fh, err = o.open(path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	of := &openFile{File: fh, path: path, refs: 1, verify: o.verify}
+
+	if o.mmap {
+		of.mapped = o.mmapOrWarn(fh, path)
+	}
+
+	return o.add(of), nil
+}
+
+// add starts keeping the given newly opened file, closing unused ones if we
+// now have too many.
+func (o *openFiles) add(of *openFile) *openFile {
+	of.element = o.lru.PushFront(of)
+	o.files[of.path] = of
+
+	o.closeUnused(o.max)
+
+	return of
+}
+
+// reuse returns our already open file at the given path, if any, noting that
+// it is being used again.
+func (o *openFiles) reuse(path string) *openFile {
+	of, ok := o.files[path]
+	if !ok {
+		return nil
+	}
+
+	of.refs++
+	o.lru.MoveToFront(of.element)
+
+	return of
+}
+
+// open opens the given path, closing all our unused files and trying again if
+// we've run out of file descriptors.
+func (o *openFiles) open(path string) (*os.File, error) {
+	fh, err := os.Open(path)
+	if errors.Is(err, syscall.EMFILE) {
+		o.closeUnused(0)
+
+		fh, err = os.Open(path)
+	}
+
+	return fh, err
+}
+
+// mmapOrWarn returns the mapped contents of the given file, or nil if mapping
+// fails, in which case the file will be read normally.
+func (o *openFiles) mmapOrWarn(fh *os.File, path string) []byte {
+	mapped, err := mmapFile(fh)
+	if err != nil {
+		slog.Warn("could not mmap data file; reading it normally instead", "path", path, "err", err)
+	}
+
+	return mapped
+}
+
+// closeUnused closes the least recently used files that aren't currently
+// acquired, until no more than keep files are open.
+func (o *openFiles) closeUnused(keep int) {
+	for e := o.lru.Back(); e != nil && len(o.files) > keep; {
+		of := e.Value.(*openFile) //nolint:errcheck,forcetypeassert
+		e = e.Prev()
+
+		if of.refs == 0 {
+			o.remove(of)
+		}
+	}
+}
+
+func (o *openFiles) remove(of *openFile) {
+	o.lru.Remove(of.element)
+	delete(o.files, of.path)
+	of.close()
+}
+
+// release says you're done with a file you acquire()d. It will be kept open
+// for future use unless we have too many files open.
+func (o *openFiles) release(of *openFile) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	of.refs--
+
+	if of.refs > 0 {
+		return
+	}
+
+	if o.files[of.path] != of {
+		of.close()
+
+		return
+	}
+
+	o.closeUnused(o.max)
+}
+
+// forget stops using any open file at the given path, eg. because it has been
+// replaced. It will be closed once it is no longer acquired.
+func (o *openFiles) forget(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	of, ok := o.files[path]
+	if !ok {
+		return
+	}
+
+	o.lru.Remove(of.element)
+	delete(o.files, path)
+
+	if of.refs == 0 {
+		of.close()
+	}
+}
+
+// closeAll closes all files that aren't currently acquired, and stops any
+// others being kept open once they are released.
+func (o *openFiles) closeAll() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.max = 0
+	o.closeUnused(0)
+}
+
+// readDataEntry reads the given entry's data from the flatIndex's data file in
+// to buf, which must be the entry's length.
+func (d *DB) readDataEntry(fi *flatIndex, buf []byte, entry *flatIndexEntry) error {
+	of, err := d.openFiles.acquire(fi.dataPath)
+	if err != nil {
+		return err
+	}
+
+	defer d.openFiles.release(of)
+
+	return of.readEntry(buf, entry)
+}
+
+// entryData returns the data of the given entry from the given file. It is read
+// in to the entry's part of buf, unless buf is nil, in which case it is
+// returned directly from the file's mapped memory, or else a new slice.
+func entryData(of *openFile, buf []byte, lde localDataEntry) ([]byte, error) {
+	if buf == nil {
+		if of.mapped != nil {
+			return of.mappedEntry(lde.entry)
+		}
+
+		buf = make([]byte, lde.entry.length)
+	} else {
+		buf = buf[lde.start : lde.start+lde.entry.length]
+	}
+
+	return buf, of.readEntry(buf, lde.entry)
+}
+
+// heldFiles are mapped files acquire()d by Scroll()s whose Results' Details
+// are backed by the mapped memory, keyed on the Results' PoolKeys, so that they
+// stay open and mapped until the Results are Done().
+type heldFiles struct {
+	mu    sync.Mutex
+	files map[int][]*openFile
+}
+
+// hold notes that the given acquired file must be kept until the given key is
+// released.
+func (h *heldFiles) hold(key int, of *openFile) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.files == nil {
+		h.files = make(map[int][]*openFile)
+	}
+
+	h.files[key] = append(h.files[key], of)
+}
+
+// release releases all the files held for the given key back to the given
+// openFiles. Returns true if any were held.
+func (h *heldFiles) release(o *openFiles, key int) bool {
+	h.mu.Lock()
+	files, ok := h.files[key]
+	delete(h.files, key)
+	h.mu.Unlock()
+
+	for _, of := range files {
+		o.release(of)
+	}
+
+	return ok
+}
+
+// releaseAll is like calling release() on every held key, returning how many
+// there were.
+func (h *heldFiles) releaseAll(o *openFiles) int {
+	h.mu.Lock()
+	keys := make([]int, 0, len(h.files))
+
+	for key := range h.files {
+		keys = append(keys, key)
+	}
+	h.mu.Unlock()
+
+	for _, key := range keys {
+		h.release(o, key)
+	}
+
+	return len(keys)
+}
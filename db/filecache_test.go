@@ -0,0 +1,247 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestOpenFiles(t *testing.T) {
+	Convey("Given some data files", t, func() {
+		dir := t.TempDir()
+		paths := make([]string, 4)
+
+		for i := range paths {
+			paths[i] = filepath.Join(dir, strconv.Itoa(i)+"."+dataKind)
+			err := os.WriteFile(paths[i], []byte("data"+strconv.Itoa(i)), 0600)
+			So(err, ShouldBeNil)
+		}
+
+		entry := &flatIndexEntry{index: 4, length: 1}
+		buf := make([]byte, 1)
+
+		Convey("You can acquire and read them, and they stay open after release", func() {
+			files := newOpenFiles(2, nil)
+
+			of, err := files.acquire(paths[1])
+			So(err, ShouldBeNil)
+
+			err = of.readEntry(buf, entry)
+			So(err, ShouldBeNil)
+			So(string(buf), ShouldEqual, "1")
+
+			files.release(of)
+			So(len(files.files), ShouldEqual, 1)
+
+			again, err := files.acquire(paths[1])
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, of)
+			files.release(again)
+
+			Convey("The least recently used are closed when there are too many", func() {
+				for _, path := range []string{paths[0], paths[2], paths[1], paths[3]} {
+					of, err = files.acquire(path)
+					So(err, ShouldBeNil)
+					files.release(of)
+				}
+
+				So(len(files.files), ShouldEqual, 2)
+				So(files.files[paths[1]], ShouldNotBeNil)
+				So(files.files[paths[3]], ShouldNotBeNil)
+			})
+
+			Convey("Files in use are not closed", func() {
+				held := make([]*openFile, len(paths))
+
+				for i, path := range paths {
+					held[i], err = files.acquire(path)
+					So(err, ShouldBeNil)
+				}
+
+				So(len(files.files), ShouldEqual, 4)
+
+				err = held[0].readEntry(buf, entry)
+				So(err, ShouldBeNil)
+				So(string(buf), ShouldEqual, "0")
+
+				for _, of := range held {
+					files.release(of)
+				}
+
+				So(len(files.files), ShouldEqual, 2)
+			})
+
+			Convey("Forgotten files are reopened, and closed once released", func() {
+				of, err = files.acquire(paths[1])
+				So(err, ShouldBeNil)
+
+				files.forget(paths[1])
+				So(len(files.files), ShouldEqual, 0)
+
+				err = of.readEntry(buf, entry)
+				So(err, ShouldBeNil)
+
+				files.release(of)
+				err = of.readEntry(buf, entry)
+				So(err, ShouldNotBeNil)
+
+				again, err = files.acquire(paths[1])
+				So(err, ShouldBeNil)
+				So(again, ShouldNotEqual, of)
+				files.release(again)
+			})
+
+			Convey("closeAll closes unused files, and in-use ones on release", func() {
+				of, err = files.acquire(paths[0])
+				So(err, ShouldBeNil)
+
+				files.closeAll()
+				So(len(files.files), ShouldEqual, 1)
+
+				files.release(of)
+				So(len(files.files), ShouldEqual, 0)
+
+				err = of.readEntry(buf, entry)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("You can memory-map them, and held files stay mapped until released", func() {
+			files := newOpenFiles(1, nil)
+			files.mmap = true
+
+			of, err := files.acquire(paths[1])
+			So(err, ShouldBeNil)
+			So(string(of.mapped), ShouldEqual, "data1")
+
+			err = of.readEntry(buf, entry)
+			So(err, ShouldBeNil)
+			So(string(buf), ShouldEqual, "1")
+
+			data, err := of.mappedEntry(entry)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "1")
+
+			_, err = of.mappedEntry(&flatIndexEntry{index: 4, length: 2})
+			So(err, ShouldNotBeNil)
+
+			var held heldFiles
+
+			held.hold(1, of)
+
+			other, err := files.acquire(paths[2])
+			So(err, ShouldBeNil)
+			files.release(other)
+			So(len(files.files), ShouldEqual, 1)
+			So(string(of.mapped), ShouldEqual, "data1")
+
+			So(held.release(files, 2), ShouldBeFalse)
+			So(held.release(files, 1), ShouldBeTrue)
+			So(held.release(files, 1), ShouldBeFalse)
+			So(of.refs, ShouldEqual, 0)
+
+			files.closeAll()
+			So(of.mapped, ShouldBeNil)
+		})
+	})
+}
+
+func TestMMapScroll(t *testing.T) {
+	Convey("Given a database configured to mmap", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+			MMap:       true,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for i := range 3 {
+			hitCh <- &es.Hit{ID: strconv.Itoa(i), Details: &es.Details{
+				Timestamp: gte.Add(time.Duration(i) * time.Minute).Unix(),
+				BOM:       "bomA",
+				UserName:  "user" + strconv.Itoa(i),
+			}}
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+			rangeFilter("lt", gte, gte.Add(oneDay)),
+			{"match_phrase": {"BOM": "bomA"}},
+		}}}}
+
+		Convey("Scroll results are read from the mapped files, which are held until Done", func() {
+			result, err := db.Scroll(query)
+			So(err, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, 3)
+			So(result.HitSet.Hits[2].Details.UserName, ShouldEqual, "user2")
+			So(result.HitSet.Hits[2].ID, ShouldEqual, "2")
+			So(len(db.heldFiles.files[result.PoolKey]), ShouldEqual, 1)
+
+			So(db.Done(result.PoolKey), ShouldBeTrue)
+			So(db.heldFiles.files, ShouldBeEmpty)
+			So(db.Done(result.PoolKey), ShouldBeFalse)
+		})
+
+		Convey("MultiScroll results are copied from the mapped files", func() {
+			results, err := db.MultiScroll([]*es.Query{query})
+			So(err, ShouldBeNil)
+			So(len(results[0].HitSet.Hits), ShouldEqual, 3)
+			So(results[0].HitSet.Hits[0].Details.UserName, ShouldEqual, "user0")
+			So(db.heldFiles.files, ShouldBeEmpty)
+			So(db.Done(results[0].PoolKey), ShouldBeTrue)
+		})
+	})
+}
@@ -27,7 +27,9 @@ package db
 
 import (
 	"bytes"
-	"strings"
+	"context"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
@@ -37,48 +39,113 @@ const ErrNoBOM = "query does not specify a BOM"
 
 type flatFilter struct {
 	BOM             string
+	cluster         string
 	LT              time.Time
 	LTE             time.Time
+	GT              time.Time
 	GTE             time.Time
 	LTKey           []byte
 	LTEKey          []byte
+	GTKey           []byte
 	GTEKey          []byte
 	accountingName  string
 	userName        string
+	queueKey        []byte
+	jobPrefixKey    []byte
 	checkAccounting bool
 	checkUser       bool
-	checkGPU        bool
+	checkQueue      bool
+	queueIsPrefix   bool
+	checkJobPrefix  bool
 	checkLTE        bool
+	checkGT         bool
 	desiredFields   es.Fields
+	patterns        []patternMatcher
+	ctx             context.Context
+	scanned         atomic.Int64
+	query           *es.Query
 }
 
 func newFlatFilter(query *es.Query) (*flatFilter, error) {
-	lt, lte, gte, err := query.DateRange()
+	filter, err := newFlatFilterForAnyBOM(query)
 	if err != nil {
 		return nil, err
 	}
 
-	filter := &flatFilter{
-		LT:            lt,
-		LTE:           lte,
-		GTE:           gte,
-		checkLTE:      !lte.IsZero(),
-		desiredFields: query.DesiredFields(),
+	if filter.BOM == "" {
+		return nil, Error{Msg: ErrNoBOM}
 	}
 
-	filter.LTKey, filter.LTEKey, filter.GTEKey = i64tob(lt.Unix()), i64tob(lte.Unix()), i64tob(gte.Unix())
-	filter.BOM, filter.accountingName, filter.userName, filter.checkGPU = queryToFilters(query)
+	return filter, nil
+}
 
-	if filter.BOM == "" {
-		return nil, Error{Msg: ErrNoBOM}
+// newFlatFilterForAnyBOM is like newFlatFilter, but doesn't require the query
+// to specify a BOM.
+func newFlatFilterForAnyBOM(query *es.Query) (*flatFilter, error) {
+	tr, err := query.TimeRange()
+	if err != nil {
+		return nil, err
+	}
+
+	patterns, err := newPatternMatchers(query)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &flatFilter{
+		LT:            tr.LT,
+		LTE:           tr.LTE,
+		GT:            tr.GT,
+		GTE:           tr.GTE,
+		checkLTE:      !tr.LTE.IsZero(),
+		checkGT:       !tr.GT.IsZero(),
+		desiredFields: patternDesiredFields(query.DesiredFields(), patterns),
+		patterns:      patterns,
+		ctx:           query.Context(),
+		query:         query,
 	}
 
+	filter.LTKey, filter.LTEKey = i64tob(tr.LT.Unix()), i64tob(tr.LTE.Unix())
+	filter.GTKey, filter.GTEKey = i64tob(tr.GT.Unix()), i64tob(tr.GTE.Unix())
+	filter.BOM, filter.accountingName, filter.userName = queryToFilters(query)
+	filter.cluster = queryCluster(query)
+	filter.setQueueFilter(query)
+	filter.setJobPrefixFilter(query)
 	filter.checkAccounting = len(filter.accountingName) > 0
 	filter.checkUser = len(filter.userName) > 0
 
 	return filter, nil
 }
 
+// bomDir returns the name of the day subdirectory of our cluster and BOM, or
+// blank if we don't have a BOM.
+func (f *flatFilter) bomDir() string {
+	if f.BOM == "" {
+		return ""
+	}
+
+	return clusterBOMDir(f.cluster, f.BOM)
+}
+
+// contextErr returns the error of our query's context, which will be non-nil
+// if the query has been cancelled or timed out.
+func (f *flatFilter) contextErr() error {
+	if f.ctx == nil {
+		return nil
+	}
+
+	return f.ctx.Err()
+}
+
+// start returns our GT value if we have one, otherwise our GTE value.
+func (f *flatFilter) start() time.Time {
+	if f.checkGT {
+		return f.GT
+	}
+
+	return f.GTE
+}
+
 func (f *flatFilter) beyondLastDate(current time.Time) bool {
 	if f.checkLTE {
 		return current.After(f.LTE)
@@ -87,19 +154,53 @@ func (f *flatFilter) beyondLastDate(current time.Time) bool {
 	return current.Equal(f.LT) || current.After(f.LT)
 }
 
-func queryToFilters(query *es.Query) (bom, accountingName, userName string, checkGPU bool) {
+func queryToFilters(query *es.Query) (bom, accountingName, userName string) {
 	filters := query.Filters()
 
 	bom = sanitiseBOMForFileSystem(filters["BOM"])
 	accountingName = filters["ACCOUNTING_NAME"]
 	userName = filters["USER_NAME"]
 
-	qname, ok := filters["QUEUE_NAME"]
-	if ok && strings.HasPrefix(qname, gpuPrefix) {
-		checkGPU = true
+	return bom, accountingName, userName
+}
+
+// setQueueFilter sets us up to check for entries with a queue name that
+// exactly matches a QUEUE_NAME match_phrase in the query, or that starts with
+// a QUEUE_NAME prefix.
+func (f *flatFilter) setQueueFilter(query *es.Query) {
+	if qname, ok := query.PrefixFilters()["QUEUE_NAME"]; ok {
+		f.checkQueue = true
+		f.queueIsPrefix = true
+		f.queueKey = []byte(qname)
+
+		return
+	}
+
+	qname, ok := query.MatchFilters()["QUEUE_NAME"]
+	if !ok {
+		return
+	}
+
+	f.checkQueue = true
+	f.queueKey = []byte(qname)
+}
+
+// setJobPrefixFilter sets us up to check that entries (that have one) have a
+// job name prefix that starts with the start of a JOB_NAME prefix in the query.
+// Since we only index the start of job names, this can only rule entries out;
+// the full prefix must still be checked against the hit data.
+func (f *flatFilter) setJobPrefixFilter(query *es.Query) {
+	prefix, ok := query.PrefixFilters()["JOB_NAME"]
+	if !ok {
+		return
+	}
+
+	if len(prefix) > jobPrefixWidth {
+		prefix = prefix[:jobPrefixWidth]
 	}
 
-	return bom, accountingName, userName, checkGPU
+	f.checkJobPrefix = true
+	f.jobPrefixKey = []byte(prefix)
 }
 
 type passChecker struct {
@@ -107,6 +208,40 @@ type passChecker struct {
 	passing bool
 }
 
+// entriesInTimeRange uses binary searches to return the sub-slice of the given
+// timestamp-sorted entries that are within our GT/GTE and LT/LTE range.
+func (f *flatFilter) entriesInTimeRange(entries []*flatIndexEntry) []*flatIndexEntry {
+	start := sort.Search(len(entries), func(i int) bool {
+		return f.afterStart(entries[i].timeStamp)
+	})
+
+	end := start + sort.Search(len(entries)-start, func(i int) bool {
+		return !f.beforeEnd(entries[start+i].timeStamp)
+	})
+
+	return entries[start:end]
+}
+
+// beforeEnd returns true if the given timestamp is less than our LT value, or
+// less than or equal to our LTE value if we have one.
+func (f *flatFilter) beforeEnd(timestamp []byte) bool {
+	if f.checkLTE {
+		return bytes.Compare(timestamp, f.LTEKey) <= 0
+	}
+
+	return bytes.Compare(timestamp, f.LTKey) < 0
+}
+
+// afterStart returns true if the given timestamp is greater than or equal to
+// our GTE value, or greater than our GT value if we have one.
+func (f *flatFilter) afterStart(timestamp []byte) bool {
+	if f.checkGT {
+		return bytes.Compare(timestamp, f.GTKey) > 0
+	}
+
+	return bytes.Compare(timestamp, f.GTEKey) >= 0
+}
+
 // PassChecker returns a new passChecker that can be used in a goroutine to see
 // if values all pass the filter.
 func (f *flatFilter) PassChecker() *passChecker {
@@ -123,32 +258,45 @@ func (p *passChecker) Fail() {
 // should be the first method you use in a loop as it overrides Passes() return
 // value.
 func (p *passChecker) LT(timestamp []byte) {
-	if p.filter.checkLTE {
-		p.passing = bytes.Compare(timestamp, p.filter.LTEKey) <= 0
-	} else {
-		p.passing = bytes.Compare(timestamp, p.filter.LTKey) < 0
-	}
+	p.passing = p.filter.beforeEnd(timestamp)
 }
 
-// GTE sees if the given timestamp is greater than or equal to the filter's GTE
-// value. Does nothing if we're already not passing.
+// GTE sees if the given timestamp is greater than or equal to (or greater
+// than, depending on what was set in the filter) the filter's GTE/GT value.
+// Does nothing if we're already not passing.
 func (p *passChecker) GTE(timestamp []byte) {
 	if !p.passing {
 		return
 	}
 
-	p.passing = bytes.Compare(timestamp, p.filter.GTEKey) >= 0
+	p.passing = p.filter.afterStart(timestamp)
+}
+
+// Queue sees if the given fixed width queue name (of whatever width it was
+// indexed with) matches the filter's queue name, or starts with it if the
+// filter is for a queue prefix. Does nothing if we're already not passing, or
+// the filter doesn't check queue names.
+func (p *passChecker) Queue(queue []byte) {
+	if !p.passing || !p.filter.checkQueue {
+		return
+	}
+
+	if p.filter.queueIsPrefix {
+		p.passing = bytes.HasPrefix(queue, p.filter.queueKey)
+	} else {
+		p.passing = bytes.Equal(bytes.TrimRight(queue, " "), p.filter.queueKey)
+	}
 }
 
-// GPU sees if the given value matches the inGPUQueue value. Does nothing if
-// we're already not passing, or the filter doesn't check for GPU queue
-// membership.
-func (p *passChecker) GPU(val byte) {
-	if !p.passing || !p.filter.checkGPU {
+// JobPrefix sees if the given job name prefix starts with the filter's job name
+// prefix. Does nothing if we're already not passing, the filter doesn't check
+// job names, or the given prefix is nil because it wasn't indexed.
+func (p *passChecker) JobPrefix(prefix []byte) {
+	if !p.passing || !p.filter.checkJobPrefix || prefix == nil {
 		return
 	}
 
-	p.passing = val == inGPUQueue
+	p.passing = bytes.HasPrefix(prefix, p.filter.jobPrefixKey)
 }
 
 // Passes returns true if Fail() hasn't been called and none of the filter check
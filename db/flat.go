@@ -30,9 +30,11 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"strings"
+	"sync"
 
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
 )
@@ -40,11 +42,14 @@ import (
 const (
 	indexKind           = "index"
 	dataKind            = "data"
+	jobPrefixKind       = "jobs"
+	jobPrefixWidth      = 8
 	entriesKeySeparator = "."
 )
 
 type flatDB struct {
 	dir             string
+	prefix          string
 	desiredFileSize int
 	bufferSize      int
 
@@ -53,15 +58,46 @@ type flatDB struct {
 
 	dataF         *os.File
 	dataW         *bufio.Writer
+	jobsF         *os.File
+	jobsW         *bufio.Writer
+	jobs          []byte
+	sumsF         *os.File
+	sumsW         *bufio.Writer
+	indexSum      uint32
+	jobsSum       uint32
 	dataPos       int
 	dataFileIndex int
+	dict          *es.Dictionary
+	rollup        rollup
+	usernames     usernameSet
+	gpu           *gpuRollup
+	widths        IndexWidths
+	columnFields  []string
+	columns       []*columnWriter
 }
 
-func newFlatDB(dir string, fileSize, bufferSize int) (*flatDB, error) {
+func newFlatDB(dir string, fileSize, bufferSize int, widths IndexWidths) (*flatDB, error) {
+	return newPrefixedFlatDB(dir, "", fileSize, bufferSize, widths)
+}
+
+// newPrefixedFlatDB is like newFlatDB, but the names of the files we create
+// start with the given prefix. If the prefix isn't blank, Finish() doesn't
+// write rollup, usernames or gpu rollup files, since we won't hold all of the
+// directory's hits.
+func newPrefixedFlatDB(dir, prefix string, fileSize, bufferSize int, widths IndexWidths) (*flatDB, error) {
 	f := &flatDB{
 		dir:             dir,
+		prefix:          prefix,
 		desiredFileSize: fileSize,
 		bufferSize:      bufferSize,
+		rollup:          make(rollup),
+		usernames:       make(usernameSet),
+		gpu:             newGPURollup(),
+		widths:          widths.OrDefaults(),
+	}
+
+	if err := f.widths.validate(); err != nil {
+		return nil, err
 	}
 
 	err := f.createFilesAndWriters()
@@ -77,7 +113,33 @@ func (f *flatDB) createFilesAndWriters() error {
 		return err
 	}
 
+	f.indexSum, f.jobsSum = 0, 0
+
+	if err = f.writeIndex(f.widths.header()); err != nil {
+		return err
+	}
+
 	f.dataF, f.dataW, err = f.createFileAndWriter(dataKind)
+	if err != nil {
+		return err
+	}
+
+	f.sumsF, f.sumsW, err = f.createFileAndWriter(checksumKind)
+	if err != nil {
+		return err
+	}
+
+	f.dict = es.NewDictionary()
+
+	return f.createColumnWriters()
+}
+
+// writeIndex writes the given bytes to our index file, keeping track of its
+// checksum.
+func (f *flatDB) writeIndex(b []byte) error {
+	f.indexSum = crc32.Update(f.indexSum, castagnoli, b)
+
+	_, err := f.indexW.Write(b)
 
 	return err
 }
@@ -88,7 +150,7 @@ func (f *flatDB) createFileAndWriter(kind string) (*os.File, *bufio.Writer, erro
 		return nil, nil, err
 	}
 
-	fh, err := os.Create(fmt.Sprintf("%s/%d.%s", f.dir, f.dataFileIndex, kind))
+	fh, err := os.Create(f.path(kind))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -98,22 +160,54 @@ func (f *flatDB) createFileAndWriter(kind string) (*os.File, *bufio.Writer, erro
 	return fh, w, nil
 }
 
+// path returns the path of our current file of the given kind.
+func (f *flatDB) path(kind string) string {
+	return fmt.Sprintf("%s/%s%d.%s", f.dir, f.prefix, f.dataFileIndex, kind)
+}
+
 func (f *flatDB) Store(hit *es.Hit) error {
-	group, user, isGPU, data, err := getFixedWidthFields(hit)
+	fields, err := getFixedWidthFields(hit, f.widths)
 	if err != nil {
 		return err
 	}
 
-	n, err := f.dataW.Write(data)
+	if fields.data, err = f.encode(hit.Details); err != nil {
+		return err
+	}
+
+	return f.storeFields(hit, fields)
+}
+
+// storeFields stores the given hit, using its fields from
+// getFixedWidthFields() and its data from encode().
+func (f *flatDB) storeFields(hit *es.Hit, fields *fixedWidthFields) error {
+	n, err := f.dataW.Write(fields.data)
 	if err != nil {
 		return err
 	}
 
-	err = f.storeIndex(hit.Details.Timestamp, group, user, isGPU, f.dataPos, len(data))
+	err = f.storeIndex(hit.Details.Timestamp, fields, f.dataPos, len(fields.data))
 	if err != nil {
 		return err
 	}
 
+	if f.jobsW != nil {
+		f.jobs = append(f.jobs, fields.jobPrefix...)
+	}
+
+	_, err = f.sumsW.Write(u32tob(crc32.Checksum(fields.data, castagnoli)))
+	if err != nil {
+		return err
+	}
+
+	if err = f.writeColumns(hit.Details); err != nil {
+		return err
+	}
+
+	f.rollup.add(hit.Details)
+	f.usernames[hit.Details.UserName] = true
+	f.gpu.add(hit.Details)
+
 	f.dataPos += n
 	if f.dataPos > f.desiredFileSize {
 		if err := f.switchToNewFiles(); err != nil {
@@ -124,16 +218,17 @@ func (f *flatDB) Store(hit *es.Hit) error {
 	return nil
 }
 
-func (f *flatDB) storeIndex(timestamp int64, group, user []byte, isGPU byte, dataIndex, dataLen int) error {
+func (f *flatDB) storeIndex(timestamp int64, fields *fixedWidthFields, dataIndex, dataLen int) error {
 	for _, field := range [][]byte{
 		i64tob(timestamp),
-		group,
-		user,
-		{isGPU},
+		fields.group,
+		fields.user,
+		{fields.isGPU},
+		fields.queue,
 		i32tob(int32(dataIndex)),
 		i32tob(int32(dataLen)),
 	} {
-		if _, err := f.indexW.Write(field); err != nil {
+		if err := f.writeIndex(field); err != nil {
 			return err
 		}
 	}
@@ -141,30 +236,60 @@ func (f *flatDB) storeIndex(timestamp int64, group, user []byte, isGPU byte, dat
 	return nil
 }
 
-func getFixedWidthFields(hit *es.Hit) ([]byte, []byte, byte, []byte, error) {
-	group, err := fixedWidthField(hit.Details.AccountingName, accountingNameWidth)
+// fixedWidthFields holds the values of a hit that we store in an index entry,
+// along with the encoded hit Details that we store in the data file, once
+// they've been encoded by the flatDB the hit will be stored in.
+type fixedWidthFields struct {
+	group     []byte
+	user      []byte
+	isGPU     byte
+	queue     []byte
+	jobPrefix []byte
+	data      []byte
+}
+
+func getFixedWidthFields(hit *es.Hit, widths IndexWidths) (*fixedWidthFields, error) {
+	group, err := fixedWidthField(hit.Details.AccountingName, widths.AccountingName)
 	if err != nil {
-		return nil, nil, 0, nil, err
+		return nil, err
 	}
 
-	user, err := fixedWidthField(hit.Details.UserName, userNameWidth)
+	user, err := fixedWidthField(hit.Details.UserName, widths.UserName)
 	if err != nil {
-		return nil, nil, 0, nil, err
+		return nil, err
+	}
+
+	queue, err := fixedWidthField(hit.Details.QueueName, widths.QueueName)
+	if err != nil {
+		return nil, err
 	}
 
 	isGPU := notInGPUQueue
-	if strings.HasPrefix(hit.Details.QueueName, gpuPrefix) {
+	if isGPUQueue(hit.Details.QueueName) {
 		isGPU = inGPUQueue
 	}
 
 	hit.Details.ID = hit.ID
 
-	encodedDetails, err := hit.Details.Serialize() //nolint:misspell
-	if err != nil {
-		return nil, nil, 0, nil, err
+	return &fixedWidthFields{
+		group:     group,
+		user:      user,
+		isGPU:     isGPU,
+		queue:     queue,
+		jobPrefix: jobNamePrefix(hit.Details.JobName),
+	}, nil
+}
+
+// jobNamePrefix returns the first jobPrefixWidth bytes of the given job name,
+// padded with spaces if it was shorter.
+func jobNamePrefix(jobName string) []byte {
+	if len(jobName) > jobPrefixWidth {
+		return []byte(jobName[:jobPrefixWidth])
 	}
 
-	return group, user, isGPU, encodedDetails, nil
+	prefix, _ := fixedWidthField(jobName, jobPrefixWidth) //nolint:errcheck
+
+	return prefix
 }
 
 func fixedWidthField(str string, width int) ([]byte, error) {
@@ -178,14 +303,19 @@ func fixedWidthField(str string, width int) ([]byte, error) {
 
 // i32tob is like i64tob, but for int32s.
 func i32tob(v int32) []byte {
+	return u32tob(uint32(v))
+}
+
+// u32tob is like i32tob, but for uint32s.
+func u32tob(v uint32) []byte {
 	b := make([]byte, lengthEncodeWidth)
-	binary.BigEndian.PutUint32(b, uint32(v))
+	binary.BigEndian.PutUint32(b, v)
 
 	return b
 }
 
 func (f *flatDB) switchToNewFiles() error {
-	err := f.Close()
+	err := f.closeChunk()
 	if err != nil {
 		return err
 	}
@@ -202,24 +332,86 @@ func (f *flatDB) switchToNewFiles() error {
 	return nil
 }
 
+// Close flushes and closes our files, including our job prefix index if we
+// have one. See closeChunk().
 func (f *flatDB) Close() error {
-	f.indexW.Flush()
-	f.dataW.Flush()
+	if err := f.closeChunk(); err != nil {
+		return err
+	}
 
-	err := f.indexF.Close()
-	if err != nil {
+	return f.closeJobPrefixIndex()
+}
+
+// closeChunk flushes and closes the files of our current data file, first
+// writing its job prefixes to our job prefix index (if we have one) and the
+// checksums of its index file and those job prefixes to our checksum file. The
+// dictionary of our data file is then written, if we stored any hits. Any
+// column files are closed as well.
+func (f *flatDB) closeChunk() error {
+	if err := f.writeJobPrefixSection(); err != nil {
+		return err
+	}
+
+	f.sumsW.Write(u32tob(f.indexSum)) //nolint:errcheck
+	f.sumsW.Write(u32tob(f.jobsSum))  //nolint:errcheck
+
+	for _, w := range []*bufio.Writer{f.indexW, f.dataW, f.sumsW} {
+		w.Flush()
+	}
+
+	for _, fh := range []*os.File{f.indexF, f.dataF, f.sumsF} {
+		if err := fh.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := f.closeColumns(); err != nil {
 		return err
 	}
 
-	return f.dataF.Close()
+	if f.dict.Len() == 0 {
+		return nil
+	}
+
+	return writeDictionary(f.path(dictKind), f.dict)
+}
+
+// Finish is like Close(), but also writes a rollup file summarising all the
+// hits we stored, a usernames file of their unique USER_NAMEs, and a gpu
+// rollup file summarising those in gpu queues. Call this instead of Close()
+// when you've finished storing.
+func (f *flatDB) Finish() error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if f.prefix != "" {
+		return nil
+	}
+
+	if err := f.rollup.write(f.dir); err != nil {
+		return err
+	}
+
+	if err := f.usernames.write(f.dir); err != nil {
+		return err
+	}
+
+	return f.gpu.write(f.dir)
 }
 
 type flatIndexEntry struct {
-	timeStamp []byte
-	gpu       byte
-	userName  string
-	index     int64
-	length    int
+	timeStamp      []byte
+	gpu            byte
+	queue          []byte
+	jobPrefix      []byte
+	accountingName string
+	userName       string
+	index          int64
+	length         int
+	checksum       uint32
+	checksummed    bool
+	ordinal        int
 }
 
 // Passes first bool will be false if LT doesn't pass. The second bool will be
@@ -232,7 +424,8 @@ func (e *flatIndexEntry) Passes(check *passChecker) (bool, bool) {
 	}
 
 	check.GTE(e.timeStamp)
-	check.GPU(e.gpu)
+	check.Queue(e.queue)
+	check.JobPrefix(e.jobPrefix)
 
 	return true, check.Passes()
 }
@@ -243,25 +436,72 @@ type flatIndex struct {
 	userEntries      map[string][]*flatIndexEntry
 	groupUserEntries map[string][]*flatIndexEntry
 
-	dataPath string
-	fh       *os.File
+	dataPath   string
+	jobsSum    uint32
+	jobsSummed bool
+
+	dictOnce sync.Once
+	dict     *es.Dictionary
+	dictErr  error
 }
 
-func newFlatIndex(path string, fileBufferSize int) (*flatIndex, error) { //nolint:funlen,gocognit,gocyclo
-	f, erro := os.Open(path)
-	if erro != nil {
-		return nil, erro
+func newFlatIndex(path string, fileBufferSize int) (*flatIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi := newEmptyFlatIndex(path)
+	sum := crc32.New(castagnoli)
+
+	if err = fi.readEntries(bufio.NewReaderSize(io.TeeReader(f, sum), fileBufferSize)); err != nil {
+		f.Close()
+
+		return nil, err
 	}
 
-	br := bufio.NewReaderSize(f, fileBufferSize)
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
 
-	fi := &flatIndex{
-		dataPath:         strings.TrimSuffix(path, indexKind) + dataKind,
+	if err = fi.verifyChecksums(path, sum.Sum32()); err != nil {
+		return nil, err
+	}
+
+	return fi, fi.loadJobPrefixes(path)
+}
+
+// newEmptyFlatIndex returns a flatIndex for the given index file path that has
+// no entries yet.
+func newEmptyFlatIndex(path string) *flatIndex {
+	return &flatIndex{
+		dataPath:         dataPathOfIndex(path),
 		groupEntries:     make(map[string][]*flatIndexEntry),
 		userEntries:      make(map[string][]*flatIndexEntry),
 		groupUserEntries: make(map[string][]*flatIndexEntry),
 	}
+}
+
+// dataPathOfIndex returns the path to the data file that corresponds to the
+// given index file path.
+func dataPathOfIndex(path string) string {
+	return strings.TrimSuffix(path, indexKind) + dataKind
+}
+
+// readEntries reads the index header, if any, and then index entries from the
+// given reader until EOF.
+func (f *flatIndex) readEntries(br *bufio.Reader) error {
+	widths, err := readIndexHeader(br)
+	if err != nil {
+		return err
+	}
 
+	return f.readEntriesOfWidths(br, widths)
+}
+
+// readEntriesOfWidths reads index entries with the given widths from the given
+// reader until EOF.
+func (f *flatIndex) readEntriesOfWidths(br *bufio.Reader, widths IndexWidths) error { //nolint:funlen
 	for {
 		entry := &flatIndexEntry{}
 
@@ -269,60 +509,65 @@ func newFlatIndex(path string, fileBufferSize int) (*flatIndex, error) { //nolin
 		_, err := io.ReadFull(br, tsBuf)
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
-				return nil, err
+				return err
 			}
 
-			break
+			return nil
 		}
 
 		entry.timeStamp = tsBuf
 
-		accBuf := make([]byte, accountingNameWidth)
+		accBuf := make([]byte, widths.AccountingName)
 		if _, err = io.ReadFull(br, accBuf); err != nil {
-			return nil, err
+			return err
 		}
 
-		userBuf := make([]byte, userNameWidth)
+		userBuf := make([]byte, widths.UserName)
 		if _, err = io.ReadFull(br, userBuf); err != nil {
-			return nil, err
+			return err
 		}
 
 		gpuByte, err := br.ReadByte()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		entry.gpu = gpuByte
 
+		queueBuf := make([]byte, widths.QueueName)
+		if _, err = io.ReadFull(br, queueBuf); err != nil {
+			return err
+		}
+
+		entry.queue = queueBuf
+
 		numBuf := make([]byte, lengthEncodeWidth)
 		if _, err = io.ReadFull(br, numBuf); err != nil {
-			return nil, err
+			return err
 		}
 
 		entry.index = int64(btoi(numBuf))
 
 		lenBuf := make([]byte, lengthEncodeWidth)
 		if _, err = io.ReadFull(br, lenBuf); err != nil {
-			return nil, err
+			return err
 		}
 
 		entry.length = btoi(lenBuf)
 
 		group := strings.TrimSpace(string(accBuf))
 		user := strings.TrimSpace(string(userBuf))
+		entry.accountingName = group
 		entry.userName = user
+		entry.ordinal = len(f.bomEntries)
 
-		fi.bomEntries = append(fi.bomEntries, entry)
-		fi.groupEntries[group] = append(fi.groupEntries[group], entry)
-		fi.userEntries[user] = append(fi.userEntries[user], entry)
+		f.bomEntries = append(f.bomEntries, entry)
+		f.groupEntries[group] = append(f.groupEntries[group], entry)
+		f.userEntries[user] = append(f.userEntries[user], entry)
 
 		groupUserKey := group + entriesKeySeparator + user
-		fi.groupUserEntries[groupUserKey] = append(fi.groupUserEntries[groupUserKey], entry)
+		f.groupUserEntries[groupUserKey] = append(f.groupUserEntries[groupUserKey], entry)
 	}
-
-	errc := f.Close()
-
-	return fi, errc
 }
 
 func btoi(b []byte) int {
@@ -331,11 +576,31 @@ func btoi(b []byte) int {
 
 func (f *flatIndex) IndexSearch(filter *flatFilter) []*flatIndexEntry {
 	entries := f.getEntries(filter)
+	passEntries := make([]*flatIndexEntry, 0, len(entries))
+
+	forEachPassingEntry(entries, filter, func(entry *flatIndexEntry) {
+		passEntries = append(passEntries, entry)
+	})
+
+	return passEntries
+}
+
+// forEachPassingEntry calls the given callback with each of the given entries
+// that pass the filter. It stops early if the filter's context is cancelled.
+// The number of entries looked at is added to the filter's scanned count.
+func forEachPassingEntry(entries []*flatIndexEntry, filter *flatFilter, cb func(*flatIndexEntry)) {
 	check := filter.PassChecker()
+	scanned := 0
 
-	passEntries := make([]*flatIndexEntry, 0, len(entries))
+	defer func() { filter.scanned.Add(int64(scanned)) }()
+
+	for i, entry := range entries {
+		if i%contextCheckInterval == 0 && filter.contextErr() != nil {
+			return
+		}
+
+		scanned++
 
-	for _, entry := range entries {
 		continueOK, passes := entry.Passes(check)
 		if !continueOK {
 			break
@@ -345,10 +610,8 @@ func (f *flatIndex) IndexSearch(filter *flatFilter) []*flatIndexEntry {
 			continue
 		}
 
-		passEntries = append(passEntries, entry)
+		cb(entry)
 	}
-
-	return passEntries
 }
 
 func (f *flatIndex) getEntries(filter *flatFilter) []*flatIndexEntry {
@@ -364,65 +627,62 @@ func (f *flatIndex) getEntries(filter *flatFilter) []*flatIndexEntry {
 		entries = f.groupEntries[filter.accountingName]
 	}
 
-	return entries
-}
-
-func (f *flatIndex) getDataEntry(buf []byte, entry *flatIndexEntry) error {
-	err := f.openDataFile()
-	if err != nil {
-		return err
-	}
-
-	n, err := f.fh.ReadAt(buf, entry.index)
-	if err != nil && n == entry.length {
-		err = nil
-	}
-
-	return err
+	return filter.entriesInTimeRange(entries)
 }
 
-func (f *flatIndex) openDataFile() error {
-	if f.fh != nil {
-		return nil
-	}
-
-	fh, err := os.Open(f.dataPath)
-	if err != nil {
-		return err
-	}
+// DistinctCounts returns how many of our entries that pass the filter have
+// each value of the given field.
+func (f *flatIndex) DistinctCounts(filter *flatFilter, field string) map[string]int {
+	counts := make(map[string]int)
+	getter := entryValueGetter(field)
 
-	f.fh = fh
+	forEachPassingEntry(f.getEntries(filter), filter, func(entry *flatIndexEntry) {
+		counts[getter(entry)]++
+	})
 
-	return nil
+	return counts
 }
 
-func (f *flatIndex) close() {
-	if f.fh != nil {
-		return
+// entryValueGetter returns a function that gets the value of the given indexed
+// field from an entry. field must be one of ACCOUNTING_NAME, USER_NAME or
+// es.DistinctGPU.
+func entryValueGetter(field string) func(*flatIndexEntry) string {
+	switch field {
+	case "ACCOUNTING_NAME":
+		return func(e *flatIndexEntry) string { return e.accountingName }
+	case "USER_NAME":
+		return func(e *flatIndexEntry) string { return e.userName }
+	default:
+		return func(e *flatIndexEntry) string { return es.GPUFlag(e.gpu == inGPUQueue) }
 	}
-
-	f.fh.Close()
-	f.fh = nil
 }
 
-func (f *flatIndex) Usernames(filter *flatFilter) map[string]bool {
-	entries := f.getEntries(filter)
+// firstPassingEntry returns the first of our entries that passes the filter, or
+// nil if none do.
+func (f *flatIndex) firstPassingEntry(filter *flatFilter) *flatIndexEntry {
 	check := filter.PassChecker()
 
-	usernames := make(map[string]bool)
-
-	for _, entry := range entries {
+	for _, entry := range f.getEntries(filter) {
 		continueOK, passes := entry.Passes(check)
 		if !continueOK {
 			break
 		}
 
-		if !passes {
-			continue
+		if passes {
+			return entry
 		}
-
-		usernames[entry.userName] = true
 	}
 
-	return usernames
+	return nil
+}
+
+// Count returns the number of our entries that pass the filter.
+func (f *flatIndex) Count(filter *flatFilter) int {
+	count := 0
+
+	forEachPassingEntry(f.getEntries(filter), filter, func(*flatIndexEntry) {
+		count++
+	})
+
+	return count
 }
@@ -0,0 +1,297 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const quarantineBasename = ".quarantine"
+
+// FsckProblem describes something wrong with a file in a database directory,
+// found by Fsck().
+type FsckProblem struct {
+	// Day is the "YYYY/MM/DD" day directory the file is in.
+	Day     string
+	Path    string
+	Problem string
+}
+
+// Fsck checks the integrity of all the flat files in the configured database
+// directory: that index and data files come in pairs, that index files (and
+// their job prefix files) match their checksums, that index entries refer to
+// data that is within their data file, that their timestamps are in order and
+// within their day, and that the data matches its checksums.
+//
+// Only the first problem of each kind in each file is reported. Problems with
+// files that are missing or unreadable are reported, but other errors are
+// returned.
+func Fsck(config Config) ([]FsckProblem, error) {
+	bomDirs, err := bomDirsWithFiles(config.Directory, indexKind, dataKind)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []FsckProblem
+
+	for _, dir := range bomDirs {
+		day, err := filepath.Rel(config.Directory, filepath.Dir(dir)) //nolint:govet
+		if err != nil {
+			return nil, err
+		}
+
+		day = filepath.ToSlash(day)
+
+		for _, p := range fsckBOMDir(dir, day, config.BufferSizeOrDefault()) {
+			problems = append(problems, FsckProblem{Day: day, Path: p.path, Problem: p.problem})
+		}
+	}
+
+	return problems, nil
+}
+
+// fsckProblems collects the first problem of each kind for each file.
+type fsckProblems struct {
+	seen     map[string]bool
+	problems []fsckProblem
+}
+
+type fsckProblem struct {
+	path    string
+	problem string
+}
+
+func (f *fsckProblems) add(path, kind, detail string) {
+	key := path + "\x00" + kind
+	if f.seen[key] {
+		return
+	}
+
+	f.seen[key] = true
+	f.problems = append(f.problems, fsckProblem{path: path, problem: kind + ": " + detail})
+}
+
+// fsckBOMDir checks the pairing of index and data files in the given day/BOM
+// directory, and checks each index file and its entries.
+func fsckBOMDir(dir, day string, bufferSize int) []fsckProblem {
+	fp := &fsckProblems{seen: make(map[string]bool)}
+
+	nums, err := flatFileNums(dir)
+	if err != nil {
+		fp.add(dir, "unreadable directory", err.Error())
+
+		return fp.problems
+	}
+
+	for _, num := range nums {
+		indexPath := filepath.Join(dir, num+"."+indexKind)
+		dataPath := dataPathOfIndex(indexPath)
+
+		if !fileExists(indexPath) {
+			fp.add(dataPath, "unpaired data file", "no corresponding index file")
+
+			continue
+		}
+
+		if !dataFileExists(dataPath) {
+			fp.add(indexPath, "unpaired index file", "no corresponding data file")
+
+			continue
+		}
+
+		fsckIndex(fp, indexPath, day, bufferSize)
+	}
+
+	return fp.problems
+}
+
+// flatFileNums returns the unique numbers of the index and data files in the
+// given directory, in numeric order.
+func flatFileNums(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+
+	for _, entry := range entries {
+		num, kind, found := strings.Cut(entry.Name(), ".")
+		if found && (kind == indexKind || kind == dataKind) {
+			seen[num] = true
+		}
+	}
+
+	nums := make([]string, 0, len(seen))
+	for num := range seen {
+		nums = append(nums, num)
+	}
+
+	sort.Slice(nums, func(i, j int) bool {
+		ni, _ := strconv.Atoi(nums[i]) //nolint:errcheck
+		nj, _ := strconv.Atoi(nums[j]) //nolint:errcheck
+
+		return ni < nj
+	})
+
+	return nums, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+// fsckIndex checks the given index file, and its entries and their data.
+func fsckIndex(fp *fsckProblems, indexPath, day string, bufferSize int) {
+	fi, err := newFlatIndex(indexPath, bufferSize)
+	if err != nil {
+		fp.add(indexPath, "bad index file", err.Error())
+
+		return
+	}
+
+	size, err := dataFileSize(fi.dataPath)
+	if err != nil {
+		fp.add(fi.dataPath, "unreadable data file", err.Error())
+
+		return
+	}
+
+	inRange := fsckEntries(fp, indexPath, fi.bomEntries, size, day)
+
+	if _, err = fi.dictionary(); err != nil {
+		fp.add(dictionaryPath(fi.dataPath), "bad dictionary", err.Error())
+	}
+
+	if _, err = readEntryData(fi.dataPath, inRange); err != nil {
+		fp.add(fi.dataPath, "bad data", err.Error())
+	}
+}
+
+// fsckEntries checks that the given entries refer to data within the given
+// data file size, and have timestamps that are in order and within the given
+// day. Returns the entries whose data is within the data file.
+func fsckEntries(fp *fsckProblems, indexPath string, entries []*flatIndexEntry,
+	dataSize int64, day string) []*flatIndexEntry {
+	gte, lt := dayTimestampRange(day)
+	inRange := make([]*flatIndexEntry, 0, len(entries))
+
+	var prev []byte
+
+	for i, entry := range entries {
+		if entry.index+int64(entry.length) > dataSize {
+			fp.add(indexPath, "entry beyond end of data file",
+				fmt.Sprintf("entry %d ends at %d, but data file is %d bytes", i, entry.index+int64(entry.length), dataSize))
+		} else {
+			inRange = append(inRange, entry)
+		}
+
+		if prev != nil && bytes.Compare(entry.timeStamp, prev) < 0 {
+			fp.add(indexPath, "timestamps out of order", fmt.Sprintf("entry %d", i))
+		}
+
+		if gte != nil && (bytes.Compare(entry.timeStamp, gte) < 0 || bytes.Compare(entry.timeStamp, lt) >= 0) {
+			fp.add(indexPath, "timestamp not in day", fmt.Sprintf("entry %d", i))
+		}
+
+		prev = entry.timeStamp
+	}
+
+	return inRange
+}
+
+// dayTimestampRange returns the timestamp bytes of the start of the given
+// "YYYY/MM/DD" day and the start of the next day. Returns nils if day isn't a
+// valid day.
+func dayTimestampRange(day string) ([]byte, []byte) {
+	start, err := time.Parse(dateFormat, day)
+	if err != nil {
+		return nil, nil
+	}
+
+	return i64tob(start.Unix()), i64tob(start.Add(oneDay).Unix())
+}
+
+// QuarantineDays moves the given "YYYY/MM/DD" day directories of the configured
+// database directory in to a hidden .quarantine directory within it, so that
+// they are no longer loaded, and so that Backfill() will backfill them again.
+func QuarantineDays(config Config, days []string) error {
+	if err := config.checkWritable(); err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		from := filepath.Join(config.Directory, filepath.FromSlash(day))
+		to := filepath.Join(config.Directory, quarantineBasename, filepath.FromSlash(day))
+
+		if err := os.RemoveAll(to); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(to), dbDirPerms); err != nil {
+			return err
+		}
+
+		if err := os.Rename(from, to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RebackfillDays quarantines the given "YYYY/MM/DD" day directories of the
+// configured database directory (see QuarantineDays()), then uses the given
+// client to Backfill() each of those days again.
+func RebackfillDays(client Scroller, config Config, days []string) error {
+	if err := QuarantineDays(config, days); err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		start, err := time.Parse(dateFormat, day)
+		if err != nil {
+			return err
+		}
+
+		if _, err = Backfill(client, config, start.Add(oneDay), oneDay); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestFsck(t *testing.T) {
+	Convey("Given a backfilled database", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: t.TempDir()}
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+
+		_, err := Backfill(mock, config, from, 2*oneDay)
+		So(err, ShouldBeNil)
+
+		bomDir := filepath.Join(config.Directory, "2024", "05", "31", "Human Genetics")
+		indexPath := filepath.Join(bomDir, "0.index")
+		dataPath := filepath.Join(bomDir, "0.data")
+
+		Convey("Fsck() finds no problems", func() {
+			problems, err := Fsck(config)
+			So(err, ShouldBeNil)
+			So(problems, ShouldBeEmpty)
+		})
+
+		Convey("Fsck() finds corrupt data", func() {
+			corruptByte(dataPath, 1)
+
+			problems, err := Fsck(config)
+			So(err, ShouldBeNil)
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0].Day, ShouldEqual, "2024/05/31")
+			So(problems[0].Path, ShouldEqual, dataPath)
+			So(problems[0].Problem, ShouldContainSubstring, ErrChecksumMismatch)
+
+			Convey("which you can quarantine", func() {
+				err = QuarantineDays(config, []string{problems[0].Day})
+				So(err, ShouldBeNil)
+
+				_, err = os.Stat(bomDir)
+				So(err, ShouldNotBeNil)
+
+				_, err = os.Stat(filepath.Join(config.Directory, quarantineBasename, "2024", "05", "31", "Human Genetics"))
+				So(err, ShouldBeNil)
+
+				problems, err = Fsck(config)
+				So(err, ShouldBeNil)
+				So(problems, ShouldBeEmpty)
+			})
+
+			Convey("or backfill again", func() {
+				err = RebackfillDays(mock, config, []string{problems[0].Day})
+				So(err, ShouldBeNil)
+
+				_, err = os.Stat(indexPath)
+				So(err, ShouldBeNil)
+
+				problems, err = Fsck(config)
+				So(err, ShouldBeNil)
+				So(problems, ShouldBeEmpty)
+			})
+		})
+
+		Convey("Fsck() finds truncated data files", func() {
+			So(os.Truncate(dataPath, 10), ShouldBeNil)
+
+			problems, err := Fsck(config)
+			So(err, ShouldBeNil)
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0].Path, ShouldEqual, indexPath)
+			So(problems[0].Problem, ShouldStartWith, "entry beyond end of data file")
+		})
+
+		Convey("Fsck() finds unpaired files", func() {
+			So(os.Remove(dataPath), ShouldBeNil)
+
+			problems, err := Fsck(config)
+			So(err, ShouldBeNil)
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0].Path, ShouldEqual, indexPath)
+			So(problems[0].Problem, ShouldStartWith, "unpaired index file")
+		})
+
+		Convey("Fsck() finds corrupt index files", func() {
+			corruptByte(indexPath, 1)
+
+			problems, err := Fsck(config)
+			So(err, ShouldBeNil)
+			So(problems, ShouldHaveLength, 1)
+			So(problems[0].Path, ShouldEqual, indexPath)
+			So(problems[0].Problem, ShouldStartWith, "bad index file")
+		})
+
+		Convey("Fsck() finds out of order timestamps", func() {
+			fdb, err := newFlatDB(filepath.Join(config.Directory, "2024", "05", "29", "bom"), fileSize, bufferSize, IndexWidths{})
+			So(err, ShouldBeNil)
+
+			for _, ts := range []int64{1716940800 + 10, 1716940800 + 5, 1717113600} {
+				So(fdb.Store(&es.Hit{ID: "a", Details: &es.Details{Timestamp: ts}}), ShouldBeNil)
+			}
+
+			So(fdb.Finish(), ShouldBeNil)
+
+			problems, err := Fsck(config)
+			So(err, ShouldBeNil)
+			So(problems, ShouldHaveLength, 2)
+			So(problems[0].Day, ShouldEqual, "2024/05/29")
+			So(problems[0].Problem, ShouldStartWith, "timestamps out of order")
+			So(problems[1].Problem, ShouldStartWith, "timestamp not in day")
+		})
+	})
+}
@@ -0,0 +1,281 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrGPUReportRange = "GPU report needs a last day no earlier than the first, at most a year later"
+
+	gpuRollupBasename = "gpu.json"
+)
+
+// isGPUQueue returns true if the given QUEUE_NAME is that of a gpu queue.
+func isGPUQueue(queue string) bool {
+	return strings.HasPrefix(queue, gpuPrefix)
+}
+
+// gpuRollup holds the number of hits in gpu queues amongst a set of hits, the
+// sums of their numeric fields, and the number of them of each USER_NAME.
+type gpuRollup struct {
+	rollupValues
+	Users map[string]int64 `json:"users"`
+}
+
+func newGPURollup() *gpuRollup {
+	return &gpuRollup{rollupValues: *newRollupValues(), Users: make(map[string]int64)}
+}
+
+// add adds the given details to us, if they're of a job in a gpu queue.
+func (g *gpuRollup) add(details *es.Details) {
+	if !isGPUQueue(details.QueueName) {
+		return
+	}
+
+	g.rollupValues.add(details)
+	g.Users[details.UserName]++
+}
+
+// merge adds the other gpuRollup to ours.
+func (g *gpuRollup) merge(other *gpuRollup) {
+	g.rollupValues.merge(&other.rollupValues)
+
+	for user, n := range other.Users {
+		g.Users[user] += n
+	}
+}
+
+// usage returns our totals as a GPUUsage.
+func (g *gpuRollup) usage() GPUUsage {
+	return GPUUsage{
+		Jobs:               g.DocCount,
+		Users:              len(g.Users),
+		CPUAvailSeconds:    g.Sums["AVAIL_CPU_TIME_SEC"],
+		CPUWastedSeconds:   g.Sums["WASTED_CPU_SECONDS"],
+		MemAvailMBSeconds:  g.Sums["MEM_REQUESTED_MB_SEC"],
+		MemWastedMBSeconds: g.Sums["WASTED_MB_SECONDS"],
+		RunTimeSeconds:     g.Sums["RUN_TIME_SEC"],
+		WastedCost:         g.WastedCost,
+	}
+}
+
+// write writes us to a gpu rollup file in the given directory.
+func (g *gpuRollup) write(dir string) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, gpuRollupBasename), data, rollupFilePerms)
+}
+
+// readGPURollup reads the gpu rollup file in the given directory.
+func readGPURollup(dir string) (*gpuRollup, error) {
+	data, err := os.ReadFile(filepath.Join(dir, gpuRollupBasename))
+	if err != nil {
+		return nil, err
+	}
+
+	g := newGPURollup()
+
+	return g, json.Unmarshal(data, g)
+}
+
+// GPUUsage is the total usage and waste of a set of jobs in gpu queues, and
+// how many different users ran them.
+type GPUUsage struct {
+	Jobs               int64   `json:"jobs"`
+	Users              int     `json:"users"`
+	CPUAvailSeconds    float64 `json:"cpu_avail_sec"`
+	CPUWastedSeconds   float64 `json:"cpu_wasted_sec"`
+	MemAvailMBSeconds  float64 `json:"mem_avail_mb_sec"`
+	MemWastedMBSeconds float64 `json:"mem_wasted_mb_sec"`
+	RunTimeSeconds     float64 `json:"run_time_sec"`
+	WastedCost         float64 `json:"wasted_cost"`
+}
+
+// GPUDay is the GPUUsage of one day.
+type GPUDay struct {
+	Day string `json:"day"`
+	GPUUsage
+}
+
+// GPUReport is the response to a GPUReport() call: the GPUUsage of a BOM (or
+// all BOMs) on each day from From to To (inclusive, YYYY-MM-DD), their Total,
+// and how many gpu jobs each user ran over all those days.
+type GPUReport struct {
+	BOM      string           `json:"bom,omitempty"`
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Days     []*GPUDay        `json:"days"`
+	Total    GPUUsage         `json:"total"`
+	UserJobs map[string]int64 `json:"user_jobs"`
+}
+
+// WriteJSON writes the report to w as indented JSON.
+func (r *GPUReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
+
+// gpuReport returns a GPUReport of the given BOM and days, getting the
+// gpuRollup of each day from the given function.
+func gpuReport(ctx context.Context, bom string, first, last time.Time,
+	gpuRollupOfDay func(day time.Time) (*gpuRollup, error)) (*GPUReport, error) {
+	first, last = truncateToDay(first), truncateToDay(last)
+
+	if !validReportRange(first, last) {
+		return nil, Error{Msg: ErrGPUReportRange}
+	}
+
+	report := &GPUReport{
+		BOM:  bom,
+		From: first.Format(time.DateOnly),
+		To:   last.Format(time.DateOnly),
+		Days: []*GPUDay{},
+	}
+
+	total := newGPURollup()
+
+	for day := first; !day.After(last); day = day.Add(oneDay) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		g, err := gpuRollupOfDay(day)
+		if err != nil {
+			return nil, err
+		}
+
+		report.Days = append(report.Days, &GPUDay{Day: day.Format(time.DateOnly), GPUUsage: g.usage()})
+		total.merge(g)
+	}
+
+	report.Total = total.usage()
+	report.UserJobs = total.Users
+
+	return report, nil
+}
+
+// GPUReport returns the GPUUsage of jobs in gpu queues of the given cluster's
+// (or the default cluster's, if "") BOM (or all BOMs, if ""), for each day from
+// first to last (inclusive).
+//
+// Each day of a BOM is answered from its gpu rollup file where possible,
+// otherwise (eg. for days backfilled before gpu rollups existed, or only with
+// hour segments so far) by reading that day's gpu queue hits.
+func (d *DB) GPUReport(ctx context.Context, cluster, bom string, first, last time.Time) (*GPUReport, error) {
+	if err := d.bomSelection.check(bom); err != nil {
+		return nil, err
+	}
+
+	cluster, err := d.resolveReportCluster(cluster, first)
+	if err != nil {
+		return nil, err
+	}
+
+	return gpuReport(ctx, bom, first, last, func(day time.Time) (*gpuRollup, error) {
+		boms, err := d.bomsOfDay(ctx, cluster, bom, day)
+		if err != nil {
+			return nil, err
+		}
+
+		g := newGPURollup()
+
+		for _, b := range boms {
+			bomG, err := d.gpuRollupOfDay(ctx, cluster, b, day)
+			if err != nil {
+				return nil, err
+			}
+
+			g.merge(bomG)
+		}
+
+		return g, nil
+	})
+}
+
+// bomsOfDay returns the given BOM, or if it's blank, all the BOMs of the given
+// cluster that have hits on the given day.
+func (d *DB) bomsOfDay(ctx context.Context, cluster, bom string, day time.Time) ([]string, error) {
+	if bom != "" {
+		return []string{bom}, nil
+	}
+
+	return d.DistinctValues(bomDayQuery(ctx, cluster, "", day), distinctBOM)
+}
+
+// gpuRollupOfDay reads the gpu rollup file of the given cluster's BOM on the
+// given day, like rollupOfDay(), falling back on reading the day's gpu queue
+// hits if there isn't one.
+func (d *DB) gpuRollupOfDay(ctx context.Context, cluster, bom string, day time.Time) (*gpuRollup, error) {
+	if bomDir, ok := d.completeDayBOMDir(day, clusterBOMDir(cluster, sanitiseBOMForFileSystem(bom))); ok {
+		g, err := readGPURollup(bomDir)
+		if err == nil {
+			return g, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		if bomDirMissing(bomDir) {
+			return newGPURollup(), nil
+		}
+	}
+
+	return scrolledGPURollup(d, gpuDayQuery(ctx, cluster, bom, day))
+}
+
+// gpuDayQuery is like bomDayQuery(), but only for hits in gpu queues.
+func gpuDayQuery(ctx context.Context, cluster, bom string, day time.Time) *es.Query {
+	query := bomDayQuery(ctx, cluster, bom, day)
+	query.Query.Bool.Filter = append(query.Query.Bool.Filter, map[string]es.MapStringStringOrMap{
+		"prefix": map[string]interface{}{"QUEUE_NAME": gpuPrefix},
+	})
+
+	return query
+}
+
+// scrolledGPURollup returns a gpuRollup of the hits of the given query.
+func scrolledGPURollup(b Backend, query *es.Query) (*gpuRollup, error) {
+	g := newGPURollup()
+
+	return g, addScrolledHits(b, query, g.add)
+}
@@ -0,0 +1,209 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestGPU(t *testing.T) {
+	Convey("A gpuRollup only adds hits in gpu queues, and can be written and read back", t, func() {
+		g := newGPURollup()
+		g.add(&es.Details{QueueName: "normal", UserName: "a", RunTimeSec: 1})
+		g.add(&es.Details{QueueName: "gpu-normal", UserName: "a", RunTimeSec: 2})
+		g.add(&es.Details{QueueName: "gpu-huge", UserName: "b", RunTimeSec: 3})
+
+		So(g.usage(), ShouldResemble, GPUUsage{Jobs: 2, Users: 2, RunTimeSeconds: 5})
+		So(g.Users, ShouldResemble, map[string]int64{"a": 1, "b": 1})
+
+		dir := t.TempDir()
+		So(g.write(dir), ShouldBeNil)
+
+		g2, err := readGPURollup(dir)
+		So(err, ShouldBeNil)
+		So(g2, ShouldResemble, g)
+
+		g2.merge(g)
+		So(g2.usage().Jobs, ShouldEqual, 4)
+		So(g2.Users["a"], ShouldEqual, 2)
+
+		_, err = readGPURollup(t.TempDir())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given a database with stored hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		first, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		last := first.Add(oneDay)
+		result := makeResult(first, last.Add(10*time.Second))
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for _, hit := range result.HitSet.Hits {
+			hitCh <- &hit
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		ctx := context.Background()
+
+		expected := func(bom string) (map[string]*gpuRollup, *gpuRollup) {
+			days := make(map[string]*gpuRollup)
+			total := newGPURollup()
+
+			for _, hit := range result.HitSet.Hits {
+				if bom != "" && hit.Details.BOM != bom {
+					continue
+				}
+
+				day := time.Unix(hit.Details.Timestamp, 0).UTC().Format(time.DateOnly)
+				if days[day] == nil {
+					days[day] = newGPURollup()
+				}
+
+				days[day].add(hit.Details)
+				total.add(hit.Details)
+			}
+
+			return days, total
+		}
+
+		checkReport := func(report *GPUReport, bom string) {
+			days, total := expected(bom)
+
+			So(report.BOM, ShouldEqual, bom)
+			So(report.From, ShouldEqual, "2024-02-04")
+			So(report.To, ShouldEqual, "2024-02-05")
+			So(report.Days, ShouldHaveLength, 2)
+
+			for _, gd := range report.Days {
+				exp := days[gd.Day].usage()
+				So(gd.Jobs, ShouldBeGreaterThan, 0)
+				So(gd.Jobs, ShouldEqual, exp.Jobs)
+				So(gd.Users, ShouldEqual, exp.Users)
+				So(gd.CPUWastedSeconds, ShouldAlmostEqual, exp.CPUWastedSeconds, 0.001)
+				So(gd.WastedCost, ShouldAlmostEqual, exp.WastedCost, 0.000001)
+			}
+
+			So(report.Total.Jobs, ShouldEqual, total.DocCount)
+			So(report.UserJobs, ShouldResemble, total.Users)
+		}
+
+		Convey("Backfill writes gpu rollups", func() {
+			g, errr := readGPURollup(filepath.Join(config.Directory, "2024", "02", "04", "bomB"))
+			So(errr, ShouldBeNil)
+
+			days, _ := expected("bomB")
+			So(g.usage(), ShouldResemble, days["2024-02-04"].usage())
+		})
+
+		Convey("You can get a GPUReport of a BOM, or all BOMs, from the gpu rollups", func() {
+			report, errg := db.GPUReport(ctx, "", "bomB", first, last)
+			So(errg, ShouldBeNil)
+			checkReport(report, "bomB")
+
+			report, errg = db.GPUReport(ctx, "", "", first, last)
+			So(errg, ShouldBeNil)
+			checkReport(report, "")
+		})
+
+		Convey("Days without gpu rollups are reported from their hits", func() {
+			for _, day := range []string{"04", "05"} {
+				dayDir := filepath.Join(config.Directory, "2024", "02", day)
+
+				entries, errr := os.ReadDir(dayDir)
+				So(errr, ShouldBeNil)
+
+				for _, entry := range entries {
+					if entry.IsDir() && strings.HasPrefix(entry.Name(), "bom") {
+						So(os.Remove(filepath.Join(dayDir, entry.Name(), gpuRollupBasename)), ShouldBeNil)
+					}
+				}
+			}
+
+			report, errg := db.GPUReport(ctx, "", "bomB", first, last)
+			So(errg, ShouldBeNil)
+			checkReport(report, "bomB")
+
+			report, errg = db.GPUReport(ctx, "", "", first, last)
+			So(errg, ShouldBeNil)
+			checkReport(report, "")
+		})
+
+		Convey("Days with no hits have zero usage", func() {
+			report, errg := db.GPUReport(ctx, "", "", first.Add(-oneDay), first.Add(-oneDay))
+			So(errg, ShouldBeNil)
+			So(report.Days, ShouldResemble, []*GPUDay{{Day: "2024-02-03"}})
+			So(report.UserJobs, ShouldBeEmpty)
+		})
+
+		Convey("Invalid date ranges and cancelled contexts are errors", func() {
+			_, errg := db.GPUReport(ctx, "", "", last, first)
+			So(errg, ShouldNotBeNil)
+			So(errg.Error(), ShouldEqual, ErrGPUReportRange)
+
+			_, errg = db.GPUReport(ctx, "other", "", first, last)
+			So(errg, ShouldNotBeNil)
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			_, errg = db.GPUReport(cancelled, "", "", first, last)
+			So(errg, ShouldEqual, context.Canceled)
+		})
+	})
+}
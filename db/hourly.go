@@ -0,0 +1,296 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrHourlyBackend = "hourly backfills are only supported for the flat backend"
+
+	hourSegmentFormat    = "h%02d"
+	hourSegmentSeparator = "-"
+	hourSuccessSeparator = "_"
+)
+
+// BackfillHours is like Backfill(), but for the day of the given now time,
+// which usually hasn't finished yet. Each hour of that day that finished before
+// now is stored as a separate "segment" of hits in that day's directory, and
+// is skipped if it was already stored, so you can call this repeatedly (eg.
+// hourly) to top up the day. Queries of a DB treat the segments as part of
+// their day.
+//
+// Nothing is done if the day was already completely backfilled. Once the day
+// is over, Backfill() it as normal; that replaces its segments with a single
+// complete day.
+//
+// Only the flat Backend is supported, and segments are not put in any
+// configured ObjectStore.
+func BackfillHours(client Scroller, config Config, now time.Time) error {
+	if err := config.checkWritable(); err != nil {
+		return err
+	}
+
+	if config.Backend != "" && config.Backend != BackendFlat {
+		return Error{Msg: ErrHourlyBackend, cause: config.Backend}
+	}
+
+	d := newDBStruct(config, true)
+
+	defer logSkippedHits(d)
+
+	day := now.UTC().Truncate(oneDay)
+
+	done, err := dayAlreadyBackfilled(d, filepath.Join(d.dateFolder(day), successBasename))
+	if err != nil || done {
+		return err
+	}
+
+	hb := hourBackfiller{d: d}
+
+	for hour := day; !hour.Add(time.Hour).After(now); hour = hour.Add(time.Hour) {
+		needed, err := hb.startDay(hour)
+		if err != nil {
+			return err
+		}
+
+		if !needed {
+			continue
+		}
+
+		if _, err = queryElasticAndStoreLocally(client, hb, hour, hour.Add(time.Hour),
+			config.ClustersOrDefault()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hourBackfiller is a dayBackfiller that stores a single hour's hits as a
+// segment of a DB's day. The "day" its methods take is the start of the hour.
+type hourBackfiller struct {
+	d *DB
+}
+
+// startDay returns false if the given hour was already stored. Otherwise it
+// removes any of its segment files left by a previous crashed backfill.
+func (h hourBackfiller) startDay(hour time.Time) (bool, error) {
+	dayDir := h.d.dateFolder(hour)
+	segment := hourSegment(hour)
+
+	if fileExists(hourSuccessPath(dayDir, segment)) {
+		return false, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dayDir, "*", segment+hourSegmentSeparator+"*"))
+	if err != nil {
+		return false, err
+	}
+
+	return true, removeAll(paths...)
+}
+
+// storeDay stores the hits from the channel in files named after the given
+// hour's segment, in the day/BOM directories of our DB.
+func (h hourBackfiller) storeDay(hour time.Time, hitCh chan *es.Hit) error {
+	return h.d.storeIn(&flatDBSet{root: h.d.dir, prefix: hourSegment(hour) + hourSegmentSeparator}, hitCh)
+}
+
+// finishDay creates the success sentinel file for the given hour.
+func (h hourBackfiller) finishDay(hour time.Time) error {
+	return recordSuccess(hourSuccessPath(h.d.dateFolder(hour), hourSegment(hour)))
+}
+
+// hourSegment returns the name of the segment that holds the hits of the given
+// hour, eg. "h13".
+func hourSegment(hour time.Time) string {
+	return fmt.Sprintf(hourSegmentFormat, hour.UTC().Hour())
+}
+
+// hourSuccessPath returns the path of the success sentinel file of the given
+// segment of the given day directory.
+func hourSuccessPath(dayDir, segment string) string {
+	return filepath.Join(dayDir, successBasename+hourSuccessSeparator+segment)
+}
+
+// isHourSuccessFile returns true if the given path is that of an hour
+// segment's success sentinel file.
+func isHourSuccessFile(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), successBasename+hourSuccessSeparator)
+}
+
+// segmentOfIndex returns the segment the given index file belongs to, and true,
+// if it belongs to one.
+func segmentOfIndex(path string) (string, bool) {
+	segment, _, found := strings.Cut(filepath.Base(path), hourSegmentSeparator)
+
+	return segment, found
+}
+
+// indexIsLoadable returns true if the given index file should be loaded: if we
+// don't only load successfully backfilled days, or it belongs to one, or it
+// belongs to a successfully stored hour segment of a day that isn't complete
+// yet. In the latter cases, its day is noted as partial.
+func (d *DB) indexIsLoadable(path string) bool {
+	dayDir := filepath.Dir(filepath.Dir(path))
+	segment, isSegment := segmentOfIndex(path)
+
+	if !isSegment {
+		return !d.checkBackfillSuccess || fileExists(filepath.Join(dayDir, successBasename))
+	}
+
+	if d.checkBackfillSuccess && (fileExists(filepath.Join(dayDir, successBasename)) ||
+		!fileExists(hourSuccessPath(dayDir, segment))) {
+		return false
+	}
+
+	d.muDateBOMDirs.Lock()
+	d.partialDays[dayDir] = true
+	d.muDateBOMDirs.Unlock()
+
+	return true
+}
+
+// isPartialDay returns true if we loaded hour segments of the given day
+// directory.
+func (d *DB) isPartialDay(dayDir string) bool {
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	return d.partialDays[dayDir]
+}
+
+// partialDayDirs returns the directories of all the days we loaded hour
+// segments of.
+func (d *DB) partialDayDirs() []string {
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	dirs := make([]string, 0, len(d.partialDays))
+
+	for dir := range d.partialDays {
+		dirs = append(dirs, dir)
+	}
+
+	return dirs
+}
+
+// reloadDay replaces our loaded indexes of the given day (eg. a partial one)
+// with those currently loadable from its directory, which will include any
+// newly stored hour segments, or the complete day if it has since been
+// backfilled. The new indexes are loaded before the old ones are replaced, so
+// queries never see the day without them. Returns true if the day has any
+// indexes.
+func (d *DB) reloadDay(dayDir string) bool {
+	fresh := d.newDayLoader()
+
+	if err := fresh.loadAllFlatIndexes(dayDir); err != nil {
+		slog.Error("reloading day failed", "dir", dayDir, "err", err)
+
+		return true
+	}
+
+	d.muDateBOMDirs.Lock()
+	defer d.muDateBOMDirs.Unlock()
+
+	d.forgetDay(d.canonicalPath(dayDir))
+
+	for dir, fis := range fresh.dateBOMDirs {
+		d.dateBOMDirs[dir] = fis
+	}
+
+	for dir, real := range fresh.dayDirs {
+		d.dayDirs[dir] = real
+	}
+
+	for dir, paths := range fresh.lazyPaths {
+		d.lazyPaths[dir] = paths
+	}
+
+	if fresh.partialDays[dayDir] {
+		d.partialDays[dayDir] = true
+	} else {
+		delete(d.partialDays, dayDir)
+	}
+
+	return len(fresh.dateBOMDirs) > 0 || len(fresh.lazyPaths) > 0
+}
+
+// newDayLoader returns a new DB with our Config that shares our open files and
+// lazily loaded indexes, in to which reloadDay() can load a day's indexes
+// without affecting our own.
+func (d *DB) newDayLoader() *DB {
+	fresh := newDBStruct(d.config, d.checkBackfillSuccess)
+	fresh.openFiles = d.openFiles
+	fresh.lazyLoaded = d.lazyLoaded
+
+	if d.lazyLoaded != nil {
+		fresh.lazyPaths = make(map[string][]string)
+	}
+
+	return fresh
+}
+
+// forgetDay removes our loaded indexes of the given day directory, and closes
+// any of their data files we have open. You must hold the muDateBOMDirs lock.
+func (d *DB) forgetDay(dayDir string) {
+	prefix := dayDir + string(filepath.Separator)
+
+	for dir, fis := range d.dateBOMDirs {
+		if !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+
+		for _, fi := range fis {
+			d.openFiles.forget(fi.dataPath)
+		}
+
+		delete(d.dateBOMDirs, dir)
+	}
+
+	for dir, paths := range d.lazyPaths {
+		if !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+
+		for _, path := range paths {
+			d.openFiles.forget(dataPathOfIndex(path))
+		}
+
+		delete(d.lazyPaths, dir)
+		d.lazyLoaded.Remove(dir)
+	}
+
+	delete(d.dayDirs, dayDir)
+}
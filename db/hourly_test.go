@@ -0,0 +1,218 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// hourlyScroller is a Scroller that returns a hit at the start of every hour of
+// the queried time range.
+type hourlyScroller struct {
+	mu      sync.Mutex
+	queries int
+}
+
+func (h *hourlyScroller) Scroll(query *es.Query, cb es.HitsCallBack) (*es.Result, error) {
+	h.mu.Lock()
+	h.queries++
+	h.mu.Unlock()
+
+	timeRange := query.Query.Bool.Filter[1]["range"]["timestamp"].(map[string]string) //nolint:errcheck,forcetypeassert
+
+	gte, err := time.Parse(time.RFC3339, timeRange["gte"])
+	if err != nil {
+		return nil, err
+	}
+
+	lt, err := time.Parse(time.RFC3339, timeRange["lt"])
+	if err != nil {
+		return nil, err
+	}
+
+	for hour := gte; hour.Before(lt); hour = hour.Add(time.Hour) {
+		cb(&es.Hit{ID: hour.String(), Details: &es.Details{
+			BOM:            "Human Genetics",
+			AccountingName: "a",
+			UserName:       "u",
+			QueueName:      "q",
+			Timestamp:      hour.Unix(),
+		}})
+	}
+
+	return &es.Result{}, nil
+}
+
+func (h *hourlyScroller) numQueries() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.queries
+}
+
+func TestBackfillHours(t *testing.T) {
+	day := time.Date(2024, 06, 1, 0, 0, 0, 0, time.UTC)
+	dayQuery := func() *es.Query {
+		query := rangeQuery(day, day.Add(oneDay))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+		return query
+	}
+
+	countEventually := func(db *DB, expected int) int {
+		deadline := time.Now().Add(5 * time.Second)
+
+		count, err := db.Count(dayQuery())
+		So(err, ShouldBeNil)
+
+		for count != expected && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+
+			count, err = db.Count(dayQuery())
+			So(err, ShouldBeNil)
+		}
+
+		return count
+	}
+
+	Convey("Given a mock elasticsearch client, you can BackfillHours() of an unfinished day", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		dir := t.TempDir()
+		scroller := &hourlyScroller{}
+		config := Config{Directory: dir, UpdateFrequency: time.Hour}
+		dayDir := filepath.Join(dir, "2024", "06", "01")
+		bomDir := filepath.Join(dayDir, "Human Genetics")
+
+		err := BackfillHours(scroller, config, day.Add(3*time.Hour+30*time.Minute))
+		So(err, ShouldBeNil)
+		So(scroller.numQueries(), ShouldEqual, 3)
+
+		for _, segment := range []string{"h00", "h01", "h02"} {
+			So(fileExists(hourSuccessPath(dayDir, segment)), ShouldBeTrue)
+			So(fileExists(filepath.Join(bomDir, segment+"-0.index")), ShouldBeTrue)
+		}
+
+		So(fileExists(hourSuccessPath(dayDir, "h03")), ShouldBeFalse)
+		So(fileExists(filepath.Join(dayDir, successBasename)), ShouldBeFalse)
+		So(fileExists(filepath.Join(bomDir, rollupBasename)), ShouldBeFalse)
+
+		Convey("Repeating it only stores newly finished hours", func() {
+			err = BackfillHours(scroller, config, day.Add(3*time.Hour+45*time.Minute))
+			So(err, ShouldBeNil)
+			So(scroller.numQueries(), ShouldEqual, 3)
+
+			err = BackfillHours(scroller, config, day.Add(4*time.Hour))
+			So(err, ShouldBeNil)
+			So(scroller.numQueries(), ShouldEqual, 4)
+		})
+
+		Convey("A crashed hour is stored again from scratch", func() {
+			leftover := filepath.Join(bomDir, "h03-7.index")
+			So(os.WriteFile(leftover, []byte("partial"), dbFilePerms), ShouldBeNil)
+
+			db, errn := New(config, true)
+			So(errn, ShouldBeNil)
+			So(countEventually(db, 3), ShouldEqual, 3)
+			So(db.Close(), ShouldBeNil)
+
+			err = BackfillHours(scroller, config, day.Add(4*time.Hour))
+			So(err, ShouldBeNil)
+			So(fileExists(leftover), ShouldBeFalse)
+			So(fileExists(filepath.Join(bomDir, "h03-0.index")), ShouldBeTrue)
+		})
+
+		Convey("A DB treats the stored hours as part of their day", func() {
+			db, errn := New(config, true)
+			So(errn, ShouldBeNil)
+
+			defer db.Close()
+
+			So(countEventually(db, 3), ShouldEqual, 3)
+			So(db.isPartialDay(dayDir), ShouldBeTrue)
+			So(db.DataThrough(), ShouldEqual, day.Add(-oneDay))
+
+			_, ok, errr := db.rollupOfDay(day, "Human Genetics")
+			So(errr, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			Convey("and sees newly stored hours soon after", func() {
+				err = BackfillHours(scroller, config, day.Add(5*time.Hour))
+				So(err, ShouldBeNil)
+				So(countEventually(db, 5), ShouldEqual, 5)
+			})
+
+			Convey("and the whole day once it is backfilled, replacing the hours", func() {
+				_, err = Backfill(scroller, config, day.Add(oneDay+30*time.Minute), oneDay)
+				So(err, ShouldBeNil)
+				So(countEventually(db, 24), ShouldEqual, 24)
+				So(db.isPartialDay(dayDir), ShouldBeFalse)
+				So(db.DataThrough(), ShouldEqual, day)
+				So(fileExists(filepath.Join(bomDir, "h00-0.index")), ShouldBeFalse)
+				So(fileExists(hourSuccessPath(dayDir, "h00")), ShouldBeFalse)
+				So(fileExists(filepath.Join(bomDir, rollupBasename)), ShouldBeTrue)
+
+				queries := scroller.numQueries()
+				err = BackfillHours(scroller, config, day.Add(6*time.Hour))
+				So(err, ShouldBeNil)
+				So(scroller.numQueries(), ShouldEqual, queries)
+			})
+		})
+
+		Convey("A DB that doesn't check for backfill success reloads the hours on update", func() {
+			db, errn := New(config, false)
+			So(errn, ShouldBeNil)
+
+			defer db.Close()
+
+			So(countEventually(db, 3), ShouldEqual, 3)
+
+			version := db.DataVersion()
+
+			err = BackfillHours(scroller, config, day.Add(5*time.Hour))
+			So(err, ShouldBeNil)
+
+			So(db.Reload(), ShouldBeNil)
+			So(countEventually(db, 5), ShouldEqual, 5)
+			So(db.DataVersion(), ShouldNotEqual, version)
+		})
+	})
+
+	Convey("You can't BackfillHours() with the sqlite backend", t, func() {
+		err := BackfillHours(&hourlyScroller{}, Config{Directory: t.TempDir(), Backend: BackendSQLite}, day)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, ErrHourlyBackend)
+	})
+}
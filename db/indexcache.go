@@ -0,0 +1,390 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	indexCacheBasename  = "index.cache"
+	indexCacheMagic     = "farmer index cache v2\n"
+	indexCacheNoJobs    = -1
+	indexCacheNoSection = 1<<32 - 1
+
+	errIndexCacheInvalid = "index cache is invalid"
+	errIndexCacheStale   = "index cache is stale"
+)
+
+// indexCacheRecord is the header of each index file stored in an index cache.
+// After it in the cache come the index file contents, followed (if JobsSize
+// isn't indexCacheNoJobs) by the size and contents of the index file's section
+// of its job prefix index.
+type indexCacheRecord struct {
+	IndexSize    int64
+	IndexModTime int64
+	JobsSize     int64
+	JobsModTime  int64
+}
+
+// newIndexCacheRecord stats the given index file and its job prefix index to
+// create an indexCacheRecord for them.
+func newIndexCacheRecord(indexPath string) (*indexCacheRecord, error) {
+	info, err := os.Stat(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &indexCacheRecord{
+		IndexSize:    info.Size(),
+		IndexModTime: info.ModTime().UnixNano(),
+		JobsSize:     indexCacheNoJobs,
+	}
+
+	info, err = os.Stat(jobPrefixPath(indexPath))
+	if err == nil {
+		record.JobsSize = info.Size()
+		record.JobsModTime = info.ModTime().UnixNano()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// loadInitialFlatIndexes loads all our flat indexes, from our index cache if it
+// is up to date, otherwise by finding and parsing every index file. Days in our
+// extraDirs that weren't in our index cache are then loaded.
+func (d *DB) loadInitialFlatIndexes() error {
+	if d.lazyLoaded != nil {
+		return d.loadOwnAndExtraDirs()
+	}
+
+	d.indexCachePath = filepath.Join(d.dir, indexCacheBasename)
+
+	err := d.loadIndexCache()
+	if err == nil {
+		if err = d.loadExtraDirs(); err != nil {
+			return err
+		}
+
+		d.loadLatestFlatIndexes()
+
+		return nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		slog.Info("not using index cache", "err", err)
+	}
+
+	return d.loadOwnAndExtraDirs()
+}
+
+// loadOwnAndExtraDirs loads all the loadable index files in our own directory,
+// then those of days that weren't in it from our extraDirs.
+func (d *DB) loadOwnAndExtraDirs() error {
+	if err := d.loadAllFlatIndexes(d.dir); err != nil {
+		return err
+	}
+
+	return d.loadExtraDirs()
+}
+
+// loadIndexCache loads the flat indexes stored in our index cache file. If any
+// of the index files it was made from have changed or been deleted since, an
+// error is returned and no indexes are loaded.
+func (d *DB) loadIndexCache() error {
+	f, err := os.Open(d.indexCachePath)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, d.bufferSize)
+
+	magic := make([]byte, len(indexCacheMagic))
+	if _, err = io.ReadFull(br, magic); err != nil || string(magic) != indexCacheMagic {
+		return Error{Msg: errIndexCacheInvalid}
+	}
+
+	dateBOMDirs := make(map[string][]*flatIndex)
+	dayDirs := make(map[string]string)
+
+	for {
+		indexPath, fi, errr := d.readIndexCacheEntry(br)
+		if errors.Is(errr, io.EOF) {
+			break
+		}
+
+		if errr != nil {
+			return errr
+		}
+
+		subDir := filepath.Dir(indexPath)
+		key := d.canonicalPath(subDir)
+		dateBOMDirs[key] = append(dateBOMDirs[key], fi)
+		noteDayDir(dayDirs, subDir, key)
+	}
+
+	if len(dateBOMDirs) == 0 {
+		return Error{Msg: errIndexCacheInvalid, cause: "no indexes"}
+	}
+
+	return d.setDateBOMDirs(dateBOMDirs, dayDirs)
+}
+
+// readIndexCacheEntry reads the next index file path, record and contents from
+// the given index cache reader, returning io.EOF if there are no more.
+func (d *DB) readIndexCacheEntry(br *bufio.Reader) (string, *flatIndex, error) {
+	var pathLen uint32
+	if err := binary.Read(br, binary.BigEndian, &pathLen); err != nil {
+		return "", nil, err
+	}
+
+	relPath := make([]byte, pathLen)
+
+	var cached indexCacheRecord
+
+	if _, err := io.ReadFull(br, relPath); err != nil {
+		return "", nil, Error{Msg: errIndexCacheInvalid, cause: err.Error()}
+	}
+
+	if err := binary.Read(br, binary.BigEndian, &cached); err != nil {
+		return "", nil, Error{Msg: errIndexCacheInvalid, cause: err.Error()}
+	}
+
+	indexPath := filepath.Join(d.dir, string(relPath))
+
+	current, err := newIndexCacheRecord(indexPath)
+	if err != nil || *current != cached {
+		return "", nil, Error{Msg: errIndexCacheStale, cause: indexPath}
+	}
+
+	fi := newEmptyFlatIndex(indexPath)
+	sum := crc32.New(castagnoli)
+
+	if err = fi.readEntries(bufio.NewReader(io.TeeReader(io.LimitReader(br, cached.IndexSize), sum))); err != nil {
+		return "", nil, Error{Msg: errIndexCacheInvalid, cause: err.Error()}
+	}
+
+	if err = fi.verifyChecksums(indexPath, sum.Sum32()); err != nil {
+		return "", nil, err
+	}
+
+	if cached.JobsSize == indexCacheNoJobs {
+		return indexPath, fi, nil
+	}
+
+	var sectionSize uint32
+
+	if err = binary.Read(br, binary.BigEndian, &sectionSize); err != nil {
+		return "", nil, Error{Msg: errIndexCacheInvalid, cause: err.Error()}
+	}
+
+	if sectionSize == indexCacheNoSection {
+		return indexPath, fi, nil
+	}
+
+	prefixes := make([]byte, sectionSize)
+
+	if _, err = io.ReadFull(br, prefixes); err != nil {
+		return "", nil, Error{Msg: errIndexCacheInvalid, cause: err.Error()}
+	}
+
+	return indexPath, fi, fi.setJobPrefixes(indexPath, prefixes)
+}
+
+// setDateBOMDirs sets our loaded flatIndexes (and the dayDirs of those loaded
+// from our extraDirs) to the given ones, and updates our latest date
+// accordingly.
+func (d *DB) setDateBOMDirs(dateBOMDirs map[string][]*flatIndex, dayDirs map[string]string) error {
+	d.muDateBOMDirs.Lock()
+	defer d.muDateBOMDirs.Unlock()
+
+	for subDir := range dateBOMDirs {
+		if err := d.updateLatestDate(filepath.Dir(subDir)); err != nil {
+			return err
+		}
+	}
+
+	d.dateBOMDirs = dateBOMDirs
+	d.dayDirs = dayDirs
+
+	return nil
+}
+
+// writeIndexCache writes the contents of the index files of all our currently
+// loaded flatIndexes to our index cache file, so that a future New() can load
+// them all from a single file. Does nothing if we don't have an index cache
+// path, or are read-only.
+func (d *DB) writeIndexCache() error {
+	if d.indexCachePath == "" || d.readOnly {
+		return nil
+	}
+
+	d.muIndexCache.Lock()
+	defer d.muIndexCache.Unlock()
+
+	tmp, err := os.CreateTemp(d.dir, indexCacheBasename+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriterSize(tmp, d.bufferSize)
+
+	err = d.writeIndexCacheEntries(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if errc := tmp.Close(); err == nil {
+		err = errc
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), d.indexCachePath)
+}
+
+func (d *DB) writeIndexCacheEntries(w io.Writer) error {
+	if _, err := io.WriteString(w, indexCacheMagic); err != nil {
+		return err
+	}
+
+	for _, indexPath := range d.loadedIndexPaths() {
+		if err := d.writeIndexCacheEntry(w, indexPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadedIndexPaths returns the paths of all our loaded index files, except hour
+// segments, which are always loaded from their day directory instead.
+func (d *DB) loadedIndexPaths() []string {
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	var paths []string
+
+	for _, fis := range d.dateBOMDirs {
+		for _, fi := range fis {
+			path := strings.TrimSuffix(fi.dataPath, dataKind) + indexKind
+
+			if _, isSegment := segmentOfIndex(path); isSegment {
+				continue
+			}
+
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+func (d *DB) writeIndexCacheEntry(w io.Writer, indexPath string) error {
+	relPath, err := filepath.Rel(d.dir, indexPath)
+	if err != nil {
+		return err
+	}
+
+	record, err := newIndexCacheRecord(indexPath)
+	if err != nil {
+		return err
+	}
+
+	if err = binary.Write(w, binary.BigEndian, uint32(len(relPath))); err != nil {
+		return err
+	}
+
+	if _, err = io.WriteString(w, relPath); err != nil {
+		return err
+	}
+
+	if err = binary.Write(w, binary.BigEndian, record); err != nil {
+		return err
+	}
+
+	if err = copyFileContents(w, indexPath, record.IndexSize); err != nil {
+		return err
+	}
+
+	if record.JobsSize == indexCacheNoJobs {
+		return nil
+	}
+
+	return writeIndexCacheJobPrefixes(w, indexPath)
+}
+
+// writeIndexCacheJobPrefixes writes the size of the given index file's section
+// of its job prefix index, followed by the section, or just indexCacheNoSection
+// if it doesn't have one.
+func writeIndexCacheJobPrefixes(w io.Writer, indexPath string) error {
+	prefixes, err := jobPrefixSection(indexPath)
+	if err != nil {
+		return err
+	}
+
+	if prefixes == nil {
+		return binary.Write(w, binary.BigEndian, uint32(indexCacheNoSection))
+	}
+
+	if err = binary.Write(w, binary.BigEndian, uint32(len(prefixes))); err != nil {
+		return err
+	}
+
+	_, err = w.Write(prefixes)
+
+	return err
+}
+
+func copyFileContents(w io.Writer, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.CopyN(w, f, size)
+
+	return err
+}
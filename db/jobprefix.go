@@ -0,0 +1,201 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	jobPrefixBasename      = "prefixes." + jobPrefixKind
+	jobPrefixSectionHeader = 2 * lengthEncodeWidth
+)
+
+// jobPrefixPath returns the path of the job prefix index that holds the job
+// name prefixes of the entries of the given index file. There is one of these
+// for all the data files of a date/BOM directory (or hour segment of one).
+func jobPrefixPath(indexPath string) string {
+	prefix, _ := chunkOfIndex(indexPath)
+
+	return filepath.Join(filepath.Dir(indexPath), prefix+jobPrefixBasename)
+}
+
+// chunkOfIndex returns the file name prefix and data file number of the given
+// index file, eg. "h05-" and 3 for "h05-3.index".
+func chunkOfIndex(indexPath string) (string, uint32) {
+	name := strings.TrimSuffix(filepath.Base(indexPath), "."+indexKind)
+
+	i := len(name)
+	for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+		i--
+	}
+
+	num, _ := strconv.ParseUint(name[i:], 10, 32) //nolint:errcheck
+
+	return name[:i], uint32(num)
+}
+
+// addJobPrefixIndex makes us also write the job name prefix of each hit we
+// store to a job prefix index shared by all our data files, so that JOB_NAME
+// prefix filters can be applied to index entries before reading hit data.
+//
+// The index holds a section for each data file: its number and how many
+// prefixes it has (as uint32s), followed by the prefixes in the same order as
+// the entries of its index file.
+func (f *flatDB) addJobPrefixIndex() error {
+	fh, err := os.Create(filepath.Join(f.dir, f.prefix+jobPrefixBasename))
+	if err != nil {
+		return err
+	}
+
+	f.jobsF, f.jobsW = fh, bufio.NewWriterSize(fh, f.bufferSize)
+
+	return nil
+}
+
+// writeJobPrefixSection writes the job name prefixes of the hits stored in our
+// current data file to our job prefix index, if we have one, and sets our
+// jobsSum to their checksum.
+func (f *flatDB) writeJobPrefixSection() error {
+	if f.jobsW == nil {
+		return nil
+	}
+
+	f.jobsSum = crc32.Checksum(f.jobs, castagnoli)
+
+	for _, b := range [][]byte{
+		u32tob(uint32(f.dataFileIndex)),
+		u32tob(uint32(len(f.jobs) / jobPrefixWidth)),
+		f.jobs,
+	} {
+		if _, err := f.jobsW.Write(b); err != nil {
+			return err
+		}
+	}
+
+	f.jobs = f.jobs[:0]
+
+	return nil
+}
+
+// closeJobPrefixIndex flushes and closes our job prefix index, if we have one.
+func (f *flatDB) closeJobPrefixIndex() error {
+	if f.jobsW == nil {
+		return nil
+	}
+
+	f.jobsW.Flush()
+
+	err := f.jobsF.Close()
+	f.jobsF, f.jobsW = nil, nil
+
+	return err
+}
+
+// jobPrefixSection returns the job name prefixes of the entries of the given
+// index file from its date/BOM directory's job prefix index. Databases created
+// without job prefix indexes don't have one, in which case nil is returned.
+func jobPrefixSection(indexPath string) ([]byte, error) {
+	fh, err := os.Open(jobPrefixPath(indexPath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	_, chunk := chunkOfIndex(indexPath)
+	header := make([]byte, jobPrefixSectionHeader)
+
+	var offset int64
+
+	for {
+		if _, err = fh.ReadAt(header, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		num := binary.BigEndian.Uint32(header)
+		size := int64(binary.BigEndian.Uint32(header[lengthEncodeWidth:])) * jobPrefixWidth
+		offset += jobPrefixSectionHeader
+
+		if num == chunk {
+			prefixes := make([]byte, size)
+			_, err = fh.ReadAt(prefixes, offset)
+
+			return prefixes, err
+		}
+
+		offset += size
+	}
+}
+
+// loadJobPrefixes reads our job name prefixes from the job prefix index of our
+// date/BOM directory, so that JOB_NAME prefix filters can be applied to our
+// entries. Without one, we do nothing and such filters are only applied after
+// reading hit data.
+func (f *flatIndex) loadJobPrefixes(indexPath string) error {
+	prefixes, err := jobPrefixSection(indexPath)
+	if err != nil || prefixes == nil {
+		return err
+	}
+
+	return f.setJobPrefixes(indexPath, prefixes)
+}
+
+// setJobPrefixes gives each of our entries its job name prefix from the given
+// section of a job prefix index, after checking it against the checksum
+// recorded by verifyChecksums().
+func (f *flatIndex) setJobPrefixes(indexPath string, prefixes []byte) error {
+	if len(prefixes) != len(f.bomEntries)*jobPrefixWidth {
+		return checksumError(jobPrefixPath(indexPath), "wrong number of job prefixes")
+	}
+
+	if f.jobsSummed && crc32.Checksum(prefixes, castagnoli) != f.jobsSum {
+		return checksumError(jobPrefixPath(indexPath), "job prefixes")
+	}
+
+	for i, entry := range f.bomEntries {
+		entry.jobPrefix = prefixes[i*jobPrefixWidth : (i+1)*jobPrefixWidth : (i+1)*jobPrefixWidth]
+	}
+
+	return nil
+}
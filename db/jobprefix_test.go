@@ -0,0 +1,98 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestJobPrefixIndex(t *testing.T) {
+	Convey("Given hits stored by flatDBs over several data files", t, func() {
+		dir := t.TempDir()
+		segment := "h05" + hourSegmentSeparator
+		jobs := []string{"jobA", "nf-long-job-name", "jobC", "jobD", "jobE"}
+
+		store := func(prefix string, withIndex bool) {
+			fdb, err := newPrefixedFlatDB(dir, prefix, 1, bufferSize, IndexWidths{})
+			So(err, ShouldBeNil)
+
+			if withIndex {
+				So(fdb.addJobPrefixIndex(), ShouldBeNil)
+			}
+
+			for i, job := range jobs {
+				err = fdb.Store(&es.Hit{ID: job, Details: &es.Details{
+					AccountingName: "group", UserName: "user", JobName: job, Timestamp: int64(1707004800 + i),
+				}})
+				So(err, ShouldBeNil)
+			}
+
+			So(fdb.Finish(), ShouldBeNil)
+		}
+
+		Convey("no job prefix index is written by default", func() {
+			store("", false)
+
+			_, err := os.Stat(filepath.Join(dir, jobPrefixBasename))
+			So(err, ShouldNotBeNil)
+
+			fi, err := newFlatIndex(filepath.Join(dir, "0.index"), bufferSize)
+			So(err, ShouldBeNil)
+			So(fi.bomEntries, ShouldHaveLength, 1)
+			So(fi.bomEntries[0].jobPrefix, ShouldBeNil)
+		})
+
+		Convey("one job prefix index holds the prefixes of every data file", func() {
+			store("", true)
+			store(segment, true)
+
+			paths, err := filepath.Glob(filepath.Join(dir, "*."+jobPrefixKind))
+			So(err, ShouldBeNil)
+			So(paths, ShouldResemble, []string{
+				filepath.Join(dir, segment+jobPrefixBasename),
+				filepath.Join(dir, jobPrefixBasename),
+			})
+
+			for _, prefix := range []string{"", segment} {
+				for i, job := range jobs {
+					indexPath := filepath.Join(dir, prefix+strconv.Itoa(i)+"."+indexKind)
+					So(jobPrefixPath(indexPath), ShouldEqual, filepath.Join(dir, prefix+jobPrefixBasename))
+
+					fi, err := newFlatIndex(indexPath, bufferSize)
+					So(err, ShouldBeNil)
+					So(fi.bomEntries, ShouldHaveLength, 1)
+					So(fi.bomEntries[0].jobPrefix, ShouldResemble, jobNamePrefix(job))
+				}
+			}
+		})
+	})
+}
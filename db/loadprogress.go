@@ -0,0 +1,146 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// indexLoadProgressInterval is how often progress is logged while loading all
+// our index files.
+var indexLoadProgressInterval = 10 * time.Second //nolint:gochecknoglobals
+
+// indexLoadProgress tracks how many days have had all their BOM directories'
+// index files loaded, and the earliest day that all later days have been
+// loaded from.
+type indexLoadProgress struct {
+	mu        sync.Mutex
+	remaining map[string]int
+	days      []string
+	loaded    int
+	done      int
+	total     int
+}
+
+// newIndexLoadProgress returns an indexLoadProgress for loading the given
+// directories.
+func newIndexLoadProgress(dirs []indexDir) *indexLoadProgress {
+	remaining := make(map[string]int)
+
+	for _, id := range dirs {
+		remaining[filepath.Dir(id.dir)]++
+	}
+
+	days := make([]string, 0, len(remaining))
+	for day := range remaining {
+		days = append(days, day)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+
+	return &indexLoadProgress{remaining: remaining, days: days, total: len(remaining)}
+}
+
+// dirDone records that the given BOM directory has been loaded.
+func (p *indexLoadProgress) dirDone(dir string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	day := filepath.Dir(dir)
+
+	p.remaining[day]--
+
+	if p.remaining[day] != 0 {
+		return
+	}
+
+	p.done++
+
+	for p.loaded < len(p.days) && p.remaining[p.days[p.loaded]] == 0 {
+		p.loaded++
+	}
+}
+
+// counts returns how many days have been loaded, and how many there are.
+func (p *indexLoadProgress) counts() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.done, p.total
+}
+
+// loadedFrom returns the earliest day directory that all later day directories
+// have been loaded from, or blank if the most recent hasn't been loaded yet.
+func (p *indexLoadProgress) loadedFrom() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.loaded == 0 {
+		return ""
+	}
+
+	return p.days[p.loaded-1]
+}
+
+// log logs how many of our days have been loaded.
+func (p *indexLoadProgress) log() {
+	done, total := p.counts()
+
+	slog.Info(fmt.Sprintf("loaded %d/%d days", done, total))
+}
+
+// logUntilDone logs our progress every interval until you call the returned
+// function, which logs our final progress.
+func (p *indexLoadProgress) logUntilDone(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-ticker.C:
+				p.log()
+			case <-stop:
+				ticker.Stop()
+
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		p.log()
+	}
+}
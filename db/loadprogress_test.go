@@ -0,0 +1,105 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexLoadProgress(t *testing.T) {
+	Convey("Given directories of several days to load", t, func() {
+		b := &lockedBuffer{}
+
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.NewTextHandler(b, nil)))
+
+		defer slog.SetDefault(defaultLogger)
+
+		p := newIndexLoadProgress([]indexDir{
+			{dir: "/db/2024/01/01/bomA"},
+			{dir: "/db/2024/01/01/bomB"},
+			{dir: "/db/2024/01/02/bomA"},
+		})
+		So(p.total, ShouldEqual, 2)
+
+		Convey("Days are done once all their directories are", func() {
+			p.dirDone("/db/2024/01/01/bomA")
+			So(p.done, ShouldEqual, 0)
+
+			p.dirDone("/db/2024/01/02/bomA")
+			So(p.done, ShouldEqual, 1)
+
+			p.dirDone("/db/2024/01/01/bomB")
+			So(p.done, ShouldEqual, 2)
+		})
+
+		Convey("Progress is logged periodically and when done", func() {
+			stop := p.logUntilDone(time.Millisecond)
+
+			p.dirDone("/db/2024/01/02/bomA")
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !strings.Contains(b.String(), `msg="loaded 1/2 days"`) && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+
+			So(b.String(), ShouldContainSubstring, `msg="loaded 1/2 days"`)
+
+			p.dirDone("/db/2024/01/01/bomA")
+			p.dirDone("/db/2024/01/01/bomB")
+			stop()
+
+			So(b.String(), ShouldContainSubstring, `msg="loaded 2/2 days"`)
+		})
+	})
+}
+
+// lockedBuffer is a bytes.Buffer that is safe to write to from a logging
+// goroutine while being read.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.b.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.b.String()
+}
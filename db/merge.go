@@ -0,0 +1,237 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	ErrMergeCountMismatch = "merged day has a different number of hits to its source"
+
+	mergingBasename = ".merging"
+)
+
+// MergeConflict is a day that Merge() found in both database directories, and
+// so didn't copy.
+type MergeConflict struct {
+	// Day is the "YYYY/MM/DD" day.
+	Day string
+	// SrcHits and DstHits are the number of hits stored for the day in each
+	// directory. They're only set if Merge() was asked to verify counts.
+	SrcHits int
+	DstHits int
+}
+
+// MergeResult describes what Merge() did.
+type MergeResult struct {
+	// Copied are the "YYYY/MM/DD" days that were copied.
+	Copied    []string
+	Conflicts []MergeConflict
+}
+
+// Merge copies every successfully backfilled day in the src flat database
+// directory that isn't in the dst one to the dst one. Days present in both are
+// left alone and reported as conflicts.
+//
+// Each day is copied to a temporary directory in dst and only moved in to
+// place once complete, so you can merge in to the directory of a running
+// server.
+//
+// If verify is true, after each day is copied the number of hits in its dst
+// index files is checked against src, failing with ErrMergeCountMismatch if
+// they differ, and the number of hits in each conflicting day is reported.
+func Merge(src, dst string, verify bool) (*MergeResult, error) {
+	result := &MergeResult{}
+	tmpDir := filepath.Join(dst, mergingBasename)
+
+	defer os.RemoveAll(tmpDir)
+
+	err := forEachBackfilledDay(src, func(srcDayDir string) error {
+		rel, err := filepath.Rel(src, srcDayDir)
+		if err != nil {
+			return err
+		}
+
+		day := filepath.ToSlash(rel)
+		dstDayDir := filepath.Join(dst, rel)
+
+		if fileExists(dstDayDir) {
+			return result.addConflict(day, srcDayDir, dstDayDir, verify)
+		}
+
+		if err = mergeDay(srcDayDir, filepath.Join(tmpDir, rel), dstDayDir, copyFile, verify); err != nil {
+			return err
+		}
+
+		result.Copied = append(result.Copied, day)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// addConflict records the given day as a conflict, counting the hits in its
+// day directories if verify is true.
+func (m *MergeResult) addConflict(day, srcDayDir, dstDayDir string, verify bool) error {
+	conflict := MergeConflict{Day: day}
+
+	if verify {
+		var err error
+
+		if conflict.SrcHits, err = countDayHits(srcDayDir); err != nil {
+			return err
+		}
+
+		if conflict.DstHits, err = countDayHits(dstDayDir); err != nil {
+			return err
+		}
+	}
+
+	m.Conflicts = append(m.Conflicts, conflict)
+
+	return nil
+}
+
+// mergeDay copies the given src day directory to the given tmp directory using
+// the given fileCopier, verifying its hit count if desired, then renames it to
+// the given dst day directory.
+func mergeDay(srcDayDir, tmpDayDir, dstDayDir string, copier fileCopier, verify bool) error {
+	if err := os.RemoveAll(tmpDayDir); err != nil {
+		return err
+	}
+
+	if err := copyDir(srcDayDir, tmpDayDir, copier); err != nil {
+		return err
+	}
+
+	if verify {
+		if err := verifyDayHits(srcDayDir, tmpDayDir); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstDayDir), dbDirPerms); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpDayDir, dstDayDir)
+}
+
+// fileCopier copies the from file to the to path, like copyFile().
+type fileCopier func(from, to string) error
+
+// copyDir copies the regular files in the from directory tree to the to
+// directory using the given fileCopier.
+func copyDir(from, to string, copier fileCopier) error {
+	return filepath.WalkDir(from, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || !de.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+
+		return copier(path, filepath.Join(to, rel))
+	})
+}
+
+// copyFile copies the from file to the to path, creating its parent
+// directories.
+func copyFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), dbDirPerms); err != nil {
+		return err
+	}
+
+	r, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, dbFilePerms)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+
+		return err
+	}
+
+	return w.Close()
+}
+
+// verifyDayHits returns an ErrMergeCountMismatch Error if the given day
+// directories don't have the same number of hits.
+func verifyDayHits(srcDayDir, dstDayDir string) error {
+	srcHits, err := countDayHits(srcDayDir)
+	if err != nil {
+		return err
+	}
+
+	dstHits, err := countDayHits(dstDayDir)
+	if err != nil {
+		return err
+	}
+
+	if srcHits != dstHits {
+		return Error{Msg: ErrMergeCountMismatch, cause: fmt.Sprintf("%s: %d vs %d", srcDayDir, srcHits, dstHits)}
+	}
+
+	return nil
+}
+
+// countDayHits returns the number of hits in the index files of the given day
+// directory.
+func countDayHits(dayDir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dayDir, "*", "*."+indexKind))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, path := range paths {
+		fi, err := newFlatIndex(path, defaultBufferSize) //nolint:govet
+		if err != nil {
+			return 0, err
+		}
+
+		count += len(fi.bomEntries)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,85 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestMerge(t *testing.T) {
+	Convey("Given two backfilled databases with some days in common", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		mock := es.NewMock("some-indexes-*")
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+		src := Config{Directory: t.TempDir()}
+		dst := Config{Directory: t.TempDir()}
+
+		_, err := Backfill(mock, src, from, 2*oneDay)
+		So(err, ShouldBeNil)
+
+		_, err = Backfill(mock, dst, from, oneDay)
+		So(err, ShouldBeNil)
+
+		_, err = os.Stat(filepath.Join(dst.Directory, "2024", "05", "29"))
+		So(err, ShouldNotBeNil)
+
+		Convey("You can Merge() the missing days in to one", func() {
+			result, err := Merge(src.Directory, dst.Directory, true)
+			So(err, ShouldBeNil)
+			So(result.Copied, ShouldResemble, []string{"2024/05/29"})
+			So(result.Conflicts, ShouldResemble, []MergeConflict{
+				{Day: "2024/05/30", SrcHits: 1, DstHits: 1},
+				{Day: "2024/05/31", SrcHits: 1, DstHits: 1},
+			})
+
+			_, err = os.Stat(filepath.Join(dst.Directory, "2024", "05", "29", successBasename))
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(dst.Directory, mergingBasename))
+			So(err, ShouldNotBeNil)
+
+			problems, err := Fsck(dst)
+			So(err, ShouldBeNil)
+			So(problems, ShouldBeEmpty)
+
+			Convey("and merging again copies nothing", func() {
+				result, err = Merge(src.Directory, dst.Directory, false)
+				So(err, ShouldBeNil)
+				So(result.Copied, ShouldBeEmpty)
+				So(result.Conflicts, ShouldHaveLength, 3)
+				So(result.Conflicts[0].SrcHits, ShouldEqual, 0)
+			})
+		})
+	})
+}
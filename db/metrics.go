@@ -0,0 +1,137 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// scrollDoner is the part of a Backend needed to calculate metric
+// aggregations.
+type scrollDoner interface {
+	Scroll(query *es.Query) (*es.Result, error)
+	Done(poolKey int) bool
+}
+
+// metricAgg is a "stats" or "percentiles" aggregation of a numeric field.
+type metricAgg struct {
+	field       string
+	percentiles bool
+	percents    []float64
+}
+
+// newMetricAgg returns a metricAgg for the given query, or false if it isn't a
+// lone stats or keyed percentiles aggregation of a numeric field.
+func newMetricAgg(query *es.Query) (*metricAgg, bool) {
+	if query.Size != 0 || query.Aggs == nil || query.Query == nil {
+		return nil, false
+	}
+
+	stats, ok := aggsStats(query.Aggs)
+	if !ok || stats.Terms != nil || stats.MultiTerms != nil || len(stats.Aggs) > 0 {
+		return nil, false
+	}
+
+	var m *metricAgg
+
+	switch {
+	case stats.Stats != nil && stats.Percentiles == nil:
+		m = &metricAgg{field: stats.Stats.Field}
+	case stats.Percentiles != nil && stats.Stats == nil:
+		if stats.Percentiles.Keyed != nil && !*stats.Percentiles.Keyed {
+			return nil, false
+		}
+
+		m = &metricAgg{
+			field:       stats.Percentiles.Field,
+			percentiles: true,
+			percents:    stats.Percentiles.Percents,
+		}
+	default:
+		return nil, false
+	}
+
+	return m, es.ValidateNumericField(m.field) == nil
+}
+
+// metricAggregate answers "stats" and "percentiles" aggregation queries (eg.
+// the min, max, avg or 95th percentile of RUN_TIME_SEC) by scrolling the
+// matching hits of the given scrollDoner and calculating the metric from their
+// details. Returns false if the query isn't one of those aggregations.
+func metricAggregate(s scrollDoner, query *es.Query) (*es.Result, bool, error) {
+	m, ok := newMetricAgg(query)
+	if !ok {
+		return nil, false, nil
+	}
+
+	scrollQuery := query.WithContext(query.Context())
+	scrollQuery.Aggs = nil
+	scrollQuery.Source = []string{m.field}
+
+	scrolled, err := s.Scroll(scrollQuery)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer s.Done(scrolled.PoolKey)
+
+	values, err := m.values(scrolled.HitSet.Hits)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return m.result(values), true, nil
+}
+
+// result returns a Result holding our metric of the given values, each of which
+// came from a matching hit.
+func (m *metricAgg) result(values []float64) *es.Result {
+	result := es.NewResult()
+	result.HitSet.Total.Value = len(values)
+	result.Aggregations = &es.Aggregations{Stats: es.NewStatsBuckets(values)}
+
+	if m.percentiles {
+		result.Aggregations.Stats = es.NewPercentilesBuckets(values, m.percents)
+	}
+
+	return result
+}
+
+// values returns the values of our field amongst the given hits.
+func (m *metricAgg) values(hits []es.Hit) ([]float64, error) {
+	values := make([]float64, len(hits))
+
+	for i, hit := range hits {
+		val, err := hit.Details.NumericValue(m.field)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = val
+	}
+
+	return values, nil
+}
@@ -0,0 +1,136 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestMetricAggregate(t *testing.T) {
+	Convey("Given a database with stored hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for i, bom := range []string{"bomA", "bomA", "bomA", "bomA", "bomB"} {
+			hitCh <- &es.Hit{Details: &es.Details{
+				Timestamp:               gte.Add(time.Duration(i) * time.Minute).Unix(),
+				BOM:                     bom,
+				RunTimeSec:              int64(10 * (i + 1)),
+				AvgMemEfficiencyPercent: float64(100 - 10*i),
+			}}
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := &es.Query{
+			Aggs: &es.Aggs{Stats: map[string]interface{}{
+				"stats": map[string]interface{}{"field": "RUN_TIME_SEC"},
+			}},
+			Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				rangeFilter("lt", gte, gte.Add(oneDay)),
+				{"match_phrase": {"BOM": "bomA"}},
+			}}},
+		}
+
+		Convey("You can get the stats of a field", func() {
+			result, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(result.HitSet.Total.Value, ShouldEqual, 4)
+
+			stats := result.Aggregations.Stats
+			So(*stats.Count, ShouldEqual, 4)
+			So(*stats.Min, ShouldEqual, 10)
+			So(*stats.Max, ShouldEqual, 40)
+			So(*stats.Avg, ShouldEqual, 25)
+			So(*stats.Sum, ShouldEqual, 100)
+		})
+
+		Convey("You can get the percentiles of memory efficiency", func() {
+			query.Aggs.Stats = map[string]interface{}{
+				"percentiles": map[string]interface{}{
+					"field":    es.MemEfficiencyField,
+					"percents": []float64{0, 50, 100},
+				},
+			}
+
+			result, ok, err := db.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			values := result.Aggregations.Stats.Values
+			So(*values["0.0"], ShouldEqual, 70)
+			So(*values["50.0"], ShouldEqual, 85)
+			So(*values["100.0"], ShouldEqual, 100)
+		})
+
+		Convey("Other metric aggregations are not answered", func() {
+			for _, stats := range []interface{}{
+				map[string]interface{}{"stats": map[string]interface{}{"field": "USER_NAME"}},
+				map[string]interface{}{"percentiles": map[string]interface{}{"field": "RUN_TIME_SEC", "keyed": false}},
+				map[string]interface{}{
+					"stats": map[string]interface{}{"field": "RUN_TIME_SEC"},
+					"terms": map[string]interface{}{"field": "USER_NAME"},
+				},
+			} {
+				query.Aggs.Stats = stats
+
+				_, ok, err := db.Aggregate(query)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+			}
+		})
+	})
+}
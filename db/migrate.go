@@ -0,0 +1,281 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	migratingSuffix = ".migrating"
+	migratedSuffix  = ".old"
+)
+
+// Migrate rewrites every day/bom directory in the configured database directory
+// that was stored using an older es.SerializationVersion (or without
+// dictionaries), so that the hits there are stored in the current format. It
+// returns the number of directories that were migrated.
+//
+// Each directory is written anew alongside the old one, which is only replaced
+// once the new one is complete, but you should not run a server against the
+// database directory while migrating.
+func Migrate(config Config) (int, error) {
+	if err := config.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	bomDirs, err := bomDirsWithFiles(config.Directory, indexKind)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+
+	for _, dir := range bomDirs {
+		needed, err := bomDirNeedsMigration(dir, config.BufferSizeOrDefault()) //nolint:govet
+		if err != nil {
+			return migrated, err
+		}
+
+		if !needed {
+			continue
+		}
+
+		if err = migrateBOMDir(dir, config); err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// bomDirsWithFiles returns the sorted paths of all directories under dir that
+// contain files of any of the given kinds, eg. indexKind.
+func bomDirsWithFiles(dir string, kinds ...string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	err := filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if de.IsDir() && isHiddenTopDir(dir, path) {
+			return filepath.SkipDir
+		}
+
+		if de.Type().IsRegular() && slices.Contains(kinds, strings.TrimPrefix(filepath.Ext(path), ".")) {
+			seen[filepath.Dir(path)] = true
+		}
+
+		return nil
+	})
+
+	dirs := make([]string, 0, len(seen))
+
+	for d := range seen {
+		if !strings.HasSuffix(d, migratingSuffix) && !strings.HasSuffix(d, migratedSuffix) {
+			dirs = append(dirs, d)
+		}
+	}
+
+	sort.Strings(dirs)
+
+	return dirs, err
+}
+
+// bomDirNeedsMigration returns true if the first hit stored in the given
+// directory was serialized with an older version than the current one, or
+// without a dictionary.
+func bomDirNeedsMigration(dir string, bufferSize int) (bool, error) {
+	fi, err := newFlatIndex(filepath.Join(dir, "0."+indexKind), bufferSize)
+	if err != nil || len(fi.bomEntries) == 0 {
+		return false, err
+	}
+
+	encoded, err := readEntryData(fi.dataPath, fi.bomEntries[:1])
+	if err != nil {
+		return false, err
+	}
+
+	version, err := es.SerializedVersion(encoded[0])
+
+	return version < es.SerializationVersion || !es.IsDictionaryEncoded(encoded[0]), err
+}
+
+// readEntryData reads the data of each of the given entries from the given
+// data file (or its compressed copy), verifying their checksums.
+func readEntryData(dataPath string, entries []*flatIndexEntry) ([][]byte, error) {
+	of, err := openDataFile(dataPath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer of.close()
+
+	encoded := make([][]byte, len(entries))
+
+	for i, entry := range entries {
+		encoded[i] = make([]byte, entry.length)
+
+		if err = of.readEntry(encoded[i], entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return encoded, nil
+}
+
+// migrateBOMDir stores all the hits in the given directory in a new directory
+// using the current serialization format, then swaps the new directory in to
+// place of the old.
+func migrateBOMDir(dir string, config Config) error {
+	tmpDir := dir + migratingSuffix
+	oldDir := dir + migratedSuffix
+
+	if err := removeAll(tmpDir, oldDir); err != nil {
+		return err
+	}
+
+	if err := restoreBOMDir(dir, tmpDir, config); err != nil {
+		return err
+	}
+
+	if err := os.Rename(dir, oldDir); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(oldDir)
+}
+
+func removeAll(paths ...string) error {
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreBOMDir deserializes all the hits in fromDir's index files, in order,
+// and stores them in toDir.
+func restoreBOMDir(fromDir, toDir string, config Config) error {
+	indexPaths, err := sortedIndexPaths(fromDir)
+	if err != nil {
+		return err
+	}
+
+	fdb, err := newFlatDB(toDir, config.FileSizeOrDefault(), config.BufferSizeOrDefault(), config.IndexWidths)
+	if err != nil {
+		return err
+	}
+
+	if err = fdb.addColumns(config.ColumnarFields); err != nil {
+		fdb.Close()
+
+		return err
+	}
+
+	if config.JobPrefixIndex {
+		if err = fdb.addJobPrefixIndex(); err != nil {
+			fdb.Close()
+
+			return err
+		}
+	}
+
+	for _, path := range indexPaths {
+		if err = restoreIndex(path, fdb, config.BufferSizeOrDefault()); err != nil {
+			fdb.Close()
+
+			return err
+		}
+	}
+
+	return fdb.Finish()
+}
+
+// sortedIndexPaths returns the paths of the index files in the given directory,
+// in the numeric order they were created in.
+func sortedIndexPaths(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*."+indexKind))
+	if err != nil {
+		return nil, err
+	}
+
+	fileNum := func(path string) int {
+		n, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), "."+indexKind)) //nolint:errcheck
+
+		return n
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return fileNum(paths[i]) < fileNum(paths[j])
+	})
+
+	return paths, nil
+}
+
+// restoreIndex stores all the hits referred to by the given index file in the
+// given flatDB.
+func restoreIndex(path string, fdb *flatDB, bufferSize int) error {
+	fi, err := newFlatIndex(path, bufferSize)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := readEntryData(fi.dataPath, fi.bomEntries)
+	if err != nil {
+		return err
+	}
+
+	for _, data := range encoded {
+		details, err := fi.deserialize(data, 0) //nolint:govet
+		if err != nil {
+			return err
+		}
+
+		if err = fdb.Store(&es.Hit{ID: details.ID, Details: details}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// v2FieldsLength is the length of the encoded fields added in version 2 of the
+// serialization format, when they have zero values.
+const v2FieldsLength = 22*8 + 4*3
+
+func TestMigrate(t *testing.T) {
+	Convey("Given a database with a day stored in the version 1 format", t, func() {
+		dbDir := t.TempDir()
+		bomDir := filepath.Join(dbDir, "2024", "02", "04", "bom")
+		config := Config{Directory: dbDir, FileSize: 300, BufferSize: bufferSize}
+
+		var expected []*es.Details
+
+		fdb, err := newFlatDB(bomDir, config.FileSize, config.BufferSize, config.IndexWidths)
+		So(err, ShouldBeNil)
+
+		for i, user := range []string{"a", "b", "c", "d"} {
+			details := &es.Details{
+				AccountingName: "group", UserName: user, QueueName: "normal",
+				JobName: "job", Timestamp: int64(1707004800 + i),
+			}
+
+			storeV1Hit(fdb, &es.Hit{ID: user, Details: details})
+
+			expected = append(expected, details)
+		}
+
+		So(fdb.Finish(), ShouldBeNil)
+
+		paths, err := sortedIndexPaths(bomDir)
+		So(err, ShouldBeNil)
+		So(len(paths), ShouldBeGreaterThan, 1)
+
+		needed, err := bomDirNeedsMigration(bomDir, config.BufferSize)
+		So(err, ShouldBeNil)
+		So(needed, ShouldBeTrue)
+
+		Convey("Migrate() rewrites it in the current format", func() {
+			migrated, err := Migrate(config)
+			So(err, ShouldBeNil)
+			So(migrated, ShouldEqual, 1)
+
+			needed, err = bomDirNeedsMigration(bomDir, config.BufferSize)
+			So(err, ShouldBeNil)
+			So(needed, ShouldBeFalse)
+
+			So(readAllDetails(bomDir, config.BufferSize), ShouldResemble, expected)
+
+			_, err = os.Stat(bomDir + migratedSuffix)
+			So(err, ShouldNotBeNil)
+
+			_, err = os.Stat(filepath.Join(bomDir, rollupBasename))
+			So(err, ShouldBeNil)
+
+			Convey("and doesn't migrate it again", func() {
+				migrated, err = Migrate(config)
+				So(err, ShouldBeNil)
+				So(migrated, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+// storeV1Hit is like flatDB.Store(), but stores the hit's details in the
+// version 1 serialization format. The hit must not have any version 2 fields
+// set.
+func storeV1Hit(f *flatDB, hit *es.Hit) {
+	fields, err := getFixedWidthFields(hit, f.widths)
+	So(err, ShouldBeNil)
+
+	fields.data, err = hit.Details.Serialize() //nolint:misspell
+	So(err, ShouldBeNil)
+
+	fields.data = fields.data[1 : len(fields.data)-v2FieldsLength]
+
+	version, err := es.SerializedVersion(fields.data)
+	So(err, ShouldBeNil)
+	So(version, ShouldEqual, 1)
+
+	So(f.storeFields(hit, fields), ShouldBeNil)
+}
+
+func readAllDetails(dir string, bufferSize int) []*es.Details {
+	paths, err := sortedIndexPaths(dir)
+	So(err, ShouldBeNil)
+
+	var all []*es.Details
+
+	for _, path := range paths {
+		fi, err := newFlatIndex(path, bufferSize)
+		So(err, ShouldBeNil)
+
+		encoded, err := readEntryData(fi.dataPath, fi.bomEntries)
+		So(err, ShouldBeNil)
+
+		for _, data := range encoded {
+			details, err := fi.deserialize(data, 0)
+			So(err, ShouldBeNil)
+
+			all = append(all, details)
+		}
+	}
+
+	return all
+}
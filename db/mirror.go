@@ -0,0 +1,190 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"sync"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"golang.org/x/sync/errgroup"
+)
+
+// Mirror is like Backfill(), but also stores the hits in the given ClickHouse
+// table. If local is false, hits are only stored in ClickHouse and nothing is
+// stored in the configured Backend.
+//
+// Days already completely stored in ClickHouse and (if local) the Backend will
+// be skipped. If only one of them has a day, the day's hits will only be
+// stored in the other.
+func Mirror(client Scroller, config Config, from time.Time, period time.Duration,
+	ch *ClickHouse, local bool) (report *BackfillReport, err error) {
+	targets := []dayBackfiller{ch}
+
+	if local {
+		ldb, closer, errn := newDayBackfiller(config)
+		if errn != nil {
+			return nil, errn
+		}
+
+		defer func() {
+			if errc := closer(); err == nil {
+				err = errc
+			}
+		}()
+
+		defer logSkippedHits(ldb)
+
+		targets = append(targets, ldb)
+	}
+
+	return backfillByDay(client, newMultiBackfiller(targets), from, period, config)
+}
+
+// multiBackfiller is a dayBackfiller that stores each day's hits in all of
+// the dayBackfillers that need that day.
+type multiBackfiller struct {
+	targets []dayBackfiller
+	needed  map[time.Time][]dayBackfiller
+	mu      sync.Mutex
+}
+
+func newMultiBackfiller(targets []dayBackfiller) *multiBackfiller {
+	return &multiBackfiller{
+		targets: targets,
+		needed:  make(map[time.Time][]dayBackfiller),
+	}
+}
+
+// startDay returns true if any of our targets need the given day.
+func (m *multiBackfiller) startDay(day time.Time) (bool, error) {
+	var needed []dayBackfiller
+
+	for _, target := range m.targets {
+		ok, err := target.startDay(day)
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			needed = append(needed, target)
+		}
+	}
+
+	if len(needed) == 0 {
+		return false, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.needed[day] = needed
+
+	return true, nil
+}
+
+func (m *multiBackfiller) neededFor(day time.Time) []dayBackfiller {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.needed[day]
+}
+
+// storeDay sends each hit from the channel to every target that needs the
+// day, each getting its own copy, since targets like DB.storeHit() alter the
+// hits they store. If a target fails, the others still get all the hits.
+func (m *multiBackfiller) storeDay(day time.Time, hitCh chan *es.Hit) error {
+	targets := m.neededFor(day)
+	chs := make([]chan *es.Hit, len(targets))
+	eg := errgroup.Group{}
+
+	for i, target := range targets {
+		ch := make(chan *es.Hit)
+		chs[i] = ch
+
+		eg.Go(func() error {
+			err := target.storeDay(day, ch)
+
+			for range ch { //nolint:revive
+			}
+
+			return err
+		})
+	}
+
+	hits := make([]*es.Hit, len(chs))
+
+	for hit := range hitCh {
+		for i := range hits {
+			hits[i] = hit
+
+			if i > 0 {
+				hits[i] = copyHit(hit)
+			}
+		}
+
+		for i, ch := range chs {
+			ch <- hits[i]
+		}
+	}
+
+	for _, ch := range chs {
+		close(ch)
+	}
+
+	return eg.Wait()
+}
+
+// copyHit returns a copy of the given hit with its own Details, which can be
+// altered without affecting the original. (Details fields are only ever
+// replaced, not modified in place, so a shallow copy is sufficient.)
+func copyHit(hit *es.Hit) *es.Hit {
+	c := *hit
+
+	if hit.Details != nil {
+		details := *hit.Details
+		c.Details = &details
+	}
+
+	return &c
+}
+
+// finishDay calls finishDay() on every target that needed the day.
+func (m *multiBackfiller) finishDay(day time.Time) error {
+	targets := m.neededFor(day)
+
+	m.mu.Lock()
+	delete(m.needed, day)
+	m.mu.Unlock()
+
+	for _, target := range targets {
+		if err := target.finishDay(day); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"sync"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"golang.org/x/sync/errgroup"
+)
+
+// multiScrollState accumulates the index entries that pass each of the filters
+// of a MultiScroll(), noting where in a shared buffer each unique entry's data
+// will be read to.
+type multiScrollState struct {
+	mu           sync.Mutex
+	filters      []*flatFilter
+	orders       []es.SortOrder
+	queryLDEs    [][]localDataEntry
+	uniqueLDEs   map[*flatIndex][]localDataEntry
+	entryStarts  map[*flatIndexEntry]int
+	lenHits      int
+	poolKey      int
+	sharedBuffer []byte
+}
+
+func newMultiScrollState(queries []*es.Query) (*multiScrollState, error) {
+	filters := make([]*flatFilter, len(queries))
+	orders := make([]es.SortOrder, len(queries))
+
+	for i, query := range queries {
+		order, err := query.TimestampSort()
+		if err != nil {
+			return nil, err
+		}
+
+		filter, err := newFlatFilter(query)
+		if err != nil {
+			return nil, err
+		}
+
+		filters[i], orders[i] = filter, order
+	}
+
+	return &multiScrollState{
+		filters:     filters,
+		orders:      orders,
+		queryLDEs:   make([][]localDataEntry, len(queries)),
+		uniqueLDEs:  make(map[*flatIndex][]localDataEntry),
+		entryStarts: make(map[*flatIndexEntry]int),
+	}, nil
+}
+
+// add records the given entries of the given flatIndex as passing the filter of
+// the query with the given index.
+func (m *multiScrollState) add(queryIndex int, fi *flatIndex, entries []*flatIndexEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, entry := range entries {
+		start, seen := m.entryStarts[entry]
+		if !seen {
+			start = m.lenHits
+			m.entryStarts[entry] = start
+			m.lenHits += entry.length
+			m.uniqueLDEs[fi] = append(m.uniqueLDEs[fi], localDataEntry{fi: fi, entry: entry, start: start})
+		}
+
+		m.queryLDEs[queryIndex] = append(m.queryLDEs[queryIndex], localDataEntry{fi: fi, entry: entry, start: start})
+	}
+}
+
+// MultiScroll is like calling Scroll() on each of the given queries, but the
+// day/BOM indexes needed by any of them are only traversed once, and the data
+// of hits that match more than one of the queries is only read once.
+//
+// The returned Results are in the same order as the queries. They all share
+// the same PoolKey, so you must call Done() with it once, after you have
+// finished with all of them.
+//
+// The configured MaxHits and MaxBytes apply to each query individually, and
+// each query's hits are ordered according to its own Sort.
+func (d *DB) MultiScroll(queries []*es.Query) ([]*es.Result, error) {
+	state, err := newMultiScrollState(queries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filter := range state.filters {
+		if err = d.checkFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	release, err := d.acquireScrollSlot(state.filters[0])
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	d.operateOnRequestedDaysOfFilters(state.filters, func(fi *flatIndex, queryIndexes []int) {
+		for _, i := range queryIndexes {
+			if entries := fi.IndexSearch(state.filters[i]); len(entries) > 0 {
+				state.add(i, fi, entries)
+			}
+		}
+	})
+
+	for i, filter := range state.filters {
+		queries[i].SetScanned(filter.scanned.Load())
+	}
+
+	if err = state.contextErr(); err != nil {
+		return nil, err
+	}
+
+	if err = state.checkQuerySizes(d); err != nil {
+		return nil, err
+	}
+
+	if state.lenHits > 0 {
+		state.sharedBuffer, state.poolKey = d.bufPool.Get(state.lenHits)
+
+		if err = d.readUniqueEntries(state); err != nil {
+			d.Done(state.poolKey)
+
+			return nil, err
+		}
+	}
+
+	results, err := state.results(queries)
+	if err != nil {
+		d.Done(state.poolKey)
+	}
+
+	return results, err
+}
+
+// contextErr returns the first error of our filters' contexts, which will be
+// non-nil if any of their queries have been cancelled or timed out.
+func (m *multiScrollState) contextErr() error {
+	for _, filter := range m.filters {
+		if err := filter.contextErr(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkQuerySizes calls the DB's checkQuerySize() on each of our queries' hits.
+func (m *multiScrollState) checkQuerySizes(d *DB) error {
+	for _, ldes := range m.queryLDEs {
+		numBytes := 0
+
+		for _, lde := range ldes {
+			numBytes += lde.entry.length
+		}
+
+		if err := d.checkQuerySize(len(ldes), numBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// operateOnRequestedDaysOfFilters is like operateOnRequestedDays(), but works
+// out all the flatIndexes needed by any of the given filters and calls the
+// callback once per flatIndex with the indexes of the filters that wanted it.
+func (d *DB) operateOnRequestedDaysOfFilters(filters []*flatFilter, cb func(*flatIndex, []int)) {
+	dirToFilters := make(map[string][]int)
+
+	for i, filter := range filters {
+		d.forEachRequestedDayBOMDir(filter, func(dayBOMDir string) {
+			dirToFilters[dayBOMDir] = append(dirToFilters[dayBOMDir], i)
+		})
+	}
+
+	var wg sync.WaitGroup
+
+	for dayBOMDir, filterIndexes := range dirToFilters {
+		indexes := d.flatIndexesInDir(dayBOMDir)
+
+		wg.Add(len(indexes))
+
+		for _, index := range indexes {
+			go func(dbIndex *flatIndex) {
+				defer wg.Done()
+
+				if filters[filterIndexes[0]].contextErr() != nil {
+					return
+				}
+
+				cb(dbIndex, filterIndexes)
+			}(index)
+		}
+	}
+
+	wg.Wait()
+}
+
+func (d *DB) readUniqueEntries(state *multiScrollState) error {
+	eg := errgroup.Group{}
+
+	for _, ldes := range state.uniqueLDEs {
+		theseLDEs := ldes
+
+		eg.Go(func() error {
+			of, err := d.openFiles.acquire(theseLDEs[0].fi.dataPath)
+			if err != nil {
+				return err
+			}
+
+			defer d.openFiles.release(of)
+
+			for i, lde := range theseLDEs {
+				if i%contextCheckInterval == 0 {
+					if err := state.contextErr(); err != nil {
+						return err
+					}
+				}
+
+				if err = of.readEntry(state.bufferFor(lde), lde.entry); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return eg.Wait()
+}
+
+func (m *multiScrollState) bufferFor(lde localDataEntry) []byte {
+	return m.sharedBuffer[lde.start : lde.start+lde.entry.length]
+}
+
+// results deserializes the data we read for each query in to a Result per
+// query.
+func (m *multiScrollState) results(queries []*es.Query) ([]*es.Result, error) {
+	results := make([]*es.Result, len(queries))
+	eg := errgroup.Group{}
+
+	for i, query := range queries {
+		eg.Go(func() error {
+			result, err := m.result(i)
+			if err != nil {
+				return err
+			}
+
+			results[i] = filterUnindexed(result, query, m.filters[i].patterns)
+
+			return nil
+		})
+	}
+
+	return results, eg.Wait()
+}
+
+func (m *multiScrollState) result(queryIndex int) (*es.Result, error) {
+	ldes := m.queryLDEs[queryIndex]
+	hits := make([]es.Hit, len(ldes))
+
+	setHitOrder(ascendingRuns(ldes), m.orders[queryIndex])
+
+	for _, lde := range ldes {
+		details, err := lde.fi.deserialize(m.bufferFor(lde), m.filters[queryIndex].desiredFields)
+		if err != nil {
+			return nil, err
+		}
+
+		hits[lde.hit] = es.Hit{
+			ID:      details.ID,
+			Details: details,
+		}
+	}
+
+	return &es.Result{
+		ScrollID: pretendScrollID,
+		HitSet: &es.HitSet{
+			Total: es.HitSetTotal{Value: len(hits)},
+			Hits:  hits,
+		},
+		PoolKey: m.poolKey,
+	}, nil
+}
@@ -0,0 +1,310 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	objectKeySeparator = "/"
+	dayKeyParts        = 3
+	fetchTempSuffix    = ".fetch"
+)
+
+// ObjectStore is something like an S3 bucket that can hold copies of the
+// files of a database directory, so that multiple servers can share one
+// backfilled dataset. Keys are paths relative to the database directory,
+// separated by forward slashes, eg. "2024/06/01/Human_Genetics/0.index".
+type ObjectStore interface {
+	// List returns the keys of all objects that start with the given prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Get writes the contents of the object with the given key to w.
+	Get(ctx context.Context, key string, w io.Writer) error
+
+	// Put stores size bytes read from r as the object with the given key.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Delete removes the object with the given key.
+	Delete(ctx context.Context, key string) error
+}
+
+// objectKey returns the ObjectStore key for the given path within our
+// directory.
+func (d *DB) objectKey(localPath string) (string, error) {
+	rel, err := filepath.Rel(d.dir, localPath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// localPath returns the path within our directory of the given ObjectStore
+// key.
+func (d *DB) localPath(key string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(key))
+}
+
+// syncFromObjectStoreIfConfigured calls syncFromObjectStore() if we have an
+// ObjectStore.
+func (d *DB) syncFromObjectStoreIfConfigured() error {
+	if d.objectStore == nil {
+		return nil
+	}
+
+	return d.syncFromObjectStore()
+}
+
+// syncFromObjectStore downloads the index files of every day in our
+// ObjectStore that we don't already have locally. If we only use successfully
+// backfilled days, only days with a success sentinel are downloaded, and the
+// sentinel is downloaded last so that the day is complete once it appears.
+//
+// Data files are not downloaded here; fetchFromObjectStore() is used to read
+// them through on first use.
+func (d *DB) syncFromObjectStore() error {
+	keys, err := d.objectStore.List(context.Background(), "")
+	if err != nil {
+		return err
+	}
+
+	for day, dayKeys := range syncableKeysByDay(keys) {
+		if err = d.syncDayFromObjectStore(day, dayKeys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncableKeysByDay groups the given keys of index, job prefix, checksum,
+// rollup, usernames, gpu rollup and success sentinel files by their
+// "YYYY/MM/DD" day prefix. Each day's keys are sorted so that any success
+// sentinel comes last.
+func syncableKeysByDay(keys []string) map[string][]string {
+	byDay := make(map[string][]string)
+
+	for _, key := range keys {
+		parts := strings.SplitN(key, objectKeySeparator, dayKeyParts+1)
+		if len(parts) <= dayKeyParts || !isSyncableKey(key) {
+			continue
+		}
+
+		day := strings.Join(parts[:dayKeyParts], objectKeySeparator)
+		byDay[day] = append(byDay[day], key)
+	}
+
+	for _, dayKeys := range byDay {
+		sort.Slice(dayKeys, func(i, j int) bool {
+			return !isSuccessKey(dayKeys[i]) && isSuccessKey(dayKeys[j])
+		})
+	}
+
+	return byDay
+}
+
+func isSyncableKey(key string) bool {
+	return isSuccessKey(key) || strings.HasSuffix(key, "."+indexKind) ||
+		strings.HasSuffix(key, "."+jobPrefixKind) || strings.HasSuffix(key, "."+checksumKind) ||
+		strings.HasSuffix(key, "."+dictKind) ||
+		path.Base(key) == rollupBasename || path.Base(key) == usernamesBasename ||
+		path.Base(key) == gpuRollupBasename
+}
+
+func isSuccessKey(key string) bool {
+	return path.Base(key) == successBasename
+}
+
+func (d *DB) syncDayFromObjectStore(day string, keys []string) error {
+	if d.checkBackfillSuccess && !isSuccessKey(keys[len(keys)-1]) {
+		return nil
+	}
+
+	for _, key := range keys {
+		localPath := d.localPath(key)
+
+		if _, err := os.Stat(localPath); err == nil {
+			continue
+		}
+
+		if err := d.fetchFromObjectStore(localPath); err != nil {
+			return err
+		}
+	}
+
+	slog.Debug("synced day from object store", "day", day)
+
+	return nil
+}
+
+// fetchFromObjectStore downloads the object corresponding to the given local
+// path to that path. The file only appears at that path once it has been
+// completely downloaded.
+func (d *DB) fetchFromObjectStore(localPath string) error {
+	key, err := d.objectKey(localPath)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(localPath), dbDirPerms); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(localPath), filepath.Base(localPath)+fetchTempSuffix)
+	if err != nil {
+		return err
+	}
+
+	if err = d.objectStore.Get(context.Background(), key, f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	return os.Rename(f.Name(), localPath)
+}
+
+// objectStoreHasDay returns true if our ObjectStore has a success sentinel for
+// the day with the given local directory.
+func (d *DB) objectStoreHasDay(dayDir string) (bool, error) {
+	key, err := d.objectKey(filepath.Join(dayDir, successBasename))
+	if err != nil {
+		return false, err
+	}
+
+	keys, err := d.objectStore.List(context.Background(), key)
+	if err != nil {
+		return false, err
+	}
+
+	for _, k := range keys {
+		if k == key {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// uploadDay puts all the files in the given local day directory in to our
+// ObjectStore, with the success sentinel being put last. Any other objects the
+// ObjectStore had for the day (eg. from before a Refill()) are then deleted.
+func (d *DB) uploadDay(dayDir string) error {
+	var paths []string
+
+	err := filepath.WalkDir(dayDir, func(p string, de os.DirEntry, err error) error {
+		if err != nil || !de.Type().IsRegular() || de.Name() == successBasename {
+			return err
+		}
+
+		paths = append(paths, p)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	uploaded := make(map[string]bool)
+
+	for _, p := range append(paths, filepath.Join(dayDir, successBasename)) {
+		key, errk := d.objectKey(p)
+		if errk != nil {
+			return errk
+		}
+
+		if err = d.uploadFile(p); err != nil {
+			return err
+		}
+
+		uploaded[key] = true
+	}
+
+	return d.deleteOtherDayObjects(dayDir, uploaded)
+}
+
+// deleteOtherDayObjects deletes the objects in our ObjectStore for the given
+// local day directory that aren't in the given set of keys.
+func (d *DB) deleteOtherDayObjects(dayDir string, keep map[string]bool) error {
+	prefix, err := d.objectKey(dayDir)
+	if err != nil {
+		return err
+	}
+
+	keys, err := d.objectStore.List(context.Background(), prefix+objectKeySeparator)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if keep[key] {
+			continue
+		}
+
+		if err = d.objectStore.Delete(context.Background(), key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *DB) uploadFile(localPath string) error {
+	key, err := d.objectKey(localPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return d.objectStore.Put(context.Background(), key, f, info.Size())
+}
@@ -0,0 +1,202 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// dirStore is an ObjectStore that stores objects as files in a local
+// directory.
+type dirStore struct {
+	dir string
+}
+
+func (s *dirStore) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	err := filepath.WalkDir(s.dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+
+		key, err := filepath.Rel(s.dir, path)
+		if err == nil && strings.HasPrefix(filepath.ToSlash(key), prefix) {
+			keys = append(keys, filepath.ToSlash(key))
+		}
+
+		return err
+	})
+
+	return keys, err
+}
+
+func (s *dirStore) Get(_ context.Context, key string, w io.Writer) error {
+	f, err := os.Open(filepath.Join(s.dir, key))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+
+	return err
+}
+
+func (s *dirStore) Put(_ context.Context, key string, r io.Reader, _ int64) error {
+	path := filepath.Join(s.dir, key)
+
+	if err := os.MkdirAll(filepath.Dir(path), dbDirPerms); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *dirStore) Delete(_ context.Context, key string) error {
+	return os.Remove(filepath.Join(s.dir, key))
+}
+
+func TestObjectStore(t *testing.T) {
+	from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+	period := (2 * 24) * time.Hour
+	bom := "Human Genetics"
+
+	Convey("Given a Backfill() to a DB with an ObjectStore", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		store := &dirStore{dir: t.TempDir()}
+		mock := es.NewMock("some-indexes-*")
+		backfillConfig := Config{Directory: t.TempDir(), ObjectStore: store}
+
+		_, err := Backfill(mock, backfillConfig, from, period)
+		So(err, ShouldBeNil)
+
+		dayKey := "2024/05/31"
+		keys, err := store.List(context.Background(), dayKey)
+		So(err, ShouldBeNil)
+		So(keys, ShouldContain, dayKey+"/"+successBasename)
+		So(keys, ShouldContain, dayKey+"/"+bom+"/0.index")
+		So(keys, ShouldContain, dayKey+"/"+bom+"/0.data")
+
+		Convey("Refill() replaces a day's objects in the store", func() {
+			staleKey := dayKey + "/" + bom + "/1.index"
+			So(store.Put(context.Background(), staleKey, strings.NewReader("stale"), 5), ShouldBeNil)
+
+			_, err = Refill(mock, backfillConfig, from.Add(-oneDay))
+			So(err, ShouldBeNil)
+
+			keys, err = store.List(context.Background(), dayKey)
+			So(err, ShouldBeNil)
+			So(keys, ShouldContain, dayKey+"/"+successBasename)
+			So(keys, ShouldContain, dayKey+"/"+bom+"/0.index")
+			So(keys, ShouldNotContain, staleKey)
+		})
+
+		Convey("Backfill()s to other directories skip days already in the store", func() {
+			config := Config{Directory: t.TempDir(), ObjectStore: store}
+
+			_, err = Backfill(mock, config, from, period)
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(config.Directory, "2024"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("New() DBs download index files, and data files on first query", func() {
+			config := Config{Directory: filepath.Join(t.TempDir(), "cache"), ObjectStore: store}
+			dayDir := filepath.Join(config.Directory, "2024", "05", "31")
+			dataPath := filepath.Join(dayDir, bom, "0.data")
+
+			db, err := New(config, true)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			_, err = os.Stat(filepath.Join(dayDir, successBasename))
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(dayDir, bom, "0.index"))
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(dataPath)
+			So(err, ShouldNotBeNil)
+
+			query := rangeQuery(timeRange(from, period))
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": bom}})
+
+			result, err := db.Scroll(query)
+			So(err, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 2)
+			So(result.HitSet.Hits[0].Details.BOM, ShouldEqual, bom)
+
+			_, err = os.Stat(dataPath)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Days without a success sentinel in the store are not synced", func() {
+			err = os.Remove(filepath.Join(store.dir, "2024", "05", "31", successBasename))
+			So(err, ShouldBeNil)
+
+			config := Config{Directory: t.TempDir(), ObjectStore: store}
+
+			db, err := New(config, true)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			_, err = os.Stat(filepath.Join(config.Directory, "2024", "05", "31"))
+			So(err, ShouldNotBeNil)
+
+			_, err = os.Stat(filepath.Join(config.Directory, "2024", "05", "30", successBasename))
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,102 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"regexp"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrUnsupportedPatternField = "wildcard and regexp filters are only supported on Command, JOB_NAME, Job and QUEUE_NAME"
+	ErrPatternDistinctBOMs     = "distinct BOMs can't be found for queries with wildcard or regexp filters"
+)
+
+// patternFields are the fields we support wildcard and regexp filters on, and
+// the es.Fields their values are deserialized with.
+var patternFields = map[string]es.Fields{ //nolint:gochecknoglobals
+	"Command":    es.FieldCommand,
+	"JOB_NAME":   es.FieldJobName,
+	"Job":        es.FieldJob,
+	"QUEUE_NAME": es.FieldQueueName,
+}
+
+// patternMatcher checks a field of hits against a compiled es.PatternFilter.
+type patternMatcher struct {
+	field string
+	re    *regexp.Regexp
+}
+
+// newPatternMatchers returns a patternMatcher for each of the query's wildcard
+// and regexp filters. Returns an error if any are invalid or on fields we don't
+// support.
+func newPatternMatchers(query *es.Query) ([]patternMatcher, error) {
+	pfs := query.PatternFilters()
+	pms := make([]patternMatcher, 0, len(pfs))
+
+	for _, pf := range pfs {
+		if _, ok := patternFields[pf.Field]; !ok {
+			return nil, Error{Msg: ErrUnsupportedPatternField, cause: pf.Field}
+		}
+
+		re, err := pf.Compile()
+		if err != nil {
+			return nil, err
+		}
+
+		pms = append(pms, patternMatcher{field: pf.Field, re: re})
+	}
+
+	return pms, nil
+}
+
+// patternDesiredFields returns the given desired fields plus those needed to
+// check the given patternMatchers.
+func patternDesiredFields(desired es.Fields, pms []patternMatcher) es.Fields {
+	if desired == 0 {
+		return desired
+	}
+
+	for _, pm := range pms {
+		desired |= patternFields[pm.field]
+	}
+
+	return desired
+}
+
+// patternsMatch returns true if the given hit matches all the given
+// patternMatchers.
+func patternsMatch(pms []patternMatcher, hit es.Hit) bool {
+	for _, pm := range pms {
+		val, err := hit.Details.FieldString(pm.field)
+		if err != nil || !pm.re.MatchString(val) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,158 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestPatternFilters(t *testing.T) {
+	Convey("Given a database with hits of various job names", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for i, jobName := range []string{"nf-align", "nf-call", "wr-align", "nf-ALIGN2"} {
+			hitCh <- &es.Hit{ID: strconv.Itoa(i), Details: &es.Details{
+				Timestamp: gte.Add(time.Duration(i) * time.Minute).Unix(),
+				BOM:       "bomA",
+				UserName:  "user" + strconv.Itoa(i),
+				JobName:   jobName,
+				Command:   "cmd" + strconv.Itoa(i),
+			}}
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := func(extra ...map[string]es.MapStringStringOrMap) *es.Query {
+			filter := es.Filter{
+				rangeFilter("lt", gte, gte.Add(oneDay)),
+				{"match_phrase": {"BOM": "bomA"}},
+			}
+
+			return &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: append(filter, extra...)}}}
+		}
+
+		userNames := func(result *es.Result) []string {
+			names := make([]string, len(result.HitSet.Hits))
+			for i, hit := range result.HitSet.Hits {
+				names[i] = hit.Details.UserName
+			}
+
+			sort.Strings(names)
+
+			return names
+		}
+
+		wildcard := map[string]es.MapStringStringOrMap{"wildcard": {"JOB_NAME": "nf-*align*"}}
+		regexp := map[string]es.MapStringStringOrMap{"regexp": {"Command": map[string]interface{}{"value": "cmd[0-2]"}}}
+
+		Convey("You can Scroll with wildcard and regexp filters", func() {
+			result, err := db.Scroll(query(wildcard))
+			So(err, ShouldBeNil)
+			So(userNames(result), ShouldResemble, []string{"user0"})
+			db.Done(result.PoolKey)
+
+			q := query(map[string]es.MapStringStringOrMap{"wildcard": {
+				"JOB_NAME": map[string]interface{}{"value": "nf-*align*", "case_insensitive": true},
+			}})
+			q.Source = []string{"USER_NAME"}
+
+			result, err = db.Scroll(q)
+			So(err, ShouldBeNil)
+			So(userNames(result), ShouldResemble, []string{"user0", "user3"})
+			db.Done(result.PoolKey)
+
+			result, err = db.Scroll(query(wildcard, regexp))
+			So(err, ShouldBeNil)
+			So(userNames(result), ShouldResemble, []string{"user0"})
+			db.Done(result.PoolKey)
+
+			results, err := db.MultiScroll([]*es.Query{query(regexp)})
+			So(err, ShouldBeNil)
+			So(userNames(results[0]), ShouldResemble, []string{"user0", "user1", "user2"})
+			db.Done(results[0].PoolKey)
+		})
+
+		Convey("Count and DistinctValues apply them too", func() {
+			count, err := db.Count(query(regexp))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+
+			values, err := db.DistinctValues(query(wildcard), "USER_NAME")
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"user0"})
+
+			counts, err := db.DistinctCounts(query(regexp), "USER_NAME")
+			So(err, ShouldBeNil)
+			So(counts, ShouldResemble, map[string]int{"user0": 1, "user1": 1, "user2": 1})
+
+			_, err = db.DistinctValues(query(wildcard), distinctBOM)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, ErrPatternDistinctBOMs)
+		})
+
+		Convey("Filters on unsupported fields, or invalid patterns, are errors", func() {
+			_, err := db.Scroll(query(map[string]es.MapStringStringOrMap{"wildcard": {"USER_NAME": "user*"}}))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrUnsupportedPatternField)
+
+			_, err = db.Scroll(query(map[string]es.MapStringStringOrMap{"regexp": {"JOB_NAME": "nf-("}}))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, es.ErrBadPattern)
+		})
+	})
+}
@@ -0,0 +1,75 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	ErrInvalidPeriod = "invalid period"
+
+	hoursInDay   = 24
+	hoursInWeek  = hoursInDay * 7
+	hoursInMonth = 730
+	hoursInYear  = 8760
+)
+
+var periodUnitRegex = regexp.MustCompile("[0-9]+[hdwmy]") //nolint:gochecknoglobals
+
+// ParsePeriod parses a Backfill() period like "2d", which is a Go duration
+// string that can also use the units d (days), w (weeks), m (months of 730
+// hours) and y (years of 8760 hours), eg. "1y2m".
+func ParsePeriod(period string) (time.Duration, error) {
+	hours := periodUnitRegex.ReplaceAllStringFunc(period, func(d string) string {
+		num, err := strconv.ParseInt(d[:len(d)-1], 10, 64)
+		if err != nil {
+			return d
+		}
+
+		switch d[len(d)-1] {
+		case 'd':
+			num *= hoursInDay
+		case 'w':
+			num *= hoursInWeek
+		case 'm':
+			num *= hoursInMonth
+		case 'y':
+			num *= hoursInYear
+		}
+
+		return strconv.FormatInt(num, 10) + "h"
+	})
+
+	d, err := time.ParseDuration(hours)
+	if err != nil {
+		return 0, Error{Msg: ErrInvalidPeriod, cause: err.Error()}
+	}
+
+	return d, nil
+}
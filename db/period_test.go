@@ -0,0 +1,53 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParsePeriod(t *testing.T) {
+	Convey("You can ParsePeriod()s with extra units", t, func() {
+		for period, expected := range map[string]time.Duration{
+			"2d":    48 * time.Hour,
+			"1w":    7 * 24 * time.Hour,
+			"1m":    730 * time.Hour,
+			"1y2m":  (8760 + 2*730) * time.Hour,
+			"1d12h": 36 * time.Hour,
+		} {
+			d, err := ParsePeriod(period)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, expected)
+		}
+
+		_, err := ParsePeriod("2 days")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, ErrInvalidPeriod)
+	})
+}
@@ -0,0 +1,196 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrNotLoaded = "the days queried are still being loaded; try again shortly"
+	ErrClosed    = "database closed"
+)
+
+// backgroundLoad holds the state of a DB configured to BackgroundLoad.
+type backgroundLoad struct {
+	background   bool
+	loadProgress atomic.Pointer[indexLoadProgress]
+	loaded       atomic.Bool
+	closing      atomic.Bool
+	loadDone     chan struct{}
+
+	muLoadErr sync.Mutex
+	loadErr   error
+}
+
+// Readiness describes how far a DB has got loading its index files.
+type Readiness struct {
+	// Ready is true once all index files have been loaded, after which all
+	// queries can be answered.
+	Ready bool
+
+	// LoadedDays and TotalDays are how many days of index files have been
+	// loaded so far, out of how many.
+	LoadedDays int
+	TotalDays  int
+
+	// LoadedFrom is the earliest day that queries can be answered from while
+	// loading continues, with all later days having been loaded. It is the
+	// zero time if no days can be queried yet.
+	LoadedFrom time.Time
+
+	// Err is the error that stopped loading, if any.
+	Err error
+}
+
+// loadInBackground loads our initial indexes in a goroutine, after which our
+// maintenance is started.
+func (d *DB) loadInBackground(config Config) {
+	d.background = true
+	d.loadProgress.Store(newIndexLoadProgress(nil))
+	d.loadDone = make(chan struct{})
+
+	go func() {
+		defer close(d.loadDone)
+
+		t := time.Now()
+
+		err := d.loadInitialFlatIndexes()
+		if d.closing.Load() {
+			return
+		}
+
+		if err != nil {
+			slog.Error("loading local database indexes failed", "err", err)
+
+			d.muLoadErr.Lock()
+			d.loadErr = err
+			d.muLoadErr.Unlock()
+
+			return
+		}
+
+		d.loaded.Store(true)
+		slog.Info("local database indexes loaded", "took", time.Since(t))
+
+		d.startMaintenance(config)
+	}()
+}
+
+// stopBackgroundLoad stops any background load as soon as possible, waiting
+// for it to stop. Returns true if our indexes were completely loaded.
+func (d *DB) stopBackgroundLoad() bool {
+	if !d.background {
+		return true
+	}
+
+	d.closing.Store(true)
+	<-d.loadDone
+
+	return d.loaded.Load()
+}
+
+// Readiness says how far we've got loading our index files. DBs not configured
+// to BackgroundLoad are always Ready.
+func (d *DB) Readiness() Readiness {
+	if !d.background || d.loaded.Load() {
+		return Readiness{Ready: true}
+	}
+
+	progress := d.loadProgress.Load()
+	done, total := progress.counts()
+
+	d.muLoadErr.Lock()
+	defer d.muLoadErr.Unlock()
+
+	return Readiness{
+		LoadedDays: done,
+		TotalDays:  total,
+		LoadedFrom: d.loadedFrom(),
+		Err:        d.loadErr,
+	}
+}
+
+// loadedFrom returns the earliest day that all later days have been loaded
+// from, or the zero time if none have.
+func (d *DB) loadedFrom() time.Time {
+	dayDir := d.loadProgress.Load().loadedFrom()
+	if dayDir == "" {
+		return time.Time{}
+	}
+
+	dateStr, err := filepath.Rel(d.dir, dayDir)
+	if err != nil {
+		return time.Time{}
+	}
+
+	day, err := time.Parse(dateFormat, filepath.ToSlash(dateStr))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return day
+}
+
+// checkLoaded returns an ErrNotLoaded Error if we're still loading in the
+// background and haven't yet loaded all days from the given time onwards.
+func (d *DB) checkLoaded(from time.Time) error {
+	if !d.background || d.loaded.Load() {
+		return nil
+	}
+
+	loadedFrom := d.loadedFrom()
+	if !loadedFrom.IsZero() && !from.Before(loadedFrom) {
+		return nil
+	}
+
+	if loadedFrom.IsZero() {
+		return Error{Msg: ErrNotLoaded}
+	}
+
+	return Error{Msg: ErrNotLoaded, cause: fmt.Sprintf("only days from %s are loaded", loadedFrom.Format(time.DateOnly))}
+}
+
+// checkQueryLoaded is like checkLoaded(), but for the start of the given
+// query's date range.
+func (d *DB) checkQueryLoaded(query *es.Query) error {
+	if !d.background || d.loaded.Load() {
+		return nil
+	}
+
+	_, _, gte, err := query.DateRange()
+	if err != nil {
+		return err
+	}
+
+	return d.checkLoaded(gte)
+}
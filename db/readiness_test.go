@@ -0,0 +1,157 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestBackgroundLoad(t *testing.T) {
+	Convey("Given a database with a few days of data", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for i := range 3 {
+			hitCh <- &es.Hit{ID: strconv.Itoa(i), Details: &es.Details{
+				Timestamp: gte.Add(time.Duration(i) * oneDay).Unix(),
+				BOM:       "bomA",
+				UserName:  "user" + strconv.Itoa(i),
+			}}
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		query := func(from time.Time) *es.Query {
+			return &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				rangeFilter("lt", from, gte.Add(3*oneDay)),
+				{"match_phrase": {"BOM": "bomA"}},
+			}}}}
+		}
+
+		Convey("Databases not loading in the background are always ready", func() {
+			db, err = New(config, false)
+			So(err, ShouldBeNil)
+
+			So(db.Readiness(), ShouldResemble, Readiness{Ready: true})
+			So(db.checkLoaded(time.Time{}), ShouldBeNil)
+			So(db.Close(), ShouldBeNil)
+		})
+
+		Convey("You can load in the background and become ready", func() {
+			config.BackgroundLoad = true
+
+			db, err = New(config, false)
+			So(err, ShouldBeNil)
+
+			<-db.loadDone
+
+			readiness := db.Readiness()
+			So(readiness.Ready, ShouldBeTrue)
+			So(readiness.Err, ShouldBeNil)
+
+			result, err := db.Scroll(query(gte))
+			So(err, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, 3)
+			db.Done(result.PoolKey)
+
+			So(db.Close(), ShouldBeNil)
+		})
+
+		Convey("While loading, only queries of loaded days are answered", func() {
+			db = &DB{dir: config.Directory}
+			db.background = true
+			db.loadDone = make(chan struct{})
+			close(db.loadDone)
+
+			progress := newIndexLoadProgress([]indexDir{
+				{dir: dayDir(config.Directory, gte, "bomA")},
+				{dir: dayDir(config.Directory, gte.Add(oneDay), "bomA")},
+				{dir: dayDir(config.Directory, gte.Add(2*oneDay), "bomA")},
+			})
+			db.loadProgress.Store(progress)
+
+			readiness := db.Readiness()
+			So(readiness.Ready, ShouldBeFalse)
+			So(readiness.TotalDays, ShouldEqual, 3)
+			So(readiness.LoadedFrom.IsZero(), ShouldBeTrue)
+
+			err = db.checkLoaded(gte.Add(2 * oneDay))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrNotLoaded)
+
+			progress.dirDone(dayDir(config.Directory, gte.Add(2*oneDay), "bomA"))
+			progress.dirDone(dayDir(config.Directory, gte, "bomA"))
+
+			readiness = db.Readiness()
+			So(readiness.LoadedDays, ShouldEqual, 2)
+			So(readiness.LoadedFrom, ShouldEqual, gte.Add(2*oneDay))
+
+			So(db.checkLoaded(gte.Add(2*oneDay)), ShouldBeNil)
+			So(db.checkLoaded(gte.Add(2*oneDay+time.Hour)), ShouldBeNil)
+
+			err = db.checkLoaded(gte)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "only days from 2024-02-06 are loaded")
+
+			So(db.checkQueryLoaded(query(gte)), ShouldNotBeNil)
+			So(db.checkQueryLoaded(query(gte.Add(2*oneDay))), ShouldBeNil)
+
+			progress.dirDone(dayDir(config.Directory, gte.Add(oneDay), "bomA"))
+			So(db.checkLoaded(gte), ShouldBeNil)
+
+			db.loaded.Store(true)
+			So(db.Readiness(), ShouldResemble, Readiness{Ready: true})
+			So(db.stopBackgroundLoad(), ShouldBeTrue)
+		})
+	})
+}
+
+func dayDir(dbDir string, day time.Time, bom string) string {
+	return filepath.Join(dbDir, day.Format(dateFormat), bom)
+}
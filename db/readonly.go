@@ -0,0 +1,60 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import "os"
+
+const (
+	ErrReadOnly            = "database is configured read-only"
+	ErrReadOnlyObjectStore = "a read-only database can't sync from an object store"
+)
+
+// checkWritable returns an ErrReadOnly Error if we're configured ReadOnly, so
+// that things that would create or modify files in our Directory can refuse
+// to start.
+func (c Config) checkWritable() error {
+	if c.ReadOnly {
+		return Error{Msg: ErrReadOnly, cause: c.Directory}
+	}
+
+	return nil
+}
+
+// checkReadOnlyDirectory returns an error if we're configured ReadOnly and
+// either have an ObjectStore (which we'd have to download files from in to our
+// Directory) or our Directory doesn't exist (which we'd otherwise create).
+func (c Config) checkReadOnlyDirectory() error {
+	if !c.ReadOnly {
+		return nil
+	}
+
+	if c.ObjectStore != nil {
+		return Error{Msg: ErrReadOnlyObjectStore, cause: c.Directory}
+	}
+
+	_, err := os.Stat(c.Directory)
+
+	return err
+}
@@ -0,0 +1,164 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestReadOnly(t *testing.T) {
+	from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+	period := (2 * 24) * time.Hour
+
+	Convey("Given a backfilled database missing its latest day", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		dir := t.TempDir()
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: dir, UpdateFrequency: time.Hour}
+
+		_, err := Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+
+		So(os.RemoveAll(filepath.Join(dir, "2024", "05", "31")), ShouldBeNil)
+
+		roConfig := config
+		roConfig.ReadOnly = true
+
+		query := rangeQuery(timeRange(from, period))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+		Convey("A read-only DB can query it without writing an index cache", func() {
+			db, err := New(roConfig, true)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			count, err := db.Count(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			_, err = os.Stat(filepath.Join(dir, indexCacheBasename))
+			So(err, ShouldNotBeNil)
+
+			Convey("and finds days backfilled elsewhere", func() {
+				_, err = Backfill(mock, config, from, period)
+				So(err, ShouldBeNil)
+
+				So(db.Reload(), ShouldBeNil)
+
+				count, err = db.Count(query)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 2)
+
+				_, err = os.Stat(filepath.Join(dir, indexCacheBasename))
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("but can't Store()", func() {
+				hitCh := make(chan *es.Hit)
+				close(hitCh)
+
+				So(db.Store(hitCh), ShouldResemble, Error{Msg: ErrReadOnly, cause: dir})
+			})
+		})
+
+		Convey("Nothing can be written with a read-only Config", func() {
+			expected := Error{Msg: ErrReadOnly, cause: dir}
+
+			_, err = Backfill(mock, roConfig, from, period)
+			So(err, ShouldResemble, expected)
+
+			_, err = Refill(mock, roConfig, from)
+			So(err, ShouldResemble, expected)
+
+			So(BackfillHours(mock, roConfig, from), ShouldResemble, expected)
+
+			_, err = Migrate(roConfig)
+			So(err, ShouldResemble, expected)
+
+			_, err = Restore(roConfig, &bytes.Buffer{})
+			So(err, ShouldResemble, expected)
+
+			So(QuarantineDays(roConfig, []string{"2024/05/30"}), ShouldResemble, expected)
+
+			_, err = os.Stat(filepath.Join(dir, "2024", "05", "30"))
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(dir, "2024", "05", "31"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A read-only DB can't be made of a missing directory", func() {
+			roConfig.Directory = filepath.Join(dir, "missing")
+
+			_, err = New(roConfig, true)
+			So(err, ShouldNotBeNil)
+
+			_, err = os.Stat(roConfig.Directory)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A read-only DB can't use an ObjectStore", func() {
+			roConfig.ObjectStore = &dirStore{dir: t.TempDir()}
+
+			_, err = New(roConfig, true)
+			So(err, ShouldResemble, Error{Msg: ErrReadOnlyObjectStore, cause: dir})
+		})
+	})
+
+	Convey("A read-only SQLite database must already exist and can't be stored in", t, func() {
+		config := Config{Directory: t.TempDir(), Backend: BackendSQLite}
+		roConfig := config
+		roConfig.ReadOnly = true
+
+		_, err := NewSQLite(roConfig)
+		So(err, ShouldNotBeNil)
+
+		s, err := NewSQLite(config)
+		So(err, ShouldBeNil)
+		So(s.Close(), ShouldBeNil)
+
+		s, err = NewSQLite(roConfig)
+		So(err, ShouldBeNil)
+
+		defer s.Close()
+
+		hitCh := make(chan *es.Hit, 1)
+		hitCh <- &es.Hit{Details: &es.Details{BOM: "Human Genetics", Timestamp: from.Unix()}}
+		close(hitCh)
+
+		So(s.Store(hitCh), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,125 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// DayStatus says what a backfill did with a day.
+type DayStatus string
+
+const (
+	// DaySkipped days had already been backfilled.
+	DaySkipped DayStatus = "skipped"
+
+	// DaySucceeded days were stored successfully.
+	DaySucceeded DayStatus = "succeeded"
+
+	// DayFailed days could not be stored; their DayReport has the Error.
+	DayFailed DayStatus = "failed"
+)
+
+// DayReport describes what happened to one day during a backfill.
+type DayReport struct {
+	Day      string    `json:"day"`
+	Status   DayStatus `json:"status"`
+	Hits     int       `json:"hits"`
+	Attempts int       `json:"attempts"`
+	Seconds  float64   `json:"seconds"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// BackfillReport describes what happened to each day during a Backfill(),
+// BackfillRange(), Refill() or Mirror(), in day order, along with totals of
+// each DayStatus. It is returned even when the backfill fails, so that callers
+// can see which days did succeed.
+type BackfillReport struct {
+	Days      []*DayReport `json:"days"`
+	Skipped   int          `json:"skipped"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+}
+
+// addDay appends a new DayReport for the given day, with the given status, and
+// returns it.
+func (r *BackfillReport) addDay(day time.Time, status DayStatus) *DayReport {
+	dr := &DayReport{Day: timestampToDay(day.Unix()), Status: status}
+	r.Days = append(r.Days, dr)
+
+	return dr
+}
+
+// addFailedDay is like addDay(), for a day that failed with the given error.
+func (r *BackfillReport) addFailedDay(day time.Time, err error) {
+	r.addDay(day, DayFailed).fail(err)
+}
+
+// tally sets our totals from our Days. Call this once all days are complete.
+func (r *BackfillReport) tally() {
+	r.Skipped, r.Succeeded, r.Failed = 0, 0, 0
+
+	for _, dr := range r.Days {
+		switch dr.Status {
+		case DaySkipped:
+			r.Skipped++
+		case DaySucceeded:
+			r.Succeeded++
+		case DayFailed:
+			r.Failed++
+		}
+	}
+}
+
+// WriteJSON writes the report to w as indented JSON.
+func (r *BackfillReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
+
+// finish records the given number of hits, how long the day took since the
+// given start time, and whether it failed with the given error.
+func (dr *DayReport) finish(start time.Time, hits int, err error) {
+	dr.Hits = hits
+	dr.Seconds = time.Since(start).Seconds()
+
+	if err != nil {
+		dr.fail(err)
+
+		return
+	}
+
+	dr.Status = DaySucceeded
+}
+
+func (dr *DayReport) fail(err error) {
+	dr.Status = DayFailed
+	dr.Error = err.Error()
+}
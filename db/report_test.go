@@ -0,0 +1,111 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestBackfillReport(t *testing.T) {
+	from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+	period := 2 * oneDay
+
+	Convey("Backfill() returns a report of each day", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: t.TempDir()}
+
+		report, err := Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+		So(report.Succeeded, ShouldEqual, 3)
+		So(report.Skipped, ShouldEqual, 0)
+		So(report.Failed, ShouldEqual, 0)
+		So(len(report.Days), ShouldEqual, 3)
+
+		days := make([]string, len(report.Days))
+		hits := make([]int, len(report.Days))
+
+		for i, dr := range report.Days {
+			days[i] = dr.Day
+			hits[i] = dr.Hits
+
+			So(dr.Status, ShouldEqual, DaySucceeded)
+			So(dr.Seconds, ShouldBeGreaterThan, 0)
+			So(dr.Error, ShouldBeBlank)
+		}
+
+		So(days, ShouldResemble, []string{"2024/05/29", "2024/05/30", "2024/05/31"})
+		So(hits, ShouldResemble, []int{0, 1, 1})
+
+		Convey("which records skipped days when repeated", func() {
+			report, err = Backfill(mock, config, from, period)
+			So(err, ShouldBeNil)
+			So(report.Skipped, ShouldEqual, 3)
+			So(report.Succeeded, ShouldEqual, 0)
+			So(report.Days[0].Status, ShouldEqual, DaySkipped)
+		})
+
+		Convey("which can be written as JSON", func() {
+			var buf bytes.Buffer
+
+			So(report.WriteJSON(&buf), ShouldBeNil)
+
+			var decoded BackfillReport
+
+			So(json.Unmarshal(buf.Bytes(), &decoded), ShouldBeNil)
+			So(decoded.Succeeded, ShouldEqual, 3)
+			So(decoded.Days[1].Day, ShouldEqual, "2024/05/30")
+			So(decoded.Days[1].Hits, ShouldEqual, 1)
+			So(buf.String(), ShouldNotContainSubstring, `"error"`)
+		})
+	})
+
+	Convey("Backfill() reports failed days along with its error", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		config := Config{Directory: t.TempDir()}
+
+		report, err := Backfill(es.NewMock("long_group"), config, from, period)
+		So(err, ShouldNotBeNil)
+		So(report, ShouldNotBeNil)
+		So(report.Failed, ShouldBeGreaterThan, 0)
+		So(report.Succeeded+report.Skipped+report.Failed, ShouldEqual, len(report.Days))
+
+		for _, dr := range report.Days {
+			if dr.Status == DayFailed {
+				So(dr.Error, ShouldStartWith, ErrFieldTooLong)
+			}
+		}
+	})
+}
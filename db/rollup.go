@@ -0,0 +1,186 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	rollupBasename  = "rollup.json"
+	rollupFilePerms = 0660
+
+	// wastedCostMapScript, wastedCostCPUSecond and wastedCostMBSecond are the
+	// map script and params of the farmer's report's "wasted_cost"
+	// scripted_metric aggregation, which we precompute in rollups.
+	wastedCostMapScript = "double cpu_cost = doc.WASTED_CPU_SECONDS.value * params.cpu_second; " +
+		"double mem_cost = doc.WASTED_MB_SECONDS.value * params.mb_second; " +
+		"state.costs.add(Math.max(cpu_cost, mem_cost))"
+	wastedCostCPUSecond = 7.0556e-07
+	wastedCostMBSecond  = 5.8865e-11
+)
+
+// rollupKey is the combination of fields that the farmer's report aggregates
+// on.
+type rollupKey struct {
+	AccountingName string `json:"ACCOUNTING_NAME"`
+	NumExecProcs   int64  `json:"NUM_EXEC_PROCS"`
+	Job            string `json:"Job"`
+}
+
+// rollupValues holds the number of hits with a certain rollupKey, and the sums
+// of their numeric fields.
+type rollupValues struct {
+	DocCount   int64              `json:"doc_count"`
+	Sums       map[string]float64 `json:"sums"`
+	WastedCost float64            `json:"wasted_cost"`
+}
+
+func newRollupValues() *rollupValues {
+	return &rollupValues{Sums: make(map[string]float64)}
+}
+
+// add counts the given details and adds them to our sums.
+func (v *rollupValues) add(details *es.Details) {
+	v.DocCount++
+	v.WastedCost += math.Max(details.WastedCPUSeconds*wastedCostCPUSecond,
+		details.WastedMBSeconds*wastedCostMBSecond)
+
+	for field, val := range rollupSummableFields(details) {
+		v.Sums[field] += val
+	}
+}
+
+// merge adds the other values to ours.
+func (v *rollupValues) merge(other *rollupValues) {
+	v.DocCount += other.DocCount
+	v.WastedCost += other.WastedCost
+
+	for field, val := range other.Sums {
+		v.Sums[field] += val
+	}
+}
+
+// rollupEntry is how a rollupKey and its rollupValues are stored in a rollup
+// file.
+type rollupEntry struct {
+	rollupKey
+	rollupValues
+}
+
+// rollup holds the rollupValues for every rollupKey seen amongst a set of hits.
+type rollup map[rollupKey]*rollupValues
+
+// add adds the given details to our sums.
+func (r rollup) add(details *es.Details) {
+	key := rollupKey{
+		AccountingName: details.AccountingName,
+		NumExecProcs:   details.NumExecProcs,
+		Job:            details.Job,
+	}
+
+	vals, ok := r[key]
+	if !ok {
+		vals = newRollupValues()
+		r[key] = vals
+	}
+
+	vals.add(details)
+}
+
+// rollupSummableFields returns the values of the numeric fields of the given
+// details, keyed on their elasticsearch names.
+func rollupSummableFields(d *es.Details) map[string]float64 {
+	return map[string]float64{
+		"AVAIL_CPU_TIME_SEC":     float64(d.AvailCPUTimeSec),
+		"MEM_REQUESTED_MB":       float64(d.MemRequestedMB),
+		"MEM_REQUESTED_MB_SEC":   float64(d.MemRequestedMBSec),
+		"NUM_EXEC_PROCS":         float64(d.NumExecProcs),
+		"PENDING_TIME_SEC":       float64(d.PendingTimeSec),
+		"RUN_TIME_SEC":           float64(d.RunTimeSec),
+		"WASTED_CPU_SECONDS":     d.WastedCPUSeconds,
+		"WASTED_MB_SECONDS":      d.WastedMBSeconds,
+		"RAW_WASTED_CPU_SECONDS": d.RawWastedCPUSeconds,
+		"RAW_WASTED_MB_SECONDS":  d.RawWastedMBSeconds,
+	}
+}
+
+// merge adds the values of the other rollup to ours.
+func (r rollup) merge(other rollup) {
+	for key, otherVals := range other {
+		vals, ok := r[key]
+		if !ok {
+			vals = newRollupValues()
+			r[key] = vals
+		}
+
+		vals.merge(otherVals)
+	}
+}
+
+// write writes our rollup to a rollup file in the given directory.
+func (r rollup) write(dir string) error {
+	entries := make([]rollupEntry, 0, len(r))
+
+	for key, vals := range r {
+		entries = append(entries, rollupEntry{rollupKey: key, rollupValues: *vals})
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, rollupBasename), data, rollupFilePerms)
+}
+
+// readRollup reads the rollup file in the given directory.
+func readRollup(dir string) (rollup, error) {
+	data, err := os.ReadFile(filepath.Join(dir, rollupBasename))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []rollupEntry
+
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	r := make(rollup, len(entries))
+
+	for _, entry := range entries {
+		vals := entry.rollupValues
+		r[entry.rollupKey] = &vals
+	}
+
+	return r, nil
+}
@@ -0,0 +1,81 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestRollup(t *testing.T) {
+	Convey("Given a rollup of some hits", t, func() {
+		r := make(rollup)
+
+		r.add(&es.Details{AccountingName: "a", NumExecProcs: 1, Job: "j", RunTimeSec: 2, WastedCPUSeconds: 1000})
+		r.add(&es.Details{AccountingName: "a", NumExecProcs: 1, Job: "j", RunTimeSec: 3, WastedMBSeconds: 1e9})
+		r.add(&es.Details{AccountingName: "b", NumExecProcs: 1, Job: "j", RunTimeSec: 4})
+
+		keyA := rollupKey{AccountingName: "a", NumExecProcs: 1, Job: "j"}
+		keyB := rollupKey{AccountingName: "b", NumExecProcs: 1, Job: "j"}
+
+		So(r, ShouldHaveLength, 2)
+		So(r[keyA].DocCount, ShouldEqual, 2)
+		So(r[keyA].Sums["RUN_TIME_SEC"], ShouldEqual, 5)
+		So(r[keyA].Sums["NUM_EXEC_PROCS"], ShouldEqual, 2)
+		So(r[keyA].WastedCost, ShouldAlmostEqual, 1000*wastedCostCPUSecond+1e9*wastedCostMBSecond)
+		So(r[keyB].DocCount, ShouldEqual, 1)
+		So(r[keyB].WastedCost, ShouldEqual, 0)
+
+		Convey("You can merge it with another", func() {
+			other := make(rollup)
+			other.add(&es.Details{AccountingName: "b", NumExecProcs: 1, Job: "j", RunTimeSec: 5})
+			other.add(&es.Details{AccountingName: "c", NumExecProcs: 2, Job: "k", RunTimeSec: 6})
+
+			r.merge(other)
+
+			So(r, ShouldHaveLength, 3)
+			So(r[keyB].DocCount, ShouldEqual, 2)
+			So(r[keyB].Sums["RUN_TIME_SEC"], ShouldEqual, 9)
+			So(r[rollupKey{AccountingName: "c", NumExecProcs: 2, Job: "k"}].DocCount, ShouldEqual, 1)
+		})
+
+		Convey("You can write it to a file and read it back", func() {
+			dir := t.TempDir()
+
+			err := r.write(dir)
+			So(err, ShouldBeNil)
+
+			r2, err := readRollup(dir)
+			So(err, ShouldBeNil)
+			So(r2, ShouldResemble, r)
+
+			_, err = readRollup(t.TempDir())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,152 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// ScheduledBackfill runs Backfill() in the background once a day.
+type ScheduledBackfill struct {
+	client  Scroller
+	config  Config
+	backend Backend
+	at      time.Duration
+	period  time.Duration
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// ScheduleBackfill starts running Backfill() with the given client and config
+// every day at the given time after midnight UTC (eg. 1*time.Hour for 01:00),
+// for the given period up to that midnight. Errors are logged, and the backfill
+// is tried again the next day.
+//
+// If the given Backend is a DB, the days each backfill completes are loaded in
+// to it immediately, without waiting for its UpdateFrequency.
+//
+// Call Stop() on the returned ScheduledBackfill before closing the Backend.
+func ScheduleBackfill(client Scroller, config Config, backend Backend, at, period time.Duration) *ScheduledBackfill {
+	s := &ScheduledBackfill{
+		client:  client,
+		config:  config,
+		backend: backend,
+		at:      at,
+		period:  period,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go s.schedule()
+
+	return s
+}
+
+func (s *ScheduledBackfill) schedule() {
+	defer close(s.done)
+
+	for {
+		next := nextRun(time.Now(), s.at)
+		timer := time.NewTimer(time.Until(next))
+
+		slog.Info("next scheduled backfill", "at", timestamp(next))
+
+		select {
+		case <-timer.C:
+			s.run(time.Now())
+		case <-s.stop:
+			timer.Stop()
+
+			return
+		}
+	}
+}
+
+// nextRun returns the first time after now that is the given duration after a
+// midnight UTC.
+func nextRun(now time.Time, at time.Duration) time.Time {
+	next := now.UTC().Truncate(oneDay).Add(at)
+
+	for !next.After(now) {
+		next = next.Add(oneDay)
+	}
+
+	return next
+}
+
+// run does a Backfill() from the given time, then loads the days it did in to
+// our Backend, if it's a DB.
+func (s *ScheduledBackfill) run(from time.Time) {
+	t := time.Now()
+
+	report, err := Backfill(s.client, s.config, from, s.period)
+	if err != nil {
+		slog.Error("scheduled backfill failed", "err", err)
+	} else {
+		slog.Info("scheduled backfill successful", "took", time.Since(t),
+			"succeeded", report.Succeeded, "skipped", report.Skipped)
+	}
+
+	if d, ok := s.backend.(*DB); ok {
+		d.loadBackfilledDays(from, s.period)
+	}
+}
+
+// Stop stops running backfills, waiting for any current one to finish.
+func (s *ScheduledBackfill) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// loadBackfilledDays loads any days that a Backfill() with the given from and
+// period would have stored that we haven't loaded yet, then updates our index
+// cache if we loaded any.
+func (d *DB) loadBackfilledDays(from time.Time, period time.Duration) {
+	gte, lt := timeRange(from, period)
+	loaded := false
+
+	for day := gte.Add(-oneDay); day.Before(lt); day = day.Add(oneDay) {
+		dateFolder := d.dateFolder(day)
+
+		if _, err := os.Stat(dateFolder); err != nil {
+			continue
+		}
+
+		if d.loadDay(dateFolder) {
+			loaded = true
+		}
+	}
+
+	if !loaded {
+		return
+	}
+
+	if err := d.writeIndexCache(); err != nil {
+		slog.Error("writeIndexCache failed", "err", err)
+	}
+}
@@ -0,0 +1,98 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestScheduleBackfill(t *testing.T) {
+	Convey("nextRun() returns the next time of day after now", t, func() {
+		now := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+
+		So(nextRun(now, time.Hour), ShouldEqual, time.Date(2024, 06, 1, 1, 0, 0, 0, time.UTC))
+		So(nextRun(now, 0), ShouldEqual, time.Date(2024, 06, 2, 0, 0, 0, 0, time.UTC))
+		So(nextRun(now, 30*time.Minute), ShouldEqual, time.Date(2024, 06, 2, 0, 30, 0, 0, time.UTC))
+		So(nextRun(now.Add(time.Hour), time.Hour), ShouldEqual, time.Date(2024, 06, 2, 1, 0, 0, 0, time.UTC))
+	})
+
+	Convey("Given a DB and a scheduled backfill", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: t.TempDir(), UpdateFrequency: time.Hour}
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+		period := 2 * oneDay
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		defer db.Close()
+
+		s := ScheduleBackfill(mock, config, db, time.Hour, period)
+
+		Convey("a run backfills and immediately loads the new days", func() {
+			s.Stop()
+			s.run(from)
+
+			query := rangeQuery(timeRange(from, period))
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+			count, errc := db.Count(query)
+			So(errc, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			db.muDateBOMDirs.RLock()
+			So(len(db.dateBOMDirs), ShouldEqual, 2)
+			db.muDateBOMDirs.RUnlock()
+		})
+
+		Convey("you can Stop() it", func() {
+			stopped := make(chan bool)
+
+			go func() {
+				s.Stop()
+				close(stopped)
+			}()
+
+			ok := false
+
+			select {
+			case <-stopped:
+				ok = true
+			case <-time.After(5 * time.Second):
+			}
+
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,328 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ErrSnapshotBackend = "snapshots are only supported for the flat backend"
+	ErrSnapshotPath    = "snapshot contains an invalid path"
+
+	restoringBasename = ".restoring"
+	dayDirDepth       = 3
+)
+
+// Snapshot writes a zstd compressed tar archive of the configured flat database
+// directory to the given writer, returning the number of days archived.
+//
+// Only days that have been successfully backfilled are archived, so it is safe
+// to take a snapshot while a Backfill() is in progress; the days it is still
+// working on are not included.
+func Snapshot(config Config, w io.Writer) (int, error) {
+	if config.Backend != "" && config.Backend != BackendFlat {
+		return 0, Error{Msg: ErrSnapshotBackend, cause: config.Backend}
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return 0, err
+	}
+
+	tw := tar.NewWriter(zw)
+
+	days, err := archiveDays(config.Directory, tw)
+	if err != nil {
+		return days, err
+	}
+
+	if err = tw.Close(); err != nil {
+		return days, err
+	}
+
+	return days, zw.Close()
+}
+
+// archiveDays adds the files of every successfully backfilled day directory in
+// the given database directory to the given tar writer.
+func archiveDays(dir string, tw *tar.Writer) (int, error) {
+	days := 0
+
+	err := forEachBackfilledDay(dir, func(dayDir string) error {
+		days++
+
+		return archiveDay(dir, dayDir, tw)
+	})
+
+	return days, err
+}
+
+// forEachBackfilledDay calls the given callback with the path of every
+// successfully backfilled day directory in the given database directory, in
+// order.
+func forEachBackfilledDay(dir string, cb func(dayDir string) error) error {
+	return filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || !de.IsDir() || path == dir {
+			return err
+		}
+
+		if isHiddenTopDir(dir, path) {
+			return filepath.SkipDir
+		}
+
+		if dirDepth(dir, path) < dayDirDepth {
+			return nil
+		}
+
+		backfilled, err := dayBackfilled(path)
+		if err != nil {
+			return err
+		}
+
+		if backfilled {
+			if err = cb(path); err != nil {
+				return err
+			}
+		}
+
+		return filepath.SkipDir
+	})
+}
+
+// dirDepth returns how many directories deep the given path is within the
+// given directory.
+func dirDepth(dir, path string) int {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// archiveDay adds the files in the given day directory of the given database
+// directory to the given tar writer, with the day's success sentinel file
+// last.
+func archiveDay(dir, dayDir string, tw *tar.Writer) error {
+	var paths []string
+
+	err := filepath.WalkDir(dayDir, func(path string, de fs.DirEntry, err error) error {
+		if err == nil && de.Type().IsRegular() && de.Name() != successBasename {
+			paths = append(paths, path)
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range append(paths, filepath.Join(dayDir, successBasename)) {
+		if err = archiveFile(dir, path, tw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveFile adds the given file to the given tar writer, named relative to
+// the given database directory.
+func archiveFile(dir, path string, tw *tar.Writer) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(rel),
+		Size:     info.Size(),
+		Mode:     dbFilePerms,
+		ModTime:  info.ModTime(),
+	}); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+
+	return err
+}
+
+// Restore extracts a Snapshot() archive from the given reader in to the
+// configured database directory, returning the number of days restored.
+//
+// Days already in the database directory are skipped, so you can restore a
+// snapshot in to a directory you're already using, eg. to get back days that
+// fsck quarantined. Each day is extracted to a temporary directory and only
+// moved in to place once complete, so a running server will not see partial
+// days.
+func Restore(config Config, r io.Reader) (int, error) {
+	if err := config.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	defer zr.Close()
+
+	tmpDir := filepath.Join(config.Directory, restoringBasename)
+
+	if err = os.RemoveAll(tmpDir); err != nil {
+		return 0, err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	if err = extractArchive(tar.NewReader(zr), tmpDir); err != nil {
+		return 0, err
+	}
+
+	return moveRestoredDays(tmpDir, config.Directory)
+}
+
+// extractArchive extracts the regular files in the given tar reader to the
+// given directory.
+func extractArchive(tr *tar.Reader, dir string) error {
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if !filepath.IsLocal(header.Name) || dirDepth(".", header.Name) <= dayDirDepth {
+			return Error{Msg: ErrSnapshotPath, cause: header.Name}
+		}
+
+		if err = extractFile(tr, filepath.Join(dir, filepath.FromSlash(header.Name))); err != nil {
+			return err
+		}
+	}
+}
+
+// extractFile writes the current file in the given tar reader to the given
+// path.
+func extractFile(tr *tar.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), dbDirPerms); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, dbFilePerms)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(f, tr); err != nil { //nolint:gosec
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// moveRestoredDays moves each successfully backfilled day directory in the
+// given restoring directory in to the given database directory, unless it
+// already has that day. Returns the number of days moved.
+func moveRestoredDays(tmpDir, dir string) (int, error) {
+	dayDirs, err := filepath.Glob(filepath.Join(tmpDir, "*", "*", "*"))
+	if err != nil {
+		return 0, err
+	}
+
+	days := 0
+
+	for _, dayDir := range dayDirs {
+		if backfilled, errb := dayBackfilled(dayDir); errb != nil || !backfilled {
+			continue
+		}
+
+		rel, errr := filepath.Rel(tmpDir, dayDir)
+		if errr != nil {
+			return days, errr
+		}
+
+		moved, errm := moveRestoredDay(dayDir, filepath.Join(dir, rel))
+		if errm != nil {
+			return days, errm
+		}
+
+		if moved {
+			days++
+		}
+	}
+
+	return days, nil
+}
+
+// moveRestoredDay renames from to to, unless to already exists. Returns true
+// if it was renamed.
+func moveRestoredDay(from, to string) (bool, error) {
+	if _, err := os.Stat(to); err == nil {
+		slog.Warn("skip restoring day already present", "dir", to)
+
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), dbDirPerms); err != nil {
+		return false, err
+	}
+
+	return true, os.Rename(from, to)
+}
@@ -0,0 +1,103 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestSnapshot(t *testing.T) {
+	Convey("Given a backfilled database with a day still being backfilled", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		config := Config{Directory: t.TempDir()}
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+
+		_, err := Backfill(es.NewMock("some-indexes-*"), config, from, 2*oneDay)
+		So(err, ShouldBeNil)
+
+		inProgress := filepath.Join(config.Directory, "2024", "05", "28", "bom")
+		So(os.MkdirAll(inProgress, dbDirPerms), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(inProgress, "0.index"), nil, dbFilePerms), ShouldBeNil)
+
+		Convey("You can Snapshot() the completed days and Restore() them elsewhere", func() {
+			var buf bytes.Buffer
+
+			days, err := Snapshot(config, &buf)
+			So(err, ShouldBeNil)
+			So(days, ShouldEqual, 3)
+
+			restoreConfig := Config{Directory: t.TempDir()}
+
+			days, err = Restore(restoreConfig, bytes.NewReader(buf.Bytes()))
+			So(err, ShouldBeNil)
+			So(days, ShouldEqual, 3)
+
+			_, err = os.Stat(filepath.Join(restoreConfig.Directory, "2024", "05", "28"))
+			So(err, ShouldNotBeNil)
+
+			_, err = os.Stat(filepath.Join(restoreConfig.Directory, restoringBasename))
+			So(err, ShouldNotBeNil)
+
+			for _, rel := range []string{
+				filepath.Join("2024", "05", "31", successBasename),
+				filepath.Join("2024", "05", "31", "Human Genetics", "0.index"),
+			} {
+				orig, errr := os.ReadFile(filepath.Join(config.Directory, rel))
+				So(errr, ShouldBeNil)
+
+				restored, errr := os.ReadFile(filepath.Join(restoreConfig.Directory, rel))
+				So(errr, ShouldBeNil)
+				So(restored, ShouldResemble, orig)
+			}
+
+			problems, err := Fsck(restoreConfig)
+			So(err, ShouldBeNil)
+			So(problems, ShouldBeEmpty)
+
+			Convey("Restoring again skips days already present", func() {
+				days, err = Restore(restoreConfig, bytes.NewReader(buf.Bytes()))
+				So(err, ShouldBeNil)
+				So(days, ShouldEqual, 0)
+			})
+		})
+
+		Convey("You can't Snapshot() other backends", func() {
+			config.Backend = BackendSQLite
+
+			_, err = Snapshot(config, &bytes.Buffer{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
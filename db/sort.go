@@ -0,0 +1,161 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bytes"
+	"container/heap"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// setHitOrder sets the hit index of every entry in the given runs, each of
+// which must be in ascending timestamp order (as the entries of a flatIndex
+// are), so that the hits will be in the given order. For es.SortNone the hits
+// simply follow the runs, otherwise the runs are merged by timestamp without
+// needing to sort all the entries.
+func setHitOrder(runs [][]localDataEntry, order es.SortOrder) {
+	if order == es.SortNone {
+		hitIndex := 0
+
+		for _, run := range runs {
+			for i := range run {
+				run[i].hit = hitIndex
+				hitIndex++
+			}
+		}
+
+		return
+	}
+
+	merger := newRunMerger(runs, order == es.SortTimestampDesc)
+
+	for hitIndex := 0; merger.Len() > 0; hitIndex++ {
+		merger.next().hit = hitIndex
+	}
+}
+
+// ascendingRuns splits the given entries in to sub-slices wherever the
+// timestamp decreases, such that each is in ascending timestamp order.
+func ascendingRuns(ldes []localDataEntry) [][]localDataEntry {
+	var runs [][]localDataEntry
+
+	start := 0
+
+	for i := 1; i < len(ldes); i++ {
+		if bytes.Compare(ldes[i].entry.timeStamp, ldes[i-1].entry.timeStamp) < 0 {
+			runs = append(runs, ldes[start:i])
+			start = i
+		}
+	}
+
+	if len(ldes) > start {
+		runs = append(runs, ldes[start:])
+	}
+
+	return runs
+}
+
+// runCursor is the position of the next entry to merge from a run.
+type runCursor struct {
+	run []localDataEntry
+	pos int
+}
+
+func (c *runCursor) current() *localDataEntry {
+	return &c.run[c.pos]
+}
+
+// runMerger is a heap of runCursors that yields the entries of all its runs in
+// timestamp order. When descending, runs are consumed from their ends.
+type runMerger struct {
+	cursors    []*runCursor
+	descending bool
+}
+
+func newRunMerger(runs [][]localDataEntry, descending bool) *runMerger {
+	m := &runMerger{descending: descending}
+
+	for _, run := range runs {
+		if len(run) == 0 {
+			continue
+		}
+
+		c := &runCursor{run: run}
+		if descending {
+			c.pos = len(run) - 1
+		}
+
+		m.cursors = append(m.cursors, c)
+	}
+
+	heap.Init(m)
+
+	return m
+}
+
+func (m *runMerger) Len() int { return len(m.cursors) }
+
+func (m *runMerger) Less(i, j int) bool {
+	cmp := bytes.Compare(m.cursors[i].current().entry.timeStamp, m.cursors[j].current().entry.timeStamp)
+	if m.descending {
+		return cmp > 0
+	}
+
+	return cmp < 0
+}
+
+func (m *runMerger) Swap(i, j int) { m.cursors[i], m.cursors[j] = m.cursors[j], m.cursors[i] }
+
+func (m *runMerger) Push(x interface{}) { m.cursors = append(m.cursors, x.(*runCursor)) } //nolint:forcetypeassert
+
+func (m *runMerger) Pop() interface{} {
+	last := m.cursors[len(m.cursors)-1]
+	m.cursors = m.cursors[:len(m.cursors)-1]
+
+	return last
+}
+
+// next returns the next entry in timestamp order and advances past it. Only
+// call when Len() > 0.
+func (m *runMerger) next() *localDataEntry {
+	c := m.cursors[0]
+	lde := c.current()
+
+	if m.descending {
+		c.pos--
+	} else {
+		c.pos++
+	}
+
+	if c.pos < 0 || c.pos >= len(c.run) {
+		heap.Pop(m)
+	} else {
+		heap.Fix(m, 0)
+	}
+
+	return lde
+}
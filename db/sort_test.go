@@ -0,0 +1,172 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestSort(t *testing.T) {
+	Convey("Given a database with hits over several days and files", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   300,
+			BufferSize: bufferSize,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		var expected []int64
+
+		for day := range 3 {
+			for i := range 10 {
+				ts := gte.Add(time.Duration(day)*oneDay + time.Duration(i*(day+1))*time.Minute).Unix()
+				expected = append(expected, ts)
+
+				hitCh <- &es.Hit{ID: strconv.Itoa(day*10 + i), Details: &es.Details{
+					Timestamp: ts,
+					BOM:       "bomA",
+					UserName:  "user" + strconv.Itoa(i%2),
+					Command:   "cmd",
+				}}
+			}
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := func(sort ...string) *es.Query {
+			return &es.Query{
+				Sort:   sort,
+				Source: []string{"USER_NAME", "timestamp"},
+				Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+					rangeFilter("lt", gte, gte.Add(3*oneDay)),
+					{"match_phrase": {"BOM": "bomA"}},
+				}}},
+			}
+		}
+
+		timestamps := func(result *es.Result) []int64 {
+			ts := make([]int64, len(result.HitSet.Hits))
+			for i, hit := range result.HitSet.Hits {
+				ts[i] = hit.Details.Timestamp
+			}
+
+			return ts
+		}
+
+		descending := make([]int64, len(expected))
+		for i, ts := range expected {
+			descending[len(expected)-1-i] = ts
+		}
+
+		Convey("Scroll returns hits in timestamp order when sorted on timestamp", func() {
+			result, err := db.Scroll(query("timestamp", "_doc"))
+			So(err, ShouldBeNil)
+			So(timestamps(result), ShouldResemble, expected)
+			So(result.HitSet.Hits[0].Details.UserName, ShouldEqual, "user0")
+			db.Done(result.PoolKey)
+
+			result, err = db.Scroll(query("timestamp:desc"))
+			So(err, ShouldBeNil)
+			So(timestamps(result), ShouldResemble, descending)
+			db.Done(result.PoolKey)
+
+			result, err = db.Scroll(query("_doc"))
+			So(err, ShouldBeNil)
+
+			got := timestamps(result)
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			So(got, ShouldResemble, expected)
+			db.Done(result.PoolKey)
+		})
+
+		Convey("MultiScroll sorts each query's hits by its own Sort", func() {
+			results, err := db.MultiScroll([]*es.Query{query("timestamp:desc"), query("timestamp:asc")})
+			So(err, ShouldBeNil)
+			So(timestamps(results[0]), ShouldResemble, descending)
+			So(timestamps(results[1]), ShouldResemble, expected)
+			db.Done(results[0].PoolKey)
+		})
+
+		Convey("Sorting on other fields is an error", func() {
+			_, err := db.Scroll(query("USER_NAME"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, es.ErrUnsupportedSort)
+
+			_, err = db.MultiScroll([]*es.Query{query("_doc:desc")})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("ascendingRuns splits entries where timestamps decrease", t, func() {
+		lde := func(ts int64) localDataEntry {
+			return localDataEntry{entry: &flatIndexEntry{timeStamp: i64tob(ts)}}
+		}
+
+		ldes := []localDataEntry{lde(1), lde(3), lde(3), lde(2), lde(5), lde(0)}
+		runs := ascendingRuns(ldes)
+		So(len(runs), ShouldEqual, 3)
+		So(len(runs[0]), ShouldEqual, 3)
+		So(len(runs[1]), ShouldEqual, 2)
+		So(len(runs[2]), ShouldEqual, 1)
+
+		setHitOrder(runs, es.SortTimestampAsc)
+
+		order := make([]int, len(ldes))
+		for i, l := range ldes {
+			order[i] = l.hit
+		}
+
+		So(order, ShouldResemble, []int{1, 3, 4, 2, 5, 0})
+		So(ascendingRuns(nil), ShouldBeEmpty)
+	})
+}
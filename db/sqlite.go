@@ -0,0 +1,743 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+const (
+	sqliteBasename      = "farmer.sqlite"
+	sqliteBatchSize     = 10000
+	sqliteBusyTimeoutMS = 60000
+	sqliteGPUColumn     = "substr(queue_name, 1, 3) = 'gpu'"
+
+	sqliteSchema = `
+CREATE TABLE IF NOT EXISTS hits (
+	timestamp INTEGER NOT NULL,
+	bom TEXT NOT NULL,
+	accounting_name TEXT NOT NULL,
+	user_name TEXT NOT NULL,
+	queue_name TEXT NOT NULL,
+	details BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS hits_timestamp ON hits (timestamp);
+CREATE INDEX IF NOT EXISTS hits_bom_timestamp ON hits (bom, timestamp);
+CREATE TABLE IF NOT EXISTS backfilled_days (day TEXT PRIMARY KEY);
+CREATE TABLE IF NOT EXISTS data_version (id INTEGER PRIMARY KEY CHECK (id = 1), version INTEGER NOT NULL);
+`
+
+	sqliteCoverage = `SELECT c.day, c.bom, c.hits, b.day IS NOT NULL
+FROM (SELECT strftime('%Y/%m/%d', timestamp, 'unixepoch') AS day, bom, COUNT(*) AS hits
+	FROM hits GROUP BY day, bom) c
+LEFT JOIN backfilled_days b ON b.day = c.day`
+
+	sqliteBumpDataVersion = `INSERT INTO data_version (id, version) VALUES (1, 1)
+ON CONFLICT (id) DO UPDATE SET version = version + 1`
+)
+
+// SQLiteDB is a Backend that stores hit details in a single SQLite database
+// file. It is slower than DB for large amounts of data, but gives you ACID
+// storage that you can inspect with standard SQL tools.
+type SQLiteDB struct {
+	queryLimits
+	*badHits
+	bomSelection *bomSelection
+	db           *sql.DB
+	muWrite      sync.Mutex
+}
+
+// NewSQLite returns an SQLiteDB that uses (creating if necessary) a
+// farmer.sqlite database file in the configured Directory. Only the MaxHits,
+// MaxBytes, SkipBadHits, SkipBOMs, OnlyBOMs and ReadOnly Config options are
+// used; if ReadOnly, the database file must already exist. Returns an
+// ErrClustersBackend or ErrExtraDirsBackend Error if Clusters or
+// ExtraDirectories are configured.
+func NewSQLite(config Config) (*SQLiteDB, error) {
+	if len(config.Clusters) > 0 {
+		return nil, Error{Msg: ErrClustersBackend, cause: BackendSQLite}
+	}
+
+	if len(config.ExtraDirectories) > 0 {
+		return nil, Error{Msg: ErrExtraDirsBackend, cause: BackendSQLite}
+	}
+
+	if config.ReadOnly {
+		return openSQLiteReadOnly(config)
+	}
+
+	if err := os.MkdirAll(config.Directory, dbDirPerms); err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)",
+		filepath.Join(config.Directory, sqliteBasename), sqliteBusyTimeoutMS)
+
+	sdb, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = sdb.Exec(sqliteSchema); err != nil {
+		sdb.Close()
+
+		return nil, err
+	}
+
+	return newSQLiteDB(config, sdb), nil
+}
+
+// openSQLiteReadOnly opens the existing database file in the configured
+// Directory without creating or modifying it, so that Store() fails.
+func openSQLiteReadOnly(config Config) (*SQLiteDB, error) {
+	path := filepath.Join(config.Directory, sqliteBasename)
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(%d)", path, sqliteBusyTimeoutMS)
+
+	sdb, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSQLiteDB(config, sdb), nil
+}
+
+func newSQLiteDB(config Config, sdb *sql.DB) *SQLiteDB {
+
+	return &SQLiteDB{
+		queryLimits:  queryLimits{maxHits: config.MaxHits, maxBytes: config.MaxBytes},
+		badHits:      newBadHits(config.SkipBadHits),
+		bomSelection: newBOMSelection(config.SkipBOMs, config.OnlyBOMs),
+		db:           sdb,
+	}
+}
+
+// sqliteRow holds the column values of a hit in our hits table.
+type sqliteRow struct {
+	timestamp      int64
+	bom            string
+	accountingName string
+	userName       string
+	queueName      string
+	details        []byte
+}
+
+func newSQLiteRow(hit *es.Hit) (sqliteRow, error) {
+	encoded, err := hit.Details.Serialize() //nolint:misspell
+	if err != nil {
+		return sqliteRow{}, err
+	}
+
+	return sqliteRow{
+		timestamp:      hit.Details.Timestamp,
+		bom:            hit.Details.BOM,
+		accountingName: hit.Details.AccountingName,
+		userName:       hit.Details.UserName,
+		queueName:      hit.Details.QueueName,
+		details:        encoded,
+	}, nil
+}
+
+// Store stores the Details in the Hits from the channel in our database file,
+// in batches. Unlike DB, what you Store() is immediately available to
+// Scroll(), and you can call Store() concurrently. Hits that can't be stored
+// are handled as per DB.Store().
+func (s *SQLiteDB) Store(hitCh chan *es.Hit) error {
+	batch := make([]sqliteRow, 0, sqliteBatchSize)
+
+	for hit := range hitCh {
+		if !s.bomSelection.storedHit(hit) {
+			continue
+		}
+
+		row, err := newSQLiteRow(hit)
+		if err != nil {
+			if err = s.badHits.handle(hit, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		batch = append(batch, row)
+
+		if len(batch) < sqliteBatchSize {
+			continue
+		}
+
+		if err = s.insert(batch); err != nil {
+			return err
+		}
+
+		batch = batch[:0]
+	}
+
+	return s.insert(batch)
+}
+
+// insert inserts the given rows in a single transaction. Only one insert
+// happens at a time, since SQLite only allows a single writer.
+func (s *SQLiteDB) insert(rows []sqliteRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	s.muWrite.Lock()
+	defer s.muWrite.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO hits (timestamp, bom, accounting_name, user_name, " +
+		"queue_name, details) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return rollback(tx, err)
+	}
+
+	defer stmt.Close()
+
+	for _, r := range rows {
+		if _, err = stmt.Exec(r.timestamp, r.bom, r.accountingName, r.userName, r.queueName, r.details); err != nil {
+			return rollback(tx, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func rollback(tx *sql.Tx, err error) error {
+	tx.Rollback() //nolint:errcheck
+
+	return err
+}
+
+// startDay returns false if the given day was already backfilled. Otherwise it
+// deletes any hits partially stored for that day by an earlier failed
+// backfill.
+func (s *SQLiteDB) startDay(day time.Time) (bool, error) {
+	var n int
+
+	err := s.db.QueryRow("SELECT COUNT(*) FROM backfilled_days WHERE day = ?", timestampToDay(day.Unix())).Scan(&n)
+	if err != nil || n > 0 {
+		return false, err
+	}
+
+	s.muWrite.Lock()
+	defer s.muWrite.Unlock()
+
+	_, err = s.db.Exec("DELETE FROM hits WHERE timestamp >= ? AND timestamp < ?",
+		day.Unix(), day.Add(oneDay).Unix())
+
+	return err == nil, err
+}
+
+// DataThrough returns the latest day that was completely backfilled, or the
+// zero time if none have been.
+func (s *SQLiteDB) DataThrough() time.Time {
+	var day sql.NullString
+
+	err := s.db.QueryRow("SELECT MAX(day) FROM backfilled_days").Scan(&day)
+	if err != nil || !day.Valid {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(dateFormat, day.String)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// DataVersion returns a string that changes whenever a day finishes being
+// backfilled, or starts being backfilled again, eg. for use in HTTP ETags.
+func (s *SQLiteDB) DataVersion() string {
+	var version int64
+
+	err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM data_version").Scan(&version)
+	if err != nil {
+		return ""
+	}
+
+	return strconv.FormatInt(version, 10)
+}
+
+// Reload does nothing, since backfilled days are visible as soon as they're
+// stored.
+func (s *SQLiteDB) Reload() error {
+	return nil
+}
+
+// storeDay is Store(), for dayBackfiller.
+func (s *SQLiteDB) storeDay(_ time.Time, hitCh chan *es.Hit) error {
+	return s.Store(hitCh)
+}
+
+// finishDay records that the given day has been completely backfilled.
+func (s *SQLiteDB) finishDay(day time.Time) error {
+	s.muWrite.Lock()
+	defer s.muWrite.Unlock()
+
+	_, err := s.db.Exec("INSERT OR IGNORE INTO backfilled_days (day) VALUES (?)", timestampToDay(day.Unix()))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(sqliteBumpDataVersion)
+
+	return err
+}
+
+// restartDay removes the record of the given day being backfilled, and its
+// hits.
+func (s *SQLiteDB) restartDay(day time.Time) error {
+	s.muWrite.Lock()
+	defer s.muWrite.Unlock()
+
+	_, err := s.db.Exec("DELETE FROM backfilled_days WHERE day = ?", timestampToDay(day.Unix()))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("DELETE FROM hits WHERE timestamp >= ? AND timestamp < ?",
+		day.Unix(), day.Add(oneDay).Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(sqliteBumpDataVersion)
+
+	return err
+}
+
+// sqliteWhere returns an SQL WHERE clause, and its arguments, that selects the
+// hits in the query's date range that pass its BOM, ACCOUNTING_NAME, USER_NAME
+// and QUEUE_NAME filters. Other filters must be applied with filterUnindexed().
+func sqliteWhere(query *es.Query) (string, []any, error) {
+	lt, lte, gte, err := query.DateRange()
+	if err != nil {
+		return "", nil, err
+	}
+
+	conds := []string{"timestamp >= ?", "timestamp < ?"}
+	args := []any{gte.Unix(), lt.Unix()}
+
+	if !lte.IsZero() {
+		conds[1] = "timestamp <= ?"
+		args[1] = lte.Unix()
+	}
+
+	filters := query.Filters()
+
+	for field, column := range map[string]string{
+		"BOM": "bom", "ACCOUNTING_NAME": "accounting_name", "USER_NAME": "user_name",
+	} {
+		if val, ok := filters[field]; ok {
+			conds = append(conds, column+" = ?")
+			args = append(args, val)
+		}
+	}
+
+	if prefix, ok := query.PrefixFilters()["QUEUE_NAME"]; ok {
+		conds = append(conds, "substr(queue_name, 1, length(?)) = ?")
+		args = append(args, prefix, prefix)
+	} else if qname, ok := query.MatchFilters()["QUEUE_NAME"]; ok {
+		conds = append(conds, "queue_name = ?")
+		args = append(args, qname)
+	}
+
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// Scroll returns all the hits that pass the filters in the given query, in the
+// query's timestamp date range, like DB.Scroll(). Hits are in timestamp order,
+// descending if that's what the query's Sort asks for. The returned Result does
+// not use a buffer pool, so calling Done() is optional.
+func (s *SQLiteDB) Scroll(query *es.Query) (*es.Result, error) {
+	if err := s.bomSelection.checkQuery(query); err != nil {
+		return nil, err
+	}
+
+	order, err := query.TimestampSort()
+	if err != nil {
+		return nil, err
+	}
+
+	orderBy := " ORDER BY timestamp"
+	if order == es.SortTimestampDesc {
+		orderBy += " DESC"
+	}
+
+	where, args, err := sqliteWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns, err := newPatternMatchers(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(query.Context(),
+		"SELECT details FROM hits WHERE "+where+orderBy, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	hits, err := s.scanHits(rows, patternDesiredFields(query.DesiredFields(), patterns))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &es.Result{
+		ScrollID: pretendScrollID,
+		HitSet: &es.HitSet{
+			Total: es.HitSetTotal{Value: len(hits)},
+			Hits:  hits,
+		},
+	}
+
+	return filterUnindexed(result, query, patterns), nil
+}
+
+// scanHits deserializes the details column of the given rows in to Hits.
+func (s *SQLiteDB) scanHits(rows *sql.Rows, desired es.Fields) ([]es.Hit, error) {
+	var (
+		hits     []es.Hit
+		numBytes int
+	)
+
+	for rows.Next() {
+		var encoded []byte
+
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+
+		numBytes += len(encoded)
+
+		if err := s.checkQuerySize(len(hits)+1, numBytes); err != nil {
+			return nil, err
+		}
+
+		details, err := es.DeserializeDetails(encoded, desired)
+		if err != nil {
+			return nil, err
+		}
+
+		hits = append(hits, es.Hit{ID: details.ID, Details: details})
+	}
+
+	return hits, rows.Err()
+}
+
+// MultiScroll is like calling Scroll() on each query.
+func (s *SQLiteDB) MultiScroll(queries []*es.Query) ([]*es.Result, error) {
+	results := make([]*es.Result, len(queries))
+
+	for i, query := range queries {
+		result, err := s.Scroll(query)
+		if err != nil {
+			return nil, err
+		}
+
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// Done does nothing, since our Results don't use a buffer pool. It always
+// returns false.
+func (s *SQLiteDB) Done(int) bool {
+	return false
+}
+
+// Usernames is the same as calling DistinctValues() with USER_NAME.
+func (s *SQLiteDB) Usernames(query *es.Query) ([]string, error) {
+	return s.DistinctValues(query, "USER_NAME")
+}
+
+// DistinctValues is like DB.DistinctValues(). Unless the query has filters on
+// properties we don't have columns for, this is answered purely with SQL.
+func (s *SQLiteDB) DistinctValues(query *es.Query, field string) ([]string, error) {
+	if err := es.ValidateDistinctField(field); err != nil {
+		return nil, err
+	}
+
+	if err := s.bomSelection.checkQuery(query); err != nil {
+		return nil, err
+	}
+
+	if hasNonIndexFilters(query) {
+		return s.distinctValuesByScrolling(query, field)
+	}
+
+	where, args, err := sqliteWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(query.Context(),
+		"SELECT DISTINCT "+sqliteDistinctColumn(field)+" FROM hits WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var values []string
+
+	for rows.Next() {
+		var val string
+
+		if err = rows.Scan(&val); err != nil {
+			return nil, err
+		}
+
+		values = append(values, val)
+	}
+
+	return values, rows.Err()
+}
+
+// sqliteDistinctColumn returns the SQL expression for the value of the given
+// valid DistinctValues() field.
+func sqliteDistinctColumn(field string) string {
+	switch field {
+	case "ACCOUNTING_NAME":
+		return "accounting_name"
+	case "USER_NAME":
+		return "user_name"
+	case distinctBOM:
+		return "bom"
+	default:
+		return "CASE WHEN " + sqliteGPUColumn + " THEN '" + es.GPUFlag(true) +
+			"' ELSE '" + es.GPUFlag(false) + "' END"
+	}
+}
+
+func (s *SQLiteDB) distinctValuesByScrolling(query *es.Query, field string) ([]string, error) {
+	counts, err := s.distinctCountsByScrolling(query, field)
+
+	return mapKeys(counts), err
+}
+
+// DistinctCounts is like DB.DistinctCounts(). Unless the query has filters on
+// properties we don't have columns for, this is answered purely with SQL.
+func (s *SQLiteDB) DistinctCounts(query *es.Query, field string) (map[string]int, error) {
+	if err := validateDistinctCountsField(field); err != nil {
+		return nil, err
+	}
+
+	if err := s.bomSelection.checkQuery(query); err != nil {
+		return nil, err
+	}
+
+	if hasNonIndexFilters(query) {
+		return s.distinctCountsByScrolling(query, field)
+	}
+
+	where, args, err := sqliteWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	col := sqliteDistinctColumn(field)
+
+	rows, err := s.db.QueryContext(query.Context(),
+		"SELECT "+col+", COUNT(*) FROM hits WHERE "+where+" GROUP BY "+col, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	counts := make(map[string]int)
+
+	for rows.Next() {
+		var (
+			val string
+			n   int
+		)
+
+		if err = rows.Scan(&val, &n); err != nil {
+			return nil, err
+		}
+
+		counts[val] = n
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *SQLiteDB) distinctCountsByScrolling(query *es.Query, field string) (map[string]int, error) {
+	result, err := s.Scroll(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return countDistinctValues(result.HitSet.Hits, field)
+}
+
+// TeamSummary is like DB.TeamSummary(), but always reads each day's hits,
+// since we don't have rollups.
+func (s *SQLiteDB) TeamSummary(ctx context.Context, cluster, bom string, first, last time.Time) (*TeamSummary, error) {
+	if err := s.bomSelection.check(bom); err != nil {
+		return nil, err
+	}
+
+	if err := checkDefaultCluster(cluster); err != nil {
+		return nil, err
+	}
+
+	return teamSummary(ctx, bom, first, last, func(day time.Time) (rollup, error) {
+		return scrolledRollup(s, bomDayQuery(ctx, "", bom, day))
+	})
+}
+
+// GPUReport is like DB.GPUReport(), but always reads each day's gpu queue
+// hits, since we don't have gpu rollups.
+func (s *SQLiteDB) GPUReport(ctx context.Context, cluster, bom string, first, last time.Time) (*GPUReport, error) {
+	if err := s.bomSelection.check(bom); err != nil {
+		return nil, err
+	}
+
+	if err := checkDefaultCluster(cluster); err != nil {
+		return nil, err
+	}
+
+	return gpuReport(ctx, bom, first, last, func(day time.Time) (*gpuRollup, error) {
+		return scrolledGPURollup(s, gpuDayQuery(ctx, "", bom, day))
+	})
+}
+
+// Coverage is like DB.Coverage(), but counts the hits of each day and BOM in
+// our hits table. Days that haven't been completely backfilled are Partial.
+func (s *SQLiteDB) Coverage(ctx context.Context) ([]DayCoverage, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteCoverage)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	c := make(coverage)
+
+	for rows.Next() {
+		var (
+			dayStr, bom string
+			hits        int
+			backfilled  bool
+		)
+
+		if err = rows.Scan(&dayStr, &bom, &hits, &backfilled); err != nil {
+			return nil, err
+		}
+
+		day, err := time.Parse(dateFormat, dayStr)
+		if err != nil {
+			return nil, err
+		}
+
+		c.add(day, !backfilled, BOMCoverage{BOM: bom, Hits: hits})
+	}
+
+	return c.days(), rows.Err()
+}
+
+// Aggregate answers "stats" and "percentiles" aggregations of a numeric field
+// by scrolling the matching hits. Returns false for other aggregations.
+func (s *SQLiteDB) Aggregate(query *es.Query) (*es.Result, bool, error) {
+	if s.bomSelection.checkQuery(query) != nil {
+		return nil, false, nil
+	}
+
+	return metricAggregate(s, query)
+}
+
+// Count is like Scroll(), but only returns the number of matching hits. Unless
+// the query has filters on properties we don't have columns for, this is
+// answered purely with SQL.
+func (s *SQLiteDB) Count(query *es.Query) (int, error) {
+	if err := s.bomSelection.checkQuery(query); err != nil {
+		return 0, err
+	}
+
+	if hasNonIndexFilters(query) {
+		result, err := s.Scroll(query)
+		if err != nil {
+			return 0, err
+		}
+
+		return result.HitSet.Total.Value, nil
+	}
+
+	where, args, err := sqliteWhere(query)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+
+	err = s.db.QueryRowContext(query.Context(), "SELECT COUNT(*) FROM hits WHERE "+where, args...).Scan(&count)
+
+	return count, err
+}
+
+// SetUpdateFrequency does nothing, since we see newly backfilled days right
+// away. It is here to satisfy Backend.
+func (s *SQLiteDB) SetUpdateFrequency(time.Duration) {}
+
+// Drain does nothing, since our Results don't hold pooled buffers, and Close()
+// waits for running queries to finish. It is here to satisfy Backend.
+func (s *SQLiteDB) Drain(context.Context) error {
+	return nil
+}
+
+// Close closes our database file.
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,379 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestSQLite(t *testing.T) {
+	gteStr := "2024-02-04T00:00:00Z"
+	lteStr := "2024-02-04T06:00:00Z"
+	bomA := "bomA"
+
+	Convey("Given an SQLiteDB with stored hits", t, func() {
+		config := Config{Directory: t.TempDir(), Backend: BackendSQLite}
+
+		backend, err := Open(config, false)
+		So(err, ShouldBeNil)
+
+		sdb, ok := backend.(*SQLiteDB)
+		So(ok, ShouldBeTrue)
+
+		defer sdb.Close()
+
+		gte, err := time.Parse(time.RFC3339, gteStr)
+		So(err, ShouldBeNil)
+
+		lte, err := time.Parse(time.RFC3339, lteStr)
+		So(err, ShouldBeNil)
+
+		result := makeResult(gte, lte)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- sdb.Store(hitCh)
+		}()
+
+		for _, hit := range result.HitSet.Hits {
+			hitCh <- &hit
+		}
+
+		close(hitCh)
+
+		So(<-errCh, ShouldBeNil)
+
+		var bomAHits []es.Hit
+
+		for _, hit := range result.HitSet.Hits {
+			if hit.Details.BOM == bomA {
+				bomAHits = append(bomAHits, hit)
+			}
+		}
+
+		query := &es.Query{
+			Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				{"match_phrase": map[string]interface{}{"META_CLUSTER_NAME": "farm"}},
+				{"range": map[string]interface{}{
+					"timestamp": map[string]string{
+						"lte":    lteStr,
+						"gte":    gteStr,
+						"format": "strict_date_optional_time",
+					},
+				}},
+				{"match_phrase": map[string]interface{}{"BOM": bomA}},
+			}}},
+		}
+
+		Convey("You can Scroll() and Count() hits", func() {
+			retrieved, err := sdb.Scroll(query)
+			So(err, ShouldBeNil)
+			So(retrieved.ScrollID, ShouldEqual, pretendScrollID)
+			So(retrieved.HitSet.Total.Value, ShouldEqual, len(bomAHits))
+			So(len(retrieved.HitSet.Hits), ShouldEqual, len(bomAHits))
+			So(retrieved.HitSet.Hits[0].Details, ShouldResemble, bomAHits[0].Details)
+			So(retrieved.HitSet.Hits[len(bomAHits)-1].Details, ShouldResemble, bomAHits[len(bomAHits)-1].Details)
+			So(sdb.Done(retrieved.PoolKey), ShouldBeFalse)
+
+			count, err := sdb.Count(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, len(bomAHits))
+
+			results, err := sdb.MultiScroll([]*es.Query{query, query})
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 2)
+			So(results[1].HitSet.Total.Value, ShouldEqual, len(bomAHits))
+		})
+
+		Convey("You can filter on queue and job name prefixes, and choose fields", func() {
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"prefix": map[string]interface{}{"QUEUE_NAME": "gpu"}},
+				map[string]es.MapStringStringOrMap{"prefix": map[string]interface{}{"JOB_NAME": "nf-"}})
+			query.Source = []string{"JOB_NAME"}
+
+			expected := 0
+
+			for _, hit := range bomAHits {
+				if strings.HasPrefix(hit.Details.QueueName, "gpu") && strings.HasPrefix(hit.Details.JobName, "nf-") {
+					expected++
+				}
+			}
+
+			So(expected, ShouldBeGreaterThan, 0)
+
+			retrieved, err := sdb.Scroll(query)
+			So(err, ShouldBeNil)
+			So(len(retrieved.HitSet.Hits), ShouldEqual, expected)
+			So(retrieved.HitSet.Hits[0].Details.JobName, ShouldEqual, "nf-foo")
+			So(retrieved.HitSet.Hits[0].Details.QueueName, ShouldEqual, "")
+
+			count, err := sdb.Count(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, expected)
+		})
+
+		Convey("You can filter on job name wildcards", func() {
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"wildcard": map[string]interface{}{"JOB_NAME": "*-f?o"}})
+			query.Source = []string{"USER_NAME"}
+
+			expected := 0
+
+			for _, hit := range bomAHits {
+				if hit.Details.JobName == "nf-foo" {
+					expected++
+				}
+			}
+
+			So(expected, ShouldBeGreaterThan, 0)
+
+			retrieved, err := sdb.Scroll(query)
+			So(err, ShouldBeNil)
+			So(len(retrieved.HitSet.Hits), ShouldEqual, expected)
+
+			count, err := sdb.Count(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, expected)
+		})
+
+		Convey("You can get DistinctValues() and Usernames()", func() {
+			usernames, err := sdb.Usernames(query)
+			So(err, ShouldBeNil)
+
+			sort.Strings(usernames)
+			So(usernames, ShouldResemble, []string{"userA", "userB", "userNameLongest"})
+
+			values, err := sdb.DistinctValues(query, es.DistinctGPU)
+			So(err, ShouldBeNil)
+
+			sort.Strings(values)
+			So(values, ShouldResemble, []string{"false", "true"})
+
+			_, err = sdb.DistinctValues(query, "JOB_NAME")
+			So(err, ShouldNotBeNil)
+
+			anyBOMQuery := &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: query.Query.Bool.Filter[:2]}}}
+			values, err = sdb.DistinctValues(anyBOMQuery, "BOM")
+			So(err, ShouldBeNil)
+
+			sort.Strings(values)
+			So(values, ShouldResemble, []string{bomA, "bomB", "bomC–IDS"})
+
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"prefix": map[string]interface{}{"JOB_NAME": "nf-"}})
+
+			values, err = sdb.DistinctValues(query, "ACCOUNTING_NAME")
+			So(err, ShouldBeNil)
+
+			sort.Strings(values)
+			So(values, ShouldResemble, []string{"groupA", "groupB"})
+		})
+
+		Convey("You can get a TeamSummary()", func() {
+			ts, err := sdb.TeamSummary(context.Background(), "", bomA, gte, gte)
+			So(err, ShouldBeNil)
+			So(ts.Days, ShouldHaveLength, 2)
+			So(ts.Days[0].AccountingName, ShouldEqual, "groupA")
+			So(ts.Days[0].Jobs+ts.Days[1].Jobs, ShouldEqual, len(bomAHits))
+
+			_, err = sdb.TeamSummary(context.Background(), "other", bomA, gte, gte)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("You can get the Coverage() of each day and BOM", func() {
+			days, err := sdb.Coverage(context.Background())
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 1)
+			So(days[0].Day, ShouldEqual, "2024-02-04")
+			So(days[0].Partial, ShouldBeTrue)
+			So(days[0].BOMs[0].BOM, ShouldEqual, bomA)
+			So(days[0].BOMs[0].Hits, ShouldEqual, len(bomAHits))
+
+			So(sdb.finishDay(gte), ShouldBeNil)
+
+			days, err = sdb.Coverage(context.Background())
+			So(err, ShouldBeNil)
+			So(days[0].Partial, ShouldBeFalse)
+		})
+
+		Convey("You can get a GPUReport()", func() {
+			expected := 0
+
+			for _, hit := range bomAHits {
+				if isGPUQueue(hit.Details.QueueName) {
+					expected++
+				}
+			}
+
+			So(expected, ShouldBeGreaterThan, 0)
+
+			report, err := sdb.GPUReport(context.Background(), "", bomA, gte, gte)
+			So(err, ShouldBeNil)
+			So(report.Days, ShouldHaveLength, 1)
+			So(report.Days[0].Jobs, ShouldEqual, expected)
+			So(report.Total.Jobs, ShouldEqual, expected)
+
+			report, err = sdb.GPUReport(context.Background(), "", "", gte, gte)
+			So(err, ShouldBeNil)
+			So(report.Total.Jobs, ShouldBeGreaterThan, expected)
+		})
+
+		Convey("You can get DistinctCounts()", func() {
+			counts, err := sdb.DistinctCounts(query, "USER_NAME")
+			So(err, ShouldBeNil)
+			So(mapKeys(counts), ShouldHaveLength, 3)
+			So(counts["userA"]+counts["userB"]+counts["userNameLongest"], ShouldEqual, len(bomAHits))
+
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"prefix": map[string]interface{}{"JOB_NAME": "nf-"}})
+
+			scrolled, err := sdb.DistinctCounts(query, "ACCOUNTING_NAME")
+			So(err, ShouldBeNil)
+			So(scrolled["groupA"]+scrolled["groupB"], ShouldBeGreaterThan, 0)
+
+			_, err = sdb.DistinctCounts(query, "BOM")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("You can Aggregate() stats, but not rollups", func() {
+			query.Aggs = &es.Aggs{Stats: es.AggsStats{Stats: &es.Field{Field: "RUN_TIME_SEC"}}}
+
+			aggResult, answered, err := sdb.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(answered, ShouldBeTrue)
+			So(*aggResult.Aggregations.Stats.Count, ShouldEqual, len(bomAHits))
+			So(*aggResult.Aggregations.Stats.Avg, ShouldEqual, 5)
+
+			query.Aggs = &es.Aggs{Stats: es.AggsStats{Terms: &es.Field{Field: "Job"}}}
+
+			_, answered, err = sdb.Aggregate(query)
+			So(err, ShouldBeNil)
+			So(answered, ShouldBeFalse)
+		})
+
+		Convey("Queries fail when too large or cancelled", func() {
+			sdb.maxHits = len(bomAHits) - 1
+
+			_, err = sdb.Scroll(query)
+			So(err, ShouldNotBeNil)
+
+			var dbErr Error
+			So(err, ShouldHaveSameTypeAs, dbErr)
+			So(err.(Error).Msg, ShouldEqual, ErrQueryTooLarge) //nolint:errcheck,errorlint,forcetypeassert
+
+			sdb.maxHits = 0
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err = sdb.Scroll(query.WithContext(ctx))
+			So(err, ShouldEqual, context.Canceled)
+
+			_, err = sdb.Count(query.WithContext(ctx))
+			So(err, ShouldEqual, context.Canceled)
+		})
+	})
+
+	Convey("You can Backfill() to an SQLiteDB", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+		period := (2 * 24) * time.Hour
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: t.TempDir(), Backend: BackendSQLite}
+
+		_, err := Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+
+		query := rangeQuery(timeRange(from, period))
+
+		backend, err := Open(config, true)
+		So(err, ShouldBeNil)
+
+		count, err := backend.Count(query)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+		So(backend.DataThrough(), ShouldEqual, time.Date(2024, 05, 31, 0, 0, 0, 0, time.UTC))
+		So(backend.Reload(), ShouldBeNil)
+
+		version := backend.DataVersion()
+		So(version, ShouldNotBeEmpty)
+
+		Convey("Repeating Backfill() doesn't store days again", func() {
+			_, err = backend.(*SQLiteDB).db.Exec("DELETE FROM hits") //nolint:errcheck,forcetypeassert
+			So(err, ShouldBeNil)
+
+			_, err = Backfill(mock, config, from, period)
+			So(err, ShouldBeNil)
+
+			count, err = backend.Count(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+			So(backend.DataVersion(), ShouldEqual, version)
+
+			So(backend.Close(), ShouldBeNil)
+		})
+
+		Convey("Refill() stores days again, replacing their hits", func() {
+			_, err = backend.(*SQLiteDB).db.Exec("DELETE FROM hits") //nolint:errcheck,forcetypeassert
+			So(err, ShouldBeNil)
+
+			_, err = Refill(mock, config, from.Add(-oneDay), from.Add(-2*oneDay))
+			So(err, ShouldBeNil)
+
+			_, err = Refill(mock, config, from.Add(-oneDay))
+			So(err, ShouldBeNil)
+
+			count, err = backend.Count(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+			So(backend.DataVersion(), ShouldNotEqual, version)
+
+			So(backend.Close(), ShouldBeNil)
+		})
+	})
+
+	Convey("You can't Open() or Backfill() an unknown Backend", t, func() {
+		config := Config{Directory: t.TempDir(), Backend: "foo"}
+
+		_, err := Open(config, false)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, ErrUnknownBackend+": foo")
+
+		_, err = Backfill(es.NewMock("some-indexes-*"), config, time.Now(), oneDay)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,161 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// ScrollEach is like Scroll(), but instead of returning a Result holding all
+// the matching hits, it calls the given callback with each hit as it is read,
+// so that memory use doesn't grow with the number of hits. The hit and its
+// Details are only valid until the callback returns. If the callback returns
+// an error, we stop and return it.
+//
+// Since we never hold all the hits, the configured MaxHits and MaxBytes don't
+// apply. Hits are in day order, but otherwise in no particular order, unless
+// the query's Sort is on timestamp, in which case we must Scroll() all the
+// hits first in order to sort them.
+func (d *DB) ScrollEach(query *es.Query, cb func(*es.Hit) error) error {
+	order, err := query.TimestampSort()
+	if err != nil {
+		return err
+	}
+
+	if order != es.SortNone {
+		return d.scrollThenEach(query, cb)
+	}
+
+	filter, err := newFlatFilter(query)
+	if err != nil {
+		return err
+	}
+
+	if err = d.checkFilter(filter); err != nil {
+		return err
+	}
+
+	release, err := d.acquireScrollSlot(filter)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	matches := unindexedMatcher(query, filter.patterns)
+
+	d.forEachRequestedDayBOMDir(filter, func(dayBOMDir string) {
+		for _, fi := range d.flatIndexesInDir(dayBOMDir) {
+			if err != nil {
+				return
+			}
+
+			err = d.eachIndexHit(fi, filter, matches, cb)
+		}
+	})
+
+	query.SetScanned(filter.scanned.Load())
+
+	if err != nil {
+		return err
+	}
+
+	return filter.contextErr()
+}
+
+// scrollThenEach calls the given callback with each hit of our Scroll() of the
+// given query.
+func (d *DB) scrollThenEach(query *es.Query, cb func(*es.Hit) error) error {
+	result, err := d.Scroll(query)
+	if err != nil {
+		return err
+	}
+
+	defer d.Done(result.PoolKey)
+
+	for i := range result.HitSet.Hits {
+		if err = cb(&result.HitSet.Hits[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eachIndexHit reads and deserializes the hits of the entries in the given
+// index that pass the given filter and matches func (if not nil), calling the
+// given callback with each.
+func (d *DB) eachIndexHit(fi *flatIndex, filter *flatFilter, matches func(es.Hit) bool,
+	cb func(*es.Hit) error) error {
+	entries := fi.IndexSearch(filter)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	of, err := d.openFiles.acquire(fi.dataPath)
+	if err != nil {
+		return err
+	}
+
+	defer d.openFiles.release(of)
+
+	timer := filter.query.PhaseTimer()
+	defer timer.Stop()
+
+	for i, entry := range entries {
+		if i%contextCheckInterval == 0 {
+			if errc := filter.contextErr(); errc != nil {
+				return errc
+			}
+		}
+
+		data, err := entryData(of, nil, localDataEntry{fi: fi, entry: entry})
+		if err != nil {
+			return err
+		}
+
+		timer.Mark(es.PhaseDataRead)
+
+		details, err := fi.deserialize(data, filter.desiredFields)
+		if err != nil {
+			return err
+		}
+
+		timer.Mark(es.PhaseDeserialize)
+
+		hit := es.Hit{ID: details.ID, Details: details}
+
+		if matches != nil && !matches(hit) {
+			continue
+		}
+
+		if err = cb(&hit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
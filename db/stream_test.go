@@ -0,0 +1,138 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestScrollEach(t *testing.T) {
+	Convey("Given a database with hits over 2 days", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for i, jobName := range []string{"nf-align", "nf-call", "wr-align", "nf-ALIGN2"} {
+			hitCh <- &es.Hit{ID: strconv.Itoa(i), Details: &es.Details{
+				Timestamp: gte.Add(time.Duration(i) * 12 * time.Hour).Unix(),
+				BOM:       "bomA",
+				UserName:  "user" + strconv.Itoa(i),
+				JobName:   jobName,
+			}}
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := func(extra ...map[string]es.MapStringStringOrMap) *es.Query {
+			filter := es.Filter{
+				rangeFilter("lt", gte, gte.Add(2*oneDay)),
+				{"match_phrase": {"BOM": "bomA"}},
+			}
+
+			return &es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: append(filter, extra...)}}}
+		}
+
+		userNames := func(q *es.Query) []string {
+			var names []string
+
+			errs := db.ScrollEach(q, func(hit *es.Hit) error {
+				names = append(names, hit.Details.UserName)
+
+				return nil
+			})
+			So(errs, ShouldBeNil)
+
+			return names
+		}
+
+		Convey("ScrollEach calls back with each hit, in day order", func() {
+			names := userNames(query())
+			So(names, ShouldHaveLength, 4)
+			So(names[:2], ShouldContain, "user0")
+			So(names[:2], ShouldContain, "user1")
+
+			sort.Strings(names)
+			So(names, ShouldResemble, []string{"user0", "user1", "user2", "user3"})
+		})
+
+		Convey("ScrollEach applies unindexed filters", func() {
+			names := userNames(query(map[string]es.MapStringStringOrMap{"wildcard": {"JOB_NAME": "nf-*"}}))
+			sort.Strings(names)
+			So(names, ShouldResemble, []string{"user0", "user1", "user3"})
+		})
+
+		Convey("ScrollEach honours timestamp sorts", func() {
+			q := query()
+			q.Sort = []string{"timestamp:desc"}
+
+			So(userNames(q), ShouldResemble, []string{"user3", "user2", "user1", "user0"})
+		})
+
+		Convey("ScrollEach stops at the first callback error", func() {
+			errStop := errors.New("stop")
+			calls := 0
+
+			err = db.ScrollEach(query(), func(*es.Hit) error {
+				calls++
+
+				return errStop
+			})
+			So(err, ShouldEqual, errStop)
+			So(calls, ShouldEqual, 1)
+		})
+	})
+}
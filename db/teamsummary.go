@@ -0,0 +1,262 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	ErrTeamSummaryRange = "team summary needs a BOM and a last day no earlier than the first, at most a year later"
+
+	maxReportDays = 366
+)
+
+// TeamDay is the total usage and waste of one team's (ACCOUNTING_NAME's) jobs
+// on one day.
+type TeamDay struct {
+	Day                string  `json:"day"`
+	AccountingName     string  `json:"accounting_name"`
+	Jobs               int64   `json:"jobs"`
+	CPUAvailSeconds    float64 `json:"cpu_avail_sec"`
+	CPUWastedSeconds   float64 `json:"cpu_wasted_sec"`
+	MemAvailMBSeconds  float64 `json:"mem_avail_mb_sec"`
+	MemWastedMBSeconds float64 `json:"mem_wasted_mb_sec"`
+	WastedCost         float64 `json:"wasted_cost"`
+}
+
+// TeamSummary is the response to a TeamSummary() call: a TeamDay for every
+// team with jobs in a BOM on each day from From to To (inclusive, YYYY-MM-DD),
+// sorted by day and then team.
+type TeamSummary struct {
+	BOM  string     `json:"bom"`
+	From string     `json:"from"`
+	To   string     `json:"to"`
+	Days []*TeamDay `json:"days"`
+}
+
+// newTeamSummary returns an empty TeamSummary for the given BOM and days, or an
+// ErrTeamSummaryRange Error if they're not valid.
+func newTeamSummary(bom string, first, last time.Time) (*TeamSummary, error) {
+	if bom == "" || !validReportRange(first, last) {
+		return nil, Error{Msg: ErrTeamSummaryRange}
+	}
+
+	return &TeamSummary{
+		BOM:  bom,
+		From: first.Format(time.DateOnly),
+		To:   last.Format(time.DateOnly),
+		Days: []*TeamDay{},
+	}, nil
+}
+
+// addDay adds a TeamDay for each ACCOUNTING_NAME in the given rollup of the
+// given day.
+func (ts *TeamSummary) addDay(day time.Time, r rollup) {
+	teams := make(map[string]*rollupValues)
+
+	for key, vals := range r {
+		team, ok := teams[key.AccountingName]
+		if !ok {
+			team = newRollupValues()
+			teams[key.AccountingName] = team
+		}
+
+		team.merge(vals)
+	}
+
+	dayStr := day.Format(time.DateOnly)
+
+	for _, name := range sortedKeys(teams) {
+		vals := teams[name]
+
+		ts.Days = append(ts.Days, &TeamDay{
+			Day:                dayStr,
+			AccountingName:     name,
+			Jobs:               vals.DocCount,
+			CPUAvailSeconds:    vals.Sums["AVAIL_CPU_TIME_SEC"],
+			CPUWastedSeconds:   vals.Sums["WASTED_CPU_SECONDS"],
+			MemAvailMBSeconds:  vals.Sums["MEM_REQUESTED_MB_SEC"],
+			MemWastedMBSeconds: vals.Sums["WASTED_MB_SECONDS"],
+			WastedCost:         vals.WastedCost,
+		})
+	}
+}
+
+// validReportRange returns true if the given last day isn't before the first,
+// and is at most a year later.
+func validReportRange(first, last time.Time) bool {
+	return !last.Before(first) && last.Sub(first) < maxReportDays*oneDay
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := mapKeys(m)
+	sort.Strings(keys)
+
+	return keys
+}
+
+// teamSummary returns a TeamSummary of the given BOM and days, getting the
+// rollup of each day from the given function.
+func teamSummary(ctx context.Context, bom string, first, last time.Time,
+	rollupOfDay func(day time.Time) (rollup, error)) (*TeamSummary, error) {
+	first, last = truncateToDay(first), truncateToDay(last)
+
+	ts, err := newTeamSummary(bom, first, last)
+	if err != nil {
+		return nil, err
+	}
+
+	for day := first; !day.After(last); day = day.Add(oneDay) {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		r, err := rollupOfDay(day)
+		if err != nil {
+			return nil, err
+		}
+
+		ts.addDay(day, r)
+	}
+
+	return ts, nil
+}
+
+// truncateToDay returns the UTC midnight at the start of the given time's day.
+func truncateToDay(t time.Time) time.Time {
+	return t.UTC().Truncate(oneDay)
+}
+
+// TeamSummary returns per-ACCOUNTING_NAME daily totals of available and wasted
+// CPU and memory, and the farmer's report's wasted cost, of the given cluster's
+// (or the default cluster's, if "") BOM's jobs, for each day from first to last
+// (inclusive).
+//
+// Each day is answered from its rollup file where possible, otherwise (eg. for
+// days backfilled before rollups existed, or only with hour segments so far) by
+// reading that day's hits.
+func (d *DB) TeamSummary(ctx context.Context, cluster, bom string, first, last time.Time) (*TeamSummary, error) {
+	if err := d.bomSelection.check(bom); err != nil {
+		return nil, err
+	}
+
+	cluster, err := d.resolveReportCluster(cluster, first)
+	if err != nil {
+		return nil, err
+	}
+
+	return teamSummary(ctx, bom, first, last, func(day time.Time) (rollup, error) {
+		r, ok, err := d.rollupOfDay(day, clusterBOMDir(cluster, sanitiseBOMForFileSystem(bom)))
+		if err != nil || ok {
+			return r, err
+		}
+
+		return scrolledRollup(d, bomDayQuery(ctx, cluster, bom, day))
+	})
+}
+
+// resolveReportCluster checks that we've loaded the given first day of a
+// report, and returns the given cluster resolved like clusterSet.resolve(),
+// or an ErrClusterNotStored Error if we don't partition by cluster and it isn't
+// the DefaultCluster.
+func (d *DB) resolveReportCluster(cluster string, first time.Time) (string, error) {
+	if err := d.checkLoaded(truncateToDay(first)); err != nil {
+		return "", err
+	}
+
+	resolved, err := d.clusters.resolve(cluster)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved == "" {
+		err = checkDefaultCluster(cluster)
+	}
+
+	return resolved, err
+}
+
+// checkDefaultCluster returns an ErrClusterNotStored Error if the given cluster
+// isn't blank or the DefaultCluster, for when we don't partition by cluster.
+func checkDefaultCluster(cluster string) error {
+	if cluster != "" && cluster != DefaultCluster {
+		return Error{Msg: ErrClusterNotStored, cause: cluster}
+	}
+
+	return nil
+}
+
+// bomDayQuery returns a query for the hits of the given cluster's (if not "")
+// BOM (or any BOM, if "") on the given day.
+func bomDayQuery(ctx context.Context, cluster, bom string, day time.Time) *es.Query {
+	filter := es.Filter{
+		{"range": map[string]interface{}{
+			"timestamp": map[string]interface{}{
+				"gte":    timestamp(day),
+				"lt":     timestamp(day.Add(oneDay)),
+				"format": "strict_date_optional_time",
+			},
+		}},
+	}
+
+	for field, val := range map[string]string{"BOM": bom, clusterField: cluster} {
+		if val != "" {
+			filter = append(filter, map[string]es.MapStringStringOrMap{
+				"match_phrase": map[string]interface{}{field: val},
+			})
+		}
+	}
+
+	return (&es.Query{Query: &es.QueryFilter{Bool: es.QFBool{Filter: filter}}}).WithContext(ctx)
+}
+
+// scrolledRollup returns a rollup of the hits of the given query.
+func scrolledRollup(b Backend, query *es.Query) (rollup, error) {
+	r := make(rollup)
+
+	return r, addScrolledHits(b, query, r.add)
+}
+
+// addScrolledHits calls the given function with the details of each hit of the
+// given query.
+func addScrolledHits(b Backend, query *es.Query, add func(*es.Details)) error {
+	result, err := b.Scroll(query)
+	if err != nil {
+		return err
+	}
+
+	defer b.Done(result.PoolKey)
+
+	for _, hit := range result.HitSet.Hits {
+		add(hit.Details)
+	}
+
+	return nil
+}
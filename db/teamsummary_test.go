@@ -0,0 +1,176 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestTeamSummary(t *testing.T) {
+	Convey("Given a database with stored hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		first, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		last := first.Add(oneDay)
+		result := makeResult(first, last.Add(10*time.Second))
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for _, hit := range result.HitSet.Hits {
+			hitCh <- &hit
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		ctx := context.Background()
+
+		expected := make(map[string]*TeamDay)
+
+		for _, hit := range result.HitSet.Hits {
+			if hit.Details.BOM != "bomB" {
+				continue
+			}
+
+			key := time.Unix(hit.Details.Timestamp, 0).UTC().Format(time.DateOnly) + " " + hit.Details.AccountingName
+
+			td, ok := expected[key]
+			if !ok {
+				td = &TeamDay{}
+				expected[key] = td
+			}
+
+			td.Jobs++
+			td.CPUWastedSeconds += hit.Details.WastedCPUSeconds
+			td.MemAvailMBSeconds += float64(hit.Details.MemRequestedMBSec)
+		}
+
+		checkSummary := func(ts *TeamSummary) {
+			So(ts.BOM, ShouldEqual, "bomB")
+			So(ts.From, ShouldEqual, "2024-02-04")
+			So(ts.To, ShouldEqual, "2024-02-05")
+			So(ts.Days, ShouldHaveLength, 4)
+
+			So(ts.Days[0].Day, ShouldEqual, "2024-02-04")
+			So(ts.Days[0].AccountingName, ShouldEqual, "groupA")
+			So(ts.Days[1].AccountingName, ShouldEqual, "groupB")
+			So(ts.Days[3].Day, ShouldEqual, "2024-02-05")
+
+			for _, td := range ts.Days {
+				exp := expected[td.Day+" "+td.AccountingName]
+				So(exp, ShouldNotBeNil)
+				So(td.Jobs, ShouldEqual, exp.Jobs)
+				So(td.CPUWastedSeconds, ShouldAlmostEqual, exp.CPUWastedSeconds, 0.001)
+				So(td.MemAvailMBSeconds, ShouldEqual, exp.MemAvailMBSeconds)
+				So(td.WastedCost, ShouldAlmostEqual,
+					float64(exp.Jobs)*math.Max(6.1*wastedCostCPUSecond, 7.1*wastedCostMBSecond), 0.000001)
+			}
+		}
+
+		Convey("You can get a TeamSummary from the rollups", func() {
+			ts, errt := db.TeamSummary(ctx, "", "bomB", first, last)
+			So(errt, ShouldBeNil)
+			checkSummary(ts)
+
+			bomDir := filepath.Join(config.Directory, "2024", "02", "04", "bomB")
+			So(rollup{{AccountingName: "fromFile"}: &rollupValues{DocCount: 1}}.write(bomDir), ShouldBeNil)
+
+			ts, errt = db.TeamSummary(ctx, "", "bomB", first, first)
+			So(errt, ShouldBeNil)
+			So(ts.Days, ShouldHaveLength, 1)
+			So(ts.Days[0].AccountingName, ShouldEqual, "fromFile")
+			So(ts.Days[0].Jobs, ShouldEqual, 1)
+		})
+
+		Convey("Days without rollups are summarised from their hits", func() {
+			for _, day := range []string{"04", "05"} {
+				So(os.Remove(filepath.Join(config.Directory, "2024", "02", day, "bomB", rollupBasename)), ShouldBeNil)
+			}
+
+			ts, errt := db.TeamSummary(ctx, "", "bomB", first, last)
+			So(errt, ShouldBeNil)
+			checkSummary(ts)
+		})
+
+		Convey("Days with no hits have no TeamDays", func() {
+			ts, errt := db.TeamSummary(ctx, "", "bomB", first.Add(-oneDay), first.Add(-oneDay))
+			So(errt, ShouldBeNil)
+			So(ts.Days, ShouldBeEmpty)
+		})
+
+		Convey("Invalid BOMs and date ranges are errors", func() {
+			_, errt := db.TeamSummary(ctx, "", "", first, last)
+			So(errt, ShouldNotBeNil)
+			So(errt.Error(), ShouldEqual, ErrTeamSummaryRange)
+
+			_, errt = db.TeamSummary(ctx, "", "bomB", last, first)
+			So(errt, ShouldNotBeNil)
+
+			_, errt = db.TeamSummary(ctx, "", "bomB", first, first.Add(maxReportDays*oneDay))
+			So(errt, ShouldNotBeNil)
+
+			_, errt = db.TeamSummary(ctx, "other", "bomB", first, last)
+			So(errt, ShouldNotBeNil)
+		})
+
+		Convey("A cancelled context is an error", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			_, errt := db.TeamSummary(cancelled, "", "bomB", first, last)
+			So(errt, ShouldEqual, context.Canceled)
+		})
+	})
+}
@@ -0,0 +1,411 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ErrTierObjectStore = "days can't be tiered in a database synced with an object store"
+	ErrTierDestination = "tier destination is not one of the configured database directories"
+
+	tieringBasename  = ".tiering"
+	tieredBasename   = ".tiered"
+	compressedSuffix = ".zst"
+)
+
+// TierResult describes what Tier() did.
+type TierResult struct {
+	// Moved are the "YYYY/MM/DD" days that were moved.
+	Moved []string
+	// Skipped are days that weren't moved because the destination directory
+	// already had them.
+	Skipped []string
+	// Tiered are the "YYYY/MM/DD" days, moved now or by earlier Tier()s,
+	// whose old copies are waiting to be deleted by RemoveTiered().
+	Tiered []string
+}
+
+// Tier moves every successfully backfilled day before the given time from the
+// configured flat database Directory and ExtraDirectories to the given one of
+// them, eg. to move old days from fast local disk to a slower archive
+// directory. Days the destination already has are left alone and reported as
+// skipped.
+//
+// If compress is true, the data files of the moved days are zstd compressed.
+// They are then read in to memory in full when queried, so this is best
+// suited to days that are rarely queried.
+//
+// Each day is copied to a temporary directory in the destination and only
+// moved in to place once complete, after which its source directory is marked
+// as tiered. DBs ignore tiered day directories, so a running server queries
+// the days from their new location after its next DB.Reload(), but until then
+// keeps querying the old copies, which are left in place. Call RemoveTiered()
+// once running servers have reloaded, to delete them.
+func Tier(config Config, before time.Time, to string, compress bool) (*TierResult, error) {
+	sources, err := tierSources(config, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TierResult{}
+	tmpDir := filepath.Join(to, tieringBasename)
+
+	defer os.RemoveAll(tmpDir)
+
+	for _, src := range sources {
+		if err = result.tierDir(src, to, tmpDir, before, compress); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// tierSources returns the configured database directories other than the given
+// destination one, or an error if we can't tier days in to it.
+func tierSources(config Config, to string) ([]string, error) {
+	if config.Backend != "" && config.Backend != BackendFlat {
+		return nil, Error{Msg: ErrExtraDirsBackend, cause: config.Backend}
+	}
+
+	if err := config.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if config.ObjectStore != nil {
+		return nil, Error{Msg: ErrTierObjectStore, cause: config.Directory}
+	}
+
+	to = filepath.Clean(to)
+	found := false
+
+	var sources []string
+
+	for _, dir := range append([]string{config.Directory}, config.ExtraDirectories...) {
+		if filepath.Clean(dir) == to {
+			found = true
+
+			continue
+		}
+
+		sources = append(sources, dir)
+	}
+
+	if !found {
+		return nil, Error{Msg: ErrTierDestination, cause: to}
+	}
+
+	return sources, nil
+}
+
+// tierDir moves the days before the given time in the src database directory
+// to the to one, via the given tmpDir, marking their src directories as tiered.
+func (t *TierResult) tierDir(src, to, tmpDir string, before time.Time, compress bool) error {
+	return forEachBackfilledDay(src, func(srcDayDir string) error {
+		rel, err := filepath.Rel(src, srcDayDir)
+		if err != nil {
+			return err
+		}
+
+		day := filepath.ToSlash(rel)
+
+		if isTieredDay(srcDayDir) {
+			t.Tiered = append(t.Tiered, day)
+
+			return nil
+		}
+
+		if !dayBefore(day, before) {
+			return nil
+		}
+
+		dstDayDir := filepath.Join(to, rel)
+
+		if fileExists(dstDayDir) {
+			t.Skipped = append(t.Skipped, day)
+
+			return nil
+		}
+
+		err = mergeDay(srcDayDir, filepath.Join(tmpDir, rel), dstDayDir, tierCopier(compress), false)
+		if err != nil {
+			return err
+		}
+
+		if err = os.WriteFile(filepath.Join(srcDayDir, tieredBasename), nil, dbFilePerms); err != nil {
+			return err
+		}
+
+		t.Moved = append(t.Moved, day)
+		t.Tiered = append(t.Tiered, day)
+
+		return nil
+	})
+}
+
+// isTieredDay returns true if Tier() has moved the given day directory
+// elsewhere, and it is only waiting to be deleted by RemoveTiered().
+func isTieredDay(dayDir string) bool {
+	return fileExists(filepath.Join(dayDir, tieredBasename))
+}
+
+// RemoveTiered deletes the day directories that Tier() moved from the
+// configured flat database Directory and ExtraDirectories. Call this once any
+// running server using those directories has done a DB.Reload(), so that it no
+// longer queries them. Days that somehow aren't in one of the other configured
+// directories are left alone. Returns the "YYYY/MM/DD" days deleted.
+func RemoveTiered(config Config) ([]string, error) {
+	if err := config.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	dirs := append([]string{config.Directory}, config.ExtraDirectories...)
+
+	var removed []string
+
+	for _, dir := range dirs {
+		err := forEachBackfilledDay(dir, func(dayDir string) error {
+			rel, err := filepath.Rel(dir, dayDir)
+			if err != nil || !isTieredDay(dayDir) || !dayInOtherDir(dirs, dir, rel) {
+				return err
+			}
+
+			if err = os.RemoveAll(dayDir); err != nil {
+				return err
+			}
+
+			removed = append(removed, filepath.ToSlash(rel))
+
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// dayInOtherDir returns true if the given relative day directory was
+// successfully backfilled in one of the given database directories other than
+// the given one, and isn't tiered there.
+func dayInOtherDir(dirs []string, dir, rel string) bool {
+	for _, other := range dirs {
+		dayDir := filepath.Join(other, rel)
+
+		if other != dir && fileExists(filepath.Join(dayDir, successBasename)) && !isTieredDay(dayDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dayBefore returns true if the given "YYYY/MM/DD" day is before the given
+// time.
+func dayBefore(day string, before time.Time) bool {
+	t, err := time.Parse(dateFormat, day)
+
+	return err == nil && t.Before(before)
+}
+
+// tierCopier returns copyFile, or if compress is true, a fileCopier that zstd
+// compresses data files and copies other files as is.
+func tierCopier(compress bool) fileCopier {
+	if !compress {
+		return copyFile
+	}
+
+	return func(from, to string) error {
+		if strings.HasSuffix(from, "."+dataKind) {
+			return compressFile(from, compressedPath(to))
+		}
+
+		return copyFile(from, to)
+	}
+}
+
+// compressedPath returns the path of the zstd compressed copy of the given
+// data file that Tier() makes in place of it.
+func compressedPath(dataPath string) string {
+	return dataPath + compressedSuffix
+}
+
+// compressFile writes a zstd compressed copy of the from file to the to path,
+// creating its parent directories. The size of the from file is recorded in
+// the zstd frame header, for dataFileSize().
+func compressFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), dbDirPerms); err != nil {
+		return err
+	}
+
+	r, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	info, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, dbFilePerms)
+	if err != nil {
+		return err
+	}
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		w.Close()
+
+		return err
+	}
+
+	zw.ResetContentSize(w, info.Size())
+
+	if _, err = io.Copy(zw, r); err != nil {
+		zw.Close()
+		w.Close()
+
+		return err
+	}
+
+	if err = zw.Close(); err != nil {
+		w.Close()
+
+		return err
+	}
+
+	return w.Close()
+}
+
+// readCompressedFile returns the decompressed contents of the given zstd
+// compressed file. The returned slice is never nil if there's no error.
+func readCompressedFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		data = []byte{}
+	}
+
+	return data, nil
+}
+
+// openDataFile opens the given data file for reading entries from, or if it
+// only exists compressed, reads in its decompressed contents. You must close()
+// the returned openFile.
+func openDataFile(dataPath string, verify bool) (*openFile, error) {
+	fh, err := os.Open(dataPath)
+	if err == nil {
+		return &openFile{File: fh, path: dataPath, verify: verify}, nil
+	}
+
+	data, errc := readCompressedFile(compressedPath(dataPath))
+	if errc != nil {
+		return nil, err
+	}
+
+	return &openFile{path: dataPath, verify: verify, mapped: data, decompressed: true}, nil
+}
+
+// dataFileSize returns the size of the given data file, or the decompressed
+// size of its compressed copy if only that exists.
+func dataFileSize(dataPath string) (int64, error) {
+	info, err := os.Stat(dataPath)
+	if err == nil {
+		return info.Size(), nil
+	}
+
+	size, errc := compressedFileSize(compressedPath(dataPath))
+	if errc != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// compressedFileSize returns the decompressed size of the given zstd compressed
+// file, as recorded in its frame header by compressFile(). Files without it
+// (eg. empty ones) are decompressed to find out.
+func compressedFileSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	b := make([]byte, zstd.HeaderMaxSize)
+
+	n, err := io.ReadFull(f, b)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+
+	var header zstd.Header
+
+	if err = header.Decode(b[:n]); err == nil && header.HasFCS {
+		return int64(header.FrameContentSize), nil
+	}
+
+	data, err := readCompressedFile(path)
+
+	return int64(len(data)), err
+}
+
+// dataFileExists returns true if the given data file, or its compressed copy,
+// exists.
+func dataFileExists(dataPath string) bool {
+	return fileExists(dataPath) || fileExists(compressedPath(dataPath))
+}
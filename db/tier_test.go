@@ -0,0 +1,186 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestTier(t *testing.T) {
+	from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+	period := 3 * oneDay
+	day30 := time.Date(2024, 05, 30, 0, 0, 0, 0, time.UTC)
+	day31 := time.Date(2024, 05, 31, 0, 0, 0, 0, time.UTC)
+
+	Convey("Given a backfilled database and an empty archive directory", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		fast, archive := t.TempDir(), t.TempDir()
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: fast, ExtraDirectories: []string{archive}, UpdateFrequency: time.Hour}
+
+		_, err := Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+
+		query := rangeQuery(timeRange(from, period))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+		db, err := New(config, true)
+		So(err, ShouldBeNil)
+
+		defer db.Close()
+
+		expected, err := db.Scroll(query)
+		So(err, ShouldBeNil)
+		So(expected.HitSet.Hits, ShouldNotBeEmpty)
+
+		checkHits := func(db *DB) {
+			result, errs := db.Scroll(query)
+			So(errs, ShouldBeNil)
+			So(result.HitSet.Hits, ShouldResemble, expected.HitSet.Hits)
+		}
+
+		rel30 := filepath.Join("2024", "05", "30")
+
+		Convey("Tier() moves days before the cutoff, compressing their data, and a running DB finds them on Reload()", func() {
+			srcDataPath := filepath.Join(fast, rel30, "Human Genetics", "0."+dataKind)
+			srcInfo, err := os.Stat(srcDataPath)
+			So(err, ShouldBeNil)
+
+			result, err := Tier(config, day31, archive, true)
+			So(err, ShouldBeNil)
+			So(result.Moved, ShouldContain, "2024/05/30")
+			So(result.Moved, ShouldNotContain, "2024/05/31")
+			So(result.Tiered, ShouldResemble, result.Moved)
+			So(result.Skipped, ShouldBeEmpty)
+
+			So(isTieredDay(filepath.Join(fast, rel30)), ShouldBeTrue)
+			So(isTieredDay(filepath.Join(fast, "2024", "05", "31")), ShouldBeFalse)
+			So(fileExists(filepath.Join(archive, tieringBasename)), ShouldBeFalse)
+
+			dataPath := filepath.Join(archive, rel30, "Human Genetics", "0."+dataKind)
+			So(fileExists(dataPath), ShouldBeFalse)
+			So(fileExists(compressedPath(dataPath)), ShouldBeTrue)
+
+			size, err := dataFileSize(dataPath)
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, srcInfo.Size())
+
+			checkHits(db)
+
+			So(db.Reload(), ShouldBeNil)
+			So(db.realDayDir(db.dateFolder(day30)), ShouldEqual, filepath.Join(archive, rel30))
+
+			checkHits(db)
+
+			again, err := Tier(config, day31, archive, true)
+			So(err, ShouldBeNil)
+			So(again.Moved, ShouldBeEmpty)
+			So(again.Skipped, ShouldBeEmpty)
+			So(again.Tiered, ShouldResemble, result.Moved)
+
+			removed, err := RemoveTiered(config)
+			So(err, ShouldBeNil)
+			So(removed, ShouldResemble, result.Moved)
+			So(fileExists(filepath.Join(fast, rel30)), ShouldBeFalse)
+			So(fileExists(filepath.Join(fast, "2024", "05", "31")), ShouldBeTrue)
+
+			checkHits(db)
+
+			problems, errf := Fsck(Config{Directory: archive})
+			So(errf, ShouldBeNil)
+			So(problems, ShouldBeEmpty)
+
+			Convey("as does a New() DB, mmapped or not", func() {
+				for _, mmap := range []bool{false, true} {
+					config.MMap = mmap
+
+					db2, errn := New(config, true)
+					So(errn, ShouldBeNil)
+
+					checkHits(db2)
+					So(db2.Close(), ShouldBeNil)
+				}
+			})
+
+			Convey("and moving them back reloads them from there", func() {
+				back := config
+				back.Directory, back.ExtraDirectories = archive, []string{fast}
+
+				result, err = Tier(back, day31, fast, false)
+				So(err, ShouldBeNil)
+				So(result.Moved, ShouldContain, "2024/05/30")
+
+				So(db.Reload(), ShouldBeNil)
+				So(db.realDayDir(db.dateFolder(day30)), ShouldEqual, db.dateFolder(day30))
+
+				checkHits(db)
+			})
+		})
+
+		Convey("A New() DB ignores the old copies of tiered days", func() {
+			_, err := Tier(config, day31, archive, false)
+			So(err, ShouldBeNil)
+			So(fileExists(filepath.Join(fast, rel30)), ShouldBeTrue)
+
+			db2, err := New(config, true)
+			So(err, ShouldBeNil)
+
+			So(db2.realDayDir(db2.dateFolder(day30)), ShouldEqual, filepath.Join(archive, rel30))
+			checkHits(db2)
+			So(db2.Close(), ShouldBeNil)
+		})
+
+		Convey("Tier() skips days the destination already has", func() {
+			So(copyDir(filepath.Join(fast, rel30), filepath.Join(archive, rel30), copyFile), ShouldBeNil)
+
+			result, err := Tier(config, day31, archive, false)
+			So(err, ShouldBeNil)
+			So(result.Skipped, ShouldResemble, []string{"2024/05/30"})
+			So(result.Moved, ShouldNotContain, "2024/05/30")
+			So(fileExists(filepath.Join(fast, rel30)), ShouldBeTrue)
+		})
+
+		Convey("Tier() fails for destinations that aren't configured, and read-only databases", func() {
+			_, err := Tier(config, day31, t.TempDir(), false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrTierDestination)
+
+			config.ReadOnly = true
+
+			_, err = Tier(config, day31, archive, false)
+			So(err, ShouldResemble, Error{Msg: ErrReadOnly, cause: fast})
+		})
+	})
+}
@@ -0,0 +1,257 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const ErrExtraDirsBackend = "extra database directories are only supported for the flat backend"
+
+// rootOf returns whichever of our directories the given path is within,
+// defaulting to our own.
+func (d *DB) rootOf(path string) string {
+	for _, dir := range d.extraDirs {
+		if isWithin(dir, path) {
+			return dir
+		}
+	}
+
+	return d.dir
+}
+
+// isWithin returns true if path is dir or is somewhere inside it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// canonicalPath returns the given path within one of our extraDirs as the same
+// path within our own directory, which is how all our loaded indexes are
+// keyed, regardless of which directory they were loaded from. Paths already
+// within our own directory are returned as is.
+func (d *DB) canonicalPath(path string) string {
+	root := d.rootOf(path)
+	if root == d.dir {
+		return path
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(d.dir, rel)
+}
+
+// extraPaths returns the given path within our own directory as the same path
+// within each of our extraDirs.
+func (d *DB) extraPaths(path string) []string {
+	if len(d.extraDirs) == 0 {
+		return nil
+	}
+
+	rel, err := filepath.Rel(d.dir, path)
+	if err != nil {
+		return nil
+	}
+
+	paths := make([]string, len(d.extraDirs))
+
+	for i, dir := range d.extraDirs {
+		paths[i] = filepath.Join(dir, rel)
+	}
+
+	return paths
+}
+
+// noteDayDir records in the given map (keyed like our dayDirs) that the day of
+// the given loaded BOM directory, if it's in one of our extraDirs, is keyed by
+// the given canonical BOM directory, so that realDayDir() can find the day's
+// files.
+func noteDayDir(dayDirs map[string]string, bomDir, canonicalBOMDir string) {
+	if bomDir == canonicalBOMDir {
+		return
+	}
+
+	dayDirs[filepath.Dir(canonicalBOMDir)] = filepath.Dir(bomDir)
+}
+
+// realDayDir returns the directory that the day with the given canonical
+// directory was loaded from, which may be in one of our extraDirs.
+func (d *DB) realDayDir(dayDir string) string {
+	if len(d.extraDirs) == 0 {
+		return dayDir
+	}
+
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	if real, ok := d.dayDirs[dayDir]; ok {
+		return real
+	}
+
+	return dayDir
+}
+
+// existingDateFolder returns the directory of the given day in the first of our
+// own directory and our extraDirs that has it, or false if none do.
+func (d *DB) existingDateFolder(day time.Time) (string, bool) {
+	return d.existingDayDir(d.dateFolder(day))
+}
+
+// existingDayDir returns the first of the given day directory within our own
+// directory and the same day directory within each of our extraDirs that
+// exists and wasn't moved elsewhere by Tier(), or false if none do.
+func (d *DB) existingDayDir(dayDir string) (string, bool) {
+	for _, dir := range append([]string{dayDir}, d.extraPaths(dayDir)...) {
+		if fileExists(dir) && !isTieredDay(dir) {
+			return dir, true
+		}
+	}
+
+	return "", false
+}
+
+// backfilledInExtraDir returns true if the day with the given directory within
+// our own directory was successfully backfilled in one of our extraDirs.
+func (d *DB) backfilledInExtraDir(dayDir string) bool {
+	for _, dir := range d.extraPaths(dayDir) {
+		if fileExists(filepath.Join(dir, successBasename)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dayLoaded returns true if we have loaded (or lazily noted) the indexes of
+// any BOM directory of the day with the given canonical directory.
+func (d *DB) dayLoaded(dayDir string) bool {
+	return len(d.dateBOMDirsWithPrefix(dayDir+string(filepath.Separator))) > 0
+}
+
+// isLoadedExtraDay returns true if the given path is a day directory within
+// the given one of our extraDirs, and we've already loaded that day, eg. from
+// our own directory.
+func (d *DB) isLoadedExtraDay(root, path string) bool {
+	if root == d.dir {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.Count(rel, string(filepath.Separator)) != watchDepthDay-1 {
+		return false
+	}
+
+	return d.dayLoaded(filepath.Join(d.dir, rel))
+}
+
+// loadExtraDirs loads the indexes of each of our extraDirs in turn, skipping
+// days we've already loaded from our own directory or an earlier extraDir.
+func (d *DB) loadExtraDirs() error {
+	for _, dir := range d.extraDirs {
+		if err := d.loadAllFlatIndexes(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reloadMovedDays calls relocateMovedDays(), updating our index cache if any
+// day changed.
+func (d *DB) reloadMovedDays() {
+	if !d.relocateMovedDays() {
+		return
+	}
+
+	d.dataVersion.Add(1)
+
+	if err := d.writeIndexCache(); err != nil {
+		slog.Error("writeIndexCache failed", "err", err)
+	}
+}
+
+// relocateMovedDays reloads each loaded day whose directory no longer exists,
+// or that now exists in a directory we prefer, from wherever it now is (eg.
+// after Tier() moved it), or forgets it if it's gone entirely. Returns true if
+// any day changed.
+func (d *DB) relocateMovedDays() bool {
+	d.muLoadDay.Lock()
+	defer d.muLoadDay.Unlock()
+
+	changed := false
+
+	for _, dayDir := range d.loadedDayDirs() {
+		current := d.realDayDir(dayDir)
+
+		want, ok := d.existingDayDir(dayDir)
+		if want == current && fileExists(current) {
+			continue
+		}
+
+		if ok {
+			d.reloadDay(want)
+		} else {
+			d.muDateBOMDirs.Lock()
+			d.forgetDay(dayDir)
+			d.muDateBOMDirs.Unlock()
+		}
+
+		changed = true
+	}
+
+	return changed
+}
+
+// loadedDayDirs returns the canonical directories of the days we have loaded
+// (or lazily noted) the indexes of.
+func (d *DB) loadedDayDirs() []string {
+	d.muDateBOMDirs.RLock()
+	defer d.muDateBOMDirs.RUnlock()
+
+	seen := make(map[string]bool)
+
+	for bomDir := range d.dateBOMDirs {
+		seen[filepath.Dir(bomDir)] = true
+	}
+
+	for bomDir := range d.lazyPaths {
+		seen[filepath.Dir(bomDir)] = true
+	}
+
+	dayDirs := make([]string, 0, len(seen))
+
+	for dayDir := range seen {
+		dayDirs = append(dayDirs, dayDir)
+	}
+
+	return dayDirs
+}
@@ -0,0 +1,169 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestExtraDirectories(t *testing.T) {
+	from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+	period := 3 * oneDay
+	day30 := time.Date(2024, 05, 30, 0, 0, 0, 0, time.UTC)
+	day31 := time.Date(2024, 05, 31, 0, 0, 0, 0, time.UTC)
+
+	Convey("Given a database with one day moved to an archive, and another copied there", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		fast, archive := t.TempDir(), t.TempDir()
+		mock := es.NewMock("some-indexes-*")
+		config := Config{Directory: fast, UpdateFrequency: time.Hour}
+
+		_, err := Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+
+		query := rangeQuery(timeRange(from, period))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}})
+
+		db, err := New(config, true)
+		So(err, ShouldBeNil)
+
+		expectedUsernames, err := db.Usernames(query)
+		So(err, ShouldBeNil)
+		slices.Sort(expectedUsernames)
+		So(db.Close(), ShouldBeNil)
+		So(os.Remove(filepath.Join(fast, indexCacheBasename)), ShouldBeNil)
+
+		rel30 := filepath.Join("2024", "05", "30")
+		rel31 := filepath.Join("2024", "05", "31")
+
+		So(os.MkdirAll(filepath.Join(archive, "2024", "05"), dbDirPerms), ShouldBeNil)
+		So(os.Rename(filepath.Join(fast, rel30), filepath.Join(archive, rel30)), ShouldBeNil)
+		So(copyDir(filepath.Join(fast, rel31), filepath.Join(archive, rel31), copyFile), ShouldBeNil)
+
+		config.ExtraDirectories = []string{archive}
+
+		checkUnion := func(db *DB) {
+			count, errc := db.Count(query)
+			So(errc, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			usernames, errc := db.Usernames(query)
+			So(errc, ShouldBeNil)
+			slices.Sort(usernames)
+			So(usernames, ShouldResemble, expectedUsernames)
+
+			So(db.DataThrough(), ShouldEqual, day31)
+			So(db.realDayDir(db.dateFolder(day30)), ShouldEqual, filepath.Join(archive, rel30))
+			So(db.realDayDir(db.dateFolder(day31)), ShouldEqual, db.dateFolder(day31))
+
+			bomDir, ok := db.completeDayBOMDir(day30, "Human Genetics")
+			So(ok, ShouldBeTrue)
+			So(bomDir, ShouldEqual, filepath.Join(archive, rel30, "Human Genetics"))
+		}
+
+		Convey("New() queries the union of both, preferring the first copy", func() {
+			db, err := New(config, true)
+			So(err, ShouldBeNil)
+
+			checkUnion(db)
+
+			Convey("as does a New() from its index cache", func() {
+				So(db.Close(), ShouldBeNil)
+
+				_, err = os.Stat(filepath.Join(fast, indexCacheBasename))
+				So(err, ShouldBeNil)
+
+				db, err = New(config, true)
+				So(err, ShouldBeNil)
+
+				defer db.Close()
+
+				checkUnion(db)
+			})
+
+			Convey("and uses a day refilled in to its own directory once it's done", func() {
+				defer db.Close()
+
+				_, err = Refill(mock, config, day30)
+				So(err, ShouldBeNil)
+
+				deadline := time.Now().Add(5 * time.Second)
+
+				for db.realDayDir(db.dateFolder(day30)) != db.dateFolder(day30) && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				So(db.realDayDir(db.dateFolder(day30)), ShouldEqual, db.dateFolder(day30))
+
+				count, errc := db.Count(query)
+				So(errc, ShouldBeNil)
+				So(count, ShouldEqual, 2)
+			})
+		})
+
+		Convey("New() with LazyLoadDirs queries the union of both", func() {
+			config.LazyLoadDirs = 10
+
+			db, err := New(config, true)
+			So(err, ShouldBeNil)
+
+			defer db.Close()
+
+			checkUnion(db)
+		})
+
+		Convey("Backfill() skips days backfilled in an extra directory", func() {
+			_, err = Backfill(mock, config, from, period)
+			So(err, ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(fast, rel30))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("New() fails if an extra directory is missing", func() {
+			config.ExtraDirectories = append(config.ExtraDirectories, filepath.Join(archive, "missing"))
+
+			_, err = New(config, true)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Only the flat backend supports extra directories", func() {
+			config.Backend = BackendSQLite
+
+			_, err = NewSQLite(config)
+			So(err, ShouldResemble, Error{Msg: ErrExtraDirsBackend, cause: BackendSQLite})
+		})
+	})
+}
@@ -0,0 +1,170 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	usernamesBasename = "usernames.json"
+	distinctUserName  = "USER_NAME"
+)
+
+// usernameSet holds the unique USER_NAMEs amongst a set of hits.
+type usernameSet map[string]bool
+
+// merge adds the other set's usernames to ours.
+func (u usernameSet) merge(other usernameSet) {
+	for name := range other {
+		u[name] = true
+	}
+}
+
+// write writes our usernames, sorted, to a usernames file in the given
+// directory.
+func (u usernameSet) write(dir string) error {
+	names := mapKeys(u)
+	sort.Strings(names)
+
+	data, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, usernamesBasename), data, rollupFilePerms)
+}
+
+// readUsernames reads the usernames file in the given directory.
+func readUsernames(dir string) (usernameSet, error) {
+	data, err := os.ReadFile(filepath.Join(dir, usernamesBasename))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	if err = json.Unmarshal(data, &names); err != nil {
+		return nil, err
+	}
+
+	u := make(usernameSet, len(names))
+
+	for _, name := range names {
+		u[name] = true
+	}
+
+	return u, nil
+}
+
+// usernamesFromFiles answers a DistinctValues() query for USER_NAME from the
+// usernames files written during backfill, without scanning any index files.
+// The query must be over whole days of a single BOM, with no other filters.
+// Returns false if the query can't be answered this way, eg. because a day
+// was backfilled before we wrote usernames files.
+func (d *DB) usernamesFromFiles(query *es.Query, filter *flatFilter) ([]string, bool, error) {
+	rq := &rollupQuery{}
+
+	if filter.BOM == "" || !onlyBOMFilters(query) || !rq.setDateRange(query) {
+		return nil, false, nil
+	}
+
+	bomDirName := clusterBOMDir(filter.cluster, filter.BOM)
+	names := make(usernameSet)
+
+	for day := rq.gte; day.Before(rq.end); day = day.Add(oneDay) {
+		dayNames, ok, err := d.usernamesOfDay(day, bomDirName)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+
+		names.merge(dayNames)
+	}
+
+	if rq.endInclusive {
+		boundaryNames, err := d.DistinctValues(boundaryQuery(query, rq.end), distinctUserName)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, name := range boundaryNames {
+			names[name] = true
+		}
+	}
+
+	return mapKeys(names), true, query.Context().Err()
+}
+
+// onlyBOMFilters returns true if the query's filters are just match_phrases on
+// BOM and META_CLUSTER_NAME, and a range.
+func onlyBOMFilters(query *es.Query) bool {
+	for _, filter := range query.Query.Bool.Filter {
+		for kind := range filter {
+			if kind != "match_phrase" && kind != "range" {
+				return false
+			}
+		}
+	}
+
+	for field := range query.MatchFilters() {
+		if field != "BOM" && field != "META_CLUSTER_NAME" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// usernamesOfDay reads the usernames file of the given BOM directory on the
+// given day, like rollupOfDay().
+func (d *DB) usernamesOfDay(day time.Time, bomDirName string) (usernameSet, bool, error) {
+	bomDir, ok := d.completeDayBOMDir(day, bomDirName)
+	if !ok {
+		return nil, false, nil
+	}
+
+	u, err := readUsernames(bomDir)
+	if err == nil {
+		return u, true, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, err
+	}
+
+	if bomDirMissing(bomDir) {
+		return make(usernameSet), true, nil
+	}
+
+	return nil, false, nil
+}
@@ -0,0 +1,145 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestUsernames(t *testing.T) {
+	Convey("A usernameSet can be written to a file and read back", t, func() {
+		u := usernameSet{"b": true, "a": true}
+		dir := t.TempDir()
+
+		So(u.write(dir), ShouldBeNil)
+
+		data, err := os.ReadFile(filepath.Join(dir, usernamesBasename))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, `["a","b"]`)
+
+		u2, err := readUsernames(dir)
+		So(err, ShouldBeNil)
+		So(u2, ShouldResemble, u)
+
+		u2.merge(usernameSet{"c": true})
+		So(u2, ShouldHaveLength, 3)
+
+		_, err = readUsernames(t.TempDir())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given a database with stored hits", t, func() {
+		config := Config{
+			Directory:  t.TempDir(),
+			FileSize:   fileSize,
+			BufferSize: bufferSize,
+		}
+
+		gte, err := time.Parse(time.RFC3339, "2024-02-04T00:00:00Z")
+		So(err, ShouldBeNil)
+
+		lte := gte.Add(oneDay)
+		result := makeResult(gte, lte.Add(10*time.Second))
+
+		db, err := New(config, false)
+		So(err, ShouldBeNil)
+
+		hitCh := make(chan *es.Hit)
+		errCh := make(chan error)
+
+		go func() {
+			errCh <- db.Store(hitCh)
+		}()
+
+		for _, hit := range result.HitSet.Hits {
+			hitCh <- &hit
+		}
+
+		close(hitCh)
+		So(<-errCh, ShouldBeNil)
+		So(db.Close(), ShouldBeNil)
+
+		bomDir := filepath.Join(config.Directory, "2024", "02", "04", "bomB")
+
+		u, err := readUsernames(bomDir)
+		So(err, ShouldBeNil)
+		So(u, ShouldResemble, usernameSet{"userA": true, "userB": true})
+
+		So(usernameSet{"fromFile": true}.write(bomDir), ShouldBeNil)
+
+		db, err = New(config, false)
+		So(err, ShouldBeNil)
+
+		defer func() {
+			So(db.Close(), ShouldBeNil)
+		}()
+
+		query := &es.Query{
+			Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+				{"match_phrase": {"META_CLUSTER_NAME": "farm"}},
+				rangeFilter("lt", gte, lte),
+				{"match_phrase": {"BOM": "bomB"}},
+			}}},
+		}
+
+		usernames := func() []string {
+			names, errd := db.Usernames(query)
+			So(errd, ShouldBeNil)
+
+			sort.Strings(names)
+
+			return names
+		}
+
+		Convey("Usernames of whole days come from the usernames files", func() {
+			So(usernames(), ShouldResemble, []string{"fromFile"})
+
+			query.Query.Bool.Filter[1] = rangeFilter("lte", gte, lte)
+			So(usernames(), ShouldResemble, []string{"fromFile", "userB"})
+		})
+
+		Convey("Other queries scan the index files", func() {
+			query.Query.Bool.Filter[1] = rangeFilter("lt", gte.Add(time.Hour), lte)
+			So(usernames(), ShouldResemble, []string{"userA", "userB"})
+
+			query.Query.Bool.Filter[1] = rangeFilter("lt", gte, lte)
+			query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+				map[string]es.MapStringStringOrMap{"match_phrase": {"ACCOUNTING_NAME": "groupA"}})
+			So(usernames(), ShouldResemble, []string{"userA", "userB"})
+		})
+
+		Convey("Days without usernames files scan the index files", func() {
+			So(os.Remove(filepath.Join(bomDir, usernamesBasename)), ShouldBeNil)
+			So(usernames(), ShouldResemble, []string{"userA", "userB"})
+		})
+	})
+}
@@ -0,0 +1,248 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// Counter types have a Count function for counting the hits matching a query
+// in something like elastic search, like es.Client.
+type Counter interface {
+	Count(query *es.Query) (int, error)
+}
+
+// DayCount is the result of comparing the number of hits elastic search said a
+// day had with the number of hits we were given to store for it.
+type DayCount struct {
+	Day     string `json:"day"`
+	Elastic int    `json:"elastic"`
+	Stored  int    `json:"stored"`
+}
+
+// Matches returns true if the Elastic and Stored counts are the same.
+func (c DayCount) Matches() bool {
+	return c.Elastic == c.Stored
+}
+
+// countRecorder is a dayBackfiller that can record a DayCount along with its
+// record of a day being completely stored.
+type countRecorder interface {
+	// recordCount records the given count of the given day; it must be called
+	// before finishDay().
+	recordCount(day time.Time, count DayCount) error
+}
+
+// countVerifier is a dayRefiller that counts the hits stored for each day by
+// another dayRefiller, then compares that with elastic search's count of the
+// day's hits before finishing the day.
+type countVerifier struct {
+	dayRefiller
+	counter  Counter
+	clusters []string
+
+	mu     sync.Mutex
+	stored map[time.Time]int
+}
+
+// verifyCountsIfConfigured returns the given dayRefiller wrapped in a
+// countVerifier if config.VerifyCounts is true and the given client can count.
+func verifyCountsIfConfigured(client Scroller, config Config, ldb dayRefiller) dayRefiller {
+	if !config.VerifyCounts {
+		return ldb
+	}
+
+	counter, ok := client.(Counter)
+	if !ok {
+		slog.Warn("not verifying counts, since client can't count")
+
+		return ldb
+	}
+
+	return &countVerifier{dayRefiller: ldb, counter: counter, clusters: config.ClustersOrDefault(),
+		stored: make(map[time.Time]int)}
+}
+
+// storeDay counts the hits from the channel as they are stored by our
+// dayRefiller.
+func (c *countVerifier) storeDay(day time.Time, hitCh chan *es.Hit) error {
+	counted := make(chan *es.Hit)
+	errCh := make(chan error)
+
+	go func() {
+		err := c.dayRefiller.storeDay(day, counted)
+
+		for range counted { //nolint:revive
+		}
+
+		errCh <- err
+	}()
+
+	n := 0
+
+	for hit := range hitCh {
+		n++
+		counted <- hit
+	}
+
+	close(counted)
+
+	c.mu.Lock()
+	c.stored[day] = n
+	c.mu.Unlock()
+
+	return <-errCh
+}
+
+// countElastic returns elastic search's count of the given day's hits in all
+// our clusters.
+func (c *countVerifier) countElastic(day time.Time) (int, error) {
+	total := 0
+
+	for _, cluster := range c.clusters {
+		n, err := c.counter.Count(clusterRangeQuery(day, day.Add(oneDay), cluster))
+		if err != nil {
+			return 0, err
+		}
+
+		total += n
+	}
+
+	return total, nil
+}
+
+// finishDay gets elastic search's count of the given day's hits, logs a warning
+// if it doesn't match the number we stored, and records the counts if our
+// dayRefiller can, before finishing the day.
+func (c *countVerifier) finishDay(day time.Time) error {
+	c.mu.Lock()
+	stored := c.stored[day]
+	delete(c.stored, day)
+	c.mu.Unlock()
+
+	elastic, err := c.countElastic(day)
+	if err != nil {
+		return err
+	}
+
+	count := DayCount{Day: timestampToDay(day.Unix()), Elastic: elastic, Stored: stored}
+
+	if !count.Matches() {
+		slog.Warn("hit count mismatch", "day", count.Day, "elastic", count.Elastic, "stored", count.Stored)
+	}
+
+	if recorder, ok := c.dayRefiller.(countRecorder); ok {
+		if err = recorder.recordCount(day, count); err != nil {
+			return err
+		}
+	}
+
+	return c.dayRefiller.finishDay(day)
+}
+
+// recordCount writes the given count as JSON in to the success sentinel file
+// of the given day in our backfillingDir(), which finishDay() will then leave
+// in place.
+func (d *DB) recordCount(day time.Time, count DayCount) error {
+	path := filepath.Join(d.backfillingDateFolder(day), successBasename)
+
+	if err := os.MkdirAll(filepath.Dir(path), dbDirPerms); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(count)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, dbFilePerms)
+}
+
+// CountReport summarises the DayCounts recorded for the days in a database
+// directory.
+type CountReport struct {
+	// Verified is the number of days that have a recorded DayCount.
+	Verified int
+
+	// Unverified is the number of days that were backfilled without
+	// Config.VerifyCounts.
+	Unverified int
+
+	// Mismatches are the recorded DayCounts that don't match.
+	Mismatches []DayCount
+}
+
+// ReportCounts reads the DayCounts recorded by Backfill()s with
+// Config.VerifyCounts for the days in the configured Directory. Only the flat
+// Backend records DayCounts.
+func ReportCounts(config Config) (*CountReport, error) {
+	report := &CountReport{}
+
+	err := forEachBackfilledDay(config.Directory, func(dayDir string) error {
+		count, ok, err := readDayCount(dayDir)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			report.Unverified++
+
+			return nil
+		}
+
+		report.Verified++
+
+		if !count.Matches() {
+			report.Mismatches = append(report.Mismatches, count)
+		}
+
+		return nil
+	})
+
+	return report, err
+}
+
+// readDayCount reads the DayCount recorded in the success sentinel of the given
+// day directory, returning false if there isn't one.
+func readDayCount(dayDir string) (DayCount, bool, error) {
+	var count DayCount
+
+	data, err := os.ReadFile(filepath.Join(dayDir, successBasename))
+	if err != nil || len(data) == 0 {
+		return count, false, err
+	}
+
+	err = json.Unmarshal(data, &count)
+
+	return count, err == nil, err
+}
@@ -0,0 +1,97 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// overCounter is an es.Mock that counts one more hit than there really are.
+type overCounter struct {
+	*es.Mock
+}
+
+func (o overCounter) Count(query *es.Query) (int, error) {
+	n, err := o.Mock.Count(query)
+
+	return n + 1, err
+}
+
+func TestVerifyCounts(t *testing.T) {
+	from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+	period := 2 * oneDay
+
+	Convey("Without VerifyCounts, Backfill()ed days are unverified", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		config := Config{Directory: t.TempDir()}
+
+		_, err := Backfill(es.NewMock("some-indexes-*"), config, from, period)
+		So(err, ShouldBeNil)
+
+		report, err := ReportCounts(config)
+		So(err, ShouldBeNil)
+		So(report.Verified, ShouldEqual, 0)
+		So(report.Unverified, ShouldEqual, 3)
+		So(report.Mismatches, ShouldBeEmpty)
+	})
+
+	Convey("With VerifyCounts, Backfill() records the counts of each day", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelError)
+
+		config := Config{Directory: t.TempDir(), VerifyCounts: true}
+
+		_, err := Backfill(es.NewMock("some-indexes-*"), config, from, period)
+		So(err, ShouldBeNil)
+
+		report, err := ReportCounts(config)
+		So(err, ShouldBeNil)
+		So(report.Verified, ShouldEqual, 3)
+		So(report.Unverified, ShouldEqual, 0)
+		So(report.Mismatches, ShouldBeEmpty)
+
+		count, ok, err := readDayCount(filepath.Join(config.Directory, "2024", "05", "31"))
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(count, ShouldResemble, DayCount{Day: "2024/05/31", Elastic: 1, Stored: 1})
+
+		Convey("and mismatches are reported", func() {
+			_, err = Refill(overCounter{es.NewMock("some-indexes-*")}, config, from.Add(-oneDay))
+			So(err, ShouldBeNil)
+
+			report, err = ReportCounts(config)
+			So(err, ShouldBeNil)
+			So(report.Verified, ShouldEqual, 3)
+			So(report.Mismatches, ShouldResemble, []DayCount{{Day: "2024/05/31", Elastic: 2, Stored: 1}})
+		})
+	})
+}
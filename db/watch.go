@@ -0,0 +1,327 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	watchDepthYear    = 1
+	watchDepthMonth   = 2
+	watchDepthDay     = 3
+	watchDepthDayFile = 4
+)
+
+// watchForNewDays uses filesystem notifications to load the indexes of each
+// day as soon as its Backfill() completes, rather than waiting for the next
+// update via our UpdateFrequency ticker. This includes days older than our
+// latestDate, which the ticker doesn't look for.
+//
+// We watch our directory, its year and month subdirectories, and any day
+// directories that haven't been successfully backfilled yet.
+func (d *DB) watchForNewDays() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	d.watcher = watcher
+
+	if err = d.watchDir(d.dir); err != nil {
+		watcher.Close()
+
+		return err
+	}
+
+	go d.handleWatchEvents()
+
+	return nil
+}
+
+// watchDir adds a watch to the given directory, then handles its existing
+// subdirectories as if they had just been created.
+func (d *DB) watchDir(dir string) error {
+	if err := d.watcher.Add(dir); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if err = d.handleCreatedDir(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleCreatedDir watches the given year or month dir, or a day dir if that
+// day isn't successfully backfilled yet. Days still being backfilled in our
+// backfillingDir() and other hidden directories are ignored.
+func (d *DB) handleCreatedDir(dir string) error {
+	if isHiddenTopDir(d.dir, dir) {
+		return nil
+	}
+
+	switch d.watchDepth(dir) {
+	case watchDepthYear, watchDepthMonth:
+		return d.watchDir(dir)
+	case watchDepthDay:
+		return d.watchDay(dir)
+	}
+
+	return nil
+}
+
+// watchDay loads the given day dir if it has been successfully backfilled,
+// otherwise watches it so we can load it when it is.
+func (d *DB) watchDay(dayDir string) error {
+	backfilled, err := dayBackfilled(dayDir)
+	if err != nil || backfilled {
+		d.loadNewDay(dayDir)
+
+		return err
+	}
+
+	if err = d.watcher.Add(dayDir); err != nil {
+		return err
+	}
+
+	// in case it completed before we started watching
+	backfilled, err = dayBackfilled(dayDir)
+	if backfilled {
+		d.loadNewDay(dayDir)
+	}
+
+	return err
+}
+
+func dayBackfilled(dayDir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(dayDir, successBasename))
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// watchDepth returns how many directories deep the given path is within our
+// directory.
+func (d *DB) watchDepth(path string) int {
+	rel, err := filepath.Rel(d.dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return 0
+	}
+
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+func (d *DB) handleWatchEvents() {
+	for {
+		select {
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+
+			d.handleWatchEvent(event)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			slog.Error("watching for new days failed", "err", err)
+		}
+	}
+}
+
+func (d *DB) handleWatchEvent(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) {
+		return
+	}
+
+	if filepath.Base(event.Name) == successBasename && d.watchDepth(event.Name) == watchDepthDayFile {
+		dayDir := filepath.Dir(event.Name)
+		d.loadNewDay(dayDir)
+
+		if err := d.watcher.Remove(dayDir); err != nil {
+			slog.Debug("unwatching day failed", "dir", dayDir, "err", err)
+		}
+
+		return
+	}
+
+	if isHourSuccessFile(event.Name) && d.watchDepth(event.Name) == watchDepthDayFile {
+		d.loadNewDay(filepath.Dir(event.Name))
+
+		return
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil || !info.IsDir() {
+		return
+	}
+
+	if d.watchDepth(event.Name) == watchDepthDay {
+		d.markDayReplaced(event.Name)
+	}
+
+	if err = d.handleCreatedDir(event.Name); err != nil {
+		slog.Error("watching new directory failed", "dir", event.Name, "err", err)
+	}
+}
+
+// markDayReplaced notes that the given newly created day directory replaced
+// one we had already loaded or lazily noted the indexes of (eg. because of a
+// Refill()), if we had, so that loadDay() will reload it. See also
+// markRefilledDays().
+func (d *DB) markDayReplaced(dayDir string) {
+	if len(d.dateBOMDirsWithPrefix(dayDir+string(filepath.Separator))) == 0 {
+		return
+	}
+
+	d.muDateBOMDirs.Lock()
+	defer d.muDateBOMDirs.Unlock()
+
+	d.replacedDays[dayDir] = true
+}
+
+// markRefilledDays calls markDayReplaced() on each loaded day whose success
+// sentinel changed since we last looked (or, for days we hadn't looked at
+// before, was created since then), eg. because a Refill() replaced the day.
+// This lets regular updates reload such days when we aren't watching for new
+// days. Returns the directories of the days it marked.
+func (d *DB) markRefilledDays() []string {
+	since := d.refillsChecked
+	d.refillsChecked = time.Now()
+
+	stamps := make(map[string]time.Time)
+
+	var marked []string
+
+	for _, dayDir := range d.loadedDayDirs() {
+		info, err := os.Stat(filepath.Join(d.realDayDir(dayDir), successBasename))
+		if err != nil {
+			continue
+		}
+
+		stamp := info.ModTime()
+		stamps[dayDir] = stamp
+
+		previous, seen := d.refillStamps[dayDir]
+		if (seen && !stamp.Equal(previous)) || (!seen && stamp.After(since)) {
+			d.markDayReplaced(dayDir)
+			marked = append(marked, dayDir)
+		}
+	}
+
+	d.refillStamps = stamps
+
+	return marked
+}
+
+// takeReplacedDay returns true if markDayReplaced() was called on the given
+// day directory since the last call to this.
+func (d *DB) takeReplacedDay(dayDir string) bool {
+	d.muDateBOMDirs.Lock()
+	defer d.muDateBOMDirs.Unlock()
+
+	replaced := d.replacedDays[dayDir]
+	delete(d.replacedDays, dayDir)
+
+	return replaced
+}
+
+// loadNewDay loads the indexes in the given date directory, unless we've
+// already loaded them, then updates our index cache.
+func (d *DB) loadNewDay(dateFolder string) {
+	if !d.loadDay(dateFolder) {
+		return
+	}
+
+	if err := d.writeIndexCache(); err != nil {
+		slog.Error("writeIndexCache failed", "err", err)
+	}
+}
+
+// loadDay loads the indexes in the given date directory, unless we've already
+// loaded them. Days we loaded hour segments of, or that were replaced since we
+// loaded them, are reloaded. Returns true if it loaded any new indexes.
+func (d *DB) loadDay(dateFolder string) bool {
+	d.muLoadDay.Lock()
+	defer d.muLoadDay.Unlock()
+
+	loaded := d.loadOrReloadDay(dateFolder)
+	if loaded {
+		d.dataVersion.Add(1)
+	}
+
+	return loaded
+}
+
+// loadOrReloadDay does the work of loadDay(), which must hold muLoadDay.
+func (d *DB) loadOrReloadDay(dateFolder string) bool {
+	prefix := d.canonicalPath(dateFolder) + string(filepath.Separator)
+
+	if len(d.dateBOMDirsWithPrefix(prefix)) > 0 {
+		if !d.isPartialDay(dateFolder) && !d.takeReplacedDay(dateFolder) {
+			return false
+		}
+
+		if dir, ok := d.existingDayDir(d.canonicalPath(dateFolder)); ok {
+			dateFolder = dir
+		}
+
+		return d.reloadDay(dateFolder)
+	}
+
+	if err := d.loadAllFlatIndexes(dateFolder); err != nil {
+		slog.Error("loadAllFlatIndexes failed", "err", err)
+	}
+
+	return len(d.dateBOMDirsWithPrefix(prefix)) > 0
+}
@@ -0,0 +1,127 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	ErrInvalidIndexWidth = "index field width must be between 1 and 255"
+
+	// indexHeaderMagic starts the header of index files that were made with
+	// non-default IndexWidths. Index files without a header start with a big
+	// endian timestamp, which won't have this first byte.
+	indexHeaderMagic = "\xffFW"
+	indexHeaderLen   = len(indexHeaderMagic) + 3
+	maxIndexWidth    = 255
+)
+
+// IndexWidths are the maximum lengths of the ACCOUNTING_NAME, USER_NAME and
+// QUEUE_NAME values of hits that can be stored in a DB. Storing a hit with a
+// longer value fails with ErrFieldTooLong. Zero values default to 24, 15 and 20
+// respectively. Larger widths make for larger index files.
+//
+// The widths are recorded in the header of each index file, so you can change
+// them for new days without needing to backfill again.
+type IndexWidths struct {
+	AccountingName int
+	UserName       int
+	QueueName      int
+}
+
+// defaultIndexWidths are the widths used for index files without a header.
+var defaultIndexWidths = IndexWidths{ //nolint:gochecknoglobals
+	AccountingName: accountingNameWidth,
+	UserName:       userNameWidth,
+	QueueName:      queueNameWidth,
+}
+
+// OrDefaults returns a copy of our widths, with zero values replaced by the
+// default widths.
+func (w IndexWidths) OrDefaults() IndexWidths {
+	if w.AccountingName == 0 {
+		w.AccountingName = accountingNameWidth
+	}
+
+	if w.UserName == 0 {
+		w.UserName = userNameWidth
+	}
+
+	if w.QueueName == 0 {
+		w.QueueName = queueNameWidth
+	}
+
+	return w
+}
+
+// validate returns an ErrInvalidIndexWidth Error if any of our widths can't be
+// recorded in an index file header.
+func (w IndexWidths) validate() error {
+	for _, width := range []int{w.AccountingName, w.UserName, w.QueueName} {
+		if width < 1 || width > maxIndexWidth {
+			return Error{Msg: ErrInvalidIndexWidth, cause: fmt.Sprintf("%d", width)}
+		}
+	}
+
+	return nil
+}
+
+// header returns the bytes that should start an index file made with our
+// widths, which will be nothing for the default widths.
+func (w IndexWidths) header() []byte {
+	if w == defaultIndexWidths {
+		return nil
+	}
+
+	return append([]byte(indexHeaderMagic), byte(w.AccountingName), byte(w.UserName), byte(w.QueueName))
+}
+
+// readIndexHeader reads the header from the start of an index file, if it has
+// one, returning the widths the index file was made with.
+func readIndexHeader(br *bufio.Reader) (IndexWidths, error) {
+	magic, err := br.Peek(len(indexHeaderMagic))
+	if err != nil || !bytes.Equal(magic, []byte(indexHeaderMagic)) {
+		return defaultIndexWidths, nil
+	}
+
+	header := make([]byte, indexHeaderLen)
+
+	if _, err = io.ReadFull(br, header); err != nil {
+		return defaultIndexWidths, err
+	}
+
+	widths := header[len(indexHeaderMagic):]
+
+	return IndexWidths{
+		AccountingName: int(widths[0]),
+		UserName:       int(widths[1]),
+		QueueName:      int(widths[2]),
+	}, nil
+}
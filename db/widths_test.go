@@ -0,0 +1,88 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package db
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestIndexWidths(t *testing.T) {
+	Convey("IndexWidths default to the original fixed widths, which need no index header", t, func() {
+		widths := IndexWidths{UserName: 30}.OrDefaults()
+		So(widths, ShouldResemble, IndexWidths{AccountingName: 24, UserName: 30, QueueName: 20})
+		So(widths.validate(), ShouldBeNil)
+		So(widths.header(), ShouldHaveLength, indexHeaderLen)
+
+		So(IndexWidths{}.OrDefaults().header(), ShouldBeNil)
+
+		widths.QueueName = maxIndexWidth + 1
+		So(widths.validate(), ShouldNotBeNil)
+	})
+
+	Convey("Given hits with long group names, you can Backfill() with wider IndexWidths", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		from := time.Date(2024, 06, 1, 0, 30, 0, 0, time.UTC)
+		period := oneDay
+		mock := es.NewMock("long_group")
+		config := Config{Directory: t.TempDir(), IndexWidths: IndexWidths{AccountingName: 40}}
+
+		_, err := Backfill(mock, config, from, period)
+		So(err, ShouldBeNil)
+
+		indexPath := filepath.Join(config.Directory, "2024", "05", "31", "Human Genetics", "0.index")
+		b, err := os.ReadFile(indexPath)
+		So(err, ShouldBeNil)
+		So(string(b[:len(indexHeaderMagic)]), ShouldEqual, indexHeaderMagic)
+
+		db, err := New(config, true)
+		So(err, ShouldBeNil)
+
+		defer db.Close()
+
+		query := rangeQuery(timeRange(from, period))
+		query.Query.Bool.Filter = append(query.Query.Bool.Filter,
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}},
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{
+				"ACCOUNTING_NAME": "long_long_long_long_long_long_long"}},
+			map[string]es.MapStringStringOrMap{"match_phrase": map[string]interface{}{"QUEUE_NAME": "q3"}},
+		)
+
+		result, err := db.Scroll(query)
+		So(err, ShouldBeNil)
+		So(result.HitSet.Hits, ShouldHaveLength, 1)
+		So(result.HitSet.Hits[0].Details.UserName, ShouldEqual, "u3")
+
+		db.Done(result.PoolKey)
+	})
+}
@@ -0,0 +1,288 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+const (
+	// ArrowContentType is the media type of an Arrow IPC stream.
+	ArrowContentType = "application/vnd.apache.arrow.stream"
+
+	// ParquetContentType is the media type of a Parquet file.
+	ParquetContentType = "application/vnd.apache.parquet"
+
+	// arrowBatchRows is the number of hits in each Arrow record batch, and thus
+	// each Parquet row group.
+	arrowBatchRows = 64 * 1024
+)
+
+// detailsFieldIndexes returns the index of each Details field, keyed on its
+// _source field name.
+var detailsFieldIndexes = sync.OnceValue(func() map[string]int { //nolint:gochecknoglobals
+	t := reflect.TypeOf(Details{})
+	indexes := make(map[string]int, t.NumField())
+
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		indexes[name] = i
+	}
+
+	return indexes
+})
+
+// arrowColumn is a column of hits in an Arrow record batch.
+type arrowColumn struct {
+	name  string
+	index int
+}
+
+// value returns the reflect.Value of our column in the given hit.
+func (c arrowColumn) value(hit Hit) reflect.Value {
+	if c.name == "_id" {
+		return reflect.ValueOf(hit.ID)
+	}
+
+	details := hit.Details
+	if details == nil {
+		details = &Details{}
+	}
+
+	return reflect.ValueOf(details).Elem().Field(c.index)
+}
+
+// ArrowSchema returns the Arrow schema of record batches with the given
+// columns, which must be "_id" or one of the SourceFields(). The types match
+// our Mappings(): longs are int64, doubles are float64, keywords are strings
+// (EXEC_HOSTNAME being a list of them), and timestamp is a timestamp in
+// seconds.
+func ArrowSchema(columns []string) (*arrow.Schema, error) {
+	schema, _, err := arrowSchemaAndColumns(columns)
+
+	return schema, err
+}
+
+func arrowSchemaAndColumns(columns []string) (*arrow.Schema, []arrowColumn, error) {
+	if err := ValidateColumns(columns); err != nil {
+		return nil, nil, err
+	}
+
+	t := reflect.TypeOf(Details{})
+	indexes := detailsFieldIndexes()
+	fields := make([]arrow.Field, len(columns))
+	cols := make([]arrowColumn, len(columns))
+
+	for i, column := range columns {
+		cols[i] = arrowColumn{name: column, index: indexes[column]}
+
+		dataType := arrow.DataType(arrow.BinaryTypes.String)
+		if column != "_id" {
+			dataType = arrowType(column, t.Field(cols[i].index).Type)
+		}
+
+		fields[i] = arrow.Field{Name: column, Type: dataType}
+	}
+
+	return arrow.NewSchema(fields, nil), cols, nil
+}
+
+// arrowType returns the Arrow type of a Details field with the given name and
+// type, matching its fieldMapping().
+func arrowType(name string, t reflect.Type) arrow.DataType {
+	var dataType arrow.DataType
+
+	switch fieldMapping(name, t).Type {
+	case "date":
+		dataType = arrow.FixedWidthTypes.Timestamp_s
+	case "long":
+		dataType = arrow.PrimitiveTypes.Int64
+	case "double":
+		dataType = arrow.PrimitiveTypes.Float64
+	default:
+		dataType = arrow.BinaryTypes.String
+	}
+
+	if t.Kind() == reflect.Slice {
+		return arrow.ListOf(dataType)
+	}
+
+	return dataType
+}
+
+// WriteArrow writes an Arrow IPC stream of our hits, with the given columns
+// (see ArrowSchema()), to the given writer, in record batches of up to 64Ki
+// hits.
+func (r *Result) WriteArrow(w io.Writer, columns []string) error {
+	hw, err := NewArrowWriter(w, columns)
+	if err != nil {
+		return err
+	}
+
+	return r.writeHits(hw)
+}
+
+// WriteParquet is like WriteArrow(), but writes a snappy compressed Parquet
+// file, with a row group per record batch.
+func (r *Result) WriteParquet(w io.Writer, columns []string) error {
+	hw, err := NewParquetWriter(w, columns)
+	if err != nil {
+		return err
+	}
+
+	return r.writeHits(hw)
+}
+
+// arrowWriter is a HitWriter that builds record batches of hits and passes
+// each full one to its write func.
+type arrowWriter struct {
+	builder *array.RecordBuilder
+	cols    []arrowColumn
+	rows    int
+	write   func(arrow.Record) error
+	close   func() error
+}
+
+// NewArrowWriter returns a HitWriter that writes an Arrow IPC stream of the
+// hits written to it, with the given columns (see ArrowSchema()), to the given
+// writer, in record batches of up to 64Ki hits.
+func NewArrowWriter(w io.Writer, columns []string) (HitWriter, error) {
+	schema, cols, err := arrowSchemaAndColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	iw := ipc.NewWriter(w, ipc.WithSchema(schema))
+
+	return newArrowWriter(schema, cols, iw.Write, iw.Close), nil
+}
+
+// NewParquetWriter is like NewArrowWriter(), but writes a snappy compressed
+// Parquet file, with a row group per record batch.
+func NewParquetWriter(w io.Writer, columns []string) (HitWriter, error) {
+	schema, cols, err := arrowSchemaAndColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	fw, err := pqarrow.NewFileWriter(schema, w,
+		parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy)),
+		pqarrow.DefaultWriterProps())
+	if err != nil {
+		return nil, err
+	}
+
+	return newArrowWriter(schema, cols, fw.Write, fw.Close), nil
+}
+
+func newArrowWriter(schema *arrow.Schema, cols []arrowColumn, write func(arrow.Record) error,
+	closer func() error,
+) *arrowWriter {
+	return &arrowWriter{
+		builder: array.NewRecordBuilder(memory.DefaultAllocator, schema),
+		cols:    cols,
+		write:   write,
+		close:   closer,
+	}
+}
+
+// WriteHit appends the given hit to our current record batch, writing the
+// batch once it is full.
+func (a *arrowWriter) WriteHit(hit *Hit) error {
+	for i, col := range a.cols {
+		appendArrowValue(a.builder.Field(i), col.value(*hit))
+	}
+
+	a.rows++
+
+	if a.rows < arrowBatchRows {
+		return nil
+	}
+
+	return a.flush()
+}
+
+// flush writes our current record batch, if it has any hits.
+func (a *arrowWriter) flush() error {
+	if a.rows == 0 {
+		return nil
+	}
+
+	a.rows = 0
+
+	return writeArrowRecord(a.builder.NewRecord(), a.write)
+}
+
+// Close writes our final record batch and closes our stream or file.
+func (a *arrowWriter) Close() error {
+	defer a.builder.Release()
+
+	err := a.flush()
+
+	if errc := a.close(); err == nil {
+		err = errc
+	}
+
+	return err
+}
+
+func writeArrowRecord(rec arrow.Record, cb func(arrow.Record) error) error {
+	defer rec.Release()
+
+	return cb(rec)
+}
+
+// appendArrowValue appends the given value to the given builder, which must be
+// of the type arrowType() returned for the value's field.
+func appendArrowValue(b array.Builder, v reflect.Value) {
+	switch fb := b.(type) {
+	case *array.StringBuilder:
+		fb.Append(v.String())
+	case *array.Int64Builder:
+		fb.Append(v.Int())
+	case *array.Float64Builder:
+		fb.Append(v.Float())
+	case *array.TimestampBuilder:
+		fb.Append(arrow.Timestamp(v.Int()))
+	case *array.ListBuilder:
+		fb.Append(true)
+
+		for i := range v.Len() {
+			appendArrowValue(fb.ValueBuilder(), v.Index(i))
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestArrow(t *testing.T) {
+	Convey("ArrowSchema() types columns like our Mappings()", t, func() {
+		schema, err := ArrowSchema([]string{"_id", "USER_NAME", "RUN_TIME_SEC",
+			"WASTED_CPU_SECONDS", "timestamp", "EXEC_HOSTNAME"})
+		So(err, ShouldBeNil)
+		So(schema.Fields(), ShouldHaveLength, 6)
+		So(schema.Field(0).Type.ID(), ShouldEqual, arrow.STRING)
+		So(schema.Field(1).Type.ID(), ShouldEqual, arrow.STRING)
+		So(schema.Field(2).Type.ID(), ShouldEqual, arrow.INT64)
+		So(schema.Field(3).Type.ID(), ShouldEqual, arrow.FLOAT64)
+		So(schema.Field(4).Type.ID(), ShouldEqual, arrow.TIMESTAMP)
+		So(schema.Field(5).Type.ID(), ShouldEqual, arrow.LIST)
+
+		_, err = ArrowSchema(SourceFields())
+		So(err, ShouldBeNil)
+
+		_, err = ArrowSchema([]string{"USER_NAME", "foo"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given a Result with some hits", t, func() {
+		result := &Result{HitSet: &HitSet{Hits: []Hit{
+			{ID: "1", Details: &Details{UserName: "u1", RunTimeSec: 10, WastedCPUSeconds: 1.5,
+				Timestamp: 1707004800, ExecHostname: []string{"h1", "h2"}}},
+			{ID: "2", Details: &Details{UserName: "u2", RunTimeSec: 20}},
+		}}}
+		columns := []string{"_id", "USER_NAME", "RUN_TIME_SEC", "WASTED_CPU_SECONDS", "timestamp", "EXEC_HOSTNAME"}
+
+		checkRecord := func(rec arrow.Record) {
+			So(rec.NumRows(), ShouldEqual, 2)
+			So(rec.NumCols(), ShouldEqual, 6)
+			So(rec.Column(0).(*array.String).Value(1), ShouldEqual, "2")
+			So(rec.Column(1).(*array.String).Value(0), ShouldEqual, "u1")
+			So(rec.Column(2).(*array.Int64).Int64Values(), ShouldResemble, []int64{10, 20})
+			So(rec.Column(3).(*array.Float64).Float64Values(), ShouldResemble, []float64{1.5, 0})
+
+			unit := rec.Schema().Field(4).Type.(*arrow.TimestampType).Unit
+			So(rec.Column(4).(*array.Timestamp).Value(0).ToTime(unit).Unix(), ShouldEqual, 1707004800)
+
+			hosts := rec.Column(5).(*array.List)
+			So(hosts.Len(), ShouldEqual, 2)
+			So(hosts.ListValues().(*array.String).Value(1), ShouldEqual, "h2")
+			start, end := hosts.ValueOffsets(1)
+			So(end-start, ShouldEqual, 0)
+		}
+
+		Convey("You can write them as an Arrow IPC stream", func() {
+			var b bytes.Buffer
+
+			err := result.WriteArrow(&b, columns)
+			So(err, ShouldBeNil)
+
+			reader, err := ipc.NewReader(&b)
+			So(err, ShouldBeNil)
+
+			defer reader.Release()
+
+			So(reader.Schema().Field(1).Name, ShouldEqual, "USER_NAME")
+			So(reader.Next(), ShouldBeTrue)
+			checkRecord(reader.Record())
+			So(reader.Next(), ShouldBeFalse)
+			So(reader.Err(), ShouldBeNil)
+		})
+
+		Convey("You can write them as a Parquet file", func() {
+			var b bytes.Buffer
+
+			err := result.WriteParquet(&b, columns)
+			So(err, ShouldBeNil)
+
+			pf, err := file.NewParquetReader(bytes.NewReader(b.Bytes()))
+			So(err, ShouldBeNil)
+
+			defer pf.Close()
+
+			So(pf.NumRows(), ShouldEqual, 2)
+
+			fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+			So(err, ShouldBeNil)
+
+			table, err := fr.ReadTable(context.Background())
+			So(err, ShouldBeNil)
+
+			defer table.Release()
+
+			tr := array.NewTableReader(table, -1)
+			defer tr.Release()
+
+			So(tr.Next(), ShouldBeTrue)
+			checkRecord(tr.Record())
+		})
+
+		Convey("Many hits are split into several record batches", func() {
+			hits := make([]Hit, arrowBatchRows+1)
+			for i := range hits {
+				hits[i] = Hit{Details: &Details{RunTimeSec: int64(i)}}
+			}
+
+			var b bytes.Buffer
+
+			err := (&Result{HitSet: &HitSet{Hits: hits}}).WriteArrow(&b, []string{"RUN_TIME_SEC"})
+			So(err, ShouldBeNil)
+
+			reader, err := ipc.NewReader(&b)
+			So(err, ShouldBeNil)
+
+			defer reader.Release()
+
+			So(reader.Next(), ShouldBeTrue)
+			So(reader.Record().NumRows(), ShouldEqual, arrowBatchRows)
+			So(reader.Next(), ShouldBeTrue)
+			So(reader.Record().NumRows(), ShouldEqual, 1)
+			So(reader.Record().Column(0).(*array.Int64).Value(0), ShouldEqual, arrowBatchRows)
+			So(reader.Next(), ShouldBeFalse)
+		})
+
+		Convey("Empty results give just the schema", func() {
+			var b bytes.Buffer
+
+			err := (&Result{}).WriteArrow(&b, columns)
+			So(err, ShouldBeNil)
+
+			reader, err := ipc.NewReader(&b)
+			So(err, ShouldBeNil)
+
+			defer reader.Release()
+
+			So(reader.Schema().NumFields(), ShouldEqual, 6)
+			So(reader.Next(), ShouldBeFalse)
+		})
+
+		Convey("You can't write unknown columns", func() {
+			var b bytes.Buffer
+
+			So(result.WriteArrow(&b, []string{"foo"}), ShouldNotBeNil)
+			So(result.WriteParquet(&b, []string{"foo"}), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,139 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ErrCircuitOpen = "elasticsearch is unavailable after repeated failures"
+
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// circuitBreakerTransport is an http.RoundTripper that stops making requests
+// for a cooldown period once a threshold of consecutive requests have failed,
+// failing them immediately with ErrCircuitOpen instead. After the cooldown, a
+// single trial request is allowed; if it succeeds, requests are made normally
+// again, otherwise we wait another cooldown.
+type circuitBreakerTransport struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openTill time.Time
+	trialing bool
+}
+
+// newCircuitBreakerTransport returns a circuitBreakerTransport that uses the
+// given RoundTripper, or http.DefaultTransport if that is nil. A cooldown of 0
+// means 30s.
+func newCircuitBreakerTransport(next http.RoundTripper, threshold int,
+	cooldown time.Duration) *circuitBreakerTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if cooldown == 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	return &circuitBreakerTransport{next: next, threshold: threshold, cooldown: cooldown}
+}
+
+// RoundTrip makes the request if our circuit is closed, or it's time for a
+// trial request, recording whether it failed. A request fails if it returns an
+// error, or a 5xx or 429 status.
+func (c *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !c.allow() {
+		return nil, Error{Msg: ErrCircuitOpen}
+	}
+
+	resp, err := c.next.RoundTrip(req)
+
+	c.record(err == nil && !isRetryableStatus(resp.StatusCode))
+
+	return resp, err
+}
+
+// allow returns true if a request can be made now.
+func (c *circuitBreakerTransport) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures < c.threshold {
+		return true
+	}
+
+	if c.trialing || time.Now().Before(c.openTill) {
+		return false
+	}
+
+	c.trialing = true
+
+	return true
+}
+
+// record records the success or failure of a request, opening our circuit if
+// that makes threshold consecutive failures.
+func (c *circuitBreakerTransport) record(ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.trialing = false
+
+	if ok {
+		c.failures = 0
+
+		return
+	}
+
+	c.failures++
+
+	if c.failures >= c.threshold {
+		c.openTill = time.Now().Add(c.cooldown)
+	}
+}
+
+// isRetryableStatus returns true for statuses that suggest the server is
+// struggling, rather than that the request was bad.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// IsUnavailable returns true if the given error is because a Client's circuit
+// breaker has tripped; see Config.BreakerThreshold.
+func IsUnavailable(err error) bool {
+	var esErr Error
+
+	return errors.As(err, &esErr) && esErr.Msg == ErrCircuitOpen
+}
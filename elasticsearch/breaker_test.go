@@ -0,0 +1,203 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flappingTransport is an http.RoundTripper that answers searches with the
+// given status until fixed, and counts the searches it was asked to do.
+type flappingTransport struct {
+	mu       sync.Mutex
+	status   int
+	searches int
+}
+
+func (f *flappingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status, jsonStr := http.StatusOK, `{"version": {"number": "7.17.0"}}`
+
+	if req.URL.Path != "/" {
+		f.searches++
+		status, jsonStr = f.status, testNonAggQueryResponseZeroSize
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(jsonStr)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			productHeader:  []string{productName},
+		},
+	}, nil
+}
+
+func (f *flappingTransport) set(status int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status = status
+	searches := f.searches
+	f.searches = 0
+
+	return searches
+}
+
+type errorTransport struct{}
+
+func (errorTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestRetriesAndBreaker(t *testing.T) {
+	Convey("Given a server that fails searches", t, func() {
+		transport := &flappingTransport{status: http.StatusTooManyRequests}
+		config := Config{
+			Host:      "mock",
+			Scheme:    "http",
+			Port:      mockPort,
+			Index:     "mock-*",
+			transport: transport,
+		}
+		query := &Query{}
+
+		Convey("searches are retried 3 times by default", func() {
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Search(query)
+			So(err, ShouldNotBeNil)
+			So(IsUnavailable(err), ShouldBeFalse)
+			So(transport.set(http.StatusOK), ShouldEqual, 4)
+		})
+
+		Convey("with a backoff between them", func() {
+			config.MaxRetries = 2
+			config.RetryBackoff = 10 * time.Millisecond
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			start := time.Now()
+			_, err = client.Search(query)
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 30*time.Millisecond)
+			So(transport.set(http.StatusOK), ShouldEqual, 3)
+		})
+
+		Convey("or not at all if MaxRetries is -1", func() {
+			config.MaxRetries = -1
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Search(query)
+			So(err, ShouldNotBeNil)
+			So(transport.set(http.StatusOK), ShouldEqual, 1)
+		})
+
+		Convey("a circuit breaker stops searches after repeated failures", func() {
+			config.MaxRetries = -1
+			config.BreakerThreshold = 2
+			config.BreakerCooldown = 50 * time.Millisecond
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			for range 2 {
+				_, err = client.Search(query)
+				So(err, ShouldNotBeNil)
+				So(IsUnavailable(err), ShouldBeFalse)
+			}
+
+			_, err = client.Search(query)
+			So(err, ShouldNotBeNil)
+			So(IsUnavailable(err), ShouldBeTrue)
+			So(transport.set(http.StatusOK), ShouldEqual, 2)
+
+			Convey("until it has cooled down and a trial search succeeds", func() {
+				<-time.After(config.BreakerCooldown)
+
+				result, err := client.Search(query)
+				So(err, ShouldBeNil)
+				So(result.HitSet.Total.Value, ShouldEqual, 2)
+
+				_, err = client.Search(query)
+				So(err, ShouldBeNil)
+				So(transport.set(http.StatusOK), ShouldEqual, 2)
+			})
+
+			Convey("but a failed trial search opens it again", func() {
+				<-time.After(config.BreakerCooldown)
+				transport.set(http.StatusBadGateway)
+
+				_, err = client.Search(query)
+				So(err, ShouldNotBeNil)
+				So(IsUnavailable(err), ShouldBeFalse)
+
+				_, err = client.Search(query)
+				So(IsUnavailable(err), ShouldBeTrue)
+				So(transport.set(http.StatusOK), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("A circuitBreakerTransport only allows one trial request at a time", t, func() {
+		cbt := newCircuitBreakerTransport(&countingTransport{}, 1, time.Millisecond)
+		cbt.record(false)
+
+		<-time.After(2 * time.Millisecond)
+
+		So(cbt.allow(), ShouldBeTrue)
+		So(cbt.allow(), ShouldBeFalse)
+
+		cbt.record(true)
+		So(cbt.allow(), ShouldBeTrue)
+		So(cbt.allow(), ShouldBeTrue)
+
+		Convey("and treats errors as failures", func() {
+			cbt.next = errorTransport{}
+
+			_, err := cbt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+			So(err, ShouldNotBeNil)
+			So(IsUnavailable(err), ShouldBeFalse)
+
+			_, err = cbt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+			So(IsUnavailable(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,126 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// canonical returns a copy of the query with its filter clauses in a canonical
+// form and order, and without Profile, for use by Key(), so that queries that
+// only differ in ways that can't change their results have the same Key().
+func (q *Query) canonical() *Query {
+	if q.Query == nil && !q.Profile {
+		return q
+	}
+
+	c := *q
+	c.Profile = false
+
+	if q.Query != nil {
+		c.Query = &QueryFilter{Bool: QFBool{Filter: canonicalFilter(q.Query.Bool.Filter)}}
+	}
+
+	return &c
+}
+
+// canonicalFilter returns the given filter clauses with their timestamp ranges
+// made canonical (see canonicalRange()), any duplicates removed, and sorted by
+// their JSON. If any clause can't be converted to JSON, returns the filter as
+// is.
+func canonicalFilter(filter Filter) Filter {
+	byJSON := make(map[string]map[string]MapStringStringOrMap, len(filter))
+	keys := make([]string, 0, len(filter))
+
+	for _, clause := range filter {
+		clause = canonicalRange(clause)
+
+		b, err := json.Marshal(clause)
+		if err != nil {
+			return filter
+		}
+
+		key := string(b)
+		if _, seen := byJSON[key]; seen {
+			continue
+		}
+
+		byJSON[key] = clause
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	canonical := make(Filter, len(keys))
+	for i, key := range keys {
+		canonical[i] = byJSON[key]
+	}
+
+	return canonical
+}
+
+// canonicalRange returns the given filter clause with any timestamp range in
+// it resolved to absolute RFC3339 bounds, so that eg. the same range given as
+// dates, epoch milliseconds or date math has the same form. Ranges relative to
+// "now" therefore resolve differently as time passes, so can't share results
+// that have become stale. Clauses with invalid ranges are returned as is.
+func canonicalRange(clause map[string]MapStringStringOrMap) map[string]MapStringStringOrMap {
+	fRange, ok := clause["range"]
+	if !ok || len(fRange.getMap("timestamp")) == 0 {
+		return clause
+	}
+
+	tr, err := (&Query{Query: &QueryFilter{Bool: QFBool{Filter: Filter{clause}}}}).TimeRange()
+	if err != nil {
+		return clause
+	}
+
+	bounds := make(map[string]interface{})
+
+	for op, t := range map[string]time.Time{"gt": tr.GT, "gte": tr.GTE, "lt": tr.LT, "lte": tr.LTE} {
+		if !t.IsZero() {
+			bounds[op] = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	canonicalRange := make(MapStringStringOrMap, len(fRange))
+	for k, v := range fRange {
+		canonicalRange[k] = v
+	}
+
+	canonicalRange["timestamp"] = bounds
+
+	canonical := make(map[string]MapStringStringOrMap, len(clause))
+	for k, v := range clause {
+		canonical[k] = v
+	}
+
+	canonical["range"] = canonicalRange
+
+	return canonical
+}
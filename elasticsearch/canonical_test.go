@@ -0,0 +1,91 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKey(t *testing.T) {
+	Convey("Equivalent queries have the same Key()", t, func() {
+		parse := func(body string) *Query {
+			query, err := newQueryFromReader(strings.NewReader(body))
+			So(err, ShouldBeNil)
+
+			return query
+		}
+
+		base := parse(`{"size":0,"query":{"bool":{"filter":[
+			{"match_phrase":{"BOM":"Human Genetics"}},
+			{"range":{"timestamp":{"gte":"2024-02-04T00:00:00Z","lt":"2024-02-05T00:00:00Z"}}}
+		]}}}`)
+
+		for _, body := range []string{
+			`{"size":0,"query":{"bool":{"filter":[{"range":{"timestamp":` +
+				`{"lt":"2024-02-05T00:00:00Z","gte":"2024-02-04T00:00:00Z"}}},{"match_phrase":{"BOM":"Human Genetics"}}]}}}`,
+			`{"size":0,"query":{"bool":{"filter":[{"match_phrase":{"BOM":"Human Genetics"}},` +
+				`{"match_phrase":{"BOM":"Human Genetics"}},` +
+				`{"range":{"timestamp":{"gte":1707004800000,"lt":"2024-02-05"}}}]}}}`,
+			`{"size":0,"query":{"bool":{"filter":[{"match_phrase":{"BOM":"Human Genetics"}},` +
+				`{"range":{"timestamp":{"gte":"2024-02-04","lt":"2024-02-05","format":"yyyy-MM-dd"}}}]}}}`,
+		} {
+			So(parse(body).Key(), ShouldEqual, base.Key())
+		}
+
+		So(base.Query.Bool.Filter[0], ShouldContainKey, "match_phrase")
+		So(base.Query.Bool.Filter[1]["range"].getMap("timestamp")["gte"], ShouldEqual, "2024-02-04T00:00:00Z")
+
+		Convey("But queries that could have different results don't", func() {
+			for _, body := range []string{
+				`{"size":1,"query":{"bool":{"filter":[{"match_phrase":{"BOM":"Human Genetics"}},` +
+					`{"range":{"timestamp":{"gte":"2024-02-04T00:00:00Z","lt":"2024-02-05T00:00:00Z"}}}]}}}`,
+				`{"size":0,"query":{"bool":{"filter":[{"match_phrase":{"BOM":"Human Genetics"}},` +
+					`{"range":{"timestamp":{"gte":"2024-02-04T00:00:00Z","lte":"2024-02-05T00:00:00Z"}}}]}}}`,
+				`{"size":0,"query":{"bool":{"filter":[{"match_phrase":{"BOM":"Other"}},` +
+					`{"range":{"timestamp":{"gte":"2024-02-04T00:00:00Z","lt":"2024-02-05T00:00:00Z"}}}]}}}`,
+			} {
+				So(parse(body).Key(), ShouldNotEqual, base.Key())
+			}
+		})
+
+		Convey("Relative ranges resolve to the current time", func() {
+			defer func() { now = time.Now }()
+
+			now = func() time.Time { return time.Date(2024, 2, 5, 12, 0, 0, 0, time.UTC) }
+
+			relative := parse(`{"size":0,"query":{"bool":{"filter":[{"match_phrase":{"BOM":"Human Genetics"}},` +
+				`{"range":{"timestamp":{"gte":"now-1d/d","lt":"now/d"}}}]}}}`)
+			So(relative.Key(), ShouldEqual, base.Key())
+
+			now = func() time.Time { return time.Date(2024, 2, 6, 12, 0, 0, 0, time.UTC) }
+			So(relative.Key(), ShouldNotEqual, base.Key())
+		})
+	})
+}
@@ -28,9 +28,11 @@ package elasticsearch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	es "github.com/elastic/go-elasticsearch/v7"
@@ -38,41 +40,171 @@ import (
 
 const scrollTime = 1 * time.Minute
 
-// Config allows you to specify your Elastic Search server details. Currently
-// only basic auth is supported, for an internal network server with "public"
-// access.
+// Config allows you to specify your Elastic Search server details. Supply a
+// Username and Password for basic auth, or an APIKey (the base64 encoding of
+// "id:api_key") or ServiceToken instead. For an https Scheme, the server's
+// certificate is verified using the system's certificate authorities plus any
+// in the PEM CACert file, unless InsecureSkipVerify is true. If the server
+// needs client certificates, supply PEM ClientCert and ClientKey files.
+//
+// To fail over between several servers (eg. the coordinating nodes of a
+// cluster), give their URLs as Addresses instead of a Host, Scheme and Port.
+// Requests are spread across them, and a server that fails to respond is
+// skipped (and any retries go to another one) until it has had some time to
+// recover.
+//
+// RequestsPerSecond defaults to 0, meaning unlimited. Otherwise, requests to
+// the server are spaced out so that no more than this many are made a second,
+// eg. so that long backfills don't overload a shared server.
 type Config struct {
-	Host      string
-	Username  string
-	Password  string
-	Scheme    string
-	Port      int
-	Index     string
+	Host              string
+	Username          string
+	Password          string
+	Scheme            string
+	Port              int
+	Addresses         []string
+	Index             string
+	RequestsPerSecond float64
+	// ScrollSlices, if greater than 1, makes Scroll() split its queries in to
+	// this many slices that are scrolled concurrently, which can be several
+	// times faster for queries with many hits.
+	ScrollSlices int
+	// UsePIT makes Scroll() page through hits using a point-in-time and
+	// search_after (needs elastic search 7.10+) instead of the scroll API.
+	UsePIT bool
+	// Flavor is the kind of server we talk to: FlavorElasticsearch7 (the
+	// default), FlavorElasticsearch8 or FlavorOpenSearch.
+	Flavor string
+
+	APIKey             string
+	ServiceToken       string
+	CACert             string
+	InsecureSkipVerify bool
+	ClientCert         string
+	ClientKey          string
+
+	// RequestTimeout, if non-zero, is how long each request to the server may
+	// take before it is abandoned (and possibly retried).
+	RequestTimeout time.Duration
+	// MaxRetries is how many times a request that times out or fails with a
+	// 5xx or 429 status is retried; 0 means 3, and -1 means no retries.
+	MaxRetries int
+	// RetryBackoff is how long to wait before the first retry of a request,
+	// doubling for each subsequent retry. 0 means retry immediately.
+	RetryBackoff time.Duration
+	// BreakerThreshold, if non-zero, is how many consecutive requests must fail
+	// before we stop making requests for BreakerCooldown (default 30s), failing
+	// them immediately with an error that IsUnavailable() instead.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
 	transport http.RoundTripper
 }
 
 // Client is used to interact with an Elastic Search server.
 type Client struct {
-	index  string
-	client *es.Client
-	Error  error
+	index         string
+	client        atomic.Pointer[es.Client]
+	unknownFields *UnknownFields
+	scrollSlices  int
+	usePIT        bool
+	metrics       *ClientMetrics
+	Error         error
 }
 
 // NewClient returns a Client that can talk to the configured Elastic Search
 // server and will use the configured index for queries.
 func NewClient(config Config) (*Client, error) {
+	client, err := newESClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		index:        config.Index,
+		scrollSlices: config.ScrollSlices,
+		usePIT:       config.UsePIT,
+		metrics:      newClientMetrics(),
+	}
+
+	c.client.Store(client)
+
+	return c, nil
+}
+
+// Reconfigure makes subsequent requests use the address, credentials, TLS and
+// transport settings of the given Config, eg. to rotate credentials without
+// downtime. Requests already in progress are unaffected. Our index,
+// ScrollSlices, UsePIT, metrics and any WatchForUnknownFields() stay the same.
+//
+// If the Config is invalid, returns an error and we continue as before.
+func (c *Client) Reconfigure(config Config) error {
+	client, err := newESClient(config)
+	if err != nil {
+		return err
+	}
+
+	c.client.Store(client)
+
+	return nil
+}
+
+// newESClient returns an elasticsearch client for the given Config.
+func newESClient(config Config) (*es.Client, error) {
+	transport, err := baseTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.RequestTimeout > 0 {
+		transport = newTimeoutTransport(transport, config.RequestTimeout)
+	}
+
+	if config.RequestsPerSecond > 0 {
+		transport = newRateLimitedTransport(transport, config.RequestsPerSecond)
+	}
+
+	compatible, transport, err := flavorSettings(config, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BreakerThreshold > 0 {
+		transport = newCircuitBreakerTransport(transport, config.BreakerThreshold, config.BreakerCooldown)
+	}
+
 	cfg := es.Config{
-		Addresses: []string{
-			fmt.Sprintf("%s://%s:%d", config.Scheme, config.Host, config.Port),
-		},
-		Username:  config.Username,
-		Password:  config.Password,
-		Transport: config.transport,
+		Addresses:    config.URLs(),
+		Username:     config.Username,
+		Password:     config.Password,
+		APIKey:       config.APIKey,
+		ServiceToken: config.ServiceToken,
+		Transport:    transport,
+
+		EnableCompatibilityMode: compatible,
 	}
 
-	client, err := es.NewClient(cfg)
+	retrySettings(&cfg, config)
 
-	return &Client{client: client, index: config.Index}, err
+	return es.NewClient(cfg)
+}
+
+// URLs returns our Addresses, or if none, the URL of our Scheme, Host and Port.
+func (c Config) URLs() []string {
+	if len(c.Addresses) > 0 {
+		return c.Addresses
+	}
+
+	return []string{fmt.Sprintf("%s://%s:%d", c.Scheme, c.Host, c.Port)}
+}
+
+// WatchForUnknownFields turns on a strict schema mode, where the hits of all
+// subsequent searches and scrolls are inspected for _source fields that
+// Details doesn't know about. The returned UnknownFields records them.
+func (c *Client) WatchForUnknownFields() *UnknownFields {
+	c.unknownFields = NewUnknownFields()
+
+	return c.unknownFields
 }
 
 // ElasticInfo is the type returned by an Info() request. It just tells you the
@@ -85,7 +217,9 @@ type ElasticInfo struct {
 
 // Info tells you the version number info of the server.
 func (c *Client) Info() (*ElasticInfo, error) {
-	resp, err := c.client.Info()
+	client := c.client.Load()
+
+	resp, err := client.Info()
 	if err != nil {
 		return nil, err
 	}
@@ -101,56 +235,153 @@ func (c *Client) Info() (*ElasticInfo, error) {
 // results. If there are more than 10,000 hits, you won't get them (use Scroll
 // instead).
 func (c *Client) Search(query *Query) (*Result, error) {
+	start := time.Now()
+
+	result, err := c.search(query)
+	c.metrics.observe(OpSearch, start, err)
+
+	return result, err
+}
+
+// search is Search() without the metrics.
+func (c *Client) search(query *Query) (*Result, error) {
+	client := c.client.Load()
+
 	qbody, err := query.asBody()
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.client.Search(
-		c.client.Search.WithIndex(c.index),
-		c.client.Search.WithBody(qbody),
+	resp, err := client.Search(
+		client.Search.WithContext(query.Context()),
+		client.Search.WithIndex(c.index),
+		client.Search.WithBody(qbody),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	result, _, err := parseResultResponse(resp, nil)
+	result, _, err := parseResultResponse(resp, nil, c.unknownFields)
 
 	return result, err
 }
 
+// Count uses our index and the query part of the given query to get the number
+// of matching hits from elasticsearch's _count API, without retrieving them.
+func (c *Client) Count(query *Query) (int, error) {
+	start := time.Now()
+
+	count, err := c.count(query)
+	c.metrics.observe(OpCount, start, err)
+
+	return count, err
+}
+
+// count is Count() without the metrics.
+func (c *Client) count(query *Query) (int, error) {
+	client := c.client.Load()
+
+	body, err := json.Marshal(map[string]*QueryFilter{"query": query.Query})
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Count(
+		client.Count.WithContext(query.Context()),
+		client.Count.WithIndex(c.index),
+		client.Count.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		return 0, Error{Msg: ErrFailedQuery, cause: resp.String()}
+	}
+
+	var count struct {
+		Count int `json:"count"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&count)
+
+	return count.Count, err
+}
+
 // Scroll uses our index and the given query to get back your desired search
 // results. It auto-scrolls and returns all your hits via the given callback,
 // and everything else in the returned Result.
+//
+// If we were configured with ScrollSlices and you supply a callback, the
+// query is split in to that many slices that are scrolled concurrently; see
+// slicedScroll(). If we were configured with UsePIT, hits are paged through
+// with searchAfterAll() instead of the scroll API.
 func (c *Client) Scroll(query *Query, cb HitsCallBack) (*Result, error) {
+	start := time.Now()
+
+	var (
+		result *Result
+		err    error
+	)
+
+	if c.scrollSlices > 1 && cb != nil && query.Slice == nil {
+		result, err = c.slicedScroll(query, cb)
+	} else {
+		result, err = c.fetchAll(query, cb)
+	}
+
+	c.metrics.observe(OpScroll, start, err)
+
+	return result, err
+}
+
+// fetchAll gets all the hits of the given query using searchAfterAll() if we
+// were configured with UsePIT, or scrollAll() otherwise.
+func (c *Client) fetchAll(query *Query, cb HitsCallBack) (*Result, error) {
+	if c.usePIT {
+		return c.searchAfterAll(query, cb)
+	}
+
+	return c.scrollAll(query, cb)
+}
+
+// scrollAll is Scroll() without slicing.
+func (c *Client) scrollAll(query *Query, cb HitsCallBack) (*Result, error) {
+	client := c.client.Load()
+
 	qbody, err := query.asBody()
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.client.Search(
-		c.client.Search.WithIndex(c.index),
-		c.client.Search.WithBody(qbody),
-		c.client.Search.WithSize(MaxSize),
-		c.client.Search.WithScroll(scrollTime),
+	resp, err := client.Search(
+		client.Search.WithContext(query.Context()),
+		client.Search.WithIndex(c.index),
+		client.Search.WithBody(qbody),
+		client.Search.WithSize(MaxSize),
+		client.Search.WithScroll(scrollTime),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	result, n, err := parseResultResponse(resp, cb)
+	result, n, err := parseResultResponse(resp, cb, c.unknownFields)
 	if err != nil {
 		return nil, err
 	}
 
 	defer c.scrollCleanup(result)
 
-	err = c.scrollUntilAllHitsReceived(result, n, cb)
+	err = c.scrollUntilAllHitsReceived(query.Context(), result, n, cb)
 
 	return result, err
 }
 
 func (c *Client) scrollCleanup(result *Result) {
+	client := c.client.Load()
+
 	scrollIDBody, err := scrollIDBody(result.ScrollID)
 	if err != nil {
 		c.Error = err
@@ -158,7 +389,7 @@ func (c *Client) scrollCleanup(result *Result) {
 		return
 	}
 
-	_, err = c.client.ClearScroll(c.client.ClearScroll.WithBody(scrollIDBody))
+	_, err = client.ClearScroll(client.ClearScroll.WithBody(scrollIDBody))
 	if err != nil {
 		c.Error = err
 	}
@@ -173,14 +404,15 @@ func scrollIDBody(scrollID string) (*bytes.Buffer, error) {
 	return bytes.NewBuffer(scrollBytes), nil
 }
 
-func (c *Client) scrollUntilAllHitsReceived(result *Result, previousNumHits int, cb HitsCallBack) error {
+func (c *Client) scrollUntilAllHitsReceived(ctx context.Context, result *Result,
+	previousNumHits int, cb HitsCallBack) error {
 	total := result.HitSet.Total.Value
 	if total <= MaxSize {
 		return nil
 	}
 
 	for keepScrolling := true; keepScrolling; keepScrolling = previousNumHits < total {
-		n, err := c.scroll(result, cb)
+		n, err := c.scroll(ctx, result, cb)
 		if err != nil {
 			return err
 		}
@@ -195,21 +427,24 @@ func (c *Client) scrollUntilAllHitsReceived(result *Result, previousNumHits int,
 	return nil
 }
 
-func (c *Client) scroll(result *Result, cb HitsCallBack) (int, error) {
+func (c *Client) scroll(ctx context.Context, result *Result, cb HitsCallBack) (int, error) {
+	client := c.client.Load()
+
 	scrollIDBody, err := scrollIDBody(result.ScrollID)
 	if err != nil {
 		return 0, err
 	}
 
-	resp, err := c.client.Scroll(
-		c.client.Scroll.WithBody(scrollIDBody),
-		c.client.Scroll.WithScroll(scrollTime),
+	resp, err := client.Scroll(
+		client.Scroll.WithContext(ctx),
+		client.Scroll.WithBody(scrollIDBody),
+		client.Scroll.WithScroll(scrollTime),
 	)
 	if err != nil {
 		return 0, err
 	}
 
-	scrollResult, n, err := parseResultResponse(resp, cb)
+	scrollResult, n, err := parseResultResponse(resp, cb, c.unknownFields)
 	if err != nil {
 		return 0, err
 	}
@@ -27,9 +27,13 @@
 package elasticsearch
 
 import (
+	"errors"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -50,6 +54,162 @@ func TestElasticSearchClientMock(t *testing.T) {
 		}
 
 		doClientTests(t, config, 2)
+
+		Convey("You can watch for unknown fields in hits", func() {
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			query, err := newQueryFromReader(strings.NewReader(testNonAggQuery))
+			So(err, ShouldBeNil)
+
+			query.Size = MaxSize
+
+			unknown := client.WatchForUnknownFields()
+			So(unknown.Fields(), ShouldBeEmpty)
+
+			_, err = client.Search(query)
+			So(err, ShouldBeNil)
+
+			_, err = client.Search(query)
+			So(err, ShouldBeNil)
+
+			So(unknown.Fields(), ShouldResemble, []UnknownField{
+				{Name: "META_CLUSTER_NAME", Count: 2, Sample: `"farm"`},
+			})
+
+			err = unknown.Inspect([]byte(`{"hits": {"hits": [{"_source": {"USER_NAME": "u", "ZZZ": "` +
+				strings.Repeat("a", maxUnknownFieldSampleLength*2) + `"}}]}}`))
+			So(err, ShouldBeNil)
+
+			fields := unknown.Fields()
+			So(len(fields), ShouldEqual, 2)
+			So(fields[1].Name, ShouldEqual, "ZZZ")
+			So(fields[1].Count, ShouldEqual, 1)
+			So(len(fields[1].Sample), ShouldEqual, maxUnknownFieldSampleLength)
+
+			So(unknown.Inspect([]byte(`{`)), ShouldNotBeNil)
+		})
+	})
+}
+
+// authRecordingTransport is a mockTransport that records the Authorization
+// header of the last request.
+type authRecordingTransport struct {
+	mockTransport
+	auth string
+}
+
+func (a *authRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	a.auth = req.Header.Get("Authorization")
+
+	return a.mockTransport.RoundTrip(req)
+}
+
+func TestReconfigure(t *testing.T) {
+	Convey("Given a client with some credentials", t, func() {
+		transport := &authRecordingTransport{}
+		config := Config{
+			Host:      "mock",
+			Username:  "user",
+			Password:  "old",
+			Scheme:    "http",
+			Port:      1234,
+			Index:     "mock-*",
+			transport: transport,
+		}
+
+		client, err := NewClient(config)
+		So(err, ShouldBeNil)
+
+		_, err = client.Info()
+		So(err, ShouldBeNil)
+
+		oldAuth := transport.auth
+		So(oldAuth, ShouldStartWith, "Basic ")
+
+		metrics := client.Metrics()
+
+		Convey("You can Reconfigure it to use new credentials", func() {
+			config.Password = "new"
+			So(client.Reconfigure(config), ShouldBeNil)
+
+			_, err = client.Info()
+			So(err, ShouldBeNil)
+			So(transport.auth, ShouldStartWith, "Basic ")
+			So(transport.auth, ShouldNotEqual, oldAuth)
+			So(client.Metrics(), ShouldEqual, metrics)
+		})
+
+		Convey("An invalid Config leaves it unchanged", func() {
+			config.Password = "new"
+			config.Flavor = "unknown"
+			So(client.Reconfigure(config), ShouldNotBeNil)
+
+			_, err = client.Info()
+			So(err, ShouldBeNil)
+			So(transport.auth, ShouldEqual, oldAuth)
+		})
+	})
+}
+
+// downHostTransport is a mockTransport that fails to connect to the "down"
+// host, and records the hosts of requests.
+type downHostTransport struct {
+	mockTransport
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (d *downHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	d.hosts = append(d.hosts, req.URL.Host)
+	d.mu.Unlock()
+
+	if req.URL.Hostname() == "down" {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+
+	return d.mockTransport.RoundTrip(req)
+}
+
+func TestFailover(t *testing.T) {
+	Convey("Given a client with multiple Addresses, one of which is down", t, func() {
+		transport := &downHostTransport{}
+
+		client, err := NewClient(Config{
+			Addresses: []string{"http://down:1234", "http://up:1234"},
+			Index:     "mock-*",
+			transport: transport,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Requests fail over to the server that is up", func() {
+			for range 3 {
+				_, err = client.Info()
+				So(err, ShouldBeNil)
+			}
+
+			So(transport.hosts, ShouldContain, "up:1234")
+			So(transport.hosts, ShouldContain, "down:1234")
+
+			downs := 0
+
+			for _, host := range transport.hosts {
+				if host == "down:1234" {
+					downs++
+				}
+			}
+
+			So(downs, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Config URLs() prefers Addresses over Host", t, func() {
+		config := Config{Scheme: "https", Host: "a", Port: 9200}
+		So(config.URLs(), ShouldResemble, []string{"https://a:9200"})
+
+		config.Addresses = []string{"http://b:1", "http://c:2"}
+		So(config.URLs(), ShouldResemble, []string{"http://b:1", "http://c:2"})
 	})
 }
 
@@ -96,6 +256,12 @@ func doClientTests(t *testing.T, config Config, expectedNumHits int) {
 				So(result.HitSet.Total.Value, ShouldEqual, expectedNumHits)
 			})
 
+			Convey("You can do a Count", func() {
+				count, err := client.Count(query)
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 2)
+			})
+
 			Convey("You can do a Scroll which always returns all hits", func() {
 				hitsReceieved := 0
 				cb := func(hit *Hit) {
@@ -0,0 +1,354 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ErrBadDate = "invalid date"
+
+	// defaultDateFormat is the format elasticsearch uses for date fields that
+	// don't specify one, and so for range bounds without a format hint.
+	defaultDateFormat = "strict_date_optional_time||epoch_millis"
+
+	dateMathNow       = "now"
+	dateMathSeparator = "||"
+	formatSeparator   = "||"
+	formatEpochMillis = "epoch_millis"
+	formatEpochSecond = "epoch_second"
+	millisPerSecond   = 1000
+	daysInWeek        = 7
+)
+
+// now is used to resolve "now" in date math.
+var now = time.Now //nolint:gochecknoglobals
+
+// dateLayout is a time.Parse() layout, along with the unit of its least
+// significant component, which is the unit that values parsed with it are
+// rounded up to when they're used as an lte bound. Blank units don't round.
+type dateLayout struct {
+	layout string
+	unit   byte
+}
+
+// optionalTimeLayouts are the layouts of elasticsearch's
+// strict_date_optional_time format, most specific first. Values without a
+// time zone are in UTC.
+var optionalTimeLayouts = []dateLayout{ //nolint:gochecknoglobals
+	{layout: time.RFC3339Nano},
+	{layout: "2006-01-02T15:04:05.999999999"},
+	{layout: "2006-01-02T15:04", unit: 'm'},
+	{layout: "2006-01-02T15", unit: 'h'},
+	{layout: "2006-01-02", unit: 'd'},
+	{layout: "2006-01", unit: 'M'},
+	{layout: "2006", unit: 'y'},
+}
+
+// namedDateLayouts are the layouts of the other elasticsearch date formats we
+// understand.
+var namedDateLayouts = map[string][]dateLayout{ //nolint:gochecknoglobals
+	"strict_date_time":                {{layout: time.RFC3339Nano}},
+	"date_time":                       {{layout: time.RFC3339Nano}},
+	"strict_date_time_no_millis":      {{layout: time.RFC3339}},
+	"date_time_no_millis":             {{layout: time.RFC3339}},
+	"strict_date_hour_minute":         {{layout: "2006-01-02T15:04", unit: 'm'}},
+	"strict_date_hour":                {{layout: "2006-01-02T15", unit: 'h'}},
+	"strict_date":                     {{layout: "2006-01-02", unit: 'd'}},
+	"date":                            {{layout: "2006-01-02", unit: 'd'}},
+	"yyyy-MM-dd":                      {{layout: "2006-01-02", unit: 'd'}},
+	"strict_year_month":               {{layout: "2006-01", unit: 'M'}},
+	"yyyy-MM":                         {{layout: "2006-01", unit: 'M'}},
+	"strict_year":                     {{layout: "2006", unit: 'y'}},
+	"yyyy":                            {{layout: "2006", unit: 'y'}},
+	"strict_date_optional_time":       optionalTimeLayouts,
+	"date_optional_time":              optionalTimeLayouts,
+	"strict_date_optional_time_nanos": optionalTimeLayouts,
+}
+
+// rangeBoundSet returns true if the given range bound value was supplied.
+func rangeBoundSet(val interface{}) bool {
+	if val == nil {
+		return false
+	}
+
+	str, ok := val.(string)
+
+	return !ok || str != ""
+}
+
+// parseRangeBound parses a range bound value the way elasticsearch would for a
+// date field: val can be a string in one of the given "||" separated formats
+// (defaulting to strict_date_optional_time||epoch_millis), date math like
+// "now-30d/d" or "2024-05-01||+1M", or a number of epoch milliseconds (or
+// seconds, if that is the format).
+//
+// Like elasticsearch, roundUp (which should be true for lte bounds) makes
+// rounding in date math, and components missing from dates, round up to the
+// last millisecond of the unit instead of down to the first.
+func parseRangeBound(val interface{}, format string, roundUp bool) (time.Time, error) {
+	formats := strings.Split(format, formatSeparator)
+	if format == "" {
+		formats = strings.Split(defaultDateFormat, formatSeparator)
+	}
+
+	switch v := val.(type) {
+	case string:
+		return parseDateString(v, formats, roundUp)
+	case float64:
+		return epochTime(v, formats), nil
+	case int:
+		return epochTime(float64(v), formats), nil
+	case int64:
+		return epochTime(float64(v), formats), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, Error{Msg: ErrBadDate, cause: v.String()}
+		}
+
+		return epochTime(f, formats), nil
+	}
+
+	return time.Time{}, Error{Msg: ErrBadDate, cause: fmt.Sprintf("%v", val)}
+}
+
+// epochTime converts the given number of epoch milliseconds to a time, or
+// seconds if epoch_second comes before any epoch_millis in the given formats.
+func epochTime(epoch float64, formats []string) time.Time {
+	for _, format := range formats {
+		switch format {
+		case formatEpochMillis:
+			return time.UnixMilli(int64(epoch)).UTC()
+		case formatEpochSecond:
+			return time.UnixMilli(int64(epoch * millisPerSecond)).UTC()
+		}
+	}
+
+	return time.UnixMilli(int64(epoch)).UTC()
+}
+
+// parseDateString parses a date string in one of the given formats, which may
+// be anchored date math.
+func parseDateString(s string, formats []string, roundUp bool) (time.Time, error) {
+	if math, ok := strings.CutPrefix(s, dateMathNow); ok {
+		return applyDateMath(now().UTC(), math, roundUp)
+	}
+
+	anchor, math, _ := strings.Cut(s, dateMathSeparator)
+
+	t, unit, err := parseDate(anchor, formats)
+	if err != nil {
+		return t, err
+	}
+
+	if roundUp && unit != 0 {
+		t = roundDate(t.UTC(), unit, true)
+	}
+
+	if math == "" {
+		return t, nil
+	}
+
+	return applyDateMath(t.UTC(), math, roundUp)
+}
+
+// parseDate parses a date string in the first of the given formats that it
+// matches, also returning the unit of the least significant component it had.
+// Formats we don't understand are ignored, and if we understand none of them,
+// the default format is used.
+func parseDate(s string, formats []string) (time.Time, byte, error) {
+	understood := false
+
+	for _, format := range formats {
+		t, unit, known, ok := parseDateInFormat(s, format)
+		if ok {
+			return t, unit, nil
+		}
+
+		understood = understood || known
+	}
+
+	if !understood {
+		return parseDate(s, strings.Split(defaultDateFormat, formatSeparator))
+	}
+
+	return time.Time{}, 0, Error{Msg: ErrBadDate, cause: s}
+}
+
+// parseDateInFormat tries to parse a date string in the given format. known is
+// false if we don't understand the format, and ok is true if the string was
+// parsed.
+func parseDateInFormat(s, format string) (t time.Time, unit byte, known, ok bool) {
+	switch format {
+	case formatEpochMillis, formatEpochSecond:
+		epoch, err := strconv.ParseFloat(s, 64)
+		if err != nil || strings.ContainsAny(s, "eEnNiI") {
+			return t, 0, true, false
+		}
+
+		return epochTime(epoch, []string{format}), 0, true, true
+	}
+
+	layouts, known := namedDateLayouts[format]
+	for _, dl := range layouts {
+		t, err := time.Parse(dl.layout, s)
+		if err == nil {
+			return t, dl.unit, true, true
+		}
+	}
+
+	return t, 0, known, false
+}
+
+// applyDateMath applies elasticsearch date math operations like "+1d", "-2h"
+// and "/M" to the given time.
+func applyDateMath(t time.Time, math string, roundUp bool) (time.Time, error) {
+	rest := math
+
+	for rest != "" {
+		op := rest[0]
+		rest = rest[1:]
+
+		if op == '/' {
+			if rest == "" || !validDateMathUnit(rest[0]) {
+				return t, Error{Msg: ErrBadDate, cause: "bad date math: " + math}
+			}
+
+			t = roundDate(t, rest[0], roundUp)
+			rest = rest[1:]
+
+			continue
+		}
+
+		if op != '+' && op != '-' {
+			return t, Error{Msg: ErrBadDate, cause: "bad date math: " + math}
+		}
+
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+
+		n := 1
+
+		if digits > 0 {
+			var err error
+
+			n, err = strconv.Atoi(rest[:digits])
+			if err != nil {
+				return t, Error{Msg: ErrBadDate, cause: "bad date math: " + math}
+			}
+		}
+
+		if digits == len(rest) || !validDateMathUnit(rest[digits]) {
+			return t, Error{Msg: ErrBadDate, cause: "bad date math: " + math}
+		}
+
+		if op == '-' {
+			n = -n
+		}
+
+		t = addDateUnits(t, n, rest[digits])
+		rest = rest[digits+1:]
+	}
+
+	return t, nil
+}
+
+// validDateMathUnit returns true if the given byte is one of elasticsearch's
+// date math units.
+func validDateMathUnit(unit byte) bool {
+	return strings.IndexByte("yMwdhHms", unit) != -1
+}
+
+// addDateUnits adds n of the given date math unit to the given time. Adding
+// months or years to the end of a month stays in the resulting month.
+func addDateUnits(t time.Time, n int, unit byte) time.Time {
+	switch unit {
+	case 'y':
+		return addMonths(t, n*12) //nolint:mnd
+	case 'M':
+		return addMonths(t, n)
+	case 'w':
+		return t.AddDate(0, 0, n*daysInWeek)
+	case 'd':
+		return t.AddDate(0, 0, n)
+	case 'h', 'H':
+		return t.Add(time.Duration(n) * time.Hour)
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute)
+	default:
+		return t.Add(time.Duration(n) * time.Second)
+	}
+}
+
+// addMonths adds n months to the given time, clamping the day to the last day
+// of the resulting month.
+func addMonths(t time.Time, n int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+
+	return first.AddDate(0, 0, min(day, lastDay)-1)
+}
+
+// roundDate rounds the given time down to the start of the given date math
+// unit, or if roundUp, up to the last millisecond of it. Weeks start on
+// Monday.
+func roundDate(t time.Time, unit byte, roundUp bool) time.Time {
+	year, month, day := t.Date()
+
+	var start time.Time
+
+	switch unit {
+	case 'y':
+		start = time.Date(year, time.January, 1, 0, 0, 0, 0, t.Location())
+	case 'M':
+		start = time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case 'w':
+		start = time.Date(year, month, day-(int(t.Weekday())+daysInWeek-1)%daysInWeek, 0, 0, 0, 0, t.Location())
+	case 'd':
+		start = time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case 'h', 'H':
+		start = t.Truncate(time.Hour)
+	case 'm':
+		start = t.Truncate(time.Minute)
+	default:
+		start = t.Truncate(time.Second)
+	}
+
+	if !roundUp {
+		return start
+	}
+
+	return addDateUnits(start, 1, unit).Add(-time.Millisecond)
+}
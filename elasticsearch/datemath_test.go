@@ -0,0 +1,134 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDateMath(t *testing.T) {
+	Convey("Given a fixed now", t, func() {
+		origNow := now
+		now = func() time.Time { return time.Date(2024, 5, 15, 13, 45, 30, 0, time.UTC) }
+
+		defer func() { now = origNow }()
+
+		parse := func(val interface{}, format string, roundUp bool) string {
+			t, err := parseRangeBound(val, format, roundUp)
+			So(err, ShouldBeNil)
+
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+
+		Convey("you can parse date math relative to now", func() {
+			So(parse("now", "", false), ShouldEqual, "2024-05-15T13:45:30Z")
+			So(parse("now-30d", "", false), ShouldEqual, "2024-04-15T13:45:30Z")
+			So(parse("now-30d/d", "", false), ShouldEqual, "2024-04-15T00:00:00Z")
+			So(parse("now/d", "", true), ShouldEqual, "2024-05-15T23:59:59.999Z")
+			So(parse("now+1h/h", "", false), ShouldEqual, "2024-05-15T14:00:00Z")
+			So(parse("now-1M/M", "", false), ShouldEqual, "2024-04-01T00:00:00Z")
+			So(parse("now/w", "", false), ShouldEqual, "2024-05-13T00:00:00Z")
+			So(parse("now/y", "", true), ShouldEqual, "2024-12-31T23:59:59.999Z")
+			So(parse("now-1y-2d+3m-4s", "", false), ShouldEqual, "2023-05-13T13:48:26Z")
+			So(parse("now-d", "", false), ShouldEqual, "2024-05-14T13:45:30Z")
+		})
+
+		Convey("you can parse date math anchored on a date", func() {
+			So(parse("2024-01-31||+1M", "", false), ShouldEqual, "2024-02-29T00:00:00Z")
+			So(parse("2024-05-04T10:00:00Z||/d", "", false), ShouldEqual, "2024-05-04T00:00:00Z")
+			So(parse("2024-05-04||+1d", "", true), ShouldEqual, "2024-05-05T23:59:59.999Z")
+		})
+
+		Convey("dates missing components are rounded up for lte", func() {
+			So(parse("2024-05-04", "", false), ShouldEqual, "2024-05-04T00:00:00Z")
+			So(parse("2024-05-04", "", true), ShouldEqual, "2024-05-04T23:59:59.999Z")
+			So(parse("2024-05", "", true), ShouldEqual, "2024-05-31T23:59:59.999Z")
+			So(parse("2024-05-04T00:10:00Z", "", true), ShouldEqual, "2024-05-04T00:10:00Z")
+		})
+
+		Convey("you can give epoch millis, or seconds with the format hint", func() {
+			So(parse(float64(1714781400000), "", false), ShouldEqual, "2024-05-04T00:10:00Z")
+			So(parse(json.Number("1714781400000"), "", false), ShouldEqual, "2024-05-04T00:10:00Z")
+			So(parse("1714781400000", "", false), ShouldEqual, "2024-05-04T00:10:00Z")
+			So(parse(int64(1714781400), "epoch_second", false), ShouldEqual, "2024-05-04T00:10:00Z")
+			So(parse("1714781400", "epoch_second", false), ShouldEqual, "2024-05-04T00:10:00Z")
+			So(parse("1714781400", "yyyy-MM-dd||epoch_second", false), ShouldEqual, "2024-05-04T00:10:00Z")
+		})
+
+		Convey("the format hint limits the formats accepted", func() {
+			So(parse("2024-05-04", "yyyy-MM-dd", false), ShouldEqual, "2024-05-04T00:00:00Z")
+			So(parse("2024-05-04T00:10:00Z", "strict_date_optional_time", false), ShouldEqual, "2024-05-04T00:10:00Z")
+			So(parse("2024-05-04T00:10:00Z", "dd/MM/yyyy", false), ShouldEqual, "2024-05-04T00:10:00Z")
+
+			_, err := parseRangeBound("2024-05-04T00:10:00Z", "yyyy-MM-dd", false)
+			So(err, ShouldNotBeNil)
+
+			_, err = parseRangeBound("2024-05-04", "epoch_millis", false)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("invalid dates and date math are errors", func() {
+			for _, bad := range []interface{}{"now-", "now-3", "now-3x", "now/", "now*2d", "yesterday", "2024-05-04||+", true} {
+				_, err := parseRangeBound(bad, "", false)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, ErrBadDate)
+			}
+		})
+
+		Convey("Query DateRange() understands them", func() {
+			query, err := newQueryFromReader(strings.NewReader(
+				`{"query":{"bool":{"filter":[{"range":{"timestamp":{"lte":"now/d","gte":"now-7d/d"}}}]}}}`))
+			So(err, ShouldBeNil)
+
+			lt, lte, gte, err := query.DateRange()
+			So(err, ShouldBeNil)
+			So(lt.IsZero(), ShouldBeTrue)
+			So(lte, ShouldEqual, time.Date(2024, 5, 15, 23, 59, 59, int(999*time.Millisecond), time.UTC))
+			So(gte, ShouldEqual, time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC))
+
+			query, err = newQueryFromReader(strings.NewReader(
+				`{"query":{"bool":{"filter":[{"range":{"timestamp":{"lt":1714781400000,"gte":1714780800000,"format":"epoch_millis"}}}]}}}`)) //nolint:lll
+			So(err, ShouldBeNil)
+
+			lt, _, gte, err = query.DateRange()
+			So(err, ShouldBeNil)
+			So(lt, ShouldEqual, time.Date(2024, 5, 4, 0, 10, 0, 0, time.UTC))
+			So(gte, ShouldEqual, time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC))
+
+			query, err = newQueryFromReader(strings.NewReader(
+				`{"query":{"bool":{"filter":[{"range":{"timestamp":{"lt":"now-1q","gte":"now-7d"}}}]}}}`))
+			So(err, ShouldBeNil)
+
+			_, _, _, err = query.DateRange()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
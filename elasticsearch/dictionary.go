@@ -0,0 +1,197 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package elasticsearch
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/deneonet/benc"
+	"github.com/deneonet/benc/bstd"
+)
+
+const (
+	// MaxDictionaryEntries is the most strings a Dictionary can hold.
+	MaxDictionaryEntries = math.MaxUint16 + 1
+
+	// DictionaryStringsPerDetails is the number of strings of a Details (its
+	// BOM, ACCOUNTING_NAME, USER_NAME and QUEUE_NAME) that are stored in the
+	// Dictionary given to SerializeWithDictionary().
+	DictionaryStringsPerDetails = 4
+
+	// dictionaryFlag is set on the version byte of Details serialized with a
+	// Dictionary.
+	dictionaryFlag byte = 0x40
+
+	ErrDictionaryFull      = "dictionary is full"
+	ErrNoDictionary        = "serialized details need a dictionary"
+	ErrUnknownDictionaryID = "unknown dictionary id"
+)
+
+// Dictionary maps strings that repeat across many Details to small ids, so
+// that Details serialized with it take up less space. The same Dictionary must
+// be used to deserialize them again.
+type Dictionary struct {
+	ids  map[string]uint16
+	strs []string
+}
+
+// NewDictionary returns an empty Dictionary.
+func NewDictionary() *Dictionary {
+	return &Dictionary{ids: make(map[string]uint16)}
+}
+
+// Len returns the number of strings in the Dictionary.
+func (d *Dictionary) Len() int {
+	return len(d.strs)
+}
+
+// Full returns true if the Dictionary might not have room for the strings of
+// another Details.
+func (d *Dictionary) Full() bool {
+	return len(d.strs) > MaxDictionaryEntries-DictionaryStringsPerDetails
+}
+
+// id returns the id of the given string, first adding it to the Dictionary if
+// necessary.
+func (d *Dictionary) id(s string) (uint16, error) {
+	if id, ok := d.ids[s]; ok {
+		return id, nil
+	}
+
+	if len(d.strs) >= MaxDictionaryEntries {
+		return 0, Error{Msg: ErrDictionaryFull}
+	}
+
+	id := uint16(len(d.strs))
+	s = strings.Clone(s)
+
+	d.ids[s] = id
+	d.strs = append(d.strs, s)
+
+	return id, nil
+}
+
+// str returns the string with the given id.
+func (d *Dictionary) str(id uint16) (string, error) {
+	if int(id) >= len(d.strs) {
+		return "", Error{Msg: ErrUnknownDictionaryID, cause: strconv.Itoa(int(id))}
+	}
+
+	return d.strs[id], nil
+}
+
+// Serialize converts the Dictionary to a byte slice representation suitable
+// for storing on disk.
+func (d *Dictionary) Serialize() ([]byte, error) { //nolint:misspell
+	size, err := bstd.SizeSlice(d.strs, bstd.SizeString)
+	if err != nil {
+		return nil, err
+	}
+
+	n, encoded := benc.Marshal(size)
+
+	n, err = bstd.MarshalSlice(n, encoded, d.strs, bstd.MarshalString)
+	if err != nil {
+		return nil, err
+	}
+
+	return encoded, benc.VerifyMarshal(n, encoded)
+}
+
+// DeserializeDictionary takes the output of Dictionary.Serialize and converts
+// it back in to a Dictionary.
+func DeserializeDictionary(encoded []byte) (*Dictionary, error) {
+	n, strs, err := bstd.UnmarshalSlice[string](0, encoded, bstd.UnmarshalString)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = benc.VerifyUnmarshal(n, encoded); err != nil {
+		return nil, err
+	}
+
+	d := &Dictionary{ids: make(map[string]uint16, len(strs)), strs: strs}
+
+	for id, s := range strs {
+		d.ids[s] = uint16(id) //nolint:gosec
+	}
+
+	return d, nil
+}
+
+// IsDictionaryEncoded returns true if the given output of Details.Serialize or
+// SerializeWithDictionary was serialized with a Dictionary.
+func IsDictionaryEncoded(encoded []byte) bool {
+	return len(encoded) > 0 && encoded[0]&(versionFlag|dictionaryFlag) == versionFlag|dictionaryFlag
+}
+
+// The following methods serialize the strings of a Details that may be stored
+// in a Dictionary. A nil *Dictionary serializes them as plain strings.
+
+func (d *Dictionary) sizeString(s string) (int, error) {
+	if d == nil {
+		return bstd.SizeString(s)
+	}
+
+	return bstd.SizeUInt16(), nil
+}
+
+func (d *Dictionary) marshalString(n int, encoded []byte, s string) (int, error) {
+	if d == nil {
+		return bstd.MarshalString(n, encoded, s)
+	}
+
+	id, err := d.id(s)
+	if err != nil {
+		return n, err
+	}
+
+	return bstd.MarshalUInt16(n, encoded, id), nil
+}
+
+func (d *Dictionary) unmarshalString(n int, encoded []byte) (int, string, error) {
+	if d == nil {
+		return bstd.UnmarshalUnsafeString(n, encoded)
+	}
+
+	n, id, err := bstd.UnmarshalUInt16(n, encoded)
+	if err != nil {
+		return n, "", err
+	}
+
+	s, err := d.str(id)
+
+	return n, s, err
+}
+
+func (d *Dictionary) skipString(n int, encoded []byte) (int, error) {
+	if d == nil {
+		return bstd.SkipString(n, encoded)
+	}
+
+	return bstd.SkipUInt16(n, encoded)
+}
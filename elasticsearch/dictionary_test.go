@@ -0,0 +1,128 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package elasticsearch
+
+import (
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDictionary(t *testing.T) {
+	Convey("Given some Details and a Dictionary", t, func() {
+		details := &Details{
+			ID:             "id",
+			AccountingName: "aname",
+			BOM:            "Human Genetics",
+			Command:        "cmd",
+			QueueName:      "normal",
+			UserName:       "uname",
+			Timestamp:      6,
+			ClusterName:    "farm",
+			ExecHostname:   []string{"host1"},
+		}
+
+		dict := NewDictionary()
+
+		plain, err := details.Serialize() //nolint:misspell
+		So(err, ShouldBeNil)
+		So(IsDictionaryEncoded(plain), ShouldBeFalse)
+
+		Convey("you can serialize them with it, storing fewer bytes", func() {
+			encoded, err := details.SerializeWithDictionary(dict)
+			So(err, ShouldBeNil)
+			So(IsDictionaryEncoded(encoded), ShouldBeTrue)
+			So(len(encoded), ShouldBeLessThan, len(plain))
+			So(dict.Len(), ShouldEqual, DictionaryStringsPerDetails)
+
+			version, err := SerializedVersion(encoded)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, SerializationVersion)
+
+			again, err := details.SerializeWithDictionary(dict)
+			So(err, ShouldBeNil)
+			So(again, ShouldResemble, encoded)
+			So(dict.Len(), ShouldEqual, DictionaryStringsPerDetails)
+
+			Convey("and deserialize them with it", func() {
+				recovered, err := DeserializeDetailsWithDictionary(encoded, 0, dict)
+				So(err, ShouldBeNil)
+				So(recovered, ShouldResemble, details)
+
+				recovered, err = DeserializeDetailsWithDictionary(encoded, FieldUserName|FieldCommand, dict)
+				So(err, ShouldBeNil)
+				So(recovered, ShouldResemble, &Details{ID: "id", UserName: "uname", Command: "cmd"})
+			})
+
+			Convey("but not without it", func() {
+				_, err = DeserializeDetails(encoded, 0)
+				So(err, ShouldResemble, Error{Msg: ErrNoDictionary})
+			})
+
+			Convey("and deserialize them with a deserialized copy of it", func() {
+				dictBytes, err := dict.Serialize() //nolint:misspell
+				So(err, ShouldBeNil)
+
+				copied, err := DeserializeDictionary(dictBytes)
+				So(err, ShouldBeNil)
+				So(copied.Len(), ShouldEqual, dict.Len())
+
+				recovered, err := DeserializeDetailsWithDictionary(encoded, 0, copied)
+				So(err, ShouldBeNil)
+				So(recovered, ShouldResemble, details)
+
+				Convey("but not with a different one", func() {
+					_, err = DeserializeDetailsWithDictionary(encoded, 0, NewDictionary())
+					So(err, ShouldResemble, Error{Msg: ErrUnknownDictionaryID, cause: "0"})
+				})
+			})
+		})
+
+		Convey("plain serializations are deserialized as normal, ignoring the Dictionary", func() {
+			recovered, err := DeserializeDetailsWithDictionary(plain, 0, dict)
+			So(err, ShouldBeNil)
+			So(recovered, ShouldResemble, details)
+		})
+
+		Convey("a full Dictionary can't take any more strings", func() {
+			for i := 0; !dict.Full(); i++ {
+				_, err = dict.id(strconv.Itoa(i))
+			}
+
+			So(err, ShouldBeNil)
+			So(dict.Len(), ShouldEqual, MaxDictionaryEntries-DictionaryStringsPerDetails+1)
+
+			for dict.Len() < MaxDictionaryEntries {
+				_, err = dict.id(strconv.Itoa(dict.Len()))
+			}
+
+			So(err, ShouldBeNil)
+
+			_, err = details.SerializeWithDictionary(dict)
+			So(err, ShouldResemble, Error{Msg: ErrDictionaryFull})
+		})
+	})
+}
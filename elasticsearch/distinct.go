@@ -0,0 +1,72 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import "strings"
+
+const (
+	ErrNotDistinctField = "distinct values are not available for that field"
+
+	// DistinctGPU is the pseudo-field for requesting the distinct GPU flags
+	// ("true" if the job was in a gpu queue, otherwise "false") of hits.
+	DistinctGPU = "GPU"
+
+	distinctGPUPrefix = "gpu"
+	distinctTrue      = "true"
+	distinctFalse     = "false"
+)
+
+// DistinctValue returns the value of the given field (one of ACCOUNTING_NAME,
+// USER_NAME, BOM or DistinctGPU) of this Details, for the purpose of finding
+// the distinct values of that field amongst many hits. Returns an error if the
+// field is not one of those.
+func (d *Details) DistinctValue(field string) (string, error) {
+	switch field {
+	case "ACCOUNTING_NAME", "USER_NAME", "BOM":
+		return d.FieldString(field)
+	case DistinctGPU:
+		return GPUFlag(strings.HasPrefix(d.QueueName, distinctGPUPrefix)), nil
+	}
+
+	return "", Error{Msg: ErrNotDistinctField, cause: field}
+}
+
+// GPUFlag returns the DistinctGPU value corresponding to the given bool.
+func GPUFlag(isGPU bool) string {
+	if isGPU {
+		return distinctTrue
+	}
+
+	return distinctFalse
+}
+
+// ValidateDistinctField returns an error if distinct values can't be found for
+// the given field.
+func ValidateDistinctField(field string) error {
+	_, err := (&Details{}).DistinctValue(field)
+
+	return err
+}
@@ -0,0 +1,302 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const ErrUnknownField = "unknown _source field"
+
+// SourceFields returns the names of all the _source fields that Details
+// supports, in the order they are marshalled to JSON.
+func SourceFields() []string {
+	return []string{
+		"ACCOUNTING_NAME",
+		"AVAIL_CPU_TIME_SEC",
+		"BOM",
+		"Command",
+		"JOB_NAME",
+		"Job",
+		"MEM_REQUESTED_MB",
+		"MEM_REQUESTED_MB_SEC",
+		"NUM_EXEC_PROCS",
+		"PENDING_TIME_SEC",
+		"QUEUE_NAME",
+		"RUN_TIME_SEC",
+		"timestamp",
+		"USER_NAME",
+		"WASTED_CPU_SECONDS",
+		"WASTED_MB_SECONDS",
+		"RAW_WASTED_CPU_SECONDS",
+		"RAW_WASTED_MB_SECONDS",
+		"AVG_MEM_EFFICIENCY_PERCENT",
+		"AVRG_MEM_USAGE_MB",
+		"AVRG_MEM_USAGE_MB_SEC_COOKED",
+		"AVRG_MEM_USAGE_MB_SEC_RAW",
+		"CLUSTER_NAME",
+		"COOKED_CPU_TIME_SEC",
+		"END_TIME",
+		"EXEC_HOSTNAME",
+		"Exit_Info",
+		"Exitreason",
+		"JOB_ID",
+		"JOB_ARRAY_INDEX",
+		"JOB_EXIT_STATUS",
+		"Job_Efficiency_Percent",
+		"Job_Efficiency_Raw_Percent",
+		"MAX_MEM_EFFICIENCY_PERCENT",
+		"MAX_MEM_USAGE_MB",
+		"MAX_MEM_USAGE_MB_SEC_COOKED",
+		"MAX_MEM_USAGE_MB_SEC_RAW",
+		"NumberOfHosts",
+		"NumberOfUniqueHosts",
+		"PROJECT_NAME",
+		"RAW_AVG_MEM_EFFICIENCY_PERCENT",
+		"RAW_CPU_TIME_SEC",
+		"RAW_MAX_MEM_EFFICIENCY_PERCENT",
+		"SUBMIT_TIME",
+	}
+}
+
+// FieldString returns a string representation of the value of the given
+// _source field (or "_id") of this Details. Returns an error if the field is
+// not one we know about.
+func (d *Details) FieldString(field string) (string, error) { //nolint:funlen,gocyclo,cyclop
+	switch field {
+	case "_id":
+		return d.ID, nil
+	case "ACCOUNTING_NAME":
+		return d.AccountingName, nil
+	case "AVAIL_CPU_TIME_SEC":
+		return strconv.FormatInt(d.AvailCPUTimeSec, 10), nil
+	case "BOM":
+		return d.BOM, nil
+	case "Command":
+		return d.Command, nil
+	case "JOB_NAME":
+		return d.JobName, nil
+	case "Job":
+		return d.Job, nil
+	case "MEM_REQUESTED_MB":
+		return strconv.FormatInt(d.MemRequestedMB, 10), nil
+	case "MEM_REQUESTED_MB_SEC":
+		return strconv.FormatInt(d.MemRequestedMBSec, 10), nil
+	case "NUM_EXEC_PROCS":
+		return strconv.FormatInt(d.NumExecProcs, 10), nil
+	case "PENDING_TIME_SEC":
+		return strconv.FormatInt(d.PendingTimeSec, 10), nil
+	case "QUEUE_NAME":
+		return d.QueueName, nil
+	case "RUN_TIME_SEC":
+		return strconv.FormatInt(d.RunTimeSec, 10), nil
+	case "timestamp":
+		return strconv.FormatInt(d.Timestamp, 10), nil
+	case "USER_NAME":
+		return d.UserName, nil
+	case "WASTED_CPU_SECONDS":
+		return formatFloat(d.WastedCPUSeconds), nil
+	case "WASTED_MB_SECONDS":
+		return formatFloat(d.WastedMBSeconds), nil
+	case "RAW_WASTED_CPU_SECONDS":
+		return formatFloat(d.RawWastedCPUSeconds), nil
+	case "RAW_WASTED_MB_SECONDS":
+		return formatFloat(d.RawWastedMBSeconds), nil
+	case "AVG_MEM_EFFICIENCY_PERCENT":
+		return formatFloat(d.AvgMemEfficiencyPercent), nil
+	case "AVRG_MEM_USAGE_MB":
+		return formatFloat(d.AvrgMemUsageMB), nil
+	case "AVRG_MEM_USAGE_MB_SEC_COOKED":
+		return formatFloat(d.AvrgMemUsageMBSecCooked), nil
+	case "AVRG_MEM_USAGE_MB_SEC_RAW":
+		return formatFloat(d.AvrgMemUsageMBSecRaw), nil
+	case "CLUSTER_NAME":
+		return d.ClusterName, nil
+	case "COOKED_CPU_TIME_SEC":
+		return formatFloat(d.CookedCPUTimeSec), nil
+	case "END_TIME":
+		return strconv.FormatInt(d.EndTime, 10), nil
+	case "EXEC_HOSTNAME":
+		return strings.Join(d.ExecHostname, " "), nil
+	case "Exit_Info":
+		return strconv.FormatInt(d.ExitInfo, 10), nil
+	case "Exitreason":
+		return d.ExitReason, nil
+	case "JOB_ID":
+		return strconv.FormatInt(d.JobID, 10), nil
+	case "JOB_ARRAY_INDEX":
+		return strconv.FormatInt(d.JobArrayIndex, 10), nil
+	case "JOB_EXIT_STATUS":
+		return strconv.FormatInt(d.JobExitStatus, 10), nil
+	case "Job_Efficiency_Percent":
+		return formatFloat(d.JobEfficiencyPercent), nil
+	case "Job_Efficiency_Raw_Percent":
+		return formatFloat(d.JobEfficiencyRawPercent), nil
+	case "MAX_MEM_EFFICIENCY_PERCENT":
+		return formatFloat(d.MaxMemEfficiencyPercent), nil
+	case "MAX_MEM_USAGE_MB":
+		return formatFloat(d.MaxMemUsageMB), nil
+	case "MAX_MEM_USAGE_MB_SEC_COOKED":
+		return formatFloat(d.MaxMemUsageMBSecCooked), nil
+	case "MAX_MEM_USAGE_MB_SEC_RAW":
+		return formatFloat(d.MaxMemUsageMBSecRaw), nil
+	case "NumberOfHosts":
+		return strconv.FormatInt(d.NumberOfHosts, 10), nil
+	case "NumberOfUniqueHosts":
+		return strconv.FormatInt(d.NumberOfUniqueHosts, 10), nil
+	case "PROJECT_NAME":
+		return d.ProjectName, nil
+	case "RAW_AVG_MEM_EFFICIENCY_PERCENT":
+		return formatFloat(d.RawAvgMemEfficiencyPercent), nil
+	case "RAW_CPU_TIME_SEC":
+		return formatFloat(d.RawCPUTimeSec), nil
+	case "RAW_MAX_MEM_EFFICIENCY_PERCENT":
+		return formatFloat(d.RawMaxMemEfficiencyPercent), nil
+	case "SUBMIT_TIME":
+		return strconv.FormatInt(d.SubmitTime, 10), nil
+	}
+
+	return "", Error{Msg: ErrUnknownField, cause: field}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ValidateColumns returns an error if any of the given columns are not "_id" or
+// one of the SourceFields().
+func ValidateColumns(columns []string) error {
+	details := &Details{}
+
+	for _, column := range columns {
+		if _, err := details.FieldString(column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HitWriter types write hits one at a time in some export format, so that you
+// can export hits as you read them, without holding them all in memory.
+type HitWriter interface {
+	// WriteHit writes the given hit.
+	WriteHit(hit *Hit) error
+
+	// Close finishes writing, after all hits have been written.
+	Close() error
+}
+
+// writeHits writes all our hits to the given HitWriter, then closes it.
+func (r *Result) writeHits(hw HitWriter) error {
+	if r.HitSet != nil {
+		for i := range r.HitSet.Hits {
+			if err := hw.WriteHit(&r.HitSet.Hits[i]); err != nil {
+				hw.Close()
+
+				return err
+			}
+		}
+	}
+
+	return hw.Close()
+}
+
+// WriteDelimited writes a header line of the given columns, followed by a line
+// per Hit with the values of those columns, to the given writer. Values are
+// separated by the given delimiter, eg. ',' for CSV or '\t' for TSV, and are
+// quoted as necessary.
+func (r *Result) WriteDelimited(w io.Writer, columns []string, delimiter rune) error {
+	hw, err := NewDelimitedWriter(w, columns, delimiter)
+	if err != nil {
+		return err
+	}
+
+	return r.writeHits(hw)
+}
+
+// delimitedWriter is a HitWriter that writes a line per hit.
+type delimitedWriter struct {
+	cw      *csv.Writer
+	columns []string
+	record  []string
+}
+
+// NewDelimitedWriter returns a HitWriter that writes lines like
+// Result.WriteDelimited(), writing the header line straight away.
+func NewDelimitedWriter(w io.Writer, columns []string, delimiter rune) (HitWriter, error) {
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+
+	if err := cw.Write(columns); err != nil {
+		return nil, err
+	}
+
+	return &delimitedWriter{cw: cw, columns: columns, record: make([]string, len(columns))}, nil
+}
+
+// WriteHit writes a line of the given hit's values of our columns.
+func (d *delimitedWriter) WriteHit(hit *Hit) error {
+	if err := hitToRecord(*hit, d.columns, d.record); err != nil {
+		return err
+	}
+
+	return d.cw.Write(d.record)
+}
+
+// Close flushes any buffered lines.
+func (d *delimitedWriter) Close() error {
+	d.cw.Flush()
+
+	return d.cw.Error()
+}
+
+func hitToRecord(hit Hit, columns []string, record []string) error {
+	details := hit.Details
+	if details == nil {
+		details = &Details{}
+	}
+
+	for i, column := range columns {
+		if column == "_id" {
+			record[i] = hit.ID
+
+			continue
+		}
+
+		val, err := details.FieldString(column)
+		if err != nil {
+			return err
+		}
+
+		record[i] = val
+	}
+
+	return nil
+}
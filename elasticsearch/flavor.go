@@ -0,0 +1,105 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import "net/http"
+
+const (
+	ErrUnknownFlavor  = "unknown search server flavor"
+	ErrPITUnsupported = "point-in-time searches are not supported by this flavor"
+
+	// FlavorElasticsearch7 is the default Config.Flavor, for elastic search
+	// 7.x servers.
+	FlavorElasticsearch7 = "elasticsearch7"
+
+	// FlavorElasticsearch8 is the Config.Flavor for elastic search 8.x
+	// servers. Requests are made in 7.x REST API compatibility mode, which 8.x
+	// servers support for all the APIs we use.
+	FlavorElasticsearch8 = "elasticsearch8"
+
+	// FlavorOpenSearch is the Config.Flavor for OpenSearch servers, which
+	// support the 7.x APIs we use (except point-in-time; see Config.UsePIT),
+	// but don't identify themselves as elastic search.
+	FlavorOpenSearch = "opensearch"
+
+	productHeader = "X-Elastic-Product"
+	productName   = "Elasticsearch"
+)
+
+// flavorSettings validates the given Config.Flavor, returning whether the
+// client should use REST API compatibility mode, and the transport it should
+// use in place of the given one.
+func flavorSettings(config Config, transport http.RoundTripper) (bool, http.RoundTripper, error) {
+	switch config.Flavor {
+	case "", FlavorElasticsearch7:
+		return false, transport, nil
+	case FlavorElasticsearch8:
+		return true, transport, nil
+	case FlavorOpenSearch:
+		if config.UsePIT {
+			return false, nil, Error{Msg: ErrPITUnsupported, cause: config.Flavor}
+		}
+
+		return false, newProductHeaderTransport(transport), nil
+	default:
+		return false, nil, Error{Msg: ErrUnknownFlavor, cause: config.Flavor}
+	}
+}
+
+// productHeaderTransport is an http.RoundTripper that adds the product header
+// elastic search sends to every response, so that the go-elasticsearch
+// client's check that it is talking to elastic search passes for compatible
+// servers like OpenSearch.
+type productHeaderTransport struct {
+	next http.RoundTripper
+}
+
+// newProductHeaderTransport returns a productHeaderTransport that makes
+// requests using the given RoundTripper, or http.DefaultTransport if that is
+// nil.
+func newProductHeaderTransport(next http.RoundTripper) *productHeaderTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &productHeaderTransport{next: next}
+}
+
+// RoundTrip makes the request, adding the product header to the response.
+func (p *productHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+
+	resp.Header.Set(productHeader, productName)
+
+	return resp, nil
+}
@@ -0,0 +1,129 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flavorTransport is an http.RoundTripper that answers every request like an
+// OpenSearch server would, without elastic search's product header unless
+// elastic is true, and records the Accept header of the last request.
+type flavorTransport struct {
+	elastic bool
+	accept  string
+}
+
+func (f *flavorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.accept = req.Header.Get("Accept")
+
+	jsonStr := testNonAggQueryResponseZeroSize
+	if req.URL.Path == "/" {
+		jsonStr = `{"version": {"distribution": "opensearch", "number": "2.11.0"}, "tagline": "The OpenSearch Project"}`
+	}
+
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	if f.elastic {
+		header.Set(productHeader, productName)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(jsonStr)),
+		Header:     header,
+	}, nil
+}
+
+func TestFlavor(t *testing.T) {
+	Convey("Given an OpenSearch server", t, func() {
+		transport := &flavorTransport{}
+		config := Config{
+			Host:      "mock",
+			Scheme:    "http",
+			Port:      mockPort,
+			Index:     "mock-*",
+			transport: transport,
+		}
+		query := &Query{}
+
+		Convey("the default flavor client refuses to talk to it", func() {
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Search(query)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("an opensearch flavor client can search it", func() {
+			config.Flavor = FlavorOpenSearch
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			result, err := client.Search(query)
+			So(err, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, 2)
+
+			info, err := client.Info()
+			So(err, ShouldBeNil)
+			So(info.Version.Number, ShouldEqual, "2.11.0")
+		})
+
+		Convey("but not with point-in-time searches", func() {
+			config.Flavor = FlavorOpenSearch
+			config.UsePIT = true
+
+			_, err := NewClient(config)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrPITUnsupported)
+		})
+
+		Convey("an elasticsearch8 flavor client makes requests in compatibility mode", func() {
+			transport.elastic = true
+			config.Flavor = FlavorElasticsearch8
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Search(query)
+			So(err, ShouldBeNil)
+			So(transport.accept, ShouldContainSubstring, "compatible-with=7")
+		})
+
+		Convey("unknown flavors are rejected", func() {
+			config.Flavor = "solr"
+
+			_, err := NewClient(config)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrUnknownFlavor)
+		})
+	})
+}
@@ -0,0 +1,96 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"io"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/metrics"
+)
+
+const (
+	OpSearch = "search"
+	OpScroll = "scroll"
+	OpCount  = "count"
+)
+
+// ClientMetrics records how many Search(), Scroll() and Count() calls a Client
+// has made, how many failed, and how long they took. Scroll() durations are of
+// fetching all hits, including the time spent in any callback.
+type ClientMetrics struct {
+	requests  *metrics.CounterVec
+	errors    *metrics.CounterVec
+	durations *metrics.HistogramVec
+}
+
+func newClientMetrics() *ClientMetrics {
+	return &ClientMetrics{
+		requests: metrics.NewCounterVec("farmer_elasticsearch_requests_total",
+			"Number of requests made to elastic search.", "op"),
+		errors: metrics.NewCounterVec("farmer_elasticsearch_request_errors_total",
+			"Number of requests to elastic search that failed.", "op"),
+		durations: metrics.NewHistogramVec("farmer_elasticsearch_request_duration_seconds",
+			"Time taken by requests to elastic search.", "op", metrics.DurationBuckets),
+	}
+}
+
+// observe records a call of the given op that started at the given time and
+// returned the given error. Does nothing for a nil ClientMetrics.
+func (m *ClientMetrics) observe(op string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.requests.Inc(op)
+	m.durations.Observe(op, time.Since(start).Seconds())
+
+	if err != nil {
+		m.errors.Inc(op)
+	}
+}
+
+// Requests returns the number of calls made of the given op (OpSearch, OpScroll
+// or OpCount).
+func (m *ClientMetrics) Requests(op string) uint64 {
+	return m.requests.Value(op)
+}
+
+// Errors returns the number of calls of the given op that failed.
+func (m *ClientMetrics) Errors(op string) uint64 {
+	return m.errors.Value(op)
+}
+
+// WriteMetrics writes our metrics in the Prometheus text exposition format.
+func (m *ClientMetrics) WriteMetrics(w io.Writer) error {
+	return metrics.WriteAll(w, m.requests, m.errors, m.durations)
+}
+
+// Metrics returns the ClientMetrics of our Search(), Scroll() and Count()
+// calls.
+func (c *Client) Metrics() *ClientMetrics {
+	return c.metrics
+}
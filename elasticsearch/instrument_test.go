@@ -0,0 +1,74 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientMetrics(t *testing.T) {
+	Convey("A Client records metrics of its requests", t, func() {
+		mock := NewMock("some-indexes-*")
+		m := mock.Metrics()
+
+		_, err := mock.Search(&Query{})
+		So(err, ShouldBeNil)
+
+		_, err = mock.Scroll(&Query{}, nil)
+		So(err, ShouldBeNil)
+
+		So(m.Requests(OpSearch), ShouldEqual, 1)
+		So(m.Requests(OpScroll), ShouldEqual, 1)
+		So(m.Requests(OpCount), ShouldEqual, 0)
+		So(m.Errors(OpSearch), ShouldEqual, 0)
+
+		var buf bytes.Buffer
+
+		So(m.WriteMetrics(&buf), ShouldBeNil)
+		So(buf.String(), ShouldContainSubstring, `farmer_elasticsearch_requests_total{op="scroll"} 1`)
+		So(buf.String(), ShouldContainSubstring, `farmer_elasticsearch_request_duration_seconds_count{op="search"} 1`)
+
+		Convey("including failures", func() {
+			client, err := NewClient(Config{
+				Host:       "mock",
+				Scheme:     "http",
+				Port:       mockPort,
+				MaxRetries: -1,
+				transport:  &flappingTransport{status: http.StatusInternalServerError},
+			})
+			So(err, ShouldBeNil)
+
+			_, err = client.Count(&Query{})
+			So(err, ShouldNotBeNil)
+			So(client.Metrics().Requests(OpCount), ShouldEqual, 1)
+			So(client.Metrics().Errors(OpCount), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,88 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"reflect"
+	"strings"
+)
+
+const (
+	FieldCapsPage = "_field_caps"
+	MappingPage   = "_mapping"
+
+	timestampField  = "timestamp"
+	timestampFormat = "strict_date_optional_time||epoch_second"
+)
+
+// FieldMapping describes the type of a field, as in an elasticsearch index
+// mapping.
+type FieldMapping struct {
+	Type   string `json:"type"`
+	Format string `json:"format,omitempty"`
+}
+
+// Mappings returns the FieldMapping of each of the _source fields that Details
+// supports, keyed on field name. String fields are keywords, since we only
+// support exact and prefix matches on them, and timestamp is a date.
+func Mappings() map[string]FieldMapping {
+	mappings := make(map[string]FieldMapping)
+	t := reflect.TypeOf(Details{})
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "" || name == "_id" {
+			continue
+		}
+
+		mappings[name] = fieldMapping(name, field.Type)
+	}
+
+	return mappings
+}
+
+// fieldMapping returns the FieldMapping for a Details field with the given
+// name and type.
+func fieldMapping(name string, t reflect.Type) FieldMapping {
+	if name == timestampField {
+		return FieldMapping{Type: "date", Format: timestampFormat}
+	}
+
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	switch t.Kind() { //nolint:exhaustive
+	case reflect.Int64:
+		return FieldMapping{Type: "long"}
+	case reflect.Float64:
+		return FieldMapping{Type: "double"}
+	default:
+		return FieldMapping{Type: "keyword"}
+	}
+}
@@ -0,0 +1,50 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMappings(t *testing.T) {
+	Convey("Mappings() describes every source field of Details", t, func() {
+		mappings := Mappings()
+		So(len(mappings), ShouldEqual, len(SourceFields()))
+
+		for _, field := range SourceFields() {
+			So(mappings[field].Type, ShouldNotBeBlank)
+		}
+
+		So(mappings["BOM"], ShouldResemble, FieldMapping{Type: "keyword"})
+		So(mappings["EXEC_HOSTNAME"], ShouldResemble, FieldMapping{Type: "keyword"})
+		So(mappings["RUN_TIME_SEC"], ShouldResemble, FieldMapping{Type: "long"})
+		So(mappings["WASTED_CPU_SECONDS"], ShouldResemble, FieldMapping{Type: "double"})
+		So(mappings["timestamp"], ShouldResemble, FieldMapping{Type: "date", Format: timestampFormat})
+		So(mappings["_id"], ShouldResemble, FieldMapping{})
+	})
+}
@@ -0,0 +1,198 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	ErrNotNumericField = "stats and percentiles are not available for that field"
+
+	// MemEfficiencyField is the field for requesting the stats or percentiles
+	// of the memory efficiency of hits.
+	MemEfficiencyField = "AVG_MEM_EFFICIENCY_PERCENT"
+
+	percentMultiplier = 100
+)
+
+// defaultPercents are the percentiles elasticsearch calculates if a
+// percentiles aggregation doesn't specify any.
+var defaultPercents = []float64{1, 5, 25, 50, 75, 95, 99} //nolint:gochecknoglobals
+
+// NumericValue returns the value of the given numeric field of this Details,
+// for the purpose of calculating stats and percentiles. Returns an error if the
+// field isn't numeric.
+func (d *Details) NumericValue(field string) (float64, error) { //nolint:funlen,gocyclo,cyclop
+	switch field {
+	case "AVAIL_CPU_TIME_SEC":
+		return float64(d.AvailCPUTimeSec), nil
+	case "MEM_REQUESTED_MB":
+		return float64(d.MemRequestedMB), nil
+	case "MEM_REQUESTED_MB_SEC":
+		return float64(d.MemRequestedMBSec), nil
+	case "NUM_EXEC_PROCS":
+		return float64(d.NumExecProcs), nil
+	case "PENDING_TIME_SEC":
+		return float64(d.PendingTimeSec), nil
+	case "RUN_TIME_SEC":
+		return float64(d.RunTimeSec), nil
+	case "WASTED_CPU_SECONDS":
+		return d.WastedCPUSeconds, nil
+	case "WASTED_MB_SECONDS":
+		return d.WastedMBSeconds, nil
+	case "RAW_WASTED_CPU_SECONDS":
+		return d.RawWastedCPUSeconds, nil
+	case "RAW_WASTED_MB_SECONDS":
+		return d.RawWastedMBSeconds, nil
+	case "AVG_MEM_EFFICIENCY_PERCENT":
+		return d.AvgMemEfficiencyPercent, nil
+	case "AVRG_MEM_USAGE_MB":
+		return d.AvrgMemUsageMB, nil
+	case "AVRG_MEM_USAGE_MB_SEC_COOKED":
+		return d.AvrgMemUsageMBSecCooked, nil
+	case "AVRG_MEM_USAGE_MB_SEC_RAW":
+		return d.AvrgMemUsageMBSecRaw, nil
+	case "COOKED_CPU_TIME_SEC":
+		return d.CookedCPUTimeSec, nil
+	case "END_TIME":
+		return float64(d.EndTime), nil
+	case "Exit_Info":
+		return float64(d.ExitInfo), nil
+	case "JOB_ID":
+		return float64(d.JobID), nil
+	case "JOB_ARRAY_INDEX":
+		return float64(d.JobArrayIndex), nil
+	case "JOB_EXIT_STATUS":
+		return float64(d.JobExitStatus), nil
+	case "Job_Efficiency_Percent":
+		return d.JobEfficiencyPercent, nil
+	case "Job_Efficiency_Raw_Percent":
+		return d.JobEfficiencyRawPercent, nil
+	case "MAX_MEM_EFFICIENCY_PERCENT":
+		return d.MaxMemEfficiencyPercent, nil
+	case "MAX_MEM_USAGE_MB":
+		return d.MaxMemUsageMB, nil
+	case "MAX_MEM_USAGE_MB_SEC_COOKED":
+		return d.MaxMemUsageMBSecCooked, nil
+	case "MAX_MEM_USAGE_MB_SEC_RAW":
+		return d.MaxMemUsageMBSecRaw, nil
+	case "NumberOfHosts":
+		return float64(d.NumberOfHosts), nil
+	case "NumberOfUniqueHosts":
+		return float64(d.NumberOfUniqueHosts), nil
+	case "RAW_AVG_MEM_EFFICIENCY_PERCENT":
+		return d.RawAvgMemEfficiencyPercent, nil
+	case "RAW_CPU_TIME_SEC":
+		return d.RawCPUTimeSec, nil
+	case "RAW_MAX_MEM_EFFICIENCY_PERCENT":
+		return d.RawMaxMemEfficiencyPercent, nil
+	case "SUBMIT_TIME":
+		return float64(d.SubmitTime), nil
+	}
+
+	return 0, Error{Msg: ErrNotNumericField, cause: field}
+}
+
+// ValidateNumericField returns an error if stats and percentiles can't be
+// calculated for the given field.
+func ValidateNumericField(field string) error {
+	_, err := (&Details{}).NumericValue(field)
+
+	return err
+}
+
+// NewStatsBuckets returns the Buckets of a "stats" aggregation of the given
+// values.
+func NewStatsBuckets(values []float64) *Buckets {
+	count := int64(len(values))
+	b := &Buckets{Count: &count, Sum: new(float64)}
+
+	if count == 0 {
+		return b
+	}
+
+	minVal, maxVal := math.Inf(1), math.Inf(-1)
+
+	for _, v := range values {
+		*b.Sum += v
+		minVal = math.Min(minVal, v)
+		maxVal = math.Max(maxVal, v)
+	}
+
+	avg := *b.Sum / float64(count)
+	b.Min, b.Max, b.Avg = &minVal, &maxVal, &avg
+
+	return b
+}
+
+// NewPercentilesBuckets returns the Buckets of a "percentiles" aggregation of
+// the given values, calculating the given percents, or elasticsearch's default
+// ones if none are given. The values will be sorted.
+func NewPercentilesBuckets(values, percents []float64) *Buckets {
+	if len(percents) == 0 {
+		percents = defaultPercents
+	}
+
+	sort.Float64s(values)
+
+	b := &Buckets{Values: make(map[string]*float64, len(percents))}
+
+	for _, p := range percents {
+		var val *float64
+
+		if len(values) > 0 {
+			v := percentile(values, p)
+			val = &v
+		}
+
+		b.Values[percentKey(p)] = val
+	}
+
+	return b
+}
+
+// percentile returns the pth percentile of the given sorted values, linearly
+// interpolating between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / percentMultiplier * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+// percentKey formats the given percent the way elasticsearch does for the keys
+// of percentiles values, eg. "50.0" or "99.9".
+func percentKey(p float64) string {
+	if p == math.Trunc(p) {
+		return strconv.FormatFloat(p, 'f', 1, 64)
+	}
+
+	return strconv.FormatFloat(p, 'f', -1, 64)
+}
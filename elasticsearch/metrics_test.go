@@ -0,0 +1,103 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("You can get the numeric values of Details fields", t, func() {
+		d := &Details{RunTimeSec: 3, AvgMemEfficiencyPercent: 75, JobID: 7}
+
+		val, err := d.NumericValue("RUN_TIME_SEC")
+		So(err, ShouldBeNil)
+		So(val, ShouldEqual, 3)
+
+		val, err = d.NumericValue(MemEfficiencyField)
+		So(err, ShouldBeNil)
+		So(val, ShouldEqual, 75)
+
+		val, err = d.NumericValue("JOB_ID")
+		So(err, ShouldBeNil)
+		So(val, ShouldEqual, 7)
+
+		_, err = d.NumericValue("USER_NAME")
+		So(err, ShouldNotBeNil)
+
+		So(ValidateNumericField("PENDING_TIME_SEC"), ShouldBeNil)
+		So(ValidateNumericField("BOM"), ShouldNotBeNil)
+	})
+
+	Convey("You can calculate stats and percentiles, which round-trip through JSON", t, func() {
+		values := []float64{4, 1, 3, 2, 5}
+
+		b := NewStatsBuckets(values)
+		So(*b.Count, ShouldEqual, 5)
+		So(*b.Min, ShouldEqual, 1)
+		So(*b.Max, ShouldEqual, 5)
+		So(*b.Avg, ShouldEqual, 3)
+		So(*b.Sum, ShouldEqual, 15)
+
+		result := &Result{HitSet: &HitSet{}, Aggregations: &Aggregations{Stats: b}}
+
+		data, err := result.MarshalFields(0)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"stats":{"count":5,"min":1,"max":5,"avg":3,"sum":15}`)
+
+		decoded := &Result{}
+		So(decoded.UnmarshalJSON(data), ShouldBeNil)
+		So(decoded.Aggregations.Stats, ShouldResemble, b)
+
+		b = NewStatsBuckets(nil)
+		So(*b.Count, ShouldEqual, 0)
+		So(b.Min, ShouldBeNil)
+
+		result.Aggregations.Stats = b
+		data, err = result.MarshalFields(0)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"stats":{"count":0,"min":null,"max":null,"avg":null,"sum":0}`)
+
+		b = NewPercentilesBuckets(values, []float64{50, 99.9})
+		So(b.Values, ShouldHaveLength, 2)
+		So(*b.Values["50.0"], ShouldEqual, 3)
+		So(*b.Values["99.9"], ShouldAlmostEqual, 4.996)
+
+		result.Aggregations.Stats = b
+		data, err = result.MarshalFields(0)
+		So(err, ShouldBeNil)
+
+		decoded = &Result{}
+		So(decoded.UnmarshalJSON(data), ShouldBeNil)
+		So(decoded.Aggregations.Stats, ShouldResemble, b)
+
+		b = NewPercentilesBuckets(nil, nil)
+		So(b.Values, ShouldHaveLength, len(defaultPercents))
+		So(b.Values["1.0"], ShouldBeNil)
+	})
+}
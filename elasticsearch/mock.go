@@ -68,12 +68,13 @@ const (
 		"hits": {
 			"total":{"value":2},
 			"hits": [
-				{"_id": "1", "_source": { "ACCOUNTING_NAME": "pathdev", "USER_NAME": "pathpipe", "QUEUE_NAME": "transfer" } },
+				{"_id": "1", "_source": { "ACCOUNTING_NAME": "pathdev", "USER_NAME": "pathpipe", "QUEUE_NAME": "transfer", "EXEC_HOSTNAME": ["host1"], "META_CLUSTER_NAME": "farm" } },
                 {"_id": "2", "_source": { "ACCOUNTING_NAME": "a2", "USER_NAME": "u2", "QUEUE_NAME": "q2" } }
 			]
 		}
 	}`
 	testNonAggQueryResponse0529 = `{"hits": {}}`
+	testCountResponse           = `{"count": 2}`
 	testNonAggQueryResponse0530 = `{
 		"hits": {
 			"total":{"value":2},
@@ -157,6 +158,8 @@ func (m mockTransport) RoundTrip(req *http.Request) (*http.Response, error) { //
 
 		if scrollRequest { //nolint:gocritic
 			jsonStr = m.scrollHits(req.Method == http.MethodPost)
+		} else if filepath.Base(req.URL.Path) == "_count" {
+			jsonStr = testCountResponse
 		} else if query.Aggs != nil {
 			jsonStr = testAggQueryResponse
 		} else {
@@ -250,11 +253,13 @@ func (m mockTransport) scrollHits(wasPost bool) string {
 // server.
 type Mock struct {
 	*Client
-	index string
+	index     string
+	transport mockTransport
 }
 
 // NewMock returns a Mock that you can get a mock Client and queries from.
 func NewMock(index string) *Mock {
+	transport := mockTransport{index: index}
 	config := Config{
 		Host:      "mock",
 		Username:  "mock",
@@ -262,12 +267,38 @@ func NewMock(index string) *Mock {
 		Scheme:    "http",
 		Port:      mockPort,
 		Index:     index,
-		transport: mockTransport{index: index},
+		transport: transport,
 	}
 
 	client, _ := NewClient(config) //nolint:errcheck
 
-	return &Mock{Client: client, index: url.QueryEscape(index)}
+	return &Mock{Client: client, index: url.QueryEscape(index), transport: transport}
+}
+
+// ServeHTTP makes Mock an http.Handler that responds to requests the same way
+// our mock Client's transport does, so you can use it as the target of a proxy.
+func (m *Mock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength == 0 {
+		r.Body = nil
+	}
+
+	resp, err := m.transport.RoundTrip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	defer resp.Body.Close()
+
+	for key, vals := range resp.Header {
+		w.Header()[key] = vals
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+
+	io.Copy(w, resp.Body) //nolint:errcheck
 }
 
 // AggQuery returns a http.Request that is requesting an aggregation search.
@@ -291,11 +322,34 @@ func (m *Mock) Done(int) bool {
 	return true
 }
 
-func (m *Mock) Usernames(query *Query) ([]string, error) {
-	usernamesMap := make(map[string]bool)
+// DistinctValues scrolls and returns the unique values of the given field
+// amongst the hits.
+func (m *Mock) DistinctValues(query *Query, field string) ([]string, error) {
+	counts, err := m.DistinctCounts(query, field)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(counts))
+	for val := range counts {
+		values = append(values, val)
+	}
+
+	return values, nil
+}
+
+// DistinctCounts scrolls and returns how many of the hits have each value of
+// the given field.
+func (m *Mock) DistinctCounts(query *Query, field string) (map[string]int, error) {
+	if err := ValidateDistinctField(field); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
 
 	cb := func(hit *Hit) {
-		usernamesMap[hit.Details.UserName] = true
+		val, _ := hit.Details.DistinctValue(field) //nolint:errcheck
+		counts[val]++
 	}
 
 	_, err := m.Scroll(query, cb)
@@ -303,10 +357,38 @@ func (m *Mock) Usernames(query *Query) ([]string, error) {
 		return nil, err
 	}
 
-	usernames := make([]string, 0, len(usernamesMap))
-	for username := range usernamesMap {
-		usernames = append(usernames, username)
+	return counts, nil
+}
+
+// Usernames is DistinctValues() with USER_NAME.
+func (m *Mock) Usernames(query *Query) ([]string, error) {
+	return m.DistinctValues(query, "USER_NAME")
+}
+
+// MultiScroll calls Scroll() on each query in turn.
+func (m *Mock) MultiScroll(queries []*Query) ([]*Result, error) {
+	results := make([]*Result, len(queries))
+
+	for i, query := range queries {
+		result, err := m.Scroll(query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+func (m *Mock) Count(query *Query) (int, error) {
+	count := 0
+
+	cb := func(*Hit) {
+		count++
 	}
 
-	return usernames, nil
+	_, err := m.Scroll(query, cb)
+
+	return count, err
 }
@@ -0,0 +1,164 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	ErrBadPattern = "invalid wildcard or regexp"
+
+	wildcardKind = "wildcard"
+	regexpKind   = "regexp"
+
+	// unsupportedRegexpOperators are the characters that are operators in
+	// elasticsearch's regexp syntax (with its default flags) that we don't
+	// support.
+	unsupportedRegexpOperators = `#@&<>~"^$`
+)
+
+// PatternFilter is a "wildcard" or "regexp" clause in a Query's filter.
+type PatternFilter struct {
+	Field           string
+	Pattern         string
+	Regexp          bool
+	CaseInsensitive bool
+}
+
+// PatternFilters returns the wildcard and regexp clauses found in the query's
+// filter. Their values can be the pattern string, or an object with "value"
+// and optionally "case_insensitive" properties.
+func (q *Query) PatternFilters() []PatternFilter {
+	var pfs []PatternFilter
+
+	for _, val := range q.Query.Bool.Filter {
+		for _, kind := range []string{wildcardKind, regexpKind} {
+			for field, v := range val[kind] {
+				pf := PatternFilter{Field: field, Regexp: kind == regexpKind}
+
+				switch pattern := v.(type) {
+				case string:
+					pf.Pattern = pattern
+				case map[string]interface{}:
+					pf.Pattern, _ = pattern["value"].(string)                  //nolint:errcheck
+					pf.CaseInsensitive, _ = pattern["case_insensitive"].(bool) //nolint:errcheck
+				default:
+					continue
+				}
+
+				pfs = append(pfs, pf)
+			}
+		}
+	}
+
+	return pfs
+}
+
+// Compile returns a regexp.Regexp that matches whole values the way
+// elasticsearch would match this PatternFilter. Wildcards can contain * (any
+// characters), ? (any one character) and \ (escape). Regexps are limited to
+// the syntax common to elasticsearch and Go, so the optional operators # @ & <
+// > ~ and the literal characters " ^ $ must be escaped to be used.
+func (p PatternFilter) Compile() (*regexp.Regexp, error) {
+	expr := wildcardToRegexp(p.Pattern)
+
+	if p.Regexp {
+		if err := checkRegexpSupported(p.Pattern); err != nil {
+			return nil, err
+		}
+
+		expr = p.Pattern
+	}
+
+	expr = `^(?:` + expr + `)$`
+
+	if p.CaseInsensitive {
+		expr = `(?i)` + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, Error{Msg: ErrBadPattern, cause: err.Error()}
+	}
+
+	return re, nil
+}
+
+// wildcardToRegexp converts an elasticsearch wildcard pattern to an equivalent
+// unanchored regular expression.
+func wildcardToRegexp(pattern string) string {
+	var b strings.Builder
+
+	escaped := false
+
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	if escaped {
+		b.WriteString(`\\`)
+	}
+
+	return b.String()
+}
+
+// checkRegexpSupported returns an ErrBadPattern Error if the given
+// elasticsearch regexp uses operators outside of a character class that we
+// don't support.
+func checkRegexpSupported(pattern string) error {
+	escaped, inClass := false, false
+
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case inClass:
+			inClass = r != ']'
+		case r == '[':
+			inClass = true
+		case strings.ContainsRune(unsupportedRegexpOperators, r):
+			return Error{Msg: ErrBadPattern, cause: "unsupported regexp operator " + string(r)}
+		}
+	}
+
+	return nil
+}
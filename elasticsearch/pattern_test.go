@@ -0,0 +1,87 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPatternFilters(t *testing.T) {
+	Convey("You can get the wildcard and regexp filters from a Query", t, func() {
+		query, err := newQueryFromReader(strings.NewReader(`{"query":{"bool":{"filter":[` +
+			`{"wildcard":{"JOB_NAME":"nf-*"}},` +
+			`{"regexp":{"Command":{"value":"bash.*","case_insensitive":true}}},` +
+			`{"prefix":{"QUEUE_NAME":"normal"}}]}}}`))
+		So(err, ShouldBeNil)
+
+		So(query.PatternFilters(), ShouldResemble, []PatternFilter{
+			{Field: "JOB_NAME", Pattern: "nf-*"},
+			{Field: "Command", Pattern: "bash.*", Regexp: true, CaseInsensitive: true},
+		})
+
+		query, err = newQueryFromReader(strings.NewReader(testNonAggQuery))
+		So(err, ShouldBeNil)
+		So(query.PatternFilters(), ShouldBeEmpty)
+	})
+
+	Convey("Wildcards compile to regexps that match whole values", t, func() {
+		matches := func(pf PatternFilter, vals ...string) []bool {
+			re, err := pf.Compile()
+			So(err, ShouldBeNil)
+
+			results := make([]bool, len(vals))
+			for i, val := range vals {
+				results[i] = re.MatchString(val)
+			}
+
+			return results
+		}
+
+		So(matches(PatternFilter{Pattern: "nf-*"}, "nf-foo", "nf-", "xnf-foo", "NF-foo"),
+			ShouldResemble, []bool{true, true, false, false})
+		So(matches(PatternFilter{Pattern: "job?.[1]"}, "jobA.[1]", "jobAB.[1]", "jobA.1"),
+			ShouldResemble, []bool{true, false, false})
+		So(matches(PatternFilter{Pattern: `a\*b\`}, `a*b\`, "axb"), ShouldResemble, []bool{true, false})
+		So(matches(PatternFilter{Pattern: "NF-*", CaseInsensitive: true}, "nf-foo"), ShouldResemble, []bool{true})
+
+		Convey("and regexps are anchored", func() {
+			So(matches(PatternFilter{Pattern: "nf-[a-z]+|job[0-9]", Regexp: true}, "nf-foo", "job1", "xjob1", "nf-foo1"),
+				ShouldResemble, []bool{true, true, false, false})
+			So(matches(PatternFilter{Pattern: `[^~]+\~`, Regexp: true}, "a~", "ab"), ShouldResemble, []bool{true, false})
+		})
+
+		Convey("and unsupported or invalid regexps are errors", func() {
+			for _, pattern := range []string{"a|~b", "a&b", "<1-5>", "^a", "a(b", "@"} {
+				_, err := PatternFilter{Pattern: pattern, Regexp: true}.Compile()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, ErrBadPattern)
+			}
+		})
+	})
+}
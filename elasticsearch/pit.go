@@ -0,0 +1,191 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// PIT specifies the point-in-time a search should be made against; see
+// searchAfterAll().
+type PIT struct {
+	ID        string `json:"id"`
+	KeepAlive string `json:"keep_alive"`
+}
+
+// defaultPITSort is the sort used for point-in-time searches of queries that
+// don't specify one, since search_after needs sorted hits.
+var defaultPITSort = []string{"timestamp", "_doc"} //nolint:gochecknoglobals
+
+// searchAfterAll is like scrollAll(), but instead of using the scroll API, it
+// opens a point-in-time on our index and pages through the query's hits with
+// search_after, which elastic search recommends over scrolls for deep
+// pagination since 7.10. Queries without a Sort are sorted on timestamp and
+// _doc.
+func (c *Client) searchAfterAll(query *Query, cb HitsCallBack) (*Result, error) {
+	ctx := query.Context()
+
+	pitID, err := c.openPIT(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { c.closePIT(pitID) }()
+
+	q := *query
+	q.ScrollParamSet = false
+
+	if len(q.Sort) == 0 {
+		q.Sort = defaultPITSort
+	}
+
+	if q.Size <= 0 || q.Size > MaxSize {
+		q.Size = MaxSize
+	}
+
+	merged := &Result{HitSet: &HitSet{}}
+
+	for {
+		q.PIT = &PIT{ID: pitID, KeepAlive: scrollTime.String()}
+
+		page, n, last, errs := c.searchAfterPage(&q, cb)
+		if errs != nil {
+			return nil, errs
+		}
+
+		if page.PITID != "" {
+			pitID = page.PITID
+		}
+
+		merged.Took += page.Took
+		merged.TimedOut = merged.TimedOut || page.TimedOut
+		merged.HitSet.Total.Value += n
+
+		if page.HitSet != nil {
+			merged.HitSet.Hits = append(merged.HitSet.Hits, page.HitSet.Hits...)
+		}
+
+		if n < q.Size || last == nil {
+			return merged, nil
+		}
+
+		q.SearchAfter = last.Sort
+	}
+}
+
+// searchAfterPage does a single search of the given point-in-time query,
+// passing hits to the given callback if not nil. It returns the page's Result,
+// its number of hits, and its last hit.
+func (c *Client) searchAfterPage(query *Query, cb HitsCallBack) (*Result, int, *Hit, error) {
+	client := c.client.Load()
+
+	qbody, err := query.asBody()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	resp, err := client.Search(
+		client.Search.WithContext(query.Context()),
+		client.Search.WithBody(qbody),
+	)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	var last *Hit
+
+	pageCB := cb
+	if cb != nil {
+		pageCB = func(hit *Hit) {
+			last = hit
+			cb(hit)
+		}
+	}
+
+	result, n, err := parseResultResponse(resp, pageCB, c.unknownFields)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if cb == nil && result.HitSet != nil && len(result.HitSet.Hits) > 0 {
+		n = len(result.HitSet.Hits)
+		last = &result.HitSet.Hits[n-1]
+	}
+
+	return result, n, last, nil
+}
+
+// openPIT opens a point-in-time on our index, returning its ID.
+func (c *Client) openPIT(ctx context.Context) (string, error) {
+	client := c.client.Load()
+
+	resp, err := client.OpenPointInTime(
+		[]string{c.index},
+		scrollTime.String(),
+		client.OpenPointInTime.WithContext(ctx),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		return "", Error{Msg: ErrFailedQuery, cause: resp.String()}
+	}
+
+	var pit struct {
+		ID string `json:"id"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&pit)
+
+	return pit.ID, err
+}
+
+// closePIT closes the point-in-time with the given ID, setting our Error if
+// that fails.
+func (c *Client) closePIT(id string) {
+	client := c.client.Load()
+
+	body, err := json.Marshal(map[string]string{"id": id})
+	if err != nil {
+		c.Error = err
+
+		return
+	}
+
+	resp, err := client.ClosePointInTime(client.ClosePointInTime.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		c.Error = err
+
+		return
+	}
+
+	resp.Body.Close()
+}
@@ -0,0 +1,152 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const pitTestHits = 10
+
+// pitTransport is an http.RoundTripper that implements enough of elastic
+// search's point-in-time and search_after APIs to page through hits
+// timestamped 0..pitTestHits-1. Each search returns a new pit_id.
+type pitTransport struct {
+	opened   int
+	searches []*Query
+	closed   string
+}
+
+func (p *pitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jsonStr := mockVersionJSON
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_pit") && req.Method == http.MethodPost:
+		p.opened++
+		jsonStr = `{"id": "pit0"}`
+	case req.URL.Path == "/_pit" && req.Method == http.MethodDelete:
+		var body map[string]string
+
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+
+		p.closed = body["id"]
+		jsonStr = `{"succeeded": true}`
+	case req.URL.Path == "/_search":
+		query := &Query{}
+
+		if err := json.NewDecoder(req.Body).Decode(query); err != nil {
+			return nil, err
+		}
+
+		p.searches = append(p.searches, query)
+		jsonStr = p.page(query)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(jsonStr)),
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+	}, nil
+}
+
+func (p *pitTransport) page(query *Query) string {
+	after := -1
+
+	if query.SearchAfter != nil {
+		var sort []int
+
+		if err := json.Unmarshal(query.SearchAfter, &sort); err == nil {
+			after = sort[0]
+		}
+	}
+
+	var hits []string
+
+	for ts := after + 1; ts < pitTestHits && len(hits) < query.Size; ts++ {
+		hits = append(hits, `{"_id": "`+strconv.Itoa(ts)+`", "_source": {"timestamp": `+strconv.Itoa(ts)+
+			`}, "sort": [`+strconv.Itoa(ts)+`, 0]}`)
+	}
+
+	return `{"pit_id": "pit` + strconv.Itoa(len(p.searches)) + `", "took": 1, "hits": {"total": {"value": ` +
+		strconv.Itoa(pitTestHits) + `}, "hits": [` + strings.Join(hits, ",") + `]}}`
+}
+
+func TestSearchAfter(t *testing.T) {
+	Convey("Given a client configured with UsePIT", t, func() {
+		transport := &pitTransport{}
+
+		client, err := NewClient(Config{
+			Host:      "mock",
+			Scheme:    "http",
+			Port:      mockPort,
+			Index:     "mock-*",
+			UsePIT:    true,
+			transport: transport,
+		})
+		So(err, ShouldBeNil)
+
+		query := &Query{Size: 3}
+
+		Convey("Scroll() pages through a point-in-time with search_after", func() {
+			var ids []string
+
+			result, err := client.Scroll(query, func(hit *Hit) {
+				ids = append(ids, hit.ID)
+			})
+			So(err, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, pitTestHits)
+			So(ids, ShouldResemble, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"})
+
+			So(transport.opened, ShouldEqual, 1)
+			So(len(transport.searches), ShouldEqual, 4)
+			So(transport.searches[0].PIT.ID, ShouldEqual, "pit0")
+			So(transport.searches[0].SearchAfter, ShouldBeNil)
+			So(transport.searches[0].Sort, ShouldResemble, defaultPITSort)
+			So(transport.searches[1].PIT.ID, ShouldEqual, "pit1")
+			So(string(transport.searches[1].SearchAfter), ShouldEqual, "[2,0]")
+			So(transport.closed, ShouldEqual, "pit4")
+
+			So(query.PIT, ShouldBeNil)
+			So(query.Sort, ShouldBeNil)
+		})
+
+		Convey("Scroll() without a callback returns all the hits", func() {
+			result, err := client.Scroll(query, nil)
+			So(err, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, pitTestHits)
+			So(result.HitSet.Hits[9].ID, ShouldEqual, "9")
+		})
+	})
+}
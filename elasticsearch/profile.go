@@ -0,0 +1,193 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+const ErrNotJSONObject = "not the end of a JSON object"
+
+// Phase is a part of answering a Query that is timed when profiling it; see
+// Query.EnableProfile().
+type Phase int
+
+const (
+	// PhaseIndexScan is the searching of a local database's index files.
+	PhaseIndexScan Phase = iota
+
+	// PhaseDataRead is the reading of hit (or rollup) data from a local
+	// database's data files.
+	PhaseDataRead
+
+	// PhaseDeserialize is the decoding of hit data in to Details.
+	PhaseDeserialize
+
+	// PhaseMarshal is the marshalling of a Result to JSON.
+	PhaseMarshal
+
+	numPhases
+)
+
+// Profile is a breakdown of where the time went in answering a Query, like
+// elasticsearch's profile API, returned in the "profile" section of results of
+// search bodies with "profile": true. Phases done in parallel have their times
+// summed, so they can add up to more than the result's took.
+type Profile struct {
+	IndexScanMS   float64 `json:"index_scan_ms"`
+	DataReadMS    float64 `json:"data_read_ms"`
+	DeserializeMS float64 `json:"deserialize_ms"`
+	MarshalMS     float64 `json:"marshal_ms"`
+	Cache         string  `json:"cache,omitempty"`
+}
+
+// profileTimings accumulates the nanoseconds spent in each Phase.
+type profileTimings [numPhases]atomic.Int64
+
+// EnableProfile sets Profile, and makes the query record the time spent in
+// each Phase given to TimePhase(), so that you can get its ProfileResult().
+// Queries made from a Request whose body has "profile": true have this
+// enabled.
+func (q *Query) EnableProfile() {
+	q.Profile = true
+
+	if q.timings == nil {
+		q.timings = &profileTimings{}
+	}
+}
+
+// Profiling returns true if EnableProfile() has been called.
+func (q *Query) Profiling() bool {
+	return q.timings != nil
+}
+
+// TimePhase adds the time since the given start to the given Phase, if we're
+// Profiling(). It is safe to call concurrently.
+func (q *Query) TimePhase(phase Phase, start time.Time) {
+	if q.timings == nil {
+		return
+	}
+
+	q.timings[phase].Add(int64(time.Since(start)))
+}
+
+// AddPhaseTime is like TimePhase(), but adds the given duration.
+func (q *Query) AddPhaseTime(phase Phase, d time.Duration) {
+	if q.timings == nil {
+		return
+	}
+
+	q.timings[phase].Add(int64(d))
+}
+
+// PhaseTimer splits the time spent in a loop between Phases, without the
+// overhead of updating its Query each iteration. A nil *PhaseTimer does
+// nothing.
+type PhaseTimer struct {
+	query *Query
+	last  time.Time
+	times [numPhases]time.Duration
+}
+
+// PhaseTimer returns a PhaseTimer started now, or nil if we're not
+// Profiling(). Call Stop() on it once done.
+func (q *Query) PhaseTimer() *PhaseTimer {
+	if q.timings == nil {
+		return nil
+	}
+
+	return &PhaseTimer{query: q, last: time.Now()}
+}
+
+// Mark adds the time since the last Mark() (or since we started) to the given
+// Phase.
+func (t *PhaseTimer) Mark(phase Phase) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	t.times[phase] += now.Sub(t.last)
+	t.last = now
+}
+
+// Stop adds the Mark()ed times to our Query.
+func (t *PhaseTimer) Stop() {
+	if t == nil {
+		return
+	}
+
+	for phase, d := range t.times {
+		if d > 0 {
+			t.query.AddPhaseTime(Phase(phase), d)
+		}
+	}
+}
+
+// ProfileResult returns the Profile of the time recorded so far, along with
+// the query's CacheStatus(). Returns nil if we're not Profiling().
+func (q *Query) ProfileResult() *Profile {
+	if q.timings == nil {
+		return nil
+	}
+
+	return &Profile{
+		IndexScanMS:   q.phaseMS(PhaseIndexScan),
+		DataReadMS:    q.phaseMS(PhaseDataRead),
+		DeserializeMS: q.phaseMS(PhaseDeserialize),
+		MarshalMS:     q.phaseMS(PhaseMarshal),
+		Cache:         q.cacheStatus,
+	}
+}
+
+func (q *Query) phaseMS(phase Phase) float64 {
+	return float64(q.timings[phase].Load()) / float64(time.Millisecond)
+}
+
+// AddTo returns the given JSON object, which must be non-empty (like the JSON
+// of a Result), or just the end of one, with a "profile" key of this Profile
+// added before its closing brace.
+func (p *Profile) AddTo(jsonObject []byte) ([]byte, error) {
+	end := bytes.LastIndexByte(jsonObject, '}')
+	if end < 0 {
+		return nil, Error{Msg: ErrNotJSONObject}
+	}
+
+	profile, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(jsonObject)+len(profile)+len(`,"profile":`))
+	out = append(out, jsonObject[:end]...)
+	out = append(out, `,"profile":`...)
+	out = append(out, profile...)
+
+	return append(out, jsonObject[end:]...), nil
+}
@@ -0,0 +1,127 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProfile(t *testing.T) {
+	body := `{"size":0,"profile":true,"query":{"bool":{"filter":[
+		{"match_phrase":{"BOM":"Human Genetics"}},
+		{"range":{"timestamp":{"gte":"2024-02-04T00:00:00Z","lt":"2024-02-05T00:00:00Z"}}}
+	]}}}`
+
+	Convey("Queries from requests with profile in the body are Profiling()", t, func() {
+		query, ok := NewQuery(httptest.NewRequest(http.MethodPost, "/index/"+SearchPage, strings.NewReader(body)))
+		So(ok, ShouldBeTrue)
+		So(query.Profiling(), ShouldBeTrue)
+
+		query, ok = NewQuery(httptest.NewRequest(http.MethodPost, "/index/"+SearchPage,
+			strings.NewReader(strings.Replace(body, `"profile":true,`, "", 1))))
+		So(ok, ShouldBeTrue)
+		So(query.Profiling(), ShouldBeFalse)
+		So(query.ProfileResult(), ShouldBeNil)
+
+		Convey("but Profile doesn't change their Key() or what is sent to elasticsearch", func() {
+			unprofiled := query.Key()
+
+			query.EnableProfile()
+			So(query.Profile, ShouldBeTrue)
+			So(query.Key(), ShouldEqual, unprofiled)
+
+			r, err := query.asBody()
+			So(err, ShouldBeNil)
+
+			sent, err := io.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(string(sent), ShouldNotContainSubstring, "profile")
+		})
+	})
+
+	Convey("Profiling() queries record time spent in each Phase", t, func() {
+		query := &Query{}
+		query.TimePhase(PhaseIndexScan, time.Now().Add(-time.Second))
+		So(query.ProfileResult(), ShouldBeNil)
+
+		query.EnableProfile()
+		query.SetCached(false)
+		query.AddPhaseTime(PhaseIndexScan, 2*time.Millisecond)
+		query.TimePhase(PhaseIndexScan, time.Now().Add(-time.Millisecond))
+
+		timer := query.PhaseTimer()
+		time.Sleep(time.Millisecond)
+		timer.Mark(PhaseDataRead)
+		timer.Mark(PhaseDeserialize)
+		timer.Stop()
+
+		profile := query.ProfileResult()
+		So(profile.IndexScanMS, ShouldBeGreaterThanOrEqualTo, 3)
+		So(profile.DataReadMS, ShouldBeGreaterThanOrEqualTo, 1)
+		So(profile.DeserializeMS, ShouldBeLessThan, profile.DataReadMS)
+		So(profile.MarshalMS, ShouldEqual, 0)
+		So(profile.Cache, ShouldEqual, CacheMiss)
+
+		Convey("which can be added to JSON objects", func() {
+			profiled, err := profile.AddTo([]byte(`{"took":1}`))
+			So(err, ShouldBeNil)
+
+			result := struct {
+				Took    int      `json:"took"`
+				Profile *Profile `json:"profile"`
+			}{}
+
+			So(json.Unmarshal(profiled, &result), ShouldBeNil)
+			So(result.Took, ShouldEqual, 1)
+			So(result.Profile, ShouldResemble, profile)
+
+			end, err := profile.AddTo([]byte("}"))
+			So(err, ShouldBeNil)
+			So(string(end), ShouldStartWith, `,"profile":{`)
+			So(string(end), ShouldEndWith, "}}")
+
+			_, err = profile.AddTo([]byte("[]"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("A nil PhaseTimer does nothing", t, func() {
+		timer := (&Query{}).PhaseTimer()
+		So(timer, ShouldBeNil)
+		So(func() {
+			timer.Mark(PhaseMarshal)
+			timer.Stop()
+		}, ShouldNotPanic)
+	})
+}
@@ -28,7 +28,9 @@ package elasticsearch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -36,6 +38,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgryski/go-farm"
@@ -43,18 +46,41 @@ import (
 
 const (
 	ErrNoTimestampRange = "no timestamp range found"
+	ErrUnsupportedRange = "unsupported timestamp range"
 	MaxSize             = 10000
 	SearchPage          = "_search"
+	CountPage           = "_count"
+	MultiSearchPage     = "_msearch"
+
+	// CacheHit is the CacheStatus() of a Query answered from a cache.
+	CacheHit = "hit"
+
+	// CacheMiss is the CacheStatus() of a Query that a cache had to answer by
+	// querying its underlying source.
+	CacheMiss = "miss"
 )
 
 // Query describes the search query you wish to run against Elastic Search.
 type Query struct {
-	Size           int          `json:"size"`
-	Aggs           *Aggs        `json:"aggs,omitempty"`
-	Query          *QueryFilter `json:"query,omitempty"`
-	Sort           []string     `json:"sort,omitempty"`
-	Source         []string     `json:"_source,omitempty"`
-	ScrollParamSet bool         `json:"_scroll,omitempty"`
+	Size           int             `json:"size"`
+	Aggs           *Aggs           `json:"aggs,omitempty"`
+	Query          *QueryFilter    `json:"query,omitempty"`
+	Sort           []string        `json:"sort,omitempty"`
+	Source         []string        `json:"_source,omitempty"`
+	SourceExcludes []string        `json:"-"`
+	ScrollParamSet bool            `json:"_scroll,omitempty"`
+	Slice          *Slice          `json:"slice,omitempty"`
+	PIT            *PIT            `json:"pit,omitempty"`
+	SearchAfter    json.RawMessage `json:"search_after,omitempty"`
+	TrackTotalHits json.RawMessage `json:"track_total_hits,omitempty"`
+	Profile        bool            `json:"profile,omitempty"`
+
+	ctx         context.Context
+	warnings    []string
+	cacheStatus string
+	scanned     int64
+	refresh     bool
+	timings     *profileTimings
 }
 
 // Aggs is used to specify an aggregation query.
@@ -63,9 +89,11 @@ type Aggs struct {
 }
 
 type AggsStats struct {
-	MultiTerms *MultiTerms          `json:"multi_terms,omitempty"`
-	Terms      *Field               `json:"terms,omitempty"`
-	Aggs       map[string]AggsField `json:"aggs,omitempty"`
+	MultiTerms  *MultiTerms          `json:"multi_terms,omitempty"`
+	Terms       *Field               `json:"terms,omitempty"`
+	Stats       *Field               `json:"stats,omitempty"`
+	Percentiles *Percentiles         `json:"percentiles,omitempty"`
+	Aggs        map[string]AggsField `json:"aggs,omitempty"`
 }
 
 type MultiTerms struct {
@@ -73,6 +101,15 @@ type MultiTerms struct {
 	Size  int     `json:"size"`
 }
 
+// Percentiles specifies a percentiles aggregation. Percents defaults to
+// elasticsearch's default percents. Keyed, if false, asks for the values to be
+// returned as an array instead of an object.
+type Percentiles struct {
+	Field    string    `json:"field"`
+	Percents []float64 `json:"percents,omitempty"`
+	Keyed    *bool     `json:"keyed,omitempty"`
+}
+
 type Field struct {
 	Field string `json:"field"`
 	Size  int    `json:"size,omitempty"`
@@ -134,18 +171,47 @@ func (m MapStringStringOrMap) GetMapString(key, subKey string) string {
 	return subKeyInterface[subKey]
 }
 
+// getMap returns the map with the given key in this map, or nil if there
+// isn't one.
+func (m MapStringStringOrMap) getMap(key string) map[string]interface{} {
+	switch v := m[key].(type) {
+	case map[string]interface{}:
+		return v
+	case MapStringStringOrMap:
+		return v
+	case map[string]string:
+		converted := make(map[string]interface{}, len(v))
+		for k, str := range v {
+			converted[k] = str
+		}
+
+		return converted
+	}
+
+	return nil
+}
+
 type Filter []map[string]MapStringStringOrMap
 
 // NewQuery looks at the given Request method, path, body and parameters to see
 // if it's a search request, and converts it to a Query if so. The booleon will
 // be false if not.
 func NewQuery(req *http.Request) (*Query, bool) {
+	return newQueryForPage(req, SearchPage)
+}
+
+// NewCountQuery is like NewQuery, but for requests to the _count page.
+func NewCountQuery(req *http.Request) (*Query, bool) {
+	return newQueryForPage(req, CountPage)
+}
+
+func newQueryForPage(req *http.Request, page string) (*Query, bool) {
 	if req.Method != http.MethodPost {
 		return nil, false
 	}
 
 	path := filepath.Base(req.URL.Path)
-	if path != SearchPage {
+	if path != page {
 		return nil, false
 	}
 
@@ -159,10 +225,169 @@ func NewQuery(req *http.Request) (*Query, bool) {
 	}
 
 	query.handleRequestParams((req.URL.Query()))
+	query.ctx = req.Context()
+	query.refresh = WantsRefresh(req.Header)
+
+	if query.Profile {
+		query.EnableProfile()
+	}
 
 	return query, true
 }
 
+// NewScrollQueries is for POST requests with a JSON array of search query
+// bodies, converting them to Queries that are all treated as scroll searches.
+// Any request parameters (eg. _source) are applied to all of them. The booleon
+// will be false if the request wasn't valid.
+func NewScrollQueries(req *http.Request) ([]*Query, bool) {
+	if req.Method != http.MethodPost || req.Body == nil {
+		return nil, false
+	}
+
+	var queries []*Query
+
+	if err := json.NewDecoder(req.Body).Decode(&queries); err != nil || len(queries) == 0 {
+		return nil, false
+	}
+
+	params := req.URL.Query()
+
+	for _, query := range queries {
+		if query == nil || query.Query == nil {
+			return nil, false
+		}
+
+		query.handleRequestParams(params)
+		query.ScrollParamSet = true
+		query.ctx = req.Context()
+		query.refresh = WantsRefresh(req.Header)
+	}
+
+	return queries, true
+}
+
+// multiSearchHeader is the header line that precedes each search query body
+// in a _msearch request. Index can be a string or an array of strings.
+type multiSearchHeader struct {
+	Index json.RawMessage `json:"index"`
+}
+
+// NewMultiSearchQueries is for POST requests to the _msearch page, which have
+// an NDJSON body of alternating header and search query lines. It returns the
+// queries if none of the headers name an index other than the given one. The
+// booleon will be false otherwise, or if the request wasn't valid.
+func NewMultiSearchQueries(req *http.Request, index string) ([]*Query, bool) {
+	if req.Method != http.MethodPost || req.Body == nil || filepath.Base(req.URL.Path) != MultiSearchPage {
+		return nil, false
+	}
+
+	var queries []*Query
+
+	dec := json.NewDecoder(req.Body)
+
+	for {
+		var header multiSearchHeader
+
+		err := dec.Decode(&header)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil || !header.isForIndex(index) {
+			return nil, false
+		}
+
+		query := &Query{}
+		if err = dec.Decode(query); err != nil {
+			return nil, false
+		}
+
+		query.ctx = req.Context()
+		query.refresh = WantsRefresh(req.Header)
+		queries = append(queries, query)
+	}
+
+	return queries, len(queries) > 0
+}
+
+// isForIndex returns true if this header doesn't specify an index, or only
+// specifies the given one.
+func (h multiSearchHeader) isForIndex(index string) bool {
+	if len(h.Index) == 0 || string(h.Index) == "null" {
+		return true
+	}
+
+	var single string
+	if json.Unmarshal(h.Index, &single) == nil {
+		return single == index
+	}
+
+	var multiple []string
+	if json.Unmarshal(h.Index, &multiple) == nil {
+		return len(multiple) == 1 && multiple[0] == index
+	}
+
+	return false
+}
+
+// Context returns the query's context. For queries made from a Request, this
+// is the Request's context, so it is cancelled when the client disconnects. For
+// other queries, it defaults to context.Background().
+func (q *Query) Context() context.Context {
+	if q.ctx != nil {
+		return q.ctx
+	}
+
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the query with its context changed to
+// the given one, which must not be nil.
+func (q *Query) WithContext(ctx context.Context) *Query {
+	q2 := *q
+	q2.ctx = ctx
+
+	return &q2
+}
+
+// AddWarning records that the answer to this query is in some way not what
+// was asked for, eg. because it could only be partially answered.
+func (q *Query) AddWarning(msg string) {
+	q.warnings = append(q.warnings, msg)
+}
+
+// Warnings returns the messages added with AddWarning().
+func (q *Query) Warnings() []string {
+	return q.warnings
+}
+
+// SetCached records whether this query was answered from a cache.
+func (q *Query) SetCached(cached bool) {
+	if cached {
+		q.cacheStatus = CacheHit
+	} else {
+		q.cacheStatus = CacheMiss
+	}
+}
+
+// CacheStatus returns CacheHit or CacheMiss if SetCached() was called, or
+// blank otherwise.
+func (q *Query) CacheStatus() string {
+	return q.cacheStatus
+}
+
+// SetScanned records how many index entries a local database looked at to
+// answer this query. It is safe to call concurrently, eg. when the same query
+// is answered by multiple goroutines.
+func (q *Query) SetScanned(n int64) {
+	atomic.StoreInt64(&q.scanned, n)
+}
+
+// Scanned returns the number set with SetScanned(), or 0 if not known.
+func (q *Query) Scanned() int64 {
+	return atomic.LoadInt64(&q.scanned)
+}
+
 func newQueryFromReader(raw io.Reader) (*Query, error) {
 	query := &Query{}
 	err := json.NewDecoder(raw).Decode(query)
@@ -179,9 +404,16 @@ func (q *Query) handleRequestParams(parms url.Values) {
 		}
 	}
 
-	sourceParam := parms.Get("_source")
-	if sourceParam != "" {
-		q.Source = strings.Split(sourceParam, ",")
+	q.handleSourceParams(parms.Get)
+
+	sortParam := parms.Get("sort")
+	if sortParam != "" {
+		q.Sort = strings.Split(sortParam, ",")
+	}
+
+	trackParam := parms.Get("track_total_hits")
+	if _, err := strconv.Atoi(trackParam); err == nil || trackParam == "true" || trackParam == "false" {
+		q.TrackTotalHits = json.RawMessage(trackParam)
 	}
 
 	scrollParam := parms.Get("scroll")
@@ -190,21 +422,45 @@ func (q *Query) handleRequestParams(parms url.Values) {
 	}
 }
 
+// TotalHitsLimit returns the number of hits up to which the query's
+// track_total_hits wants the total counted accurately, or 0 if that is unset,
+// true or false. Unlike elasticsearch, which defaults to a limit of 10000 and
+// omits the total when false, we always know the total when answering queries
+// ourselves, so only give a lower bound when a limit is explicitly asked for.
+func (q *Query) TotalHitsLimit() int {
+	limit, err := strconv.Atoi(string(q.TrackTotalHits))
+	if err != nil || limit < 0 {
+		return 0
+	}
+
+	return limit
+}
+
 // IsScroll returns true if the http.Request this Query was made from had a
 // scroll parameter.
 func (q *Query) IsScroll() bool {
 	return q.ScrollParamSet
 }
 
-// Key returns a string that is unique to this Query.
+// Key returns a string that is unique to this Query. Queries that only differ
+// in the order of their filter clauses, repeated clauses, or how the same
+// timestamp range is expressed, have the same Key.
 func (q *Query) Key() string {
-	queryBytes, _ := json.Marshal(q) //nolint:errcheck,errchkjson
+	queryBytes, _ := json.Marshal(q.canonical()) //nolint:errcheck,errchkjson
 	l, h := farm.Hash128(queryBytes)
 
 	return fmt.Sprintf("%016x%016x", l, h)
 }
 
+// asBody returns the JSON of the query to send to elasticsearch. Profile is
+// left out, since we profile queries ourselves.
 func (q *Query) asBody() (*bytes.Reader, error) {
+	if q.Profile {
+		q2 := *q
+		q2.Profile = false
+		q = &q2
+	}
+
 	queryBytes, err := json.Marshal(q)
 	if err != nil {
 		return nil, err
@@ -213,48 +469,101 @@ func (q *Query) asBody() (*bytes.Reader, error) {
 	return bytes.NewReader(queryBytes), nil
 }
 
-// DateRange looks at the query's range->timestamp and returns the lt, lte and
-// gte values. Returns an error if none were found.
-func (q *Query) DateRange() (lt, lte, gte time.Time, err error) { //nolint:gocognit,funlen,gocyclo
+// TimeRange is the timestamp range of a Query. Exactly one of GT and GTE, and
+// exactly one of LT and LTE, will be non-zero.
+type TimeRange struct {
+	GT  time.Time
+	GTE time.Time
+	LT  time.Time
+	LTE time.Time
+}
+
+// TimeRange looks at the query's range->timestamp and returns its bounds. Like
+// elasticsearch, values can be date math (eg. "now-30d/d") or epoch
+// milliseconds, and are parsed according to any "format" hint; see
+// parseRangeBound(). Returns an ErrNoTimestampRange Error if there's no range
+// on timestamp, and an ErrUnsupportedRange Error if it isn't bounded by one of
+// gt and gte, and one of lt and lte.
+func (q *Query) TimeRange() (TimeRange, error) {
+	var tr TimeRange
+
 	for _, val := range q.Query.Bool.Filter {
 		fRange, ok := val["range"]
 		if !ok {
 			continue
 		}
 
-		ltStr := fRange.GetMapString("timestamp", "lt")
-		lteStr := fRange.GetMapString("timestamp", "lte")
-
-		if ltStr == "" && lteStr == "" {
+		bounds := fRange.getMap("timestamp")
+		if len(bounds) == 0 {
 			continue
 		}
 
-		if ltStr != "" { //nolint:nestif
-			lt, err = time.Parse(time.RFC3339, ltStr)
-			if err != nil {
-				return lt, lte, gte, err
+		if err := checkRangeShape(bounds); err != nil {
+			return tr, err
+		}
+
+		format, _ := bounds["format"].(string) //nolint:errcheck
+
+		for _, b := range []struct {
+			op      string
+			t       *time.Time
+			roundUp bool
+		}{
+			{"gt", &tr.GT, true},
+			{"gte", &tr.GTE, false},
+			{"lt", &tr.LT, false},
+			{"lte", &tr.LTE, true},
+		} {
+			if !rangeBoundSet(bounds[b.op]) {
+				continue
 			}
-		} else {
-			lte, err = time.Parse(time.RFC3339, lteStr)
+
+			t, err := parseRangeBound(bounds[b.op], format, b.roundUp)
 			if err != nil {
-				return lt, lte, gte, err
+				return tr, err
 			}
-		}
 
-		gteStr := fRange.GetMapString("timestamp", "gte")
-		if gteStr == "" {
-			continue
+			*b.t = t
 		}
 
-		gte, err = time.Parse(time.RFC3339, gteStr)
-		if err != nil {
-			return lt, lte, gte, err
+		return tr, nil
+	}
+
+	return tr, Error{Msg: ErrNoTimestampRange}
+}
+
+// checkRangeShape returns an ErrUnsupportedRange Error unless the given range
+// bounds have exactly one of gt and gte, and exactly one of lt and lte.
+func checkRangeShape(bounds map[string]interface{}) error {
+	for _, pair := range [][2]string{{"gt", "gte"}, {"lt", "lte"}} {
+		exclusive, inclusive := rangeBoundSet(bounds[pair[0]]), rangeBoundSet(bounds[pair[1]])
+
+		switch {
+		case exclusive && inclusive:
+			return Error{Msg: ErrUnsupportedRange, cause: fmt.Sprintf("both %s and %s given", pair[0], pair[1])}
+		case !exclusive && !inclusive:
+			return Error{Msg: ErrUnsupportedRange, cause: fmt.Sprintf("one of %s or %s is required", pair[0], pair[1])}
 		}
+	}
+
+	return nil
+}
 
+// DateRange is like TimeRange(), but returns the lt, lte and gte values. A gt
+// value is returned as the equivalent gte, given that hit timestamps are whole
+// seconds.
+func (q *Query) DateRange() (lt, lte, gte time.Time, err error) {
+	tr, err := q.TimeRange()
+	if err != nil {
 		return lt, lte, gte, err
 	}
 
-	return lt, lte, gte, Error{Msg: ErrNoTimestampRange}
+	gte = tr.GTE
+	if !tr.GT.IsZero() {
+		gte = tr.GT.Truncate(time.Second).Add(time.Second)
+	}
+
+	return tr.LT, tr.LTE, gte, nil
 }
 
 // Filters returns a combination of MatchFilters() and PrefixFilters().
@@ -309,7 +618,7 @@ func (q *Query) PrefixFilters() map[string]string {
 	return filters
 }
 
-type Fields uint32
+type Fields uint64
 
 const (
 	FieldAccountingName Fields = 1 << iota
@@ -330,61 +639,164 @@ const (
 	FieldWastedMBSeconds
 	FieldRawWastedCPUSeconds
 	FieldRawWastedMBSeconds
+	FieldAvgMemEfficiencyPercent
+	FieldAvrgMemUsageMB
+	FieldAvrgMemUsageMBSecCooked
+	FieldAvrgMemUsageMBSecRaw
+	FieldClusterName
+	FieldCookedCPUTimeSec
+	FieldEndTime
+	FieldExecHostname
+	FieldExitInfo
+	FieldExitReason
+	FieldJobID
+	FieldJobArrayIndex
+	FieldJobExitStatus
+	FieldJobEfficiencyPercent
+	FieldJobEfficiencyRawPercent
+	FieldMaxMemEfficiencyPercent
+	FieldMaxMemUsageMB
+	FieldMaxMemUsageMBSecCooked
+	FieldMaxMemUsageMBSecRaw
+	FieldNumberOfHosts
+	FieldNumberOfUniqueHosts
+	FieldProjectName
+	FieldRawAvgMemEfficiencyPercent
+	FieldRawCPUTimeSec
+	FieldRawMaxMemEfficiencyPercent
+	FieldSubmitTime
+
+	// FieldNone is the DesiredFields() of a Query that wants none of our
+	// fields, eg. because its _source is false.
+	FieldNone Fields = 1 << 63
 )
 
-// DesiredFields returns a Fields bitmask value with all our Source values set.
-// Call eg. WantsField(value, FieldAccountingName) to see if the returned value
-// and thus this Query had a Source entry "ACCOUNTING_NAME".
+// DesiredFields returns a Fields bitmask value with all the fields our Source
+// and SourceExcludes select set. Call eg. WantsField(value,
+// FieldAccountingName) to see if the returned value and thus this Query wanted
+// the _source field "ACCOUNTING_NAME". Source and SourceExcludes entries can
+// contain * wildcards.
 //
-// If no Source values are set, this returns a 0 value which will be treated by
-// WantsField() as wanting all fields.
-func (q *Query) DesiredFields() Fields { //nolint:funlen,gocyclo,cyclop
+// If no Source or SourceExcludes values are set, this returns a 0 value which
+// will be treated by WantsField() as wanting all fields. If they select no
+// fields, this returns FieldNone.
+func (q *Query) DesiredFields() Fields {
+	if len(q.Source) == 0 && len(q.SourceExcludes) == 0 {
+		return 0
+	}
+
 	var f Fields
 
-	for _, field := range q.Source {
-		switch field {
-		case "ACCOUNTING_NAME":
-			f |= FieldAccountingName
-		case "AVAIL_CPU_TIME_SEC":
-			f |= FieldAvailCPUTimeSec
-		case "BOM":
-			f |= FieldBOM
-		case "Command":
-			f |= FieldCommand
-		case "JOB_NAME":
-			f |= FieldJobName
-		case "Job":
-			f |= FieldJob
-		case "MEM_REQUESTED_MB":
-			f |= FieldMemRequestedMB
-		case "MEM_REQUESTED_MB_SEC":
-			f |= FieldMemRequestedMBSec
-		case "NUM_EXEC_PROCS":
-			f |= FieldNumExecProcs
-		case "PENDING_TIME_SEC":
-			f |= FieldPendingTimeSec
-		case "QUEUE_NAME":
-			f |= FieldQueueName
-		case "RUN_TIME_SEC":
-			f |= FieldRunTimeSec
-		case "timestamp":
-			f |= FieldTimestamp
-		case "USER_NAME":
-			f |= FieldUserName
-		case "WASTED_CPU_SECONDS":
-			f |= FieldWastedCPUSeconds
-		case "WASTED_MB_SECONDS":
-			f |= FieldWastedMBSeconds
-		case "RAW_WASTED_CPU_SECONDS":
-			f |= FieldRawWastedCPUSeconds
-		case "RAW_WASTED_MB_SECONDS":
-			f |= FieldRawWastedMBSeconds
+	for _, name := range SourceFields() {
+		if q.wantsSourceField(name) {
+			f |= sourceField(name)
 		}
 	}
 
+	if f == 0 {
+		return FieldNone
+	}
+
 	return f
 }
 
+// sourceField returns the Fields flag corresponding to the given _source field
+// name, or 0 if it isn't one we know about.
+func sourceField(name string) Fields { //nolint:funlen,gocyclo,cyclop
+	switch name {
+	case "ACCOUNTING_NAME":
+		return FieldAccountingName
+	case "AVAIL_CPU_TIME_SEC":
+		return FieldAvailCPUTimeSec
+	case "BOM":
+		return FieldBOM
+	case "Command":
+		return FieldCommand
+	case "JOB_NAME":
+		return FieldJobName
+	case "Job":
+		return FieldJob
+	case "MEM_REQUESTED_MB":
+		return FieldMemRequestedMB
+	case "MEM_REQUESTED_MB_SEC":
+		return FieldMemRequestedMBSec
+	case "NUM_EXEC_PROCS":
+		return FieldNumExecProcs
+	case "PENDING_TIME_SEC":
+		return FieldPendingTimeSec
+	case "QUEUE_NAME":
+		return FieldQueueName
+	case "RUN_TIME_SEC":
+		return FieldRunTimeSec
+	case "timestamp":
+		return FieldTimestamp
+	case "USER_NAME":
+		return FieldUserName
+	case "WASTED_CPU_SECONDS":
+		return FieldWastedCPUSeconds
+	case "WASTED_MB_SECONDS":
+		return FieldWastedMBSeconds
+	case "RAW_WASTED_CPU_SECONDS":
+		return FieldRawWastedCPUSeconds
+	case "RAW_WASTED_MB_SECONDS":
+		return FieldRawWastedMBSeconds
+	case "AVG_MEM_EFFICIENCY_PERCENT":
+		return FieldAvgMemEfficiencyPercent
+	case "AVRG_MEM_USAGE_MB":
+		return FieldAvrgMemUsageMB
+	case "AVRG_MEM_USAGE_MB_SEC_COOKED":
+		return FieldAvrgMemUsageMBSecCooked
+	case "AVRG_MEM_USAGE_MB_SEC_RAW":
+		return FieldAvrgMemUsageMBSecRaw
+	case "CLUSTER_NAME":
+		return FieldClusterName
+	case "COOKED_CPU_TIME_SEC":
+		return FieldCookedCPUTimeSec
+	case "END_TIME":
+		return FieldEndTime
+	case "EXEC_HOSTNAME":
+		return FieldExecHostname
+	case "Exit_Info":
+		return FieldExitInfo
+	case "Exitreason":
+		return FieldExitReason
+	case "JOB_ID":
+		return FieldJobID
+	case "JOB_ARRAY_INDEX":
+		return FieldJobArrayIndex
+	case "JOB_EXIT_STATUS":
+		return FieldJobExitStatus
+	case "Job_Efficiency_Percent":
+		return FieldJobEfficiencyPercent
+	case "Job_Efficiency_Raw_Percent":
+		return FieldJobEfficiencyRawPercent
+	case "MAX_MEM_EFFICIENCY_PERCENT":
+		return FieldMaxMemEfficiencyPercent
+	case "MAX_MEM_USAGE_MB":
+		return FieldMaxMemUsageMB
+	case "MAX_MEM_USAGE_MB_SEC_COOKED":
+		return FieldMaxMemUsageMBSecCooked
+	case "MAX_MEM_USAGE_MB_SEC_RAW":
+		return FieldMaxMemUsageMBSecRaw
+	case "NumberOfHosts":
+		return FieldNumberOfHosts
+	case "NumberOfUniqueHosts":
+		return FieldNumberOfUniqueHosts
+	case "PROJECT_NAME":
+		return FieldProjectName
+	case "RAW_AVG_MEM_EFFICIENCY_PERCENT":
+		return FieldRawAvgMemEfficiencyPercent
+	case "RAW_CPU_TIME_SEC":
+		return FieldRawCPUTimeSec
+	case "RAW_MAX_MEM_EFFICIENCY_PERCENT":
+		return FieldRawMaxMemEfficiencyPercent
+	case "SUBMIT_TIME":
+		return FieldSubmitTime
+	}
+
+	return 0
+}
+
 // WantsField takes the output of Query.DesiredFields() and sees if the given
 // field from amongst our Fields* flags is one of the desired fields.
 //
@@ -26,6 +26,7 @@
 package elasticsearch
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"testing"
@@ -137,6 +138,89 @@ func TestQuery(t *testing.T) {
 		So(key6, ShouldNotBeBlank)
 		So(key6, ShouldNotEqual, key5)
 		So(query.IsScroll(), ShouldBeTrue)
+
+		req, err = http.NewRequest(http.MethodPost, url, //nolint:noctx
+			strings.NewReader("["+testNonAggQuery+","+testScollQueryManyHits+"]"))
+		So(err, ShouldBeNil)
+
+		queries, madeQueries := NewScrollQueries(req)
+		So(madeQueries, ShouldBeTrue)
+		So(len(queries), ShouldEqual, 2)
+		So(queries[0].Key(), ShouldEqual, key6)
+		So(queries[1].IsScroll(), ShouldBeTrue)
+		So(queries[1].Source, ShouldResemble, []string{"USER_NAME", "QUEUE_NAME"})
+
+		Convey("Queries made from requests have the request's context", func() {
+			type ctxKey string
+
+			ctx := context.WithValue(context.Background(), ctxKey("k"), "v")
+
+			req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(testNonAggQuery))
+			So(err, ShouldBeNil)
+
+			query, madeQuery = NewQuery(req)
+			So(madeQuery, ShouldBeTrue)
+			So(query.Context().Value(ctxKey("k")), ShouldEqual, "v")
+			So(queries[0].Context(), ShouldNotBeNil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			withCtx := query.WithContext(ctx)
+			So(withCtx.Context().Err(), ShouldEqual, context.Canceled)
+			So(query.Context().Err(), ShouldBeNil)
+			So(withCtx.Key(), ShouldEqual, query.Key())
+			So((&Query{}).Context(), ShouldEqual, context.Background())
+		})
+
+		for _, badBody := range []string{testNonAggQuery, "[]", "[{}]"} {
+			req, err = http.NewRequest(http.MethodPost, url, strings.NewReader(badBody)) //nolint:noctx
+			So(err, ShouldBeNil)
+
+			_, madeQueries = NewScrollQueries(req)
+			So(madeQueries, ShouldBeFalse)
+		}
+	})
+
+	Convey("You can make Queries from _msearch requests", t, func() {
+		index := "some-indexes-*"
+		url := "http://localhost/" + index + "/" + MultiSearchPage
+
+		msearch := func(body string) ([]*Query, bool) {
+			req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body)) //nolint:noctx
+			So(err, ShouldBeNil)
+
+			return NewMultiSearchQueries(req, index)
+		}
+
+		queries, ok := msearch("{}\n" + testAggQuery + "\n" +
+			`{"index":"` + index + `"}` + "\n" + testScollQueryManyHits + "\n" +
+			`{"index":["` + index + `"],"preference":"x"}` + "\n{}\n")
+		So(ok, ShouldBeTrue)
+		So(len(queries), ShouldEqual, 3)
+		So(queries[0].Aggs, ShouldNotBeNil)
+		So(queries[1].Aggs, ShouldBeNil)
+		So(queries[1].Size, ShouldEqual, MaxSize)
+		So(queries[1].IsScroll(), ShouldBeFalse)
+		So(queries[2].Query, ShouldBeNil)
+
+		for _, badBody := range []string{
+			"",
+			"{}\n",
+			"{}\n{",
+			`{"index":"other"}` + "\n{}\n",
+			`{"index":["` + index + `","other"]}` + "\n{}\n",
+			`{"index":1}` + "\n{}\n",
+		} {
+			_, ok = msearch(badBody)
+			So(ok, ShouldBeFalse)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, url, strings.NewReader("{}\n{}\n")) //nolint:noctx
+		So(err, ShouldBeNil)
+
+		_, ok = NewMultiSearchQueries(req, index)
+		So(ok, ShouldBeFalse)
 	})
 
 	manualQuery := &Query{
@@ -192,6 +276,32 @@ func TestQuery(t *testing.T) {
 		So(lt, ShouldEqual, expectedLTE)
 		So(lte.IsZero(), ShouldBeTrue)
 		So(gte, ShouldEqual, expectedGTE)
+
+		gtQuery := `{"query":{"bool":{"filter":[{"range":{"timestamp":{"lt":"2024-05-04T00:10:00Z","gt":"2024-05-04T00:00:00Z"}}}]}}}` //nolint:lll
+		query, err = newQueryFromReader(strings.NewReader(gtQuery))
+		So(err, ShouldBeNil)
+
+		tr, err := query.TimeRange()
+		So(err, ShouldBeNil)
+		So(tr, ShouldResemble, TimeRange{GT: expectedGTE, LT: expectedLTE})
+
+		_, _, gte, err = query.DateRange()
+		So(err, ShouldBeNil)
+		So(gte, ShouldEqual, expectedGTE.Add(time.Second))
+
+		for _, bounds := range []string{
+			`"gt":"2024-05-04T00:00:00Z","gte":"2024-05-04T00:00:00Z","lt":"2024-05-04T00:10:00Z"`,
+			`"gte":"2024-05-04T00:00:00Z","lt":"2024-05-04T00:10:00Z","lte":"2024-05-04T00:10:00Z"`,
+			`"lt":"2024-05-04T00:10:00Z"`,
+			`"gte":"2024-05-04T00:00:00Z"`,
+		} {
+			query, err = newQueryFromReader(strings.NewReader(`{"query":{"bool":{"filter":[{"range":{"timestamp":{` + bounds + `}}}]}}}`)) //nolint:lll
+			So(err, ShouldBeNil)
+
+			_, err = query.TimeRange()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrUnsupportedRange)
+		}
 	})
 
 	Convey("You can get the filters from a Query", t, func() {
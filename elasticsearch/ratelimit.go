@@ -0,0 +1,87 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimitedTransport is an http.RoundTripper that spaces out the requests
+// it makes so that no more than a certain number are made per second.
+type rateLimitedTransport struct {
+	next     http.RoundTripper
+	interval time.Duration
+
+	mu      sync.Mutex
+	nextReq time.Time
+}
+
+// newRateLimitedTransport returns a rateLimitedTransport that makes at most
+// perSecond requests a second using the given RoundTripper, or
+// http.DefaultTransport if that is nil.
+func newRateLimitedTransport(next http.RoundTripper, perSecond float64) *rateLimitedTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &rateLimitedTransport{
+		next:     next,
+		interval: time.Duration(float64(time.Second) / perSecond),
+	}
+}
+
+// RoundTrip waits for our next request slot, or for the request's context to
+// be done, then makes the request.
+func (r *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timer := time.NewTimer(r.reserve())
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	return r.next.RoundTrip(req)
+}
+
+// reserve reserves the next request slot, returning how long to wait until it.
+func (r *rateLimitedTransport) reserve() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.nextReq.Before(now) {
+		r.nextReq = now
+	}
+
+	wait := r.nextReq.Sub(now)
+	r.nextReq = r.nextReq.Add(r.interval)
+
+	return wait
+}
@@ -0,0 +1,76 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type countingTransport struct {
+	n int
+}
+
+func (c *countingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	c.n++
+
+	return &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	Convey("A rateLimitedTransport spaces out requests", t, func() {
+		counter := &countingTransport{}
+		rlt := newRateLimitedTransport(counter, 100)
+		start := time.Now()
+
+		for range 5 {
+			resp, err := rlt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		}
+
+		So(counter.n, ShouldEqual, 5)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+
+		Convey("but gives up waiting if the request is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			rlt = newRateLimitedTransport(counter, 0.1)
+			_, err := rlt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+			So(err, ShouldBeNil)
+
+			_, err = rlt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+			So(err, ShouldEqual, context.Canceled)
+			So(counter.n, ShouldEqual, 6)
+		})
+	})
+}
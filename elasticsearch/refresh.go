@@ -0,0 +1,69 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package elasticsearch
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RefreshHeader is a custom request header that, when set to a true value
+// (eg. "1" or "true"), asks for a query's cached result to be ignored and
+// replaced with a freshly computed one.
+const RefreshHeader = "X-Farmer-Refresh"
+
+// WantsRefresh returns true if the given request headers ask for any cached
+// answer to be bypassed: that is if Cache-Control includes no-cache or
+// max-age=0, or RefreshHeader is true.
+func WantsRefresh(header http.Header) bool {
+	if refresh, err := strconv.ParseBool(header.Get(RefreshHeader)); err == nil && refresh {
+		return true
+	}
+
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+
+			if directive == "no-cache" || directive == "max-age=0" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SetRefresh records whether any cached answer to this query should be
+// ignored, and replaced with a new one.
+func (q *Query) SetRefresh(refresh bool) {
+	q.refresh = refresh
+}
+
+// Refresh returns the value set with SetRefresh(). Queries made from a Request
+// have it set according to WantsRefresh() of the Request's headers.
+func (q *Query) Refresh() bool {
+	return q.refresh
+}
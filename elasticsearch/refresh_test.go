@@ -0,0 +1,81 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package elasticsearch
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRefresh(t *testing.T) {
+	Convey("WantsRefresh understands Cache-Control and X-Farmer-Refresh headers", t, func() {
+		for _, test := range []struct {
+			name, value string
+			expected    bool
+		}{
+			{"Cache-Control", "no-cache", true},
+			{"Cache-Control", "max-age=60, No-Cache", true},
+			{"Cache-Control", "max-age=0", true},
+			{"Cache-Control", "max-age=60", false},
+			{"Cache-Control", "no-store", false},
+			{RefreshHeader, "1", true},
+			{RefreshHeader, "true", true},
+			{RefreshHeader, "false", false},
+			{RefreshHeader, "maybe", false},
+		} {
+			header := http.Header{}
+			header.Set(test.name, test.value)
+			So(WantsRefresh(header), ShouldEqual, test.expected)
+		}
+
+		So(WantsRefresh(http.Header{}), ShouldBeFalse)
+	})
+
+	Convey("Queries made from a Request have Refresh set from its headers", t, func() {
+		body := `{"query":{"bool":{"filter":[{"match_phrase":{"META_CLUSTER_NAME":"farm"}}]}}}`
+
+		req, err := http.NewRequest(http.MethodPost, "/index/"+SearchPage, strings.NewReader(body))
+		So(err, ShouldBeNil)
+
+		query, ok := NewQuery(req)
+		So(ok, ShouldBeTrue)
+		So(query.Refresh(), ShouldBeFalse)
+
+		req, err = http.NewRequest(http.MethodPost, "/index/"+SearchPage, strings.NewReader(body))
+		So(err, ShouldBeNil)
+		req.Header.Set(RefreshHeader, "1")
+
+		query, ok = NewQuery(req)
+		So(ok, ShouldBeTrue)
+		So(query.Refresh(), ShouldBeTrue)
+		So(query.WithContext(req.Context()).Refresh(), ShouldBeTrue)
+
+		query.SetRefresh(false)
+		So(query.Refresh(), ShouldBeFalse)
+	})
+}
@@ -27,9 +27,12 @@
 package elasticsearch
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/deneonet/benc"
 	"github.com/deneonet/benc/bstd"
@@ -42,6 +45,18 @@ const (
 	headTailLen         = (maxFieldLength / 2) - (len(truncationIndicator) / 2) //nolint:mnd
 
 	MaxEncodedDetailsLength = 16 * 1024
+
+	// SerializationVersion is the version of the Details.Serialize() format,
+	// recorded in the first byte of each serialized Details. It is incremented
+	// whenever the fields of Details change. Version 1 had only the fields up
+	// to and including RAW_WASTED_MB_SECONDS, and no version byte.
+	SerializationVersion = 2
+
+	// versionFlag is set on the version byte, so it can't be mistaken for the
+	// first byte of a version 1 serialization.
+	versionFlag byte = 0x80
+
+	ErrUnknownSerializationVersion = "unknown serialization version"
 )
 
 // Error is an error type that has a Msg with one of our const Err* messages.
@@ -64,6 +79,7 @@ const ErrFailedQuery = "elasticsearch query failed"
 // Result holds the results of a search query.
 type Result struct {
 	ScrollID     string        `json:"_scroll_id,omitempty"`
+	PITID        string        `json:"pit_id,omitempty"`
 	Took         int           `json:"took"`
 	TimedOut     bool          `json:"timed_out"`
 	HitSet       *HitSet       `json:"hits"`
@@ -71,6 +87,41 @@ type Result struct {
 	PoolKey      int           `json:"-"`
 }
 
+// CountResult holds the results of a count query.
+type CountResult struct {
+	Count  int    `json:"count"`
+	Shards Shards `json:"_shards"`
+}
+
+// Shards describes the shards involved in a query.
+type Shards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+// NewCountResult returns a CountResult for the given count, claiming to come
+// from a single successful shard.
+func NewCountResult(count int) *CountResult {
+	return &CountResult{
+		Count:  count,
+		Shards: Shards{Total: 1, Successful: 1},
+	}
+}
+
+// SetLocalStats sets our Took to the milliseconds elapsed since the given
+// start, and limits our total according to the query's track_total_hits (see
+// Query.TotalHitsLimit()). For use on Results we create ourselves instead of
+// getting from elasticsearch.
+func (r *Result) SetLocalStats(query *Query, start time.Time) {
+	r.Took = int(time.Since(start).Milliseconds())
+
+	if r.HitSet != nil {
+		r.HitSet.Total.Limit(query.TotalHitsLimit())
+	}
+}
+
 // NewResult returns a Result with an empty HitSet in it, suitable for adding
 // hits and errors to.
 func NewResult() *Result {
@@ -86,78 +137,121 @@ type HitSet struct {
 	Hits  []Hit       `json:"hits"`
 }
 
+// HitSetTotal is the number of matching documents. A Relation of RelationGte
+// means Value is only a lower bound; blank means RelationEq.
 type HitSetTotal struct {
-	Value int `json:"value"`
+	Value    int    `json:"value"`
+	Relation string `json:"relation,omitempty"`
+}
+
+const (
+	RelationEq  = "eq"
+	RelationGte = "gte"
+)
+
+// IsLowerBound returns true if our Value is only a lower bound of the total.
+func (t HitSetTotal) IsLowerBound() bool {
+	return t.Relation == RelationGte
+}
+
+// Limit makes us a lower bound of at most the given number of hits if we're
+// greater, like elasticsearch's track_total_hits. A limit of 0 does nothing.
+func (t *HitSetTotal) Limit(limit int) {
+	if limit > 0 && t.Value > limit {
+		t.Value = limit
+		t.Relation = RelationGte
+	}
+}
+
+// Add adds the given total to ours, such that we become a lower bound if it is.
+func (t *HitSetTotal) Add(other HitSetTotal) {
+	t.Value += other.Value
+
+	if other.IsLowerBound() {
+		t.Relation = RelationGte
+	}
 }
 
 type Hit struct {
-	ID      string   `json:"_id,omitempty"`
-	Details *Details `json:"_source"`
+	ID      string          `json:"_id,omitempty"`
+	Details *Details        `json:"_source"`
+	Sort    json.RawMessage `json:"sort,omitempty"`
+	// Cluster is the META_CLUSTER_NAME that the hit was queried by, if known.
+	// It isn't part of the hit's JSON, since Details don't store it.
+	Cluster string `json:"-"`
 }
 
 // Details holds the document information of a Hit.
 type Details struct {
-	ID                  string  `json:"_id"`
-	AccountingName      string  `json:"ACCOUNTING_NAME"`
-	AvailCPUTimeSec     int64   `json:"AVAIL_CPU_TIME_SEC"`
-	BOM                 string  `json:"BOM"`
-	Command             string  `json:"Command"`
-	JobName             string  `json:"JOB_NAME"`
-	Job                 string  `json:"Job"`
-	MemRequestedMB      int64   `json:"MEM_REQUESTED_MB"`
-	MemRequestedMBSec   int64   `json:"MEM_REQUESTED_MB_SEC"`
-	NumExecProcs        int64   `json:"NUM_EXEC_PROCS"`
-	PendingTimeSec      int64   `json:"PENDING_TIME_SEC"`
-	QueueName           string  `json:"QUEUE_NAME"`
-	RunTimeSec          int64   `json:"RUN_TIME_SEC"`
-	Timestamp           int64   `json:"timestamp"`
-	UserName            string  `json:"USER_NAME"`
-	WastedCPUSeconds    float64 `json:"WASTED_CPU_SECONDS"`
-	WastedMBSeconds     float64 `json:"WASTED_MB_SECONDS"`
-	RawWastedCPUSeconds float64 `json:"RAW_WASTED_CPU_SECONDS"`
-	RawWastedMBSeconds  float64 `json:"RAW_WASTED_MB_SECONDS"`
-	// AVG_MEM_EFFICIENCY_PERCENT     float64
-	// AVRG_MEM_USAGE_MB              float64
-	// AVRG_MEM_USAGE_MB_SEC_COOKED   float64
-	// AVRG_MEM_USAGE_MB_SEC_RAW      float64
-	// CLUSTER_NAME                   string
-	// COOKED_CPU_TIME_SEC            float64
-	// END_TIME                       int
-	// EXEC_HOSTNAME                  []string
-	// Exit_Info                      int
-	// Exitreason                     string
-	// JOB_ID          int
-	// JOB_ARRAY_INDEX int
-	// JOB_EXIT_STATUS                int
-	// Job_Efficiency_Percent         float64
-	// Job_Efficiency_Raw_Percent     float64
-	// MAX_MEM_EFFICIENCY_PERCENT     float64
-	// MAX_MEM_USAGE_MB               float64
-	// MAX_MEM_USAGE_MB_SEC_COOKED    float64
-	// MAX_MEM_USAGE_MB_SEC_RAW       float64
-	// NumberOfHosts                  int
-	// NumberOfUniqueHosts            int
-	// PROJECT_NAME                   string
-	// RAW_AVG_MEM_EFFICIENCY_PERCENT float64
-	// RAW_CPU_TIME_SEC               float64
-	// RAW_MAX_MEM_EFFICIENCY_PERCENT float64
-	// SUBMIT_TIME  int
+	ID                         string   `json:"_id"`
+	AccountingName             string   `json:"ACCOUNTING_NAME"`
+	AvailCPUTimeSec            int64    `json:"AVAIL_CPU_TIME_SEC"`
+	BOM                        string   `json:"BOM"`
+	Command                    string   `json:"Command"`
+	JobName                    string   `json:"JOB_NAME"`
+	Job                        string   `json:"Job"`
+	MemRequestedMB             int64    `json:"MEM_REQUESTED_MB"`
+	MemRequestedMBSec          int64    `json:"MEM_REQUESTED_MB_SEC"`
+	NumExecProcs               int64    `json:"NUM_EXEC_PROCS"`
+	PendingTimeSec             int64    `json:"PENDING_TIME_SEC"`
+	QueueName                  string   `json:"QUEUE_NAME"`
+	RunTimeSec                 int64    `json:"RUN_TIME_SEC"`
+	Timestamp                  int64    `json:"timestamp"`
+	UserName                   string   `json:"USER_NAME"`
+	WastedCPUSeconds           float64  `json:"WASTED_CPU_SECONDS"`
+	WastedMBSeconds            float64  `json:"WASTED_MB_SECONDS"`
+	RawWastedCPUSeconds        float64  `json:"RAW_WASTED_CPU_SECONDS"`
+	RawWastedMBSeconds         float64  `json:"RAW_WASTED_MB_SECONDS"`
+	AvgMemEfficiencyPercent    float64  `json:"AVG_MEM_EFFICIENCY_PERCENT"`
+	AvrgMemUsageMB             float64  `json:"AVRG_MEM_USAGE_MB"`
+	AvrgMemUsageMBSecCooked    float64  `json:"AVRG_MEM_USAGE_MB_SEC_COOKED"`
+	AvrgMemUsageMBSecRaw       float64  `json:"AVRG_MEM_USAGE_MB_SEC_RAW"`
+	ClusterName                string   `json:"CLUSTER_NAME"`
+	CookedCPUTimeSec           float64  `json:"COOKED_CPU_TIME_SEC"`
+	EndTime                    int64    `json:"END_TIME"`
+	ExecHostname               []string `json:"EXEC_HOSTNAME"`
+	ExitInfo                   int64    `json:"Exit_Info"`
+	ExitReason                 string   `json:"Exitreason"`
+	JobID                      int64    `json:"JOB_ID"`
+	JobArrayIndex              int64    `json:"JOB_ARRAY_INDEX"`
+	JobExitStatus              int64    `json:"JOB_EXIT_STATUS"`
+	JobEfficiencyPercent       float64  `json:"Job_Efficiency_Percent"`
+	JobEfficiencyRawPercent    float64  `json:"Job_Efficiency_Raw_Percent"`
+	MaxMemEfficiencyPercent    float64  `json:"MAX_MEM_EFFICIENCY_PERCENT"`
+	MaxMemUsageMB              float64  `json:"MAX_MEM_USAGE_MB"`
+	MaxMemUsageMBSecCooked     float64  `json:"MAX_MEM_USAGE_MB_SEC_COOKED"`
+	MaxMemUsageMBSecRaw        float64  `json:"MAX_MEM_USAGE_MB_SEC_RAW"`
+	NumberOfHosts              int64    `json:"NumberOfHosts"`
+	NumberOfUniqueHosts        int64    `json:"NumberOfUniqueHosts"`
+	ProjectName                string   `json:"PROJECT_NAME"`
+	RawAvgMemEfficiencyPercent float64  `json:"RAW_AVG_MEM_EFFICIENCY_PERCENT"`
+	RawCPUTimeSec              float64  `json:"RAW_CPU_TIME_SEC"`
+	RawMaxMemEfficiencyPercent float64  `json:"RAW_MAX_MEM_EFFICIENCY_PERCENT"`
+	SubmitTime                 int64    `json:"SUBMIT_TIME"`
 }
 
 // Serialize converts a Details to a byte slice representation suitable for
 // storing on disk.
-func (d *Details) Serialize() ([]byte, error) { //nolint:funlen,misspell
+func (d *Details) Serialize() ([]byte, error) { //nolint:misspell
+	return d.SerializeWithDictionary(nil)
+}
+
+// SerializeWithDictionary is like Serialize, but stores our BOM,
+// ACCOUNTING_NAME, USER_NAME and QUEUE_NAME in the given Dictionary (if not
+// nil), serializing just their ids. Returns an ErrDictionaryFull Error if the
+// Dictionary doesn't have room for them; see Dictionary.Full().
+func (d *Details) SerializeWithDictionary(dict *Dictionary) ([]byte, error) { //nolint:funlen
 	d.headTailStrings()
 
 	var (
-		size int
+		size = 1 // for the version byte
 		err  error
 	)
 
 	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.ID) })
-	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.AccountingName) })
+	addSize(&size, &err, func() (int, error) { return dict.sizeString(d.AccountingName) })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
-	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.BOM) })
+	addSize(&size, &err, func() (int, error) { return dict.sizeString(d.BOM) })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.Command) })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.JobName) })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.Job) })
@@ -165,20 +259,46 @@ func (d *Details) Serialize() ([]byte, error) { //nolint:funlen,misspell
 	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
-	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.QueueName) })
+	addSize(&size, &err, func() (int, error) { return dict.sizeString(d.QueueName) })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
-	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.UserName) })
+	addSize(&size, &err, func() (int, error) { return dict.sizeString(d.UserName) })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
 	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.ClusterName) })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeSlice(d.ExecHostname, bstd.SizeString) })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.ExitReason) })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeString(d.ProjectName) })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeFloat64(), nil })
+	addSize(&size, &err, func() (int, error) { return bstd.SizeInt64(), nil })
 
 	if err != nil {
 		return nil, err
 	}
 
-	return d.marshal(size)
+	return d.marshal(size, dict)
 }
 
 func addSize(size *int, err *error, fn func() (int, error)) {
@@ -190,22 +310,29 @@ func addSize(size *int, err *error, fn func() (int, error)) {
 	*size += thisSize
 }
 
-func (d *Details) marshal(size int) ([]byte, error) { //nolint:funlen,gocyclo
+func (d *Details) marshal(size int, dict *Dictionary) ([]byte, error) { //nolint:funlen,gocyclo
 	n, encoded := benc.Marshal(size)
 
+	encoded[n] = versionFlag | SerializationVersion
+	if dict != nil {
+		encoded[n] |= dictionaryFlag
+	}
+
+	n++
+
 	n, err := bstd.MarshalString(n, encoded, d.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	n, err = bstd.MarshalString(n, encoded, d.AccountingName)
+	n, err = dict.marshalString(n, encoded, d.AccountingName)
 	if err != nil {
 		return nil, err
 	}
 
 	n = bstd.MarshalInt64(n, encoded, d.AvailCPUTimeSec)
 
-	n, err = bstd.MarshalString(n, encoded, d.BOM)
+	n, err = dict.marshalString(n, encoded, d.BOM)
 	if err != nil {
 		return nil, err
 	}
@@ -230,7 +357,7 @@ func (d *Details) marshal(size int) ([]byte, error) { //nolint:funlen,gocyclo
 	n = bstd.MarshalInt64(n, encoded, d.NumExecProcs)
 	n = bstd.MarshalInt64(n, encoded, d.PendingTimeSec)
 
-	n, err = bstd.MarshalString(n, encoded, d.QueueName)
+	n, err = dict.marshalString(n, encoded, d.QueueName)
 	if err != nil {
 		return nil, err
 	}
@@ -238,7 +365,7 @@ func (d *Details) marshal(size int) ([]byte, error) { //nolint:funlen,gocyclo
 	n = bstd.MarshalInt64(n, encoded, d.RunTimeSec)
 	n = bstd.MarshalInt64(n, encoded, d.Timestamp)
 
-	n, err = bstd.MarshalString(n, encoded, d.UserName)
+	n, err = dict.marshalString(n, encoded, d.UserName)
 	if err != nil {
 		return nil, err
 	}
@@ -247,6 +374,52 @@ func (d *Details) marshal(size int) ([]byte, error) { //nolint:funlen,gocyclo
 	n = bstd.MarshalFloat64(n, encoded, d.WastedMBSeconds)
 	n = bstd.MarshalFloat64(n, encoded, d.RawWastedCPUSeconds)
 	n = bstd.MarshalFloat64(n, encoded, d.RawWastedMBSeconds)
+	n = bstd.MarshalFloat64(n, encoded, d.AvgMemEfficiencyPercent)
+	n = bstd.MarshalFloat64(n, encoded, d.AvrgMemUsageMB)
+	n = bstd.MarshalFloat64(n, encoded, d.AvrgMemUsageMBSecCooked)
+	n = bstd.MarshalFloat64(n, encoded, d.AvrgMemUsageMBSecRaw)
+
+	n, err = bstd.MarshalString(n, encoded, d.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	n = bstd.MarshalFloat64(n, encoded, d.CookedCPUTimeSec)
+	n = bstd.MarshalInt64(n, encoded, d.EndTime)
+
+	n, err = bstd.MarshalSlice(n, encoded, d.ExecHostname, bstd.MarshalString)
+	if err != nil {
+		return nil, err
+	}
+
+	n = bstd.MarshalInt64(n, encoded, d.ExitInfo)
+
+	n, err = bstd.MarshalString(n, encoded, d.ExitReason)
+	if err != nil {
+		return nil, err
+	}
+
+	n = bstd.MarshalInt64(n, encoded, d.JobID)
+	n = bstd.MarshalInt64(n, encoded, d.JobArrayIndex)
+	n = bstd.MarshalInt64(n, encoded, d.JobExitStatus)
+	n = bstd.MarshalFloat64(n, encoded, d.JobEfficiencyPercent)
+	n = bstd.MarshalFloat64(n, encoded, d.JobEfficiencyRawPercent)
+	n = bstd.MarshalFloat64(n, encoded, d.MaxMemEfficiencyPercent)
+	n = bstd.MarshalFloat64(n, encoded, d.MaxMemUsageMB)
+	n = bstd.MarshalFloat64(n, encoded, d.MaxMemUsageMBSecCooked)
+	n = bstd.MarshalFloat64(n, encoded, d.MaxMemUsageMBSecRaw)
+	n = bstd.MarshalInt64(n, encoded, d.NumberOfHosts)
+	n = bstd.MarshalInt64(n, encoded, d.NumberOfUniqueHosts)
+
+	n, err = bstd.MarshalString(n, encoded, d.ProjectName)
+	if err != nil {
+		return nil, err
+	}
+
+	n = bstd.MarshalFloat64(n, encoded, d.RawAvgMemEfficiencyPercent)
+	n = bstd.MarshalFloat64(n, encoded, d.RawCPUTimeSec)
+	n = bstd.MarshalFloat64(n, encoded, d.RawMaxMemEfficiencyPercent)
+	n = bstd.MarshalInt64(n, encoded, d.SubmitTime)
 
 	err = benc.VerifyMarshal(n, encoded)
 
@@ -269,32 +442,127 @@ func (d *Details) headTailStrings() {
 	if len(d.Job) > maxFieldLength {
 		d.Job = headTailString(d.Job)
 	}
+
+	if len(d.ExitReason) > maxFieldLength {
+		d.ExitReason = headTailString(d.ExitReason)
+	}
+
+	d.ExecHostname = limitStrings(d.ExecHostname)
+}
+
+// limitStrings returns as many of the given strings as fit in maxFieldLength
+// once serialized.
+func limitStrings(strs []string) []string {
+	total := 0
+
+	for i, str := range strs {
+		size, err := bstd.SizeString(str)
+		total += size
+
+		if err != nil || total > maxFieldLength {
+			return strs[:i]
+		}
+	}
+
+	return strs
 }
 
 func headTailString(s string) string {
 	return s[0:headTailLen] + truncationIndicator + s[len(s)-headTailLen:]
 }
 
+// unmarshalStringSlice is like bstd.UnmarshalSlice for strings, but returns a
+// nil slice if there were no strings.
+func unmarshalStringSlice(n int, encoded []byte) (int, []string, error) {
+	n, strs, err := bstd.UnmarshalSlice[string](n, encoded, bstd.UnmarshalUnsafeString)
+	if len(strs) == 0 {
+		strs = nil
+	}
+
+	return n, strs, err
+}
+
+// recordVersion returns the SerializationVersion of the given output of
+// Details.Serialize, and the position its fields start at. Version 1 records
+// had no version byte, and start with the serialization of the ID string,
+// whose first byte never has versionFlag set.
+func recordVersion(encoded []byte) (int, int, error) {
+	if len(encoded) == 0 {
+		return 0, 0, Error{Msg: ErrUnknownSerializationVersion, cause: "empty record"}
+	}
+
+	if encoded[0]&versionFlag == 0 {
+		return 1, 0, nil
+	}
+
+	version := int(encoded[0] &^ (versionFlag | dictionaryFlag))
+	if version > SerializationVersion {
+		return 0, 0, Error{Msg: ErrUnknownSerializationVersion, cause: strconv.Itoa(version)}
+	}
+
+	return version, 1, nil
+}
+
+// dictionaryOf returns the given Dictionary if the given serialized Details
+// were serialized with one, or nil if they weren't. Returns an ErrNoDictionary
+// Error if they need a Dictionary but none was given.
+func dictionaryOf(encoded []byte, dict *Dictionary) (*Dictionary, error) {
+	if !IsDictionaryEncoded(encoded) {
+		return nil, nil
+	}
+
+	if dict == nil {
+		return nil, Error{Msg: ErrNoDictionary}
+	}
+
+	return dict, nil
+}
+
+// SerializedVersion returns the SerializationVersion of the given output of
+// Details.Serialize. Returns an error if the version is newer than ours.
+func SerializedVersion(encoded []byte) (int, error) {
+	version, _, err := recordVersion(encoded)
+
+	return version, err
+}
+
 // DeserializeDetails takes the output of Details.Serialize and converts it
 // back in to a Details. Provide a non-zero Fields (from Query.DesiredFields())
-// to skip the unmarshalling of undesired fields, for a speed boost.
-func DeserializeDetails(encoded []byte, desired Fields) (*Details, error) { //nolint:funlen,gocognit,gocyclo,cyclop
+// to skip the unmarshalling of undesired fields, for a speed boost. Output of
+// older SerializationVersions can be deserialized, leaving the fields they
+// didn't have at their zero values.
+func DeserializeDetails(encoded []byte, desired Fields) (*Details, error) {
+	return DeserializeDetailsWithDictionary(encoded, desired, nil)
+}
+
+// DeserializeDetailsWithDictionary is like DeserializeDetails, but can also
+// deserialize the output of Details.SerializeWithDictionary, given the same
+// Dictionary. Output of Details.Serialize is deserialized as normal, ignoring
+// the Dictionary, so you can provide the Dictionary (if any) of wherever the
+// output was stored without knowing how it was serialized.
+func DeserializeDetailsWithDictionary(encoded []byte, desired Fields, //nolint:funlen,gocognit,gocyclo,cyclop
+	dict *Dictionary) (*Details, error) {
 	details := &Details{}
 
-	var (
-		n   int //nolint:varnamelen
-		err error
-	)
+	version, n, err := recordVersion(encoded) //nolint:varnamelen
+	if err != nil {
+		return nil, err
+	}
 
-	n, details.ID, err = bstd.UnmarshalUnsafeString(0, encoded)
+	dict, err = dictionaryOf(encoded, dict)
+	if err != nil {
+		return nil, err
+	}
+
+	n, details.ID, err = bstd.UnmarshalUnsafeString(n, encoded)
 	if err != nil {
 		return nil, err
 	}
 
 	if WantsField(desired, FieldAccountingName) {
-		n, details.AccountingName, err = bstd.UnmarshalUnsafeString(n, encoded)
+		n, details.AccountingName, err = dict.unmarshalString(n, encoded)
 	} else {
-		n, err = bstd.SkipString(n, encoded)
+		n, err = dict.skipString(n, encoded)
 	}
 
 	if err != nil {
@@ -312,9 +580,9 @@ func DeserializeDetails(encoded []byte, desired Fields) (*Details, error) { //no
 	}
 
 	if WantsField(desired, FieldBOM) {
-		n, details.BOM, err = bstd.UnmarshalUnsafeString(n, encoded)
+		n, details.BOM, err = dict.unmarshalString(n, encoded)
 	} else {
-		n, err = bstd.SkipString(n, encoded)
+		n, err = dict.skipString(n, encoded)
 	}
 
 	if err != nil {
@@ -392,9 +660,9 @@ func DeserializeDetails(encoded []byte, desired Fields) (*Details, error) { //no
 	}
 
 	if WantsField(desired, FieldQueueName) {
-		n, details.QueueName, err = bstd.UnmarshalUnsafeString(n, encoded)
+		n, details.QueueName, err = dict.unmarshalString(n, encoded)
 	} else {
-		n, err = bstd.SkipString(n, encoded)
+		n, err = dict.skipString(n, encoded)
 	}
 
 	if err != nil {
@@ -422,9 +690,9 @@ func DeserializeDetails(encoded []byte, desired Fields) (*Details, error) { //no
 	}
 
 	if WantsField(desired, FieldUserName) {
-		n, details.UserName, err = bstd.UnmarshalUnsafeString(n, encoded)
+		n, details.UserName, err = dict.unmarshalString(n, encoded)
 	} else {
-		n, err = bstd.SkipString(n, encoded)
+		n, err = dict.skipString(n, encoded)
 	}
 
 	if err != nil {
@@ -467,6 +735,13 @@ func DeserializeDetails(encoded []byte, desired Fields) (*Details, error) { //no
 		return nil, err
 	}
 
+	if version > 1 {
+		n, err = deserializeV2Fields(details, n, encoded, desired)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = benc.VerifyUnmarshal(n, encoded)
 	if err != nil {
 		slog.Error("unmarhsal failed", "err", err,
@@ -478,18 +753,296 @@ func DeserializeDetails(encoded []byte, desired Fields) (*Details, error) { //no
 	return details, err
 }
 
+// deserializeV2Fields deserializes the fields that were added to Details in
+// SerializationVersion 2, starting at position n of encoded.
+func deserializeV2Fields(details *Details, n int, encoded []byte, desired Fields) (int, error) { //nolint:funlen,gocognit,gocyclo,cyclop
+	var err error
+
+	if WantsField(desired, FieldAvgMemEfficiencyPercent) {
+		n, details.AvgMemEfficiencyPercent, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldAvrgMemUsageMB) {
+		n, details.AvrgMemUsageMB, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldAvrgMemUsageMBSecCooked) {
+		n, details.AvrgMemUsageMBSecCooked, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldAvrgMemUsageMBSecRaw) {
+		n, details.AvrgMemUsageMBSecRaw, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldClusterName) {
+		n, details.ClusterName, err = bstd.UnmarshalUnsafeString(n, encoded)
+	} else {
+		n, err = bstd.SkipString(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldCookedCPUTimeSec) {
+		n, details.CookedCPUTimeSec, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldEndTime) {
+		n, details.EndTime, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldExecHostname) {
+		n, details.ExecHostname, err = unmarshalStringSlice(n, encoded)
+	} else {
+		n, err = bstd.SkipSlice(n, encoded, bstd.SkipString)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldExitInfo) {
+		n, details.ExitInfo, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldExitReason) {
+		n, details.ExitReason, err = bstd.UnmarshalUnsafeString(n, encoded)
+	} else {
+		n, err = bstd.SkipString(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldJobID) {
+		n, details.JobID, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldJobArrayIndex) {
+		n, details.JobArrayIndex, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldJobExitStatus) {
+		n, details.JobExitStatus, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldJobEfficiencyPercent) {
+		n, details.JobEfficiencyPercent, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldJobEfficiencyRawPercent) {
+		n, details.JobEfficiencyRawPercent, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldMaxMemEfficiencyPercent) {
+		n, details.MaxMemEfficiencyPercent, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldMaxMemUsageMB) {
+		n, details.MaxMemUsageMB, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldMaxMemUsageMBSecCooked) {
+		n, details.MaxMemUsageMBSecCooked, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldMaxMemUsageMBSecRaw) {
+		n, details.MaxMemUsageMBSecRaw, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldNumberOfHosts) {
+		n, details.NumberOfHosts, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldNumberOfUniqueHosts) {
+		n, details.NumberOfUniqueHosts, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldProjectName) {
+		n, details.ProjectName, err = bstd.UnmarshalUnsafeString(n, encoded)
+	} else {
+		n, err = bstd.SkipString(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldRawAvgMemEfficiencyPercent) {
+		n, details.RawAvgMemEfficiencyPercent, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldRawCPUTimeSec) {
+		n, details.RawCPUTimeSec, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldRawMaxMemEfficiencyPercent) {
+		n, details.RawMaxMemEfficiencyPercent, err = bstd.UnmarshalFloat64(n, encoded)
+	} else {
+		n, err = bstd.SkipFloat64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if WantsField(desired, FieldSubmitTime) {
+		n, details.SubmitTime, err = bstd.UnmarshalInt64(n, encoded)
+	} else {
+		n, err = bstd.SkipInt64(n, encoded)
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
 type Aggregations struct {
 	Stats *Buckets `json:"stats,omitempty"`
 }
 
+// Buckets holds the result of a "stats" aggregation. Bucket aggregations like
+// terms and multi_terms fill in Buckets; stats aggregations fill in Count, Min,
+// Max, Avg and Sum (where Min, Max and Avg are nil if Count is 0); and
+// percentiles aggregations fill in Values.
 type Buckets struct {
-	Buckets []interface{} `json:"buckets,omitempty"`
+	Buckets []interface{}       `json:"buckets,omitempty"`
+	Count   *int64              `json:"count,omitempty"`
+	Min     *float64            `json:"min,omitempty"`
+	Max     *float64            `json:"max,omitempty"`
+	Avg     *float64            `json:"avg,omitempty"`
+	Sum     *float64            `json:"sum,omitempty"`
+	Values  map[string]*float64 `json:"values,omitempty"`
 }
 
 // parseResultResponse parses the response in to a Result. If the given cb is
 // not nil, Hits are passed to the cb and not stored in the result and the total
 // number of Hits seen is returned as well.
-func parseResultResponse(resp *esapi.Response, cb HitsCallBack) (*Result, int, error) {
+func parseResultResponse(resp *esapi.Response, cb HitsCallBack, unknown *UnknownFields) (*Result, int, error) {
 	if resp.IsError() {
 		return nil, 0, Error{Msg: ErrFailedQuery, cause: resp.String()}
 	}
@@ -501,6 +1054,12 @@ func parseResultResponse(resp *esapi.Response, cb HitsCallBack) (*Result, int, e
 		return nil, 0, err
 	}
 
+	if unknown != nil {
+		if err = unknown.Inspect(data); err != nil {
+			return nil, 0, err
+		}
+	}
+
 	result := &Result{}
 
 	n, err := result.FromJSON(data, cb)
@@ -18,11 +18,13 @@ import (
 // empty, all fields are included.
 func (v *Result) MarshalFields(desired Fields) ([]byte, error) {
 	w := jwriter.Writer{}
-	marshalFieldsResult(&w, v, desired)
+	marshalFieldsResult(&w, v, desired, nil)
 	return w.Buffer.BuildBytes(), w.Error
 }
 
-func marshalFieldsResult(out *jwriter.Writer, in *Result, desired Fields) {
+// marshalFieldsResult writes in as JSON to out, calling afterHit (if not nil)
+// after each hit is written. Hits stop being written if out gets an Error.
+func marshalFieldsResult(out *jwriter.Writer, in *Result, desired Fields, afterHit func(*jwriter.Writer)) {
 	out.RawByte('{')
 	first := true
 	_ = first
@@ -53,7 +55,7 @@ func marshalFieldsResult(out *jwriter.Writer, in *Result, desired Fields) {
 		if in.HitSet == nil {
 			out.RawString("null")
 		} else {
-			(*in.HitSet).MarshalFields(out, desired)
+			(*in.HitSet).marshalFields(out, desired, afterHit)
 		}
 	}
 	if in.Aggregations != nil {
@@ -68,6 +70,10 @@ func marshalFieldsResult(out *jwriter.Writer, in *Result, desired Fields) {
 // fields of the hit details, even if they're zero value. If the desired map is
 // empty, all fields are included.
 func (v *HitSet) MarshalFields(w *jwriter.Writer, desired Fields) {
+	v.marshalFields(w, desired, nil)
+}
+
+func (v *HitSet) marshalFields(w *jwriter.Writer, desired Fields, afterHit func(*jwriter.Writer)) {
 	w.RawByte('{')
 	first := true
 	_ = first
@@ -88,6 +94,12 @@ func (v *HitSet) MarshalFields(w *jwriter.Writer, desired Fields) {
 					w.RawByte(',')
 				}
 				(v3).MarshalEasyJSON(w, desired)
+				if afterHit != nil {
+					afterHit(w)
+					if w.Error != nil {
+						break
+					}
+				}
 			}
 			w.RawByte(']')
 		}
@@ -105,6 +117,15 @@ func (v HitSetTotal) MarshalEasyJSON(w *jwriter.Writer) {
 		w.RawString(prefix[1:])
 		w.Int(int(v.Value))
 	}
+	{
+		const prefix string = ",\"relation\":"
+		w.RawString(prefix)
+		if v.Relation == "" {
+			w.String(RelationEq)
+		} else {
+			w.String(string(v.Relation))
+		}
+	}
 	w.RawByte('}')
 }
 
@@ -340,6 +361,292 @@ func (v Details) MarshalEasyJSON(w *jwriter.Writer, desired Fields) {
 		w.Float64(float64(v.RawWastedMBSeconds))
 	}
 
+	if WantsField(desired, FieldAvgMemEfficiencyPercent) {
+		const prefix string = ",\"AVG_MEM_EFFICIENCY_PERCENT\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.AvgMemEfficiencyPercent))
+	}
+
+	if WantsField(desired, FieldAvrgMemUsageMB) {
+		const prefix string = ",\"AVRG_MEM_USAGE_MB\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.AvrgMemUsageMB))
+	}
+
+	if WantsField(desired, FieldAvrgMemUsageMBSecCooked) {
+		const prefix string = ",\"AVRG_MEM_USAGE_MB_SEC_COOKED\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.AvrgMemUsageMBSecCooked))
+	}
+
+	if WantsField(desired, FieldAvrgMemUsageMBSecRaw) {
+		const prefix string = ",\"AVRG_MEM_USAGE_MB_SEC_RAW\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.AvrgMemUsageMBSecRaw))
+	}
+
+	if WantsField(desired, FieldClusterName) {
+		const prefix string = ",\"CLUSTER_NAME\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.String(string(v.ClusterName))
+	}
+
+	if WantsField(desired, FieldCookedCPUTimeSec) {
+		const prefix string = ",\"COOKED_CPU_TIME_SEC\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.CookedCPUTimeSec))
+	}
+
+	if WantsField(desired, FieldEndTime) {
+		const prefix string = ",\"END_TIME\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.EndTime))
+	}
+
+	if WantsField(desired, FieldExecHostname) {
+		const prefix string = ",\"EXEC_HOSTNAME\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		marshalStrings(w, v.ExecHostname)
+	}
+
+	if WantsField(desired, FieldExitInfo) {
+		const prefix string = ",\"Exit_Info\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.ExitInfo))
+	}
+
+	if WantsField(desired, FieldExitReason) {
+		const prefix string = ",\"Exitreason\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.String(string(v.ExitReason))
+	}
+
+	if WantsField(desired, FieldJobID) {
+		const prefix string = ",\"JOB_ID\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.JobID))
+	}
+
+	if WantsField(desired, FieldJobArrayIndex) {
+		const prefix string = ",\"JOB_ARRAY_INDEX\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.JobArrayIndex))
+	}
+
+	if WantsField(desired, FieldJobExitStatus) {
+		const prefix string = ",\"JOB_EXIT_STATUS\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.JobExitStatus))
+	}
+
+	if WantsField(desired, FieldJobEfficiencyPercent) {
+		const prefix string = ",\"Job_Efficiency_Percent\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.JobEfficiencyPercent))
+	}
+
+	if WantsField(desired, FieldJobEfficiencyRawPercent) {
+		const prefix string = ",\"Job_Efficiency_Raw_Percent\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.JobEfficiencyRawPercent))
+	}
+
+	if WantsField(desired, FieldMaxMemEfficiencyPercent) {
+		const prefix string = ",\"MAX_MEM_EFFICIENCY_PERCENT\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.MaxMemEfficiencyPercent))
+	}
+
+	if WantsField(desired, FieldMaxMemUsageMB) {
+		const prefix string = ",\"MAX_MEM_USAGE_MB\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.MaxMemUsageMB))
+	}
+
+	if WantsField(desired, FieldMaxMemUsageMBSecCooked) {
+		const prefix string = ",\"MAX_MEM_USAGE_MB_SEC_COOKED\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.MaxMemUsageMBSecCooked))
+	}
+
+	if WantsField(desired, FieldMaxMemUsageMBSecRaw) {
+		const prefix string = ",\"MAX_MEM_USAGE_MB_SEC_RAW\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.MaxMemUsageMBSecRaw))
+	}
+
+	if WantsField(desired, FieldNumberOfHosts) {
+		const prefix string = ",\"NumberOfHosts\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.NumberOfHosts))
+	}
+
+	if WantsField(desired, FieldNumberOfUniqueHosts) {
+		const prefix string = ",\"NumberOfUniqueHosts\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.NumberOfUniqueHosts))
+	}
+
+	if WantsField(desired, FieldProjectName) {
+		const prefix string = ",\"PROJECT_NAME\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.String(string(v.ProjectName))
+	}
+
+	if WantsField(desired, FieldRawAvgMemEfficiencyPercent) {
+		const prefix string = ",\"RAW_AVG_MEM_EFFICIENCY_PERCENT\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.RawAvgMemEfficiencyPercent))
+	}
+
+	if WantsField(desired, FieldRawCPUTimeSec) {
+		const prefix string = ",\"RAW_CPU_TIME_SEC\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.RawCPUTimeSec))
+	}
+
+	if WantsField(desired, FieldRawMaxMemEfficiencyPercent) {
+		const prefix string = ",\"RAW_MAX_MEM_EFFICIENCY_PERCENT\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Float64(float64(v.RawMaxMemEfficiencyPercent))
+	}
+
+	if WantsField(desired, FieldSubmitTime) {
+		const prefix string = ",\"SUBMIT_TIME\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(int64(v.SubmitTime))
+	}
+
 	w.RawByte('}')
 }
 
@@ -383,5 +690,66 @@ func (v Buckets) MarshalEasyJSON(w *jwriter.Writer) {
 			w.RawByte(']')
 		}
 	}
+	if v.Count != nil {
+		const prefix string = ",\"count\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.Int64(*v.Count)
+		marshalNullableFloat64(w, ",\"min\":", v.Min)
+		marshalNullableFloat64(w, ",\"max\":", v.Max)
+		marshalNullableFloat64(w, ",\"avg\":", v.Avg)
+		marshalNullableFloat64(w, ",\"sum\":", v.Sum)
+	}
+	if v.Values != nil {
+		const prefix string = ",\"values\":"
+		if first {
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.RawByte('{')
+		v7First := true
+		for v7Name, v7Value := range v.Values {
+			if v7First {
+				v7First = false
+			} else {
+				w.RawByte(',')
+			}
+			w.String(v7Name)
+			marshalNullableFloat64(w, ":", v7Value)
+		}
+		w.RawByte('}')
+	}
 	w.RawByte('}')
 }
+
+// marshalStrings writes the given strings as a JSON array, or null if nil.
+func marshalStrings(w *jwriter.Writer, strs []string) {
+	if strs == nil {
+		w.RawString("null")
+		return
+	}
+	w.RawByte('[')
+	for i, str := range strs {
+		if i > 0 {
+			w.RawByte(',')
+		}
+		w.String(str)
+	}
+	w.RawByte(']')
+}
+
+// marshalNullableFloat64 writes the given prefix followed by the given value,
+// or null if it is nil, as elasticsearch does for metrics of no documents.
+func marshalNullableFloat64(w *jwriter.Writer, prefix string, v *float64) {
+	w.RawString(prefix)
+	if v == nil {
+		w.RawString("null")
+		return
+	}
+	w.Float64(*v)
+}
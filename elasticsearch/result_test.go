@@ -26,8 +26,12 @@
 package elasticsearch
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -53,16 +57,25 @@ func TestDetails(t *testing.T) {
 			WastedMBSeconds:     7.2,
 			RawWastedCPUSeconds: 7.1,
 			RawWastedMBSeconds:  7.2,
+			ClusterName:         "farm",
+			ExecHostname:        []string{"host1", "host2"},
+			JobID:               8,
+			SubmitTime:          9,
+			MaxMemUsageMB:       10.5,
 		}
 
 		detailBytes, err := details.Serialize() //nolint:misspell
 		So(err, ShouldBeNil)
-		So(len(detailBytes), ShouldEqual, 143)
+		So(len(detailBytes), ShouldEqual, 352)
 
 		recovered, err := DeserializeDetails(detailBytes, 0)
 		So(err, ShouldBeNil)
 		So(recovered, ShouldResemble, details)
 
+		version, err := SerializedVersion(detailBytes)
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, SerializationVersion)
+
 		expectedID := "id"
 		details = &Details{
 			ID:                expectedID,
@@ -82,11 +95,14 @@ func TestDetails(t *testing.T) {
 			UserName:          "uname",
 			WastedCPUSeconds:  7.1,
 			WastedMBSeconds:   7.2,
+			ExecHostname:      []string{"host1"},
+			ExitReason:        "killed",
+			EndTime:           10,
 		}
 
 		detailBytes, err = details.Serialize() //nolint:misspell
 		So(err, ShouldBeNil)
-		So(len(detailBytes), ShouldEqual, 140)
+		So(len(detailBytes), ShouldEqual, 343)
 
 		recovered, err = DeserializeDetails(detailBytes, 0)
 		So(err, ShouldBeNil)
@@ -160,6 +176,42 @@ func TestDetails(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(recovered, ShouldResemble, &Details{ID: expectedID, BOM: "bname", WastedMBSeconds: 7.2})
 
+		recovered, err = DeserializeDetails(detailBytes, FieldExecHostname)
+		So(err, ShouldBeNil)
+		So(recovered, ShouldResemble, &Details{ID: expectedID, ExecHostname: []string{"host1"}})
+
+		recovered, err = DeserializeDetails(detailBytes, FieldExitReason|FieldEndTime)
+		So(err, ShouldBeNil)
+		So(recovered, ShouldResemble, &Details{ID: expectedID, ExitReason: "killed", EndTime: 10})
+
+		Convey("Version 1 serializations, without the version byte and newer fields, can be deserialized", func() {
+			details.ExecHostname, details.ExitReason, details.EndTime = nil, "", 0
+
+			detailBytes, err = details.Serialize() //nolint:misspell
+			So(err, ShouldBeNil)
+
+			v2FieldsLength := 22*8 + 4*3
+			v1Bytes := detailBytes[1 : len(detailBytes)-v2FieldsLength]
+
+			version, err = SerializedVersion(v1Bytes)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+
+			recovered, err = DeserializeDetails(v1Bytes, 0)
+			So(err, ShouldBeNil)
+			So(recovered, ShouldResemble, details)
+		})
+
+		Convey("Serializations from a future version can't be deserialized", func() {
+			detailBytes[0] = versionFlag | (SerializationVersion + 1)
+
+			_, err = DeserializeDetails(detailBytes, 0)
+			So(err, ShouldNotBeNil)
+
+			_, err = SerializedVersion(detailBytes)
+			So(err, ShouldNotBeNil)
+		})
+
 		details = &Details{
 			ID:                  strings.Repeat("a", 26),
 			AccountingName:      strings.Repeat("a", 24),
@@ -180,11 +232,14 @@ func TestDetails(t *testing.T) {
 			WastedMBSeconds:     0,
 			RawWastedCPUSeconds: 7.1,
 			RawWastedMBSeconds:  7.2,
+			ExitReason:          strings.Repeat("reason", 1000),
+			ExecHostname:        strings.Split(strings.Repeat("hostname ", 1000), " "),
 		}
 
 		detailBytes, err = details.Serialize() //nolint:misspell
 		So(err, ShouldBeNil)
-		So(len(detailBytes), ShouldEqual, 7730)
+		So(len(detailBytes), ShouldEqual, 12916)
+		So(len(detailBytes), ShouldBeLessThan, MaxEncodedDetailsLength)
 
 		recovered, err = DeserializeDetails(detailBytes, 0)
 		So(err, ShouldBeNil)
@@ -207,5 +262,101 @@ func TestDetails(t *testing.T) {
 		So(recovered.UserName, ShouldEqual, details.UserName)
 		So(recovered.RawWastedCPUSeconds, ShouldEqual, details.RawWastedCPUSeconds)
 		So(recovered.RawWastedMBSeconds, ShouldEqual, details.RawWastedMBSeconds)
+		So(len(recovered.ExitReason), ShouldEqual, maxFieldLength)
+		So(recovered.ExecHostname, ShouldHaveLength, 227)
+	})
+}
+
+func TestWriteDelimited(t *testing.T) {
+	Convey("Given a Result with some hits", t, func() {
+		result := &Result{HitSet: &HitSet{Hits: []Hit{
+			{ID: "1", Details: &Details{UserName: "u1", JobName: "a,b", WastedCPUSeconds: 1.5}},
+			{ID: "2", Details: &Details{UserName: "u2", NumExecProcs: 3}},
+		}}}
+
+		Convey("You can write chosen columns as CSV or TSV", func() {
+			var b bytes.Buffer
+
+			err := result.WriteDelimited(&b, []string{"_id", "USER_NAME", "JOB_NAME", "WASTED_CPU_SECONDS"}, ',')
+			So(err, ShouldBeNil)
+			So(b.String(), ShouldEqual, "_id,USER_NAME,JOB_NAME,WASTED_CPU_SECONDS\n"+
+				"1,u1,\"a,b\",1.5\n2,u2,,0\n")
+
+			b.Reset()
+
+			err = result.WriteDelimited(&b, []string{"USER_NAME", "NUM_EXEC_PROCS"}, '\t')
+			So(err, ShouldBeNil)
+			So(b.String(), ShouldEqual, "USER_NAME\tNUM_EXEC_PROCS\nu1\t0\nu2\t3\n")
+		})
+
+		Convey("You can't write unknown columns", func() {
+			So(ValidateColumns(SourceFields()), ShouldBeNil)
+			So(ValidateColumns([]string{"_id", "foo"}), ShouldNotBeNil)
+
+			var b bytes.Buffer
+
+			err := result.WriteDelimited(&b, []string{"foo"}, ',')
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestHitSetTotal(t *testing.T) {
+	Convey("Totals are marshalled with a relation, which can be unmarshalled", t, func() {
+		result := &Result{HitSet: &HitSet{Total: HitSetTotal{Value: 3}}}
+
+		data, err := result.MarshalFields(0)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"total":{"value":3,"relation":"eq"}`)
+
+		result.HitSet.Total.Relation = RelationGte
+
+		data, err = result.MarshalFields(0)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"total":{"value":3,"relation":"gte"}`)
+
+		var decoded Result
+
+		err = json.Unmarshal(data, &decoded)
+		So(err, ShouldBeNil)
+		So(decoded.HitSet.Total.IsLowerBound(), ShouldBeTrue)
+	})
+
+	Convey("Totals can be limited and added", t, func() {
+		total := HitSetTotal{Value: 10}
+		total.Limit(0)
+		So(total, ShouldResemble, HitSetTotal{Value: 10})
+
+		total.Limit(20)
+		So(total.IsLowerBound(), ShouldBeFalse)
+
+		total.Limit(5)
+		So(total, ShouldResemble, HitSetTotal{Value: 5, Relation: RelationGte})
+
+		sum := HitSetTotal{Value: 1, Relation: RelationEq}
+		sum.Add(HitSetTotal{Value: 2})
+		So(sum, ShouldResemble, HitSetTotal{Value: 3, Relation: RelationEq})
+
+		sum.Add(total)
+		So(sum, ShouldResemble, HitSetTotal{Value: 8, Relation: RelationGte})
+	})
+
+	Convey("SetLocalStats sets Took and applies track_total_hits", t, func() {
+		query := &Query{}
+		query.handleRequestParams(url.Values{"track_total_hits": {"2"}})
+		So(query.TotalHitsLimit(), ShouldEqual, 2)
+
+		result := &Result{HitSet: &HitSet{Total: HitSetTotal{Value: 3}}}
+		result.SetLocalStats(query, time.Now().Add(-50*time.Millisecond))
+		So(result.Took, ShouldBeGreaterThanOrEqualTo, 50)
+		So(result.HitSet.Total, ShouldResemble, HitSetTotal{Value: 2, Relation: RelationGte})
+
+		for _, track := range []string{"true", "false", "-1", `"2"`} {
+			So((&Query{TrackTotalHits: json.RawMessage(track)}).TotalHitsLimit(), ShouldEqual, 0)
+		}
+
+		query = &Query{}
+		query.handleRequestParams(url.Values{"track_total_hits": {"nonsense"}})
+		So(query.TrackTotalHits, ShouldBeNil)
 	})
 }
@@ -64,6 +64,8 @@ func easyjsonD3b49167DecodeGithubComWtsiHgiGoFarmerElasticsearch(in *jlexer.Lexe
 		switch key {
 		case "_scroll_id":
 			out.ScrollID = string(in.String())
+		case "pit_id":
+			out.PITID = string(in.String())
 		case "took":
 			out.Took = int(in.Int())
 		case "timed_out":
@@ -134,6 +136,8 @@ func easyjsonD3b49167DecodeGithubComWtsiHgiGoFarmerElasticsearch1(in *jlexer.Lex
 		switch key {
 		case "value":
 			out.Value = int(in.Int())
+		case "relation":
+			out.Relation = string(in.String())
 		default:
 			in.SkipRecursive()
 		}
@@ -273,6 +277,8 @@ func easyjsonD3b49167DecodeGithubComWtsiHgiGoFarmerElasticsearch3(in *jlexer.Lex
 				}
 				(*out.Details).UnmarshalEasyJSON(in)
 			}
+		case "sort":
+			out.Sort = append(json.RawMessage(nil), in.Raw()...)
 		default:
 			in.SkipRecursive()
 		}
@@ -353,6 +359,58 @@ func easyjsonD3b49167DecodeGithubComWtsiHgiGoFarmerElasticsearch5(in *jlexer.Lex
 			out.RawWastedCPUSeconds = float64(in.Float64())
 		case "RAW_WASTED_MB_SECONDS":
 			out.RawWastedMBSeconds = float64(in.Float64())
+		case "AVG_MEM_EFFICIENCY_PERCENT":
+			out.AvgMemEfficiencyPercent = float64(in.Float64())
+		case "AVRG_MEM_USAGE_MB":
+			out.AvrgMemUsageMB = float64(in.Float64())
+		case "AVRG_MEM_USAGE_MB_SEC_COOKED":
+			out.AvrgMemUsageMBSecCooked = float64(in.Float64())
+		case "AVRG_MEM_USAGE_MB_SEC_RAW":
+			out.AvrgMemUsageMBSecRaw = float64(in.Float64())
+		case "CLUSTER_NAME":
+			out.ClusterName = string(in.String())
+		case "COOKED_CPU_TIME_SEC":
+			out.CookedCPUTimeSec = float64(in.Float64())
+		case "END_TIME":
+			out.EndTime = int64(in.Int64())
+		case "EXEC_HOSTNAME":
+			out.ExecHostname = unmarshalStrings(in)
+		case "Exit_Info":
+			out.ExitInfo = int64(in.Int64())
+		case "Exitreason":
+			out.ExitReason = string(in.String())
+		case "JOB_ID":
+			out.JobID = int64(in.Int64())
+		case "JOB_ARRAY_INDEX":
+			out.JobArrayIndex = int64(in.Int64())
+		case "JOB_EXIT_STATUS":
+			out.JobExitStatus = int64(in.Int64())
+		case "Job_Efficiency_Percent":
+			out.JobEfficiencyPercent = float64(in.Float64())
+		case "Job_Efficiency_Raw_Percent":
+			out.JobEfficiencyRawPercent = float64(in.Float64())
+		case "MAX_MEM_EFFICIENCY_PERCENT":
+			out.MaxMemEfficiencyPercent = float64(in.Float64())
+		case "MAX_MEM_USAGE_MB":
+			out.MaxMemUsageMB = float64(in.Float64())
+		case "MAX_MEM_USAGE_MB_SEC_COOKED":
+			out.MaxMemUsageMBSecCooked = float64(in.Float64())
+		case "MAX_MEM_USAGE_MB_SEC_RAW":
+			out.MaxMemUsageMBSecRaw = float64(in.Float64())
+		case "NumberOfHosts":
+			out.NumberOfHosts = int64(in.Int64())
+		case "NumberOfUniqueHosts":
+			out.NumberOfUniqueHosts = int64(in.Int64())
+		case "PROJECT_NAME":
+			out.ProjectName = string(in.String())
+		case "RAW_AVG_MEM_EFFICIENCY_PERCENT":
+			out.RawAvgMemEfficiencyPercent = float64(in.Float64())
+		case "RAW_CPU_TIME_SEC":
+			out.RawCPUTimeSec = float64(in.Float64())
+		case "RAW_MAX_MEM_EFFICIENCY_PERCENT":
+			out.RawMaxMemEfficiencyPercent = float64(in.Float64())
+		case "SUBMIT_TIME":
+			out.SubmitTime = int64(in.Int64())
 		default:
 			in.SkipRecursive()
 		}
@@ -364,6 +422,22 @@ func easyjsonD3b49167DecodeGithubComWtsiHgiGoFarmerElasticsearch5(in *jlexer.Lex
 	}
 }
 
+// unmarshalStrings reads a JSON array of strings. elasticsearch can also give a
+// single string for fields that are usually arrays, which we also accept.
+func unmarshalStrings(in *jlexer.Lexer) []string {
+	if !in.IsDelim('[') {
+		return []string{string(in.String())}
+	}
+	in.Delim('[')
+	strs := []string{}
+	for !in.IsDelim(']') {
+		strs = append(strs, string(in.String()))
+		in.WantComma()
+	}
+	in.Delim(']')
+	return strs
+}
+
 // UnmarshalEasyJSON supports easyjson.Unmarshaler interface
 func (v *Details) UnmarshalEasyJSON(l *jlexer.Lexer) {
 	easyjsonD3b49167DecodeGithubComWtsiHgiGoFarmerElasticsearch5(l, v)
@@ -424,6 +498,42 @@ func easyjsonD3b49167DecodeGithubComWtsiHgiGoFarmerElasticsearch6(in *jlexer.Lex
 				}
 				in.Delim(']')
 			}
+		case "count":
+			out.Count = new(int64)
+			*out.Count = in.Int64()
+		case "min":
+			out.Min = new(float64)
+			*out.Min = in.Float64()
+		case "max":
+			out.Max = new(float64)
+			*out.Max = in.Float64()
+		case "avg":
+			out.Avg = new(float64)
+			*out.Avg = in.Float64()
+		case "sum":
+			out.Sum = new(float64)
+			*out.Sum = in.Float64()
+		case "values":
+			if !in.IsDelim('{') {
+				in.SkipRecursive()
+				break
+			}
+			in.Delim('{')
+			out.Values = make(map[string]*float64)
+			for !in.IsDelim('}') {
+				key := string(in.String())
+				in.WantColon()
+				var v5 *float64
+				if in.IsNull() {
+					in.Skip()
+				} else {
+					v5 = new(float64)
+					*v5 = in.Float64()
+				}
+				out.Values[key] = v5
+				in.WantComma()
+			}
+			in.Delim('}')
 		default:
 			in.SkipRecursive()
 		}
@@ -0,0 +1,131 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+const maxUnknownFieldSampleLength = 100
+
+// UnknownField describes a _source field that Details doesn't know about.
+type UnknownField struct {
+	Name   string
+	Count  int
+	Sample string
+}
+
+// UnknownFields records _source fields seen in elasticsearch hits that aren't
+// part of our Details schema, and would thus be silently dropped. It is safe
+// to use concurrently.
+type UnknownFields struct {
+	mu     sync.Mutex
+	known  map[string]bool
+	fields map[string]*UnknownField
+}
+
+// NewUnknownFields returns an empty UnknownFields.
+func NewUnknownFields() *UnknownFields {
+	known := map[string]bool{"_id": true}
+
+	for _, field := range SourceFields() {
+		known[field] = true
+	}
+
+	return &UnknownFields{
+		known:  known,
+		fields: make(map[string]*UnknownField),
+	}
+}
+
+type sourcesOnlyResult struct {
+	HitSet struct {
+		Hits []struct {
+			Source map[string]json.RawMessage `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Inspect looks at the _source of every hit in the given raw elasticsearch
+// search response, and records any unknown fields. The first time a field is
+// seen, a warning is logged with a sample value.
+func (u *UnknownFields) Inspect(data []byte) error {
+	var result sourcesOnlyResult
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, hit := range result.HitSet.Hits {
+		for name, val := range hit.Source {
+			if !u.known[name] {
+				u.record(name, val)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (u *UnknownFields) record(name string, val json.RawMessage) {
+	field, seen := u.fields[name]
+	if !seen {
+		sample := string(val)
+		if len(sample) > maxUnknownFieldSampleLength {
+			sample = sample[:maxUnknownFieldSampleLength]
+		}
+
+		field = &UnknownField{Name: name, Sample: sample}
+		u.fields[name] = field
+
+		slog.Warn("unknown _source field", "field", name, "sample", sample)
+	}
+
+	field.Count++
+}
+
+// Fields returns details of all the unknown fields seen so far, sorted by name.
+func (u *UnknownFields) Fields() []UnknownField {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	fields := make([]UnknownField, 0, len(u.fields))
+	for _, field := range u.fields {
+		fields = append(fields, *field)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields
+}
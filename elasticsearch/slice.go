@@ -0,0 +1,138 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import "sync"
+
+// sliceBufferSize is how many hits each slice of a slicedScroll() can get
+// ahead of the slowest slice.
+const sliceBufferSize = MaxSize
+
+// Slice makes a scroll query only return hits from one of Max independent
+// slices of its results, so that the slices can be scrolled in parallel.
+type Slice struct {
+	ID  int `json:"id"`
+	Max int `json:"max"`
+}
+
+// slicedScroll is like Scroll(), but scrolls our configured number of slices of
+// the query concurrently. Each slice's hits come back in the query's sort
+// order, and they are merged in order of their timestamp before being passed
+// to the given callback one at a time, so that hits of a query sorted on
+// timestamp (like a backfill's) are still received in order.
+func (c *Client) slicedScroll(query *Query, cb HitsCallBack) (*Result, error) {
+	n := c.scrollSlices
+	chs := make([]chan *Hit, n)
+	results := make([]*Result, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+
+	for i := range chs {
+		chs[i] = make(chan *Hit, sliceBufferSize)
+		sliceQuery := *query
+		sliceQuery.Slice = &Slice{ID: i, Max: n}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer close(chs[i])
+
+			results[i], errs[i] = c.fetchAll(&sliceQuery, func(hit *Hit) {
+				chs[i] <- hit
+			})
+		}()
+	}
+
+	mergeHitsByTimestamp(chs, cb)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mergeSliceResults(results), nil
+}
+
+// mergeHitsByTimestamp passes the hits from the given channels to the given
+// callback until all the channels are closed. If each channel's hits are in
+// timestamp order, the callback gets all the hits in timestamp order.
+func mergeHitsByTimestamp(chs []chan *Hit, cb HitsCallBack) {
+	heads := make([]*Hit, len(chs))
+
+	for i, ch := range chs {
+		heads[i] = <-ch
+	}
+
+	for {
+		next := -1
+
+		for i, hit := range heads {
+			if hit != nil && (next == -1 || hitTimestamp(hit) < hitTimestamp(heads[next])) {
+				next = i
+			}
+		}
+
+		if next == -1 {
+			return
+		}
+
+		cb(heads[next])
+		heads[next] = <-chs[next]
+	}
+}
+
+func hitTimestamp(hit *Hit) int64 {
+	if hit.Details == nil {
+		return 0
+	}
+
+	return hit.Details.Timestamp
+}
+
+// mergeSliceResults returns a Result with the totals of the given results of
+// each slice of a query.
+func mergeSliceResults(results []*Result) *Result {
+	merged := &Result{HitSet: &HitSet{}}
+
+	for _, result := range results {
+		if result.Took > merged.Took {
+			merged.Took = result.Took
+		}
+
+		merged.TimedOut = merged.TimedOut || result.TimedOut
+
+		if result.HitSet != nil {
+			merged.HitSet.Total.Add(result.HitSet.Total)
+			merged.HitSet.Hits = append(merged.HitSet.Hits, result.HitSet.Hits...)
+		}
+	}
+
+	return merged
+}
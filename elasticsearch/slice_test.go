@@ -0,0 +1,136 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const slicedTestHits = 10
+
+// slicedTransport is an http.RoundTripper that answers search requests with
+// hits timestamped 0..slicedTestHits-1, giving each slice of a sliced query the
+// hits whose timestamps modulo the number of slices are the slice's ID.
+type slicedTransport struct {
+	mu     sync.Mutex
+	slices []*Slice
+}
+
+func (s *slicedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jsonStr := mockVersionJSON
+
+	if req.Body != nil && req.Method == http.MethodPost {
+		query := &Query{}
+
+		if err := json.NewDecoder(req.Body).Decode(query); err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		s.slices = append(s.slices, query.Slice)
+		s.mu.Unlock()
+
+		jsonStr = slicedHitsJSON(query.Slice)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(jsonStr)),
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+	}, nil
+}
+
+func slicedHitsJSON(slice *Slice) string {
+	var hits []string
+
+	for ts := 0; ts < slicedTestHits; ts++ {
+		if slice != nil && ts%slice.Max != slice.ID {
+			continue
+		}
+
+		hits = append(hits, `{"_id": "`+strconv.Itoa(ts)+`", "_source": {"timestamp": `+strconv.Itoa(ts)+`}}`)
+	}
+
+	return `{"took": 1, "hits": {"total": {"value": ` + strconv.Itoa(len(hits)) + `}, "hits": [` +
+		strings.Join(hits, ",") + `]}}`
+}
+
+func TestSlicedScroll(t *testing.T) {
+	Convey("Given a client configured with ScrollSlices", t, func() {
+		transport := &slicedTransport{}
+
+		client, err := NewClient(Config{
+			Host:         "mock",
+			Scheme:       "http",
+			Port:         mockPort,
+			Index:        "mock-*",
+			ScrollSlices: 3,
+			transport:    transport,
+		})
+		So(err, ShouldBeNil)
+
+		query := &Query{Size: MaxSize, Sort: []string{"timestamp", "_doc"}}
+
+		var ids []string
+
+		cb := func(hit *Hit) {
+			ids = append(ids, hit.ID)
+		}
+
+		Convey("Scroll() queries each slice and gives you all hits in timestamp order", func() {
+			result, err := client.Scroll(query, cb)
+			So(err, ShouldBeNil)
+			So(result.HitSet.Total.Value, ShouldEqual, slicedTestHits)
+			So(ids, ShouldResemble, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"})
+
+			seen := make(map[int]bool)
+
+			for _, slice := range transport.slices {
+				So(slice, ShouldNotBeNil)
+				So(slice.Max, ShouldEqual, 3)
+				seen[slice.ID] = true
+			}
+
+			So(seen, ShouldResemble, map[int]bool{0: true, 1: true, 2: true})
+			So(query.Slice, ShouldBeNil)
+		})
+
+		Convey("Scroll() doesn't slice without a callback", func() {
+			result, err := client.Scroll(query, nil)
+			So(err, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, slicedTestHits)
+			So(transport.slices, ShouldResemble, []*Slice{nil})
+		})
+	})
+}
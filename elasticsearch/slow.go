@@ -0,0 +1,78 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"time"
+)
+
+// SlowQuery describes a query that took a long time to answer, with details
+// that might suggest why, or how indexing could be improved.
+type SlowQuery struct {
+	Kind    string            `json:"kind"`
+	Time    time.Time         `json:"time"`
+	TookMS  float64           `json:"took_ms"`
+	Items   int               `json:"items"`
+	GTE     string            `json:"gte,omitempty"`
+	LT      string            `json:"lt,omitempty"`
+	LTE     string            `json:"lte,omitempty"`
+	Filters map[string]string `json:"filters"`
+	Scanned int64             `json:"scanned,omitempty"`
+	Key     string            `json:"key"`
+}
+
+// NewSlowQuery returns a SlowQuery describing the given query of the given
+// kind (eg. "scroll"), which took the given time to get the given number of
+// items (hits, buckets or values). Its Scanned is the query's Scanned().
+func NewSlowQuery(kind string, query *Query, took time.Duration, items int) SlowQuery {
+	sq := SlowQuery{
+		Kind:    kind,
+		Time:    time.Now(),
+		TookMS:  float64(took) / float64(time.Millisecond),
+		Items:   items,
+		Filters: query.Filters(),
+		Scanned: query.Scanned(),
+		Key:     query.Key(),
+	}
+
+	lt, lte, gte, err := query.DateRange()
+	if err == nil {
+		sq.GTE = formatRangeTime(gte)
+		sq.LT = formatRangeTime(lt)
+		sq.LTE = formatRangeTime(lte)
+	}
+
+	return sq
+}
+
+// formatRangeTime formats non-zero times as RFC3339, and zero times as blank.
+func formatRangeTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,92 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ErrUnsupportedSort = "unsupported sort"
+
+	sortFieldDoc       = "_doc"
+	sortFieldTimestamp = "timestamp"
+	sortOrderAsc       = "asc"
+	sortOrderDesc      = "desc"
+)
+
+// SortOrder describes how a Query wants its hits ordered.
+type SortOrder int
+
+const (
+	// SortNone means hits can be in any order, as when sorting on _doc.
+	SortNone SortOrder = iota
+
+	// SortTimestampAsc means hits are wanted oldest first.
+	SortTimestampAsc
+
+	// SortTimestampDesc means hits are wanted newest first.
+	SortTimestampDesc
+)
+
+// TimestampSort returns the order the query's Sort wants hits in. Sort entries
+// are a field name, optionally suffixed with ":asc" or ":desc", and the only
+// supported fields are timestamp and _doc (ascending only). The first entry
+// decides the order; later entries only break ties, which _doc order does
+// anyway. Returns an ErrUnsupportedSort Error for anything else.
+func (q *Query) TimestampSort() (SortOrder, error) {
+	order := SortNone
+
+	for i, entry := range q.Sort {
+		this, err := parseSortEntry(entry)
+		if err != nil {
+			return SortNone, err
+		}
+
+		if i == 0 {
+			order = this
+		}
+	}
+
+	return order, nil
+}
+
+// parseSortEntry parses a single "field[:order]" Sort entry.
+func parseSortEntry(entry string) (SortOrder, error) {
+	field, direction, _ := strings.Cut(entry, ":")
+
+	switch {
+	case field == sortFieldDoc && (direction == "" || direction == sortOrderAsc):
+		return SortNone, nil
+	case field == sortFieldTimestamp && (direction == "" || direction == sortOrderAsc):
+		return SortTimestampAsc, nil
+	case field == sortFieldTimestamp && direction == sortOrderDesc:
+		return SortTimestampDesc, nil
+	}
+
+	return SortNone, Error{Msg: ErrUnsupportedSort, cause: fmt.Sprintf("%q", entry)}
+}
@@ -0,0 +1,71 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTimestampSort(t *testing.T) {
+	Convey("TimestampSort returns the order wanted by the first Sort entry", t, func() {
+		for _, test := range []struct {
+			sort     []string
+			expected SortOrder
+		}{
+			{nil, SortNone},
+			{[]string{"_doc"}, SortNone},
+			{[]string{"_doc:asc", "timestamp:desc"}, SortNone},
+			{[]string{"timestamp"}, SortTimestampAsc},
+			{[]string{"timestamp:asc", "_doc"}, SortTimestampAsc},
+			{[]string{"timestamp:desc", "_doc"}, SortTimestampDesc},
+		} {
+			order, err := (&Query{Sort: test.sort}).TimestampSort()
+			So(err, ShouldBeNil)
+			So(order, ShouldEqual, test.expected)
+		}
+	})
+
+	Convey("Unsupported fields and orders are errors", t, func() {
+		for _, sort := range [][]string{{"USER_NAME"}, {"_doc:desc"}, {"timestamp:up"}, {"timestamp", "BOM"}} {
+			_, err := (&Query{Sort: sort}).TimestampSort()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrUnsupportedSort)
+		}
+	})
+
+	Convey("You can set the sort with a request parameter", t, func() {
+		query := &Query{}
+		query.handleRequestParams(url.Values{"sort": {"timestamp:desc,_doc"}})
+		So(query.Sort, ShouldResemble, []string{"timestamp:desc", "_doc"})
+
+		order, err := query.TimestampSort()
+		So(err, ShouldBeNil)
+		So(order, ShouldEqual, SortTimestampDesc)
+	})
+}
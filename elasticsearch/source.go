@@ -0,0 +1,238 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	sourceWildcard       = "*"
+	sourceParam          = "_source"
+	sourceIncludesParam  = "_source_includes"
+	sourceExcludesParam  = "_source_excludes"
+	sourceParamSeparator = ","
+)
+
+// queryJSON is a Query without our JSON methods, so we can use the default
+// encoding for everything but _source.
+type queryJSON Query
+
+// sourceObject is the object form of _source. The singular keys are older
+// elasticsearch synonyms.
+type sourceObject struct {
+	Includes stringOrStrings `json:"includes,omitempty"`
+	Include  stringOrStrings `json:"include,omitempty"`
+	Excludes stringOrStrings `json:"excludes,omitempty"`
+	Exclude  stringOrStrings `json:"exclude,omitempty"`
+}
+
+// stringOrStrings is a JSON string or array of strings.
+type stringOrStrings []string
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (s *stringOrStrings) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = []string{str}
+
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(s))
+}
+
+// UnmarshalJSON decodes a Query, accepting any of elasticsearch's forms of
+// _source: a bool, a field (pattern), an array of them, or an object with
+// includes and excludes arrays. _source false is treated as excluding all
+// fields.
+func (q *Query) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*queryJSON
+		Source json.RawMessage `json:"_source,omitempty"`
+	}{queryJSON: (*queryJSON)(q)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if len(aux.Source) == 0 {
+		return nil
+	}
+
+	return q.unmarshalSource(aux.Source)
+}
+
+// unmarshalSource sets our Source and SourceExcludes from the given _source
+// JSON.
+func (q *Query) unmarshalSource(data json.RawMessage) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		q.setSourceBool(b)
+
+		return nil
+	}
+
+	var obj sourceObject
+	if err := json.Unmarshal(data, &obj); err == nil {
+		q.Source = append(obj.Includes, obj.Include...)
+		q.SourceExcludes = append(obj.Excludes, obj.Exclude...)
+
+		return nil
+	}
+
+	var includes stringOrStrings
+	if err := json.Unmarshal(data, &includes); err != nil {
+		return err
+	}
+
+	q.Source = includes
+
+	return nil
+}
+
+// setSourceBool sets our Source and SourceExcludes according to a _source
+// bool.
+func (q *Query) setSourceBool(b bool) {
+	q.Source = nil
+	q.SourceExcludes = nil
+
+	if !b {
+		q.SourceExcludes = []string{sourceWildcard}
+	}
+}
+
+// MarshalJSON encodes a Query, with _source as an array of fields, or as an
+// object if we have SourceExcludes.
+func (q *Query) MarshalJSON() ([]byte, error) {
+	var source interface{}
+
+	switch {
+	case len(q.SourceExcludes) > 0:
+		source = sourceObject{Includes: q.Source, Excludes: q.SourceExcludes}
+	case len(q.Source) > 0:
+		source = q.Source
+	}
+
+	return json.Marshal(struct {
+		*queryJSON
+		Source interface{} `json:"_source,omitempty"`
+	}{queryJSON: (*queryJSON)(q), Source: source})
+}
+
+// handleSourceParams sets our Source and SourceExcludes from any _source,
+// _source_includes and _source_excludes request parameters.
+func (q *Query) handleSourceParams(get func(string) string) {
+	switch param := get(sourceParam); param {
+	case "":
+	case "true", "false":
+		q.setSourceBool(param == "true")
+	default:
+		q.Source = strings.Split(param, sourceParamSeparator)
+	}
+
+	if param := get(sourceIncludesParam); param != "" {
+		q.Source = strings.Split(param, sourceParamSeparator)
+	}
+
+	if param := get(sourceExcludesParam); param != "" {
+		q.SourceExcludes = strings.Split(param, sourceParamSeparator)
+	}
+}
+
+// SelectedSourceFields returns our Source if it is a plain list of field names
+// and we have no SourceExcludes. Otherwise returns the SourceFields() that our
+// Source and SourceExcludes select, in that order. Returns nil if we have
+// neither.
+func (q *Query) SelectedSourceFields() []string {
+	if len(q.SourceExcludes) == 0 && !strings.Contains(strings.Join(q.Source, ""), sourceWildcard) {
+		return q.Source
+	}
+
+	var names []string
+
+	for _, name := range SourceFields() {
+		if q.wantsSourceField(name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// wantsSourceField returns true if the given _source field name matches one of
+// our Source patterns (or we have none), and none of our SourceExcludes.
+func (q *Query) wantsSourceField(name string) bool {
+	included := len(q.Source) == 0
+
+	for _, pattern := range q.Source {
+		if matchSourcePattern(pattern, name) {
+			included = true
+
+			break
+		}
+	}
+
+	if !included {
+		return false
+	}
+
+	for _, pattern := range q.SourceExcludes {
+		if matchSourcePattern(pattern, name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchSourcePattern returns true if the given name matches the given _source
+// pattern, where * matches any characters.
+func matchSourcePattern(pattern, name string) bool {
+	parts := strings.Split(pattern, sourceWildcard)
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	rest, ok := strings.CutPrefix(name, parts[0])
+	if !ok {
+		return false
+	}
+
+	last := parts[len(parts)-1]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+
+		rest = rest[i+len(part):]
+	}
+
+	return len(rest) >= len(last) && strings.HasSuffix(rest, last)
+}
@@ -0,0 +1,152 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSource(t *testing.T) {
+	Convey("You can give _source in any of elasticsearch's forms", t, func() {
+		parse := func(source string) *Query {
+			query, err := newQueryFromReader(strings.NewReader(`{"size":1,"_source":` + source + `}`))
+			So(err, ShouldBeNil)
+			So(query.Size, ShouldEqual, 1)
+
+			return query
+		}
+
+		query := parse(`"USER_NAME"`)
+		So(query.Source, ShouldResemble, []string{"USER_NAME"})
+		So(query.SourceExcludes, ShouldBeNil)
+
+		query = parse(`["USER_*","BOM"]`)
+		So(query.Source, ShouldResemble, []string{"USER_*", "BOM"})
+
+		query = parse(`{"includes":["*_NAME"],"excludes":"JOB_NAME"}`)
+		So(query.Source, ShouldResemble, []string{"*_NAME"})
+		So(query.SourceExcludes, ShouldResemble, []string{"JOB_NAME"})
+
+		query = parse(`{"include":"BOM","exclude":["*"]}`)
+		So(query.Source, ShouldResemble, []string{"BOM"})
+		So(query.SourceExcludes, ShouldResemble, []string{"*"})
+
+		query = parse(`true`)
+		So(query.Source, ShouldBeNil)
+		So(query.SourceExcludes, ShouldBeNil)
+		So(query.DesiredFields(), ShouldEqual, 0)
+
+		query = parse(`false`)
+		So(query.SourceExcludes, ShouldResemble, []string{"*"})
+		So(query.DesiredFields(), ShouldEqual, FieldNone)
+
+		_, err := newQueryFromReader(strings.NewReader(`{"_source":1}`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Wildcards and excludes select the desired fields", t, func() {
+		query := &Query{Source: []string{"*_NAME", "BOM"}, SourceExcludes: []string{"JOB_*"}}
+
+		desired := query.DesiredFields()
+		So(desired, ShouldEqual, FieldAccountingName|FieldQueueName|FieldUserName|FieldClusterName|FieldProjectName|FieldBOM)
+		So(query.SelectedSourceFields(), ShouldResemble,
+			[]string{"ACCOUNTING_NAME", "BOM", "QUEUE_NAME", "USER_NAME", "CLUSTER_NAME", "PROJECT_NAME"})
+
+		query = &Query{SourceExcludes: []string{"*"}}
+		So(query.DesiredFields(), ShouldEqual, FieldNone)
+		So(WantsField(query.DesiredFields(), FieldBOM), ShouldBeFalse)
+
+		query = &Query{Source: []string{"nonexistent"}}
+		So(query.DesiredFields(), ShouldEqual, FieldNone)
+
+		query = &Query{Source: []string{"USER_NAME", "_id"}}
+		So(query.SelectedSourceFields(), ShouldResemble, []string{"USER_NAME", "_id"})
+
+		So(matchSourcePattern("RAW_*_SEC*", "RAW_CPU_TIME_SEC"), ShouldBeTrue)
+		So(matchSourcePattern("*SEC*SEC", "RAW_CPU_TIME_SEC"), ShouldBeFalse)
+		So(matchSourcePattern("A*A", "A"), ShouldBeFalse)
+		So(matchSourcePattern("*", ""), ShouldBeTrue)
+	})
+
+	Convey("_source is marshalled as an array, or as an object if there are excludes", t, func() {
+		query := &Query{Source: []string{"USER_*"}}
+		body, err := query.MarshalJSON()
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, `"_source":["USER_*"]`)
+
+		key := query.Key()
+
+		query.SourceExcludes = []string{"USER_NAME"}
+		body, err = query.MarshalJSON()
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, `"_source":{"includes":["USER_*"],"excludes":["USER_NAME"]}`)
+		So(query.Key(), ShouldNotEqual, key)
+
+		roundTripped, err := newQueryFromReader(strings.NewReader(string(body)))
+		So(err, ShouldBeNil)
+		So(roundTripped.Source, ShouldResemble, query.Source)
+		So(roundTripped.SourceExcludes, ShouldResemble, query.SourceExcludes)
+
+		body, err = (&Query{}).MarshalJSON()
+		So(err, ShouldBeNil)
+		So(string(body), ShouldNotContainSubstring, "_source")
+	})
+
+	Convey("You can set _source with request parameters", t, func() {
+		query := &Query{}
+		query.handleRequestParams(url.Values{
+			"_source_includes": {"USER_NAME,BOM"},
+			"_source_excludes": {"BOM"},
+		})
+		So(query.Source, ShouldResemble, []string{"USER_NAME", "BOM"})
+		So(query.SourceExcludes, ShouldResemble, []string{"BOM"})
+		So(query.DesiredFields(), ShouldEqual, FieldUserName)
+
+		query = &Query{Source: []string{"BOM"}}
+		query.handleRequestParams(url.Values{"_source": {"false"}})
+		So(query.Source, ShouldBeNil)
+		So(query.DesiredFields(), ShouldEqual, FieldNone)
+	})
+
+	Convey("Hits are marshalled with just the selected fields", t, func() {
+		result := &Result{HitSet: &HitSet{Hits: []Hit{{ID: "1", Details: &Details{
+			AccountingName: "a", UserName: "u", JobName: "j", BOM: "b",
+		}}}}}
+
+		query := &Query{Source: []string{"*_NAME"}, SourceExcludes: []string{"JOB_NAME"}}
+
+		body, err := result.MarshalFields(query.DesiredFields())
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, `"ACCOUNTING_NAME":"a"`)
+		So(string(body), ShouldContainSubstring, `"USER_NAME":"u"`)
+		So(string(body), ShouldNotContainSubstring, "JOB_NAME")
+		So(string(body), ShouldNotContainSubstring, "BOM")
+	})
+}
@@ -0,0 +1,90 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"io"
+
+	jwriter "github.com/mailru/easyjson/jwriter"
+)
+
+const (
+	// streamChunkBytes is about how much JSON MarshalFieldsTo() writes at a
+	// time.
+	streamChunkBytes = 1024 * 1024
+
+	// streamCheckHits is how many hits MarshalFieldsTo() marshals between
+	// checks of how much JSON it has.
+	streamCheckHits = 256
+)
+
+// flusher is implemented by writers, like http.ResponseWriter, that can send on
+// what has been written to them so far.
+type flusher interface {
+	Flush()
+}
+
+// MarshalFieldsTo is like MarshalFields(), but writes the JSON to w in chunks
+// of about 1MB as the hits are marshalled, so that the JSON of a large Result
+// never has to be held in memory all at once. If w has a Flush() method (like
+// an http.ResponseWriter), it is called after each chunk, so that the start of
+// the JSON can be sent to a client sooner.
+//
+// If writing to w fails, no further hits are marshalled and the error is
+// returned.
+func (v *Result) MarshalFieldsTo(w io.Writer, desired Fields) error {
+	out := &jwriter.Writer{}
+	f, canFlush := w.(flusher)
+	hits := 0
+
+	dump := func(out *jwriter.Writer) {
+		if _, err := out.Buffer.DumpTo(w); err != nil {
+			out.Error = err
+
+			return
+		}
+
+		if canFlush {
+			f.Flush()
+		}
+	}
+
+	marshalFieldsResult(out, v, desired, func(out *jwriter.Writer) {
+		hits++
+
+		if hits%streamCheckHits == 0 && out.Buffer.Size() >= streamChunkBytes {
+			dump(out)
+		}
+	})
+
+	if out.Error != nil {
+		return out.Error
+	}
+
+	dump(out)
+
+	return out.Error
+}
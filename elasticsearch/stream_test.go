@@ -0,0 +1,125 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingWriter is an io.Writer that fails once it has been written to a
+// certain number of times.
+type failingWriter struct {
+	writes    int
+	failAfter int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+
+	if f.writes > f.failAfter {
+		return 0, errors.New("client went away")
+	}
+
+	return len(p), nil
+}
+
+// flushCountingBuffer is a bytes.Buffer that counts how often it is flushed.
+type flushCountingBuffer struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (f *flushCountingBuffer) Flush() {
+	f.flushes++
+}
+
+// flushCountingFailingWriter is a failingWriter that counts how often it is
+// flushed.
+type flushCountingFailingWriter struct {
+	failingWriter
+	flushes int
+}
+
+func (f *flushCountingFailingWriter) Flush() {
+	f.flushes++
+}
+
+func TestMarshalFieldsTo(t *testing.T) {
+	Convey("Given a large Result", t, func() {
+		hits := make([]Hit, 50000)
+		for i := range hits {
+			id := strconv.Itoa(i)
+			hits[i] = Hit{ID: id, Details: &Details{ID: id, UserName: "user" + id, JobName: "a fairly long job name"}}
+		}
+
+		result := &Result{ScrollID: "id", HitSet: &HitSet{Total: HitSetTotal{Value: len(hits)}, Hits: hits}}
+		desired := FieldUserName | FieldJobName
+
+		expected, err := result.MarshalFields(desired)
+		So(err, ShouldBeNil)
+		So(len(expected), ShouldBeGreaterThan, 2*streamChunkBytes)
+
+		Convey("you can MarshalFieldsTo() a writer in flushed chunks, getting the same JSON", func() {
+			buf := &flushCountingBuffer{}
+
+			err = result.MarshalFieldsTo(buf, desired)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(buf.Bytes(), expected), ShouldBeTrue)
+			So(buf.flushes, ShouldEqual, len(expected)/streamChunkBytes+1)
+
+			var plain bytes.Buffer
+
+			err = result.MarshalFieldsTo(&plain, desired)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(plain.Bytes(), expected), ShouldBeTrue)
+		})
+
+		Convey("writing stops if the writer fails", func() {
+			w := &flushCountingFailingWriter{}
+
+			err = result.MarshalFieldsTo(w, desired)
+			So(err, ShouldNotBeNil)
+			So(w.writes, ShouldEqual, 1)
+			So(w.flushes, ShouldEqual, 0)
+		})
+
+		Convey("small results are written in one go", func() {
+			result.HitSet.Hits = hits[:1]
+			buf := &flushCountingBuffer{}
+
+			err = result.MarshalFieldsTo(buf, desired)
+			So(err, ShouldBeNil)
+			So(buf.flushes, ShouldEqual, 1)
+			So(buf.String(), ShouldEqual, `{"_scroll_id":"id","took":0,"timed_out":false,"hits":{"total":{"value":50000,"relation":"eq"},`+
+				`"hits":[{"_id":"0","_source":{"JOB_NAME":"a fairly long job name","USER_NAME":"user0"}}]}}`)
+		})
+	})
+}
@@ -0,0 +1,109 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	es "github.com/elastic/go-elasticsearch/v7"
+)
+
+// timeoutTransport is an http.RoundTripper that gives up on requests that
+// take longer than a certain time, including reading their response bodies.
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+// newTimeoutTransport returns a timeoutTransport that uses the given
+// RoundTripper, or http.DefaultTransport if that is nil.
+func newTimeoutTransport(next http.RoundTripper, timeout time.Duration) *timeoutTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &timeoutTransport{next: next, timeout: timeout}
+}
+
+// RoundTrip makes the request with a context that expires after our timeout.
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// cancelOnClose is an io.ReadCloser that cancels a context when closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the ReadCloser and cancels the context.
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+
+	return err
+}
+
+// retrySettings configures cfg to retry requests that time out or fail with a
+// 5xx or 429 status, as per config's MaxRetries and RetryBackoff.
+func retrySettings(cfg *es.Config, config Config) {
+	if config.MaxRetries < 0 {
+		cfg.DisableRetry = true
+
+		return
+	}
+
+	cfg.MaxRetries = config.MaxRetries
+	cfg.EnableRetryOnTimeout = true
+	cfg.RetryOnStatus = []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+
+	if config.RetryBackoff > 0 {
+		base := config.RetryBackoff
+		cfg.RetryBackoff = func(attempt int) time.Duration {
+			return base << (attempt - 1)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slowTransport is an http.RoundTripper that takes delay to respond to
+// searches, unless the request's context is done first.
+type slowTransport struct {
+	delay time.Duration
+	ctx   context.Context
+}
+
+func (s *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.ctx = req.Context()
+
+	if req.URL.Path != "/" {
+		select {
+		case <-time.After(s.delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(testNonAggQueryResponseZeroSize)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			productHeader:  []string{productName},
+		},
+	}, nil
+}
+
+func TestRequestTimeout(t *testing.T) {
+	Convey("Given a slow server", t, func() {
+		transport := &slowTransport{delay: 50 * time.Millisecond}
+		config := Config{
+			Host:      "mock",
+			Scheme:    "http",
+			Port:      mockPort,
+			Index:     "mock-*",
+			transport: transport,
+		}
+		query := &Query{}
+
+		Convey("searches without a RequestTimeout wait for it", func() {
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Search(query)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("searches that exceed the RequestTimeout are retried then fail", func() {
+			config.RequestTimeout = 10 * time.Millisecond
+			config.MaxRetries = 1
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			start := time.Now()
+			_, err = client.Search(query)
+			So(err, ShouldEqual, context.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, transport.delay)
+		})
+	})
+
+	Convey("A timeoutTransport's context lasts until the response body is closed", t, func() {
+		transport := &slowTransport{}
+		tt := newTimeoutTransport(transport, time.Minute)
+
+		resp, err := tt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+		So(err, ShouldBeNil)
+		So(transport.ctx.Err(), ShouldBeNil)
+
+		err = resp.Body.Close()
+		So(err, ShouldBeNil)
+		So(transport.ctx.Err(), ShouldEqual, context.Canceled)
+	})
+}
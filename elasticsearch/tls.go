@@ -0,0 +1,124 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+)
+
+const ErrInvalidCACert = "no certificates found in CA cert file"
+
+// baseTransport returns the configured transport if set (for testing), or,
+// if any TLS options were configured, an http.Transport that uses them. It
+// returns nil if neither apply, meaning the default transport should be used.
+func baseTransport(config Config) (http.RoundTripper, error) {
+	if config.transport != nil {
+		return config.transport, nil
+	}
+
+	if !config.hasTLSOptions() {
+		return nil, nil //nolint:nilnil
+	}
+
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// HTTPTransport returns an http.RoundTripper that connects to our server with
+// our TLS options, for use by things that talk to the server directly, like a
+// reverse proxy.
+func (c Config) HTTPTransport() (http.RoundTripper, error) {
+	transport, err := baseTransport(c)
+	if err != nil || transport != nil {
+		return transport, err
+	}
+
+	return http.DefaultTransport, nil
+}
+
+func (c Config) hasTLSOptions() bool {
+	return c.CACert != "" || c.InsecureSkipVerify || c.ClientCert != "" || c.ClientKey != ""
+}
+
+// tlsConfig returns a tls.Config that trusts the certificates in our CACert
+// file (in addition to the system's), skips verification if our
+// InsecureSkipVerify is true, and presents our ClientCert and ClientKey.
+func (c Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.CACert != "" {
+		pool, err := caCertPool(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// caCertPool returns the system's certificate pool with the PEM certificates
+// in the given file added.
+func caCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, Error{Msg: ErrInvalidCACert, cause: path}
+	}
+
+	return pool, nil
+}
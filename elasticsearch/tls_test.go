@@ -0,0 +1,174 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTLS(t *testing.T) {
+	Convey("Given an https server that wants client certificates and an API key", t, func() {
+		var authorization string
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+
+			w.Header().Set(productHeader, productName)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(mockVersionJSON)) //nolint:errcheck
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.StartTLS()
+
+		defer server.Close()
+
+		dir := t.TempDir()
+		certPath, keyPath := writeTestCertAndKey(t, dir, server.TLS.Certificates[0])
+
+		host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+		So(err, ShouldBeNil)
+
+		port, err := strconv.Atoi(portStr)
+		So(err, ShouldBeNil)
+
+		config := Config{
+			Host:       host,
+			Port:       port,
+			Scheme:     "https",
+			APIKey:     "a2V5",
+			CACert:     certPath,
+			ClientCert: certPath,
+			ClientKey:  keyPath,
+		}
+
+		Convey("you can connect with a CA cert, client cert and API key", func() {
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			info, err := client.Info()
+			So(err, ShouldBeNil)
+			So(info.Version.Number, ShouldEqual, testExpectedVersion)
+			So(authorization, ShouldEqual, "APIKey a2V5")
+		})
+
+		Convey("you can connect without a CA cert if you skip verification", func() {
+			config.CACert = ""
+			config.InsecureSkipVerify = true
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Info()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("you can't connect without a CA cert", func() {
+			config.CACert = ""
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Info()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("you can't connect without a client cert", func() {
+			config.ClientCert = ""
+			config.ClientKey = ""
+
+			client, err := NewClient(config)
+			So(err, ShouldBeNil)
+
+			_, err = client.Info()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("invalid CA cert files are rejected", func() {
+			config.CACert = keyPath
+
+			_, err := NewClient(config)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, ErrInvalidCACert)
+
+			_, err = config.HTTPTransport()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("you can get an HTTPTransport() for talking to it directly", func() {
+			transport, err := config.HTTPTransport()
+			So(err, ShouldBeNil)
+
+			resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			resp.Body.Close()
+
+			transport, err = Config{}.HTTPTransport()
+			So(err, ShouldBeNil)
+			So(transport, ShouldEqual, http.DefaultTransport)
+		})
+	})
+}
+
+// writeTestCertAndKey writes the given certificate and its private key to PEM
+// files in the given directory, returning their paths.
+func writeTestCertAndKey(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	t.Helper()
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
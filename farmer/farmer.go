@@ -0,0 +1,440 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// Package farmer lets you embed a fully wired farmer server in your own
+// program, instead of running the farmer binary.
+package farmer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/analytics"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/server"
+)
+
+// DefaultCacheEntries is the number of entries in each of a Farmer's caches if
+// the Config doesn't say.
+const DefaultCacheEntries = 128
+
+// ErrReadOnlyBackfill is returned by New() if the Config has a Backfill
+// schedule but a database is ReadOnly.
+var ErrReadOnlyBackfill = errors.New("can't schedule backfills of a read-only database")
+
+// Config configures a Farmer.
+type Config struct {
+	// Elastic configures the client of the real elasticsearch, and its Index
+	// is the one whose searches we answer. Requests we don't answer ourselves
+	// are proxied to its Addresses, or its Scheme, Host and Port.
+	Elastic es.Config
+
+	// DB configures the local database that scroll searches are answered
+	// from.
+	DB db.Config
+
+	Cache CacheConfig
+
+	// QueryTimeout, if greater than 0, limits how long each query can take.
+	QueryTimeout time.Duration
+
+	Auth         server.Auth
+	RateLimit    server.RateLimit
+	ScrollPaging bool
+
+	// Dashboard enables the Server's dashboard, which needs admin credentials
+	// unless DashboardOpen is true (see server.EnableDashboard()).
+	Dashboard     bool
+	DashboardOpen bool
+
+	// Backfill, if not nil, makes the Farmer backfill its database(s) itself
+	// every day. It can't be used if any database is ReadOnly, and a ReadOnly
+	// DB also makes the Server refuse /admin/backfill requests.
+	Backfill *BackfillSchedule
+
+	// Farms are other indexes whose searches we answer from their own
+	// elasticsearch and database, using our other settings.
+	Farms []FarmConfig
+
+	// AnalyticsFile, if set, is the path of an analytics.Store that every
+	// query is recorded in, for AnalyticsRetention (or
+	// analytics.DefaultRetention if 0).
+	AnalyticsFile      string
+	AnalyticsRetention time.Duration
+}
+
+// CacheConfig configures the CachedQuerier of each farm of a Farmer. Sizes that
+// are 0 default to DefaultCacheEntries. See the CachedQuerier Set*() methods
+// for the other settings.
+type CacheConfig struct {
+	Sizes              cache.Sizes
+	StreamMinHits      int
+	SlowQueryThreshold time.Duration
+	RevalidateAge      time.Duration
+}
+
+// BackfillSchedule says when to backfill each day: At is the time after
+// midnight UTC, and Period is how many days before then to backfill.
+type BackfillSchedule struct {
+	At     time.Duration
+	Period time.Duration
+}
+
+// FarmConfig configures an additional farm of a Farmer.
+type FarmConfig struct {
+	Elastic es.Config
+	DB      db.Config
+}
+
+// Farmer is a Server, answering searches of the configured index (and those
+// of any configured Farms) from a CachedQuerier of a real elasticsearch and a
+// local database.
+//
+// Serve the Server yourself (it is an http.Handler, and can also give you a
+// GRPCServer()). When shutting down, stop serving, then Drain() and Close()
+// the Farmer.
+type Farmer struct {
+	*Farm
+	Server *server.Server
+
+	// Farms are those of the Config's Farms, in the same order.
+	Farms []*Farm
+
+	// Analytics is the Store queries are recorded in, if the Config has an
+	// AnalyticsFile.
+	Analytics *analytics.Store
+
+	metrics *cache.Metrics
+	mu      sync.Mutex
+}
+
+// Farm is the elasticsearch client, local database and CachedQuerier used to
+// answer searches of one index.
+type Farm struct {
+	Index  string
+	Client *es.Client
+	DB     db.Backend
+
+	querier  atomic.Pointer[cache.CachedQuerier]
+	backfill *db.ScheduledBackfill
+}
+
+// Querier returns the farm's current CachedQuerier, which is replaced by
+// Farmer.Reconfigure().
+func (f *Farm) Querier() *cache.CachedQuerier {
+	return f.querier.Load()
+}
+
+// New opens the configured local databases, which can take a while, and
+// returns a Farmer whose Server is ready to serve.
+func New(config Config) (*Farmer, error) {
+	proxyTargets, err := ElasticURLs(config.Elastic)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyTransport, err := config.Elastic.HTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Farmer{metrics: cache.NewMetrics()}
+
+	if f.Farm, err = f.openFarm(config, config.Elastic, config.DB); err != nil {
+		return nil, err
+	}
+
+	s := server.New(f.Querier(), f.Index, proxyTargets[0])
+	s.SetProxyTargets(proxyTargets...)
+	s.SetProxyTransport(proxyTransport)
+	s.SetTimeout(config.QueryTimeout)
+	s.AddMetrics(f.Client.Metrics(), f.metrics)
+
+	if fdb, ok := f.DB.(*db.DB); ok {
+		s.AddMetrics(fdb.PoolMetrics())
+	}
+
+	s.SetAuth(config.Auth)
+	s.SetRateLimit(config.RateLimit)
+	s.SetDataSource(f.DB)
+	s.SetReloader(f.DB)
+	s.SetTeamSummarizer(f.DB)
+	s.SetGPUReporter(f.DB)
+	s.SetBackfiller(backfillFunc(f.Client, config.DB))
+	s.SetReadOnly(config.DB.ReadOnly)
+	s.SetScrollPaging(config.ScrollPaging)
+
+	if config.Dashboard {
+		s.EnableDashboard(config.DashboardOpen)
+	}
+
+	f.Server = s
+
+	if config.AnalyticsFile != "" {
+		if f.Analytics, err = analytics.Open(config.AnalyticsFile, config.AnalyticsRetention); err != nil {
+			return nil, errors.Join(err, f.Close())
+		}
+
+		s.SetQueryRecorder(f.Analytics)
+	}
+
+	for _, fc := range config.Farms {
+		farm, errf := f.openFarm(config, fc.Elastic, fc.DB)
+		if errf != nil {
+			return nil, errors.Join(errf, f.Close())
+		}
+
+		s.AddIndex(farm.Index, farm.Querier(), farm.DB)
+
+		f.Farms = append(f.Farms, farm)
+	}
+
+	return f, nil
+}
+
+// openFarm creates a client of the given elasticsearch, opens the given
+// database and creates a CachedQuerier of them with the config's cache
+// settings, and schedules backfills if configured.
+func (f *Farmer) openFarm(config Config, esConfig es.Config, dbConfig db.Config) (*Farm, error) {
+	if config.Backfill != nil && dbConfig.ReadOnly {
+		return nil, fmt.Errorf("%w for %s", ErrReadOnlyBackfill, esConfig.Index)
+	}
+
+	client, err := es.NewClient(esConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create elasticsearch client for %s: %w", esConfig.Index, err)
+	}
+
+	ldb, err := db.Open(dbConfig, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local database for %s: %w", esConfig.Index, err)
+	}
+
+	farm := &Farm{Index: esConfig.Index, Client: client, DB: ldb}
+
+	if err = f.setQuerier(farm, config.Cache); err != nil {
+		return nil, errors.Join(err, ldb.Close())
+	}
+
+	if config.Backfill != nil {
+		farm.backfill = db.ScheduleBackfill(client, dbConfig, ldb, config.Backfill.At, config.Backfill.Period)
+	}
+
+	return farm, nil
+}
+
+// setQuerier gives the farm a new CachedQuerier of its client and database with
+// the given cache settings.
+func (f *Farmer) setQuerier(farm *Farm, config CacheConfig) error {
+	cq, err := cache.NewWithSizes(farm.Client, farm.DB, cacheSizes(config.Sizes))
+	if err != nil {
+		return err
+	}
+
+	cq.SetStreamThreshold(config.StreamMinHits)
+	cq.SetSlowQueryThreshold(config.SlowQueryThreshold)
+	cq.SetRevalidateAge(config.RevalidateAge)
+	cq.SetMetrics(f.metrics)
+
+	farm.querier.Store(cq)
+
+	return nil
+}
+
+// cacheSizes returns the given sizes, with those that are 0 replaced with
+// DefaultCacheEntries.
+func cacheSizes(sizes cache.Sizes) cache.Sizes {
+	for _, size := range []*int{&sizes.Search, &sizes.Scroll, &sizes.Distinct} {
+		if *size <= 0 {
+			*size = DefaultCacheEntries
+		}
+	}
+
+	return sizes
+}
+
+// backfillFunc returns a server.BackfillFunc that does a db.Backfill() using
+// the given client and database config.
+func backfillFunc(client *es.Client, config db.Config) server.BackfillFunc {
+	return func(from time.Time, period time.Duration, progress func(done, total int)) (*db.BackfillReport, error) {
+		dbConfig := config
+		dbConfig.BackfillProgress = progress
+
+		return db.Backfill(client, dbConfig, from, period)
+	}
+}
+
+// Reconfigure applies the given config's Elastic settings (eg. new
+// credentials) to our clients and proxy, replaces our farms' CachedQueriers
+// with new ones using its Cache settings, and applies its DB UpdateFrequency
+// to our databases. Its Farms must have the same indexes as ours; farms can't
+// be added or removed this way, and other settings are ignored.
+func (f *Farmer) Reconfigure(config Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	proxyTargets, err := ElasticURLs(config.Elastic)
+	if err != nil {
+		return err
+	}
+
+	farmConfigs := make([]FarmConfig, len(f.Farms))
+
+	for i, farm := range f.Farms {
+		fc, ok := farmConfigOf(config.Farms, farm.Index)
+		if !ok {
+			return fmt.Errorf("index %q is not configured", farm.Index)
+		}
+
+		farmConfigs[i] = fc
+	}
+
+	if err = f.reconfigureFarm(f.Farm, config, config.Elastic, config.DB); err != nil {
+		return err
+	}
+
+	f.Server.SetProxyTargets(proxyTargets...)
+	f.Server.SetSearchScroller(f.Querier())
+
+	for i, farm := range f.Farms {
+		if err = f.reconfigureFarm(farm, config, farmConfigs[i].Elastic, farmConfigs[i].DB); err != nil {
+			return err
+		}
+
+		f.Server.SetIndexSearchScroller(farm.Index, farm.Querier())
+	}
+
+	return nil
+}
+
+// farmConfigOf returns the FarmConfig with the given index.
+func farmConfigOf(farms []FarmConfig, index string) (FarmConfig, bool) {
+	for _, fc := range farms {
+		if fc.Elastic.Index == index {
+			return fc, true
+		}
+	}
+
+	return FarmConfig{}, false
+}
+
+// reconfigureFarm applies the given settings to the farm like Reconfigure().
+func (f *Farmer) reconfigureFarm(farm *Farm, config Config, esConfig es.Config, dbConfig db.Config) error {
+	if err := farm.Client.Reconfigure(esConfig); err != nil {
+		return err
+	}
+
+	if err := f.setQuerier(farm, config.Cache); err != nil {
+		return err
+	}
+
+	farm.DB.SetUpdateFrequency(dbConfig.UpdateFrequency)
+
+	return nil
+}
+
+// Drain makes our Server refuse new queries and waits for those in flight to
+// finish (see Server.Drain()), then has our databases release their buffers
+// and unused files (see db.Backend.Drain()). Returns the context's error if it
+// is done first.
+func (f *Farmer) Drain(ctx context.Context) error {
+	if err := f.Server.Drain(ctx); err != nil {
+		return err
+	}
+
+	for _, farm := range f.allFarms() {
+		if err := farm.DB.Drain(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allFarms returns our own Farm followed by our Farms.
+func (f *Farmer) allFarms() []*Farm {
+	return append([]*Farm{f.Farm}, f.Farms...)
+}
+
+// Close stops scheduled backfills and closes our databases and any Analytics.
+func (f *Farmer) Close() error {
+	var errs []error
+
+	if f.Analytics != nil {
+		if err := f.Analytics.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close analytics: %w", err))
+		}
+	}
+
+	for _, farm := range append(slices.Clone(f.Farms), f.Farm) {
+		if farm.backfill != nil {
+			farm.backfill.Stop()
+		}
+
+		if err := farm.DB.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close local database for %s: %w", farm.Index, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ElasticURLs returns the URLs of the given config's Addresses, or if none, the
+// URL of its Scheme, Host and Port.
+func ElasticURLs(config es.Config) ([]*url.URL, error) {
+	if len(config.Addresses) == 0 {
+		return []*url.URL{{
+			Host:   net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+			Scheme: config.Scheme,
+		}}, nil
+	}
+
+	urls := make([]*url.URL, len(config.Addresses))
+
+	for i, address := range config.Addresses {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("elastic address %q needs a scheme and host", address)
+		}
+
+		urls[i] = u
+	}
+
+	return urls, nil
+}
@@ -0,0 +1,199 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package farmer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/analytics"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/server"
+)
+
+func TestFarmer(t *testing.T) {
+	Convey("Given a config for a mock elasticsearch and backfilled databases", t, func() {
+		indexA, indexB := "farm-a-*", "farm-b-*"
+		mockA, mockB := es.NewMock(indexA), es.NewMock(indexB)
+
+		esA := httptest.NewServer(mockA)
+		defer esA.Close()
+
+		esB := httptest.NewServer(mockB)
+		defer esB.Close()
+
+		dir := t.TempDir()
+		dbConfigA := db.Config{Directory: filepath.Join(dir, "a"), UpdateFrequency: time.Hour}
+		dbConfigB := db.Config{Directory: filepath.Join(dir, "b"), UpdateFrequency: time.Hour}
+
+		for _, backfill := range []struct {
+			mock   *es.Mock
+			config db.Config
+		}{{mockA, dbConfigA}, {mockB, dbConfigB}} {
+			_, err := db.Backfill(backfill.mock, backfill.config, server.TestBackfillFrom, 48*time.Hour)
+			So(err, ShouldBeNil)
+		}
+
+		config := Config{
+			Elastic: es.Config{Addresses: []string{esA.URL}, Index: indexA},
+			DB:      dbConfigA,
+			Cache:   CacheConfig{Sizes: cache.Sizes{Search: 2}},
+			Farms: []FarmConfig{{
+				Elastic: es.Config{Addresses: []string{esB.URL}, Index: indexB},
+				DB:      dbConfigB,
+			}},
+		}
+
+		Convey("New returns a Farmer whose Server answers searches of each index", func() {
+			f, err := New(config)
+			So(err, ShouldBeNil)
+
+			defer func() {
+				So(f.Close(), ShouldBeNil)
+			}()
+
+			So(f.Index, ShouldEqual, indexA)
+			So(f.Farms, ShouldHaveLength, 1)
+			So(f.Farms[0].Index, ShouldEqual, indexB)
+			So(f.DB.DataThrough(), ShouldNotBeZeroValue)
+
+			for _, mock := range []*es.Mock{mockA, mockB} {
+				w := httptest.NewRecorder()
+				f.Server.ServeHTTP(w, mock.AggQuery())
+				So(w.Code, ShouldEqual, http.StatusOK)
+			}
+
+			So(f.Querier().Flush(), ShouldEqual, 1)
+			So(f.Farms[0].Querier().Flush(), ShouldEqual, 1)
+
+			Convey("which you can Reconfigure with new Queriers", func() {
+				cqA, cqB := f.Querier(), f.Farms[0].Querier()
+
+				So(f.Reconfigure(config), ShouldBeNil)
+				So(f.Querier(), ShouldNotPointTo, cqA)
+				So(f.Farms[0].Querier(), ShouldNotPointTo, cqB)
+
+				w := httptest.NewRecorder()
+				f.Server.ServeHTTP(w, mockB.AggQuery())
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(f.Farms[0].Querier().Flush(), ShouldEqual, 1)
+
+				Convey("but not without its farms", func() {
+					config.Farms = nil
+
+					So(f.Reconfigure(config), ShouldNotBeNil)
+				})
+			})
+
+			Convey("which you can Drain, after which searches are refused", func() {
+				So(f.Drain(context.Background()), ShouldBeNil)
+
+				w := httptest.NewRecorder()
+				f.Server.ServeHTTP(w, mockA.AggQuery())
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			})
+		})
+
+		Convey("New can record queries in Analytics", func() {
+			config.AnalyticsFile = filepath.Join(t.TempDir(), "analytics.sqlite")
+
+			f, err := New(config)
+			So(err, ShouldBeNil)
+			So(f.Analytics, ShouldNotBeNil)
+
+			w := httptest.NewRecorder()
+			f.Server.ServeHTTP(w, mockA.AggQuery())
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			So(f.Close(), ShouldBeNil)
+
+			store, err := analytics.Open(config.AnalyticsFile, 0)
+			So(err, ShouldBeNil)
+
+			defer store.Close()
+
+			report, err := store.Report(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), 1)
+			So(err, ShouldBeNil)
+			So(report.Queries, ShouldEqual, 1)
+		})
+
+		Convey("New can make a read-only Server", func() {
+			config.DB.ReadOnly = true
+			config.Auth = server.Auth{AdminTokens: []string{"admin"}}
+
+			f, err := New(config)
+			So(err, ShouldBeNil)
+
+			defer func() {
+				So(f.Close(), ShouldBeNil)
+			}()
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/backfill", nil)
+			req.Header.Set("Authorization", "Bearer admin")
+
+			w := httptest.NewRecorder()
+			f.Server.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+
+			_, err = os.Stat(filepath.Join(dbConfigA.Directory, "index.cache"))
+			So(err, ShouldNotBeNil)
+
+			Convey("but not one that backfills", func() {
+				config.Backfill = &BackfillSchedule{At: time.Hour, Period: 48 * time.Hour}
+
+				_, err = New(config)
+				So(errors.Is(err, ErrReadOnlyBackfill), ShouldBeTrue)
+			})
+		})
+
+		Convey("New fails with a bad config", func() {
+			config.Elastic.Addresses = []string{"no-scheme"}
+
+			_, err := New(config)
+			So(err, ShouldNotBeNil)
+
+			config.Elastic.Addresses = []string{esA.URL}
+			config.Farms[0].DB.Backend = "unknown"
+
+			_, err = New(config)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("cacheSizes defaults unset sizes", t, func() {
+		So(cacheSizes(cache.Sizes{Scroll: 3}), ShouldResemble,
+			cache.Sizes{Search: DefaultCacheEntries, Scroll: 3, Distinct: DefaultCacheEntries})
+	})
+}
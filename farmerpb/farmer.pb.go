@@ -0,0 +1,1135 @@
+// Copyright (c) 2024 Genome Research Ltd.
+//
+// Author: Sendu Bala <sb10@sanger.ac.uk>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be included
+// in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+// CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+// TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+// SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        v5.28.3
+// source: farmerpb/farmer.proto
+
+package farmerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// QueryRequest describes the hits you're interested in, either with a Filter
+// or a raw elasticsearch query body.
+type QueryRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// index is the index to query; blank means the server's default index.
+	Index string `protobuf:"bytes,1,opt,name=index,proto3" json:"index,omitempty"`
+	// Types that are assignable to Query:
+	//	*QueryRequest_Filter
+	//	*QueryRequest_Body
+	Query isQueryRequest_Query `protobuf_oneof:"query"`
+	// fields limits the fields of returned hits, like _source; all fields are
+	// returned if empty.
+	Fields []string `protobuf:"bytes,4,rep,name=fields,proto3" json:"fields,omitempty"`
+	// size is the maximum number of hits Search returns for a filter query
+	// (Scroll returns them all). For a body query, the body's size is used.
+	Size int32 `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_farmerpb_farmer_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_farmerpb_farmer_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_farmerpb_farmer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *QueryRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (m *QueryRequest) GetQuery() isQueryRequest_Query {
+	if m != nil {
+		return m.Query
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetFilter() *Filter {
+	if x, ok := x.GetQuery().(*QueryRequest_Filter); ok {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetBody() string {
+	if x, ok := x.GetQuery().(*QueryRequest_Body); ok {
+		return x.Body
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetFields() []string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetSize() int32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+type isQueryRequest_Query interface {
+	isQueryRequest_Query()
+}
+
+type QueryRequest_Filter struct {
+	Filter *Filter `protobuf:"bytes,2,opt,name=filter,proto3,oneof"`
+}
+
+type QueryRequest_Body struct {
+	// body is the JSON of an elasticsearch search query body.
+	Body string `protobuf:"bytes,3,opt,name=body,proto3,oneof"`
+}
+
+func (*QueryRequest_Filter) isQueryRequest_Query() {}
+
+func (*QueryRequest_Body) isQueryRequest_Query() {}
+
+// Filter is like the client package's Filter. from and to are required.
+type Filter struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// from is the inclusive start of the timestamp range, in unix seconds.
+	From int64 `protobuf:"varint,1,opt,name=from,proto3" json:"from,omitempty"`
+	// to is the exclusive end of the timestamp range, in unix seconds.
+	To             int64  `protobuf:"varint,2,opt,name=to,proto3" json:"to,omitempty"`
+	Bom            string `protobuf:"bytes,3,opt,name=bom,proto3" json:"bom,omitempty"`
+	AccountingName string `protobuf:"bytes,4,opt,name=accounting_name,json=accountingName,proto3" json:"accounting_name,omitempty"`
+	UserName       string `protobuf:"bytes,5,opt,name=user_name,json=userName,proto3" json:"user_name,omitempty"`
+	// gpu_only restricts hits to those with a QUEUE_NAME starting "gpu".
+	GpuOnly bool `protobuf:"varint,6,opt,name=gpu_only,json=gpuOnly,proto3" json:"gpu_only,omitempty"`
+}
+
+func (x *Filter) Reset() {
+	*x = Filter{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_farmerpb_farmer_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Filter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Filter) ProtoMessage() {}
+
+func (x *Filter) ProtoReflect() protoreflect.Message {
+	mi := &file_farmerpb_farmer_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Filter.ProtoReflect.Descriptor instead.
+func (*Filter) Descriptor() ([]byte, []int) {
+	return file_farmerpb_farmer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Filter) GetFrom() int64 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *Filter) GetTo() int64 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+func (x *Filter) GetBom() string {
+	if x != nil {
+		return x.Bom
+	}
+	return ""
+}
+
+func (x *Filter) GetAccountingName() string {
+	if x != nil {
+		return x.AccountingName
+	}
+	return ""
+}
+
+func (x *Filter) GetUserName() string {
+	if x != nil {
+		return x.UserName
+	}
+	return ""
+}
+
+func (x *Filter) GetGpuOnly() bool {
+	if x != nil {
+		return x.GpuOnly
+	}
+	return false
+}
+
+type SearchResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Total int64 `protobuf:"varint,1,opt,name=total,proto3" json:"total,omitempty"`
+	// total_is_lower_bound is true if total is only a lower bound, due to the
+	// query's track_total_hits.
+	TotalIsLowerBound bool   `protobuf:"varint,2,opt,name=total_is_lower_bound,json=totalIsLowerBound,proto3" json:"total_is_lower_bound,omitempty"`
+	Hits              []*Hit `protobuf:"bytes,3,rep,name=hits,proto3" json:"hits,omitempty"`
+	// aggregations is the JSON of the result's aggregations, if any.
+	Aggregations []byte `protobuf:"bytes,4,opt,name=aggregations,proto3" json:"aggregations,omitempty"`
+}
+
+func (x *SearchResponse) Reset() {
+	*x = SearchResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_farmerpb_farmer_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SearchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResponse) ProtoMessage() {}
+
+func (x *SearchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_farmerpb_farmer_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResponse.ProtoReflect.Descriptor instead.
+func (*SearchResponse) Descriptor() ([]byte, []int) {
+	return file_farmerpb_farmer_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SearchResponse) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *SearchResponse) GetTotalIsLowerBound() bool {
+	if x != nil {
+		return x.TotalIsLowerBound
+	}
+	return false
+}
+
+func (x *SearchResponse) GetHits() []*Hit {
+	if x != nil {
+		return x.Hits
+	}
+	return nil
+}
+
+func (x *SearchResponse) GetAggregations() []byte {
+	if x != nil {
+		return x.Aggregations
+	}
+	return nil
+}
+
+type HitBatch struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hits []*Hit `protobuf:"bytes,1,rep,name=hits,proto3" json:"hits,omitempty"`
+}
+
+func (x *HitBatch) Reset() {
+	*x = HitBatch{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_farmerpb_farmer_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HitBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HitBatch) ProtoMessage() {}
+
+func (x *HitBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_farmerpb_farmer_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HitBatch.ProtoReflect.Descriptor instead.
+func (*HitBatch) Descriptor() ([]byte, []int) {
+	return file_farmerpb_farmer_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *HitBatch) GetHits() []*Hit {
+	if x != nil {
+		return x.Hits
+	}
+	return nil
+}
+
+type UsernamesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Usernames []string `protobuf:"bytes,1,rep,name=usernames,proto3" json:"usernames,omitempty"`
+}
+
+func (x *UsernamesResponse) Reset() {
+	*x = UsernamesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_farmerpb_farmer_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UsernamesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UsernamesResponse) ProtoMessage() {}
+
+func (x *UsernamesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_farmerpb_farmer_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UsernamesResponse.ProtoReflect.Descriptor instead.
+func (*UsernamesResponse) Descriptor() ([]byte, []int) {
+	return file_farmerpb_farmer_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UsernamesResponse) GetUsernames() []string {
+	if x != nil {
+		return x.Usernames
+	}
+	return nil
+}
+
+// Hit has the fields of an elasticsearch hit's _source. Fields that weren't
+// requested have their zero value.
+type Hit struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id                         string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	AccountingName             string   `protobuf:"bytes,2,opt,name=accounting_name,json=accountingName,proto3" json:"accounting_name,omitempty"`
+	AvailCpuTimeSec            int64    `protobuf:"varint,3,opt,name=avail_cpu_time_sec,json=availCpuTimeSec,proto3" json:"avail_cpu_time_sec,omitempty"`
+	Bom                        string   `protobuf:"bytes,4,opt,name=bom,proto3" json:"bom,omitempty"`
+	Command                    string   `protobuf:"bytes,5,opt,name=command,proto3" json:"command,omitempty"`
+	JobName                    string   `protobuf:"bytes,6,opt,name=job_name,json=jobName,proto3" json:"job_name,omitempty"`
+	Job                        string   `protobuf:"bytes,7,opt,name=job,proto3" json:"job,omitempty"`
+	MemRequestedMb             int64    `protobuf:"varint,8,opt,name=mem_requested_mb,json=memRequestedMb,proto3" json:"mem_requested_mb,omitempty"`
+	MemRequestedMbSec          int64    `protobuf:"varint,9,opt,name=mem_requested_mb_sec,json=memRequestedMbSec,proto3" json:"mem_requested_mb_sec,omitempty"`
+	NumExecProcs               int64    `protobuf:"varint,10,opt,name=num_exec_procs,json=numExecProcs,proto3" json:"num_exec_procs,omitempty"`
+	PendingTimeSec             int64    `protobuf:"varint,11,opt,name=pending_time_sec,json=pendingTimeSec,proto3" json:"pending_time_sec,omitempty"`
+	QueueName                  string   `protobuf:"bytes,12,opt,name=queue_name,json=queueName,proto3" json:"queue_name,omitempty"`
+	RunTimeSec                 int64    `protobuf:"varint,13,opt,name=run_time_sec,json=runTimeSec,proto3" json:"run_time_sec,omitempty"`
+	Timestamp                  int64    `protobuf:"varint,14,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	UserName                   string   `protobuf:"bytes,15,opt,name=user_name,json=userName,proto3" json:"user_name,omitempty"`
+	WastedCpuSeconds           float64  `protobuf:"fixed64,16,opt,name=wasted_cpu_seconds,json=wastedCpuSeconds,proto3" json:"wasted_cpu_seconds,omitempty"`
+	WastedMbSeconds            float64  `protobuf:"fixed64,17,opt,name=wasted_mb_seconds,json=wastedMbSeconds,proto3" json:"wasted_mb_seconds,omitempty"`
+	RawWastedCpuSeconds        float64  `protobuf:"fixed64,18,opt,name=raw_wasted_cpu_seconds,json=rawWastedCpuSeconds,proto3" json:"raw_wasted_cpu_seconds,omitempty"`
+	RawWastedMbSeconds         float64  `protobuf:"fixed64,19,opt,name=raw_wasted_mb_seconds,json=rawWastedMbSeconds,proto3" json:"raw_wasted_mb_seconds,omitempty"`
+	AvgMemEfficiencyPercent    float64  `protobuf:"fixed64,20,opt,name=avg_mem_efficiency_percent,json=avgMemEfficiencyPercent,proto3" json:"avg_mem_efficiency_percent,omitempty"`
+	AvrgMemUsageMb             float64  `protobuf:"fixed64,21,opt,name=avrg_mem_usage_mb,json=avrgMemUsageMb,proto3" json:"avrg_mem_usage_mb,omitempty"`
+	AvrgMemUsageMbSecCooked    float64  `protobuf:"fixed64,22,opt,name=avrg_mem_usage_mb_sec_cooked,json=avrgMemUsageMbSecCooked,proto3" json:"avrg_mem_usage_mb_sec_cooked,omitempty"`
+	AvrgMemUsageMbSecRaw       float64  `protobuf:"fixed64,23,opt,name=avrg_mem_usage_mb_sec_raw,json=avrgMemUsageMbSecRaw,proto3" json:"avrg_mem_usage_mb_sec_raw,omitempty"`
+	ClusterName                string   `protobuf:"bytes,24,opt,name=cluster_name,json=clusterName,proto3" json:"cluster_name,omitempty"`
+	CookedCpuTimeSec           float64  `protobuf:"fixed64,25,opt,name=cooked_cpu_time_sec,json=cookedCpuTimeSec,proto3" json:"cooked_cpu_time_sec,omitempty"`
+	EndTime                    int64    `protobuf:"varint,26,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	ExecHostname               []string `protobuf:"bytes,27,rep,name=exec_hostname,json=execHostname,proto3" json:"exec_hostname,omitempty"`
+	ExitInfo                   int64    `protobuf:"varint,28,opt,name=exit_info,json=exitInfo,proto3" json:"exit_info,omitempty"`
+	ExitReason                 string   `protobuf:"bytes,29,opt,name=exit_reason,json=exitReason,proto3" json:"exit_reason,omitempty"`
+	JobId                      int64    `protobuf:"varint,30,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	JobArrayIndex              int64    `protobuf:"varint,31,opt,name=job_array_index,json=jobArrayIndex,proto3" json:"job_array_index,omitempty"`
+	JobExitStatus              int64    `protobuf:"varint,32,opt,name=job_exit_status,json=jobExitStatus,proto3" json:"job_exit_status,omitempty"`
+	JobEfficiencyPercent       float64  `protobuf:"fixed64,33,opt,name=job_efficiency_percent,json=jobEfficiencyPercent,proto3" json:"job_efficiency_percent,omitempty"`
+	JobEfficiencyRawPercent    float64  `protobuf:"fixed64,34,opt,name=job_efficiency_raw_percent,json=jobEfficiencyRawPercent,proto3" json:"job_efficiency_raw_percent,omitempty"`
+	MaxMemEfficiencyPercent    float64  `protobuf:"fixed64,35,opt,name=max_mem_efficiency_percent,json=maxMemEfficiencyPercent,proto3" json:"max_mem_efficiency_percent,omitempty"`
+	MaxMemUsageMb              float64  `protobuf:"fixed64,36,opt,name=max_mem_usage_mb,json=maxMemUsageMb,proto3" json:"max_mem_usage_mb,omitempty"`
+	MaxMemUsageMbSecCooked     float64  `protobuf:"fixed64,37,opt,name=max_mem_usage_mb_sec_cooked,json=maxMemUsageMbSecCooked,proto3" json:"max_mem_usage_mb_sec_cooked,omitempty"`
+	MaxMemUsageMbSecRaw        float64  `protobuf:"fixed64,38,opt,name=max_mem_usage_mb_sec_raw,json=maxMemUsageMbSecRaw,proto3" json:"max_mem_usage_mb_sec_raw,omitempty"`
+	NumberOfHosts              int64    `protobuf:"varint,39,opt,name=number_of_hosts,json=numberOfHosts,proto3" json:"number_of_hosts,omitempty"`
+	NumberOfUniqueHosts        int64    `protobuf:"varint,40,opt,name=number_of_unique_hosts,json=numberOfUniqueHosts,proto3" json:"number_of_unique_hosts,omitempty"`
+	ProjectName                string   `protobuf:"bytes,41,opt,name=project_name,json=projectName,proto3" json:"project_name,omitempty"`
+	RawAvgMemEfficiencyPercent float64  `protobuf:"fixed64,42,opt,name=raw_avg_mem_efficiency_percent,json=rawAvgMemEfficiencyPercent,proto3" json:"raw_avg_mem_efficiency_percent,omitempty"`
+	RawCpuTimeSec              float64  `protobuf:"fixed64,43,opt,name=raw_cpu_time_sec,json=rawCpuTimeSec,proto3" json:"raw_cpu_time_sec,omitempty"`
+	RawMaxMemEfficiencyPercent float64  `protobuf:"fixed64,44,opt,name=raw_max_mem_efficiency_percent,json=rawMaxMemEfficiencyPercent,proto3" json:"raw_max_mem_efficiency_percent,omitempty"`
+	SubmitTime                 int64    `protobuf:"varint,45,opt,name=submit_time,json=submitTime,proto3" json:"submit_time,omitempty"`
+}
+
+func (x *Hit) Reset() {
+	*x = Hit{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_farmerpb_farmer_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Hit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hit) ProtoMessage() {}
+
+func (x *Hit) ProtoReflect() protoreflect.Message {
+	mi := &file_farmerpb_farmer_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hit.ProtoReflect.Descriptor instead.
+func (*Hit) Descriptor() ([]byte, []int) {
+	return file_farmerpb_farmer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Hit) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Hit) GetAccountingName() string {
+	if x != nil {
+		return x.AccountingName
+	}
+	return ""
+}
+
+func (x *Hit) GetAvailCpuTimeSec() int64 {
+	if x != nil {
+		return x.AvailCpuTimeSec
+	}
+	return 0
+}
+
+func (x *Hit) GetBom() string {
+	if x != nil {
+		return x.Bom
+	}
+	return ""
+}
+
+func (x *Hit) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *Hit) GetJobName() string {
+	if x != nil {
+		return x.JobName
+	}
+	return ""
+}
+
+func (x *Hit) GetJob() string {
+	if x != nil {
+		return x.Job
+	}
+	return ""
+}
+
+func (x *Hit) GetMemRequestedMb() int64 {
+	if x != nil {
+		return x.MemRequestedMb
+	}
+	return 0
+}
+
+func (x *Hit) GetMemRequestedMbSec() int64 {
+	if x != nil {
+		return x.MemRequestedMbSec
+	}
+	return 0
+}
+
+func (x *Hit) GetNumExecProcs() int64 {
+	if x != nil {
+		return x.NumExecProcs
+	}
+	return 0
+}
+
+func (x *Hit) GetPendingTimeSec() int64 {
+	if x != nil {
+		return x.PendingTimeSec
+	}
+	return 0
+}
+
+func (x *Hit) GetQueueName() string {
+	if x != nil {
+		return x.QueueName
+	}
+	return ""
+}
+
+func (x *Hit) GetRunTimeSec() int64 {
+	if x != nil {
+		return x.RunTimeSec
+	}
+	return 0
+}
+
+func (x *Hit) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Hit) GetUserName() string {
+	if x != nil {
+		return x.UserName
+	}
+	return ""
+}
+
+func (x *Hit) GetWastedCpuSeconds() float64 {
+	if x != nil {
+		return x.WastedCpuSeconds
+	}
+	return 0
+}
+
+func (x *Hit) GetWastedMbSeconds() float64 {
+	if x != nil {
+		return x.WastedMbSeconds
+	}
+	return 0
+}
+
+func (x *Hit) GetRawWastedCpuSeconds() float64 {
+	if x != nil {
+		return x.RawWastedCpuSeconds
+	}
+	return 0
+}
+
+func (x *Hit) GetRawWastedMbSeconds() float64 {
+	if x != nil {
+		return x.RawWastedMbSeconds
+	}
+	return 0
+}
+
+func (x *Hit) GetAvgMemEfficiencyPercent() float64 {
+	if x != nil {
+		return x.AvgMemEfficiencyPercent
+	}
+	return 0
+}
+
+func (x *Hit) GetAvrgMemUsageMb() float64 {
+	if x != nil {
+		return x.AvrgMemUsageMb
+	}
+	return 0
+}
+
+func (x *Hit) GetAvrgMemUsageMbSecCooked() float64 {
+	if x != nil {
+		return x.AvrgMemUsageMbSecCooked
+	}
+	return 0
+}
+
+func (x *Hit) GetAvrgMemUsageMbSecRaw() float64 {
+	if x != nil {
+		return x.AvrgMemUsageMbSecRaw
+	}
+	return 0
+}
+
+func (x *Hit) GetClusterName() string {
+	if x != nil {
+		return x.ClusterName
+	}
+	return ""
+}
+
+func (x *Hit) GetCookedCpuTimeSec() float64 {
+	if x != nil {
+		return x.CookedCpuTimeSec
+	}
+	return 0
+}
+
+func (x *Hit) GetEndTime() int64 {
+	if x != nil {
+		return x.EndTime
+	}
+	return 0
+}
+
+func (x *Hit) GetExecHostname() []string {
+	if x != nil {
+		return x.ExecHostname
+	}
+	return nil
+}
+
+func (x *Hit) GetExitInfo() int64 {
+	if x != nil {
+		return x.ExitInfo
+	}
+	return 0
+}
+
+func (x *Hit) GetExitReason() string {
+	if x != nil {
+		return x.ExitReason
+	}
+	return ""
+}
+
+func (x *Hit) GetJobId() int64 {
+	if x != nil {
+		return x.JobId
+	}
+	return 0
+}
+
+func (x *Hit) GetJobArrayIndex() int64 {
+	if x != nil {
+		return x.JobArrayIndex
+	}
+	return 0
+}
+
+func (x *Hit) GetJobExitStatus() int64 {
+	if x != nil {
+		return x.JobExitStatus
+	}
+	return 0
+}
+
+func (x *Hit) GetJobEfficiencyPercent() float64 {
+	if x != nil {
+		return x.JobEfficiencyPercent
+	}
+	return 0
+}
+
+func (x *Hit) GetJobEfficiencyRawPercent() float64 {
+	if x != nil {
+		return x.JobEfficiencyRawPercent
+	}
+	return 0
+}
+
+func (x *Hit) GetMaxMemEfficiencyPercent() float64 {
+	if x != nil {
+		return x.MaxMemEfficiencyPercent
+	}
+	return 0
+}
+
+func (x *Hit) GetMaxMemUsageMb() float64 {
+	if x != nil {
+		return x.MaxMemUsageMb
+	}
+	return 0
+}
+
+func (x *Hit) GetMaxMemUsageMbSecCooked() float64 {
+	if x != nil {
+		return x.MaxMemUsageMbSecCooked
+	}
+	return 0
+}
+
+func (x *Hit) GetMaxMemUsageMbSecRaw() float64 {
+	if x != nil {
+		return x.MaxMemUsageMbSecRaw
+	}
+	return 0
+}
+
+func (x *Hit) GetNumberOfHosts() int64 {
+	if x != nil {
+		return x.NumberOfHosts
+	}
+	return 0
+}
+
+func (x *Hit) GetNumberOfUniqueHosts() int64 {
+	if x != nil {
+		return x.NumberOfUniqueHosts
+	}
+	return 0
+}
+
+func (x *Hit) GetProjectName() string {
+	if x != nil {
+		return x.ProjectName
+	}
+	return ""
+}
+
+func (x *Hit) GetRawAvgMemEfficiencyPercent() float64 {
+	if x != nil {
+		return x.RawAvgMemEfficiencyPercent
+	}
+	return 0
+}
+
+func (x *Hit) GetRawCpuTimeSec() float64 {
+	if x != nil {
+		return x.RawCpuTimeSec
+	}
+	return 0
+}
+
+func (x *Hit) GetRawMaxMemEfficiencyPercent() float64 {
+	if x != nil {
+		return x.RawMaxMemEfficiencyPercent
+	}
+	return 0
+}
+
+func (x *Hit) GetSubmitTime() int64 {
+	if x != nil {
+		return x.SubmitTime
+	}
+	return 0
+}
+
+var File_farmerpb_farmer_proto protoreflect.FileDescriptor
+
+var file_farmerpb_farmer_proto_rawDesc = []byte{
+	0x0a, 0x15, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x70, 0x62, 0x2f, 0x66, 0x61, 0x72, 0x6d, 0x65,
+	0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x09, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x2e,
+	0x76, 0x31, 0x22, 0x9c, 0x01, 0x0a, 0x0c, 0x51, 0x75, 0x65, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x2b, 0x0a, 0x06, 0x66, 0x69, 0x6c,
+	0x74, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x66, 0x61, 0x72, 0x6d,
+	0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x48, 0x00, 0x52, 0x06,
+	0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x14, 0x0a, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x12, 0x16, 0x0a, 0x06,
+	0x66, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x66, 0x69,
+	0x65, 0x6c, 0x64, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x42, 0x07, 0x0a, 0x05, 0x71, 0x75, 0x65, 0x72,
+	0x79, 0x22, 0x9f, 0x01, 0x0a, 0x06, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04,
+	0x66, 0x72, 0x6f, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x66, 0x72, 0x6f, 0x6d,
+	0x12, 0x0e, 0x0a, 0x02, 0x74, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x74, 0x6f,
+	0x12, 0x10, 0x0a, 0x03, 0x62, 0x6f, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x62,
+	0x6f, 0x6d, 0x12, 0x27, 0x0a, 0x0f, 0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x69, 0x6e, 0x67,
+	0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x61, 0x63, 0x63,
+	0x6f, 0x75, 0x6e, 0x74, 0x69, 0x6e, 0x67, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x75,
+	0x73, 0x65, 0x72, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x75, 0x73, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x67, 0x70, 0x75, 0x5f,
+	0x6f, 0x6e, 0x6c, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x67, 0x70, 0x75, 0x4f,
+	0x6e, 0x6c, 0x79, 0x22, 0x9f, 0x01, 0x0a, 0x0e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x2f, 0x0a, 0x14,
+	0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x69, 0x73, 0x5f, 0x6c, 0x6f, 0x77, 0x65, 0x72, 0x5f, 0x62,
+	0x6f, 0x75, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x74, 0x6f, 0x74, 0x61,
+	0x6c, 0x49, 0x73, 0x4c, 0x6f, 0x77, 0x65, 0x72, 0x42, 0x6f, 0x75, 0x6e, 0x64, 0x12, 0x22, 0x0a,
+	0x04, 0x68, 0x69, 0x74, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x66, 0x61,
+	0x72, 0x6d, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x48, 0x69, 0x74, 0x52, 0x04, 0x68, 0x69, 0x74,
+	0x73, 0x12, 0x22, 0x0a, 0x0c, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x61, 0x67, 0x67, 0x72, 0x65, 0x67, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x22, 0x2e, 0x0a, 0x08, 0x48, 0x69, 0x74, 0x42, 0x61, 0x74, 0x63,
+	0x68, 0x12, 0x22, 0x0a, 0x04, 0x68, 0x69, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x0e, 0x2e, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x48, 0x69, 0x74, 0x52,
+	0x04, 0x68, 0x69, 0x74, 0x73, 0x22, 0x31, 0x0a, 0x11, 0x55, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d,
+	0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x75, 0x73,
+	0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x75,
+	0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x22, 0xe3, 0x0e, 0x0a, 0x03, 0x48, 0x69, 0x74,
+	0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64,
+	0x12, 0x27, 0x0a, 0x0f, 0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x6e,
+	0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x61, 0x63, 0x63, 0x6f, 0x75,
+	0x6e, 0x74, 0x69, 0x6e, 0x67, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x2b, 0x0a, 0x12, 0x61, 0x76, 0x61,
+	0x69, 0x6c, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x73, 0x65, 0x63, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x61, 0x76, 0x61, 0x69, 0x6c, 0x43, 0x70, 0x75, 0x54,
+	0x69, 0x6d, 0x65, 0x53, 0x65, 0x63, 0x12, 0x10, 0x0a, 0x03, 0x62, 0x6f, 0x6d, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x03, 0x62, 0x6f, 0x6d, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x6f, 0x6d, 0x6d,
+	0x61, 0x6e, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61,
+	0x6e, 0x64, 0x12, 0x19, 0x0a, 0x08, 0x6a, 0x6f, 0x62, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6a, 0x6f, 0x62, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x10, 0x0a,
+	0x03, 0x6a, 0x6f, 0x62, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6a, 0x6f, 0x62, 0x12,
+	0x28, 0x0a, 0x10, 0x6d, 0x65, 0x6d, 0x5f, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x65, 0x64,
+	0x5f, 0x6d, 0x62, 0x18, 0x08, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x6d, 0x65, 0x6d, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x65, 0x64, 0x4d, 0x62, 0x12, 0x2f, 0x0a, 0x14, 0x6d, 0x65, 0x6d,
+	0x5f, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x65, 0x64, 0x5f, 0x6d, 0x62, 0x5f, 0x73, 0x65,
+	0x63, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x11, 0x6d, 0x65, 0x6d, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x65, 0x64, 0x4d, 0x62, 0x53, 0x65, 0x63, 0x12, 0x24, 0x0a, 0x0e, 0x6e, 0x75,
+	0x6d, 0x5f, 0x65, 0x78, 0x65, 0x63, 0x5f, 0x70, 0x72, 0x6f, 0x63, 0x73, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0c, 0x6e, 0x75, 0x6d, 0x45, 0x78, 0x65, 0x63, 0x50, 0x72, 0x6f, 0x63, 0x73,
+	0x12, 0x28, 0x0a, 0x10, 0x70, 0x65, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x5f, 0x74, 0x69, 0x6d, 0x65,
+	0x5f, 0x73, 0x65, 0x63, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x70, 0x65, 0x6e, 0x64,
+	0x69, 0x6e, 0x67, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x63, 0x12, 0x1d, 0x0a, 0x0a, 0x71, 0x75,
+	0x65, 0x75, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x71, 0x75, 0x65, 0x75, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x20, 0x0a, 0x0c, 0x72, 0x75, 0x6e,
+	0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x73, 0x65, 0x63, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0a, 0x72, 0x75, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x63, 0x12, 0x1c, 0x0a, 0x09, 0x74,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x1b, 0x0a, 0x09, 0x75, 0x73, 0x65,
+	0x72, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73,
+	0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x2c, 0x0a, 0x12, 0x77, 0x61, 0x73, 0x74, 0x65, 0x64,
+	0x5f, 0x63, 0x70, 0x75, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x10, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x10, 0x77, 0x61, 0x73, 0x74, 0x65, 0x64, 0x43, 0x70, 0x75, 0x53, 0x65, 0x63,
+	0x6f, 0x6e, 0x64, 0x73, 0x12, 0x2a, 0x0a, 0x11, 0x77, 0x61, 0x73, 0x74, 0x65, 0x64, 0x5f, 0x6d,
+	0x62, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x11, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x0f, 0x77, 0x61, 0x73, 0x74, 0x65, 0x64, 0x4d, 0x62, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73,
+	0x12, 0x33, 0x0a, 0x16, 0x72, 0x61, 0x77, 0x5f, 0x77, 0x61, 0x73, 0x74, 0x65, 0x64, 0x5f, 0x63,
+	0x70, 0x75, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x12, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x13, 0x72, 0x61, 0x77, 0x57, 0x61, 0x73, 0x74, 0x65, 0x64, 0x43, 0x70, 0x75, 0x53, 0x65,
+	0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x31, 0x0a, 0x15, 0x72, 0x61, 0x77, 0x5f, 0x77, 0x61, 0x73,
+	0x74, 0x65, 0x64, 0x5f, 0x6d, 0x62, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x13,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x12, 0x72, 0x61, 0x77, 0x57, 0x61, 0x73, 0x74, 0x65, 0x64, 0x4d,
+	0x62, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x3b, 0x0a, 0x1a, 0x61, 0x76, 0x67, 0x5f,
+	0x6d, 0x65, 0x6d, 0x5f, 0x65, 0x66, 0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x70,
+	0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x18, 0x14, 0x20, 0x01, 0x28, 0x01, 0x52, 0x17, 0x61, 0x76,
+	0x67, 0x4d, 0x65, 0x6d, 0x45, 0x66, 0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x50, 0x65,
+	0x72, 0x63, 0x65, 0x6e, 0x74, 0x12, 0x29, 0x0a, 0x11, 0x61, 0x76, 0x72, 0x67, 0x5f, 0x6d, 0x65,
+	0x6d, 0x5f, 0x75, 0x73, 0x61, 0x67, 0x65, 0x5f, 0x6d, 0x62, 0x18, 0x15, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x0e, 0x61, 0x76, 0x72, 0x67, 0x4d, 0x65, 0x6d, 0x55, 0x73, 0x61, 0x67, 0x65, 0x4d, 0x62,
+	0x12, 0x3d, 0x0a, 0x1c, 0x61, 0x76, 0x72, 0x67, 0x5f, 0x6d, 0x65, 0x6d, 0x5f, 0x75, 0x73, 0x61,
+	0x67, 0x65, 0x5f, 0x6d, 0x62, 0x5f, 0x73, 0x65, 0x63, 0x5f, 0x63, 0x6f, 0x6f, 0x6b, 0x65, 0x64,
+	0x18, 0x16, 0x20, 0x01, 0x28, 0x01, 0x52, 0x17, 0x61, 0x76, 0x72, 0x67, 0x4d, 0x65, 0x6d, 0x55,
+	0x73, 0x61, 0x67, 0x65, 0x4d, 0x62, 0x53, 0x65, 0x63, 0x43, 0x6f, 0x6f, 0x6b, 0x65, 0x64, 0x12,
+	0x37, 0x0a, 0x19, 0x61, 0x76, 0x72, 0x67, 0x5f, 0x6d, 0x65, 0x6d, 0x5f, 0x75, 0x73, 0x61, 0x67,
+	0x65, 0x5f, 0x6d, 0x62, 0x5f, 0x73, 0x65, 0x63, 0x5f, 0x72, 0x61, 0x77, 0x18, 0x17, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x14, 0x61, 0x76, 0x72, 0x67, 0x4d, 0x65, 0x6d, 0x55, 0x73, 0x61, 0x67, 0x65,
+	0x4d, 0x62, 0x53, 0x65, 0x63, 0x52, 0x61, 0x77, 0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6c, 0x75, 0x73,
+	0x74, 0x65, 0x72, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x18, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b,
+	0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x2d, 0x0a, 0x13, 0x63,
+	0x6f, 0x6f, 0x6b, 0x65, 0x64, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x73,
+	0x65, 0x63, 0x18, 0x19, 0x20, 0x01, 0x28, 0x01, 0x52, 0x10, 0x63, 0x6f, 0x6f, 0x6b, 0x65, 0x64,
+	0x43, 0x70, 0x75, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x63, 0x12, 0x19, 0x0a, 0x08, 0x65, 0x6e,
+	0x64, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x1a, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x65, 0x6e,
+	0x64, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x78, 0x65, 0x63, 0x5f, 0x68, 0x6f,
+	0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x1b, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0c, 0x65, 0x78,
+	0x65, 0x63, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x65, 0x78,
+	0x69, 0x74, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x18, 0x1c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x65,
+	0x78, 0x69, 0x74, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x1f, 0x0a, 0x0b, 0x65, 0x78, 0x69, 0x74, 0x5f,
+	0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x1d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x65, 0x78,
+	0x69, 0x74, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x15, 0x0a, 0x06, 0x6a, 0x6f, 0x62, 0x5f,
+	0x69, 0x64, 0x18, 0x1e, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x6a, 0x6f, 0x62, 0x49, 0x64, 0x12,
+	0x26, 0x0a, 0x0f, 0x6a, 0x6f, 0x62, 0x5f, 0x61, 0x72, 0x72, 0x61, 0x79, 0x5f, 0x69, 0x6e, 0x64,
+	0x65, 0x78, 0x18, 0x1f, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x6a, 0x6f, 0x62, 0x41, 0x72, 0x72,
+	0x61, 0x79, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x26, 0x0a, 0x0f, 0x6a, 0x6f, 0x62, 0x5f, 0x65,
+	0x78, 0x69, 0x74, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x20, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0d, 0x6a, 0x6f, 0x62, 0x45, 0x78, 0x69, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12,
+	0x34, 0x0a, 0x16, 0x6a, 0x6f, 0x62, 0x5f, 0x65, 0x66, 0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63,
+	0x79, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x18, 0x21, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x14, 0x6a, 0x6f, 0x62, 0x45, 0x66, 0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x50, 0x65,
+	0x72, 0x63, 0x65, 0x6e, 0x74, 0x12, 0x3b, 0x0a, 0x1a, 0x6a, 0x6f, 0x62, 0x5f, 0x65, 0x66, 0x66,
+	0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x72, 0x61, 0x77, 0x5f, 0x70, 0x65, 0x72, 0x63,
+	0x65, 0x6e, 0x74, 0x18, 0x22, 0x20, 0x01, 0x28, 0x01, 0x52, 0x17, 0x6a, 0x6f, 0x62, 0x45, 0x66,
+	0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x52, 0x61, 0x77, 0x50, 0x65, 0x72, 0x63, 0x65,
+	0x6e, 0x74, 0x12, 0x3b, 0x0a, 0x1a, 0x6d, 0x61, 0x78, 0x5f, 0x6d, 0x65, 0x6d, 0x5f, 0x65, 0x66,
+	0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74,
+	0x18, 0x23, 0x20, 0x01, 0x28, 0x01, 0x52, 0x17, 0x6d, 0x61, 0x78, 0x4d, 0x65, 0x6d, 0x45, 0x66,
+	0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x12,
+	0x27, 0x0a, 0x10, 0x6d, 0x61, 0x78, 0x5f, 0x6d, 0x65, 0x6d, 0x5f, 0x75, 0x73, 0x61, 0x67, 0x65,
+	0x5f, 0x6d, 0x62, 0x18, 0x24, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d, 0x6d, 0x61, 0x78, 0x4d, 0x65,
+	0x6d, 0x55, 0x73, 0x61, 0x67, 0x65, 0x4d, 0x62, 0x12, 0x3b, 0x0a, 0x1b, 0x6d, 0x61, 0x78, 0x5f,
+	0x6d, 0x65, 0x6d, 0x5f, 0x75, 0x73, 0x61, 0x67, 0x65, 0x5f, 0x6d, 0x62, 0x5f, 0x73, 0x65, 0x63,
+	0x5f, 0x63, 0x6f, 0x6f, 0x6b, 0x65, 0x64, 0x18, 0x25, 0x20, 0x01, 0x28, 0x01, 0x52, 0x16, 0x6d,
+	0x61, 0x78, 0x4d, 0x65, 0x6d, 0x55, 0x73, 0x61, 0x67, 0x65, 0x4d, 0x62, 0x53, 0x65, 0x63, 0x43,
+	0x6f, 0x6f, 0x6b, 0x65, 0x64, 0x12, 0x35, 0x0a, 0x18, 0x6d, 0x61, 0x78, 0x5f, 0x6d, 0x65, 0x6d,
+	0x5f, 0x75, 0x73, 0x61, 0x67, 0x65, 0x5f, 0x6d, 0x62, 0x5f, 0x73, 0x65, 0x63, 0x5f, 0x72, 0x61,
+	0x77, 0x18, 0x26, 0x20, 0x01, 0x28, 0x01, 0x52, 0x13, 0x6d, 0x61, 0x78, 0x4d, 0x65, 0x6d, 0x55,
+	0x73, 0x61, 0x67, 0x65, 0x4d, 0x62, 0x53, 0x65, 0x63, 0x52, 0x61, 0x77, 0x12, 0x26, 0x0a, 0x0f,
+	0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x5f, 0x6f, 0x66, 0x5f, 0x68, 0x6f, 0x73, 0x74, 0x73, 0x18,
+	0x27, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x4f, 0x66, 0x48,
+	0x6f, 0x73, 0x74, 0x73, 0x12, 0x33, 0x0a, 0x16, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x5f, 0x6f,
+	0x66, 0x5f, 0x75, 0x6e, 0x69, 0x71, 0x75, 0x65, 0x5f, 0x68, 0x6f, 0x73, 0x74, 0x73, 0x18, 0x28,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x13, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x4f, 0x66, 0x55, 0x6e,
+	0x69, 0x71, 0x75, 0x65, 0x48, 0x6f, 0x73, 0x74, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x72, 0x6f,
+	0x6a, 0x65, 0x63, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x29, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0b, 0x70, 0x72, 0x6f, 0x6a, 0x65, 0x63, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x42, 0x0a, 0x1e,
+	0x72, 0x61, 0x77, 0x5f, 0x61, 0x76, 0x67, 0x5f, 0x6d, 0x65, 0x6d, 0x5f, 0x65, 0x66, 0x66, 0x69,
+	0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x18, 0x2a,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x1a, 0x72, 0x61, 0x77, 0x41, 0x76, 0x67, 0x4d, 0x65, 0x6d, 0x45,
+	0x66, 0x66, 0x69, 0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74,
+	0x12, 0x27, 0x0a, 0x10, 0x72, 0x61, 0x77, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x74, 0x69, 0x6d, 0x65,
+	0x5f, 0x73, 0x65, 0x63, 0x18, 0x2b, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d, 0x72, 0x61, 0x77, 0x43,
+	0x70, 0x75, 0x54, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x63, 0x12, 0x42, 0x0a, 0x1e, 0x72, 0x61, 0x77,
+	0x5f, 0x6d, 0x61, 0x78, 0x5f, 0x6d, 0x65, 0x6d, 0x5f, 0x65, 0x66, 0x66, 0x69, 0x63, 0x69, 0x65,
+	0x6e, 0x63, 0x79, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x18, 0x2c, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x1a, 0x72, 0x61, 0x77, 0x4d, 0x61, 0x78, 0x4d, 0x65, 0x6d, 0x45, 0x66, 0x66, 0x69,
+	0x63, 0x69, 0x65, 0x6e, 0x63, 0x79, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x12, 0x1f, 0x0a,
+	0x0b, 0x73, 0x75, 0x62, 0x6d, 0x69, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x2d, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0a, 0x73, 0x75, 0x62, 0x6d, 0x69, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x32, 0xc4,
+	0x01, 0x0a, 0x06, 0x46, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x12, 0x3c, 0x0a, 0x06, 0x53, 0x65, 0x61,
+	0x72, 0x63, 0x68, 0x12, 0x17, 0x2e, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e,
+	0x51, 0x75, 0x65, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e, 0x66,
+	0x61, 0x72, 0x6d, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x38, 0x0a, 0x06, 0x53, 0x63, 0x72, 0x6f, 0x6c,
+	0x6c, 0x12, 0x17, 0x2e, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x51, 0x75,
+	0x65, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e, 0x66, 0x61, 0x72,
+	0x6d, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x48, 0x69, 0x74, 0x42, 0x61, 0x74, 0x63, 0x68, 0x30,
+	0x01, 0x12, 0x42, 0x0a, 0x09, 0x55, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x12, 0x17,
+	0x2e, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x51, 0x75, 0x65, 0x72, 0x79,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72,
+	0x2e, 0x76, 0x31, 0x2e, 0x55, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x28, 0x5a, 0x26, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e,
+	0x63, 0x6f, 0x6d, 0x2f, 0x77, 0x74, 0x73, 0x69, 0x2d, 0x68, 0x67, 0x69, 0x2f, 0x67, 0x6f, 0x2d,
+	0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x2f, 0x66, 0x61, 0x72, 0x6d, 0x65, 0x72, 0x70, 0x62, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_farmerpb_farmer_proto_rawDescOnce sync.Once
+	file_farmerpb_farmer_proto_rawDescData = file_farmerpb_farmer_proto_rawDesc
+)
+
+func file_farmerpb_farmer_proto_rawDescGZIP() []byte {
+	file_farmerpb_farmer_proto_rawDescOnce.Do(func() {
+		file_farmerpb_farmer_proto_rawDescData = protoimpl.X.CompressGZIP(file_farmerpb_farmer_proto_rawDescData)
+	})
+	return file_farmerpb_farmer_proto_rawDescData
+}
+
+var file_farmerpb_farmer_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_farmerpb_farmer_proto_goTypes = []any{
+	(*QueryRequest)(nil),      // 0: farmer.v1.QueryRequest
+	(*Filter)(nil),            // 1: farmer.v1.Filter
+	(*SearchResponse)(nil),    // 2: farmer.v1.SearchResponse
+	(*HitBatch)(nil),          // 3: farmer.v1.HitBatch
+	(*UsernamesResponse)(nil), // 4: farmer.v1.UsernamesResponse
+	(*Hit)(nil),               // 5: farmer.v1.Hit
+}
+var file_farmerpb_farmer_proto_depIdxs = []int32{
+	1, // 0: farmer.v1.QueryRequest.filter:type_name -> farmer.v1.Filter
+	5, // 1: farmer.v1.SearchResponse.hits:type_name -> farmer.v1.Hit
+	5, // 2: farmer.v1.HitBatch.hits:type_name -> farmer.v1.Hit
+	0, // 3: farmer.v1.Farmer.Search:input_type -> farmer.v1.QueryRequest
+	0, // 4: farmer.v1.Farmer.Scroll:input_type -> farmer.v1.QueryRequest
+	0, // 5: farmer.v1.Farmer.Usernames:input_type -> farmer.v1.QueryRequest
+	2, // 6: farmer.v1.Farmer.Search:output_type -> farmer.v1.SearchResponse
+	3, // 7: farmer.v1.Farmer.Scroll:output_type -> farmer.v1.HitBatch
+	4, // 8: farmer.v1.Farmer.Usernames:output_type -> farmer.v1.UsernamesResponse
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_farmerpb_farmer_proto_init() }
+func file_farmerpb_farmer_proto_init() {
+	if File_farmerpb_farmer_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_farmerpb_farmer_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*QueryRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_farmerpb_farmer_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*Filter); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_farmerpb_farmer_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*SearchResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_farmerpb_farmer_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*HitBatch); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_farmerpb_farmer_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*UsernamesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_farmerpb_farmer_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*Hit); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_farmerpb_farmer_proto_msgTypes[0].OneofWrappers = []any{
+		(*QueryRequest_Filter)(nil),
+		(*QueryRequest_Body)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_farmerpb_farmer_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_farmerpb_farmer_proto_goTypes,
+		DependencyIndexes: file_farmerpb_farmer_proto_depIdxs,
+		MessageInfos:      file_farmerpb_farmer_proto_msgTypes,
+	}.Build()
+	File_farmerpb_farmer_proto = out.File
+	file_farmerpb_farmer_proto_rawDesc = nil
+	file_farmerpb_farmer_proto_goTypes = nil
+	file_farmerpb_farmer_proto_depIdxs = nil
+}
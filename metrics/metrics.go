@@ -0,0 +1,249 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// Package metrics provides simple counters and histograms that can be written
+// out in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ContentType is the Content-Type of the output of WriteMetrics().
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DurationBuckets are the default upper bounds, in seconds, of the buckets of a
+// histogram of request durations.
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60} //nolint:gochecknoglobals,lll
+
+// Writer types can write their metrics in the Prometheus text exposition
+// format.
+type Writer interface {
+	WriteMetrics(w io.Writer) error
+}
+
+// CounterVec is a set of counters that share a name, distinguished by the
+// value of a label.
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// NewCounterVec returns a CounterVec with the given name, help text and label
+// name.
+func NewCounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+}
+
+// Inc increments the counter with the given label value.
+func (c *CounterVec) Inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[labelValue]++
+}
+
+// Value returns the value of the counter with the given label value.
+func (c *CounterVec) Value(labelValue string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[labelValue]
+}
+
+// WriteMetrics writes our counters to the given Writer, in order of label
+// value.
+func (c *CounterVec) WriteMetrics(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	writeHeader(&buf, c.name, c.help, "counter")
+
+	for _, lv := range sortedKeys(c.values) {
+		fmt.Fprintf(&buf, "%s{%s=%q} %d\n", c.name, c.label, lv, c.values[lv])
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// histogram holds the observations of one label value of a HistogramVec.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a set of histograms that share a name and bucket bounds,
+// distinguished by the value of a label.
+type HistogramVec struct {
+	name   string
+	help   string
+	label  string
+	bounds []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+// NewHistogramVec returns a HistogramVec with the given name, help text, label
+// name and ascending bucket upper bounds (eg. DurationBuckets).
+func NewHistogramVec(name, help, label string, bounds []float64) *HistogramVec {
+	return &HistogramVec{
+		name:   name,
+		help:   help,
+		label:  label,
+		bounds: bounds,
+		values: make(map[string]*histogram),
+	}
+}
+
+// Observe adds the given value to the histogram with the given label value.
+func (h *HistogramVec) Observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[labelValue]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.bounds))}
+		h.values[labelValue] = hist
+	}
+
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		hist.counts[i]++
+	}
+
+	hist.sum += v
+	hist.count++
+}
+
+// Count returns the number of values observed by the histogram with the given
+// label value.
+func (h *HistogramVec) Count(labelValue string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if hist, ok := h.values[labelValue]; ok {
+		return hist.count
+	}
+
+	return 0
+}
+
+// WriteMetrics writes our histograms, with cumulative bucket counts, to the
+// given Writer, in order of label value.
+func (h *HistogramVec) WriteMetrics(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	writeHeader(&buf, h.name, h.help, "histogram")
+
+	for _, lv := range sortedKeys(h.values) {
+		hist := h.values[lv]
+
+		var cumulative uint64
+
+		for i, bound := range h.bounds {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(&buf, "%s_bucket{%s=%q,le=%q} %d\n", h.name, h.label, lv,
+				strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+
+		fmt.Fprintf(&buf, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", h.name, h.label, lv, hist.count)
+		fmt.Fprintf(&buf, "%s_sum{%s=%q} %s\n", h.name, h.label, lv,
+			strconv.FormatFloat(hist.sum, 'g', -1, 64))
+		fmt.Fprintf(&buf, "%s_count{%s=%q} %d\n", h.name, h.label, lv, hist.count)
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// WriteGauge writes a single unlabelled gauge with the given name, help text
+// and value to w.
+func WriteGauge(w io.Writer, name, help string, value float64) error {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, name, help, "gauge")
+	fmt.Fprintf(&buf, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// WriteCounter is like WriteGauge, but for a single unlabelled counter.
+func WriteCounter(w io.Writer, name, help string, value uint64) error {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, name, help, "counter")
+	fmt.Fprintf(&buf, "%s %d\n", name, value)
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// WriteAll writes the metrics of each of the given Writers to w, stopping at
+// the first error.
+func WriteAll(w io.Writer, writers ...Writer) error {
+	for _, mw := range writers {
+		if err := mw.WriteMetrics(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("CounterVecs count per label value", t, func() {
+		c := NewCounterVec("things_total", "Number of things.", "kind")
+		c.Inc("b")
+		c.Inc("a")
+		c.Inc("b")
+
+		So(c.Value("a"), ShouldEqual, 1)
+		So(c.Value("b"), ShouldEqual, 2)
+		So(c.Value("c"), ShouldEqual, 0)
+
+		var buf bytes.Buffer
+
+		So(c.WriteMetrics(&buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `# HELP things_total Number of things.
+# TYPE things_total counter
+things_total{kind="a"} 1
+things_total{kind="b"} 2
+`)
+	})
+
+	Convey("HistogramVecs write cumulative buckets per label value", t, func() {
+		h := NewHistogramVec("took_seconds", "Time taken.", "kind", []float64{0.5, 1})
+		h.Observe("a", 0.25)
+		h.Observe("a", 1)
+		h.Observe("a", 3)
+
+		So(h.Count("a"), ShouldEqual, 3)
+		So(h.Count("b"), ShouldEqual, 0)
+
+		var buf bytes.Buffer
+
+		So(WriteAll(&buf, h), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `# HELP took_seconds Time taken.
+# TYPE took_seconds histogram
+took_seconds_bucket{kind="a",le="0.5"} 1
+took_seconds_bucket{kind="a",le="1"} 2
+took_seconds_bucket{kind="a",le="+Inf"} 3
+took_seconds_sum{kind="a"} 4.25
+took_seconds_count{kind="a"} 3
+`)
+	})
+	Convey("Single gauges and counters can be written", t, func() {
+		var buf bytes.Buffer
+
+		So(WriteGauge(&buf, "size_bytes", "Size.", 1.5), ShouldBeNil)
+		So(WriteCounter(&buf, "evictions_total", "Evictions.", 3), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `# HELP size_bytes Size.
+# TYPE size_bytes gauge
+size_bytes 1.5
+# HELP evictions_total Evictions.
+# TYPE evictions_total counter
+evictions_total 3
+`)
+	})
+}
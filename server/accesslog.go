@@ -0,0 +1,245 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	accessLogMsg      = "request"
+	accessLogCacheMix = "partial"
+	accessLogListSep  = ","
+	msPerSecond       = 1000
+)
+
+type accessLogKey struct{}
+
+// accessLogEntry collects details of a request for its access log record.
+type accessLogEntry struct {
+	identity string
+	queries  []*es.Query
+}
+
+// accessLogWriter is an http.ResponseWriter that records the status and number
+// of bytes written.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader records the status before passing it on.
+func (a *accessLogWriter) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+
+	a.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written before passing them on.
+func (a *accessLogWriter) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += int64(n)
+
+	return n, err
+}
+
+// Unwrap lets http.ResponseController find our underlying ResponseWriter, eg.
+// to Flush() it.
+func (a *accessLogWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// SetAccessLog makes us log a record for every request to the given Logger,
+// with its method, path, status, bytes written (after any compression),
+// duration, the identity of the client (see SetAuth()), and for query requests,
+// the query Key()s, any BOM and USER_NAME filters, and whether the results
+// came from the cache. Use a nil Logger (the default) to turn this off.
+func (s *Server) SetAccessLog(logger *slog.Logger) {
+	s.accessLog = logger
+}
+
+// serveHTTPWithAccessLog calls serveHTTP() and then logs an access log record
+// for the request, if we have an access log, and records its queries, if we
+// have a QueryRecorder.
+func (s *Server) serveHTTPWithAccessLog(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	entry := &accessLogEntry{}
+	alw := &accessLogWriter{ResponseWriter: w}
+
+	s.serveHTTP(alw, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+	took := time.Since(start)
+
+	status := alw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if s.queryRecorder != nil {
+		s.recordQueries(requestKind(r), entry, start, took, status, alw.bytes)
+	}
+
+	s.logAccess(r.Context(), r.Method, r.URL.Path, status, alw.bytes, took, entry)
+}
+
+// logAccess logs an access log record for a request with the given details, if
+// we have an access log.
+func (s *Server) logAccess(ctx context.Context, method, path string, status int, bytes int64,
+	took time.Duration, entry *accessLogEntry) {
+	if s.accessLog == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("path", path),
+		slog.Int("status", status),
+		slog.Int64("bytes", bytes),
+		slog.Float64("duration_ms", float64(took.Microseconds())/msPerSecond),
+	}
+
+	if entry.identity != "" {
+		attrs = append(attrs, slog.String("identity", entry.identity))
+	}
+
+	attrs = append(attrs, entry.queryAttrs()...)
+
+	s.accessLog.LogAttrs(ctx, slog.LevelInfo, accessLogMsg, attrs...)
+}
+
+// noteIdentity records the identity of the client in the request's access log
+// entry, if there is one.
+func noteIdentity(r *http.Request, identity string) {
+	if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.identity = identity
+	}
+}
+
+// noteQueries records the queries a request is for in its access log entry, if
+// there is one.
+func noteQueries(r *http.Request, queries ...*es.Query) {
+	noteContextQueries(r.Context(), queries...)
+}
+
+// noteContextQueries is like noteQueries(), but for the access log entry of the
+// given context, eg. that of a gRPC call.
+func noteContextQueries(ctx context.Context, queries ...*es.Query) {
+	if entry, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.queries = append(entry.queries, queries...)
+	}
+}
+
+// queryAttrs returns log attributes describing our queries, or nothing if we
+// have none.
+func (e *accessLogEntry) queryAttrs() []slog.Attr {
+	if len(e.queries) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(e.queries))
+	boms := make(map[string]bool)
+	users := make(map[string]bool)
+	statuses := make(map[string]bool)
+
+	for i, query := range e.queries {
+		keys[i] = query.Key()
+		filters := query.Filters()
+
+		if bom := filters["BOM"]; bom != "" {
+			boms[bom] = true
+		}
+
+		if user := filters["USER_NAME"]; user != "" {
+			users[user] = true
+		}
+
+		statuses[query.CacheStatus()] = true
+	}
+
+	attrs := []slog.Attr{
+		slog.Int("queries", len(e.queries)),
+		slog.String("query_key", strings.Join(keys, accessLogListSep)),
+	}
+
+	attrs = appendListAttr(attrs, "bom", boms)
+	attrs = appendListAttr(attrs, "user", users)
+
+	if cache := cacheStatus(statuses); cache != "" {
+		attrs = append(attrs, slog.String("cache", cache))
+	}
+
+	return attrs
+}
+
+// appendListAttr appends an attribute with the given key and the sorted,
+// comma-separated keys of the given set as its value, unless the set is empty.
+func appendListAttr(attrs []slog.Attr, key string, set map[string]bool) []slog.Attr {
+	if len(set) == 0 {
+		return attrs
+	}
+
+	vals := make([]string, 0, len(set))
+	for val := range set {
+		vals = append(vals, val)
+	}
+
+	sort.Strings(vals)
+
+	return append(attrs, slog.String(key, strings.Join(vals, accessLogListSep)))
+}
+
+// cacheStatus returns es.CacheHit or es.CacheMiss if all queries had that
+// CacheStatus(), "partial" if they had a mix, or blank if unknown.
+func cacheStatus(statuses map[string]bool) string {
+	delete(statuses, "")
+
+	switch len(statuses) {
+	case 0:
+		return ""
+	case 1:
+		if statuses[es.CacheHit] {
+			return es.CacheHit
+		}
+
+		return es.CacheMiss
+	default:
+		return accessLogCacheMix
+	}
+}
@@ -0,0 +1,150 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestAccessLog(t *testing.T) {
+	Convey("cacheStatus() summarises the cache statuses of queries", t, func() {
+		So(cacheStatus(map[string]bool{}), ShouldBeBlank)
+		So(cacheStatus(map[string]bool{"": true}), ShouldBeBlank)
+		So(cacheStatus(map[string]bool{es.CacheHit: true}), ShouldEqual, es.CacheHit)
+		So(cacheStatus(map[string]bool{es.CacheMiss: true, "": true}), ShouldEqual, es.CacheMiss)
+		So(cacheStatus(map[string]bool{es.CacheMiss: true, es.CacheHit: true}), ShouldEqual, accessLogCacheMix)
+	})
+
+	Convey("Given a server with an access log", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		var buf bytes.Buffer
+
+		server.SetAccessLog(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+		records := func() []map[string]any {
+			var recs []map[string]any
+
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var rec map[string]any
+
+				So(json.Unmarshal([]byte(line), &rec), ShouldBeNil)
+
+				recs = append(recs, rec)
+			}
+
+			return recs
+		}
+
+		Convey("query requests are logged with their query details and cache status", func() {
+			for range 2 {
+				req, _ := mock.ScrollQuery("?scroll=1m")
+
+				w := httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, http.StatusOK)
+			}
+
+			recs := records()
+			So(len(recs), ShouldEqual, 2)
+
+			So(recs[0]["msg"], ShouldEqual, accessLogMsg)
+			So(recs[0]["method"], ShouldEqual, http.MethodPost)
+			So(recs[0]["path"], ShouldEqual, "/"+index+"/"+es.SearchPage)
+			So(recs[0]["status"], ShouldEqual, http.StatusOK)
+			So(recs[0]["bytes"], ShouldBeGreaterThan, 0)
+			So(recs[0]["duration_ms"], ShouldBeGreaterThan, 0)
+			So(recs[0]["queries"], ShouldEqual, 1)
+			So(recs[0]["query_key"], ShouldNotBeBlank)
+			So(recs[0]["identity"], ShouldBeNil)
+			So(recs[0]["cache"], ShouldEqual, es.CacheMiss)
+
+			So(recs[1]["query_key"], ShouldEqual, recs[0]["query_key"])
+			So(recs[1]["cache"], ShouldEqual, es.CacheHit)
+		})
+
+		Convey("BOM and user filters are logged", func() {
+			entry := &accessLogEntry{queries: []*es.Query{
+				{Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+					{"match_phrase": map[string]interface{}{"BOM": "Human Genetics"}},
+					{"match_phrase": map[string]interface{}{"USER_NAME": "u1"}},
+				}}}},
+				{Query: &es.QueryFilter{Bool: es.QFBool{Filter: es.Filter{
+					{"match_phrase": map[string]interface{}{"BOM": "CASM"}},
+				}}}},
+			}}
+
+			attrs := make(map[string]string)
+			for _, attr := range entry.queryAttrs() {
+				attrs[attr.Key] = attr.Value.String()
+			}
+
+			So(attrs["queries"], ShouldEqual, "2")
+			So(attrs["bom"], ShouldEqual, "CASM,Human Genetics")
+			So(attrs["user"], ShouldEqual, "u1")
+			So(attrs["cache"], ShouldBeBlank)
+		})
+
+		Convey("rejected and non-query requests are logged too", func() {
+			server.SetAuth(Auth{Users: map[string]string{"user": "pass"}})
+
+			req := httptest.NewRequest(http.MethodGet, "/"+statusEndpoint, nil)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+
+			req = httptest.NewRequest(http.MethodGet, "/"+statusEndpoint, nil)
+			req.SetBasicAuth("user", "pass")
+			w = httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			recs := records()
+			So(len(recs), ShouldEqual, 2)
+			So(recs[0]["status"], ShouldEqual, http.StatusUnauthorized)
+			So(recs[0]["identity"], ShouldBeNil)
+			So(recs[1]["status"], ShouldEqual, http.StatusOK)
+			So(recs[1]["identity"], ShouldEqual, "user:user")
+			So(recs[1]["queries"], ShouldBeNil)
+		})
+	})
+}
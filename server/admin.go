@@ -0,0 +1,216 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	adminReloadEndpoint       = "admin/reload"
+	adminFlushCacheEndpoint   = "admin/flush-cache"
+	adminSlowQueriesEndpoint  = "admin/slow-queries"
+	adminCacheEndpoint        = "admin/cache"
+	adminReloadConfigEndpoint = "admin/reload-config"
+
+	msgAdminOnly = "admin credentials required"
+)
+
+// Reloader types can look for new data right away. db.Backends are Reloaders.
+type Reloader interface {
+	Reload() error
+}
+
+// FlushResult is the response to an /admin/flush-cache request.
+type FlushResult struct {
+	// Flushed is how many cached results were discarded.
+	Flushed int `json:"flushed"`
+}
+
+type identityKey struct{}
+
+// withIdentity returns the request with the given identity from checkAuth()
+// stored in its context, so that handlers can find it with identityOf().
+func withIdentity(r *http.Request, identity string) *http.Request {
+	if identity == "" {
+		return r
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+}
+
+// identityOf returns the identity stored in the request by withIdentity(), or
+// "" if there isn't one.
+func identityOf(r *http.Request) string {
+	identity, _ := r.Context().Value(identityKey{}).(string) //nolint:errcheck
+
+	return identity
+}
+
+// SetReloader makes our /admin/reload endpoint call the given Reloader's
+// Reload(), eg. after a manual backfill.
+func (s *Server) SetReloader(r Reloader) {
+	s.reloader = r
+}
+
+// SetConfigReloader makes our /admin/reload-config endpoint call the given
+// function, which should re-read the configuration the server was started
+// with and apply it, eg. by calling SetSearchScroller().
+func (s *Server) SetConfigReloader(reload func() error) {
+	s.configReloader = reload
+}
+
+// checkAdmin returns true if the request uses the given method and is from one
+// of our Auth's Admins or AdminTokens. Otherwise responds with a 405 or 403
+// status and returns false.
+func (s *Server) checkAdmin(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return false
+	}
+
+	if s.auth == nil || !s.auth.isAdmin(identityOf(r)) {
+		w.WriteHeader(http.StatusForbidden)
+		sendMessageToClient(w, msgAdminOnly)
+
+		return false
+	}
+
+	return true
+}
+
+// adminReload handles /admin/reload requests by calling our Reloader's
+// Reload() and then emptying our cache, since cached results might be missing
+// the new data. Responds with our new Status as JSON.
+func (s *Server) adminReload(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r, http.MethodPost) {
+		return
+	}
+
+	if s.reloader == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+
+		return
+	}
+
+	flushed, err := s.reloadAndFlush()
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	slog.Info("admin reload", "by", identityOf(r), "flushed", flushed)
+
+	sendJSONToClient(w, http.StatusOK, s.currentStatus(time.Now()))
+}
+
+// reloadAndFlush calls our Reloader's Reload(), if we have one, and that of
+// any AddIndex() DataSource that is a Reloader, then empties our caches,
+// returning how many results were in them.
+func (s *Server) reloadAndFlush() (int, error) {
+	reloaders := []Reloader{s.reloader}
+
+	for _, f := range s.farms {
+		if reloader, ok := f.dataSource.(Reloader); ok {
+			reloaders = append(reloaders, reloader)
+		}
+	}
+
+	for _, reloader := range reloaders {
+		if reloader == nil {
+			continue
+		}
+
+		if err := reloader.Reload(); err != nil {
+			return 0, err
+		}
+	}
+
+	return s.flushAll(), nil
+}
+
+// adminFlushCache handles /admin/flush-cache requests by emptying our cache,
+// responding with a FlushResult as JSON.
+func (s *Server) adminFlushCache(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r, http.MethodPost) {
+		return
+	}
+
+	flushed := s.flushAll()
+
+	slog.Info("admin cache flush", "by", identityOf(r), "flushed", flushed)
+
+	sendJSONToClient(w, http.StatusOK, FlushResult{Flushed: flushed})
+}
+
+// adminReloadConfig handles /admin/reload-config requests by calling our
+// config reloader. Responds with our Status as JSON.
+func (s *Server) adminReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r, http.MethodPost) {
+		return
+	}
+
+	if s.configReloader == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+
+		return
+	}
+
+	if err := s.configReloader(); err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	slog.Info("admin config reload", "by", identityOf(r))
+
+	sendJSONToClient(w, http.StatusOK, s.currentStatus(time.Now()))
+}
+
+// adminSlowQueries handles /admin/slow-queries requests by responding
+// with our SearchScroller's SlowQueries() as JSON.
+func (s *Server) adminSlowQueries(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r, http.MethodGet) {
+		return
+	}
+
+	sendJSONToClient(w, http.StatusOK, s.slowQueries())
+}
+
+// adminCache handles /admin/cache requests by responding with the cache
+// Stats() of our SearchScrollers as JSON, keyed by index.
+func (s *Server) adminCache(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdmin(w, r, http.MethodGet) {
+		return
+	}
+
+	sendJSONToClient(w, http.StatusOK, s.cacheStats())
+}
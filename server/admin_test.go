@@ -0,0 +1,280 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// countingReloader is a Reloader that counts its Reload() calls, returning the
+// given err.
+type countingReloader struct {
+	reloads int
+	err     error
+}
+
+func (c *countingReloader) Reload() error {
+	c.reloads++
+
+	return c.err
+}
+
+func TestAdmin(t *testing.T) {
+	Convey("Given a server with a cache and a Reloader", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 2)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		reloader := &countingReloader{}
+		server.SetReloader(reloader)
+
+		serve := func(req *http.Request) *http.Response {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		adminRequest := func(endpoint string) *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/"+endpoint, nil)
+			req.SetBasicAuth("admin", "secret")
+
+			return req
+		}
+
+		cacheAQuery := func() {
+			req := mock.AggQuery()
+			req.SetBasicAuth("admin", "secret")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+		}
+
+		Convey("admin endpoints are forbidden without auth", func() {
+			resp := serve(httptest.NewRequest(http.MethodPost, "/"+adminFlushCacheEndpoint, nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+
+			resp = serve(httptest.NewRequest(http.MethodPost, "/"+adminReloadEndpoint, nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(reloader.reloads, ShouldEqual, 0)
+		})
+
+		Convey("with auth", func() {
+			server.SetAuth(Auth{
+				Users:       map[string]string{"admin": "secret", "user": "pass"},
+				Tokens:      []string{"tok"},
+				Admins:      []string{"admin"},
+				AdminTokens: []string{"admintok"},
+			})
+
+			Convey("non-admins are forbidden", func() {
+				req := adminRequest(adminFlushCacheEndpoint)
+				req.SetBasicAuth("user", "pass")
+				So(serve(req).StatusCode, ShouldEqual, http.StatusForbidden)
+
+				req = adminRequest(adminFlushCacheEndpoint)
+				req.Header.Set("Authorization", "Bearer tok")
+				So(serve(req).StatusCode, ShouldEqual, http.StatusForbidden)
+
+				server.auth.ExemptPaths = []string{"/" + adminReloadEndpoint}
+
+				req = httptest.NewRequest(http.MethodPost, "/"+adminReloadEndpoint, nil)
+				So(serve(req).StatusCode, ShouldEqual, http.StatusForbidden)
+				So(reloader.reloads, ShouldEqual, 0)
+			})
+
+			Convey("only POST is allowed", func() {
+				req := adminRequest(adminFlushCacheEndpoint)
+				req.Method = http.MethodGet
+				So(serve(req).StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+			})
+
+			Convey("admins can flush the cache", func() {
+				cacheAQuery()
+
+				resp := serve(adminRequest(adminFlushCacheEndpoint))
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				var result FlushResult
+
+				err = json.NewDecoder(resp.Body).Decode(&result)
+				So(err, ShouldBeNil)
+				So(result.Flushed, ShouldEqual, 1)
+
+				req := adminRequest(adminFlushCacheEndpoint)
+				req.Header.Set("Authorization", "Bearer admintok")
+				resp = serve(req)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				err = json.NewDecoder(resp.Body).Decode(&result)
+				So(err, ShouldBeNil)
+				So(result.Flushed, ShouldEqual, 0)
+			})
+
+			Convey("admin tokens also work as normal tokens", func() {
+				req := mock.AggQuery()
+				req.Header.Set("Authorization", "Bearer admintok")
+				So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("admins can reload, which also flushes the cache", func() {
+				server.SetDataSource(fixedDataSource(time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)))
+				cacheAQuery()
+
+				resp := serve(adminRequest(adminReloadEndpoint))
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(reloader.reloads, ShouldEqual, 1)
+				So(cq.Flush(), ShouldEqual, 0)
+
+				var status Status
+
+				err = json.NewDecoder(resp.Body).Decode(&status)
+				So(err, ShouldBeNil)
+				So(status.DaysBehind, ShouldEqual, 0)
+
+				reloader.err = errors.New("sync failed")
+
+				resp = serve(adminRequest(adminReloadEndpoint))
+				So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+			})
+
+			Convey("admins can list slow queries", func() {
+				cq.SetSlowQueryThreshold(time.Nanosecond)
+				cacheAQuery()
+
+				req := adminRequest(adminSlowQueriesEndpoint)
+				req.Method = http.MethodGet
+				resp := serve(req)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				var sqs []es.SlowQuery
+
+				err = json.NewDecoder(resp.Body).Decode(&sqs)
+				So(err, ShouldBeNil)
+				So(len(sqs), ShouldEqual, 1)
+				So(sqs[0].Kind, ShouldEqual, "search")
+
+				req = adminRequest(adminSlowQueriesEndpoint)
+				So(serve(req).StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+
+				req = adminRequest(adminSlowQueriesEndpoint)
+				req.Method = http.MethodGet
+				req.SetBasicAuth("user", "pass")
+				So(serve(req).StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+
+			Convey("admins can list what's in the cache", func() {
+				cacheAQuery()
+				cacheAQuery()
+
+				req := adminRequest(adminCacheEndpoint)
+				req.Method = http.MethodGet
+				resp := serve(req)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				var stats map[string]cache.Stats
+
+				err = json.NewDecoder(resp.Body).Decode(&stats)
+				So(err, ShouldBeNil)
+				So(stats, ShouldContainKey, index)
+				So(stats[index].Partitions[0], ShouldResemble, cache.PartitionStats{
+					Name: cache.PartitionSearch, Size: 2, Entries: 1,
+					Bytes: stats[index].Entries[0].Bytes, Hits: 1, Misses: 1,
+				})
+				So(len(stats[index].Entries), ShouldEqual, 1)
+				So(stats[index].Entries[0].Kind, ShouldEqual, cache.PartitionSearch)
+
+				req = adminRequest(adminCacheEndpoint)
+				So(serve(req).StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+
+				req = adminRequest(adminCacheEndpoint)
+				req.Method = http.MethodGet
+				req.SetBasicAuth("user", "pass")
+				So(serve(req).StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+
+			Convey("admins can reload the config, which can replace the SearchScroller", func() {
+				server.SetDataSource(fixedDataSource(time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)))
+				cacheAQuery()
+
+				resp := serve(adminRequest(adminReloadConfigEndpoint))
+				So(resp.StatusCode, ShouldEqual, http.StatusNotImplemented)
+
+				cq2, errc := cache.New(mock, mock, 2)
+				So(errc, ShouldBeNil)
+
+				reloads := 0
+				server.SetConfigReloader(func() error {
+					reloads++
+					server.SetSearchScroller(cq2)
+
+					return nil
+				})
+
+				req := adminRequest(adminReloadConfigEndpoint)
+				req.SetBasicAuth("user", "pass")
+				So(serve(req).StatusCode, ShouldEqual, http.StatusForbidden)
+				So(reloads, ShouldEqual, 0)
+
+				resp = serve(adminRequest(adminReloadConfigEndpoint))
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(reloads, ShouldEqual, 1)
+
+				var status Status
+
+				err = json.NewDecoder(resp.Body).Decode(&status)
+				So(err, ShouldBeNil)
+				So(status.DaysBehind, ShouldEqual, 0)
+
+				cacheAQuery()
+				So(cq.Flush(), ShouldEqual, 1)
+				So(cq2.Flush(), ShouldEqual, 1)
+
+				server.SetConfigReloader(func() error { return errors.New("bad config") })
+
+				resp = serve(adminRequest(adminReloadConfigEndpoint))
+				So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+			})
+
+			Convey("reload isn't implemented without a Reloader", func() {
+				server.SetReloader(nil)
+
+				resp := serve(adminRequest(adminReloadEndpoint))
+				So(resp.StatusCode, ShouldEqual, http.StatusNotImplemented)
+			})
+		})
+	})
+}
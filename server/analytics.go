@@ -0,0 +1,81 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/analytics"
+)
+
+const scrollKind = "scroll"
+
+// QueryRecorder types can record the queries we answer, eg. an
+// *analytics.Store.
+type QueryRecorder interface {
+	RecordQuery(rec analytics.Record)
+}
+
+// SetQueryRecorder makes us give the given QueryRecorder an analytics.Record of
+// every query sent to us over HTTP or gRPC, with the identity of the client
+// (see SetAuth()), the duration of the request, and the size and status of its
+// response (gRPC codes being converted to their equivalent http status). Use
+// nil (the default) to turn this off.
+func (s *Server) SetQueryRecorder(qr QueryRecorder) {
+	s.queryRecorder = qr
+}
+
+// requestKind returns the analytics.Record Kind of the given request, based on
+// its path.
+func requestKind(r *http.Request) string {
+	return strings.TrimPrefix(path.Base(r.URL.Path), "_")
+}
+
+// recordQueries gives our QueryRecorder a Record of each of the queries in the
+// given access log entry, of a request of the given kind.
+func (s *Server) recordQueries(kind string, entry *accessLogEntry, start time.Time, took time.Duration,
+	status int, bytes int64) {
+	for _, query := range entry.queries {
+		rec := analytics.Record{
+			Time:     start,
+			Kind:     kind,
+			Identity: entry.identity,
+			Query:    query,
+			Duration: took,
+			Bytes:    bytes,
+			Status:   status,
+		}
+
+		if kind == "search" && query.IsScroll() {
+			rec.Kind = scrollKind
+		}
+
+		s.queryRecorder.RecordQuery(rec)
+	}
+}
@@ -0,0 +1,111 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/analytics"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+type recorder struct {
+	mu      sync.Mutex
+	records []analytics.Record
+}
+
+func (r *recorder) RecordQuery(rec analytics.Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, rec)
+}
+
+func TestQueryRecorder(t *testing.T) {
+	Convey("Given a server with a QueryRecorder", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		server.SetAuth(Auth{Users: map[string]string{"alice": "pass"}})
+
+		rec := &recorder{}
+		server.SetQueryRecorder(rec)
+
+		serve := func(req *http.Request) *httptest.ResponseRecorder {
+			req.SetBasicAuth("alice", "pass")
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w
+		}
+
+		Convey("each query is recorded with its kind, identity, duration and size", func() {
+			req, _ := mock.ScrollQuery("?scroll=1m")
+			w := serve(req)
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			w = serve(mock.AggQuery())
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			So(len(rec.records), ShouldEqual, 2)
+
+			scroll := rec.records[0]
+			So(scroll.Kind, ShouldEqual, scrollKind)
+			So(scroll.Identity, ShouldEqual, "user:alice")
+			So(scroll.Query.Filters()["META_CLUSTER_NAME"], ShouldEqual, "farm")
+			So(scroll.Query.CacheStatus(), ShouldEqual, es.CacheMiss)
+			So(scroll.Duration, ShouldBeGreaterThan, 0)
+			So(scroll.Bytes, ShouldBeGreaterThan, 0)
+			So(scroll.Status, ShouldEqual, http.StatusOK)
+			So(scroll.Time.IsZero(), ShouldBeFalse)
+
+			So(rec.records[1].Kind, ShouldEqual, "search")
+		})
+
+		Convey("requests that aren't queries aren't recorded", func() {
+			serve(httptest.NewRequest(http.MethodGet, "/"+statusEndpoint, nil))
+			So(rec.records, ShouldBeEmpty)
+		})
+
+		Convey("it can be turned off", func() {
+			server.SetQueryRecorder(nil)
+
+			req, _ := mock.ScrollQuery("?scroll=1m")
+			serve(req)
+			So(rec.records, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,181 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	authHeader   = "Authorization"
+	bearerPrefix = "Bearer "
+
+	userIdentityPrefix       = "user:"
+	tokenIdentityPrefix      = "token:"
+	adminTokenIdentityPrefix = "admin-token:"
+)
+
+// Auth configures the credentials clients must supply to use a Server. Users
+// maps basic auth usernames to their passwords, and Tokens are accepted as
+// "Authorization: Bearer <token>". Requests for ExemptPaths (eg. a health
+// check) need no credentials.
+//
+// Only the Users named in Admins, and the holders of AdminTokens (which can
+// also be used like Tokens), can use the admin endpoints.
+type Auth struct {
+	Users       map[string]string
+	Tokens      []string
+	ExemptPaths []string
+	Admins      []string
+	AdminTokens []string
+}
+
+// enabled returns true if any credentials have been configured.
+func (a *Auth) enabled() bool {
+	return len(a.Users) > 0 || a.hasTokens()
+}
+
+// hasTokens returns true if any Tokens or AdminTokens have been configured.
+func (a *Auth) hasTokens() bool {
+	return len(a.Tokens) > 0 || len(a.AdminTokens) > 0
+}
+
+// isExempt returns true if the given path is one of our ExemptPaths.
+func (a *Auth) isExempt(path string) bool {
+	for _, exempt := range a.ExemptPaths {
+		if path == exempt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize returns the identity of the request's credentials if they are
+// one of our users' basic auth credentials ("user:<name>"), or one of our
+// Tokens ("token:<index>") or AdminTokens ("admin-token:<index>"), and true if
+// they were a token.
+func (a *Auth) authorize(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get(authHeader), bearerPrefix); ok {
+		for i, t := range a.Tokens {
+			if secretsEqual(token, t) {
+				return tokenIdentityPrefix + strconv.Itoa(i), true
+			}
+		}
+
+		for i, t := range a.AdminTokens {
+			if secretsEqual(token, t) {
+				return adminTokenIdentityPrefix + strconv.Itoa(i), true
+			}
+		}
+
+		return "", false
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	if expected, ok := a.Users[user]; ok && secretsEqual(password, expected) {
+		return userIdentityPrefix + user, false
+	}
+
+	return "", false
+}
+
+// isAdmin returns true if the given identity from authorize() is one of our
+// Admins or AdminTokens.
+func (a *Auth) isAdmin(identity string) bool {
+	if strings.HasPrefix(identity, adminTokenIdentityPrefix) {
+		return true
+	}
+
+	user, ok := strings.CutPrefix(identity, userIdentityPrefix)
+	if !ok {
+		return false
+	}
+
+	for _, admin := range a.Admins {
+		if user == admin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secretsEqual compares the given strings in constant time, regardless of
+// their lengths.
+func secretsEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// SetAuth makes all subsequent requests, except for the auth's ExemptPaths,
+// need one of the auth's credentials, otherwise they get a 401 status. A
+// bearer token is removed from requests before they are proxied, since
+// elastic search wouldn't understand it, but basic auth credentials are
+// passed on.
+func (s *Server) SetAuth(auth Auth) {
+	s.auth = &auth
+}
+
+// checkAuth returns the identity of the request's credentials (or "" if we
+// don't require any) and true if the request is allowed by our Auth.
+// Otherwise responds with a 401 status and returns false.
+func (s *Server) checkAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if s.auth == nil || !s.auth.enabled() || s.auth.isExempt(r.URL.Path) {
+		return "", true
+	}
+
+	identity, isToken := s.auth.authorize(r)
+	if identity != "" {
+		if isToken {
+			r.Header.Del(authHeader)
+		}
+
+		return identity, true
+	}
+
+	if len(s.auth.Users) > 0 {
+		w.Header().Add("WWW-Authenticate", `Basic realm="farmer"`)
+	}
+
+	if s.auth.hasTokens() {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="farmer"`)
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+	sendMessageToClient(w, http.StatusText(http.StatusUnauthorized))
+
+	return "", false
+}
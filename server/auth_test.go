@@ -0,0 +1,133 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+)
+
+// authRecordingServer is a "real" elastic search that records the
+// Authorization header of the last request proxied to it.
+type authRecordingServer struct {
+	authorization string
+}
+
+func (a *authRecordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.authorization = r.Header.Get("Authorization")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestAuth(t *testing.T) {
+	Convey("Given a server with auth", t, func() {
+		index := "some-indexes-*"
+		realES := &authRecordingServer{}
+
+		mockReal := httptest.NewServer(realES)
+		defer mockReal.Close()
+
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: strings.TrimPrefix(mockReal.URL, "http://"), Scheme: "http"})
+		server.SetAuth(Auth{
+			Users:       map[string]string{"alice": "pass"},
+			Tokens:      []string{"tok"},
+			ExemptPaths: []string{"/_cluster/health"},
+		})
+
+		serve := func(req *http.Request) *http.Response {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		Convey("requests without credentials are refused", func() {
+			resp := serve(mock.AggQuery())
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(resp.Header.Values("WWW-Authenticate"), ShouldResemble,
+				[]string{`Basic realm="farmer"`, `Bearer realm="farmer"`})
+
+			resp = serve(httptest.NewRequest(http.MethodGet, "/", nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(realES.authorization, ShouldBeEmpty)
+		})
+
+		Convey("requests with the wrong credentials are refused", func() {
+			req := mock.AggQuery()
+			req.SetBasicAuth("alice", "wrong")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			req = mock.AggQuery()
+			req.SetBasicAuth("bob", "pass")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			req = mock.AggQuery()
+			req.Header.Set("Authorization", "Bearer wrong")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("requests with a user's basic auth are allowed, and proxied with it", func() {
+			req := mock.AggQuery()
+			req.SetBasicAuth("alice", "pass")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.SetBasicAuth("alice", "pass")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+			So(realES.authorization, ShouldStartWith, "Basic ")
+		})
+
+		Convey("requests with a token are allowed, and proxied without it", func() {
+			req := mock.AggQuery()
+			req.Header.Set("Authorization", "Bearer tok")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer tok")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+			So(realES.authorization, ShouldBeEmpty)
+		})
+
+		Convey("exempt paths need no credentials", func() {
+			resp := serve(httptest.NewRequest(http.MethodGet, "/_cluster/health", nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("an Auth without credentials allows everything", func() {
+			server.SetAuth(Auth{})
+			So(serve(mock.AggQuery()).StatusCode, ShouldEqual, http.StatusOK)
+		})
+	})
+}
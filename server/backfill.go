@@ -0,0 +1,324 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+const (
+	adminBackfillEndpoint = "admin/backfill"
+
+	backfillFromFormat    = time.DateOnly
+	defaultBackfillPeriod = "2d"
+	maxBackfillJobs       = 100
+
+	msgBackfillRunning = "a backfill is already running"
+	msgReadOnly        = "server is read-only"
+
+	// BackfillRunning is the State of a BackfillJob that hasn't finished yet.
+	BackfillRunning = "running"
+
+	// BackfillSucceeded is the State of a BackfillJob that finished without
+	// error.
+	BackfillSucceeded = "succeeded"
+
+	// BackfillFailed is the State of a BackfillJob that finished with an
+	// error; some days may still have succeeded, as its Report shows.
+	BackfillFailed = "failed"
+)
+
+// BackfillFunc backfills the local database for the given period before the
+// midnight prior to from, like db.Backfill(), calling progress each time a day
+// is finished.
+type BackfillFunc func(from time.Time, period time.Duration,
+	progress func(done, total int)) (*db.BackfillReport, error)
+
+// BackfillJob describes a backfill started by an /admin/backfill request.
+type BackfillJob struct {
+	ID        string             `json:"id"`
+	State     string             `json:"state"`
+	From      string             `json:"from"`
+	Period    string             `json:"period"`
+	DaysDone  int                `json:"days_done"`
+	DaysTotal int                `json:"days_total"`
+	Started   time.Time          `json:"started"`
+	Finished  *time.Time         `json:"finished,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Report    *db.BackfillReport `json:"report,omitempty"`
+}
+
+// backfillJobs runs a BackfillFunc for /admin/backfill requests, one at a
+// time, remembering the most recent maxBackfillJobs BackfillJobs.
+type backfillJobs struct {
+	backfill BackfillFunc
+
+	mu      sync.Mutex
+	jobs    map[string]*BackfillJob
+	order   []string
+	lastID  int
+	running bool
+}
+
+// SetBackfiller makes our /admin/backfill endpoint start backfills in the
+// background using the given BackfillFunc. When each finishes, anything you
+// SetReloader() is reloaded and our cache is emptied.
+func (s *Server) SetBackfiller(backfill BackfillFunc) {
+	s.backfills = &backfillJobs{
+		backfill: backfill,
+		jobs:     make(map[string]*BackfillJob),
+	}
+}
+
+// SetReadOnly, if given true, makes our /admin/backfill endpoint refuse to
+// start backfills with a 403 status, for when the database must not be
+// modified, eg. because it's a replica maintained elsewhere. The state of
+// backfills that were already started can still be got.
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// adminBackfill handles POST /admin/backfill?from=YYYY-MM-DD&period=2d requests
+// by starting a backfill and responding with a 202 status and its BackfillJob,
+// and GET /admin/backfill/<id> requests by responding with the BackfillJob
+// with that id.
+func (s *Server) adminBackfill(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, slash+adminBackfillEndpoint), slash)
+
+	method := http.MethodPost
+	if id != "" {
+		method = http.MethodGet
+	}
+
+	if !s.checkAdmin(w, r, method) {
+		return
+	}
+
+	if s.backfills == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+
+		return
+	}
+
+	if id != "" {
+		s.sendBackfillJob(w, id)
+
+		return
+	}
+
+	s.startBackfill(w, r)
+}
+
+// sendBackfillJob responds with the BackfillJob with the given id, or a 404
+// status if there isn't one.
+func (s *Server) sendBackfillJob(w http.ResponseWriter, id string) {
+	job, ok := s.backfills.job(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	sendJSONToClient(w, http.StatusOK, job)
+}
+
+// startBackfill starts a backfill for the request's from and period, unless one
+// is already running or we're read-only.
+func (s *Server) startBackfill(w http.ResponseWriter, r *http.Request) {
+	if s.readOnly {
+		w.WriteHeader(http.StatusForbidden)
+		sendMessageToClient(w, msgReadOnly)
+
+		return
+	}
+
+	from, period, err := backfillParams(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		sendMessageToClient(w, err.Error())
+
+		return
+	}
+
+	job, ok := s.backfills.start(from, period, s.finishBackfill)
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		sendMessageToClient(w, msgBackfillRunning)
+
+		return
+	}
+
+	slog.Info("admin backfill started", "by", identityOf(r), "id", job.ID,
+		"from", job.From, "period", job.Period)
+
+	w.Header().Set("Location", slash+adminBackfillEndpoint+slash+job.ID)
+	sendJSONToClient(w, http.StatusAccepted, job)
+}
+
+// backfillParams returns the request's from (default today) and period
+// (default 2d) query parameters.
+func backfillParams(r *http.Request) (time.Time, string, error) {
+	from := time.Now().UTC()
+
+	if f := r.URL.Query().Get("from"); f != "" {
+		var err error
+
+		from, err = time.Parse(backfillFromFormat, f)
+		if err != nil {
+			return from, "", err
+		}
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = defaultBackfillPeriod
+	}
+
+	_, err := db.ParsePeriod(period)
+
+	return from, period, err
+}
+
+// finishBackfill is called after each backfill to make its days visible.
+func (s *Server) finishBackfill(job BackfillJob) {
+	slog.Info("admin backfill finished", "id", job.ID, "state", job.State, "err", job.Error)
+
+	if _, err := s.reloadAndFlush(); err != nil {
+		slog.Error("reload after backfill failed", "err", err)
+	}
+}
+
+// start starts a backfill in the background and returns its BackfillJob, unless
+// one is already running. The given finished function is called with the
+// final BackfillJob once the backfill is done.
+func (b *backfillJobs) start(from time.Time, period string, finished func(BackfillJob)) (BackfillJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.running {
+		return BackfillJob{}, false
+	}
+
+	b.running = true
+	b.lastID++
+
+	job := &BackfillJob{
+		ID:      strconv.Itoa(b.lastID),
+		State:   BackfillRunning,
+		From:    from.Format(backfillFromFormat),
+		Period:  period,
+		Started: time.Now(),
+	}
+
+	b.remember(job)
+
+	go b.run(job, from, period, finished)
+
+	return *job, true
+}
+
+// remember stores the given job, forgetting the oldest if we have too many.
+func (b *backfillJobs) remember(job *BackfillJob) {
+	b.jobs[job.ID] = job
+	b.order = append(b.order, job.ID)
+
+	if len(b.order) > maxBackfillJobs {
+		delete(b.jobs, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// run runs our BackfillFunc for the given job, updating it as it progresses.
+func (b *backfillJobs) run(job *BackfillJob, from time.Time, period string, finished func(BackfillJob)) {
+	d, _ := db.ParsePeriod(period) //nolint:errcheck
+
+	report, err := b.backfill(from, d, func(done, total int) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		job.DaysDone, job.DaysTotal = done, total
+	})
+
+	b.mu.Lock()
+
+	now := time.Now()
+	job.Finished = &now
+	job.Report = report
+	job.State = BackfillSucceeded
+
+	if err != nil {
+		job.State = BackfillFailed
+		job.Error = err.Error()
+	}
+
+	final := *job
+	b.mu.Unlock()
+
+	finished(final)
+
+	b.mu.Lock()
+	b.running = false
+	b.mu.Unlock()
+}
+
+// list returns our remembered BackfillJobs, newest first. Returns an empty
+// slice if we're nil.
+func (b *backfillJobs) list() []BackfillJob {
+	jobs := []BackfillJob{}
+
+	if b == nil {
+		return jobs
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := len(b.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, *b.jobs[b.order[i]])
+	}
+
+	return jobs
+}
+
+// job returns a copy of the BackfillJob with the given id, if we have it.
+func (b *backfillJobs) job(id string) (BackfillJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	job, ok := b.jobs[id]
+	if !ok {
+		return BackfillJob{}, false
+	}
+
+	return *job, true
+}
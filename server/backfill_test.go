@@ -0,0 +1,186 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+// blockingBackfiller is a BackfillFunc that records its args, reports progress
+// of 1 of 2 days, then waits to be told to finish with the given error.
+type blockingBackfiller struct {
+	from   time.Time
+	period time.Duration
+	finish chan error
+}
+
+func (b *blockingBackfiller) backfill(from time.Time, period time.Duration,
+	progress func(done, total int)) (*db.BackfillReport, error) {
+	b.from, b.period = from, period
+
+	progress(1, 2)
+
+	return &db.BackfillReport{Succeeded: 2}, <-b.finish
+}
+
+func TestAdminBackfill(t *testing.T) {
+	Convey("Given a server with a backfiller and an admin", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 2)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		server.SetAuth(Auth{AdminTokens: []string{"admin"}})
+
+		reloader := &countingReloader{}
+		server.SetReloader(reloader)
+
+		backfiller := &blockingBackfiller{finish: make(chan error)}
+		server.SetBackfiller(backfiller.backfill)
+
+		defer close(backfiller.finish)
+
+		request := func(method, path string) *http.Response {
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Authorization", "Bearer admin")
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		decodeJob := func(resp *http.Response) BackfillJob {
+			var job BackfillJob
+
+			So(json.NewDecoder(resp.Body).Decode(&job), ShouldBeNil)
+
+			return job
+		}
+
+		getJobEventually := func(id, state string) BackfillJob {
+			for {
+				resp := request(http.MethodGet, "/admin/backfill/"+id)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+				job := decodeJob(resp)
+				if job.State == state && job.DaysDone > 0 {
+					return job
+				}
+
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		Convey("you can start a backfill and follow its progress", func() {
+			resp := request(http.MethodPost, "/admin/backfill?from=2024-05-10&period=3d")
+			So(resp.StatusCode, ShouldEqual, http.StatusAccepted)
+			So(resp.Header.Get("Location"), ShouldEqual, "/admin/backfill/1")
+
+			job := decodeJob(resp)
+			So(job.ID, ShouldEqual, "1")
+			So(job.State, ShouldEqual, BackfillRunning)
+			So(job.From, ShouldEqual, "2024-05-10")
+			So(job.Period, ShouldEqual, "3d")
+
+			job = getJobEventually("1", BackfillRunning)
+			So(job.DaysDone, ShouldEqual, 1)
+			So(job.DaysTotal, ShouldEqual, 2)
+			So(job.Report, ShouldBeNil)
+			So(backfiller.from, ShouldEqual, time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC))
+			So(backfiller.period, ShouldEqual, 72*time.Hour)
+
+			Convey("but not start another until it finishes, after which we reload", func() {
+				resp = request(http.MethodPost, "/admin/backfill")
+				So(resp.StatusCode, ShouldEqual, http.StatusConflict)
+
+				backfiller.finish <- nil
+
+				job = getJobEventually("1", BackfillSucceeded)
+				So(job.Finished, ShouldNotBeNil)
+				So(job.Report.Succeeded, ShouldEqual, 2)
+
+				for {
+					resp = request(http.MethodPost, "/admin/backfill")
+					if resp.StatusCode != http.StatusConflict {
+						break
+					}
+
+					time.Sleep(time.Millisecond)
+				}
+
+				So(resp.StatusCode, ShouldEqual, http.StatusAccepted)
+				So(reloader.reloads, ShouldEqual, 1)
+
+				job = decodeJob(resp)
+				So(job.ID, ShouldEqual, "2")
+				So(job.From, ShouldEqual, time.Now().UTC().Format(time.DateOnly))
+				So(job.Period, ShouldEqual, "2d")
+
+				backfiller.finish <- errors.New("elastic search down")
+
+				job = getJobEventually("2", BackfillFailed)
+				So(job.Error, ShouldEqual, "elastic search down")
+			})
+		})
+
+		Convey("bad parameters are rejected", func() {
+			resp := request(http.MethodPost, "/admin/backfill?from=yesterday")
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+			resp = request(http.MethodPost, "/admin/backfill?period=2%20days")
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("backfills can't be started if read-only", func() {
+			server.SetReadOnly(true)
+
+			resp := request(http.MethodPost, "/admin/backfill?from=2024-05-10&period=3d")
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(backfiller.period, ShouldEqual, 0)
+		})
+
+		Convey("unknown jobs aren't found", func() {
+			So(request(http.MethodGet, "/admin/backfill/1").StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("the right methods must be used", func() {
+			So(request(http.MethodGet, "/admin/backfill").StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+			So(request(http.MethodPost, "/admin/backfill/1").StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
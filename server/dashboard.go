@@ -0,0 +1,184 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	dashboardEndpoint         = "dashboard/"
+	dashboardOverviewEndpoint = "dashboard/api/overview"
+	dashboardDaysParam        = "days"
+	defaultDashboardDays      = 30
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// CoverageSource types can say how many hits they have stored for each day and
+// BOM. db.Backends are CoverageSources.
+type CoverageSource interface {
+	Coverage(ctx context.Context) ([]db.DayCoverage, error)
+}
+
+// DashboardOverview is the response to a /dashboard/api/overview request.
+type DashboardOverview struct {
+	// Status and Readiness are as returned by /status and /readyz.
+	Status    Status    `json:"status"`
+	Readiness Readiness `json:"readiness"`
+
+	// Coverage has the hits stored for each of the most recent days and each
+	// BOM, for each index whose DataSource is a CoverageSource.
+	Coverage map[string][]db.DayCoverage `json:"coverage"`
+
+	// Backfills are the recent backfills started with /admin/backfill, newest
+	// first.
+	Backfills []BackfillJob `json:"backfills"`
+
+	// Cache has the occupancy of each index's caches, as in /admin/cache.
+	Cache map[string][]cache.PartitionStats `json:"cache"`
+
+	// SlowQueries are as returned by /admin/slow-queries.
+	SlowQueries []es.SlowQuery `json:"slow_queries"`
+}
+
+// EnableDashboard makes us serve a web dashboard at "/dashboard/", showing the
+// coverage of the local database(s) per day and BOM, backfill status, cache
+// statistics and recent slow queries, using JSON from
+// "/dashboard/api/overview". Like our /admin endpoints, these need the
+// credentials of one of our Auth's Admins or AdminTokens (see SetAuth()), so
+// are forbidden to everyone if we have no Auth, unless open is true, in which
+// case anyone (who passes any Auth) can see them.
+func (s *Server) EnableDashboard(open bool) {
+	files, _ := fs.Sub(dashboardFiles, "dashboard") //nolint:errcheck
+
+	s.dashboard = http.StripPrefix(slash+dashboardEndpoint, http.FileServer(http.FS(files)))
+	s.dashboardOpen = open
+}
+
+// checkDashboardAccess returns true if we EnableDashboard()ed and the request
+// is a GET that passes checkAdmin(), or our dashboard is open. Otherwise
+// responds with a 404, 405 or 403 status and returns false.
+func (s *Server) checkDashboardAccess(w http.ResponseWriter, r *http.Request) bool {
+	if s.dashboard == nil {
+		http.NotFound(w, r)
+
+		return false
+	}
+
+	if !s.dashboardOpen {
+		return s.checkAdmin(w, r, http.MethodGet)
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return false
+	}
+
+	return true
+}
+
+// dashboardPage handles /dashboard/ requests by serving our embedded static
+// files.
+func (s *Server) dashboardPage(w http.ResponseWriter, r *http.Request) {
+	if !s.checkDashboardAccess(w, r) {
+		return
+	}
+
+	s.dashboard.ServeHTTP(w, r)
+}
+
+// dashboardOverview handles /dashboard/api/overview?days=N requests by
+// returning our DashboardOverview as JSON, with the coverage of the last N
+// (default 30) days.
+func (s *Server) dashboardOverview(w http.ResponseWriter, r *http.Request) {
+	if !s.checkDashboardAccess(w, r) {
+		return
+	}
+
+	days, err := dashboardDays(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		sendMessageToClient(w, err.Error())
+
+		return
+	}
+
+	overview := DashboardOverview{
+		Status:      s.currentStatus(time.Now()),
+		Readiness:   s.currentReadiness(),
+		Coverage:    make(map[string][]db.DayCoverage),
+		Backfills:   s.backfills.list(),
+		Cache:       make(map[string][]cache.PartitionStats),
+		SlowQueries: s.slowQueries(),
+	}
+
+	for _, f := range s.allFarms() {
+		overview.Cache[f.index] = f.searchScroller().Stats().Partitions
+
+		cs, ok := f.dataSource.(CoverageSource)
+		if !ok {
+			continue
+		}
+
+		coverage, errc := cs.Coverage(r.Context())
+		if errc != nil {
+			sendErrorToClient(w, errc)
+
+			return
+		}
+
+		overview.Coverage[f.index] = coverage[max(0, len(coverage)-days):]
+	}
+
+	sendJSONToClient(w, http.StatusOK, overview)
+}
+
+// dashboardDays returns the request's days parameter, defaulting to 30.
+func dashboardDays(r *http.Request) (int, error) {
+	param := r.URL.Query().Get(dashboardDaysParam)
+	if param == "" {
+		return defaultDashboardDays, nil
+	}
+
+	days, err := strconv.Atoi(param)
+	if err == nil && days < 1 {
+		err = strconv.ErrRange
+	}
+
+	return days, err
+}
@@ -0,0 +1,147 @@
+// Renders the JSON from api/overview, refreshing it every 30 seconds.
+"use strict";
+
+const refreshMS = 30000;
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+
+  for (const [k, v] of Object.entries(attrs || {})) {
+    e.setAttribute(k, v);
+  }
+
+  for (const child of children) {
+    e.append(child instanceof Node ? child : String(child ?? ""));
+  }
+
+  return e;
+}
+
+function table(headings, rows) {
+  if (rows.length === 0) {
+    return el("p", {}, "none");
+  }
+
+  return el("table", {},
+    el("thead", {}, el("tr", {}, ...headings.map((h) => el("th", {}, h)))),
+    el("tbody", {}, ...rows));
+}
+
+function num(n) {
+  return el("td", { class: "num" }, typeof n === "number" ? n.toLocaleString() : n);
+}
+
+function renderStatus(o) {
+  const behind = o.status.days_behind;
+  const rows = [
+    el("tr", {}, el("th", {}, "data through"), el("td", {}, o.status.data_through || "no data")),
+    el("tr", {}, el("th", {}, "days behind"),
+      el("td", { class: behind === 0 ? "ok" : "bad" }, behind)),
+    el("tr", {}, el("th", {}, "ready"),
+      el("td", { class: o.readiness.ready ? "ok" : "bad" }, o.readiness.ready ? "yes" : "loading")),
+  ];
+
+  for (const [index, r] of Object.entries(o.readiness.indexes || {})) {
+    if (!r.ready) {
+      rows.push(el("tr", {}, el("th", {}, index || "default"),
+        el("td", { class: "bad" }, `${r.loaded_days}/${r.total_days} days loaded ${r.error || ""}`)));
+    }
+  }
+
+  return el("table", {}, el("tbody", {}, ...rows));
+}
+
+function renderBackfills(jobs) {
+  return table(["id", "state", "from", "period", "days", "started", "error"],
+    jobs.map((j) => el("tr", {},
+      el("td", {}, j.id),
+      el("td", { class: j.state === "failed" ? "bad" : "" }, j.state),
+      el("td", {}, j.from),
+      el("td", {}, j.period),
+      num(`${j.days_done}/${j.days_total}`),
+      el("td", {}, new Date(j.started).toLocaleString()),
+      el("td", {}, j.error || ""))));
+}
+
+function renderCache(cache) {
+  const rows = [];
+
+  for (const [index, partitions] of Object.entries(cache)) {
+    for (const p of partitions || []) {
+      const lookups = p.hits + p.misses;
+      const ratio = lookups ? `${Math.round((100 * p.hits) / lookups)}%` : "";
+
+      rows.push(el("tr", {}, el("td", {}, index), el("td", {}, p.name),
+        num(`${p.entries}/${p.size}`), num(p.bytes), num(p.hits), num(p.misses), num(ratio), num(p.evictions)));
+    }
+  }
+
+  return table(["index", "cache", "entries", "bytes", "hits", "misses", "hit ratio", "evictions"], rows);
+}
+
+function renderSlow(slow) {
+  return table(["time", "kind", "took ms", "items", "from", "to", "filters"],
+    slow.map((q) => el("tr", {},
+      el("td", {}, new Date(q.time).toLocaleString()),
+      el("td", {}, q.kind),
+      num(Math.round(q.took_ms)),
+      num(q.items),
+      el("td", {}, q.gte),
+      el("td", {}, q.lte || q.lt),
+      el("td", {}, Object.entries(q.filters || {}).map(([k, v]) => `${k}=${v}`).join(" ")))));
+}
+
+function renderCoverage(coverage) {
+  const div = el("div", {});
+
+  for (const [index, days] of Object.entries(coverage)) {
+    const boms = [...new Set(days.flatMap((d) => d.boms.map((b) => (b.cluster ? `${b.cluster}:` : "") + b.bom)))].sort();
+
+    const rows = days.slice().reverse().map((d) => {
+      const hits = new Map(d.boms.map((b) => [(b.cluster ? `${b.cluster}:` : "") + b.bom, b.hits]));
+
+      return el("tr", { class: d.partial ? "partial" : "" },
+        el("td", {}, d.day + (d.partial ? " (partial)" : "")),
+        ...boms.map((b) => {
+          if (!hits.has(b)) {
+            return el("td", { class: "num missing" }, "0");
+          }
+
+          return num(hits.get(b) < 0 ? "not loaded" : hits.get(b));
+        }));
+    });
+
+    div.append(el("h3", {}, index), table(["day", ...boms], rows));
+  }
+
+  return div;
+}
+
+async function refresh() {
+  const days = document.getElementById("days").value;
+  const error = document.getElementById("error");
+
+  try {
+    const resp = await fetch(`api/overview?days=${days}`);
+    if (!resp.ok) {
+      throw new Error(`${resp.status} ${await resp.text()}`);
+    }
+
+    const o = await resp.json();
+
+    document.getElementById("status").replaceChildren(renderStatus(o));
+    document.getElementById("backfills").replaceChildren(renderBackfills(o.backfills));
+    document.getElementById("cache").replaceChildren(renderCache(o.cache));
+    document.getElementById("slow").replaceChildren(renderSlow(o.slow_queries));
+    document.getElementById("coverage").replaceChildren(renderCoverage(o.coverage));
+    document.getElementById("updated").textContent = `updated ${new Date().toLocaleTimeString()}`;
+    error.hidden = true;
+  } catch (err) {
+    error.textContent = `failed to get overview: ${err.message}`;
+    error.hidden = false;
+  }
+}
+
+document.getElementById("days").addEventListener("change", refresh);
+refresh();
+setInterval(refresh, refreshMS);
@@ -0,0 +1,200 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+type coverageDataSource struct {
+	fixedDataSource
+	days []db.DayCoverage
+}
+
+func (c *coverageDataSource) Coverage(ctx context.Context) ([]db.DayCoverage, error) {
+	return c.days, ctx.Err()
+}
+
+func TestDashboard(t *testing.T) {
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		get := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+			return w
+		}
+
+		Convey("the dashboard isn't served unless enabled", func() {
+			So(get("/"+dashboardEndpoint).Code, ShouldEqual, http.StatusNotFound)
+			So(get("/"+dashboardOverviewEndpoint).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("once enabled without auth, it is forbidden", func() {
+			server.EnableDashboard(false)
+
+			So(get("/"+dashboardEndpoint).Code, ShouldEqual, http.StatusForbidden)
+			So(get("/"+dashboardOverviewEndpoint).Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("once enabled with open access", func() {
+			server.EnableDashboard(true)
+
+			yesterday := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+			server.SetDataSource(&coverageDataSource{
+				fixedDataSource: fixedDataSource(yesterday),
+				days: []db.DayCoverage{
+					{Day: "2024-05-01", BOMs: []db.BOMCoverage{{BOM: "bomA", Hits: 3}}},
+					{Day: "2024-05-02", Partial: true, BOMs: []db.BOMCoverage{{BOM: "bomA", Hits: -1}}},
+				},
+			})
+
+			Convey("its static pages are served", func() {
+				w := get("/" + dashboardEndpoint)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldStartWith, "text/html")
+
+				body, errr := io.ReadAll(w.Body)
+				So(errr, ShouldBeNil)
+				So(string(body), ShouldContainSubstring, "dashboard.js")
+
+				w = get("/" + dashboardEndpoint + "dashboard.js")
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldContainSubstring, "api/overview")
+
+				So(get("/"+dashboardEndpoint+"missing.js").Code, ShouldEqual, http.StatusNotFound)
+
+				w = httptest.NewRecorder()
+				server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+dashboardEndpoint, nil))
+				So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			})
+
+			Convey("you can get an overview", func() {
+				w := get("/" + dashboardOverviewEndpoint)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var overview DashboardOverview
+
+				So(json.NewDecoder(w.Body).Decode(&overview), ShouldBeNil)
+				So(overview.Status.DaysBehind, ShouldEqual, 0)
+				So(overview.Readiness.Ready, ShouldBeTrue)
+				So(overview.Coverage[index], ShouldHaveLength, 2)
+				So(overview.Coverage[index][1].Partial, ShouldBeTrue)
+				So(overview.Coverage[index][1].BOMs[0].Hits, ShouldEqual, -1)
+				So(overview.Backfills, ShouldBeEmpty)
+				So(overview.Cache[index], ShouldNotBeEmpty)
+				So(overview.SlowQueries, ShouldNotBeNil)
+
+				w = get("/" + dashboardOverviewEndpoint + "?days=1")
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(json.NewDecoder(w.Body).Decode(&overview), ShouldBeNil)
+				So(overview.Coverage[index], ShouldHaveLength, 1)
+				So(overview.Coverage[index][0].Day, ShouldEqual, "2024-05-02")
+
+				So(get("/"+dashboardOverviewEndpoint+"?days=0").Code, ShouldEqual, http.StatusBadRequest)
+				So(get("/"+dashboardOverviewEndpoint+"?days=x").Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("the overview includes backfills, newest first", func() {
+				done := make(chan bool)
+
+				server.SetBackfiller(func(_ time.Time, _ time.Duration, _ func(int, int)) (*db.BackfillReport, error) {
+					return nil, nil
+				})
+
+				_, ok := server.backfills.start(time.Now(), "1d", func(BackfillJob) { done <- true })
+				So(ok, ShouldBeTrue)
+				<-done
+
+				for ok = false; !ok; {
+					_, ok = server.backfills.start(time.Now(), "2d", func(BackfillJob) { done <- true })
+				}
+
+				<-done
+
+				var overview DashboardOverview
+
+				So(json.NewDecoder(get("/"+dashboardOverviewEndpoint).Body).Decode(&overview), ShouldBeNil)
+				So(overview.Backfills, ShouldHaveLength, 2)
+				So(overview.Backfills[0].Period, ShouldEqual, "2d")
+				So(overview.Backfills[1].Period, ShouldEqual, "1d")
+			})
+
+			Convey("with auth, anyone authenticated can see it", func() {
+				server.SetAuth(Auth{Users: map[string]string{"user": "pass"}})
+
+				req := httptest.NewRequest(http.MethodGet, "/"+dashboardOverviewEndpoint, nil)
+				req.SetBasicAuth("user", "pass")
+
+				w := httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				So(get("/"+dashboardEndpoint).Code, ShouldEqual, http.StatusUnauthorized)
+			})
+
+			Convey("without open access, only admins can see it", func() {
+				server.EnableDashboard(false)
+				server.SetAuth(Auth{
+					Users:  map[string]string{"admin": "secret", "user": "pass"},
+					Admins: []string{"admin"},
+				})
+
+				req := httptest.NewRequest(http.MethodGet, "/"+dashboardOverviewEndpoint, nil)
+				req.SetBasicAuth("user", "pass")
+
+				w := httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, http.StatusForbidden)
+
+				req.SetBasicAuth("admin", "secret")
+
+				w = httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				So(get("/"+dashboardEndpoint).Code, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+	})
+}
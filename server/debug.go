@@ -0,0 +1,111 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof" //nolint:gosec
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	debugPprofPath = "/debug/pprof/"
+	debugVarsPath  = "/debug/vars"
+)
+
+var (
+	processStart       = time.Now() //nolint:gochecknoglobals
+	publishRuntimeOnce sync.Once    //nolint:gochecknoglobals
+)
+
+// DebugHandler returns a http.Handler suitable for serving on a separate admin
+// port, so that profiles can be captured from a live server on demand.
+//
+// It serves net/http/pprof's profiles under "/debug/pprof/" (eg. GET
+// "/debug/pprof/profile?seconds=30" for a CPU profile, or
+// "/debug/pprof/heap"), and expvar's runtime stats (memstats, plus goroutines,
+// num_cpu and uptime_seconds) as JSON at "/debug/vars".
+//
+// If you SetAuth(), these need the credentials of one of its Admins or
+// AdminTokens.
+func (s *Server) DebugHandler() http.Handler {
+	publishRuntimeOnce.Do(publishRuntimeVars)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPprofPath, pprof.Index)
+	mux.HandleFunc(debugPprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(debugPprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(debugPprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(debugPprofPath+"trace", pprof.Trace)
+	mux.Handle(debugVarsPath, expvar.Handler())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.checkDebugAuth(w, r) {
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// publishRuntimeVars adds runtime stats to those expvar publishes by default.
+func publishRuntimeVars() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("num_cpu", expvar.Func(func() interface{} {
+		return runtime.NumCPU()
+	}))
+	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} {
+		return time.Since(processStart).Seconds()
+	}))
+}
+
+// checkDebugAuth returns true if we have no Auth, or the request has the
+// credentials of an admin. Otherwise responds with a 401 or 403 status and
+// returns false.
+func (s *Server) checkDebugAuth(w http.ResponseWriter, r *http.Request) bool {
+	if s.auth == nil || !s.auth.enabled() {
+		return true
+	}
+
+	identity, ok := s.checkAuth(w, r)
+	if !ok {
+		return false
+	}
+
+	if !s.auth.isAdmin(identity) {
+		w.WriteHeader(http.StatusForbidden)
+		sendMessageToClient(w, msgAdminOnly)
+
+		return false
+	}
+
+	return true
+}
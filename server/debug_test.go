@@ -0,0 +1,108 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+)
+
+func TestDebugHandler(t *testing.T) {
+	Convey("Given a server's DebugHandler", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 2)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		handler := server.DebugHandler()
+
+		serve := func(req *http.Request) *http.Response {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		Convey("You can get pprof profiles", func() {
+			resp := serve(httptest.NewRequest(http.MethodGet, debugPprofPath, nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			body, errr := io.ReadAll(resp.Body)
+			So(errr, ShouldBeNil)
+			So(string(body), ShouldContainSubstring, "goroutine")
+
+			resp = serve(httptest.NewRequest(http.MethodGet, debugPprofPath+"heap", nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("You can get runtime stats", func() {
+			resp := serve(httptest.NewRequest(http.MethodGet, debugVarsPath, nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			var vars map[string]interface{}
+
+			err = json.NewDecoder(resp.Body).Decode(&vars)
+			So(err, ShouldBeNil)
+			So(vars["memstats"], ShouldNotBeNil)
+			So(vars["goroutines"], ShouldBeGreaterThan, 0)
+			So(vars["num_cpu"], ShouldBeGreaterThan, 0)
+			So(vars["uptime_seconds"], ShouldBeGreaterThan, 0)
+		})
+
+		Convey("Calling DebugHandler again doesn't republish runtime stats", func() {
+			So(func() { server.DebugHandler() }, ShouldNotPanic)
+		})
+
+		Convey("With auth, only admins can use it", func() {
+			server.SetAuth(Auth{
+				Users:  map[string]string{"admin": "secret", "user": "pass"},
+				Admins: []string{"admin"},
+			})
+
+			req := httptest.NewRequest(http.MethodGet, debugVarsPath, nil)
+			So(serve(req).StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			req.SetBasicAuth("user", "pass")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusForbidden)
+
+			req.SetBasicAuth("admin", "secret")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("It doesn't serve normal requests", func() {
+			resp := serve(mock.AggQuery())
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,114 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+const msgDraining = "server is shutting down"
+
+// inFlight counts the requests we're handling, so that Drain() can wait for
+// them to finish. The zero value is ready to use.
+type inFlight struct {
+	mu       sync.Mutex
+	draining bool
+	wg       sync.WaitGroup
+}
+
+// begin records the start of a request, returning false if we're draining, in
+// which case the request must not be handled. Otherwise you must call
+// wg.Done() once the request has been handled.
+func (f *inFlight) begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.draining {
+		return false
+	}
+
+	f.wg.Add(1)
+
+	return true
+}
+
+// drain stops begin() succeeding, and returns a channel that is closed once
+// no requests are being handled.
+func (f *inFlight) drain() <-chan struct{} {
+	f.mu.Lock()
+	f.draining = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	return done
+}
+
+// Drain makes us respond to new requests with a 503 status, and waits until
+// requests we're already handling have finished, or the context is done. It
+// then closes any scrolls being paged (see SetScrollPaging()), calling the
+// SearchScroller's Done() on their results.
+//
+// Returns the context's error if requests were still being handled, in which
+// case paged scrolls are left open.
+//
+// Call this when shutting down, after you have stopped accepting new
+// connections, but before closing the SearchScroller's database.
+func (s *Server) Drain(ctx context.Context) error {
+	select {
+	case <-s.inFlight.drain():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if s.scrolls != nil {
+		s.scrolls.closeAll()
+	}
+
+	return nil
+}
+
+// checkNotDraining returns true if Drain() hasn't been called, in which case
+// you must call s.inFlight.wg.Done() once you've handled the request.
+// Otherwise responds with a 503 status and returns false.
+func (s *Server) checkNotDraining(w http.ResponseWriter) bool {
+	if s.inFlight.begin() {
+		return true
+	}
+
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	sendMessageToClient(w, msgDraining)
+
+	return false
+}
@@ -0,0 +1,108 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestDrain(t *testing.T) {
+	Convey("Database drain errors get a 503", t, func() {
+		So(errorStatus(db.Error{Msg: db.ErrDraining}), ShouldEqual, http.StatusServiceUnavailable)
+	})
+
+	Convey("Given a server with an open paged scroll", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		sc := &doneCountingScroller{SearchScroller: cq}
+		server := New(sc, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		server.SetScrollPaging(true)
+
+		serve := func(req *http.Request) *http.Response {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		req, _ := mock.ScrollQuery("?scroll=1m")
+		So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+		So(sc.done.Load(), ShouldEqual, 0)
+
+		Convey("Drain waits for in-flight requests, then closes paged scrolls", func() {
+			So(server.inFlight.begin(), ShouldBeTrue)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			err = server.Drain(ctx)
+			So(err, ShouldEqual, context.DeadlineExceeded)
+			So(sc.done.Load(), ShouldEqual, 0)
+
+			resp := serve(mock.AggQuery())
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(resp.Header.Get("Connection"), ShouldEqual, "close")
+
+			body, errr := io.ReadAll(resp.Body)
+			So(errr, ShouldBeNil)
+			So(string(body), ShouldContainSubstring, msgDraining)
+
+			go func() {
+				<-time.After(10 * time.Millisecond)
+				server.inFlight.wg.Done()
+			}()
+
+			err = server.Drain(context.Background())
+			So(err, ShouldBeNil)
+			So(sc.done.Load(), ShouldEqual, 1)
+
+			req, _ = mock.ScrollQuery("?scroll=1m")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+		})
+
+		Convey("Drain returns right away with nothing in flight", func() {
+			So(server.Drain(context.Background()), ShouldBeNil)
+			So(sc.done.Load(), ShouldEqual, 1)
+
+			resp := serve(httptest.NewRequest(http.MethodGet, "/"+es.SearchPage, nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+}
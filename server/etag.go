@@ -0,0 +1,107 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const etagHashBytes = 16
+
+// etag returns a weak ETag for a request with the given parts (eg. its URL and
+// query Key()), combined with the DataVersion() of the DataSource for the
+// request's index, or "" if we don't know that data version. The ETag is weak
+// so that it applies to both the gzip compressed and uncompressed forms of a
+// response.
+func (s *Server) etag(r *http.Request, parts ...string) string {
+	ds := s.farmOf(r).dataSource
+	if ds == nil {
+		return ""
+	}
+
+	version := ds.DataVersion()
+	if version == "" {
+		return ""
+	}
+
+	h := sha256.New()
+
+	for _, part := range append(parts, version) {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:etagHashBytes]) + `"`
+}
+
+// notModified sets an ETag header for a request with the given parts (see
+// etag()). If the request's If-None-Match header matches it, also responds with
+// a 304 status and returns true, so that the request doesn't need to be
+// answered. Requests that ask for cached answers to be refreshed (see
+// es.WantsRefresh()) are always answered.
+func (s *Server) notModified(w http.ResponseWriter, r *http.Request, parts ...string) bool {
+	etag := s.etag(r, append([]string{r.URL.Path, r.URL.RawQuery}, parts...)...)
+	if etag == "" {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+
+	if es.WantsRefresh(r.Header) || !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	s.setDataThroughHeader(w, r)
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// etagMatches returns true if the given If-None-Match header value matches
+// the given ETag, using weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	opaque := strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+
+	return false
+}
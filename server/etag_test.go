@@ -0,0 +1,215 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+type versionedDataSource struct {
+	version string
+}
+
+func (v *versionedDataSource) DataThrough() time.Time {
+	return time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+}
+
+func (v *versionedDataSource) DataVersion() string {
+	return v.version
+}
+
+func TestETag(t *testing.T) {
+	Convey("etagMatches() understands If-None-Match headers", t, func() {
+		etag := `W/"abc"`
+
+		for header, expected := range map[string]bool{
+			"":                 false,
+			`W/"abc"`:          true,
+			`"abc"`:            true,
+			`"def", W/"abc"`:   true,
+			`"def"`:            false,
+			"*":                true,
+			`W/"abcd", "ab"`:   false,
+			` W/"abc" `:        true,
+			`W/"def",W/"abc",`: true,
+		} {
+			So(etagMatches(header, etag), ShouldEqual, expected)
+		}
+	})
+
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		scroll := func(ifNoneMatch string) *http.Response {
+			req, _ := mock.ScrollQuery("?scroll=1m")
+			if ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", ifNoneMatch)
+			}
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		Convey("without a DataSource, responses have no ETag", func() {
+			resp := scroll("*")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("ETag"), ShouldBeEmpty)
+		})
+
+		Convey("with a DataSource that doesn't know its version, responses have no ETag", func() {
+			server.SetDataSource(&versionedDataSource{})
+
+			So(scroll("").Header.Get("ETag"), ShouldBeEmpty)
+		})
+
+		Convey("with a versioned DataSource", func() {
+			ds := &versionedDataSource{version: "1"}
+			server.SetDataSource(ds)
+
+			resp := scroll("")
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			etag := resp.Header.Get("ETag")
+			So(etag, ShouldStartWith, `W/"`)
+			So(scroll("").Header.Get("ETag"), ShouldEqual, etag)
+
+			Convey("a conditional request with a matching ETag gets a 304", func() {
+				resp = scroll(etag)
+				So(resp.StatusCode, ShouldEqual, http.StatusNotModified)
+				So(resp.Header.Get("ETag"), ShouldEqual, etag)
+				So(resp.Header.Get(dataThroughHeader), ShouldNotBeEmpty)
+				So(resp.ContentLength, ShouldBeLessThanOrEqualTo, 0)
+
+				resp = scroll(`"other", ` + strings.TrimPrefix(etag, "W/"))
+				So(resp.StatusCode, ShouldEqual, http.StatusNotModified)
+			})
+
+			Convey("a matching ETag gets the full response if a refresh is wanted", func() {
+				for _, header := range [][2]string{{"Cache-Control", "no-cache"}, {es.RefreshHeader, "true"}} {
+					req, _ := mock.ScrollQuery("?scroll=1m")
+					req.Header.Set("If-None-Match", etag)
+					req.Header.Set(header[0], header[1])
+					w := httptest.NewRecorder()
+					server.ServeHTTP(w, req)
+					So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+					So(w.Result().Header.Get("ETag"), ShouldEqual, etag)
+				}
+			})
+
+			Convey("a non-matching ETag gets the full response", func() {
+				So(scroll(`W/"other"`).StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("the ETag changes when the data does", func() {
+				ds.version = "2"
+
+				resp = scroll(etag)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("ETag"), ShouldNotEqual, etag)
+			})
+
+			Convey("different queries have different ETags", func() {
+				req, _ := mock.ScrollQuery("?scroll=1m&columns=USER_NAME")
+				w := httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Result().Header.Get("ETag"), ShouldNotEqual, etag)
+
+				req, _ = mock.ScrollQuery("")
+				req.URL.Path = strings.Replace(req.URL.Path, es.SearchPage, es.CountPage, 1)
+				req.Header.Set("If-None-Match", etag)
+				w = httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				countETag := w.Result().Header.Get("ETag")
+				So(countETag, ShouldNotBeEmpty)
+				So(countETag, ShouldNotEqual, etag)
+
+				req, _ = mock.ScrollQuery("")
+				req.URL.Path = strings.Replace(req.URL.Path, es.SearchPage, es.CountPage, 1)
+				req.Header.Set("If-None-Match", countETag)
+				w = httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Result().StatusCode, ShouldEqual, http.StatusNotModified)
+			})
+
+			Convey("exports and distinct value requests get their own ETags", func() {
+				etags := map[string]bool{etag: true}
+
+				for _, endpoint := range []string{exportEndpoint, getUsernamesEndpoint, getBOMsEndpoint} {
+					req, _ := mock.ScrollQuery("?scroll=1m")
+					req.URL.Path = slash + endpoint
+					w := httptest.NewRecorder()
+					server.ServeHTTP(w, req)
+					So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+					endpointETag := w.Result().Header.Get("ETag")
+					So(endpointETag, ShouldNotBeEmpty)
+					So(etags[endpointETag], ShouldBeFalse)
+					etags[endpointETag] = true
+
+					req, _ = mock.ScrollQuery("?scroll=1m")
+					req.URL.Path = slash + endpoint
+					req.Header.Set("If-None-Match", endpointETag)
+					w = httptest.NewRecorder()
+					server.ServeHTTP(w, req)
+					So(w.Result().StatusCode, ShouldEqual, http.StatusNotModified)
+				}
+			})
+
+			Convey("error responses have no ETag", func() {
+				req, _ := mock.ScrollQuery("?scroll=1m&columns=foo")
+				req.URL.Path = slash + exportEndpoint
+				w := httptest.NewRecorder()
+				server.ServeHTTP(w, req)
+				So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+				So(w.Result().Header.Get("ETag"), ShouldBeEmpty)
+			})
+
+			Convey("non-scroll searches, which aren't answered locally, have no ETag", func() {
+				w := httptest.NewRecorder()
+				server.ServeHTTP(w, mock.AggQuery())
+				So(w.Result().Header.Get("ETag"), ShouldBeEmpty)
+			})
+		})
+	})
+}
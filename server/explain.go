@@ -0,0 +1,72 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const explainPlanParam = "explain_plan"
+
+// Explainer types can describe the work they would do to answer a scroll
+// query, without doing it. A db.DB is one.
+type Explainer interface {
+	Explain(query *es.Query) (*db.QueryPlan, error)
+}
+
+// wantsExplainPlan returns true if the request has a true explain_plan
+// parameter.
+func wantsExplainPlan(r *http.Request) bool {
+	explain, err := strconv.ParseBool(r.URL.Query().Get(explainPlanParam))
+
+	return err == nil && explain
+}
+
+// explainPlan responds to an /index/_search?explain_plan=true request with the
+// db.QueryPlan of its query as JSON, if the DataSource of the request's index
+// is an Explainer. Otherwise responds with a 501 status.
+func (s *Server) explainPlan(w http.ResponseWriter, r *http.Request, query *es.Query) {
+	explainer, ok := s.farmOf(r).dataSource.(Explainer)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+
+		return
+	}
+
+	plan, err := explainer.Explain(query)
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	s.setDataThroughHeader(w, r)
+	sendJSONToClient(w, http.StatusOK, plan)
+}
@@ -0,0 +1,99 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestExplainPlan(t *testing.T) {
+	query := `{"size":10000,"query":{"bool":{"filter":[` +
+		`{"match_phrase":{"META_CLUSTER_NAME":"farm"}},` +
+		`{"range":{"timestamp":{"lt":"2024-06-01T00:00:00Z","gte":"2024-05-30T00:00:00Z",` +
+		`"format":"strict_date_optional_time"}}}%s]}}}`
+	bomQuery := strings.Replace(query, "%s", `,{"match_phrase":{"BOM":"Human Genetics"}}`, 1)
+	searchPath := "/some-indexes-%2A/" + es.SearchPage + "?scroll=1m&" + explainPlanParam + "=true"
+
+	Convey("Given an EmbeddedServer", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		ts, err := NewTestServer("some-indexes-*", t.TempDir())
+		So(err, ShouldBeNil)
+
+		defer ts.Close()
+
+		Convey("searches with explain_plan get the query's plan instead of hits", func() {
+			resp, errp := http.Post(ts.URL+searchPath, "application/json", strings.NewReader(bomQuery)) //nolint:noctx
+			So(errp, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get(dataThroughHeader), ShouldNotBeBlank)
+
+			defer resp.Body.Close()
+
+			plan := &db.QueryPlan{}
+			So(json.NewDecoder(resp.Body).Decode(plan), ShouldBeNil)
+			So(plan.BOM, ShouldEqual, "Human Genetics")
+			So(plan.Hits, ShouldEqual, 2)
+			So(plan.Dirs, ShouldNotBeEmpty)
+			So(plan.EstimatedBytes, ShouldBeGreaterThan, 0)
+			So(plan.IndexOnly, ShouldBeTrue)
+		})
+
+		Convey("invalid queries get an error", func() {
+			noBOMQuery := strings.Replace(query, "%s", "", 1)
+
+			resp, errp := http.Post(ts.URL+searchPath, "application/json", strings.NewReader(noBOMQuery)) //nolint:noctx
+			So(errp, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+			resp.Body.Close()
+		})
+	})
+
+	Convey("Servers whose DataSource isn't an Explainer don't support explain_plan", t, func() {
+		mock := newMockScroller("some-indexes-*")
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, "some-indexes-*", &url.URL{Host: "localhost:0", Scheme: "http"})
+		server.SetDataSource(fixedDataSource(time.Now()))
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, searchPath, strings.NewReader(bomQuery)))
+		So(w.Code, ShouldEqual, http.StatusNotImplemented)
+	})
+}
@@ -0,0 +1,192 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// farm is what we use to answer queries against one index: a SearchScroller,
+// and optionally a DataSource saying how up to date it is.
+type farm struct {
+	index      string
+	sc         atomic.Pointer[SearchScroller]
+	dataSource DataSource
+}
+
+func newFarm(index string, sc SearchScroller) *farm {
+	f := &farm{index: index}
+	f.setSearchScroller(sc)
+
+	return f
+}
+
+func (f *farm) setSearchScroller(sc SearchScroller) {
+	f.sc.Store(&sc)
+}
+
+// searchScroller returns our current SearchScroller. Use the same one for
+// both getting and Done()ing a result.
+func (f *farm) searchScroller() SearchScroller {
+	return *f.sc.Load()
+}
+
+type farmContextKey struct{}
+
+// withFarm returns a handler that calls the given one with a request whose
+// context says to answer it using the given farm.
+func withFarm(f *farm, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), farmContextKey{}, f)))
+	}
+}
+
+// farmOf returns the farm that withFarm() said to answer the request with, or
+// our default farm.
+func (s *Server) farmOf(r *http.Request) *farm {
+	if f, ok := r.Context().Value(farmContextKey{}).(*farm); ok {
+		return f
+	}
+
+	return s.farm
+}
+
+// handleIndex makes our mux answer search, count, _msearch, _field_caps and
+// _mapping requests for the given index using the given farm.
+func (s *Server) handleIndex(mux *http.ServeMux, index string, f *farm) {
+	prefix := slash + url.QueryEscape(index) + slash
+
+	mux.HandleFunc(prefix+es.SearchPage, withFarm(f, s.search))
+	mux.HandleFunc(prefix+es.CountPage, withFarm(f, s.count))
+	mux.HandleFunc(prefix+es.MultiSearchPage, withFarm(f, s.multiSearch(index)))
+	mux.HandleFunc(prefix+es.FieldCapsPage, fieldCapsHandler(index))
+	mux.HandleFunc(prefix+es.MappingPage, mappingHandler(index))
+}
+
+// AddIndex makes us also answer search, count, _msearch, _field_caps and
+// _mapping requests for the given index (eg. that of another farm), using the
+// given SearchScroller instead of the one we were made with. The given
+// DataSource, if not nil, is used for the X-Farmer-Data-Through header and
+// ETags of those requests, like SetDataSource(); if it is also a Reloader, it
+// will be reloaded by "/admin/reload".
+//
+// The cache of the SearchScroller is flushed by "/admin/flush-cache", its
+// SlowQueries() are included in "/admin/slow-queries", and its cache Stats()
+// in "/admin/cache". Other endpoints, like
+// "/get_usernames", "/export" and "/status", only use the SearchScroller we
+// were made with.
+//
+// Call this before serving any requests.
+func (s *Server) AddIndex(index string, sc SearchScroller, ds DataSource) {
+	f := newFarm(index, sc)
+	f.dataSource = ds
+
+	s.farms[index] = f
+
+	s.handleIndex(s.routes, index, f)
+}
+
+// SetIndexSearchScroller is like SetSearchScroller(), but replaces the
+// SearchScroller of an index you AddIndex()ed. Does nothing for other indexes.
+func (s *Server) SetIndexSearchScroller(index string, sc SearchScroller) {
+	if f, ok := s.farms[index]; ok {
+		f.setSearchScroller(sc)
+	}
+}
+
+// allFarms returns our default farm followed by those of AddIndex()ed indexes.
+func (s *Server) allFarms() []*farm {
+	farms := []*farm{s.farm}
+
+	indexes := make([]string, 0, len(s.farms))
+	for index := range s.farms {
+		indexes = append(indexes, index)
+	}
+
+	sort.Strings(indexes)
+
+	for _, index := range indexes {
+		farms = append(farms, s.farms[index])
+	}
+
+	return farms
+}
+
+// flushAll empties the caches of all our farms' SearchScrollers, returning how
+// many results were in them.
+func (s *Server) flushAll() int {
+	flushed := 0
+
+	for _, f := range s.allFarms() {
+		flushed += f.searchScroller().Flush()
+	}
+
+	return flushed
+}
+
+// cacheStats returns the cache Stats() of all our farms' SearchScrollers, keyed
+// by index.
+func (s *Server) cacheStats() map[string]cache.Stats {
+	stats := make(map[string]cache.Stats)
+
+	for _, f := range s.allFarms() {
+		stats[f.index] = f.searchScroller().Stats()
+	}
+
+	return stats
+}
+
+// slowQueries returns the SlowQueries() of all our farms' SearchScrollers,
+// newest first.
+func (s *Server) slowQueries() []es.SlowQuery {
+	farms := s.allFarms()
+	if len(farms) == 1 {
+		return s.farm.searchScroller().SlowQueries()
+	}
+
+	var slow []es.SlowQuery
+
+	for _, f := range farms {
+		slow = append(slow, f.searchScroller().SlowQueries()...)
+	}
+
+	sort.SliceStable(slow, func(i, j int) bool {
+		return slow[i].Time.After(slow[j].Time)
+	})
+
+	if slow == nil {
+		slow = []es.SlowQuery{}
+	}
+
+	return slow
+}
@@ -0,0 +1,97 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+)
+
+func TestAddIndex(t *testing.T) {
+	Convey("Given a server with an additional index", t, func() {
+		indexA := "farm-a-*"
+		mockA := newMockScroller(indexA)
+		cqA, err := cache.New(mockA, mockA, 1)
+		So(err, ShouldBeNil)
+
+		indexB := "farm-b-*"
+		mockB := newMockScroller(indexB)
+		cqB, err := cache.New(mockB, mockB, 1)
+		So(err, ShouldBeNil)
+
+		throughA := time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC)
+		throughB := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+		server := New(cqA, indexA, &url.URL{Host: "localhost:0", Scheme: "http"})
+		server.SetDataSource(fixedDataSource(throughA))
+		server.AddIndex(indexB, cqB, fixedDataSource(throughB))
+
+		Convey("queries of each index are answered by that index's SearchScroller and DataSource", func() {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, mockA.AggQuery())
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(w.Result().Header.Get(dataThroughHeader), ShouldEqual, throughA.Format(time.DateOnly))
+
+			w = httptest.NewRecorder()
+			server.ServeHTTP(w, mockB.AggQuery())
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(w.Result().Header.Get(dataThroughHeader), ShouldEqual, throughB.Format(time.DateOnly))
+
+			So(server.flushAll(), ShouldEqual, 2)
+			So(server.flushAll(), ShouldEqual, 0)
+
+			Convey("and you can replace the additional index's SearchScroller", func() {
+				cqB2, errc := cache.New(mockB, mockB, 1)
+				So(errc, ShouldBeNil)
+
+				server.SetIndexSearchScroller(indexB, cqB2)
+
+				w = httptest.NewRecorder()
+				server.ServeHTTP(w, mockB.AggQuery())
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(cqB.Flush(), ShouldEqual, 0)
+				So(cqB2.Flush(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("slow queries of all indexes are included", func() {
+			So(server.slowQueries(), ShouldNotBeNil)
+			So(server.slowQueries(), ShouldBeEmpty)
+		})
+
+		Convey("unknown indexes are not answered locally", func() {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, newMockScroller("farm-c-*").AggQuery())
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadGateway)
+		})
+	})
+}
@@ -0,0 +1,113 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+type mockGPUReporter struct {
+	bom         string
+	first, last time.Time
+}
+
+func (m *mockGPUReporter) GPUReport(_ context.Context, _, bom string, first, last time.Time) (*db.GPUReport, error) {
+	m.bom, m.first, m.last = bom, first, last
+
+	if last.Before(first) {
+		return nil, db.Error{Msg: db.ErrGPUReportRange}
+	}
+
+	return &db.GPUReport{
+		BOM:      bom,
+		From:     first.Format(time.DateOnly),
+		To:       last.Format(time.DateOnly),
+		Days:     []*db.GPUDay{{Day: first.Format(time.DateOnly), GPUUsage: db.GPUUsage{Jobs: 3, Users: 2}}},
+		Total:    db.GPUUsage{Jobs: 3, Users: 2},
+		UserJobs: map[string]int64{"a": 2, "b": 1},
+	}, nil
+}
+
+func TestGPUReport(t *testing.T) {
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		get := func(params string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+gpuReportEndpoint+params, nil))
+
+			return w
+		}
+
+		Convey("without a GPUReporter, GPU reports aren't implemented", func() {
+			So(get("?from=2024-05-01").Code, ShouldEqual, http.StatusNotImplemented)
+		})
+
+		Convey("with a GPUReporter, you can get GPU reports", func() {
+			gr := &mockGPUReporter{}
+			server.SetGPUReporter(gr)
+
+			w := get("?from=2024-05-01&to=2024-05-03")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(gr.bom, ShouldEqual, "")
+			So(gr.first, ShouldEqual, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+			So(gr.last, ShouldEqual, time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC))
+
+			var report db.GPUReport
+
+			So(json.NewDecoder(w.Body).Decode(&report), ShouldBeNil)
+			So(report.Days, ShouldHaveLength, 1)
+			So(report.Days[0].Jobs, ShouldEqual, 3)
+			So(report.Total.Users, ShouldEqual, 2)
+			So(report.UserJobs, ShouldResemble, map[string]int64{"a": 2, "b": 1})
+
+			So(get("?from=2024-05-01&bom=bomA").Code, ShouldEqual, http.StatusOK)
+			So(gr.bom, ShouldEqual, "bomA")
+			So(gr.last, ShouldEqual, gr.first)
+
+			So(get("").Code, ShouldEqual, http.StatusBadRequest)
+			So(get("?from=2024-05-03&to=2024-05-01").Code, ShouldEqual, http.StatusBadRequest)
+
+			w = httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+gpuReportEndpoint, nil))
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
@@ -0,0 +1,602 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/farmerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// grpcBatchHits is the maximum number of hits in each HitBatch streamed by
+	// the gRPC Scroll method.
+	grpcBatchHits = 1000
+
+	grpcAuthMetadata       = "authorization"
+	grpcRetryAfterMetadata = "retry-after"
+	grpcLogMethod          = "GRPC"
+	usernameField          = "USER_NAME"
+)
+
+// GRPCServer returns a grpc.Server that offers the farmerpb.Farmer service,
+// answering Search, Scroll and Usernames requests using the same
+// SearchScrollers (and indexes, see AddIndex()) as our HTTP endpoints. You
+// must Serve() it on a listener of your own.
+//
+// If you SetAuth(), calls need the same credentials as our HTTP endpoints,
+// supplied as "authorization" metadata in the form of an Authorization header,
+// eg. "Bearer <token>". Our SetTimeout() applies to each call, and once you
+// Drain() us, new calls fail with an Unavailable code. Our SetRateLimit()
+// applies as well, with calls over the limit failing with a ResourceExhausted
+// code and "retry-after" header metadata. Calls are also logged to our
+// SetAccessLog() and recorded by our SetQueryRecorder().
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(s.grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(s.grpcStreamInterceptor),
+	)
+
+	gs := grpc.NewServer(opts...)
+	farmerpb.RegisterFarmerServer(gs, &grpcFarmer{s: s})
+
+	return gs
+}
+
+func (s *Server) grpcUnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var resp interface{}
+
+	err := s.grpcServe(ctx, info.FullMethod, func(ctx context.Context) (int64, error) {
+		var err error
+
+		resp, err = handler(ctx, req)
+
+		return protoSize(resp), err
+	})
+
+	return resp, err
+}
+
+func (s *Server) grpcStreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return s.grpcServe(ss.Context(), info.FullMethod, func(ctx context.Context) (int64, error) {
+		cs := &grpcCountingStream{ServerStream: ss, ctx: ctx}
+
+		err := handler(srv, cs)
+
+		return cs.bytes, err
+	})
+}
+
+// grpcServe calls the given handle function, which should return the size of
+// its response, if the call is authorised, we're not draining and the client
+// is within our RateLimit. Then, like serveHTTPWithAccessLog(), logs an access
+// log record for the call and records its queries.
+func (s *Server) grpcServe(ctx context.Context, method string, handle func(context.Context) (int64, error)) error {
+	start := time.Now()
+	entry := &accessLogEntry{}
+	ctx = context.WithValue(ctx, accessLogKey{}, entry)
+
+	bytes, err := s.grpcHandle(ctx, entry, handle)
+
+	took := time.Since(start)
+	httpStatus := grpcHTTPStatus(status.Code(err))
+
+	if s.queryRecorder != nil {
+		s.recordQueries(grpcKind(method), entry, start, took, httpStatus, bytes)
+	}
+
+	s.logAccess(ctx, grpcLogMethod, method, httpStatus, bytes, took, entry)
+
+	return err
+}
+
+// grpcHandle does the checks of grpcServe() before calling handle.
+func (s *Server) grpcHandle(ctx context.Context, entry *accessLogEntry,
+	handle func(context.Context) (int64, error)) (int64, error) {
+	identity, err := s.grpcBegin(ctx)
+	entry.identity = identity
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer s.inFlight.wg.Done()
+
+	release, err := s.grpcRateLimit(ctx, identity)
+	if err != nil {
+		return 0, err
+	}
+
+	defer release()
+
+	return handle(ctx)
+}
+
+// grpcBegin is like checkAuth() followed by checkNotDraining() for gRPC calls,
+// returning the identity of the call's credentials (or "" if we have no Auth),
+// and an Unauthenticated or Unavailable status error if the call must not be
+// handled. Otherwise you must call s.inFlight.wg.Done() once you've handled
+// the call.
+func (s *Server) grpcBegin(ctx context.Context) (string, error) {
+	var identity string
+
+	if s.auth != nil && s.auth.enabled() {
+		r := &http.Request{Header: http.Header{}}
+
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, v := range md.Get(grpcAuthMetadata) {
+				r.Header.Add(authHeader, v)
+			}
+		}
+
+		if identity, _ = s.auth.authorize(r); identity == "" {
+			return "", status.Error(codes.Unauthenticated, "invalid or missing credentials")
+		}
+	}
+
+	if !s.inFlight.begin() {
+		return identity, status.Error(codes.Unavailable, msgDraining)
+	}
+
+	return identity, nil
+}
+
+// grpcRateLimit is like checkRateLimit() for gRPC calls, using the IP address
+// of the call's peer if identity is "". If the client is over our RateLimit,
+// sets "retry-after" header metadata and returns a ResourceExhausted status
+// error.
+func (s *Server) grpcRateLimit(ctx context.Context, identity string) (func(), error) {
+	if s.limiter == nil {
+		return func() {}, nil
+	}
+
+	client := identity
+	if client == "" {
+		client = grpcClientIP(ctx)
+	}
+
+	ok, retryAfter := s.limiter.acquire(client, time.Now())
+	if ok {
+		return func() { s.limiter.release(client) }, nil
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(grpcRetryAfterMetadata, retryAfterSeconds(retryAfter))); err != nil {
+		slog.Error("setting gRPC header failed", "err", err)
+	}
+
+	return nil, status.Error(codes.ResourceExhausted, http.StatusText(http.StatusTooManyRequests))
+}
+
+// grpcClientIP returns the IP address of the given call's peer, or "" if
+// unknown.
+func grpcClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	return hostOfAddr(p.Addr.String())
+}
+
+// grpcKind returns the analytics.Record Kind of calls to the given full method
+// name, eg. "search" for "/farmer.Farmer/Search".
+func grpcKind(method string) string {
+	return strings.ToLower(path.Base(method))
+}
+
+// grpcHTTPStatus returns the http status equivalent to the given code, for our
+// access log and QueryRecorder.
+func grpcHTTPStatus(code codes.Code) int {
+	switch code { //nolint:exhaustive
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// protoSize returns the encoded size of the given response, if it's a proto
+// message.
+func protoSize(resp interface{}) int64 {
+	if m, ok := resp.(proto.Message); ok {
+		return int64(proto.Size(m))
+	}
+
+	return 0
+}
+
+// grpcCountingStream is a grpc.ServerStream that has our context and counts
+// the bytes of the messages sent.
+type grpcCountingStream struct {
+	grpc.ServerStream
+	ctx   context.Context //nolint:containedctx
+	bytes int64
+}
+
+// Context returns our context.
+func (c *grpcCountingStream) Context() context.Context {
+	return c.ctx
+}
+
+// SendMsg counts the size of the message before sending it.
+func (c *grpcCountingStream) SendMsg(m interface{}) error {
+	c.bytes += protoSize(m)
+
+	return c.ServerStream.SendMsg(m)
+}
+
+// grpcFarmer implements farmerpb.FarmerServer for a Server.
+type grpcFarmer struct {
+	farmerpb.UnimplementedFarmerServer
+	s *Server
+}
+
+// Search answers the query with its farm's SearchScroller's Search().
+func (g *grpcFarmer) Search(ctx context.Context, req *farmerpb.QueryRequest) (*farmerpb.SearchResponse, error) {
+	f, query, cancel, err := g.s.grpcQuery(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cancel()
+
+	jsonResult, err := f.searchScroller().Search(query)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	result, err := decodeResult(jsonResult)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return searchResponse(result)
+}
+
+// decodeResult decodes the given, possibly gzip compressed, JSON of a Result.
+func decodeResult(jsonResult []byte) (*es.Result, error) {
+	plain, err := cache.Plain(jsonResult)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &es.Result{}
+
+	err = json.Unmarshal(plain, result)
+
+	return result, err
+}
+
+// searchResponse converts the given Result to a SearchResponse.
+func searchResponse(result *es.Result) (*farmerpb.SearchResponse, error) {
+	resp := &farmerpb.SearchResponse{}
+
+	if result.HitSet != nil {
+		resp.Total = int64(result.HitSet.Total.Value)
+		resp.TotalIsLowerBound = result.HitSet.Total.IsLowerBound()
+		resp.Hits = hitsToProto(result.HitSet.Hits)
+	}
+
+	if result.Aggregations != nil {
+		aggs, err := json.Marshal(result.Aggregations)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+
+		resp.Aggregations = aggs
+	}
+
+	return resp, nil
+}
+
+// Scroll streams all the hits matching the query, got with its farm's
+// SearchScroller's ScrollResult(), in batches of up to grpcBatchHits.
+func (g *grpcFarmer) Scroll(req *farmerpb.QueryRequest, stream farmerpb.Farmer_ScrollServer) error {
+	f, query, cancel, err := g.s.grpcQuery(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	defer cancel()
+
+	query.Size = es.MaxSize
+	sc := f.searchScroller()
+
+	result, err := sc.ScrollResult(query)
+	if err != nil {
+		return grpcError(err)
+	}
+
+	defer sc.Done(result.PoolKey)
+
+	if result.HitSet == nil {
+		return nil
+	}
+
+	hits := result.HitSet.Hits
+
+	for start := 0; start < len(hits); start += grpcBatchHits {
+		end := min(start+grpcBatchHits, len(hits))
+
+		if err = stream.Send(&farmerpb.HitBatch{Hits: hitsToProto(hits[start:end])}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Usernames returns the unique USER_NAMEs of the hits matching the query, got
+// with its farm's SearchScroller's DistinctValues().
+func (g *grpcFarmer) Usernames(ctx context.Context, req *farmerpb.QueryRequest) (*farmerpb.UsernamesResponse, error) {
+	f, query, cancel, err := g.s.grpcQuery(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cancel()
+
+	jsonStrs, err := f.searchScroller().DistinctValues(query, usernameField)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	plain, err := cache.Plain(jsonStrs)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &farmerpb.UsernamesResponse{}
+
+	if err = json.Unmarshal(plain, &resp.Usernames); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return resp, nil
+}
+
+// grpcQuery returns the farm of the request's index, and the es.Query its
+// filter or body describes, using a context that has our timeout, which you
+// must cancel once done. Returns a NotFound or InvalidArgument status error if
+// the request isn't valid.
+func (s *Server) grpcQuery(ctx context.Context, req *farmerpb.QueryRequest) (*farm, *es.Query, context.CancelFunc, error) {
+	f := s.farmOfIndex(req.GetIndex())
+	if f == nil {
+		return nil, nil, nil, status.Errorf(codes.NotFound, "unknown index %q", req.GetIndex())
+	}
+
+	var query *es.Query
+
+	switch q := req.GetQuery().(type) {
+	case *farmerpb.QueryRequest_Filter:
+		if q.Filter.GetTo() <= q.Filter.GetFrom() {
+			return nil, nil, nil, status.Error(codes.InvalidArgument, "filter to must be after from")
+		}
+
+		query = filterQuery(q.Filter)
+		query.Size = int(req.GetSize())
+	case *farmerpb.QueryRequest_Body:
+		query = &es.Query{}
+
+		if err := json.Unmarshal([]byte(q.Body), query); err != nil {
+			return nil, nil, nil, status.Errorf(codes.InvalidArgument, "invalid query body: %s", err)
+		}
+
+		if query.Query == nil {
+			return nil, nil, nil, status.Error(codes.InvalidArgument, "query body has no query")
+		}
+	default:
+		return nil, nil, nil, status.Error(codes.InvalidArgument, "a filter or body is required")
+	}
+
+	if fields := req.GetFields(); len(fields) > 0 {
+		if err := es.ValidateColumns(fields); err != nil {
+			return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		query.Source = fields
+	}
+
+	cancel := context.CancelFunc(func() {})
+
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+
+	noteContextQueries(ctx, query)
+
+	return f, query.WithContext(ctx), cancel, nil
+}
+
+// farmOfIndex returns the farm of the given index, with blank meaning our
+// default farm, or nil if we don't know the index.
+func (s *Server) farmOfIndex(index string) *farm {
+	if index == "" || index == s.farm.index {
+		return s.farm
+	}
+
+	return s.farms[index]
+}
+
+// filterQuery converts the given Filter to an es.Query, like the client
+// package's Filter.Query().
+func filterQuery(filter *farmerpb.Filter) *es.Query {
+	esFilter := es.Filter{
+		{"match_phrase": map[string]interface{}{"META_CLUSTER_NAME": "farm"}},
+		{"range": map[string]interface{}{
+			"timestamp": map[string]interface{}{
+				"lt":     time.Unix(filter.GetTo(), 0).UTC().Format(time.RFC3339),
+				"gte":    time.Unix(filter.GetFrom(), 0).UTC().Format(time.RFC3339),
+				"format": "strict_date_optional_time",
+			},
+		}},
+	}
+
+	for _, kv := range [][2]string{
+		{"BOM", filter.GetBom()},
+		{"ACCOUNTING_NAME", filter.GetAccountingName()},
+		{usernameField, filter.GetUserName()},
+	} {
+		if kv[1] != "" {
+			esFilter = append(esFilter, map[string]es.MapStringStringOrMap{
+				"match_phrase": map[string]interface{}{kv[0]: kv[1]},
+			})
+		}
+	}
+
+	if filter.GetGpuOnly() {
+		esFilter = append(esFilter, map[string]es.MapStringStringOrMap{
+			"prefix": map[string]interface{}{"QUEUE_NAME": "gpu"},
+		})
+	}
+
+	return &es.Query{
+		Sort:  []string{"_doc"},
+		Query: &es.QueryFilter{Bool: es.QFBool{Filter: esFilter}},
+	}
+}
+
+// grpcError converts the given error to a status error with the code
+// equivalent to the http status sendErrorToClient() would use.
+func grpcError(err error) error {
+	code := codes.Internal
+
+	switch errorStatus(err) {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+
+	if errors.Is(err, context.Canceled) {
+		code = codes.Canceled
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// hitsToProto converts the given Hits to farmerpb Hits.
+func hitsToProto(hits []es.Hit) []*farmerpb.Hit {
+	pbHits := make([]*farmerpb.Hit, len(hits))
+
+	for i := range hits {
+		pbHits[i] = hitToProto(&hits[i])
+	}
+
+	return pbHits
+}
+
+// hitToProto converts the given Hit to a farmerpb Hit.
+func hitToProto(hit *es.Hit) *farmerpb.Hit { //nolint:funlen
+	d := hit.Details
+	if d == nil {
+		return &farmerpb.Hit{Id: hit.ID}
+	}
+
+	id := hit.ID
+	if id == "" {
+		id = d.ID
+	}
+
+	return &farmerpb.Hit{
+		Id:                         id,
+		AccountingName:             d.AccountingName,
+		AvailCpuTimeSec:            d.AvailCPUTimeSec,
+		Bom:                        d.BOM,
+		Command:                    d.Command,
+		JobName:                    d.JobName,
+		Job:                        d.Job,
+		MemRequestedMb:             d.MemRequestedMB,
+		MemRequestedMbSec:          d.MemRequestedMBSec,
+		NumExecProcs:               d.NumExecProcs,
+		PendingTimeSec:             d.PendingTimeSec,
+		QueueName:                  d.QueueName,
+		RunTimeSec:                 d.RunTimeSec,
+		Timestamp:                  d.Timestamp,
+		UserName:                   d.UserName,
+		WastedCpuSeconds:           d.WastedCPUSeconds,
+		WastedMbSeconds:            d.WastedMBSeconds,
+		RawWastedCpuSeconds:        d.RawWastedCPUSeconds,
+		RawWastedMbSeconds:         d.RawWastedMBSeconds,
+		AvgMemEfficiencyPercent:    d.AvgMemEfficiencyPercent,
+		AvrgMemUsageMb:             d.AvrgMemUsageMB,
+		AvrgMemUsageMbSecCooked:    d.AvrgMemUsageMBSecCooked,
+		AvrgMemUsageMbSecRaw:       d.AvrgMemUsageMBSecRaw,
+		ClusterName:                d.ClusterName,
+		CookedCpuTimeSec:           d.CookedCPUTimeSec,
+		EndTime:                    d.EndTime,
+		ExecHostname:               d.ExecHostname,
+		ExitInfo:                   d.ExitInfo,
+		ExitReason:                 d.ExitReason,
+		JobId:                      d.JobID,
+		JobArrayIndex:              d.JobArrayIndex,
+		JobExitStatus:              d.JobExitStatus,
+		JobEfficiencyPercent:       d.JobEfficiencyPercent,
+		JobEfficiencyRawPercent:    d.JobEfficiencyRawPercent,
+		MaxMemEfficiencyPercent:    d.MaxMemEfficiencyPercent,
+		MaxMemUsageMb:              d.MaxMemUsageMB,
+		MaxMemUsageMbSecCooked:     d.MaxMemUsageMBSecCooked,
+		MaxMemUsageMbSecRaw:        d.MaxMemUsageMBSecRaw,
+		NumberOfHosts:              d.NumberOfHosts,
+		NumberOfUniqueHosts:        d.NumberOfUniqueHosts,
+		ProjectName:                d.ProjectName,
+		RawAvgMemEfficiencyPercent: d.RawAvgMemEfficiencyPercent,
+		RawCpuTimeSec:              d.RawCPUTimeSec,
+		RawMaxMemEfficiencyPercent: d.RawMaxMemEfficiencyPercent,
+		SubmitTime:                 d.SubmitTime,
+	}
+}
@@ -0,0 +1,299 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	"github.com/wtsi-hgi/go-farmer/farmerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPC(t *testing.T) {
+	Convey("Given a server's gRPC server listening on a buffer", t, func() {
+		index := "farm-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		client, stop := newGRPCTestClient(server)
+		defer stop()
+
+		ctx := context.Background()
+
+		_, expectedHits := mock.ScrollQuery("")
+		scrollFilter := &farmerpb.QueryRequest_Filter{Filter: &farmerpb.Filter{
+			From: time.Date(2024, 5, 3, 15, 0, 0, 0, time.UTC).Unix(),
+			To:   time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC).Unix(),
+		}}
+
+		Convey("you can Scroll all hits in batches", func() {
+			stream, errs := client.Scroll(ctx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(errs, ShouldBeNil)
+
+			batches, hits := 0, 0
+			users := make(map[string]bool)
+
+			for {
+				batch, errr := stream.Recv()
+				if errors.Is(errr, io.EOF) {
+					break
+				}
+
+				So(errr, ShouldBeNil)
+				So(len(batch.GetHits()), ShouldBeLessThanOrEqualTo, grpcBatchHits)
+
+				batches++
+				hits += len(batch.GetHits())
+
+				for _, hit := range batch.GetHits() {
+					users[hit.GetUserName()] = true
+				}
+			}
+
+			So(hits, ShouldEqual, expectedHits)
+			So(batches, ShouldEqual, (expectedHits+grpcBatchHits-1)/grpcBatchHits)
+			So(users, ShouldResemble, map[string]bool{"u": true, "u1": true, "u2": true})
+		})
+
+		Convey("you can get Usernames", func() {
+			resp, errs := client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter, Index: index})
+			So(errs, ShouldBeNil)
+
+			usernames := resp.GetUsernames()
+			sort.Strings(usernames)
+			So(usernames, ShouldResemble, []string{"u", "u1", "u2"})
+		})
+
+		Convey("you can Search with a raw query body", func() {
+			resp, errs := client.Search(ctx, &farmerpb.QueryRequest{
+				Query: &farmerpb.QueryRequest_Body{Body: `{"size":0,"aggs":{"stats":{"terms":{"field":"BOM"}}},` +
+					`"query":{"bool":{"filter":[{"match_phrase":{"META_CLUSTER_NAME":"farm"}}]}}}`},
+			})
+			So(errs, ShouldBeNil)
+			So(resp.GetAggregations(), ShouldNotBeEmpty)
+		})
+
+		Convey("invalid requests get appropriate codes", func() {
+			_, err = client.Search(ctx, &farmerpb.QueryRequest{})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			_, err = client.Search(ctx, &farmerpb.QueryRequest{Query: &farmerpb.QueryRequest_Body{Body: "{"}})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			_, err = client.Search(ctx, &farmerpb.QueryRequest{Query: &farmerpb.QueryRequest_Body{Body: "{}"}})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{
+				Query: &farmerpb.QueryRequest_Filter{Filter: &farmerpb.Filter{From: 2, To: 1}},
+			})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter, Fields: []string{"foo"}})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter, Index: "other-*"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("with auth, calls need credentials in metadata", func() {
+			server.SetAuth(Auth{Tokens: []string{"secret"}})
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+
+			badCtx := metadata.AppendToOutgoingContext(ctx, grpcAuthMetadata, bearerPrefix+"wrong")
+			_, err = client.Usernames(badCtx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+
+			authCtx := metadata.AppendToOutgoingContext(ctx, grpcAuthMetadata, bearerPrefix+"secret")
+			_, err = client.Usernames(authCtx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("with a rate limit, identities over the limit are ResourceExhausted", func() {
+			server.SetAuth(Auth{Tokens: []string{"secret", "other"}})
+			server.SetRateLimit(RateLimit{PerSecond: 0.001, Burst: 1})
+
+			authCtx := metadata.AppendToOutgoingContext(ctx, grpcAuthMetadata, bearerPrefix+"secret")
+			_, err = client.Usernames(authCtx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(err, ShouldBeNil)
+
+			var header metadata.MD
+
+			_, err = client.Usernames(authCtx, &farmerpb.QueryRequest{Query: scrollFilter}, grpc.Header(&header))
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+			So(header.Get(grpcRetryAfterMetadata), ShouldNotBeEmpty)
+
+			stream, errs := client.Scroll(authCtx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(errs, ShouldBeNil)
+
+			_, err = stream.Recv()
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+
+			otherCtx := metadata.AppendToOutgoingContext(ctx, grpcAuthMetadata, bearerPrefix+"other")
+			_, err = client.Usernames(otherCtx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("without auth, the rate limit applies to the peer's address", func() {
+			server.SetRateLimit(RateLimit{PerSecond: 0.001, Burst: 1})
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(err, ShouldBeNil)
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+		})
+
+		Convey("calls are access logged and recorded", func() {
+			var buf bytes.Buffer
+
+			server.SetAccessLog(slog.New(slog.NewJSONHandler(&buf, nil)))
+			server.SetAuth(Auth{Tokens: []string{"secret"}})
+
+			rec := &recorder{}
+			server.SetQueryRecorder(rec)
+
+			authCtx := metadata.AppendToOutgoingContext(ctx, grpcAuthMetadata, bearerPrefix+"secret")
+			_, err = client.Usernames(authCtx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(err, ShouldBeNil)
+
+			stream, errs := client.Scroll(authCtx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(errs, ShouldBeNil)
+
+			for {
+				if _, errr := stream.Recv(); errr != nil {
+					So(errr, ShouldEqual, io.EOF)
+
+					break
+				}
+			}
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			So(len(lines), ShouldEqual, 3)
+
+			logs := make([]map[string]any, len(lines))
+			for i, line := range lines {
+				So(json.Unmarshal([]byte(line), &logs[i]), ShouldBeNil)
+			}
+
+			So(logs[0]["method"], ShouldEqual, grpcLogMethod)
+			So(logs[0]["path"], ShouldEqual, farmerpb.Farmer_Usernames_FullMethodName)
+			So(logs[0]["status"], ShouldEqual, http.StatusOK)
+			So(logs[0]["bytes"], ShouldBeGreaterThan, 0)
+			So(logs[0]["identity"], ShouldEqual, tokenIdentityPrefix+"0")
+			So(logs[0]["queries"], ShouldEqual, 1)
+			So(logs[0]["query_key"], ShouldNotBeBlank)
+
+			So(logs[1]["path"], ShouldEqual, farmerpb.Farmer_Scroll_FullMethodName)
+			So(logs[1]["bytes"], ShouldBeGreaterThan, logs[0]["bytes"])
+
+			So(logs[2]["status"], ShouldEqual, http.StatusUnauthorized)
+			So(logs[2]["identity"], ShouldBeNil)
+
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+
+			So(len(rec.records), ShouldEqual, 2)
+			So(rec.records[0].Kind, ShouldEqual, "usernames")
+			So(rec.records[0].Identity, ShouldEqual, tokenIdentityPrefix+"0")
+			So(rec.records[0].Status, ShouldEqual, http.StatusOK)
+			So(rec.records[0].Query, ShouldNotBeNil)
+			So(rec.records[1].Kind, ShouldEqual, scrollKind)
+			So(rec.records[1].Bytes, ShouldBeGreaterThan, rec.records[0].Bytes)
+		})
+
+		Convey("after draining, calls are Unavailable", func() {
+			So(server.Drain(ctx), ShouldBeNil)
+
+			_, err = client.Usernames(ctx, &farmerpb.QueryRequest{Query: scrollFilter})
+			So(status.Code(err), ShouldEqual, codes.Unavailable)
+		})
+	})
+
+	Convey("grpcHTTPStatus converts codes to equivalent http statuses", t, func() {
+		So(grpcHTTPStatus(codes.OK), ShouldEqual, http.StatusOK)
+		So(grpcHTTPStatus(codes.ResourceExhausted), ShouldEqual, http.StatusTooManyRequests)
+		So(grpcHTTPStatus(codes.Unavailable), ShouldEqual, http.StatusServiceUnavailable)
+		So(grpcHTTPStatus(codes.Internal), ShouldEqual, http.StatusInternalServerError)
+	})
+
+	Convey("grpcError converts errors to equivalent codes", t, func() {
+		So(status.Code(grpcError(db.Error{Msg: db.ErrQueryTooLarge})), ShouldEqual, codes.InvalidArgument)
+		So(status.Code(grpcError(db.Error{Msg: db.ErrDraining})), ShouldEqual, codes.Unavailable)
+		So(status.Code(grpcError(context.DeadlineExceeded)), ShouldEqual, codes.DeadlineExceeded)
+		So(status.Code(grpcError(context.Canceled)), ShouldEqual, codes.Canceled)
+		So(status.Code(grpcError(io.ErrUnexpectedEOF)), ShouldEqual, codes.Internal)
+	})
+}
+
+// newGRPCTestClient serves the given server's GRPCServer() on an in-memory
+// listener, and returns a client connected to it, and a function to stop it.
+func newGRPCTestClient(server *Server) (farmerpb.FarmerClient, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	gs := server.GRPCServer()
+
+	go gs.Serve(lis) //nolint:errcheck
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	So(err, ShouldBeNil)
+
+	return farmerpb.NewFarmerClient(conn), func() {
+		conn.Close()
+		gs.Stop()
+	}
+}
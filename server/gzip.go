@@ -0,0 +1,173 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	gzipEncoding = "gzip"
+
+	// minGzipBytes is the smallest response body we bother to compress.
+	minGzipBytes = 1024
+)
+
+// acceptsGzip returns true if the request's Accept-Encoding header allows a
+// gzip encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), gzipEncoding) {
+			continue
+		}
+
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+
+		weight, err := strconv.ParseFloat(q, 64)
+
+		return err == nil && weight > 0
+	}
+
+	return false
+}
+
+// sendResult responds with the given JSON query result and a 200 status,
+// gzip compressing it with our SearchScroller's Gzip() if it's large enough and
+// the client accepts that. Already compressed results (see cache.IsGzipped())
+// are sent as is to clients that accept gzip, and decompressed for those that
+// don't.
+func (s *Server) sendResult(w http.ResponseWriter, r *http.Request, jsonResult []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	body := jsonResult
+
+	if cache.IsGzipped(jsonResult) && !acceptsGzip(r) {
+		plain, err := cache.Plain(jsonResult)
+		if err != nil {
+			sendErrorToClient(w, err)
+
+			return
+		}
+
+		body = plain
+	} else if (cache.IsGzipped(jsonResult) || len(jsonResult) >= minGzipBytes) && acceptsGzip(r) {
+		gz, err := s.farmOf(r).searchScroller().Gzip(jsonResult)
+		if err != nil {
+			slog.Error("gzip failed", "err", err)
+		} else {
+			w.Header().Set("Content-Encoding", gzipEncoding)
+
+			body = gz
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(body); err != nil {
+		slog.Error("write to client failed", "err", err)
+	}
+}
+
+// gzipWriterIfAccepted returns a writer that gzip compresses what is written to
+// it before writing it to w, if the client accepts that, otherwise w itself.
+// Also returns a function that sends what has been written so far to the
+// client, and one to call once done writing. This must be called before the
+// response's status is written.
+func gzipWriterIfAccepted(w http.ResponseWriter, r *http.Request) (io.Writer, func(), func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	rc := http.NewResponseController(w)
+
+	if !acceptsGzip(r) {
+		return w, func() { rc.Flush() }, func() {} //nolint:errcheck
+	}
+
+	w.Header().Set("Content-Encoding", gzipEncoding)
+
+	zw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed) //nolint:errcheck
+
+	flush := func() {
+		if err := zw.Flush(); err == nil {
+			rc.Flush() //nolint:errcheck
+		}
+	}
+
+	return zw, flush, func() {
+		if err := zw.Close(); err != nil {
+			slog.Error("write to client failed", "err", err)
+		}
+	}
+}
+
+// flushingWriter is an io.Writer that calls flush when Flush()ed, so that
+// Result.MarshalFieldsTo() sends on each chunk as it is written.
+type flushingWriter struct {
+	io.Writer
+	flush func()
+}
+
+func (f flushingWriter) Flush() {
+	f.flush()
+}
+
+// streamResult responds with the given Result of the given query as JSON with a
+// 200 status, writing and flushing it in chunks as it is marshalled (so without
+// a Content-Length), gzip compressed if the client accepts that. If the query
+// is being profiled, its es.Profile is added to the end of the JSON.
+func streamResult(w http.ResponseWriter, r *http.Request, result *es.Result, query *es.Query) {
+	w.Header().Set("Content-Type", "application/json")
+
+	out, flush, finish := gzipWriterIfAccepted(w, r)
+	defer finish()
+
+	w.WriteHeader(http.StatusOK)
+
+	fw := flushingWriter{Writer: out, flush: flush}
+
+	if query.Profiling() {
+		streamProfiledResult(fw, result, query)
+
+		return
+	}
+
+	if err := result.MarshalFieldsTo(fw, query.DesiredFields()); err != nil {
+		slog.Error("write to client failed", "err", err)
+	}
+}
@@ -0,0 +1,155 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+)
+
+func TestGzip(t *testing.T) {
+	Convey("acceptsGzip() understands Accept-Encoding headers", t, func() {
+		for header, expected := range map[string]bool{
+			"":                        false,
+			"identity":                false,
+			"gzip":                    true,
+			"GZIP":                    true,
+			"deflate, gzip;q=0.5":     true,
+			"br;q=1.0, gzip; q=0.001": true,
+			"gzip;q=0":                false,
+			"gzip;q=0.000":            false,
+			"gzip;q=nonsense":         false,
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", header)
+			So(acceptsGzip(req), ShouldEqual, expected)
+		}
+	})
+
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 2)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		serve := func(req *http.Request, acceptEncoding string) (*http.Response, []byte) {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Vary"), ShouldEqual, "Accept-Encoding")
+
+			body, errr := io.ReadAll(resp.Body)
+			So(errr, ShouldBeNil)
+
+			return resp, body
+		}
+
+		gunzip := func(data []byte) []byte {
+			zr, errz := gzip.NewReader(bytes.NewReader(data))
+			So(errz, ShouldBeNil)
+
+			plain, errz := io.ReadAll(zr)
+			So(errz, ShouldBeNil)
+
+			return plain
+		}
+
+		Convey("large search results are gzipped if the client accepts it", func() {
+			req, _ := mock.ScrollQuery("?scroll=1m")
+			resp, plain := serve(req, "")
+			So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+			So(len(plain), ShouldBeGreaterThan, minGzipBytes)
+			So(cache.IsGzipped(plain), ShouldBeFalse)
+			So(resp.Header.Get("Content-Length"), ShouldEqual, strconv.Itoa(len(plain)))
+
+			req, _ = mock.ScrollQuery("?scroll=1m")
+			resp, compressed := serve(req, "gzip")
+			So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(resp.Header.Get("Content-Length"), ShouldNotBeEmpty)
+			So(len(compressed), ShouldBeLessThan, len(plain))
+			So(bytes.Equal(gunzip(compressed), plain), ShouldBeTrue)
+		})
+
+		Convey("large scroll results can be streamed, gzipped or not", func() {
+			req, _ := mock.ScrollQuery("?scroll=1m")
+			resp, plain := serve(req, "")
+			So(resp.Header.Get("Content-Length"), ShouldNotBeEmpty)
+
+			cq.Flush()
+			cq.SetStreamThreshold(1)
+
+			// each uncached scroll has its own took
+			took := regexp.MustCompile(`"took":\d+`)
+			withoutTook := func(data []byte) []byte {
+				return took.ReplaceAll(data, nil)
+			}
+
+			req, _ = mock.ScrollQuery("?scroll=1m")
+			resp, streamed := serve(req, "")
+			So(resp.Header.Get("Content-Length"), ShouldBeEmpty)
+			So(bytes.Equal(withoutTook(streamed), withoutTook(plain)), ShouldBeTrue)
+
+			req, _ = mock.ScrollQuery("?scroll=1m")
+			resp, compressed := serve(req, "gzip")
+			So(resp.Header.Get("Content-Length"), ShouldBeEmpty)
+			So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(bytes.Equal(withoutTook(gunzip(compressed)), withoutTook(plain)), ShouldBeTrue)
+		})
+
+		Convey("small results aren't gzipped", func() {
+			resp, _ := serve(mock.AggQuery(), "gzip")
+			So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+		})
+
+		Convey("exports are gzipped if the client accepts it", func() {
+			req, _ := mock.ScrollQuery("?columns=USER_NAME")
+			req.URL.Path = slash + exportEndpoint
+			_, plain := serve(req, "")
+
+			req, _ = mock.ScrollQuery("?columns=USER_NAME")
+			req.URL.Path = slash + exportEndpoint
+			resp, compressed := serve(req, "gzip")
+			So(resp.Header.Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(bytes.Equal(gunzip(compressed), plain), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,154 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	fieldCapsFieldsParam  = "fields"
+	illegalArgumentError  = "illegal_argument_exception"
+	msgFieldCapsNoFields  = "specified fields can't be null or empty"
+	fieldCapsFieldsSep    = ","
+	fieldCapsBodyMaxBytes = 1 << 20
+)
+
+// indexMapping is the response to a _mapping request.
+type indexMapping struct {
+	Mappings struct {
+		Properties map[string]es.FieldMapping `json:"properties"`
+	} `json:"mappings"`
+}
+
+// fieldCap describes the capabilities of a field in a _field_caps response.
+type fieldCap struct {
+	Type          string `json:"type"`
+	MetadataField bool   `json:"metadata_field"`
+	Searchable    bool   `json:"searchable"`
+	Aggregatable  bool   `json:"aggregatable"`
+}
+
+// fieldCapsResponse is the response to a _field_caps request.
+type fieldCapsResponse struct {
+	Indices []string                       `json:"indices"`
+	Fields  map[string]map[string]fieldCap `json:"fields"`
+}
+
+// mappingHandler returns a handler for /_mapping and /index/_mapping requests,
+// which we answer with the es.Mappings() of the given index, so that tools like
+// Grafana work without the real elasticsearch.
+func mappingHandler(index string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		var im indexMapping
+
+		im.Mappings.Properties = es.Mappings()
+
+		sendJSONToClient(w, http.StatusOK, map[string]indexMapping{index: im})
+	}
+}
+
+// fieldCapsHandler returns a handler for /index/_field_caps requests, which we
+// answer with the capabilities of those es.Mappings() that match the requested
+// fields.
+func fieldCapsHandler(index string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		patterns := fieldCapsPatterns(r)
+		if len(patterns) == 0 {
+			sendElasticError(w, illegalArgumentError, msgFieldCapsNoFields, http.StatusBadRequest)
+
+			return
+		}
+
+		response := fieldCapsResponse{
+			Indices: []string{index},
+			Fields:  make(map[string]map[string]fieldCap),
+		}
+
+		for name, mapping := range es.Mappings() {
+			if !matchesAnyPattern(name, patterns) {
+				continue
+			}
+
+			response.Fields[name] = map[string]fieldCap{
+				mapping.Type: {Type: mapping.Type, Searchable: true, Aggregatable: true},
+			}
+		}
+
+		sendJSONToClient(w, http.StatusOK, response)
+	}
+}
+
+// fieldCapsPatterns returns the field name patterns in the fields parameter
+// of a _field_caps request, or in its body.
+func fieldCapsPatterns(r *http.Request) []string {
+	fields := r.URL.Query().Get(fieldCapsFieldsParam)
+	if fields != "" {
+		return strings.Split(fields, fieldCapsFieldsSep)
+	}
+
+	if r.Body == nil {
+		return nil
+	}
+
+	var body struct {
+		Fields []string `json:"fields"`
+	}
+
+	json.NewDecoder(io.LimitReader(r.Body, fieldCapsBodyMaxBytes)).Decode(&body) //nolint:errcheck
+
+	return body.Fields
+}
+
+// matchesAnyPattern returns true if the given name matches any of the given
+// patterns, which can contain * wildcards.
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(strings.TrimSpace(pattern), name); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,114 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestMapping(t *testing.T) {
+	Convey("Given a server whose real elasticsearch is unreachable", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		indexPath := "/" + url.QueryEscape(index) + "/"
+
+		serve := func(method, target, body string) *httptest.ResponseRecorder {
+			var req *http.Request
+			if body == "" {
+				req = httptest.NewRequest(method, target, nil)
+			} else {
+				req = httptest.NewRequest(method, target, strings.NewReader(body))
+			}
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w
+		}
+
+		Convey("_mapping requests get our mappings", func() {
+			for _, target := range []string{"/" + es.MappingPage, indexPath + es.MappingPage} {
+				w := serve(http.MethodGet, target, "")
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var mappings map[string]indexMapping
+
+				So(json.Unmarshal(w.Body.Bytes(), &mappings), ShouldBeNil)
+				So(len(mappings), ShouldEqual, 1)
+				So(mappings[index].Mappings.Properties, ShouldResemble, es.Mappings())
+			}
+
+			So(serve(http.MethodPut, indexPath+es.MappingPage, "{}").Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("_field_caps requests get the capabilities of matching fields", func() {
+			decode := func(w *httptest.ResponseRecorder) fieldCapsResponse {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var caps fieldCapsResponse
+
+				So(json.Unmarshal(w.Body.Bytes(), &caps), ShouldBeNil)
+				So(caps.Indices, ShouldResemble, []string{index})
+
+				return caps
+			}
+
+			caps := decode(serve(http.MethodGet, indexPath+es.FieldCapsPage+"?fields=*", ""))
+			So(len(caps.Fields), ShouldEqual, len(es.SourceFields()))
+			So(caps.Fields["timestamp"], ShouldResemble, map[string]fieldCap{
+				"date": {Type: "date", Searchable: true, Aggregatable: true},
+			})
+
+			caps = decode(serve(http.MethodPost, indexPath+es.FieldCapsPage+"?fields=BOM,RUN_*", ""))
+			So(len(caps.Fields), ShouldEqual, 2)
+			So(caps.Fields["BOM"]["keyword"].Type, ShouldEqual, "keyword")
+			So(caps.Fields["RUN_TIME_SEC"]["long"].Type, ShouldEqual, "long")
+
+			caps = decode(serve(http.MethodPost, indexPath+es.FieldCapsPage, `{"fields":["USER_NAME"]}`))
+			So(len(caps.Fields), ShouldEqual, 1)
+			So(caps.Fields["USER_NAME"]["keyword"].Aggregatable, ShouldBeTrue)
+
+			w := serve(http.MethodGet, indexPath+es.FieldCapsPage, "")
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(w.Body.String(), ShouldContainSubstring, msgFieldCapsNoFields)
+
+			So(serve(http.MethodDelete, indexPath+es.FieldCapsPage, "").Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
@@ -0,0 +1,194 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	maxConcurrentMultiSearches = 8
+	multiSearchErrorType       = "farmer_exception"
+	multiSearchOKStatus        = `"status":200}`
+)
+
+// elasticError is the JSON form of an error that elasticsearch gives, eg. in
+// place of the result of a search in a _msearch response that failed.
+type elasticError struct {
+	Error struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+	Status int `json:"status"`
+}
+
+// multiSearch returns a handler for /index/_msearch requests, which have an
+// NDJSON body of several search queries. We answer each of them like search()
+// would for a non-scroll search, returning an elasticsearch-style response with
+// all their results. Requests that involve other indexes, or that we can't
+// parse, are proxied to the real elasticsearch.
+func (s *Server) multiSearch(index string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		queries, ok := es.NewMultiSearchQueries(r, index)
+		if !ok {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			s.proxy.ServeHTTP(w, r)
+
+			return
+		}
+
+		noteQueries(r, queries...)
+
+		start := time.Now()
+		responses := searchAll(s.farmOf(r).searchScroller(), queries)
+
+		for _, query := range queries {
+			for _, warning := range query.Warnings() {
+				w.Header().Add("Warning", fmt.Sprintf("299 farmer %q", warning))
+			}
+		}
+
+		s.setDataThroughHeader(w, r)
+		s.sendResult(w, r, multiSearchResponse(time.Since(start), responses))
+	}
+}
+
+// searchAll does a Search() of each of the given queries, a few at a time,
+// returning the result of each (in the same order) with the status
+// elasticsearch would give it in a _msearch response.
+func searchAll(sc SearchScroller, queries []*es.Query) [][]byte {
+	responses := make([][]byte, len(queries))
+	sem := make(chan struct{}, maxConcurrentMultiSearches)
+
+	var wg sync.WaitGroup
+
+	for i, query := range queries {
+		wg.Add(1)
+
+		sem <- struct{}{}
+
+		go func(i int, query *es.Query) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			responses[i] = withMultiSearchStatus(plainSearch(sc, query))
+		}(i, query)
+	}
+
+	wg.Wait()
+
+	return responses
+}
+
+// plainSearch returns the uncompressed JSON result of the SearchScroller's
+// Search().
+func plainSearch(sc SearchScroller, query *es.Query) ([]byte, error) {
+	jsonResult, err := sc.Search(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.Plain(jsonResult)
+}
+
+// withMultiSearchStatus returns the given JSON search result with a "status"
+// of 200 added to it, or if err is not nil, an elasticError for it.
+func withMultiSearchStatus(jsonResult []byte, err error) []byte {
+	if err != nil {
+		return elasticErrorJSON(multiSearchErrorType, err.Error(), errorStatus(err))
+	}
+
+	jsonResult = bytes.TrimSpace(jsonResult)
+	if len(jsonResult) < 2 || jsonResult[0] != '{' || jsonResult[len(jsonResult)-1] != '}' {
+		return elasticErrorJSON(multiSearchErrorType, "search result was not a JSON object",
+			http.StatusInternalServerError)
+	}
+
+	// jsonResult may be shared with our cache, so we must not append to it.
+	withStatus := make([]byte, 0, len(jsonResult)+len(multiSearchOKStatus))
+	withStatus = append(withStatus, jsonResult[:len(jsonResult)-1]...)
+
+	if len(bytes.TrimSpace(jsonResult[1:len(jsonResult)-1])) > 0 {
+		withStatus = append(withStatus, ',')
+	}
+
+	return append(withStatus, multiSearchOKStatus...)
+}
+
+// elasticErrorJSON returns the JSON of an elasticError with the given type,
+// reason and status.
+func elasticErrorJSON(errType, reason string, status int) []byte {
+	ee := elasticError{Status: status}
+	ee.Error.Type = errType
+	ee.Error.Reason = reason
+
+	data, _ := json.Marshal(ee) //nolint:errcheck,errchkjson
+
+	return data
+}
+
+// multiSearchResponse combines the given responses into the JSON of an
+// elasticsearch _msearch response.
+func multiSearchResponse(took time.Duration, responses [][]byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(`{"took":`)
+	buf.WriteString(strconv.FormatInt(took.Milliseconds(), 10))
+	buf.WriteString(`,"responses":[`)
+
+	for i, response := range responses {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		buf.Write(response)
+	}
+
+	buf.WriteString("]}")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,143 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+type multiSearchResult struct {
+	Took      int               `json:"took"`
+	Responses []json.RawMessage `json:"responses"`
+}
+
+func TestMultiSearch(t *testing.T) {
+	Convey("withMultiSearchStatus() adds a status to results without altering them", t, func() {
+		result := []byte(`{"took":1}`)
+		So(string(withMultiSearchStatus(result, nil)), ShouldEqual, `{"took":1,"status":200}`)
+		So(string(result), ShouldEqual, `{"took":1}`)
+		So(string(withMultiSearchStatus([]byte(" { }\n"), nil)), ShouldEqual, `{ "status":200}`)
+		So(string(withMultiSearchStatus([]byte("[]"), nil)), ShouldContainSubstring, `"status":500`)
+		So(string(withMultiSearchStatus(nil, es.Error{Msg: es.ErrCircuitOpen})), ShouldEqual,
+			`{"error":{"type":"farmer_exception","reason":"`+es.ErrCircuitOpen+`"},"status":503}`)
+	})
+
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+
+		mockReal := httptest.NewServer(&mockRealServer{})
+		defer mockReal.Close()
+
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		realURL := &url.URL{Host: strings.TrimPrefix(mockReal.URL, "http://"), Scheme: "http"}
+		server := New(cq, index, realURL)
+
+		aggQuery, err := io.ReadAll(mock.AggQuery().Body)
+		So(err, ShouldBeNil)
+
+		msearch := func(body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/"+url.QueryEscape(index)+"/"+es.MultiSearchPage,
+				strings.NewReader(body))
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w
+		}
+
+		decode := func(w *httptest.ResponseRecorder) multiSearchResult {
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			var result multiSearchResult
+
+			So(json.Unmarshal(w.Body.Bytes(), &result), ShouldBeNil)
+
+			return result
+		}
+
+		Convey("_msearch requests get the result of each search", func() {
+			w := msearch("{}\n" + string(aggQuery) + "\n" + `{"index":"` + index + `"}` + "\n" + string(aggQuery) + "\n")
+			result := decode(w)
+			So(len(result.Responses), ShouldEqual, 2)
+
+			for _, response := range result.Responses {
+				var status struct {
+					Status int `json:"status"`
+				}
+
+				So(json.Unmarshal(response, &status), ShouldBeNil)
+				So(status.Status, ShouldEqual, http.StatusOK)
+
+				searchResult, errd := cache.Decode(response)
+				So(errd, ShouldBeNil)
+				So(len(searchResult.Aggregations.Stats.Buckets), ShouldEqual, 6)
+			}
+		})
+
+		Convey("_msearch requests for other indexes, or that are invalid, are proxied", func() {
+			for _, body := range []string{`{"index":"other"}` + "\n{}\n", "{}\n{"} {
+				w := msearch(body)
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, "a real elasticsearch response")
+			}
+		})
+
+		Convey("searches that fail get an error response", func() {
+			cq, err = cache.New(unavailableSearcher{}, mock, 1)
+			So(err, ShouldBeNil)
+
+			server = New(cq, index, realURL)
+
+			result := decode(msearch("{}\n" + string(aggQuery) + "\n"))
+			So(len(result.Responses), ShouldEqual, 1)
+			So(string(result.Responses[0]), ShouldContainSubstring, `"status":503`)
+			So(string(result.Responses[0]), ShouldContainSubstring, es.ErrCircuitOpen)
+		})
+
+		Convey("searches answered locally have warnings", func() {
+			cq, err = cache.New(unavailableSearcher{}, &localScroller{mock}, 1)
+			So(err, ShouldBeNil)
+
+			server = New(cq, index, realURL)
+
+			w := msearch("{}\n" + string(aggQuery) + "\n")
+			So(len(decode(w).Responses), ShouldEqual, 1)
+			So(w.Result().Header.Get("Warning"), ShouldEqual, `299 farmer "`+cache.WarnLocalOnly+`"`)
+		})
+	})
+}
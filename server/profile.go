@@ -0,0 +1,122 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// sendProfiledResult is like sendResult(), but adds the es.Profile of the
+// given query to the JSON result. The time taken to decompress cached results
+// counts towards its es.PhaseMarshal.
+func (s *Server) sendProfiledResult(w http.ResponseWriter, r *http.Request, jsonResult []byte, query *es.Query) {
+	start := time.Now()
+
+	plain, err := cache.Plain(jsonResult)
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	query.TimePhase(es.PhaseMarshal, start)
+
+	profiled, err := query.ProfileResult().AddTo(plain)
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	s.sendResult(w, r, profiled)
+}
+
+// streamProfiledResult marshals the given Result to w, as MarshalFieldsTo()
+// does, then adds the es.Profile of the given query, which includes the time
+// taken to marshal.
+func streamProfiledResult(w flushingWriter, result *es.Result, query *es.Query) {
+	pw := &profileWriter{flushingWriter: w}
+	start := time.Now()
+
+	if err := result.MarshalFieldsTo(pw, query.DesiredFields()); err != nil {
+		slog.Error("write to client failed", "err", err)
+
+		return
+	}
+
+	query.TimePhase(es.PhaseMarshal, start)
+
+	if err := pw.finish(query); err != nil {
+		slog.Error("write to client failed", "err", err)
+	}
+}
+
+// profileWriter is a flushingWriter that holds back the last byte written to
+// it, which for a JSON object is its closing brace, so that a "profile" can be
+// added before it with finish().
+type profileWriter struct {
+	flushingWriter
+	tail []byte
+}
+
+// Write writes all but the last byte of b, after any byte held back from the
+// previous Write.
+func (p *profileWriter) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if len(p.tail) > 0 {
+		if _, err := p.flushingWriter.Write(p.tail); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := p.flushingWriter.Write(b[:len(b)-1]); err != nil {
+		return 0, err
+	}
+
+	p.tail = append(p.tail[:0], b[len(b)-1])
+
+	return len(b), nil
+}
+
+// finish writes the given query's es.Profile and the held back closing brace.
+func (p *profileWriter) finish(query *es.Query) error {
+	end, err := query.ProfileResult().AddTo(p.tail)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.flushingWriter.Write(end)
+
+	return err
+}
@@ -0,0 +1,143 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestProfile(t *testing.T) {
+	query := `{"size":10000,"profile":true,"query":{"bool":{"filter":[` +
+		`{"match_phrase":{"META_CLUSTER_NAME":"farm"}},` +
+		`{"range":{"timestamp":{"lt":"2024-06-01T00:00:00Z","gte":"2024-05-30T00:00:00Z",` +
+		`"format":"strict_date_optional_time"}}},` +
+		`{"match_phrase":{"BOM":"Human Genetics"}}]}}}`
+
+	type profiledResult struct {
+		Hits struct {
+			Hits []es.Hit `json:"hits"`
+		} `json:"hits"`
+		Profile *es.Profile `json:"profile"`
+	}
+
+	decode := func(body io.Reader) *profiledResult {
+		result := &profiledResult{}
+		So(json.NewDecoder(body).Decode(result), ShouldBeNil)
+
+		return result
+	}
+
+	Convey("Given an EmbeddedServer", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		ts, err := NewTestServer("some-indexes-*", t.TempDir())
+		So(err, ShouldBeNil)
+
+		defer ts.Close()
+
+		search := func(body string) *profiledResult {
+			resp, errp := http.Post(ts.URL+"/some-indexes-%2A/"+es.SearchPage+"?scroll=1m", //nolint:noctx
+				"application/json", strings.NewReader(body))
+			So(errp, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			defer resp.Body.Close()
+
+			return decode(resp.Body)
+		}
+
+		Convey("searches with profile get a breakdown of where the time went", func() {
+			result := search(query)
+			So(len(result.Hits.Hits), ShouldEqual, 2)
+			So(result.Profile, ShouldNotBeNil)
+			So(result.Profile.Cache, ShouldEqual, es.CacheMiss)
+			So(result.Profile.IndexScanMS, ShouldBeGreaterThan, 0)
+			So(result.Profile.DataReadMS, ShouldBeGreaterThan, 0)
+			So(result.Profile.DeserializeMS, ShouldBeGreaterThan, 0)
+			So(result.Profile.MarshalMS, ShouldBeGreaterThan, 0)
+
+			result = search(query)
+			So(len(result.Hits.Hits), ShouldEqual, 2)
+			So(result.Profile.Cache, ShouldEqual, es.CacheHit)
+			So(result.Profile.IndexScanMS, ShouldEqual, 0)
+			So(result.Profile.DataReadMS, ShouldEqual, 0)
+
+			Convey("which isn't in the cached result", func() {
+				result = search(strings.Replace(query, `"profile":true,`, "", 1))
+				So(len(result.Hits.Hits), ShouldEqual, 2)
+				So(result.Profile, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Streamed search results can be profiled", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		cq.SetStreamThreshold(1)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		for _, encoding := range []string{"", gzipEncoding} {
+			req := httptest.NewRequest(http.MethodPost, "/"+index+"/"+es.SearchPage+"?scroll=1m", strings.NewReader(query))
+			req.Header.Set("Accept-Encoding", encoding)
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			body := io.Reader(w.Body)
+
+			if encoding != "" {
+				So(w.Header().Get("Content-Encoding"), ShouldEqual, gzipEncoding)
+
+				plain, errp := cache.Plain(w.Body.Bytes())
+				So(errp, ShouldBeNil)
+
+				body = strings.NewReader(string(plain))
+			}
+
+			result := decode(body)
+			So(result.Hits.Hits, ShouldNotBeEmpty)
+			So(result.Profile, ShouldNotBeNil)
+			So(result.Profile.Cache, ShouldEqual, es.CacheMiss)
+			So(result.Profile.MarshalMS, ShouldBeGreaterThan, 0)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// proxyTargetCooldown is how long we avoid proxying to a target after failing
+// to get a response from it.
+const proxyTargetCooldown = 30 * time.Second
+
+// proxyFailover is an http.RoundTripper that sends requests to the first of its
+// targets that hasn't failed to respond in the last proxyTargetCooldown,
+// failing over to the next target if it doesn't respond and the request can be
+// resent.
+type proxyFailover struct {
+	transport http.RoundTripper
+
+	mu        sync.Mutex
+	targets   []*url.URL
+	downUntil []time.Time
+}
+
+func newProxyFailover(targets ...*url.URL) *proxyFailover {
+	p := &proxyFailover{transport: http.DefaultTransport}
+	p.setTargets(targets)
+
+	return p
+}
+
+// setTargets replaces our targets, forgetting which were down.
+func (p *proxyFailover) setTargets(targets []*url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.targets = targets
+	p.downUntil = make([]time.Time, len(targets))
+}
+
+// RoundTrip implements http.RoundTripper, sending the request to the scheme and
+// host of each of our targets in turn until one responds. Requests with a body
+// are only sent once, since their body can't be read again.
+func (p *proxyFailover) RoundTrip(req *http.Request) (*http.Response, error) {
+	var err error
+
+	for _, target := range p.order(time.Now()) {
+		out := req.Clone(req.Context())
+		out.URL.Scheme = target.Scheme
+		out.URL.Host = target.Host
+
+		var resp *http.Response
+
+		resp, err = p.transport.RoundTrip(out)
+		if err == nil {
+			return resp, nil
+		}
+
+		p.markDown(target, time.Now())
+
+		if (req.Body != nil && req.Body != http.NoBody) || req.Context().Err() != nil {
+			break
+		}
+	}
+
+	return nil, err
+}
+
+// order returns our targets that are up, followed by those that are down, so
+// that we still try them all if they're all down.
+func (p *proxyFailover) order(now time.Time) []*url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	up := make([]*url.URL, 0, len(p.targets))
+
+	var down []*url.URL
+
+	for i, target := range p.targets {
+		if now.Before(p.downUntil[i]) {
+			down = append(down, target)
+		} else {
+			up = append(up, target)
+		}
+	}
+
+	return append(up, down...)
+}
+
+// markDown makes us avoid the given target for the next proxyTargetCooldown.
+func (p *proxyFailover) markDown(target *url.URL, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, t := range p.targets {
+		if t == target {
+			p.downUntil[i] = now.Add(proxyTargetCooldown)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+)
+
+func TestProxyFailover(t *testing.T) {
+	Convey("Given a server with multiple proxy targets, the first of which is down", t, func() {
+		index := "some-indexes-*"
+
+		up := httptest.NewServer(&mockRealServer{})
+		defer up.Close()
+
+		down := httptest.NewServer(&mockRealServer{})
+		down.Close()
+
+		upURL, err := url.Parse(up.URL)
+		So(err, ShouldBeNil)
+
+		downURL, err := url.Parse(down.URL)
+		So(err, ShouldBeNil)
+
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, downURL)
+		server.SetProxyTargets(downURL, upURL)
+
+		transport := &countingTransport{}
+		server.SetProxyTransport(transport)
+
+		Convey("requests without a body fail over to the next target", func() {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, "a real elasticsearch response")
+			So(transport.n, ShouldEqual, 2)
+
+			Convey("and subsequent requests avoid the target that was down", func() {
+				w = httptest.NewRecorder()
+				server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")))
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(transport.n, ShouldEqual, 3)
+			})
+		})
+
+		Convey("requests with a body are only sent once", func() {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadGateway)
+			So(transport.n, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,201 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTrackedClients is how many clients a clientLimiter tracks before it
+// forgets those that are idle.
+const maxTrackedClients = 10000
+
+// RateLimit configures per-client limits on requests to a Server. Clients are
+// identified by their authenticated user or token if the Server has an Auth,
+// otherwise by their IP address.
+//
+// PerSecond, if non-zero, is the sustained number of requests a client may
+// make each second, with bursts of up to Burst (default PerSecond, rounded up)
+// requests. MaxConcurrent, if non-zero, is how many requests a client may have
+// in progress at once.
+type RateLimit struct {
+	PerSecond     float64
+	Burst         int
+	MaxConcurrent int
+}
+
+// clientState is a client's token bucket and number of requests in flight.
+type clientState struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// clientLimiter applies a RateLimit to each of many clients.
+type clientLimiter struct {
+	RateLimit
+
+	mu      sync.Mutex
+	clients map[string]*clientState
+}
+
+func newClientLimiter(rl RateLimit) *clientLimiter {
+	if rl.Burst < 1 {
+		rl.Burst = int(math.Max(1, math.Ceil(rl.PerSecond)))
+	}
+
+	return &clientLimiter{RateLimit: rl, clients: make(map[string]*clientState)}
+}
+
+// acquire returns true if the given client may make a request now, in which
+// case you must release() it when the request is done. Otherwise also returns
+// how long the client should wait before trying again.
+func (l *clientLimiter) acquire(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cs, ok := l.clients[client]
+	if !ok {
+		l.forgetIdleClients(now)
+
+		cs = &clientState{tokens: float64(l.Burst), last: now}
+		l.clients[client] = cs
+	}
+
+	if l.PerSecond > 0 {
+		cs.tokens = math.Min(float64(l.Burst), cs.tokens+now.Sub(cs.last).Seconds()*l.PerSecond)
+		cs.last = now
+
+		if cs.tokens < 1 {
+			return false, time.Duration((1 - cs.tokens) / l.PerSecond * float64(time.Second))
+		}
+	}
+
+	if l.MaxConcurrent > 0 && cs.inFlight >= l.MaxConcurrent {
+		return false, time.Second
+	}
+
+	if l.PerSecond > 0 {
+		cs.tokens--
+	}
+
+	cs.inFlight++
+
+	return true, 0
+}
+
+// forgetIdleClients forgets clients with no requests in flight and full token
+// buckets, if we're tracking too many. Must be called with the lock held.
+func (l *clientLimiter) forgetIdleClients(now time.Time) {
+	if len(l.clients) < maxTrackedClients {
+		return
+	}
+
+	for client, cs := range l.clients {
+		refilled := l.PerSecond == 0 ||
+			cs.tokens+now.Sub(cs.last).Seconds()*l.PerSecond >= float64(l.Burst)
+
+		if cs.inFlight == 0 && refilled {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// release records that a request acquire()d by the given client is done.
+func (l *clientLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cs, ok := l.clients[client]; ok {
+		cs.inFlight--
+	}
+}
+
+// SetRateLimit makes all subsequent requests subject to the given per-client
+// RateLimit, with those over the limit getting a 429 status and a Retry-After
+// header, so that one runaway client can't starve others.
+func (s *Server) SetRateLimit(rl RateLimit) {
+	if rl.PerSecond <= 0 && rl.MaxConcurrent <= 0 {
+		s.limiter = nil
+
+		return
+	}
+
+	s.limiter = newClientLimiter(rl)
+}
+
+// checkRateLimit returns true if the client with the given identity (or the
+// request's IP address if identity is "") is within our RateLimit, in which
+// case you must call the returned function when the request is done.
+// Otherwise responds with a 429 status and returns false.
+func (s *Server) checkRateLimit(w http.ResponseWriter, r *http.Request, identity string) (func(), bool) {
+	if s.limiter == nil {
+		return func() {}, true
+	}
+
+	client := identity
+	if client == "" {
+		client = clientIP(r)
+	}
+
+	ok, retryAfter := s.limiter.acquire(client, time.Now())
+	if ok {
+		return func() { s.limiter.release(client) }, true
+	}
+
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	sendMessageToClient(w, http.StatusText(http.StatusTooManyRequests))
+
+	return nil, false
+}
+
+// retryAfterSeconds returns the given duration as a Retry-After value: a whole
+// number of seconds, rounded up.
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
+// clientIP returns the IP address of the request's client.
+func clientIP(r *http.Request) string {
+	return hostOfAddr(r.RemoteAddr)
+}
+
+// hostOfAddr returns the host part of the given "host:port" address, or the
+// whole address if it has no port.
+func hostOfAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
@@ -0,0 +1,144 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+)
+
+func TestRateLimit(t *testing.T) {
+	Convey("A clientLimiter limits the rate of each client's requests", t, func() {
+		l := newClientLimiter(RateLimit{PerSecond: 2})
+		So(l.Burst, ShouldEqual, 2)
+
+		now := time.Now()
+
+		for range 2 {
+			ok, _ := l.acquire("a", now)
+			So(ok, ShouldBeTrue)
+			l.release("a")
+		}
+
+		ok, retryAfter := l.acquire("a", now)
+		So(ok, ShouldBeFalse)
+		So(retryAfter, ShouldEqual, 500*time.Millisecond)
+
+		ok, _ = l.acquire("b", now)
+		So(ok, ShouldBeTrue)
+
+		ok, _ = l.acquire("a", now.Add(500*time.Millisecond))
+		So(ok, ShouldBeTrue)
+
+		ok, _ = l.acquire("a", now.Add(600*time.Millisecond))
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("A clientLimiter limits each client's concurrent requests", t, func() {
+		l := newClientLimiter(RateLimit{MaxConcurrent: 1})
+		now := time.Now()
+
+		ok, _ := l.acquire("a", now)
+		So(ok, ShouldBeTrue)
+
+		ok, retryAfter := l.acquire("a", now)
+		So(ok, ShouldBeFalse)
+		So(retryAfter, ShouldEqual, time.Second)
+
+		l.release("a")
+
+		ok, _ = l.acquire("a", now)
+		So(ok, ShouldBeTrue)
+
+		Convey("and forgets idle clients when it tracks too many", func() {
+			for i := range maxTrackedClients {
+				l.clients[strconv.Itoa(i)] = &clientState{}
+			}
+
+			ok, _ = l.acquire("b", now)
+			So(ok, ShouldBeTrue)
+			So(l.clients, ShouldHaveLength, 2)
+		})
+	})
+
+	Convey("Given a rate limited server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		server.SetRateLimit(RateLimit{PerSecond: 0.1})
+
+		serve := func(req *http.Request) *http.Response {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		Convey("clients going over the limit get Too Many Requests", func() {
+			So(serve(mock.AggQuery()).StatusCode, ShouldEqual, http.StatusOK)
+
+			resp := serve(mock.AggQuery())
+			So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+			So(resp.Header.Get("Retry-After"), ShouldEqual, "10")
+
+			req := mock.AggQuery()
+			req.RemoteAddr = "192.0.2.2:1234"
+			So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("authenticated clients are limited by identity, not IP", func() {
+			server.SetAuth(Auth{Users: map[string]string{"a": "p", "b": "p"}})
+
+			for _, user := range []string{"a", "b"} {
+				req := mock.AggQuery()
+				req.SetBasicAuth(user, "p")
+				So(serve(req).StatusCode, ShouldEqual, http.StatusOK)
+			}
+
+			req := mock.AggQuery()
+			req.SetBasicAuth("a", "p")
+			So(serve(req).StatusCode, ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		Convey("a RateLimit without limits turns limiting off", func() {
+			server.SetRateLimit(RateLimit{})
+
+			for range 2 {
+				So(serve(mock.AggQuery()).StatusCode, ShouldEqual, http.StatusOK)
+			}
+		})
+	})
+}
@@ -0,0 +1,132 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+const (
+	readyEndpoint = "readyz"
+
+	// notLoadedRetryAfter is the Retry-After, in seconds, of responses to
+	// queries of days that are still being loaded.
+	notLoadedRetryAfter = 30
+)
+
+// ReadinessSource types can say whether they have finished loading their data.
+// A db.DB configured to BackgroundLoad is one.
+type ReadinessSource interface {
+	Readiness() db.Readiness
+}
+
+// IndexReadiness is the readiness of one index in a Readiness.
+type IndexReadiness struct {
+	Ready      bool   `json:"ready"`
+	LoadedDays int    `json:"loaded_days"`
+	TotalDays  int    `json:"total_days"`
+	LoadedFrom string `json:"loaded_from,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Readiness is the response to a /readyz request.
+type Readiness struct {
+	// Ready is true if all our DataSources have finished loading.
+	Ready bool `json:"ready"`
+
+	// Indexes holds the readiness of each index whose DataSource is a
+	// ReadinessSource, keyed on index name ("" for our default index).
+	Indexes map[string]IndexReadiness `json:"indexes,omitempty"`
+}
+
+// readyz handles /readyz requests by returning our Readiness as JSON, with a
+// 200 status if we're Ready, or a 503 status and a Retry-After header if not.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	readiness := s.currentReadiness()
+	if readiness.Ready {
+		sendJSONToClient(w, http.StatusOK, readiness)
+
+		return
+	}
+
+	setNotLoadedRetryAfter(w)
+	sendJSONToClient(w, http.StatusServiceUnavailable, readiness)
+}
+
+// currentReadiness returns the Readiness of our farms' DataSources.
+func (s *Server) currentReadiness() Readiness {
+	readiness := Readiness{Ready: true}
+
+	s.addIndexReadiness(&readiness, "", s.farm)
+
+	for index, f := range s.farms {
+		s.addIndexReadiness(&readiness, index, f)
+	}
+
+	return readiness
+}
+
+// addIndexReadiness adds the readiness of the given farm's DataSource, if it
+// is a ReadinessSource, to the given Readiness.
+func (s *Server) addIndexReadiness(readiness *Readiness, index string, f *farm) {
+	rs, ok := f.dataSource.(ReadinessSource)
+	if !ok {
+		return
+	}
+
+	dbr := rs.Readiness()
+	ir := IndexReadiness{Ready: dbr.Ready, LoadedDays: dbr.LoadedDays, TotalDays: dbr.TotalDays}
+
+	if !dbr.LoadedFrom.IsZero() {
+		ir.LoadedFrom = dbr.LoadedFrom.Format(time.DateOnly)
+	}
+
+	if dbr.Err != nil {
+		ir.Error = dbr.Err.Error()
+	}
+
+	if readiness.Indexes == nil {
+		readiness.Indexes = make(map[string]IndexReadiness)
+	}
+
+	readiness.Indexes[index] = ir
+	readiness.Ready = readiness.Ready && ir.Ready
+}
+
+// setNotLoadedRetryAfter sets a Retry-After header suitable for a response
+// saying the data needed hasn't been loaded yet.
+func setNotLoadedRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(notLoadedRetryAfter))
+}
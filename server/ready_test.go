@@ -0,0 +1,118 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+type loadingDataSource struct {
+	fixedDataSource
+	readiness db.Readiness
+}
+
+func (l *loadingDataSource) Readiness() db.Readiness {
+	return l.readiness
+}
+
+func TestReady(t *testing.T) {
+	Convey("Databases that haven't loaded the days queried get a 503", t, func() {
+		So(errorStatus(db.Error{Msg: db.ErrNotLoaded}), ShouldEqual, http.StatusServiceUnavailable)
+	})
+
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		getReady := func() (*http.Response, Readiness) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+readyEndpoint, nil))
+
+			var readiness Readiness
+
+			So(json.NewDecoder(w.Body).Decode(&readiness), ShouldBeNil)
+
+			return w.Result(), readiness
+		}
+
+		Convey("without a ReadinessSource, it is always ready", func() {
+			resp, readiness := getReady()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(readiness, ShouldResemble, Readiness{Ready: true})
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+readyEndpoint, nil))
+			So(w.Result().StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("with a ReadinessSource, it says how far loading has got", func() {
+			ds := &loadingDataSource{
+				fixedDataSource: fixedDataSource(time.Now()),
+				readiness: db.Readiness{
+					LoadedDays: 2,
+					TotalDays:  5,
+					LoadedFrom: time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC),
+				},
+			}
+			server.SetDataSource(ds)
+
+			resp, readiness := getReady()
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(resp.Header.Get("Retry-After"), ShouldEqual, strconv.Itoa(notLoadedRetryAfter))
+			So(readiness, ShouldResemble, Readiness{Indexes: map[string]IndexReadiness{
+				"": {LoadedDays: 2, TotalDays: 5, LoadedFrom: "2024-06-09"},
+			}})
+
+			ds.readiness = db.Readiness{LoadedDays: 2, TotalDays: 5, Err: errors.New("disk on fire")}
+
+			resp, readiness = getReady()
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(readiness.Indexes[""].Error, ShouldEqual, "disk on fire")
+
+			ds.readiness = db.Readiness{Ready: true}
+
+			resp, readiness = getReady()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(readiness, ShouldResemble, Readiness{Ready: true, Indexes: map[string]IndexReadiness{
+				"": {Ready: true},
+			}})
+		})
+	})
+}
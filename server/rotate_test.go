@@ -0,0 +1,103 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRotatingFile(t *testing.T) {
+	Convey("Given a RotatingFile", t, func() {
+		path := filepath.Join(t.TempDir(), "access.log")
+
+		r, err := NewRotatingFile(path, 10, 2)
+		So(err, ShouldBeNil)
+
+		defer r.Close()
+
+		read := func(p string) string {
+			content, errr := os.ReadFile(p)
+			So(errr, ShouldBeNil)
+
+			return string(content)
+		}
+
+		write := func(s string) {
+			n, errw := r.Write([]byte(s))
+			So(errw, ShouldBeNil)
+			So(n, ShouldEqual, len(s))
+		}
+
+		Convey("writes are appended until they would exceed the max size", func() {
+			write("12345")
+			write("6789")
+			So(read(path), ShouldEqual, "123456789")
+
+			write("ab")
+			So(read(path), ShouldEqual, "ab")
+			So(read(path+".1"), ShouldEqual, "123456789")
+
+			write("0123456789xyz")
+			So(read(path), ShouldEqual, "0123456789xyz")
+			So(read(path+".1"), ShouldEqual, "ab")
+			So(read(path+".2"), ShouldEqual, "123456789")
+
+			write("c")
+			So(read(path), ShouldEqual, "c")
+			So(read(path+".1"), ShouldEqual, "0123456789xyz")
+			So(read(path+".2"), ShouldEqual, "ab")
+
+			_, err = os.Stat(path + ".3")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("existing files are appended to", func() {
+			write("12345")
+			So(r.Close(), ShouldBeNil)
+
+			r, err = NewRotatingFile(path, 10, 0)
+			So(err, ShouldBeNil)
+
+			write("678")
+			So(read(path), ShouldEqual, "12345678")
+
+			write("abc")
+			So(read(path), ShouldEqual, "abc")
+
+			_, err = os.Stat(path + ".1")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+
+	Convey("You can't make a RotatingFile in a non-existent directory", t, func() {
+		_, err := NewRotatingFile("/non/existent/access.log", 10, 1)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,379 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	scrollIDPrefix         = "farmer_page_"
+	scrollIDBytes          = 16
+	scrollIDParam          = "scroll_id"
+	scrollKeepAliveParam   = "scroll"
+	defaultScrollKeepAlive = time.Minute
+	maxScrollKeepAlive     = time.Hour
+	maxPagedScrolls        = 100
+
+	scrollMissingErrorType = "search_context_missing_exception"
+	scrollLimitErrorType   = "too_many_scroll_contexts_exception"
+
+	msgTooManyScrolls = "too many open scrolls"
+)
+
+// pagedScroll is the state of a scroll search being returned a page at a
+// time.
+type pagedScroll struct {
+	mu       sync.Mutex
+	sc       SearchScroller
+	result   *es.Result
+	desired  es.Fields
+	pageSize int
+	next     int
+	timer    *time.Timer
+	closed   bool
+}
+
+// pagedScrolls holds the pagedScrolls that clients haven't finished with,
+// keyed on their scroll ids.
+type pagedScrolls struct {
+	max  int
+	mu   sync.Mutex
+	open map[string]*pagedScroll
+}
+
+// scrollRequest is the body of a /_search/scroll request. ScrollID is a string
+// for POST requests, and a string or array of strings for DELETE requests.
+type scrollRequest struct {
+	ScrollID json.RawMessage `json:"scroll_id"`
+	Scroll   string          `json:"scroll"`
+}
+
+// SetScrollPaging, if given true, makes us answer scroll searches like
+// elasticsearch does: with only the first page of hits (of the query's size,
+// or es.MaxSize) and a scroll id that must be sent to /_search/scroll to get
+// each subsequent page. Clients that can't accept all hits in one response
+// need this, but it's slower, since the full result is not cached, and held in
+// memory until the client has all the pages, DELETEs the scroll, or doesn't
+// ask for the next page within the requested scroll keep-alive time.
+func (s *Server) SetScrollPaging(enabled bool) {
+	if !enabled {
+		s.scrolls = nil
+
+		return
+	}
+
+	s.scrolls = &pagedScrolls{
+		max:  maxPagedScrolls,
+		open: make(map[string]*pagedScroll),
+	}
+}
+
+// startPagedScroll answers a scroll search query with its first page of hits.
+func (s *Server) startPagedScroll(w http.ResponseWriter, r *http.Request, query *es.Query) {
+	sc := s.farmOf(r).searchScroller()
+
+	result, err := sc.ScrollResult(query)
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	id, err := s.scrolls.add(sc, result, query, scrollKeepAlive(r.URL.Query().Get(scrollKeepAliveParam)))
+	if err != nil || id == "" {
+		sc.Done(result.PoolKey)
+	}
+
+	switch {
+	case err != nil:
+		sendErrorToClient(w, err)
+
+		return
+	case id == "":
+		sendElasticError(w, scrollLimitErrorType, msgTooManyScrolls, http.StatusTooManyRequests)
+
+		return
+	}
+
+	for _, warning := range query.Warnings() {
+		w.Header().Add("Warning", fmt.Sprintf("299 farmer %q", warning))
+	}
+
+	s.sendScrollPage(w, r, id, "")
+}
+
+// scrollKeepAlive parses an elasticsearch time value like "1m", returning
+// our default for invalid values and our max for ones larger than that.
+func scrollKeepAlive(value string) time.Duration {
+	keepAlive, err := time.ParseDuration(value)
+	if err != nil || keepAlive <= 0 {
+		return defaultScrollKeepAlive
+	}
+
+	return min(keepAlive, maxScrollKeepAlive)
+}
+
+// add stores the given result of the given query, got from the given
+// SearchScroller, returning a new scroll id to get pages of it. The result
+// will be released if it isn't scrolled within the keepAlive duration. Returns
+// a blank id if too many scrolls are open.
+func (p *pagedScrolls) add(sc SearchScroller, result *es.Result, query *es.Query,
+	keepAlive time.Duration) (string, error) {
+	id, err := newScrollID()
+	if err != nil {
+		return "", err
+	}
+
+	pageSize := query.Size
+	if pageSize <= 0 || pageSize > es.MaxSize {
+		pageSize = es.MaxSize
+	}
+
+	if result.HitSet == nil {
+		result.HitSet = &es.HitSet{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.open) >= p.max {
+		return "", nil
+	}
+
+	p.open[id] = &pagedScroll{
+		sc:       sc,
+		result:   result,
+		desired:  query.DesiredFields(),
+		pageSize: pageSize,
+		timer:    time.AfterFunc(keepAlive, func() { p.close(id) }),
+	}
+
+	return id, nil
+}
+
+// newScrollID returns a random, unguessable scroll id.
+func newScrollID() (string, error) {
+	b := make([]byte, scrollIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return scrollIDPrefix + hex.EncodeToString(b), nil
+}
+
+// page returns the JSON of the next page of hits of the scroll with the given
+// id, resetting its keep-alive timer if keepAlive is not blank. Returns false
+// if there's no such scroll. Once a page with no hits has been returned, the
+// scroll is closed.
+func (p *pagedScrolls) page(id, keepAlive string) ([]byte, bool, error) {
+	p.mu.Lock()
+	ps, ok := p.open[id]
+	p.mu.Unlock()
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	ps.mu.Lock()
+
+	if ps.closed {
+		ps.mu.Unlock()
+
+		return nil, false, nil
+	}
+
+	if keepAlive != "" {
+		ps.timer.Reset(scrollKeepAlive(keepAlive))
+	}
+
+	hits := ps.result.HitSet.Hits
+	start := ps.next
+	end := min(start+ps.pageSize, len(hits))
+	ps.next = end
+
+	page := &es.Result{
+		ScrollID: id,
+		Took:     ps.result.Took,
+		HitSet:   &es.HitSet{Total: ps.result.HitSet.Total, Hits: hits[start:end]},
+	}
+
+	jsonPage, err := page.MarshalFields(ps.desired)
+
+	ps.mu.Unlock()
+
+	if start == end {
+		p.close(id)
+	}
+
+	return jsonPage, true, err
+}
+
+// close releases the result of the scroll with the given id, returning false
+// if there's no such scroll.
+func (p *pagedScrolls) close(id string) bool {
+	p.mu.Lock()
+	ps, ok := p.open[id]
+	delete(p.open, id)
+	p.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.timer.Stop()
+	ps.closed = true
+	ps.sc.Done(ps.result.PoolKey)
+	ps.result = nil
+
+	return true
+}
+
+// closeAll closes all our open scrolls, releasing their results.
+func (p *pagedScrolls) closeAll() {
+	p.mu.Lock()
+	ids := make([]string, 0, len(p.open))
+
+	for id := range p.open {
+		ids = append(ids, id)
+	}
+
+	p.mu.Unlock()
+
+	for _, id := range ids {
+		p.close(id)
+	}
+}
+
+// pagedScroll handles /_search/scroll requests for scrolls we're paging,
+// returning false if the request isn't for one of those.
+func (s *Server) pagedScroll(w http.ResponseWriter, r *http.Request) bool {
+	if s.scrolls == nil {
+		return false
+	}
+
+	ids, keepAlive := scrollRequestIDs(r)
+	if len(ids) == 0 {
+		return false
+	}
+
+	if r.Method == http.MethodDelete {
+		freed := 0
+
+		for _, id := range ids {
+			if s.scrolls.close(id) {
+				freed++
+			}
+		}
+
+		sendJSONToClient(w, http.StatusOK, map[string]any{"succeeded": true, "num_freed": freed})
+
+		return true
+	}
+
+	s.sendScrollPage(w, r, ids[0], keepAlive)
+
+	return true
+}
+
+// scrollRequestIDs returns the scroll ids of ours, and keep-alive, in the body
+// or parameters of a /_search/scroll request.
+func scrollRequestIDs(r *http.Request) ([]string, string) {
+	var sr scrollRequest
+
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err == nil && len(body) > 0 {
+			json.Unmarshal(body, &sr) //nolint:errcheck
+		}
+	}
+
+	params := r.URL.Query()
+
+	keepAlive := sr.Scroll
+	if keepAlive == "" {
+		keepAlive = params.Get(scrollKeepAliveParam)
+	}
+
+	var candidates []string
+
+	var single string
+
+	switch {
+	case json.Unmarshal(sr.ScrollID, &single) == nil:
+		candidates = []string{single}
+	case json.Unmarshal(sr.ScrollID, &candidates) == nil:
+	default:
+		candidates = strings.Split(params.Get(scrollIDParam), ",")
+	}
+
+	ids := make([]string, 0, len(candidates))
+
+	for _, id := range candidates {
+		if strings.HasPrefix(id, scrollIDPrefix) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, keepAlive
+}
+
+// sendScrollPage responds with the next page of the scroll with the given id,
+// or a 404 if there's no such scroll.
+func (s *Server) sendScrollPage(w http.ResponseWriter, r *http.Request, id, keepAlive string) {
+	jsonPage, ok, err := s.scrolls.page(id, keepAlive)
+
+	switch {
+	case !ok:
+		sendElasticError(w, scrollMissingErrorType, "No search context found for id ["+id+"]", http.StatusNotFound)
+	case err != nil:
+		sendErrorToClient(w, err)
+	default:
+		s.setDataThroughHeader(w, r)
+		s.sendResult(w, r, jsonPage)
+	}
+}
+
+// sendElasticError responds with the given status and the JSON of an
+// elasticError.
+func sendElasticError(w http.ResponseWriter, errType, reason string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(elasticErrorJSON(errType, reason, status)) //nolint:errcheck
+}
@@ -0,0 +1,195 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+// doneCountingScroller is a SearchScroller that counts calls to Done().
+type doneCountingScroller struct {
+	SearchScroller
+	done atomic.Int32
+}
+
+func (d *doneCountingScroller) Done(poolKey int) bool {
+	d.done.Add(1)
+
+	return d.SearchScroller.Done(poolKey)
+}
+
+func TestScrollPaging(t *testing.T) {
+	Convey("scrollKeepAlive() parses elasticsearch time values", t, func() {
+		So(scrollKeepAlive("30s"), ShouldEqual, 30*time.Second)
+		So(scrollKeepAlive("60000ms"), ShouldEqual, time.Minute)
+		So(scrollKeepAlive(""), ShouldEqual, defaultScrollKeepAlive)
+		So(scrollKeepAlive("1x"), ShouldEqual, defaultScrollKeepAlive)
+		So(scrollKeepAlive("-1s"), ShouldEqual, defaultScrollKeepAlive)
+		So(scrollKeepAlive("48h"), ShouldEqual, maxScrollKeepAlive)
+	})
+
+	Convey("Given a server with scroll paging enabled", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		sc := &doneCountingScroller{SearchScroller: cq}
+		server := New(sc, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+		server.SetScrollPaging(true)
+
+		serve := func(req *http.Request) (*http.Response, *es.Result) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			if resp.StatusCode != http.StatusOK {
+				return resp, nil
+			}
+
+			result := es.NewResult()
+			So(result.UnmarshalJSON(w.Body.Bytes()), ShouldBeNil)
+
+			return resp, result
+		}
+
+		scroll := func(method, body string) (*http.Response, *es.Result) {
+			return serve(httptest.NewRequest(method, "/"+es.SearchPage+"/"+scrollPage, strings.NewReader(body)))
+		}
+
+		req, expectedNumHits := mock.ScrollQuery("?scroll=1m")
+		resp, result := serve(req)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(result.ScrollID, ShouldStartWith, scrollIDPrefix)
+		So(result.HitSet.Total.Value, ShouldEqual, expectedNumHits)
+		So(len(result.HitSet.Hits), ShouldEqual, es.MaxSize)
+
+		id := result.ScrollID
+
+		Convey("you can get the rest of the hits a page at a time", func() {
+			numHits := len(result.HitSet.Hits)
+			body := `{"scroll":"1m","scroll_id":"` + id + `"}`
+
+			for {
+				resp, result = scroll(http.MethodPost, body)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(result.ScrollID, ShouldEqual, id)
+				So(result.HitSet.Total.Value, ShouldEqual, expectedNumHits)
+
+				if len(result.HitSet.Hits) == 0 {
+					break
+				}
+
+				numHits += len(result.HitSet.Hits)
+			}
+
+			So(numHits, ShouldEqual, expectedNumHits)
+			So(sc.done.Load(), ShouldEqual, 1)
+
+			resp, _ = scroll(http.MethodPost, body)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("scroll ids can also be given as a parameter", func() {
+			resp, result = serve(httptest.NewRequest(http.MethodGet,
+				"/"+es.SearchPage+"/"+scrollPage+"?scroll=1m&scroll_id="+id, nil))
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(len(result.HitSet.Hits), ShouldEqual, es.MaxSize)
+		})
+
+		Convey("DELETEing a scroll releases it", func() {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/"+es.SearchPage+"/"+scrollPage,
+				strings.NewReader(`{"scroll_id":["`+id+`","`+scrollIDPrefix+`unknown"]}`)))
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			var freed struct {
+				NumFreed int `json:"num_freed"`
+			}
+
+			So(json.Unmarshal(w.Body.Bytes(), &freed), ShouldBeNil)
+			So(freed.NumFreed, ShouldEqual, 1)
+			So(sc.done.Load(), ShouldEqual, 1)
+
+			resp, _ = scroll(http.MethodPost, `{"scroll_id":"`+id+`"}`)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("scrolls that aren't continued within their keep-alive are released", func() {
+			req, _ = mock.ScrollQuery("?scroll=1ms")
+			resp, result = serve(req)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			for i := 0; i < 100 && sc.done.Load() == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			So(sc.done.Load(), ShouldEqual, 1)
+
+			resp, _ = scroll(http.MethodPost, `{"scroll_id":"`+result.ScrollID+`"}`)
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("page size follows the query's size", func() {
+			req, _ = mock.ScrollQuery("?scroll=1m&size=100")
+			resp, result = serve(req)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(len(result.HitSet.Hits), ShouldEqual, 100)
+		})
+
+		Convey("too many open scrolls get a 429", func() {
+			server.scrolls.max = 3
+
+			for range 2 {
+				req, _ = mock.ScrollQuery("?scroll=1m")
+				resp, _ = serve(req)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			}
+
+			req, _ = mock.ScrollQuery("?scroll=1m")
+			resp, _ = serve(req)
+			So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		Convey("requests for scroll ids that aren't ours get the fake answer", func() {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+es.SearchPage+"/"+scrollPage,
+				strings.NewReader(`{"scroll_id":"farmer_scroll_id"}`)))
+			So(w.Body.String(), ShouldEqual, `{"_scroll_id":"farmer_scroll_id"}`)
+		})
+	})
+}
@@ -26,73 +26,322 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+	"github.com/wtsi-hgi/go-farmer/metrics"
 )
 
 const (
-	slash                = "/"
-	scrollPage           = "scroll"
-	getUsernamesEndpoint = "get_usernames"
+	slash                      = "/"
+	scrollPage                 = "scroll"
+	getUsernamesEndpoint       = "get_usernames"
+	getAccountingNamesEndpoint = "get_accounting_names"
+	getBOMsEndpoint            = "get_boms"
+	multiScrollEndpoint        = "multi_scroll"
+	exportEndpoint             = "export"
+	metricsEndpoint            = "metrics"
+	exportColumnsParam         = "columns"
+	exportFormatParam          = "format"
+	exportFormatCSV            = "csv"
+	exportFormatTSV            = "tsv"
+	exportFormatArrow          = "arrow"
+	exportFormatParquet        = "parquet"
+	distinctCountsParam        = "counts"
 )
 
 // SearchScroller types have Search and Scroll functions for querying something
 // like elastic search. The Scroll will automatically get all hits in a single
-// scroll call. They return JSON of the results.
+// scroll call. They return JSON of the results, which may be gzip compressed
+// (see cache.IsGzipped()), except that ScrollOrStream can return a large Result
+// for us to stream instead.
 type SearchScroller interface {
 	Search(query *es.Query) ([]byte, error)
-	Scroll(query *es.Query) ([]byte, int, error)
+	ScrollOrStream(query *es.Query) ([]byte, *es.Result, int, error)
+	MultiScroll(queries []*es.Query) ([]byte, error)
 	Done(int) bool
-	Usernames(query *es.Query) ([]byte, error)
+	DistinctValues(query *es.Query, field string) ([]byte, error)
+	DistinctCounts(query *es.Query, field string) ([]byte, error)
+	Count(query *es.Query) ([]byte, error)
+	ScrollResult(query *es.Query) (*es.Result, error)
+	ScrollEach(query *es.Query, cb func(*es.Hit) error) error
+	Flush() int
+	Gzip(data []byte) ([]byte, error)
+	SlowQueries() []es.SlowQuery
+	Stats() cache.Stats
 }
 
 // Server is a http.Handler that pretends to be like an elastic search server,
 // but only handles what is required for the farmer's report.
 type Server struct {
-	mux http.Handler
-	sc  SearchScroller
+	mux     http.Handler
+	routes  *http.ServeMux
+	farm    *farm
+	farms   map[string]*farm
+	proxy   *httputil.ReverseProxy
+	targets *proxyFailover
+	timeout time.Duration
+	metrics []metrics.Writer
+	auth    *Auth
+	limiter *clientLimiter
+
+	reloader       Reloader
+	configReloader func() error
+	backfills      *backfillJobs
+	readOnly       bool
+	teamSummarizer TeamSummarizer
+	gpuReporter    GPUReporter
+	dashboard      http.Handler
+	dashboardOpen  bool
+	scrolls        *pagedScrolls
+	inFlight       inFlight
+	accessLog      *slog.Logger
+	queryRecorder  QueryRecorder
 }
 
 // New returns a Server, which is an http.Handler.
 //
 // It takes SearchScroller, such as a CachedQuerier, which will be used to get
 // the results of requested searches. Search requests are those sent to
-// "/index/_search".
+// "/index/_search". Use AddIndex() to also answer searches of other indexes.
 //
 // It takes proxyTarget, which should be the URL of the real elasticsearch
 // server, for which we will become a transparent proxy for all non-search
 // requests. (Except for /_search/scroll requests, which are handled by
-// returning some fixed results since we don't do real scolls.)
+// returning some fixed results since we don't do real scolls, unless you
+// SetScrollPaging().)
+//
+// Count requests sent to "/index/_count" are answered with the SearchScroller's
+// Count().
+//
+// Multi search requests sent to "/index/_msearch" have each of their searches
+// answered like a non-scroll search, unless they involve other indexes.
+//
+// "/index/_field_caps", "/index/_mapping" and "/_mapping" requests are answered
+// from es.Mappings() without the real elasticsearch.
+//
+// The unique USER_NAME, ACCOUNTING_NAME or BOM values of the hits matching a
+// search query body can be got from "/get_usernames", "/get_accounting_names"
+// and "/get_boms" respectively; the latter doesn't require the query to specify
+// a BOM.
+//
+// Several scroll searches can be done at once by POSTing a JSON array of query
+// bodies to "/multi_scroll", which returns a JSON array of their results, in
+// the same order.
+//
+// There is also a "/export" endpoint that takes the same query body as a
+// search, but returns all matching hits as CSV (or TSV with ?format=tsv). The
+// columns can be chosen with ?columns=A,B, otherwise the query's _source
+// fields, or all fields, are used. ?format=arrow or ?format=parquet (or an
+// Accept header of es.ArrowContentType or es.ParquetContentType) instead
+// returns an Arrow IPC stream or Parquet file of typed columns, for analytics
+// clients like pyarrow. Scroll searches asking for those formats are answered
+// the same way.
+//
+// Searches whose body has "profile": true have a "profile" section added to
+// their result, with an es.Profile of the time spent scanning indexes, reading
+// and deserializing hit data and marshalling JSON, and whether the result was
+// cached.
+//
+// Searches with ?explain_plan=true are not run; instead, if the index's
+// DataSource is an Explainer, the db.QueryPlan of the query is returned as
+// JSON, saying which day/BOM directories and index files would be looked at,
+// how many hits and bytes would be read, and whether the query can be answered
+// from the index alone.
+//
+// GET requests to "/metrics" return the metrics of anything you AddMetrics(),
+// in the Prometheus text exposition format, and GET requests to "/status"
+// return JSON saying how up to date the local database is; see
+// SetDataSource(). GET requests to "/readyz" return a 200 status once any
+// DataSources that are ReadinessSources have finished loading, and a 503
+// status before then, with JSON saying how far they've got.
+//
+// GET requests to "/report/team_summary?bom=X&from=YYYY-MM-DD&to=YYYY-MM-DD"
+// return JSON of each team's daily usage and waste of that BOM from anything
+// you SetTeamSummarizer(), and GET requests to
+// "/report/gpu?from=YYYY-MM-DD&to=YYYY-MM-DD" (optionally with a bom) return
+// JSON of the daily usage of gpu queues from anything you SetGPUReporter().
+//
+// If you EnableDashboard(), "/dashboard/" serves a web page summarising the
+// health of the server for operators.
+//
+// POST requests to "/admin/reload" and "/admin/flush-cache" make anything you
+// SetReloader() look for new data now, and empty the SearchScroller's cache,
+// respectively. POST requests to "/admin/reload-config" call anything you
+// SetConfigReloader(). POST requests to "/admin/backfill" start a backfill
+// using anything you SetBackfiller(), the progress of which can be got from
+// "/admin/backfill/<id>", unless you SetReadOnly(). GET requests to
+// "/admin/slow-queries" return the SearchScroller's SlowQueries() as JSON, and
+// GET requests to "/admin/cache" return its cache Stats() as JSON, keyed by
+// index. These need the credentials of one of the Auth's Admins or AdminTokens;
+// see SetAuth().
+//
+// JSON query results (and exports) are gzip compressed if the client's
+// Accept-Encoding allows it, using the SearchScroller's Gzip() if they weren't
+// already compressed, and are decompressed for clients that don't. Large scroll
+// results that the SearchScroller's ScrollOrStream() returns as a Result are
+// streamed in chunks as they're converted to JSON.
+//
+// The queries passed to the SearchScroller have the Context() of the request,
+// so that work can stop if the client goes away, or our SetTimeout() expires.
+//
+// GRPCServer() returns a gRPC server answering Search, Scroll and Usernames
+// calls the same way, which you can serve alongside us.
 //
 // To start a webserver, do something like:
 //
 //	s := New(sc, "index", &url.URL{Host: "domain:port", Scheme: "http"})
 //	http.ListenAndServe(80, s)
 func New(sc SearchScroller, index string, proxyTarget *url.URL) *Server {
+	targets := newProxyFailover(proxyTarget)
 	proxy := httputil.NewSingleHostReverseProxy(proxyTarget)
+	proxy.Transport = targets
 
 	mux := http.NewServeMux()
 	s := &Server{
-		mux: mux,
-		sc:  sc,
+		mux:     mux,
+		routes:  mux,
+		farm:    newFarm(index, sc),
+		farms:   make(map[string]*farm),
+		proxy:   proxy,
+		targets: targets,
 	}
 
-	mux.HandleFunc(slash+url.QueryEscape(index)+slash+es.SearchPage, s.search)
+	s.handleIndex(mux, index, s.farm)
+	mux.HandleFunc(slash+es.MappingPage, mappingHandler(index))
 	mux.HandleFunc(slash+es.SearchPage+slash+scrollPage, s.fakeScroll)
-	mux.HandleFunc(slash+getUsernamesEndpoint, s.usernames)
+	mux.HandleFunc(slash+getUsernamesEndpoint, s.distinctValues(usernameField))
+	mux.HandleFunc(slash+getAccountingNamesEndpoint, s.distinctValues("ACCOUNTING_NAME"))
+	mux.HandleFunc(slash+getBOMsEndpoint, s.distinctValues("BOM"))
+	mux.HandleFunc(slash+multiScrollEndpoint, s.multiScroll)
+	mux.HandleFunc(slash+exportEndpoint, s.export)
+	mux.HandleFunc(slash+metricsEndpoint, s.writeMetrics)
+	mux.HandleFunc(slash+statusEndpoint, s.status)
+	mux.HandleFunc(slash+teamSummaryEndpoint, s.teamSummary)
+	mux.HandleFunc(slash+gpuReportEndpoint, s.gpuReport)
+	mux.HandleFunc(slash+readyEndpoint, s.readyz)
+	mux.HandleFunc(slash+dashboardEndpoint, s.dashboardPage)
+	mux.HandleFunc(slash+dashboardOverviewEndpoint, s.dashboardOverview)
+	mux.HandleFunc(slash+adminReloadEndpoint, s.adminReload)
+	mux.HandleFunc(slash+adminFlushCacheEndpoint, s.adminFlushCache)
+	mux.HandleFunc(slash+adminSlowQueriesEndpoint, s.adminSlowQueries)
+	mux.HandleFunc(slash+adminCacheEndpoint, s.adminCache)
+	mux.HandleFunc(slash+adminReloadConfigEndpoint, s.adminReloadConfig)
+	mux.HandleFunc(slash+adminBackfillEndpoint, s.adminBackfill)
+	mux.HandleFunc(slash+adminBackfillEndpoint+slash, s.adminBackfill)
 	mux.Handle(slash, proxy)
 
 	return s
 }
 
+// SetSearchScroller atomically replaces the SearchScroller we were made with,
+// eg. with one that has a differently sized cache or new elasticsearch
+// credentials. Requests already in progress finish using the old one.
+func (s *Server) SetSearchScroller(sc SearchScroller) {
+	s.farm.setSearchScroller(sc)
+}
+
+// SetTimeout makes all subsequent requests time out after the given duration,
+// cancelling any work being done to answer them and returning a 504 status.
+// The default of 0 means requests are only cancelled if the client goes away.
+func (s *Server) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// SetProxyTransport makes requests we proxy to the real elasticsearch use the
+// given transport, eg. one from es.Config.HTTPTransport() that trusts the
+// server's TLS certificate.
+func (s *Server) SetProxyTransport(transport http.RoundTripper) {
+	s.targets.transport = transport
+}
+
+// SetProxyTargets makes requests we proxy go to the first of the given real
+// elasticsearch servers that hasn't recently failed to respond, instead of the
+// proxyTarget we were made with. Requests without a body that a server fails
+// to respond to are sent to the next one. Only the scheme and host of the
+// targets are used; the path of the proxyTarget is still prepended.
+func (s *Server) SetProxyTargets(targets ...*url.URL) {
+	s.targets.setTargets(targets)
+}
+
+// AddMetrics makes our "/metrics" endpoint include the metrics of the given
+// Writers, eg. an es.Client's Metrics().
+func (s *Server) AddMetrics(writers ...metrics.Writer) {
+	s.metrics = append(s.metrics, writers...)
+}
+
+// writeMetrics handles /metrics requests by writing out the metrics of
+// everything passed to AddMetrics().
+func (s *Server) writeMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(http.StatusOK)
+
+	if err := metrics.WriteAll(w, s.metrics...); err != nil {
+		slog.Error("write to client failed", "err", err)
+	}
+}
+
 // ServeHTTP handles search requests using our SearchScroller. Everything else
 // just returns OK.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.mux.ServeHTTP(w, r)
+	if s.accessLog != nil || s.queryRecorder != nil {
+		s.serveHTTPWithAccessLog(w, r)
+
+		return
+	}
+
+	s.serveHTTP(w, r)
+}
+
+// serveHTTP does the work of ServeHTTP().
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.checkNotDraining(w) {
+		return
+	}
+
+	defer s.inFlight.wg.Done()
+
+	identity, ok := s.checkAuth(w, r)
+	noteIdentity(r, identity)
+
+	if !ok {
+		return
+	}
+
+	release, ok := s.checkRateLimit(w, r, identity)
+	if !ok {
+		return
+	}
+
+	defer release()
+
+	if s.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+		defer cancel()
+
+		r = r.WithContext(ctx)
+	}
+
+	s.mux.ServeHTTP(w, withIdentity(r, identity))
 }
 
 func sendMessageToClient(w http.ResponseWriter, msg string) {
@@ -101,9 +350,81 @@ func sendMessageToClient(w http.ResponseWriter, msg string) {
 	}
 }
 
+// sendJSONToClient responds with the given value as JSON, with the given
+// status.
+func sendJSONToClient(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("write to client failed", "err", err)
+	}
+}
+
+// sendErrorToClient responds with the given error, using a 504 status if it was
+// due to our timeout, a 400 status if the query was too large, a 503 status if
+// elastic search is unavailable, the database is shutting down, or the days
+// queried are still being loaded (with a Retry-After header), or a 500 status
+// otherwise.
+func sendErrorToClient(w http.ResponseWriter, err error) {
+	w.Header().Del("ETag")
+
+	var dbErr db.Error
+	if errors.As(err, &dbErr) && dbErr.Msg == db.ErrNotLoaded {
+		setNotLoadedRetryAfter(w)
+	}
+
+	w.WriteHeader(errorStatus(err))
+	sendMessageToClient(w, err.Error())
+}
+
+// isBadRequest returns true if the given db Error is the fault of the query.
+func isBadRequest(err db.Error) bool {
+	switch err.Msg {
+	case db.ErrQueryTooLarge, db.ErrBOMNotStored, db.ErrClusterNotStored,
+		db.ErrUnsupportedPatternField, db.ErrPatternDistinctBOMs,
+		db.ErrDistinctCountsBOM, db.ErrTeamSummaryRange, db.ErrGPUReportRange:
+		return true
+	}
+
+	return false
+}
+
+// isBadQuery returns true if the given elasticsearch package error is due to
+// the query being invalid or using features we don't support.
+func isBadQuery(err es.Error) bool {
+	switch err.Msg {
+	case es.ErrBadDate, es.ErrUnsupportedRange, es.ErrBadPattern, es.ErrUnsupportedSort:
+		return true
+	}
+
+	return false
+}
+
+// errorStatus returns the http status code sendErrorToClient() would use for
+// the given error.
+func errorStatus(err error) int {
+	var (
+		dbErr db.Error
+		esErr es.Error
+	)
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &dbErr) && isBadRequest(dbErr), errors.As(err, &esErr) && isBadQuery(esErr):
+		return http.StatusBadRequest
+	case es.IsUnavailable(err), errors.As(err, &dbErr) && (dbErr.Msg == db.ErrDraining || dbErr.Msg == db.ErrNotLoaded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // search handles /index/_search requests which are for aggregation queries, and
 // also for ?scroll searches which we will auto-scroll without the use of the
-// /_search/scroll endpoint.
+// /_search/scroll endpoint. With ?explain_plan=true, responds with the query's
+// plan instead.
 func (s *Server) search(w http.ResponseWriter, r *http.Request) {
 	query, ok := es.NewQuery(r)
 	if !ok {
@@ -112,7 +433,31 @@ func (s *Server) search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsonResult, deferFunc, ok := s.handleQuery(w, query)
+	noteQueries(r, query)
+
+	if wantsExplainPlan(r) {
+		s.explainPlan(w, r, query)
+
+		return
+	}
+
+	if format := columnarFormat(r); format != "" && query.IsScroll() {
+		s.exportHits(w, r, query, format)
+
+		return
+	}
+
+	if query.IsScroll() && s.scrolls != nil {
+		s.startPagedScroll(w, r, query)
+
+		return
+	}
+
+	if query.IsScroll() && s.notModified(w, r, query.Key()) {
+		return
+	}
+
+	jsonResult, result, deferFunc, ok := s.handleQuery(w, r, query)
 
 	defer deferFunc()
 
@@ -120,45 +465,126 @@ func (s *Server) search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	for _, warning := range query.Warnings() {
+		w.Header().Add("Warning", fmt.Sprintf("299 farmer %q", warning))
+	}
 
-	_, err := w.Write(jsonResult)
-	if err != nil {
-		slog.Error("write to client failed", "err", err)
+	s.setDataThroughHeader(w, r)
+
+	if result != nil {
+		streamResult(w, r, result, query)
+
+		return
+	}
+
+	if query.Profiling() {
+		s.sendProfiledResult(w, r, jsonResult, query)
+
+		return
 	}
+
+	s.sendResult(w, r, jsonResult)
 }
 
-func (s *Server) handleQuery(w http.ResponseWriter, query *es.Query) ([]byte, func(), bool) {
+// handleQuery returns the JSON result of the query, or for large scroll
+// results, the Result to stream.
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request, query *es.Query) ([]byte, *es.Result, func(), bool) {
 	var (
 		jsonResult []byte
+		result     *es.Result
 		poolKey    int
 		err        error
 	)
 
 	deferFunc := func() {}
 
+	sc := s.farmOf(r).searchScroller()
+
 	if query.IsScroll() {
-		jsonResult, poolKey, err = s.sc.Scroll(query)
+		jsonResult, result, poolKey, err = sc.ScrollOrStream(query)
 		deferFunc = func() {
-			s.sc.Done(poolKey)
+			sc.Done(poolKey)
 		}
 	} else {
-		jsonResult, err = s.sc.Search(query)
+		jsonResult, err = sc.Search(query)
 	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		sendMessageToClient(w, err.Error())
+		sendErrorToClient(w, err)
 
-		return nil, deferFunc, false
+		return nil, nil, deferFunc, false
 	}
 
-	return jsonResult, deferFunc, true
+	return jsonResult, result, deferFunc, true
 }
 
-// fakeScroll handles unneeded requests to the /_search/scroll endpoint.
+// count handles /index/_count requests, which we answer like elasticsearch
+// would, but using our SearchScroller's Count().
+func (s *Server) count(w http.ResponseWriter, r *http.Request) {
+	query, ok := es.NewCountQuery(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	noteQueries(r, query)
+
+	if s.notModified(w, r, query.Key()) {
+		return
+	}
+
+	jsonCount, err := s.farmOf(r).searchScroller().Count(query)
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	s.setDataThroughHeader(w, r)
+	s.sendResult(w, r, jsonCount)
+}
+
+// multiScroll handles /multi_scroll requests, which contain an array of search
+// queries that we treat as scroll searches, returning an array of their
+// results.
+func (s *Server) multiScroll(w http.ResponseWriter, r *http.Request) {
+	queries, ok := es.NewScrollQueries(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	noteQueries(r, queries...)
+
+	keys := make([]string, len(queries))
+	for i, query := range queries {
+		keys[i] = query.Key()
+	}
+
+	if s.notModified(w, r, keys...) {
+		return
+	}
+
+	jsonResults, err := s.farmOf(r).searchScroller().MultiScroll(queries)
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	s.setDataThroughHeader(w, r)
+	s.sendResult(w, r, jsonResults)
+}
+
+// fakeScroll handles unneeded requests to the /_search/scroll endpoint, or
+// those for scrolls we're paging if SetScrollPaging() was used.
 func (s *Server) fakeScroll(w http.ResponseWriter, r *http.Request) {
+	if s.pagedScroll(w, r) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
@@ -173,10 +599,63 @@ func (s *Server) fakeScroll(w http.ResponseWriter, r *http.Request) {
 	sendMessageToClient(w, msg)
 }
 
-// usernames handles /get_usernames requests which are treated like scroll
-// search requests, but we only return an array of unique usernames found in the
-// result.
-func (s *Server) usernames(w http.ResponseWriter, r *http.Request) {
+// distinctValues returns a handler for /get_usernames (and similar) requests,
+// which are treated like scroll search requests, but we only return an array of
+// the unique values of the given field found in the result. With ?counts=true
+// we instead return an object of each value to the number of hits that have
+// it.
+func (s *Server) distinctValues(field string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = es.SearchPage
+
+		query, ok := es.NewQuery(r)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		noteQueries(r, query)
+
+		if s.notModified(w, r, field, query.Key()) {
+			return
+		}
+
+		sc := s.farmOf(r).searchScroller()
+
+		var (
+			jsonStrs []byte
+			err      error
+		)
+
+		if wantsDistinctCounts(r) {
+			jsonStrs, err = sc.DistinctCounts(query, field)
+		} else {
+			jsonStrs, err = sc.DistinctValues(query, field)
+		}
+
+		if err != nil {
+			sendErrorToClient(w, err)
+
+			return
+		}
+
+		s.setDataThroughHeader(w, r)
+		s.sendResult(w, r, jsonStrs)
+	}
+}
+
+// wantsDistinctCounts returns true if the request has a true counts parameter.
+func wantsDistinctCounts(r *http.Request) bool {
+	counts, err := strconv.ParseBool(r.URL.Query().Get(distinctCountsParam))
+
+	return err == nil && counts
+}
+
+// export handles /export requests which are treated like scroll search
+// requests, but we stream the hits back as CSV or TSV rows, or as Arrow record
+// batches or a Parquet file.
+func (s *Server) export(w http.ResponseWriter, r *http.Request) {
 	r.URL.Path = es.SearchPage
 
 	query, ok := es.NewQuery(r)
@@ -186,19 +665,188 @@ func (s *Server) usernames(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsonStrs, err := s.sc.Usernames(query)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	noteQueries(r, query)
+
+	s.exportHits(w, r, query, exportFormat(r))
+}
+
+// exportHits streams all the hits of the given scroll query in the given
+// export format, with the columns given by exportColumns().
+func (s *Server) exportHits(w http.ResponseWriter, r *http.Request, query *es.Query, format string) {
+	columns := exportColumns(r, query)
+
+	if err := es.ValidateColumns(columns); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		sendMessageToClient(w, err.Error())
 
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	query.Source, query.SourceExcludes = columns, nil
+
+	if s.notModified(w, r, exportEndpoint, format, query.Key()) {
+		return
+	}
+
+	var (
+		hw      es.HitWriter
+		started bool
+		finish  = func() {}
+	)
+
+	defer func() { finish() }()
+
+	// we only start the response once we have the first hit (or know there
+	// are none), so that query errors can still get an error status
+	start := func() error {
+		started = true
+
+		var out io.Writer
+
+		out, finish = s.startExport(w, r, format)
+
+		var err error
+
+		hw, err = newHitWriter(out, columns, format)
+
+		return err
+	}
+
+	err := s.farmOf(r).searchScroller().ScrollEach(query, func(hit *es.Hit) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		return hw.WriteHit(hit)
+	})
+
+	if !started {
+		if err != nil {
+			sendErrorToClient(w, err)
+
+			return
+		}
+
+		err = start()
+	}
+
+	if err == nil {
+		err = hw.Close()
+	}
 
-	_, err = w.Write(jsonStrs)
 	if err != nil {
 		slog.Error("write to client failed", "err", err)
 	}
 }
+
+// startExport writes the headers of a successful export response in the given
+// format, returning the writer to write the export to (gzip compressed if the
+// client accepts it and the format isn't already compressed), and a func to
+// call once it has been written.
+func (s *Server) startExport(w http.ResponseWriter, r *http.Request, format string) (io.Writer, func()) {
+	s.setDataThroughHeader(w, r)
+	w.Header().Set("Content-Type", exportContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="export.`+format+`"`)
+
+	out, finish := io.Writer(w), func() {}
+
+	if format != exportFormatParquet {
+		out, _, finish = gzipWriterIfAccepted(w, r)
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+
+	return out, finish
+}
+
+// exportColumns returns the columns requested with the columns parameter,
+// falling back on the query's _source, falling back on all fields.
+func exportColumns(r *http.Request, query *es.Query) []string {
+	if param := r.URL.Query().Get(exportColumnsParam); param != "" {
+		return strings.Split(param, ",")
+	}
+
+	if fields := query.SelectedSourceFields(); len(fields) > 0 {
+		return fields
+	}
+
+	return append([]string{"_id"}, es.SourceFields()...)
+}
+
+// exportFormat returns the format requested with the format parameter, falling
+// back on an Arrow or Parquet format the Accept header asks for, falling back
+// on CSV.
+func exportFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get(exportFormatParam); format {
+	case exportFormatTSV, exportFormatArrow, exportFormatParquet:
+		return format
+	}
+
+	if format := acceptedColumnarFormat(r); format != "" {
+		return format
+	}
+
+	return exportFormatCSV
+}
+
+// columnarFormat returns the Arrow or Parquet format that a search request asks
+// for with the format parameter or Accept header, or "" if it wants JSON.
+func columnarFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get(exportFormatParam); format {
+	case exportFormatArrow, exportFormatParquet:
+		return format
+	}
+
+	return acceptedColumnarFormat(r)
+}
+
+// acceptedColumnarFormat returns the first of the Arrow or Parquet formats
+// listed in the request's Accept header, or "" if neither is.
+func acceptedColumnarFormat(r *http.Request) string {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+
+			switch strings.TrimSpace(mediaType) {
+			case es.ArrowContentType:
+				return exportFormatArrow
+			case es.ParquetContentType:
+				return exportFormatParquet
+			}
+		}
+	}
+
+	return ""
+}
+
+// exportContentType returns the Content-Type of the given export format.
+func exportContentType(format string) string {
+	switch format {
+	case exportFormatTSV:
+		return "text/tab-separated-values"
+	case exportFormatArrow:
+		return es.ArrowContentType
+	case exportFormatParquet:
+		return es.ParquetContentType
+	default:
+		return "text/csv"
+	}
+}
+
+// newHitWriter returns a HitWriter that writes hits with the given columns to
+// the given writer in the given export format.
+func newHitWriter(w io.Writer, columns []string, format string) (es.HitWriter, error) {
+	switch format {
+	case exportFormatTSV:
+		return es.NewDelimitedWriter(w, columns, '\t')
+	case exportFormatArrow:
+		return es.NewArrowWriter(w, columns)
+	case exportFormatParquet:
+		return es.NewParquetWriter(w, columns)
+	default:
+		return es.NewDelimitedWriter(w, columns, ',')
+	}
+}
@@ -27,6 +27,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -35,9 +36,15 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/parquet/file"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
 	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
 )
 
@@ -59,9 +66,43 @@ func newMockScroller(index string) *mockScroller {
 }
 
 func (m *mockScroller) Scroll(query *es.Query) (*es.Result, error) {
+	if err := query.Context().Err(); err != nil {
+		return nil, err
+	}
+
 	return m.Mock.Scroll(query, nil)
 }
 
+// unavailableSearcher is a cache.Searcher whose circuit breaker has tripped.
+type unavailableSearcher struct{}
+
+func (unavailableSearcher) Search(*es.Query) (*es.Result, error) {
+	return nil, es.Error{Msg: es.ErrCircuitOpen}
+}
+
+// localScroller is a mockScroller that is also a cache.LocalAggregator.
+type localScroller struct {
+	*mockScroller
+}
+
+func (l *localScroller) AggregateLocalOnly(query *es.Query) (*es.Result, bool, error) {
+	result, err := l.Search(query)
+
+	return result, true, err
+}
+
+// countingTransport is an http.RoundTripper that counts the requests it makes
+// with http.DefaultTransport.
+type countingTransport struct {
+	n int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n++
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
 func TestServer(t *testing.T) {
 	Convey("Given a server", t, func() {
 		urlStr := "http://host:1234/"
@@ -90,6 +131,17 @@ func TestServer(t *testing.T) {
 			_, err := content.ReadFrom(resp.Body)
 			So(err, ShouldBeNil)
 			So(content.String(), ShouldEqual, "a real elasticsearch response")
+
+			Convey("using the transport you set", func() {
+				transport := &countingTransport{}
+				server.SetProxyTransport(transport)
+
+				w = httptest.NewRecorder()
+				server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, urlStr, nil))
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(transport.n, ShouldEqual, 1)
+			})
 		})
 
 		Convey("and an invalid search request, server returns Bad Request", func() {
@@ -109,6 +161,8 @@ func TestServer(t *testing.T) {
 
 			resp = w.Result()
 			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+			So(errorStatus(es.Error{Msg: es.ErrBadDate}), ShouldEqual, http.StatusBadRequest)
 		})
 
 		Convey("and a valid aggregation search request, server returns agg results", func() {
@@ -122,7 +176,7 @@ func TestServer(t *testing.T) {
 
 			data, err := io.ReadAll(resp.Body)
 			So(err, ShouldBeNil)
-			So(len(data), ShouldEqual, 240)
+			So(len(data), ShouldEqual, 256)
 			resp.Body.Close()
 
 			result, err := cache.Decode(data)
@@ -132,6 +186,24 @@ func TestServer(t *testing.T) {
 			So(len(result.Aggregations.Stats.Buckets), ShouldEqual, 6)
 		})
 
+		Convey("and elastic search unavailable, aggregation searches get local-only results with a warning", func() {
+			cq, err = cache.New(unavailableSearcher{}, &localScroller{mock}, 1)
+			So(err, ShouldBeNil)
+
+			server = New(cq, index, &url.URL{Host: strings.TrimPrefix(mockReal.URL, "http://"), Scheme: "http"})
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, mock.AggQuery())
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Warning"), ShouldEqual, `299 farmer "`+cache.WarnLocalOnly+`"`)
+
+			result, err := cache.Decode(w.Body.Bytes())
+			So(err, ShouldBeNil)
+			So(len(result.Aggregations.Stats.Buckets), ShouldEqual, 6)
+		})
+
 		Convey("and a valid scrolling search request, server returns all scroll hits", func() {
 			req, _ := mock.ScrollQuery("")
 			w := httptest.NewRecorder()
@@ -197,6 +269,186 @@ func TestServer(t *testing.T) {
 			So(string(bodyBytes), ShouldEqual, `{"succeeded":true,"num_freed":0}`)
 		})
 
+		Convey("and a valid count request, server returns the count", func() {
+			req, expectedNumHits := mock.ScrollQuery("")
+			req.URL.Path = strings.Replace(req.URL.Path, es.SearchPage, es.CountPage, 1)
+
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			data, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			countResult := &es.CountResult{}
+
+			err = json.Unmarshal(data, countResult)
+			So(err, ShouldBeNil)
+			So(countResult.Count, ShouldEqual, expectedNumHits)
+			So(countResult.Shards.Successful, ShouldEqual, 1)
+
+			req = httptest.NewRequest(http.MethodGet, req.URL.String(), nil)
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("and a valid multi_scroll request, server returns all hits for each query", func() {
+			req, _ := mock.ScrollQuery("")
+			body, err := io.ReadAll(req.Body)
+			So(err, ShouldBeNil)
+
+			req = httptest.NewRequest(http.MethodPost, slash+multiScrollEndpoint+"?_source=USER_NAME",
+				strings.NewReader("["+string(body)+","+string(body)+"]"))
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			data, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			var raws []json.RawMessage
+
+			err = json.Unmarshal(data, &raws)
+			So(err, ShouldBeNil)
+			So(len(raws), ShouldEqual, 2)
+
+			for _, raw := range raws {
+				result, errd := cache.Decode(raw)
+				So(errd, ShouldBeNil)
+				So(len(result.HitSet.Hits), ShouldEqual, 2)
+				So(result.HitSet.Hits[0].Details.UserName, ShouldEqual, "pathpipe")
+			}
+
+			req = httptest.NewRequest(http.MethodPost, slash+multiScrollEndpoint, strings.NewReader(string(body)))
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("and a valid export request, server returns CSV or TSV rows", func() {
+			req, _ := mock.ScrollQuery("?columns=USER_NAME,QUEUE_NAME")
+			req.URL.Path = slash + exportEndpoint
+
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/csv")
+
+			data, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			So(string(data), ShouldEqual, "USER_NAME,QUEUE_NAME\npathpipe,transfer\nu2,q2\n")
+
+			req, _ = mock.ScrollQuery("?_source=USER_NAME&format=tsv")
+			req.URL.Path = slash + exportEndpoint
+
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp = w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/tab-separated-values")
+
+			data, err = io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			So(string(data), ShouldStartWith, "USER_NAME\npathpipe\n")
+
+			req, _ = mock.ScrollQuery("?columns=USER_NAME,foo")
+			req.URL.Path = slash + exportEndpoint
+
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp = w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("and an export or scroll search asking for Arrow or Parquet, server returns typed columns", func() {
+			req, _ := mock.ScrollQuery("?columns=USER_NAME,RUN_TIME_SEC&format=arrow")
+			req.URL.Path = slash + exportEndpoint
+
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, es.ArrowContentType)
+
+			reader, err := ipc.NewReader(resp.Body)
+			So(err, ShouldBeNil)
+			So(reader.Schema().Field(1).Type.ID(), ShouldEqual, arrow.INT64)
+			So(reader.Next(), ShouldBeTrue)
+			So(reader.Record().NumRows(), ShouldEqual, 2)
+			So(reader.Record().Column(0).(*array.String).Value(0), ShouldEqual, "pathpipe")
+			reader.Release()
+
+			req, _ = mock.ScrollQuery("?scroll=1m&_source=USER_NAME")
+			req.Header.Set("Accept", "application/json;q=0.5, "+es.ArrowContentType)
+
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp = w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, es.ArrowContentType)
+
+			reader, err = ipc.NewReader(resp.Body)
+			So(err, ShouldBeNil)
+			So(reader.Schema().NumFields(), ShouldEqual, 1)
+			So(reader.Next(), ShouldBeTrue)
+			So(reader.Record().Column(0).(*array.String).Value(1), ShouldEqual, "u2")
+			reader.Release()
+
+			req, _ = mock.ScrollQuery("?scroll=1m&_source=USER_NAME&format=parquet")
+
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp = w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, es.ParquetContentType)
+
+			data, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			pf, err := file.NewParquetReader(bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			So(pf.NumRows(), ShouldEqual, 2)
+			pf.Close()
+
+			req, _ = mock.ScrollQuery("?scroll=1m&columns=foo&format=arrow")
+
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
 		Convey("and a valid get_usernames request, server returns all users", func() {
 			req, _ := mock.ScrollQuery("?scroll=1m")
 			req.URL.Path = slash + getUsernamesEndpoint
@@ -222,5 +474,112 @@ func TestServer(t *testing.T) {
 			expected := []string{"u", "u1", "u2"}
 			So(usernames, ShouldResemble, expected)
 		})
+
+		Convey("and a get_usernames request with counts, server returns job counts per user", func() {
+			req, _ := mock.ScrollQuery("?scroll=1m&counts=true")
+			req.URL.Path = slash + getUsernamesEndpoint
+
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			data, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			var counts map[string]int
+
+			err = json.Unmarshal(data, &counts)
+			So(err, ShouldBeNil)
+			So(len(counts), ShouldEqual, 3)
+
+			total := 0
+			for _, n := range counts {
+				So(n, ShouldBeGreaterThan, 0)
+				total += n
+			}
+
+			_, expectedTotal := mock.ScrollQuery("")
+			So(total, ShouldEqual, expectedTotal)
+		})
+
+		Convey("and a timeout, requests that take too long return Gateway Timeout", func() {
+			server.SetTimeout(time.Nanosecond)
+
+			req, _ := mock.ScrollQuery("?scroll=1m")
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusGatewayTimeout)
+
+			server.SetTimeout(time.Minute)
+
+			req, _ = mock.ScrollQuery("?scroll=1m")
+			w = httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			resp = w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("and a metrics request, server returns the metrics you added", func() {
+			server.AddMetrics(mock.Metrics())
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, mock.AggQuery())
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			w = httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, urlStr+"metrics", nil))
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(w.Body.String(), ShouldContainSubstring, `farmer_elasticsearch_requests_total{op="search"} 1`)
+
+			w = httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, urlStr+"metrics", nil))
+			So(w.Result().StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("errors are returned with an appropriate status", func() {
+			for _, test := range []struct {
+				err    error
+				status int
+			}{
+				{context.DeadlineExceeded, http.StatusGatewayTimeout},
+				{db.Error{Msg: db.ErrQueryTooLarge}, http.StatusBadRequest},
+				{db.Error{Msg: db.ErrBOMNotStored}, http.StatusBadRequest},
+				{db.Error{Msg: db.ErrClusterNotStored}, http.StatusBadRequest},
+				{db.Error{Msg: db.ErrDistinctCountsBOM}, http.StatusBadRequest},
+				{db.Error{Msg: db.ErrNoBOM}, http.StatusInternalServerError},
+				{es.Error{Msg: es.ErrCircuitOpen}, http.StatusServiceUnavailable},
+			} {
+				w := httptest.NewRecorder()
+				sendErrorToClient(w, test.err)
+				So(w.Result().StatusCode, ShouldEqual, test.status)
+				So(w.Body.String(), ShouldEqual, test.err.Error())
+			}
+		})
+
+		Convey("and a cancelled request, the SearchScroller sees its cancelled context", func() {
+			req, _ := mock.ScrollQuery("?scroll=1m")
+
+			ctx, cancel := context.WithCancel(req.Context())
+			cancel()
+
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req.WithContext(ctx))
+
+			resp := w.Result()
+			So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+		})
 	})
 }
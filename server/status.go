@@ -0,0 +1,113 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	statusEndpoint    = "status"
+	dataThroughHeader = "X-Farmer-Data-Through"
+	dataThroughFormat = time.DateOnly
+	hoursInDay        = 24
+)
+
+// DataSource types can tell you the latest day they have complete data for,
+// returning the zero time if they have none, and a DataVersion that changes
+// whenever their data does (or "" if they don't know it). db.Backends are
+// DataSources.
+type DataSource interface {
+	DataThrough() time.Time
+	DataVersion() string
+}
+
+// Status is the response to a /status request.
+type Status struct {
+	// DataThrough is the latest day (YYYY-MM-DD, UTC) the local database has
+	// complete data for, or empty if it has none.
+	DataThrough string `json:"data_through"`
+
+	// DaysBehind is how many days before yesterday DataThrough is, so 0 if the
+	// local database is up to date, or -1 if it has no data.
+	DaysBehind int `json:"days_behind"`
+}
+
+// SetDataSource makes our /status endpoint, and an X-Farmer-Data-Through
+// header on responses to local queries, report how up to date the given
+// DataSource is. Responses to local queries also get an ETag based on the
+// query and the DataSource's DataVersion(), and conditional requests with a
+// matching If-None-Match get a 304 status without the query being run.
+func (s *Server) SetDataSource(ds DataSource) {
+	s.farm.dataSource = ds
+}
+
+// status handles /status requests by returning our Status as JSON.
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	sendJSONToClient(w, http.StatusOK, s.currentStatus(time.Now()))
+}
+
+// currentStatus returns the Status of our DataSource as of the given time.
+func (s *Server) currentStatus(now time.Time) Status {
+	status := Status{DaysBehind: -1}
+
+	through := dataThrough(s.farm.dataSource)
+	if through.IsZero() {
+		return status
+	}
+
+	status.DataThrough = through.Format(dataThroughFormat)
+
+	yesterday := now.UTC().Truncate(hoursInDay * time.Hour).Add(-hoursInDay * time.Hour)
+	status.DaysBehind = max(0, int(yesterday.Sub(through).Hours()/hoursInDay))
+
+	return status
+}
+
+// dataThrough returns the given DataSource's DataThrough(), or the zero time if
+// it is nil.
+func dataThrough(ds DataSource) time.Time {
+	if ds == nil {
+		return time.Time{}
+	}
+
+	return ds.DataThrough()
+}
+
+// setDataThroughHeader sets our X-Farmer-Data-Through header, if we know how
+// up to date the DataSource for the request's index is.
+func (s *Server) setDataThroughHeader(w http.ResponseWriter, r *http.Request) {
+	if through := dataThrough(s.farmOf(r).dataSource); !through.IsZero() {
+		w.Header().Set(dataThroughHeader, through.Format(dataThroughFormat))
+	}
+}
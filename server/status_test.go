@@ -0,0 +1,104 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+)
+
+type fixedDataSource time.Time
+
+func (f fixedDataSource) DataThrough() time.Time {
+	return time.Time(f)
+}
+
+func (f fixedDataSource) DataVersion() string {
+	return time.Time(f).Format(time.RFC3339)
+}
+
+func TestStatus(t *testing.T) {
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		getStatus := func() Status {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			var status Status
+
+			So(json.NewDecoder(w.Body).Decode(&status), ShouldBeNil)
+
+			return status
+		}
+
+		Convey("without a DataSource, the status says there's no data", func() {
+			So(getStatus(), ShouldResemble, Status{DaysBehind: -1})
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, mock.AggQuery())
+			So(w.Result().Header.Get(dataThroughHeader), ShouldBeEmpty)
+		})
+
+		Convey("with a DataSource, the status and query responses say how up to date it is", func() {
+			through := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -3)
+			server.SetDataSource(fixedDataSource(through))
+
+			So(getStatus(), ShouldResemble, Status{
+				DataThrough: through.Format(time.DateOnly),
+				DaysBehind:  2,
+			})
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, mock.AggQuery())
+			So(w.Result().Header.Get(dataThroughHeader), ShouldEqual, through.Format(time.DateOnly))
+
+			w = httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/status", nil))
+			So(w.Result().StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("a DataSource with yesterday's data isn't behind", func() {
+			now := time.Date(2024, 6, 10, 1, 0, 0, 0, time.UTC)
+			server.SetDataSource(fixedDataSource(time.Date(2024, 6, 9, 0, 0, 0, 0, time.UTC)))
+
+			So(server.currentStatus(now), ShouldResemble, Status{DataThrough: "2024-06-09", DaysBehind: 0})
+		})
+	})
+}
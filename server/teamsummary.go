@@ -0,0 +1,112 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+const (
+	teamSummaryEndpoint = "report/team_summary"
+	reportDayFormat     = time.DateOnly
+)
+
+// TeamSummarizer types can total up each team's usage and waste per day.
+// db.Backends are TeamSummarizers.
+type TeamSummarizer interface {
+	TeamSummary(ctx context.Context, cluster, bom string, first, last time.Time) (*db.TeamSummary, error)
+}
+
+// SetTeamSummarizer makes our /report/team_summary endpoint answer using the
+// given TeamSummarizer.
+func (s *Server) SetTeamSummarizer(ts TeamSummarizer) {
+	s.teamSummarizer = ts
+}
+
+// teamSummary handles GET
+// /report/team_summary?bom=X&from=YYYY-MM-DD&to=YYYY-MM-DD requests (with an
+// optional cluster parameter, and to defaulting to from) by responding with
+// our TeamSummarizer's TeamSummary as JSON.
+func (s *Server) teamSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if s.teamSummarizer == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+
+		return
+	}
+
+	first, last, err := reportDays(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		sendMessageToClient(w, err.Error())
+
+		return
+	}
+
+	if s.notModified(w, r) {
+		return
+	}
+
+	params := r.URL.Query()
+
+	ts, err := s.teamSummarizer.TeamSummary(r.Context(), params.Get("cluster"), params.Get("bom"), first, last)
+	if err != nil {
+		sendErrorToClient(w, err)
+
+		return
+	}
+
+	s.setDataThroughHeader(w, r)
+	sendJSONToClient(w, http.StatusOK, ts)
+}
+
+// reportDays returns the request's from and to query parameters, the
+// latter defaulting to the former.
+func reportDays(r *http.Request) (time.Time, time.Time, error) {
+	params := r.URL.Query()
+
+	first, err := time.Parse(reportDayFormat, params.Get("from"))
+	if err != nil {
+		return first, first, err
+	}
+
+	to := params.Get("to")
+	if to == "" {
+		return first, first, nil
+	}
+
+	last, err := time.Parse(reportDayFormat, to)
+
+	return first, last, err
+}
@@ -0,0 +1,112 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+)
+
+type mockTeamSummarizer struct {
+	cluster     string
+	first, last time.Time
+}
+
+func (m *mockTeamSummarizer) TeamSummary(_ context.Context, cluster, bom string,
+	first, last time.Time) (*db.TeamSummary, error) {
+	m.cluster, m.first, m.last = cluster, first, last
+
+	if bom == "" {
+		return nil, db.Error{Msg: db.ErrTeamSummaryRange}
+	}
+
+	return &db.TeamSummary{
+		BOM:  bom,
+		From: first.Format(time.DateOnly),
+		To:   last.Format(time.DateOnly),
+		Days: []*db.TeamDay{{Day: first.Format(time.DateOnly), AccountingName: "groupA", Jobs: 2, WastedCost: 0.5}},
+	}, nil
+}
+
+func TestTeamSummary(t *testing.T) {
+	Convey("Given a server", t, func() {
+		index := "some-indexes-*"
+		mock := newMockScroller(index)
+		cq, err := cache.New(mock, mock, 1)
+		So(err, ShouldBeNil)
+
+		server := New(cq, index, &url.URL{Host: "localhost:0", Scheme: "http"})
+
+		get := func(params string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+teamSummaryEndpoint+params, nil))
+
+			return w
+		}
+
+		Convey("without a TeamSummarizer, team summaries aren't implemented", func() {
+			So(get("?bom=bomA&from=2024-05-01").Code, ShouldEqual, http.StatusNotImplemented)
+		})
+
+		Convey("with a TeamSummarizer, you can get team summaries", func() {
+			ts := &mockTeamSummarizer{}
+			server.SetTeamSummarizer(ts)
+
+			w := get("?bom=bomA&from=2024-05-01&to=2024-05-03&cluster=farm")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(ts.cluster, ShouldEqual, "farm")
+			So(ts.first, ShouldEqual, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+			So(ts.last, ShouldEqual, time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC))
+
+			var summary db.TeamSummary
+
+			So(json.NewDecoder(w.Body).Decode(&summary), ShouldBeNil)
+			So(summary.BOM, ShouldEqual, "bomA")
+			So(summary.To, ShouldEqual, "2024-05-03")
+			So(summary.Days, ShouldHaveLength, 1)
+			So(summary.Days[0].Jobs, ShouldEqual, 2)
+
+			So(get("?bom=bomA&from=2024-05-01").Code, ShouldEqual, http.StatusOK)
+			So(ts.last, ShouldEqual, ts.first)
+
+			So(get("?bom=bomA").Code, ShouldEqual, http.StatusBadRequest)
+			So(get("?bom=bomA&from=2024-05-01&to=tomorrow").Code, ShouldEqual, http.StatusBadRequest)
+			So(get("?from=2024-05-01").Code, ShouldEqual, http.StatusBadRequest)
+
+			w = httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+teamSummaryEndpoint, nil))
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
@@ -0,0 +1,112 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"log/slog"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/wtsi-hgi/go-farmer/cache"
+	"github.com/wtsi-hgi/go-farmer/db"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+const (
+	testCacheSize      = 128
+	testBackfillPeriod = 2 * 24 * time.Hour
+)
+
+// TestBackfillFrom is the time that a NewTestServer()'s database is backfilled
+// from; it will contain the mock elasticsearch hits for the 2 days prior.
+var TestBackfillFrom = time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC) //nolint:gochecknoglobals
+
+// EmbeddedServer is an httptest.Server running a fully wired farmer Server, for
+// use in integration tests.
+type EmbeddedServer struct {
+	*httptest.Server
+	es  *httptest.Server
+	ldb *db.DB
+}
+
+// NewTestServer starts and returns an EmbeddedServer, which is a farmer Server
+// configured with a mock elasticsearch (see es.NewMock()) for the given index,
+// and a local database in dbDir (eg. from t.TempDir()) that has been backfilled
+// from that mock. Non-farmer requests are proxied to the mock elasticsearch.
+//
+// Clients should query the EmbeddedServer's URL, and you must Close() it when
+// you're done.
+func NewTestServer(index, dbDir string) (*EmbeddedServer, error) {
+	mock := es.NewMock(index)
+	config := db.Config{Directory: dbDir}
+
+	if _, err := db.Backfill(mock, config, TestBackfillFrom, testBackfillPeriod); err != nil {
+		return nil, err
+	}
+
+	ldb, err := db.New(config, true)
+	if err != nil {
+		return nil, err
+	}
+
+	cq, err := cache.New(mock, ldb, testCacheSize)
+	if err != nil {
+		ldb.Close() //nolint:errcheck
+
+		return nil, err
+	}
+
+	esServer := httptest.NewServer(mock)
+
+	esURL, err := url.Parse(esServer.URL)
+	if err != nil {
+		esServer.Close()
+		ldb.Close() //nolint:errcheck
+
+		return nil, err
+	}
+
+	s := New(cq, index, esURL)
+	s.SetDataSource(ldb)
+	s.AddMetrics(mock.Metrics())
+
+	return &EmbeddedServer{
+		Server: httptest.NewServer(s),
+		es:     esServer,
+		ldb:    ldb,
+	}, nil
+}
+
+// Close shuts down the EmbeddedServer, its mock elasticsearch and its database.
+func (t *EmbeddedServer) Close() {
+	t.Server.Close()
+	t.es.Close()
+
+	if err := t.ldb.Close(); err != nil {
+		slog.Error("failed to close test database", "err", err)
+	}
+}
@@ -0,0 +1,121 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-hgi/go-farmer/cache"
+	es "github.com/wtsi-hgi/go-farmer/elasticsearch"
+)
+
+func TestEmbeddedServer(t *testing.T) {
+	Convey("Given an EmbeddedServer", t, func() {
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+
+		index := "some-indexes-*"
+
+		ts, err := NewTestServer(index, t.TempDir())
+		So(err, ShouldBeNil)
+
+		defer ts.Close()
+
+		Convey("scroll searches are answered from the backfilled database", func() {
+			query := `{"size":10000,"query":{"bool":{"filter":[` +
+				`{"match_phrase":{"META_CLUSTER_NAME":"farm"}},` +
+				`{"range":{"timestamp":{"lt":"2024-06-01T00:00:00Z","gte":"2024-05-30T00:00:00Z",` +
+				`"format":"strict_date_optional_time"}}},` +
+				`{"match_phrase":{"BOM":"Human Genetics"}}]}}}`
+
+			resp, errp := http.Post(ts.URL+"/some-indexes-%2A/"+es.SearchPage+"?scroll=1m", //nolint:noctx
+				"application/json", strings.NewReader(query))
+			So(errp, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			data, errr := io.ReadAll(resp.Body)
+			So(errr, ShouldBeNil)
+			resp.Body.Close()
+
+			result, errd := cache.Decode(data)
+			So(errd, ShouldBeNil)
+			So(len(result.HitSet.Hits), ShouldEqual, 2)
+
+			usernames := []string{result.HitSet.Hits[0].Details.UserName, result.HitSet.Hits[1].Details.UserName}
+			sort.Strings(usernames)
+			So(usernames, ShouldResemble, []string{"pathpipe", "u2"})
+		})
+
+		Convey("distinct values are answered from the backfilled database", func() {
+			query := `{"query":{"bool":{"filter":[` +
+				`{"match_phrase":{"META_CLUSTER_NAME":"farm"}},` +
+				`{"range":{"timestamp":{"lt":"2024-06-01T00:00:00Z","gte":"2024-05-30T00:00:00Z",` +
+				`"format":"strict_date_optional_time"}}}%s]}}}`
+			bomQuery := fmt.Sprintf(query, `,{"match_phrase":{"BOM":"Human Genetics"}}`)
+
+			So(postForStrings(ts.URL+slash+getAccountingNamesEndpoint, bomQuery), ShouldResemble,
+				[]string{"a2", "pathdev"})
+			So(postForStrings(ts.URL+slash+getBOMsEndpoint, fmt.Sprintf(query, "")), ShouldResemble,
+				[]string{"Human Genetics"})
+		})
+
+		Convey("other requests are proxied to the mock elasticsearch", func() {
+			resp, errg := http.Get(ts.URL + "/") //nolint:noctx
+			So(errg, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			data, errr := io.ReadAll(resp.Body)
+			So(errr, ShouldBeNil)
+			resp.Body.Close()
+
+			So(string(data), ShouldContainSubstring, `"number":"7.17.6"`)
+		})
+	})
+}
+
+func postForStrings(url, body string) []string {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body)) //nolint:noctx
+	So(err, ShouldBeNil)
+	So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+	defer resp.Body.Close()
+
+	var strs []string
+
+	err = json.NewDecoder(resp.Body).Decode(&strs)
+	So(err, ShouldBeNil)
+
+	sort.Strings(strs)
+
+	return strs
+}
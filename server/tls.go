@@ -0,0 +1,138 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often a certReloader checks if its files changed.
+const certCheckInterval = time.Minute
+
+// TLSConfig returns a tls.Config for serving https using the given PEM
+// certificate and key files, which is also set up to negotiate HTTP/2 unless
+// disableHTTP2 is true.
+//
+// If reload is true, the files are checked every minute and their certificate
+// is used for new connections if they've changed, so that you can renew it
+// without restarting the server.
+func TLSConfig(certFile, keyFile string, reload, disableHTTP2 bool) (*tls.Config, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, interval: certCheckInterval}
+
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if disableHTTP2 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+
+	if reload {
+		config.GetCertificate = cr.getCertificate
+	} else {
+		config.Certificates = []tls.Certificate{*cr.cert}
+	}
+
+	return config, nil
+}
+
+// certReloader holds a certificate loaded from files, and reloads it if the
+// files' modification times change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// load (re)loads our certificate from our files.
+func (cr *certReloader) load() error {
+	modTime, err := cr.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.cert = &cert
+	cr.modTime = modTime
+
+	return nil
+}
+
+// filesModTime returns the latest modification time of our files.
+func (cr *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, path := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// getCertificate is a tls.Config.GetCertificate that returns our certificate,
+// first reloading it if our interval has passed and our files have changed. If
+// reloading fails (eg. because the files are mid-renewal), the old certificate
+// continues to be used.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.lastCheck) < cr.interval {
+		return cr.cert, nil
+	}
+
+	cr.lastCheck = time.Now()
+
+	if modTime, err := cr.filesModTime(); err == nil && !modTime.Equal(cr.modTime) {
+		cr.load() //nolint:errcheck
+	}
+
+	return cr.cert, nil
+}
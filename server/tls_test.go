@@ -0,0 +1,186 @@
+/*******************************************************************************
+ * Copyright (c) 2024 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTLS(t *testing.T) {
+	Convey("Given certificate and key files", t, func() {
+		dir := t.TempDir()
+		certPath := filepath.Join(dir, "cert.pem")
+		keyPath := filepath.Join(dir, "key.pem")
+		certDER := writeSelfSignedCert(t, certPath, keyPath, "first")
+
+		Convey("you can make a TLSConfig that serves https with HTTP/2", func() {
+			config, err := TLSConfig(certPath, keyPath, false, false)
+			So(err, ShouldBeNil)
+			So(config.Certificates, ShouldHaveLength, 1)
+			So(config.Certificates[0].Certificate[0], ShouldResemble, certDER)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+
+			srv := &http.Server{ //nolint:gosec
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+			}
+
+			go srv.Serve(tls.NewListener(listener, config)) //nolint:errcheck
+
+			defer srv.Close()
+
+			cert, err := x509.ParseCertificate(certDER)
+			So(err, ShouldBeNil)
+
+			pool := x509.NewCertPool()
+			pool.AddCert(cert)
+
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+				ForceAttemptHTTP2: true,
+			}}
+
+			resp, err := client.Get("https://" + listener.Addr().String()) //nolint:noctx
+			So(err, ShouldBeNil)
+			So(resp.Body.Close(), ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Proto, ShouldEqual, "HTTP/2.0")
+
+			config, err = TLSConfig(certPath, keyPath, false, true)
+			So(err, ShouldBeNil)
+			So(config.NextProtos, ShouldResemble, []string{"http/1.1"})
+		})
+
+		Convey("a TLSConfig that reloads certificates gets them on demand", func() {
+			config, err := TLSConfig(certPath, keyPath, true, false)
+			So(err, ShouldBeNil)
+			So(config.Certificates, ShouldBeEmpty)
+
+			cert, err := config.GetCertificate(nil)
+			So(err, ShouldBeNil)
+			So(cert.Certificate[0], ShouldResemble, certDER)
+		})
+
+		Convey("a certReloader picks up changed files after its interval", func() {
+			cr := &certReloader{certFile: certPath, keyFile: keyPath, interval: time.Hour}
+			So(cr.load(), ShouldBeNil)
+
+			newDER := writeSelfSignedCert(t, certPath, keyPath, "second")
+			future := time.Now().Add(time.Minute)
+			So(os.Chtimes(certPath, future, future), ShouldBeNil)
+
+			cert, err := cr.getCertificate(nil)
+			So(err, ShouldBeNil)
+			So(cert.Certificate[0], ShouldResemble, newDER)
+
+			Convey("but not before then", func() {
+				writeSelfSignedCert(t, certPath, keyPath, "third")
+				future = future.Add(time.Minute)
+				So(os.Chtimes(certPath, future, future), ShouldBeNil)
+
+				cert, err = cr.getCertificate(nil)
+				So(err, ShouldBeNil)
+				So(cert.Certificate[0], ShouldResemble, newDER)
+			})
+
+			Convey("and keeps the old one if the new files are bad", func() {
+				cr.interval = 0
+				So(os.WriteFile(keyPath, []byte("bad"), 0600), ShouldBeNil)
+
+				cert, err = cr.getCertificate(nil)
+				So(err, ShouldBeNil)
+				So(cert.Certificate[0], ShouldResemble, newDER)
+			})
+		})
+
+		Convey("TLSConfig fails with bad files", func() {
+			_, err := TLSConfig(certPath, filepath.Join(dir, "missing.pem"), false, false)
+			So(err, ShouldNotBeNil)
+
+			_, err = TLSConfig(keyPath, keyPath, false, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// writeSelfSignedCert writes a new self-signed certificate for localhost and
+// its key to the given PEM files, returning the DER bytes of the certificate.
+func writeSelfSignedCert(t *testing.T, certPath, keyPath, cn string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, block := range map[string]*pem.Block{
+		certPath: {Type: "CERTIFICATE", Bytes: der},
+		keyPath:  {Type: "PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err = os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return der
+}